@@ -0,0 +1,169 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// poolActivities are the long-running activities zpool wait -t knows about.
+var poolActivities = []string{"discard", "initialize", "remove", "resilver", "scrub", "trim"}
+
+// activityStatus is what zpool status -i -t shows of the long-running
+// activities of a pool.
+type activityStatus struct {
+	inProgress map[string]bool
+	// percentDone holds the progress of an initialize, remove or trim in
+	// progress, averaged over the vdevs for initialize and trim.
+	percentDone map[string]float64
+}
+
+// parsePercent parses "12%" or "54.64%", rejecting values outside 0-100.
+func parsePercent(s string) (float64, bool) {
+	if !strings.HasSuffix(s, "%") {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	return v, err == nil && v >= 0 && v <= 100
+}
+
+// parseActivities finds the activities in progress in zpool status -i -t
+// output: the scan from scan, a device removal in the remove: section, a
+// checkpoint being discarded and initializing or trimming vdevs in the
+// config section, such as "sda  ONLINE  0 0 0  (12% initialized, started at
+// Tue Jun  2 10:00:00 2020)".
+func parseActivities(output string, scan scanStatus) activityStatus {
+	p := newActivityParser()
+	for lines := newLineScanner(output); lines.scan(); {
+		p.line(lines.line)
+	}
+	return p.result(scan)
+}
+
+// activityParser is parseActivities fed one line at a time, as zpool status
+// prints them.
+type activityParser struct {
+	a        activityStatus
+	perVdev  map[string][]float64
+	removing bool
+}
+
+func newActivityParser() *activityParser {
+	return &activityParser{
+		a:       activityStatus{inProgress: map[string]bool{}, percentDone: map[string]float64{}},
+		perVdev: map[string][]float64{},
+	}
+}
+
+func (p *activityParser) line(line string) {
+	a := p.a
+	trimmed := strings.TrimSpace(line)
+	switch {
+	case strings.HasPrefix(trimmed, "remove:"):
+		p.removing = strings.Contains(trimmed, " in progress since ")
+		a.inProgress["remove"] = p.removing
+		return
+	case strings.HasPrefix(trimmed, "checkpoint:"):
+		a.inProgress["discard"] = strings.Contains(trimmed, "discarding")
+		p.removing = false
+	case isStatusKey(trimmed):
+		p.removing = false
+	}
+	if p.removing {
+		// "2.73G copied out of 5.00G at 190M/s, 54.64% done, 0h0m to go"
+		for _, clause := range strings.Split(trimmed, ", ") {
+			if strings.HasSuffix(clause, " done") {
+				if v, ok := parsePercent(strings.TrimSuffix(clause, " done")); ok {
+					a.percentDone["remove"] = v
+				}
+			}
+		}
+	}
+	if !strings.Contains(trimmed, "(") {
+		return
+	}
+	for _, note := range vdevNotes(trimmed) {
+		activity, progress, ok := parseVdevProgress(note)
+		if ok && progress.state == "started" {
+			a.inProgress[activity] = true
+			p.perVdev[activity] = append(p.perVdev[activity], progress.percentDone)
+		}
+	}
+}
+
+// result returns the activities of the lines seen so far, with the scan from
+// scan.
+func (p *activityParser) result(scan scanStatus) activityStatus {
+	a := p.a
+	if scan.state == "in progress" {
+		a.inProgress[scan.function] = true
+	}
+	for activity, values := range p.perVdev {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		a.percentDone[activity] = sum / float64(len(values))
+	}
+	return a
+}
+
+// vdevProgress is the initialize or trim of one vdev, from the note zpool
+// status -i -t prints after it, such as "(12% initialized, started at Tue
+// Jun  2 10:00:00 2020)".
+type vdevProgress struct {
+	state       string // "started", "suspended" or "completed"
+	percentDone float64
+	at          time.Time // when it reached state, zero when not parsed
+}
+
+// vdevNotes returns the text of the parenthesized notes in line, such as
+// "untrimmed" and "100% initialized, completed at Tue Jun  2 11:00:00 2020".
+func vdevNotes(line string) []string {
+	var notes []string
+	for _, group := range strings.Split(line, "(")[1:] {
+		if end := strings.Index(group, ")"); end >= 0 {
+			notes = append(notes, group[:end])
+		}
+	}
+	return notes
+}
+
+// parseVdevProgress parses a note returned by vdevNotes into the activity,
+// "initialize" or "trim", and its progress. Notes of vdevs that were never
+// initialized or trimmed, and other notes, are not progress.
+func parseVdevProgress(note string) (string, vdevProgress, bool) {
+	fields := strings.SplitN(note, ", ", 2)
+	words := strings.Fields(fields[0])
+	if len(words) != 2 || len(fields) != 2 {
+		return "", vdevProgress{}, false // uninitialized, untrimmed or another note
+	}
+	var activity string
+	switch words[1] {
+	case "initialized":
+		activity = "initialize"
+	case "trimmed":
+		activity = "trim"
+	default:
+		return "", vdevProgress{}, false
+	}
+	state, at, _ := strings.Cut(fields[1], " at ")
+	percent, ok := parsePercent(words[0])
+	if !ok || !stringInSlice(state, []string{"started", "suspended", "completed"}) {
+		return "", vdevProgress{}, false
+	}
+	progress := vdevProgress{state: state, percentDone: percent}
+	progress.at, _ = parseScanTime(at)
+	return activity, progress, true
+}
+
+// initializeNote returns the initialize progress among the notes in text,
+// nil when it has none, as for a vdev that was never initialized.
+func initializeNote(text string) *vdevProgress {
+	for _, note := range vdevNotes(text) {
+		if activity, progress, ok := parseVdevProgress(note); ok && activity == "initialize" {
+			return &progress
+		}
+	}
+	return nil
+}
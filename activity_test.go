@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const activityOutput = `  pool: tank
+ state: ONLINE
+  scan: scrub in progress since Sun Jul 25 16:07:49 2021
+	1.09T scanned at 580M/s, 276G issued at 144M/s, 1.55T total
+	0B repaired, 17.40% done, 02:28:35 to go
+remove: Evacuation of /dev/sdc in progress since Sun Jul 25 16:00:00 2021
+	2.73G copied out of 5.00G at 190M/s, 54.64% done, 0h0m to go
+checkpoint: created Sun Jul 25 15:00:00 2021, consumes 1.20M
+config:
+
+        NAME        STATE     READ WRITE CKSUM
+        tank        ONLINE       0     0     0
+          mirror-0  ONLINE       0     0     0
+            sda     ONLINE       0     0     0  (10% initialized, started at Sun Jul 25 16:05:00 2021)  (untrimmed)
+            sdb     ONLINE       0     0     0  (30% initialized, started at Sun Jul 25 16:05:00 2021)  (100% trimmed, completed at Sun Jul 25 14:00:00 2021)
+          sdc       ONLINE       0     0     0  (uninitialized)  (40% trimmed, suspended, started at Sun Jul 25 15:30:00 2021)
+
+errors: No known data errors`
+
+func TestParseActivities(t *testing.T) {
+	a := parseActivities(activityOutput, parseScan(activityOutput))
+	for _, activity := range poolActivities {
+		want := activity == "scrub" || activity == "remove" || activity == "initialize"
+		if a.inProgress[activity] != want {
+			t.Errorf("Incorrect %s in progress (%v), should be %v", activity, a.inProgress[activity], want)
+		}
+	}
+	if len(a.percentDone) != 2 || a.percentDone["remove"] != 54.64 || a.percentDone["initialize"] != 20 {
+		t.Errorf("Incorrect percent done %v, should be remove 54.64 and initialize 20", a.percentDone)
+	}
+
+	a = parseActivities("  pool: tank\n state: ONLINE\ncheckpoint: discarding\nconfig:\n", scanStatus{})
+	if !a.inProgress["discard"] || len(a.percentDone) != 0 {
+		t.Errorf("Checkpoint discard should be in progress, got %+v", a)
+	}
+}
+
+func TestDeviceInitialize(t *testing.T) {
+	output := "config:\n\n" +
+		"\tNAME        STATE     READ WRITE CKSUM\n" +
+		"\ttank        ONLINE       0     0     0\n" +
+		"\t  mirror-0  ONLINE       0     0     0\n" +
+		"\t    sda     ONLINE       0     0     0  (100% initialized, completed at Tue Jun  2 11:00:00 2020)  (untrimmed)\n" +
+		"\t    sdb     ONLINE       0     0     0  (12% initialized, started at Tue Jun  2 10:00:00 2020)\n" +
+		"\t    sdc     ONLINE       0     0     0\n" +
+		"\t      (40% initialized, suspended at Tue Jun  2 10:30:00 2020)\n" +
+		"\t    sdd     ONLINE       0     0     0  (uninitialized)  (25% trimmed, started at Tue Jun  2 10:00:00 2020)\n"
+	devices := leafDevices(parseStatusConfig(output))
+	if len(devices) != 4 {
+		t.Fatalf("Incorrect devices %+v", devices)
+	}
+	completed, _ := time.ParseInLocation(scanTimeLayout, "Tue Jun 2 11:00:00 2020", time.Local)
+	for i, want := range []*vdevProgress{
+		{state: "completed", percentDone: 100, at: completed},
+		{state: "started", percentDone: 12},
+		{state: "suspended", percentDone: 40},
+		nil,
+	} {
+		got := devices[i].initialize
+		switch {
+		case want == nil && got != nil:
+			t.Errorf("Device %s was never initialized, got %+v", devices[i].device, got)
+		case want != nil && (got == nil || got.state != want.state || got.percentDone != want.percentDone):
+			t.Errorf("Incorrect initialize of %s %+v, should be %+v", devices[i].device, got, want)
+		case want != nil && !want.at.IsZero() && !got.at.Equal(want.at):
+			t.Errorf("Incorrect completion of %s (%s), should be %s", devices[i].device, got.at, want.at)
+		}
+	}
+
+	// The trim of sdd is still counted as an activity.
+	a := parseActivities(output, scanStatus{})
+	if !a.inProgress["trim"] || a.percentDone["trim"] != 25 || a.percentDone["initialize"] != 12 {
+		t.Errorf("Incorrect activities %+v", a)
+	}
+}
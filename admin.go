@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// adminPoolsPath is where the admin API serves the monitored pools:
+// POST adminPoolsPath+name starts monitoring a pool, DELETE stops.
+const adminPoolsPath = "/api/pools/"
+
+// poolNames returns the names of pools.
+func poolNames(pools []zpool) []string {
+	names := make([]string, len(pools))
+	for i, pool := range pools {
+		names[i] = pool.name
+	}
+	return names
+}
+
+// ServeAdminPools adds and removes monitored pools. The pool set the
+// collections use is only changed by the next collection, see syncPools, so
+// that a scrape never sees it half updated. Names ZFS does not allow, such
+// as those taken for options of zpool, are refused before any command runs,
+// and so are names that collide with a monitored pool, see
+// poolNameCollision.
+func (e *Exporter) ServeAdminPools(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, adminPoolsPath)
+	if !poolNameRE.MatchString(name) {
+		http.Error(w, fmt.Sprintf("invalid pool name %q", name), http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		e.mutex.Lock()
+		other, what, collides := poolNameCollision(name, e.wantPools)
+		e.mutex.Unlock()
+		if collides {
+			http.Error(w, fmt.Sprintf("cannot monitor pool %s: its %s is the same as that of monitored pool %s", name, what, other), http.StatusConflict)
+			return
+		}
+		if err := checkExistance(e.runner, name); err != nil {
+			http.Error(w, fmt.Sprintf("cannot monitor pool %s: %s", name, err), http.StatusNotFound)
+			return
+		}
+		e.mutex.Lock()
+		added := !stringInSlice(name, e.wantPools)
+		if added {
+			e.wantPools = append(e.wantPools, name)
+			e.poolsChanged = true
+		}
+		e.mutex.Unlock()
+		if !added {
+			fmt.Fprintf(w, "pool %s is already monitored\n", name)
+			return
+		}
+		logf(logFields{"POOL": name}, "Monitoring pool %s, added through the admin API", name)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "monitoring pool %s from the next scrape\n", name)
+	case http.MethodDelete:
+		e.mutex.Lock()
+		i := -1
+		for j, want := range e.wantPools {
+			if want == name {
+				i = j
+			}
+		}
+		last := i >= 0 && len(e.wantPools) == 1
+		if i >= 0 && !last {
+			e.wantPools = append(e.wantPools[:i:i], e.wantPools[i+1:]...)
+			e.poolsChanged = true
+		}
+		e.mutex.Unlock()
+		switch {
+		case i < 0:
+			http.Error(w, fmt.Sprintf("pool %s is not monitored", name), http.StatusNotFound)
+		case last:
+			http.Error(w, fmt.Sprintf("pool %s is the last monitored pool", name), http.StatusConflict)
+		default:
+			logf(logFields{"POOL": name}, "No longer monitoring pool %s, removed through the admin API", name)
+			fmt.Fprintf(w, "no longer monitoring pool %s from the next scrape\n", name)
+		}
+	default:
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// syncPools brings the monitored pools in line with the ones requested
+// through the admin API. Pools that stay keep their state, such as the end
+// of their last scrub; fetchDetails fetches the details of added pools once
+// they are collected.
+// It is called by the collection, the only user of the pool set.
+func (e *Exporter) syncPools() {
+	e.mutex.Lock()
+	changed, want := e.poolsChanged, append([]string(nil), e.wantPools...)
+	e.poolsChanged = false
+	e.mutex.Unlock()
+	if !changed {
+		return
+	}
+
+	current := *e.zpools
+	pools := make([]zpool, 0, len(want))
+	for _, pool := range current {
+		if stringInSlice(pool.name, want) {
+			pools = append(pools, pool)
+		}
+	}
+	kept := len(pools)
+	for _, name := range want {
+		if !stringInSlice(name, poolNames(pools[:kept])) {
+			pools = append(pools, zpool{name: name})
+		}
+	}
+	*e.zpools = pools
+}
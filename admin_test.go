@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestServeAdminPools(t *testing.T) {
+	e := newMockExporter(t)
+	request := func(method, name string, want int) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		e.ServeAdminPools(w, httptest.NewRequest(method, adminPoolsPath+name, nil))
+		if w.Code != want {
+			t.Errorf("Incorrect status of %s %s (%d), should be %d: %s", method, name, w.Code, want, strings.TrimSpace(w.Body.String()))
+		}
+	}
+	scraped := func() []string {
+		t.Helper()
+		metrics := e.snapshot(nil)
+		var names []string
+		for _, m := range metrics {
+			if m.Desc() == zpoolUpDesc {
+				names = append(names, metricLabel(m, "name"))
+			}
+		}
+		return names
+	}
+
+	request(http.MethodDelete, "backup", http.StatusOK)
+	// Only the next collection changes the pool set.
+	if got := poolNames(*e.zpools); len(got) != 2 {
+		t.Errorf("Pools should not change before the next scrape, got %v", got)
+	}
+	if got := scraped(); len(got) != 1 || got[0] != "tank" {
+		t.Errorf("Incorrect pools scraped %v, should be tank", got)
+	}
+	request(http.MethodDelete, "backup", http.StatusNotFound)
+	request(http.MethodDelete, "tank", http.StatusConflict)
+	request(http.MethodPost, "missing", http.StatusNotFound)
+	request(http.MethodPost, "tank", http.StatusOK)
+	request(http.MethodPost, "backup", http.StatusCreated)
+	request(http.MethodGet, "backup", http.StatusMethodNotAllowed)
+	request(http.MethodPost, "", http.StatusBadRequest)
+	request(http.MethodPost, "tank/home", http.StatusBadRequest)
+	// Names that would be taken for options of zpool list, or that ZFS does
+	// not allow, are refused before any command runs.
+	for _, name := range []string{"-c", "-v", "--help", "-o", "1tank", "_tank", "-v%20tank", "tank,backup", "tank/home%20x"} {
+		request(http.MethodPost, name, http.StatusBadRequest)
+	}
+	request(http.MethodDelete, "-c", http.StatusBadRequest)
+	// Spaces are allowed, so the name only has to exist.
+	request(http.MethodPost, "my%20pool", http.StatusNotFound)
+	// A name that a --pool entry would trim to that of a monitored pool.
+	request(http.MethodPost, "tank%20", http.StatusConflict)
+	if got := scraped(); len(got) != 2 || got[0] != "backup" {
+		t.Errorf("Incorrect pools scraped %v, should be backup and tank", got)
+	}
+	if (*e.zpools)[1].creation == 0 {
+		t.Errorf("Added pool should have its creation time")
+	}
+}
+
+// TestAdminPoolsConcurrent changes the pools while scraping, for the race
+// detector.
+func TestAdminPoolsConcurrent(t *testing.T) {
+	e := newMockExporter(t)
+	reg := prometheus.NewRegistry()
+	if err := e.Register(reg); err != nil {
+		t.Fatalf("Error in Register (%s)", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			method := http.MethodDelete
+			if i%2 == 1 {
+				method = http.MethodPost
+			}
+			e.ServeAdminPools(httptest.NewRecorder(), httptest.NewRequest(method, adminPoolsPath+"backup", nil))
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		if _, err := reg.Gather(); err != nil {
+			t.Errorf("Error in Gather (%s)", err)
+		}
+	}
+	<-done
+}
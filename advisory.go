@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+)
+
+// statusReason maps the start of a status: advisory of zpool status onto
+// the short code exported as the reason label.
+type statusReason struct {
+	prefix string
+	reason string
+}
+
+// statusReasons normalizes the advisories OpenZFS and illumos print. They are
+// matched against the start of the advisory with runs of spaces collapsed, in
+// order, so that more specific texts come first. Advisories that are not
+// listed are exported as unknown; add them here as they are seen.
+var statusReasons = []statusReason{
+	{"Some supported and requested features are not enabled", "feature_upgrade"},
+	{"Some supported features are not enabled", "feature_upgrade"},
+	{"The pool is formatted using a legacy on-disk format", "feature_upgrade"},
+	{"The pool is formatted using an older on-disk format", "feature_upgrade"},
+	{"This pool has a compatibility list specified", "compatibility"},
+	{"One or more features are enabled on the pool despite not being requested", "compatibility"},
+	{"The pool cannot be accessed on this system because it uses the following feature(s) not supported", "unsupported_features"},
+	{"The pool can only be accessed in read-only mode on this system", "unsupported_features"},
+	{"The pool uses the following feature(s) not supported", "unsupported_features"},
+	{"One or more devices has experienced an unrecoverable error", "device_errors"},
+	{"One or more devices has experienced an error resulting in data corruption", "device_errors"},
+	{"One or more devices are faulted in response to persistent errors", "device_errors"},
+	{"One or more devices are faulted in response to IO failures", "device_errors"},
+	{"One or more devices could not be used because the label is missing", "device_missing"},
+	{"One or more devices could not be opened", "device_missing"},
+	{"One or more devices has been removed by the administrator", "device_offline"},
+	{"One or more devices has been taken offline by the administrator", "device_offline"},
+	{"One or more devices is currently being resilvered", "resilvering"},
+	{"One or more devices are configured to use a non-native block size", "non_native_block_size"},
+	{"Mismatch between pool hostid and system hostid", "hostid_mismatch"},
+}
+
+// parseStatusReason returns the code of the status: advisory in zpool status
+// output, or "" when there is none.
+func parseStatusReason(output string) string {
+	section := statusSection(output, "status:")
+	if len(section) == 0 {
+		return ""
+	}
+	text := strings.Join(strings.Fields(strings.Join(section, " ")), " ")
+	for _, r := range statusReasons {
+		if strings.HasPrefix(text, r.prefix) {
+			return r.reason
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseStatusReason(t *testing.T) {
+	for fixture, want := range map[string]string{
+		"zpool-status-long-names.txt": "device_missing",
+		"zpool-status-replacing.txt":  "resilvering",
+		"zpool-status-spare.txt":      "device_errors",
+		"zpool-status-removed.txt":    "",
+	} {
+		if got := parseStatusReason(readFixture(t, fixture)); got != want {
+			t.Errorf("Incorrect status reason of %s (%q), should be %q", fixture, got, want)
+		}
+	}
+
+	for output, want := range map[string]string{
+		`  pool: tank
+ state: ONLINE
+status: Some supported and requested features are not enabled on the pool.
+	The pool can still be used, but some features are unavailable.
+action: Enable all features using 'zpool upgrade'. Once this is done,
+	the pool may no longer be accessible by software that does not support
+	the features. See zpool-features(7) for details.
+  scan: scrub repaired 0B in 00:12:01 with 0 errors on Sun Oct 13 00:36:02 2024
+config:
+`: "feature_upgrade",
+		`  pool: tank
+ state: ONLINE
+status: The pool can only be accessed in read-only mode on this system. It
+	cannot be accessed in read-write mode because it uses the following
+	feature(s) not supported on this system:
+	com.klarasystems:vdev_zaps_v2
+action: The pool cannot be accessed in read-write mode. Import the pool with
+	"-o readonly=on", access the pool on a system that supports the
+	required feature(s), or recreate the pool from backup.
+config:
+`: "unsupported_features",
+		`  pool: tank
+ state: ONLINE
+status: One or more devices has experienced an unrecoverable error.  An
+	attempt was made to correct the error.  Applications are unaffected.
+action: Determine if the device needs to be replaced, and clear the errors
+	using 'zpool clear' or replace the device with 'zpool replace'.
+config:
+`: "device_errors",
+		`  pool: tank
+ state: ONLINE
+status: Something a newer zpool has to say.
+config:
+`: "unknown",
+	} {
+		if got := parseStatusReason(output); got != want {
+			t.Errorf("Incorrect status reason (%q), should be %q for\n%s", got, want, output)
+		}
+	}
+}
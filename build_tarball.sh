@@ -6,9 +6,12 @@ SCRIPT_DIR=$(dirname $0)
 cd ${SCRIPT_DIR}
 
 # Build
-declare -a TARGETS=(darwin linux solaris freebsd)
+declare -a TARGETS=(darwin linux solaris freebsd windows)
 for target in ${TARGETS[@]} ; do
   output="prometheus-zfs-${target}"
+  if [ "${target}" == "windows" ] ; then
+    output="${output}.exe"
+  fi
   echo "Building for ${target}, output bin/${output}"
   export GOOS=${target}
   export GOARCH=amd64
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var zpoolInCachefileDesc = prometheus.NewDesc("zpool_in_cachefile",
+	"Whether the pool is in the cache file of its cachefile property (1) or not (0), in which case it is not imported at boot", []string{"name"}, nil)
+
+// defaultCachefile is the cache file of the pools whose cachefile property is
+// unset, which the boot scripts import on Linux and FreeBSD.
+var defaultCachefile = "/etc/zfs/zpool.cache"
+
+// cachefileCollector exports whether each pool is in the cache file the boot
+// scripts import from. A pool imported with -o cachefile=none, or whose entry
+// was lost, imports fine by hand but does not come back after a reboot. The
+// cache file is read with zdb -C -U, which needs to be run as root.
+type cachefileCollector struct{}
+
+func (cachefileCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- zpoolInCachefileDesc
+}
+
+// cachefilePath returns the cache file the boot scripts import pool from,
+// or "" for none.
+func cachefilePath(pool zpool) string {
+	switch pool.cachefile {
+	case "none":
+		return ""
+	case "":
+		return defaultCachefile
+	}
+	return pool.cachefile
+}
+
+// cachefileCommand reads the cache file at path.
+func cachefileCommand(path string) command {
+	return command{"zdb", []string{"-C", "-U", path}}
+}
+
+// plan needs the cachefile property of pools from zpool list.
+func (cachefileCollector) plan(pools []zpool) []plannedCommand {
+	var plan []plannedCommand
+	seen := map[string]bool{}
+	for _, pool := range pools {
+		if path := cachefilePath(pool); path != "" && !seen[path] {
+			seen[path] = true
+			plan = append(plan, plannedCommand{cachefileCommand(path), ""})
+		}
+	}
+	return plan
+}
+
+func (cachefileCollector) collect(r commandRunner, pools []zpool, ch chan<- prometheus.Metric) error {
+	inCache := map[string]bool{}
+	files := map[string][]string{}
+	for _, pool := range pools {
+		switch path := cachefilePath(pool); {
+		case pool.err != nil:
+		case path == "":
+			inCache[pool.name] = false
+		default:
+			files[path] = append(files[path], pool.name)
+		}
+	}
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	// All the cache files are read before anything is exported, so that
+	// the metric is absent rather than partial when zdb fails.
+	for _, path := range paths {
+		cached, err := readCachefile(r, path)
+		if err != nil {
+			return err
+		}
+		for _, name := range files[path] {
+			inCache[name] = cached[name]
+		}
+	}
+	for name, ok := range inCache {
+		ch <- prometheus.MustNewConstMetric(zpoolInCachefileDesc, prometheus.GaugeValue, boolToFloat(ok), name)
+	}
+	return nil
+}
+
+// readCachefile returns the pools in the cache file at path. A cache file
+// that does not exist has no pools.
+func readCachefile(r commandRunner, path string) (map[string]bool, error) {
+	output, err := cachefileCommand(path).run(r)
+	switch {
+	case err != nil && strings.Contains(output, "No such file or directory"):
+		return map[string]bool{}, nil
+	case err != nil && strings.TrimSpace(output) == "":
+		return nil, err
+	case err != nil:
+		return nil, fmt.Errorf("zdb -C -U %s: %s", path, strings.TrimSpace(output))
+	}
+	return parseCachefile(output), nil
+}
+
+// parseCachefile parses the pool names out of the configurations zdb -C -U
+// prints, each starting with the name of the pool followed by a colon
+// without indentation:
+//
+//	tank:
+//	    version: 5000
+//	    name: 'tank'
+func parseCachefile(output string) map[string]bool {
+	pools := map[string]bool{}
+	for lines := newLineScanner(output); lines.scan(); {
+		line := strings.TrimRight(lines.line, " \r")
+		if line == "" || line[0] == ' ' || line[0] == '\t' || !strings.HasSuffix(line, ":") {
+			continue
+		}
+		pools[strings.Trim(strings.TrimSuffix(line, ":"), "'")] = true
+	}
+	return pools
+}
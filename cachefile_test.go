@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// cachefileRunner answers zdb -C -U with the output of the cache file given,
+// failing for the ones without output.
+type cachefileRunner map[string]string
+
+func (r cachefileRunner) run(name string, args ...string) (string, error) {
+	path := args[len(args)-1]
+	output, ok := r[path]
+	if !ok {
+		return "cannot open '" + path + "': No such file or directory\n", errors.New("exit status 1")
+	}
+	if strings.HasPrefix(output, "error: ") {
+		return strings.TrimPrefix(output, "error: "), errors.New("exit status 1")
+	}
+	return output, nil
+}
+
+func (r cachefileRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	output, err := r.run(name, args...)
+	return io.NopCloser(strings.NewReader(output)), err
+}
+
+func TestParseCachefile(t *testing.T) {
+	b, err := mockFS.ReadFile("mock/zdb-cachefile.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pools := parseCachefile(string(b) + "backup:\n    version: 5000\n    name: 'backup'\n")
+	if len(pools) != 2 || !pools["tank"] || !pools["backup"] {
+		t.Errorf("Incorrect pools in cache file %v", pools)
+	}
+}
+
+func gatherCachefile(t *testing.T, r commandRunner, pools []zpool) (map[string]float64, error) {
+	t.Helper()
+	ch := make(chan prometheus.Metric, 10)
+	err := cachefileCollector{}.collect(r, pools, ch)
+	close(ch)
+	got := map[string]float64{}
+	for m := range ch {
+		got[metricLabel(m, "name")] = metricValue(m)
+	}
+	return got, err
+}
+
+func TestCachefileCollector(t *testing.T) {
+	pools := []zpool{
+		{name: "tank"},
+		{name: "backup"},
+		{name: "scratch", cachefile: "none"},
+		{name: "usb", cachefile: "/etc/zfs/usb.cache"},
+		{name: "broken", err: errors.New("cannot open 'broken': no such pool")},
+	}
+	r := cachefileRunner{
+		defaultCachefile:     "tank:\n    name: 'tank'\n",
+		"/etc/zfs/usb.cache": "'usb':\n    name: 'usb'\n",
+	}
+	got, err := gatherCachefile(t, r, pools)
+	want := map[string]float64{"tank": 1, "backup": 0, "scratch": 0, "usb": 1}
+	if err != nil || len(got) != len(want) {
+		t.Fatalf("Incorrect zpool_in_cachefile %v (%v), should be %v", got, err, want)
+	}
+	for name, v := range want {
+		if got[name] != v {
+			t.Errorf("Incorrect zpool_in_cachefile of %s (%v), should be %v", name, got[name], v)
+		}
+	}
+
+	// Without a cache file, no pool is imported at boot.
+	if got, err := gatherCachefile(t, cachefileRunner{}, pools[:2]); err != nil || len(got) != 2 || got["tank"] != 0 {
+		t.Errorf("Missing cache file should export 0, got %v (%v)", got, err)
+	}
+
+	r[defaultCachefile] = "error: cannot open '/etc/zfs/zpool.cache': Permission denied\n"
+	if got, err := gatherCachefile(t, r, pools); len(got) != 0 || err == nil || !isPermissionError(err) {
+		t.Errorf("Unreadable cache file should fail with a permission error and export nothing, got %v (%v)", got, err)
+	}
+}
+
+func TestCachefileWithoutZdb(t *testing.T) {
+	r := execRunner{}
+	t.Setenv("PATH", t.TempDir())
+	_, err := gatherCachefile(t, r, []zpool{{name: "tank"}})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Missing zdb should fail with ErrNotExist, got %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var capabilityDesc = prometheus.NewDesc("zfs_exporter_capability",
+	"Whether the installed zpool or zfs supports the option or subcommand (1) or not (0), as probed when the pools were set up", []string{"capability"}, nil)
+
+// capabilities are the options of zpool status and the zfs subcommands that
+// differ between releases. They are probed once when the pools are set up,
+// by probeCapabilities, so that the collectors know up front what they can
+// run instead of failing at scrape time, and so that a host behaves the same
+// on every scrape.
+type capabilities struct {
+	probed       bool
+	json         bool // zpool status -j, OpenZFS 2.3 and later
+	parsable     bool // zpool status -p
+	slowIOs      bool // zpool status -s
+	trim         bool // zpool status -i -t, OpenZFS 0.8 and later
+	projectspace bool // zfs projectspace
+	requestSizes bool // zpool iostat -r, OpenZFS 0.7 and later
+}
+
+// capability is one of the capabilities by its zfs_exporter_capability
+// label.
+type capability struct {
+	name      string
+	supported bool
+}
+
+func (c capabilities) list() []capability {
+	return []capability{
+		{"status_json", c.json},
+		{"status_parsable", c.parsable},
+		{"status_slow_ios", c.slowIOs},
+		{"status_trim", c.trim},
+		{"projectspace", c.projectspace},
+		{"iostat_request_sizes", c.requestSizes},
+	}
+}
+
+// String summarizes the capabilities for the log, such as
+// "zpool status: -j=yes, -p=yes, -s=yes, -t=no; zpool iostat: -r=yes; zfs
+// projectspace: yes".
+func (c capabilities) String() string {
+	yes := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+	return fmt.Sprintf("zpool status: -j=%s, -p=%s, -s=%s, -t=%s; zpool iostat: -r=%s; zfs projectspace: %s",
+		yes(c.json), yes(c.parsable), yes(c.slowIOs), yes(c.trim), yes(c.requestSizes), yes(c.projectspace))
+}
+
+func (c capabilities) collect(ch chan<- prometheus.Metric) {
+	if !c.probed {
+		return
+	}
+	for _, capability := range c.list() {
+		ch <- prometheus.MustNewConstMetric(capabilityDesc, prometheus.GaugeValue, boolToFloat(capability.supported), capability.name)
+	}
+}
+
+// probeCapabilities runs the cheap commands that tell the capabilities of
+// the installed zpool and zfs apart on pool, which should exist.
+func probeCapabilities(r commandRunner, pool string) capabilities {
+	return capabilities{
+		probed:       true,
+		json:         probeJSON(r, pool),
+		parsable:     probeParsable(r, pool),
+		slowIOs:      probeSlowIOs(r, pool),
+		trim:         probeTrim(r, pool),
+		projectspace: probeProjectspace(r, pool),
+		requestSizes: probeRequestSizes(r, pool),
+	}
+}
+
+// jsonProbe, parsableProbe, slowIOsProbe, trimProbe and projectspaceProbe
+// are the commands of the probes, which run once when the pools are set up.
+func jsonProbe(pool string) command {
+	return command{"zpool", []string{"status", "-j", pool}}
+}
+
+func parsableProbe(pool string) command {
+	return command{"zpool", []string{"status", "-p", pool}}
+}
+
+func slowIOsProbe(pool string) command {
+	return command{"zpool", []string{"status", "-s", pool}}
+}
+
+func trimProbe(pool string) command {
+	return command{"zpool", []string{"status", "-i", "-t", pool}}
+}
+
+func projectspaceProbe(dataset string) command {
+	return command{"zfs", []string{"projectspace", "-H", "-o", "name", dataset}}
+}
+
+// probeCommands are the commands probeCapabilities runs on pool.
+func probeCommands(pool string) []command {
+	return []command{
+		jsonProbe(pool),
+		parsableProbe(pool),
+		slowIOsProbe(pool),
+		trimProbe(pool),
+		projectspaceProbe(pool),
+		requestSizeCommand([]zpool{{name: pool}}),
+	}
+}
+
+// probeJSON reports whether zpool status supports -j, which prints JSON.
+func probeJSON(r commandRunner, pool string) bool {
+	output, err := jsonProbe(pool).run(r)
+	return err == nil && strings.HasPrefix(strings.TrimSpace(output), "{")
+}
+
+// probeTrim reports whether zpool status supports -i and -t, which show the
+// initialize and trim state of every vdev for --collect-activities.
+func probeTrim(r commandRunner, pool string) bool {
+	output, err := trimProbe(pool).run(r)
+	return err == nil && strings.Contains(output, "pool: "+pool)
+}
+
+// probeProjectspace reports whether zfs knows the projectspace subcommand,
+// which older releases do not. dataset need not exist: zfs only complains
+// about it once it knows the subcommand.
+func probeProjectspace(r commandRunner, dataset string) bool {
+	output, err := projectspaceProbe(dataset).run(r)
+	return err == nil || !(strings.Contains(output, "unrecognized command") || strings.Contains(output, "invalid command"))
+}
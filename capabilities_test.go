@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestProbeCapabilities(t *testing.T) {
+	status := "  pool: tank\n state: ONLINE\nconfig:\n\n\tNAME        STATE     READ WRITE CKSUM  SLOW\n\ttank        ONLINE       0     0     0     -\n"
+	r := unsupportedRunner{staticRunner{
+		"zpool status -p tank": status,
+		"zpool status -s tank": status,
+		"zpool status -j tank": "invalid option 'j'\n",
+	}}
+	caps := probeCapabilities(r, "tank")
+	want := capabilities{probed: true, parsable: true, slowIOs: true}
+	if caps != want {
+		t.Errorf("Incorrect capabilities %+v, should be %+v", caps, want)
+	}
+	if got := caps.String(); got != "zpool status: -j=no, -p=yes, -s=yes, -t=no; zpool iostat: -r=no; zfs projectspace: no" {
+		t.Errorf("Incorrect summary %q", got)
+	}
+
+	ch := make(chan prometheus.Metric, 10)
+	caps.collect(ch)
+	close(ch)
+	got := map[string]float64{}
+	for m := range ch {
+		got[metricLabel(m, "capability")] = metricValue(m)
+	}
+	if len(got) != 6 || got["status_slow_ios"] != 1 || got["status_trim"] != 0 {
+		t.Errorf("Incorrect capability metrics %v", got)
+	}
+}
+
+func TestProbeDisablesActivities(t *testing.T) {
+	e := NewExporter(&[]zpool{{name: "tank"}})
+	e.runner = staticRunner{}
+	e.pool.activities = true
+	e.probe("tank")
+	if e.pool.activities || !e.caps.probed {
+		t.Errorf("Activities should be disabled when zpool status -t is not supported")
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// childProcesses counts the commands the runners started that have not
+// exited yet. A command stuck in the kernel, as zpool status is when a pool
+// hangs, cannot be killed and stays until the kernel lets it go; with
+// --command.timeout every scrape would leave another one behind, so at most
+// max are started, and the collection fails once that many are left.
+type childProcesses struct {
+	max     int           // 0 for no limit
+	timeout time.Duration // 0 for none
+
+	mu   sync.Mutex
+	live int
+}
+
+// children holds the limits of --command.max-processes and
+// --command.timeout. run replaces it with the one zfs_exporter_child_processes
+// reports.
+var children = &childProcesses{max: 16, timeout: 5 * time.Minute}
+
+// killGrace is how long wait waits for a command it killed to exit before
+// giving up on it.
+const killGrace = 5 * time.Second
+
+// tooManyChildrenError is a command that was not started because max
+// commands are still running. Like an sshError, the pools are exported with
+// zpool_up 0 while it lasts.
+type tooManyChildrenError struct {
+	name string
+	max  int
+}
+
+func (e *tooManyChildrenError) Error() string {
+	return fmt.Sprintf("not running %s: %d commands started earlier are still running, see --command.max-processes", e.name, e.max)
+}
+
+// commandTimeoutError is a command that was killed after running for longer
+// than --command.timeout.
+type commandTimeoutError struct {
+	name    string
+	timeout time.Duration
+}
+
+func (e *commandTimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %s and was killed, see --command.timeout", e.name, e.timeout)
+}
+
+// commandCanceledError is a command that was not started, or was killed,
+// because the context it ran with was done, as when every client waiting for
+// the scrape disconnected. It is a context.Canceled.
+type commandCanceledError struct {
+	name string
+	err  error // of the context
+}
+
+func (e *commandCanceledError) Error() string {
+	return fmt.Sprintf("%s was canceled: %s", e.name, e.err)
+}
+
+func (e *commandCanceledError) Unwrap() error {
+	return e.err
+}
+
+func (c *childProcesses) acquire(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.max > 0 && c.live >= c.max {
+		return &tooManyChildrenError{name: name, max: c.max}
+	}
+	c.live++
+	return nil
+}
+
+func (c *childProcesses) release() {
+	c.mu.Lock()
+	c.live--
+	c.mu.Unlock()
+}
+
+func (c *childProcesses) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.live
+}
+
+// gauge returns zfs_exporter_child_processes, which reports count.
+func (c *childProcesses) gauge() prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "zfs_exporter_child_processes",
+		Help: "Number of zpool, zfs and other commands started by the exporter that have not exited yet, including killed ones stuck in the kernel",
+	}, func() float64 { return float64(c.count()) })
+}
+
+// child is a command started by startChild. It runs in a process group of
+// its own, so that a timeout kills whatever it started too, such as the
+// commands a wrapper runs.
+type child struct {
+	name    string
+	cmd     *exec.Cmd
+	done    chan struct{} // closed once the command exited
+	err     error         // of cmd.Wait, set before done is closed
+	kill    sync.Once
+	killed  chan struct{} // closed once the command timed out or was canceled and was killed
+	killErr error         // why, set before killed is closed
+}
+
+// startChild starts cmd, named name in errors, counted in children. The
+// command is killed after children.timeout, and once ctx is done. onKill,
+// when not nil, is called once it was killed, to unblock readers of its
+// output.
+func startChild(ctx context.Context, name string, cmd *exec.Cmd, onKill func()) (*child, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, &commandCanceledError{name: name, err: err}
+	}
+	if err := children.acquire(name); err != nil {
+		return nil, err
+	}
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		children.release()
+		return nil, err
+	}
+	c := &child{name: name, cmd: cmd, done: make(chan struct{}), killed: make(chan struct{})}
+	limits := children
+	go func() {
+		c.err = cmd.Wait()
+		limits.release()
+		close(c.done)
+	}()
+	if timeout := limits.timeout; timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			c.stop(&commandTimeoutError{name: name, timeout: timeout}, onKill)
+		})
+		go func() {
+			<-c.done
+			timer.Stop()
+		}()
+	}
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				debugf("Scrape %s: killing %s, every client waiting for the collection disconnected", scrapeIDs(ctx), name)
+				c.stop(&commandCanceledError{name: name, err: ctx.Err()}, onKill)
+			case <-c.done:
+			}
+		}()
+	}
+	return c, nil
+}
+
+// stop kills the command unless it already exited or was killed, so that
+// wait returns err.
+func (c *child) stop(err error, onKill func()) {
+	c.kill.Do(func() {
+		select {
+		case <-c.done:
+			return // exited just in time, its process group may be gone
+		default:
+		}
+		killProcessGroup(c.cmd)
+		if onKill != nil {
+			onKill()
+		}
+		c.killErr = err
+		close(c.killed)
+	})
+}
+
+// wait waits for the command to exit and returns its error, or a
+// commandTimeoutError or commandCanceledError when it was killed. A killed
+// command that does not exit within killGrace is left behind, still counted
+// in children.
+func (c *child) wait() error {
+	select {
+	case <-c.done:
+		select {
+		case <-c.killed:
+		default:
+			return c.err
+		}
+	case <-c.killed:
+		select {
+		case <-c.done:
+		case <-time.After(killGrace):
+		}
+	}
+	return c.killErr
+}
+
+// lockedBuffer is a bytes.Buffer that a command left behind may still
+// write to while its output is read.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// runCommand runs cmd as a child with ctx and returns its combined output
+// once it exited.
+func runCommand(ctx context.Context, name string, cmd *exec.Cmd) (string, error) {
+	var out lockedBuffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	c, err := startChild(ctx, name, cmd, nil)
+	if err != nil {
+		return "", err
+	}
+	err = c.wait()
+	return out.String(), err
+}
+
+// startCommand starts cmd as a child with ctx and returns its standard
+// output as it is produced. The output is read from a pipe of its own rather
+// than cmd.StdoutPipe, so that the child can be waited for while it is read,
+// and killing it closes it for the reader of a command stuck without output.
+func startCommand(ctx context.Context, name string, cmd *exec.Cmd) (*commandOutput, error) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	output := &commandOutput{ReadCloser: pr}
+	cmd.Stdout, cmd.Stderr = pw, &output.stderr
+	output.child, err = startChild(ctx, name, cmd, func() { pr.Close() })
+	pw.Close()
+	if err != nil {
+		pr.Close()
+		return nil, err
+	}
+	return output, nil
+}
+
+// commandOutput is the stdout of a started command; Close reaps the command
+// and reports its exit status along with anything it printed to stderr.
+type commandOutput struct {
+	io.ReadCloser
+	child  *child
+	stderr lockedBuffer
+}
+
+func (c *commandOutput) Close() error {
+	c.ReadCloser.Close()
+	err := c.child.wait()
+	if msg := strings.TrimSpace(c.stderr.String()); err != nil && msg != "" {
+		return fmt.Errorf("%s: %s", err, msg)
+	}
+	return err
+}
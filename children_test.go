@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// withChildLimits sets children to limits for the test.
+func withChildLimits(t *testing.T, max int, timeout time.Duration) {
+	old := children
+	children = &childProcesses{max: max, timeout: timeout}
+	t.Cleanup(func() { children = old })
+}
+
+func TestChildProcessLimit(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep is not installed")
+	}
+	withChildLimits(t, 1, 200*time.Millisecond)
+	r := execRunner{}
+	output, err := r.start("sleep", "30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := children.count(); got != 1 {
+		t.Errorf("The started command should be counted, got %d", got)
+	}
+	var limit *tooManyChildrenError
+	if _, err := r.run("true"); !errors.As(err, &limit) || !unreachable(err) {
+		t.Errorf("A command beyond -command.max-processes should not start, got %v", err)
+	}
+
+	// The timeout unblocks the reader of the command that prints nothing.
+	started := time.Now()
+	io.Copy(io.Discard, output)
+	var timeout *commandTimeoutError
+	if err := output.Close(); !errors.As(err, &timeout) {
+		t.Errorf("The sleeping command should time out, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("The timeout should have killed the command, took %s", elapsed)
+	}
+	if got := children.count(); got != 0 {
+		t.Errorf("The killed command should no longer be counted, got %d", got)
+	}
+	if out, err := r.run("echo", "ok"); err != nil || out != "ok\n" {
+		t.Errorf("Commands should run again, got %q (%v)", out, err)
+	}
+}
+
+// limitedRunner fails every command as when -command.max-processes commands
+// are stuck.
+type limitedRunner struct{}
+
+func (limitedRunner) run(name string, args ...string) (string, error) {
+	return "", &tooManyChildrenError{name: name, max: 16}
+}
+
+func (limitedRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	return nil, &tooManyChildrenError{name: name, max: 16}
+}
+
+func TestExporterChildLimit(t *testing.T) {
+	// setup looks zpool up in PATH unless it runs the mock runner itself.
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "zpool"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+	e := newMockExporter(t)
+	e.runner = limitedRunner{}
+	for i := 0; i < 2; i++ {
+		up := map[string]float64{}
+		for _, m := range e.snapshot(nil) {
+			if descName(m.Desc()) == "zpool_up" {
+				up[metricLabel(m, "name")] = metricValue(m)
+			}
+		}
+		if want := map[string]float64{"tank": 0, "backup": 0}; !reflect.DeepEqual(up, want) {
+			t.Errorf("Scrape %d beyond -command.max-processes should export zpool_up 0 for every pool, got %v", i, up)
+		}
+	}
+	select {
+	case err := <-e.fatal:
+		t.Errorf("Stuck commands should not stop the exporter (%s)", err)
+	default:
+	}
+}
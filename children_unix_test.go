@@ -0,0 +1,59 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestProcessGroupKill runs a shell wrapper that starts a sleeping command of
+// its own, as -command.nice or ssh would, and checks that the timeout kills
+// both rather than only the wrapper.
+func TestProcessGroupKill(t *testing.T) {
+	withChildLimits(t, 4, 300*time.Millisecond)
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	_, err := execRunner{}.run("sh", "-c", `sleep 30 & echo $! > "$0"; wait`, pidFile)
+	var timeout *commandTimeoutError
+	if !errors.As(err, &timeout) {
+		t.Fatalf("The wrapper should time out, got %v", err)
+	}
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if alive(pid) && time.Now().After(deadline) {
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatalf("The sleep started by the wrapper survived the timeout")
+		} else if !alive(pid) {
+			break
+		}
+	}
+}
+
+// alive reports whether the process pid runs, rather than having exited
+// and maybe waiting to be reaped by init.
+func alive(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return true // no /proc, as on FreeBSD: assume the signal test is right
+	}
+	// The state follows the parenthesized command name.
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
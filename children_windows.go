@@ -0,0 +1,12 @@
+package main
+
+import "os/exec"
+
+// Windows has no process groups to kill as one; only the command itself is
+// killed.
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
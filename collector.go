@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	collectorEnabledDesc = prometheus.NewDesc("zfs_exporter_collector_enabled",
+		"Whether the optional collector is running (1) or was disabled because it lacks privileges or support (0)", []string{"collector"}, nil)
+	collectorDurationDesc = prometheus.NewDesc("zfs_exporter_collector_duration_seconds",
+		"Time the collector took during the last scrape", []string{"collector"}, nil)
+	collectorSuccessDesc = prometheus.NewDesc("zfs_exporter_collector_success",
+		"Whether the collector succeeded (1) or failed (0) during the last scrape, 0 for optional collectors that were disabled", []string{"collector"}, nil)
+)
+
+// collector is implemented by the pool collector and the optional
+// collectors, which each add their own zfs or zpool invocations to a scrape.
+type collector interface {
+	describe(ch chan<- *prometheus.Desc)
+	collect(r commandRunner, pools []zpool, ch chan<- prometheus.Metric) error
+}
+
+// optionalCollector is a collector enabled on the command line. It is
+// disabled for the rest of the run the first time it fails for lack of
+// privileges or because what it reads does not exist on this system, so that
+// this is logged once instead of on every scrape.
+type optionalCollector struct {
+	name string
+	collector
+	disabled bool
+}
+
+// permissionErrors are the messages zfs, zpool and zdb print when they are
+// not run with sufficient privileges, the last one on Windows.
+var permissionErrors = []string{
+	"permission denied",
+	"operation not permitted",
+	"must be root",
+	"insufficient privileges",
+	"access is denied",
+}
+
+// isPermissionError reports whether err looks like a failure caused by
+// running unprivileged.
+func isPermissionError(err error) bool {
+	return substringInSlice(strings.ToLower(err.Error()), permissionErrors)
+}
+
+// run collects the metrics of c unless it was disabled. A disabled collector
+// keeps exporting zfs_exporter_collector_success 0, so that one alert on it
+// covers every collector that was enabled but does not work.
+func (c *optionalCollector) run(r commandRunner, pools []zpool, ch chan<- prometheus.Metric) {
+	if c.disabled {
+		ch <- prometheus.MustNewConstMetric(collectorSuccessDesc, prometheus.GaugeValue, 0, c.name)
+	} else {
+		start := time.Now()
+		err := recovered(c.name, func() error { return c.collect(r, pools, ch) })
+		collectorStats(ch, c.name, start, err)
+		fields := logFields{"COLLECTOR": c.name}
+		switch {
+		case err == nil:
+			logResolved("collector "+c.name, fields, "The %s collector is collecting again", c.name)
+		case isPermissionError(err):
+			c.disabled = true
+			logf(fields, "Warning: disabling the %s collector, it lacks the privileges it needs: %s", c.name, err)
+		case errors.Is(err, os.ErrNotExist):
+			c.disabled = true
+			logf(fields, "Warning: disabling the %s collector, it is not supported here: %s", c.name, err)
+		default:
+			logProblem("collector "+c.name, fields, "Error collecting %s metrics: %s", c.name, err)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(collectorEnabledDesc, prometheus.GaugeValue, boolToFloat(!c.disabled), c.name)
+}
+
+// skip exports zfs_exporter_collector_success 0, and no duration, for a
+// scrape in which c did not run because the pool collector failed, so that
+// its success series does not disappear then.
+func (c *optionalCollector) skip(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(collectorSuccessDesc, prometheus.GaugeValue, 0, c.name)
+	ch <- prometheus.MustNewConstMetric(collectorEnabledDesc, prometheus.GaugeValue, boolToFloat(!c.disabled), c.name)
+}
+
+// collectorStats exports how long the named collector took since start and
+// whether it succeeded.
+func collectorStats(ch chan<- prometheus.Metric, name string, start time.Time, err error) {
+	ch <- prometheus.MustNewConstMetric(collectorDurationDesc, prometheus.GaugeValue, time.Since(start).Seconds(), name)
+	ch <- prometheus.MustNewConstMetric(collectorSuccessDesc, prometheus.GaugeValue, boolToFloat(err == nil), name)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// failingCollector fails every collection with err.
+type failingCollector struct {
+	err   error
+	calls *int
+}
+
+func (failingCollector) describe(ch chan<- *prometheus.Desc) {}
+
+func (c failingCollector) collect(r commandRunner, pools []zpool, ch chan<- prometheus.Metric) error {
+	*c.calls++
+	return c.err
+}
+
+func TestOptionalCollector(t *testing.T) {
+	for _, test := range []struct {
+		err      error
+		disabled bool
+	}{
+		{errors.New("zfs userspace tank: cannot get used/quota for tank: permission denied"), true},
+		{errors.New("listing snapshots: exit status 1: Operation not permitted"), true},
+		{&os.PathError{Op: "open", Path: "/proc/spl/kstat/zfs/arcstats", Err: os.ErrNotExist}, true},
+		{commandNotFoundError{"zdb"}, true},
+		{errors.New("zfs get filesystem_count,snapshot_count: dataset does not exist"), false},
+	} {
+		calls := 0
+		c := &optionalCollector{name: "test", collector: failingCollector{err: test.err, calls: &calls}}
+		for i := 0; i < 2; i++ {
+			ch := make(chan prometheus.Metric, 3)
+			c.run(staticRunner{}, nil, ch)
+			close(ch)
+			success := false
+			for m := range ch {
+				name := descName(m.Desc())
+				if name == "zfs_exporter_collector_enabled" && metricValue(m) != boolToFloat(!test.disabled) {
+					t.Errorf("Incorrect zfs_exporter_collector_enabled (%v) after %q", metricValue(m), test.err)
+				}
+				if name == "zfs_exporter_collector_success" {
+					success = true
+					if metricValue(m) != 0 {
+						t.Errorf("Failing collector should export zfs_exporter_collector_success 0")
+					}
+				}
+			}
+			if !success {
+				t.Errorf("Collector failing with %q should export zfs_exporter_collector_success on scrape %d", test.err, i+1)
+			}
+		}
+		want := 2
+		if test.disabled {
+			want = 1
+		}
+		if calls != want {
+			t.Errorf("Collector failing with %q should have run %d times, ran %d", test.err, want, calls)
+		}
+	}
+}
+
+func TestPoolFailureSkipsCollectors(t *testing.T) {
+	e := newMockExporter(t)
+	e.keepRunning = true
+	e.runner = staticRunner{}
+	success := map[string]float64{}
+	for _, m := range e.snapshot(nil) {
+		switch descName(m.Desc()) {
+		case "zfs_exporter_collector_success":
+			success[metricLabel(m, "collector")] = metricValue(m)
+		case "zfs_exporter_collector_duration_seconds":
+			if name := metricLabel(m, "collector"); name != "pool" {
+				t.Errorf("Collector %s should export no duration when the pool collector failed", name)
+			}
+		}
+	}
+	if len(e.collectors) == 0 {
+		t.Fatal("Mock exporter should have optional collectors")
+	}
+	for _, c := range append([]string{"pool"}, collectorNames(e.collectors)...) {
+		if value, ok := success[c]; !ok || value != 0 {
+			t.Errorf("Collector %s should export zfs_exporter_collector_success 0 when the pool collector failed, got %v (exported %t)", c, value, ok)
+		}
+	}
+}
+
+func collectorNames(collectors []*optionalCollector) []string {
+	var names []string
+	for _, c := range collectors {
+		names = append(names, c.name)
+	}
+	return names
+}
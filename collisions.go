@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var poolNameCollisionsDesc = prometheus.NewDesc("zfs_exporter_pool_name_collisions",
+	"Number of --pool entries not monitored because a name the exporter derives from them is that of another monitored pool", nil, nil)
+
+// poolNameForms are the names the exporter derives from a pool name. Two
+// pools whose names have the same form would end up under one InfluxDB
+// point, one pool of the datasets or one --pool entry, so only the first of
+// them is monitored. The name label and the POOL field of the journal are
+// the name as is, which parsePools and the admin API keep unique.
+var poolNameForms = []struct {
+	what string
+	form func(name string) string
+}{
+	{"InfluxDB tag", influxEscaper.Replace},
+	// The pools of the dataset and snapshot labels, see poolOf.
+	{"dataset prefix", poolOf},
+	// An entry of --pool, and so the pools a reload restores.
+	{"--pool entry", strings.TrimSpace},
+}
+
+// poolNameCollision returns the first of names that collides with name in
+// one of poolNameForms, and that form.
+func poolNameCollision(name string, names []string) (other, what string, ok bool) {
+	for _, f := range poolNameForms {
+		for _, n := range names {
+			if n != name && f.form(n) == f.form(name) {
+				return n, f.what, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// dropPoolNameCollisions returns pools without those whose name collides
+// with that of an earlier one, which are logged as errors, and how many it
+// dropped.
+func dropPoolNameCollisions(pools []zpool) ([]zpool, int) {
+	var kept []zpool
+	for _, pool := range pools {
+		if other, what, ok := poolNameCollision(pool.name, poolNames(kept)); ok {
+			logf(logFields{"POOL": pool.name}, "Error: not monitoring pool %q, its %s is the same as that of pool %q", pool.name, what, other)
+			continue
+		}
+		kept = append(kept, pool)
+	}
+	return kept, len(pools) - len(kept)
+}
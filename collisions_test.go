@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPoolNameCollision(t *testing.T) {
+	// Names that only differ in punctuation or spaces stay apart in every
+	// form
+	names := []string{"data-1", "data.1", "data_1", "data:1", "data 1", "data1"}
+	for _, name := range names {
+		if other, what, ok := poolNameCollision(name, names); ok {
+			t.Errorf("Pool %s should not collide, got %s in the %s", name, other, what)
+		}
+	}
+
+	for _, test := range []struct {
+		name, other, what string
+	}{
+		{"tank ", "tank", "--pool entry"},
+		{" tank", "tank", "--pool entry"},
+	} {
+		other, what, ok := poolNameCollision(test.name, []string{"backup", test.other})
+		if !ok || other != test.other || what != test.what {
+			t.Errorf("Pool %q should collide with %s in the %s, got %q in the %s (%v)", test.name, test.other, test.what, other, what, ok)
+		}
+	}
+	if _, _, ok := poolNameCollision("tank", []string{"tank"}); ok {
+		t.Errorf("Pool tank should not collide with itself")
+	}
+}
+
+func TestPoolNameCollisionForms(t *testing.T) {
+	// A form that replaces punctuation, as a relabeling might, merges the
+	// names the others keep apart
+	old := poolNameForms
+	defer func() { poolNameForms = old }()
+	poolNameForms = append(poolNameForms, struct {
+		what string
+		form func(name string) string
+	}{"relabeled name", func(name string) string { return strings.NewReplacer("-", "_", ".", "_").Replace(name) }})
+
+	pools, dropped := dropPoolNameCollisions([]zpool{{name: "data-1"}, {name: "tank"}, {name: "data.1"}, {name: "data_1"}})
+	if got := poolNames(pools); dropped != 2 || len(got) != 2 || got[0] != "data-1" || got[1] != "tank" {
+		t.Errorf("Incorrect pools %v after dropping %d collisions, should be data-1 and tank after dropping 2", got, dropped)
+	}
+	if pools, dropped := dropPoolNameCollisions([]zpool{{name: "tank"}, {name: "backup"}}); dropped != 0 || len(pools) != 2 {
+		t.Errorf("Pools without collisions should all be kept, got %v after dropping %d", poolNames(pools), dropped)
+	}
+}
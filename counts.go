@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	poolDatasetCountDesc = prometheus.NewDesc("zfs_pool_dataset_count",
+		"Number of filesystems and volumes in the pool, including the root dataset", []string{"name"}, nil)
+	poolSnapshotCountDesc = prometheus.NewDesc("zfs_pool_snapshot_count",
+		"Number of snapshots in the pool", []string{"name"}, nil)
+)
+
+// poolCounts holds the number of datasets and snapshots of one pool.
+type poolCounts struct {
+	datasets  uint64
+	snapshots uint64
+}
+
+// parseCountProperties parses zfs get -Hp -o name,property,value
+// filesystem_count,snapshot_count output for pool root datasets. Only pools
+// for which both counts are tracked (which requires a filesystem or snapshot
+// limit somewhere in the pool) are returned.
+func parseCountProperties(output string) map[string]*poolCounts {
+	fs := map[string]uint64{}
+	snap := map[string]uint64{}
+	for lines := newLineScanner(output); lines.scan(); {
+		fields := strings.Split(lines.line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			continue // "-" when the count is not tracked
+		}
+		switch fields[1] {
+		case "filesystem_count":
+			fs[fields[0]] = v
+		case "snapshot_count":
+			snap[fields[0]] = v
+		}
+	}
+	counts := map[string]*poolCounts{}
+	for name, f := range fs {
+		if s, ok := snap[name]; ok {
+			// filesystem_count only counts descendants
+			counts[name] = &poolCounts{datasets: f + 1, snapshots: s}
+		}
+	}
+	return counts
+}
+
+// countNames counts the datasets and snapshots per pool from
+// zfs list -H -o name output.
+func countNames(scanner *bufio.Scanner, counts map[string]*poolCounts) error {
+	for scanner.Scan() {
+		name := scanner.Text()
+		if name == "" {
+			continue
+		}
+		pool := name
+		if i := strings.IndexAny(name, "/@"); i >= 0 {
+			pool = name[:i]
+		}
+		c, ok := counts[pool]
+		if !ok {
+			c = &poolCounts{}
+			counts[pool] = c
+		}
+		if strings.Contains(name, "@") {
+			c.snapshots++
+		} else {
+			c.datasets++
+		}
+	}
+	return scanError(scanner)
+}
+
+// poolCountCollector exports the number of datasets and snapshots per pool,
+// a cheap aggregate compared to per-dataset metrics. It prefers the
+// filesystem_count and snapshot_count properties of the pool root dataset,
+// and falls back to counting the names listed by zfs list for pools where
+// those are not tracked.
+type poolCountCollector struct{}
+
+func (poolCountCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- poolDatasetCountDesc
+	ch <- poolSnapshotCountDesc
+}
+
+// countsCommand is the zfs get of the count properties of pools.
+func countsCommand(pools []zpool) command {
+	return command{"zfs", append([]string{"get", "-Hp", "-o", "name,property,value", "filesystem_count,snapshot_count"}, poolNames(pools)...)}
+}
+
+// countListCommand lists the names to count for pools without the count
+// properties.
+func countListCommand(pools []zpool) command {
+	return command{"zfs", append([]string{"list", "-H", "-o", "name", "-t", "filesystem,volume,snapshot", "-r"}, poolNames(pools)...)}
+}
+
+func (poolCountCollector) plan(pools []zpool) []plannedCommand {
+	return []plannedCommand{
+		{countsCommand(pools), ""},
+		{countListCommand(pools), "for the pools without filesystem_count and snapshot_count"},
+	}
+}
+
+func (poolCountCollector) collect(r commandRunner, pools []zpool, ch chan<- prometheus.Metric) error {
+	output, err := countsCommand(pools).run(r)
+	if err != nil {
+		return fmt.Errorf("zfs get filesystem_count,snapshot_count: %s", strings.TrimSpace(output))
+	}
+	counts := parseCountProperties(output)
+
+	var uncounted []zpool
+	for _, pool := range pools {
+		if _, ok := counts[pool.name]; !ok {
+			uncounted = append(uncounted, pool)
+		}
+	}
+	if len(uncounted) > 0 {
+		list, err := countListCommand(uncounted).start(r)
+		if err != nil {
+			return err
+		}
+		err = countNames(newOutputScanner(list), counts)
+		if closeErr := list.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("listing datasets: %s", err)
+		}
+	}
+
+	for _, pool := range pools {
+		c, ok := counts[pool.name]
+		if !ok {
+			c = &poolCounts{}
+		}
+		emitIfKnown(ch, poolDatasetCountDesc, float64(c.datasets), ok, pool.name)
+		emitIfKnown(ch, poolSnapshotCountDesc, float64(c.snapshots), ok, pool.name)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPoolCountCollector(t *testing.T) {
+	r := staticRunner{
+		"zfs get -Hp -o name,property,value filesystem_count,snapshot_count tank backup": "tank\tfilesystem_count\t41\n" +
+			"tank\tsnapshot_count\t1200\n" +
+			"backup\tfilesystem_count\t-\n" +
+			"backup\tsnapshot_count\t-\n",
+		"zfs list -H -o name -t filesystem,volume,snapshot -r backup": "backup\n" +
+			"backup/a\n" +
+			"backup/a@1\n" +
+			"backup/a@2\n" +
+			"backup/zvol\n",
+	}
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		if err := (poolCountCollector{}).collect(r, []zpool{{name: "tank"}, {name: "backup"}}, ch); err != nil {
+			t.Errorf("Error in collect (%s)", err)
+		}
+		close(ch)
+	}()
+	got := map[string]float64{}
+	for m := range ch {
+		got[descName(m.Desc())+" "+metricLabel(m, "name")] = metricValue(m)
+	}
+	for key, want := range map[string]float64{
+		"zfs_pool_dataset_count tank":    42, // root dataset included
+		"zfs_pool_snapshot_count tank":   1200,
+		"zfs_pool_dataset_count backup":  3,
+		"zfs_pool_snapshot_count backup": 2,
+	} {
+		if got[key] != want {
+			t.Errorf("Incorrect %s (%v), should be %v", key, got[key], want)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// dbufCacheSizeDesc is exported by the kmem collector from dbuf_size of
+// arcstats unless the dbuf collector exports it from dbufstats.
+var dbufCacheSizeDesc = prometheus.NewDesc("zfs_dbuf_cache_size_bytes", "Size of the dbuf cache", nil, nil)
+
+// dbufMetrics are the dbufstats statistics exported by the dbuf collector.
+// Releases before OpenZFS 0.8 have no dbufstats, and some statistics were
+// added later, which are left out where missing.
+var dbufMetrics = []kstatMetric{
+	{stat: "cache_size_bytes", desc: dbufCacheSizeDesc},
+	newKstatMetric("cache_target_bytes", "zfs_dbuf_cache_max_bytes", "Size the dbuf cache is evicted down to", false),
+	newKstatMetric("cache_count", "zfs_dbuf_cache_buffers", "Number of buffers in the dbuf cache", false),
+	newKstatMetric("cache_total_evicts", "zfs_dbuf_cache_evictions_total", "Number of buffers evicted from the dbuf cache", true),
+	newKstatMetric("metadata_cache_size_bytes", "zfs_dbuf_metadata_cache_size_bytes", "Size of the dbuf metadata cache", false),
+	newKstatMetric("metadata_cache_overflow", "zfs_dbuf_metadata_cache_overflows_total", "Number of times the dbuf metadata cache grew beyond its limit", true),
+	newKstatMetric("hash_hits", "zfs_dbuf_hits_total", "Number of lookups that found the buffer in the dbuf hash table", true),
+	newKstatMetric("hash_misses", "zfs_dbuf_misses_total", "Number of lookups that did not find the buffer in the dbuf hash table", true),
+	newKstatMetric("hash_elements", "zfs_dbuf_hash_elements", "Number of buffers in the dbuf hash table", false),
+}
+
+func newDbufCollector() *kstatCollector {
+	return &kstatCollector{kstat: "dbufstats", metrics: dbufMetrics}
+}
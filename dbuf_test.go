@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dbufstats is the dbufstats of a release without the metadata cache.
+const dbufstats = `15 1 0x01 18 4896 3576668862 1711978956744537
+name                            type data
+cache_count                     4    1126
+cache_size_bytes                4    24795136
+cache_size_bytes_max            4    25100800
+cache_target_bytes              4    207911462
+cache_total_evicts              4    48213
+hash_hits                       4    88127364
+hash_misses                     4    2314876
+hash_elements                   4    52311
+`
+
+func TestDbufCollector(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "dbufstats"), []byte(dbufstats), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(old string) { kstatDir = old }(kstatDir)
+	kstatDir = dir
+
+	ch := make(chan prometheus.Metric, 20)
+	if err := newDbufCollector().collect(staticRunner{}, nil, ch); err != nil {
+		t.Fatalf("Error in collect (%s)", err)
+	}
+	close(ch)
+	got := map[string]float64{}
+	for m := range ch {
+		got[descName(m.Desc())] = metricValue(m)
+	}
+	want := map[string]float64{
+		"zfs_dbuf_cache_size_bytes":      24795136,
+		"zfs_dbuf_cache_max_bytes":       207911462,
+		"zfs_dbuf_cache_buffers":         1126,
+		"zfs_dbuf_cache_evictions_total": 48213,
+		"zfs_dbuf_hits_total":            88127364,
+		"zfs_dbuf_misses_total":          2314876,
+		"zfs_dbuf_hash_elements":         52311,
+	}
+	if len(got) != len(want) {
+		t.Errorf("Statistics missing from dbufstats should be left out, got %v", got)
+	}
+	for name, v := range want {
+		if got[name] != v {
+			t.Errorf("Incorrect %s (%v), should be %v", name, got[name], v)
+		}
+	}
+
+	// Without dbufstats the collector reports that it is not supported,
+	// which disables it.
+	os.Remove(filepath.Join(dir, "dbufstats"))
+	c := &optionalCollector{name: "dbuf", collector: newDbufCollector()}
+	c.run(staticRunner{}, nil, make(chan prometheus.Metric, 20))
+	if !c.disabled {
+		t.Errorf("A missing dbufstats should disable the collector")
+	}
+}
+
+// TestKmemWithoutDbufSize checks that the kmem collector leaves
+// zfs_dbuf_cache_size_bytes to the dbuf collector when both run.
+func TestKmemWithoutDbufSize(t *testing.T) {
+	descs := make(chan *prometheus.Desc, 20)
+	newKmemCollector(10).withoutDbufSize().describe(descs)
+	close(descs)
+	for desc := range descs {
+		if desc == dbufCacheSizeDesc {
+			t.Errorf("The kmem collector should not describe %s", descName(desc))
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// debugPoolsPath serves the pools as the last collection parsed them, with
+// --web.enable-debug.
+const debugPoolsPath = "/debug/pools"
+
+// maxDebugCommands is how many of the commands run since the previous
+// collection debugState keeps, in case scrapes stop while the import
+// collector keeps scanning.
+const maxDebugCommands = 1000
+
+// debugState is what debugPoolsPath serves: the commands run by a
+// debugRunner, and the snapshot of the pools taken by the collection
+// that ran them. Serving it never runs a command.
+type debugState struct {
+	mutex    sync.Mutex
+	commands []debugCommand // since the previous snapshot
+
+	snapshot atomic.Value // *debugSnapshot, nil before the first collection
+}
+
+// debugCommand is a command run by the exporter, as its exact command line.
+type debugCommand struct {
+	Command  string    `json:"command"`
+	Started  time.Time `json:"started"`
+	Duration float64   `json:"duration_seconds"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// debugSnapshot is the body of debugPoolsPath.
+type debugSnapshot struct {
+	Started   time.Time      `json:"started"`
+	Collected time.Time      `json:"collected"`
+	Commands  []debugCommand `json:"commands"`
+	Pools     []debugPool    `json:"pools"`
+}
+
+// debugPool is a zpool with all the fields it was parsed into. Sizes are in
+// bytes, and -1 stands for values zpool did not show, as in zpool.
+type debugPool struct {
+	Name           string             `json:"name"`
+	Error          string             `json:"error,omitempty"`
+	Warnings       []string           `json:"warnings,omitempty"`
+	StatusTime     *time.Time         `json:"status_time,omitempty"`
+	Size           uint64             `json:"size"`
+	Alloc          uint64             `json:"alloc"`
+	Free           uint64             `json:"free"`
+	Capacity       int64              `json:"capacity"`
+	Fragmentation  int64              `json:"fragmentation"`
+	Health         string             `json:"health"`
+	Readonly       bool               `json:"readonly"`
+	Altroot        string             `json:"altroot"`
+	Cachefile      string             `json:"cachefile"`
+	Comment        string             `json:"comment"`
+	Bootfs         string             `json:"bootfs"`
+	Version        string             `json:"version"`
+	GUID           string             `json:"guid"`
+	RootUsed       *uint64            `json:"root_used,omitempty"`
+	RootAvailable  *uint64            `json:"root_available,omitempty"`
+	Healthy        bool               `json:"healthy"`
+	Status         string             `json:"status"`
+	Online         int64              `json:"online"`
+	Faulted        int64              `json:"faulted"`
+	Configured     int64              `json:"configured"`
+	LogsFaulted    int64              `json:"logs_faulted"`
+	CacheFaulted   int64              `json:"cache_faulted"`
+	StatusReason   string             `json:"status_reason"`
+	Creation       int64              `json:"creation"`
+	Devices        []debugDevice      `json:"devices"`
+	Indirect       int64              `json:"indirect_vdevs"`
+	Removal        *debugRemoval      `json:"removal,omitempty"`
+	Scan           debugScan          `json:"scan"`
+	LastScrub      *time.Time         `json:"last_scrub,omitempty"`
+	DDT            *debugDDT          `json:"ddt,omitempty"`
+	DataErrors     int64              `json:"data_errors"`
+	DataErrorFiles []string           `json:"data_error_entries,omitempty"`
+	Vdevs          []debugVdev        `json:"vdevs,omitempty"`
+	Ashifts        map[string]int64   `json:"ashifts,omitempty"`
+	InProgress     map[string]bool    `json:"activities_in_progress,omitempty"`
+	PercentDone    map[string]float64 `json:"activities_percent_done,omitempty"`
+	SlowIOs        map[string]float64 `json:"slow_ios,omitempty"`
+}
+
+type debugDevice struct {
+	Device      string         `json:"device"`
+	GUID        string         `json:"guid,omitempty"`
+	Resilvering bool           `json:"resilvering"`
+	Read        *float64       `json:"read_errors,omitempty"`
+	Write       *float64       `json:"write_errors,omitempty"`
+	Checksum    *float64       `json:"checksum_errors,omitempty"`
+	Initialize  *debugProgress `json:"initialize,omitempty"`
+}
+
+type debugProgress struct {
+	State       string     `json:"state"`
+	PercentDone float64    `json:"percent_done"`
+	At          *time.Time `json:"at,omitempty"`
+}
+
+type debugRemoval struct {
+	InProgress bool    `json:"in_progress"`
+	Copied     float64 `json:"copied"`
+	Total      float64 `json:"total"`
+}
+
+type debugScan struct {
+	Function string     `json:"function"`
+	State    string     `json:"state"`
+	End      *time.Time `json:"end,omitempty"`
+	Rate     float64    `json:"rate"`
+	Scanned  float64    `json:"scanned"`
+	Issued   float64    `json:"issued"`
+	Total    float64    `json:"total"`
+}
+
+type debugDDT struct {
+	Entries uint64 `json:"entries"`
+	OnDisk  uint64 `json:"on_disk"`
+	InCore  uint64 `json:"in_core"`
+}
+
+type debugVdev struct {
+	Name          string `json:"name"`
+	Size          uint64 `json:"size"`
+	Alloc         uint64 `json:"alloc"`
+	Fragmentation int64  `json:"fragmentation"`
+}
+
+// optionalTime returns nil for the zero time, which stands for unknown.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// newDebugPool copies the fields of pool. Permanent error entries are hashed
+// unless showPaths is set, as in zpool_permanent_error_info.
+func newDebugPool(pool zpool, showPaths bool) debugPool {
+	p := pool.properties
+	d := debugPool{
+		Name:          pool.name,
+		Warnings:      pool.warnings,
+		StatusTime:    optionalTime(pool.statusTime),
+		Size:          pool.size,
+		Alloc:         pool.alloc,
+		Free:          pool.free,
+		Capacity:      pool.capacity,
+		Fragmentation: pool.fragmentation,
+		Health:        pool.health,
+		Readonly:      pool.readonly,
+		Altroot:       pool.altroot,
+		Cachefile:     pool.cachefile,
+		Comment:       p.comment,
+		Bootfs:        p.bootfs,
+		Version:       p.version,
+		GUID:          p.guid,
+		Healthy:       pool.healthy,
+		Status:        pool.status,
+		Online:        pool.online,
+		Faulted:       pool.faulted,
+		Configured:    pool.configured,
+		LogsFaulted:   pool.logsFaulted,
+		CacheFaulted:  pool.cacheFaulted,
+		StatusReason:  pool.statusReason,
+		Creation:      pool.creation,
+		Devices:       []debugDevice{},
+		Indirect:      pool.indirect,
+		Scan: debugScan{
+			Function: pool.scan.function,
+			State:    pool.scan.state,
+			End:      optionalTime(pool.scan.end),
+			Rate:     pool.scan.rate,
+			Scanned:  pool.scan.scanned,
+			Issued:   pool.scan.issued,
+			Total:    pool.scan.total,
+		},
+		LastScrub:  optionalTime(pool.lastScrub),
+		DataErrors: pool.dataErrors.count,
+	}
+	if pool.err != nil {
+		d.Error = pool.err.Error()
+	}
+	if pool.rootSpace {
+		d.RootUsed, d.RootAvailable = &pool.rootUsed, &pool.rootAvailable
+	}
+	for _, device := range pool.devices {
+		dd := debugDevice{Device: device.device, GUID: device.guid, Resilvering: device.resilvering}
+		if device.errorsKnown {
+			errors := device.errors
+			dd.Read, dd.Write, dd.Checksum = &errors.read, &errors.write, &errors.checksum
+		}
+		if i := device.initialize; i != nil {
+			dd.Initialize = &debugProgress{State: i.state, PercentDone: i.percentDone, At: optionalTime(i.at)}
+		}
+		d.Devices = append(d.Devices, dd)
+	}
+	if pool.removal.present {
+		d.Removal = &debugRemoval{InProgress: pool.removal.inProgress, Copied: pool.removal.copied, Total: pool.removal.total}
+	}
+	if pool.ddt != nil {
+		d.DDT = &debugDDT{Entries: pool.ddt.entries, OnDisk: pool.ddt.onDisk, InCore: pool.ddt.inCore}
+	}
+	for _, entry := range pool.dataErrors.entries {
+		d.DataErrorFiles = append(d.DataErrorFiles, errorEntryLabel(entry, showPaths))
+	}
+	for _, v := range pool.vdevs {
+		d.Vdevs = append(d.Vdevs, debugVdev{Name: v.name, Size: v.size, Alloc: v.alloc, Fragmentation: v.fragmentation})
+	}
+	if len(pool.ashifts) > 0 {
+		d.Ashifts = map[string]int64{}
+		for _, a := range pool.ashifts {
+			d.Ashifts[a.vdev] = a.ashift
+		}
+	}
+	for activity, ok := range pool.activities.inProgress {
+		if d.InProgress == nil {
+			d.InProgress = map[string]bool{}
+		}
+		d.InProgress[activity] = ok
+	}
+	for activity, percent := range pool.activities.percentDone {
+		if d.PercentDone == nil {
+			d.PercentDone = map[string]float64{}
+		}
+		d.PercentDone[activity] = percent
+	}
+	if len(pool.slowIOs) > 0 {
+		d.SlowIOs = map[string]float64{}
+		for _, s := range pool.slowIOs {
+			d.SlowIOs[s.device] = s.slow
+		}
+	}
+	return d
+}
+
+// record adds a command that ran from start until now.
+func (s *debugState) record(name string, args []string, start time.Time, err error) {
+	c := debugCommand{Command: commandLine(name, args), Started: start, Duration: time.Since(start).Seconds()}
+	if err != nil {
+		c.Error = err.Error()
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.commands) >= maxDebugCommands {
+		s.commands = s.commands[1:]
+	}
+	s.commands = append(s.commands, c)
+}
+
+// store replaces the snapshot with the pools of a collection that started
+// at started, and the commands run since the previous one. The pools are
+// copied, so that the next collection does not change them.
+func (s *debugState) store(pools []zpool, showPaths bool, started time.Time) {
+	snapshot := &debugSnapshot{Started: started, Collected: time.Now(), Pools: make([]debugPool, len(pools))}
+	for i, pool := range pools {
+		snapshot.Pools[i] = newDebugPool(pool, showPaths)
+	}
+	s.mutex.Lock()
+	snapshot.Commands, s.commands = s.commands, nil
+	s.mutex.Unlock()
+	if snapshot.Commands == nil {
+		snapshot.Commands = []debugCommand{}
+	}
+	s.snapshot.Store(snapshot)
+}
+
+// ServeDebugPools serves the last snapshot as JSON, or 503 before the first
+// collection finished.
+func (s *debugState) ServeDebugPools(w http.ResponseWriter, r *http.Request) {
+	snapshot, ok := s.snapshot.Load().(*debugSnapshot)
+	if !ok {
+		http.Error(w, "no collection finished yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(snapshot)
+}
+
+// commandLine formats a command as it could be typed in a shell, quoting the
+// arguments that need it.
+func commandLine(name string, args []string) string {
+	words := []string{name}
+	for _, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$`*?;&|<>()") {
+			arg = strconv.Quote(arg)
+		}
+		words = append(words, arg)
+	}
+	return strings.Join(words, " ")
+}
+
+// debugRunner records the commands it runs in a debugState.
+type debugRunner struct {
+	commandRunner
+	debug *debugState
+}
+
+func (r debugRunner) run(name string, args ...string) (string, error) {
+	start := time.Now()
+	out, err := r.commandRunner.run(name, args...)
+	r.debug.record(name, args, start, err)
+	return out, err
+}
+
+func (r debugRunner) withContext(ctx context.Context) commandRunner {
+	return debugRunner{runnerWithContext(r.commandRunner, ctx), r.debug}
+}
+
+func (r debugRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	start := time.Now()
+	out, err := r.commandRunner.start(name, args...)
+	if err != nil {
+		r.debug.record(name, args, start, err)
+		return nil, err
+	}
+	return &instrumentedOutput{ReadCloser: out, done: func(err error) {
+		r.debug.record(name, args, start, err)
+	}}, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeDebugPools(t *testing.T) {
+	e := newMockExporter(t)
+	e.debug = &debugState{}
+	e.runner = debugRunner{e.runner, e.debug}
+	serve := func() (int, debugSnapshot) {
+		w := httptest.NewRecorder()
+		e.debug.ServeDebugPools(w, httptest.NewRequest("GET", debugPoolsPath, nil))
+		var body debugSnapshot
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Error decoding %s (%s)", w.Body, err)
+			}
+		}
+		return w.Code, body
+	}
+
+	if code, _ := serve(); code != http.StatusServiceUnavailable {
+		t.Errorf("Debug pools before a collection should answer 503, got %d", code)
+	}
+	e.snapshot(nil)
+	code, body := serve()
+	if code != http.StatusOK || len(body.Pools) != 2 || body.Collected.Before(body.Started) {
+		t.Fatalf("Incorrect debug pools %d %+v", code, body)
+	}
+	var listed bool
+	for _, c := range body.Commands {
+		listed = listed || strings.HasPrefix(c.Command, "zpool list -Hp -o ")
+	}
+	if !listed {
+		t.Errorf("Debug pools should list the zpool list command line, got %+v", body.Commands)
+	}
+	backup := body.Pools[1]
+	if backup.Name != "backup" || backup.Health != "DEGRADED" || len(backup.Devices) == 0 || backup.DataErrors != 2 {
+		t.Errorf("Incorrect debug pool %+v", backup)
+	}
+	for _, entry := range backup.DataErrorFiles {
+		if strings.Contains(entry, "IMG_0042") {
+			t.Errorf("Debug pools should hash the permanent error paths, got %q", entry)
+		}
+	}
+
+	// Serving does not run anything: the next snapshot only has the
+	// commands of the next collection.
+	serve()
+	e.debug.mutex.Lock()
+	pending := len(e.debug.commands)
+	e.debug.mutex.Unlock()
+	if pending != 0 {
+		t.Errorf("Serving debug pools should not run commands, ran %d", pending)
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	got := commandLine("zfs", []string{"list", "-H", "-o", "name", "tank/my data", ""})
+	if want := `zfs list -H -o name "tank/my data" ""`; got != want {
+		t.Errorf("Incorrect command line %s, should be %s", got, want)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	deviceReadErrorsDesc = prometheus.NewDesc("zpool_device_read_errors_observed_total",
+		"Number of read errors of the device seen by the exporter since it started, kept across zpool clear", []string{"name", "device", "enclosure", "slot", "guid"}, nil)
+	deviceWriteErrorsDesc = prometheus.NewDesc("zpool_device_write_errors_observed_total",
+		"Number of write errors of the device seen by the exporter since it started, kept across zpool clear", []string{"name", "device", "enclosure", "slot", "guid"}, nil)
+	deviceChecksumErrorsDesc = prometheus.NewDesc("zpool_device_checksum_errors_observed_total",
+		"Number of checksum errors of the device seen by the exporter since it started, kept across zpool clear", []string{"name", "device", "enclosure", "slot", "guid"}, nil)
+	deviceErrorResetsDesc = prometheus.NewDesc("zpool_device_error_counter_resets_total",
+		"Number of collections that found an error counter of the device lower than at the previous one, as after zpool clear", []string{"name", "device", "enclosure", "slot", "guid"}, nil)
+)
+
+// poolDevice is a leaf device of a pool, by its GUID when known and by its
+// name otherwise, so that its counts survive a rename with
+// -collect-device-guids.
+type poolDevice struct {
+	pool, device string
+}
+
+// observedErrors accumulates the error counters of one device.
+type observedErrors struct {
+	device, guid  string // as last seen
+	enclosureSlot        // as last seen
+	last, total   deviceErrors
+	resets        float64 // collections that found a counter reset
+	created       time.Time
+}
+
+// errorTracker turns the error counters of zpool status, which zpool clear,
+// an export or a reboot set back to 0, into counters that only increase, so
+// that increase() over a long range is not lost to a clear. A counter lower
+// than at the previous collection was reset, and its whole value is new
+// errors, as the rate functions of Prometheus assume, and the collection is
+// counted in resets, to tell when the errors were cleared. Devices start at their
+// count when first seen. The zero value is ready to use; like healthTracker,
+// it is not safe for concurrent use.
+type errorTracker struct {
+	devices map[poolDevice]*observedErrors
+	// events gives the counters that are above 0 the last error report of
+	// the device as an exemplar, nil without -collector.events.
+	events *eventsCollector
+}
+
+// observe records the error counters of the devices of pools at now. Pools
+// that failed to collect keep their counts; devices no longer in a pool that
+// was collected, and pools no longer monitored, are forgotten.
+func (t *errorTracker) observe(pools []zpool, now time.Time) {
+	if t.devices == nil {
+		t.devices = map[poolDevice]*observedErrors{}
+	}
+	// collected holds whether each monitored pool was collected.
+	collected := map[string]bool{}
+	seen := map[poolDevice]bool{}
+	for _, pool := range pools {
+		collected[pool.name] = pool.err == nil
+		if pool.err != nil {
+			continue
+		}
+		for _, d := range pool.devices {
+			if !d.errorsKnown {
+				continue
+			}
+			key := poolDevice{pool.name, d.device}
+			if d.guid != "" {
+				key.device = d.guid
+			}
+			seen[key] = true
+			o, ok := t.devices[key]
+			if !ok {
+				t.devices[key] = &observedErrors{device: d.device, guid: d.guid, enclosureSlot: d.enclosureSlot, last: d.errors, total: d.errors, created: now}
+				continue
+			}
+			o.device, o.guid, o.enclosureSlot = d.device, d.guid, d.enclosureSlot
+			o.total.read += counterIncrease(o.last.read, d.errors.read)
+			o.total.write += counterIncrease(o.last.write, d.errors.write)
+			o.total.checksum += counterIncrease(o.last.checksum, d.errors.checksum)
+			if d.errors.read < o.last.read || d.errors.write < o.last.write || d.errors.checksum < o.last.checksum {
+				o.resets++
+			}
+			o.last = d.errors
+		}
+	}
+	for key := range t.devices {
+		ok, monitored := collected[key.pool]
+		if !monitored || ok && !seen[key] {
+			delete(t.devices, key)
+		}
+	}
+}
+
+// counterIncrease is how much a counter increased from prev to cur, treating
+// a decrease as a reset to 0 in between.
+func counterIncrease(prev, cur float64) float64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+func (t *errorTracker) collect(ch chan<- prometheus.Metric) {
+	for key, o := range t.devices {
+		ch <- t.counter(deviceReadErrorsDesc, o.total.read, key, o, "read")
+		ch <- t.counter(deviceWriteErrorsDesc, o.total.write, key, o, "write")
+		ch <- t.counter(deviceChecksumErrorsDesc, o.total.checksum, key, o, "checksum")
+		ch <- prometheus.MustNewConstMetricWithCreatedTimestamp(deviceErrorResetsDesc, prometheus.CounterValue, o.resets, o.created, key.pool, o.device, o.enclosure, o.slot, o.guid)
+	}
+}
+
+// counter returns the counter of the errors of kind of the device, with the
+// exemplar of t.events once there were any.
+func (t *errorTracker) counter(desc *prometheus.Desc, value float64, key poolDevice, o *observedErrors, kind string) prometheus.Metric {
+	m := prometheus.MustNewConstMetricWithCreatedTimestamp(desc, prometheus.CounterValue, value, o.created, key.pool, o.device, o.enclosure, o.slot, o.guid)
+	if t.events == nil || value == 0 {
+		return m
+	}
+	return t.events.errorExemplar(m, key.pool, o.device, o.guid, kind)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestErrorTracker(t *testing.T) {
+	var tracker errorTracker
+	now := time.Unix(1700000000, 0)
+	pool := func(name string, checksum ...float64) zpool {
+		z := zpool{name: name}
+		for i, v := range checksum {
+			z.devices = append(z.devices, statusDevice{device: string(rune('a' + i)), errors: deviceErrors{read: v, checksum: v}, errorsKnown: true})
+		}
+		return z
+	}
+	failed := zpool{name: "backup", err: errors.New("zpool status failed")}
+	for _, pools := range [][]zpool{
+		{pool("tank", 2, 0), pool("backup", 1)},
+		{pool("tank", 5, 0), failed},
+		// zpool clear, then new errors on sda
+		{pool("tank", 1, 0), failed},
+		{pool("tank", 4, 0), pool("backup", 1)},
+		// sdb was removed
+		{pool("tank", 4), pool("backup", 1)},
+	} {
+		tracker.observe(pools, now)
+		now = now.Add(time.Minute)
+	}
+
+	ch := make(chan prometheus.Metric, 20)
+	tracker.collect(ch)
+	close(ch)
+	got, resets := map[string]float64{}, map[string]float64{}
+	for m := range ch {
+		key := metricLabel(m, "name") + " " + metricLabel(m, "device")
+		switch m.Desc() {
+		case deviceChecksumErrorsDesc:
+			got[key] = metricValue(m)
+		case deviceErrorResetsDesc:
+			resets[key] = metricValue(m)
+		}
+	}
+	// 2, +3, reset to 1, +3
+	want := map[string]float64{"tank a": 9, "backup a": 1}
+	if len(got) != len(want) {
+		t.Errorf("Incorrect checksum errors %v, should be %v", got, want)
+	}
+	for key, v := range want {
+		if got[key] != v {
+			t.Errorf("Incorrect checksum errors of %s (%v), should be %v", key, got[key], v)
+		}
+	}
+
+	if want := map[string]float64{"tank a": 1, "backup a": 0}; len(resets) != len(want) || resets["tank a"] != 1 || resets["backup a"] != 0 {
+		t.Errorf("Incorrect error counter resets %v, should be %v", resets, want)
+	}
+
+	// backup is no longer monitored
+	tracker.observe([]zpool{pool("tank", 4)}, now)
+	if _, ok := tracker.devices[poolDevice{"backup", "a"}]; ok {
+		t.Errorf("Devices of pools no longer monitored should be forgotten")
+	}
+}
+
+func TestErrorTrackerGUIDs(t *testing.T) {
+	var tracker errorTracker
+	now := time.Unix(1700000000, 0)
+	pool := func(device string, checksum float64) []zpool {
+		return []zpool{{name: "tank", devices: []statusDevice{
+			{device: device, guid: "1234", errors: deviceErrors{checksum: checksum}, errorsKnown: true},
+		}}}
+	}
+	// The controller was renumbered between the collections.
+	tracker.observe(pool("sda", 2), now)
+	tracker.observe(pool("sdc", 3), now.Add(time.Minute))
+
+	ch := make(chan prometheus.Metric, 8)
+	tracker.collect(ch)
+	close(ch)
+	for m := range ch {
+		if m.Desc() != deviceChecksumErrorsDesc {
+			continue
+		}
+		if device, guid := metricLabel(m, "device"), metricLabel(m, "guid"); device != "sdc" || guid != "1234" || metricValue(m) != 3 {
+			t.Errorf("The counts of a renamed device should continue under its new name, got %s %s %v", device, guid, metricValue(m))
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sysfsDir and devDir are where the enclosure lookup finds block devices on
+// Linux.
+var (
+	sysfsDir = "/sys"
+	devDir   = "/dev"
+)
+
+// devLinkDirs are searched, in order, for the device names zpool status
+// shows, such as "sda", "ata-WDC_WD80EFAX-68KNBN0_VAJ8AAAL" or the aliases
+// of /etc/zfs/vdev_id.conf.
+var devLinkDirs = []string{"", "disk/by-vdev", "disk/by-id", "disk/by-path", "disk/by-partlabel", "mapper"}
+
+// enclosureSlot is where a leaf vdev sits in a SCSI enclosure: the SES
+// device of the enclosure, such as "0:0:24:0", and the slot, such as "12".
+// Both are empty when the disk is not in an enclosure the kernel knows of.
+type enclosureSlot struct {
+	enclosure string
+	slot      string
+}
+
+// lookupEnclosure finds the enclosure slot of a leaf vdev the way ZFS finds
+// vdev_enc_sysfs_path: from the enclosure_device link of the disk in sysfs.
+func lookupEnclosure(device string) enclosureSlot {
+	disk := blockDevice(device)
+	if disk == "" {
+		return enclosureSlot{}
+	}
+	links, _ := filepath.Glob(filepath.Join(sysfsDir, "class/block", disk, "device/enclosure_device:*"))
+	if len(links) == 0 {
+		return enclosureSlot{}
+	}
+	component, err := filepath.EvalSymlinks(links[0])
+	if err != nil {
+		return enclosureSlot{}
+	}
+	// Newer kernels number the slots, older ones only name the components,
+	// such as "SLOT 12" or "Disk012".
+	slot := strings.TrimPrefix(filepath.Base(links[0]), "enclosure_device:")
+	if b, err := os.ReadFile(filepath.Join(component, "slot")); err == nil {
+		slot = strings.TrimSpace(string(b))
+	}
+	return enclosureSlot{enclosure: filepath.Base(filepath.Dir(component)), slot: slot}
+}
+
+// blockDevice returns the kernel name of the disk holding device, such as
+// "sda" for "ata-WDC_WD80EFAX-68KNBN0_VAJ8AAAL-part1", or "" when it cannot
+// be found.
+func blockDevice(device string) string {
+	candidates := []string{device}
+	if !filepath.IsAbs(device) {
+		candidates = nil
+		for _, dir := range devLinkDirs {
+			candidates = append(candidates, filepath.Join(devDir, dir, device))
+		}
+	}
+	var name string
+	for _, path := range candidates {
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			name = filepath.Base(resolved)
+			break
+		}
+	}
+	if name == "" {
+		return ""
+	}
+	// Partitions are found under their disk, as in
+	// /sys/devices/.../block/sda/sda1.
+	block := filepath.Join(sysfsDir, "class/block", name)
+	if _, err := os.Stat(filepath.Join(block, "partition")); err == nil {
+		resolved, err := filepath.EvalSymlinks(block)
+		if err != nil {
+			return ""
+		}
+		return filepath.Base(filepath.Dir(resolved))
+	}
+	return name
+}
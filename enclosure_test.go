@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fakeSysfs builds a sysfs and /dev with sda1 on sda in slot 12 of an
+// enclosure, and sdb on no enclosure.
+func fakeSysfs(t *testing.T) {
+	root := t.TempDir()
+	sys := filepath.Join(root, "sys")
+	dev := filepath.Join(root, "dev")
+	sda := filepath.Join(sys, "devices/pci0000:00/host0/target0:0:3/0:0:3:0/block/sda")
+	sdb := filepath.Join(sys, "devices/pci0000:00/host0/target0:0:4/0:0:4:0/block/sdb")
+	slot := filepath.Join(sys, "devices/pci0000:00/host0/target0:0:24/0:0:24:0/enclosure/0:0:24:0/SLOT 12")
+	for _, dir := range []string{
+		filepath.Join(sda, "sda1"), filepath.Join(sda, "device"), sdb, slot,
+		filepath.Join(sys, "class/block"), filepath.Join(dev, "disk/by-id"),
+	} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []struct{ path, content string }{
+		{filepath.Join(sda, "sda1/partition"), "1\n"},
+		{filepath.Join(slot, "slot"), "12\n"},
+		{filepath.Join(dev, "sda"), ""},
+		{filepath.Join(dev, "sda1"), ""},
+		{filepath.Join(dev, "sdb"), ""},
+	} {
+		if err := os.WriteFile(f.path, []byte(f.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, l := range []struct{ target, link string }{
+		{sda, filepath.Join(sys, "class/block/sda")},
+		{filepath.Join(sda, "sda1"), filepath.Join(sys, "class/block/sda1")},
+		{sdb, filepath.Join(sys, "class/block/sdb")},
+		{slot, filepath.Join(sda, "device/enclosure_device:SLOT 12")},
+		{"../../sda1", filepath.Join(dev, "disk/by-id/ata-WDC_WD80EFAX-68KNBN0_VAJ8AAAL-part1")},
+	} {
+		if err := os.Symlink(l.target, l.link); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldSys, oldDev := sysfsDir, devDir
+	t.Cleanup(func() { sysfsDir, devDir = oldSys, oldDev })
+	sysfsDir, devDir = sys, dev
+}
+
+func TestLookupEnclosure(t *testing.T) {
+	fakeSysfs(t)
+	inSlot := enclosureSlot{enclosure: "0:0:24:0", slot: "12"}
+	for device, want := range map[string]enclosureSlot{
+		"sda":  inSlot,
+		"sda1": inSlot,
+		"ata-WDC_WD80EFAX-68KNBN0_VAJ8AAAL-part1": inSlot,
+		filepath.Join(devDir, "sda1"):             inSlot,
+		"sdb":                                     {},
+		"nvme0n1":                                 {},
+	} {
+		if got := lookupEnclosure(device); got != want {
+			t.Errorf("Incorrect enclosure slot of %s %v, should be %v", device, got, want)
+		}
+	}
+
+	// Without a slot file, the component name is the slot
+	if err := os.Remove(filepath.Join(sysfsDir, "devices/pci0000:00/host0/target0:0:24/0:0:24:0/enclosure/0:0:24:0/SLOT 12/slot")); err != nil {
+		t.Fatal(err)
+	}
+	if got := lookupEnclosure("sda"); got.slot != "SLOT 12" {
+		t.Errorf("Incorrect slot %q, should be the component name", got.slot)
+	}
+}
+
+func TestPoolEnclosures(t *testing.T) {
+	fakeSysfs(t)
+	z := zpool{name: "backup"}
+	if err := z.setStatus(degradedStatus, poolOptions{enclosures: true}); err != nil {
+		t.Fatal(err)
+	}
+	inSlot := enclosureSlot{enclosure: "0:0:24:0", slot: "12"}
+	want := map[string]enclosureSlot{"sda": inSlot, "sdb": {}}
+	if len(z.devices) != len(want) {
+		t.Fatalf("Incorrect devices %v, should be sda and sdb", z.devices)
+	}
+	for _, d := range z.devices {
+		if d.enclosureSlot != want[d.device] {
+			t.Errorf("Incorrect enclosure slot of %s %v without -s, should be %v", d.device, d.enclosureSlot, want[d.device])
+		}
+	}
+
+	// The observed error counters carry the labels too
+	var tracker errorTracker
+	tracker.observe([]zpool{z}, time.Now())
+	ch := make(chan prometheus.Metric, 8)
+	tracker.collect(ch)
+	close(ch)
+	for m := range ch {
+		device := metricLabel(m, "device")
+		if got := (enclosureSlot{metricLabel(m, "enclosure"), metricLabel(m, "slot")}); got != want[device] {
+			t.Errorf("Incorrect enclosure labels of %s for %s %v, should be %v", descName(m.Desc()), device, got, want[device])
+		}
+	}
+}
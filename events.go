@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var poolEreportsDesc = prometheus.NewDesc("zfs_pool_ereports_total",
+	"Number of error reports of the pool zpool events listed since the exporter started, by class such as checksum, io or delay, including those in the event log at startup", []string{"name", "class"}, nil)
+
+// ereportPrefix starts the class of the error reports among the events,
+// which also hold sysevents such as history_event.
+const ereportPrefix = "ereport.fs.zfs."
+
+// zpoolEvent is one event zpool events -v printed.
+type zpoolEvent struct {
+	eid          uint64
+	class        string // such as checksum for ereport.fs.zfs.checksum
+	ereport      bool
+	pool         string
+	guid, path   string // of the vdev, the GUID in decimal like zpool status -g
+	zioType      uint64
+	time         time.Time
+	timeReported bool
+}
+
+// errorKind returns which error counter of zpool status the event counts
+// in: checksum, read or write, or "" for the other events.
+func (e zpoolEvent) errorKind() string {
+	switch {
+	case !e.ereport:
+	case e.class == "checksum":
+		return "checksum"
+	case e.class == "io" && e.zioType == 1:
+		return "read"
+	case e.class == "io" && e.zioType == 2:
+		return "write"
+	}
+	return ""
+}
+
+// parseEvents parses the output of zpool events -H -v: each event starts on
+// an unindented line with its time and class, followed by its indented
+// name = value pairs. The pairs of embedded nvlists, such as detector, are
+// skipped up to their "(end" line.
+func parseEvents(output string) ([]zpoolEvent, error) {
+	var events []zpoolEvent
+	depth := 0
+	for lines := newLineScanner(output); lines.scan(); {
+		line := lines.line
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			continue
+		case line[0] != ' ' && line[0] != '\t':
+			fields := strings.Fields(line)
+			class := fields[len(fields)-1]
+			events = append(events, zpoolEvent{
+				class:   strings.TrimPrefix(class, ereportPrefix),
+				ereport: strings.HasPrefix(class, ereportPrefix),
+			})
+			depth = 0
+			continue
+		case len(events) == 0:
+			return nil, fmt.Errorf("unexpected line %q before the first event", line)
+		case strings.HasPrefix(trimmed, "(end "):
+			depth--
+			continue
+		}
+		key, value, ok := strings.Cut(trimmed, " = ")
+		if !ok {
+			continue
+		}
+		if value == "(embedded nvlist)" {
+			depth++
+			continue
+		}
+		if depth > 0 {
+			continue
+		}
+		e := &events[len(events)-1]
+		var err error
+		switch key {
+		case "eid":
+			e.eid, err = strconv.ParseUint(value, 0, 64)
+		case "pool":
+			e.pool = unquoteNvpair(value)
+		case "vdev_guid":
+			var guid uint64
+			guid, err = strconv.ParseUint(value, 0, 64)
+			e.guid = strconv.FormatUint(guid, 10)
+		case "vdev_path":
+			e.path = unquoteNvpair(value)
+		case "zio_type":
+			e.zioType, err = strconv.ParseUint(value, 0, 64)
+		case "time":
+			// The seconds and nanoseconds since the epoch.
+			parts := strings.Fields(value)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid time %q of event", value)
+			}
+			var sec, nsec uint64
+			if sec, err = strconv.ParseUint(parts[0], 0, 64); err == nil {
+				nsec, err = strconv.ParseUint(parts[1], 0, 64)
+			}
+			e.time, e.timeReported = time.Unix(int64(sec), int64(nsec)), true
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q of event", key, value)
+		}
+	}
+	return events, nil
+}
+
+// unquoteNvpair returns the string value of an nvpair without its quotes.
+func unquoteNvpair(value string) string {
+	if s, err := strconv.Unquote(value); err == nil {
+		return s
+	}
+	return strings.Trim(value, `"`)
+}
+
+// vdevMatches reports whether the vdev_path of an event is the device zpool
+// status names, which leaves out /dev and, for a whole disk, the partition
+// ZFS created on it.
+func vdevMatches(path, device string) bool {
+	if path == device {
+		return true
+	}
+	base := filepath.Base(path)
+	return base == device || base == device+"-part1" || base == device+"1" || base == device+"p1"
+}
+
+// errorEventKey is a device of a pool, by its GUID, and a kind of error of
+// zpoolEvent.errorKind.
+type errorEventKey struct {
+	pool, guid, kind string
+}
+
+// eventsCollector counts the error reports of the pools from zpool events,
+// and keeps the last one of each kind of error of each device, which the
+// errorTracker of the exporter attaches as exemplars to the error counters
+// of the device. zpool events lists the whole event log of the kernel
+// module, so it is read in the background by run every interval rather than
+// on every scrape, and only the events with an ID after the last one read
+// are new.
+type eventsCollector struct {
+	interval time.Duration
+
+	mutex   sync.Mutex
+	read    bool   // false until the event log was read
+	lastEID uint64 // of the last event read
+	counts  map[string]map[string]float64
+	latest  map[errorEventKey]zpoolEvent
+	err     error // of the last read
+}
+
+func newEventsCollector(interval time.Duration) *eventsCollector {
+	return &eventsCollector{
+		interval: interval,
+		counts:   map[string]map[string]float64{},
+		latest:   map[errorEventKey]zpoolEvent{},
+	}
+}
+
+func (c *eventsCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- poolEreportsDesc
+}
+
+func (c *eventsCollector) collect(r commandRunner, pools []zpool, ch chan<- prometheus.Metric) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.read {
+		return c.err
+	}
+	for _, pool := range pools {
+		for class, count := range c.counts[pool.name] {
+			ch <- prometheus.MustNewConstMetric(poolEreportsDesc, prometheus.CounterValue, count, pool.name, class)
+		}
+	}
+	return c.err
+}
+
+// run reads the event log with r right away and then every interval, until
+// ctx is done.
+func (c *eventsCollector) run(ctx context.Context, r commandRunner) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		c.poll(r)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// eventsCommand lists the events of the kernel module with their details.
+var eventsCommand = command{"zpool", []string{"events", "-H", "-v"}}
+
+func (c *eventsCollector) plan([]zpool) []plannedCommand {
+	return []plannedCommand{{eventsCommand, fmt.Sprintf("every %s in the background", c.interval)}}
+}
+
+// poll reads the event log once. A failed read fails the collector until a
+// read succeeds again, which then counts the events that were missed as long
+// as the event log still holds them.
+func (c *eventsCollector) poll(r commandRunner) {
+	output, err := eventsCommand.run(r)
+	var events []zpoolEvent
+	if err != nil {
+		err = fmt.Errorf("zpool events: %s", strings.TrimSpace(output))
+	} else {
+		err = recovered("events", func() error {
+			var err error
+			events, err = parseEvents(output)
+			return err
+		})
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.err = err
+	if err != nil {
+		return
+	}
+	c.read = true
+	var last uint64
+	for _, e := range events {
+		last = max(last, e.eid)
+	}
+	if last < c.lastEID {
+		// The IDs start over when the kernel module is loaded again.
+		c.lastEID = 0
+	}
+	for _, e := range events {
+		if e.eid <= c.lastEID || !e.ereport || e.pool == "" {
+			continue
+		}
+		if c.counts[e.pool] == nil {
+			c.counts[e.pool] = map[string]float64{}
+		}
+		c.counts[e.pool][e.class]++
+		if kind := e.errorKind(); kind != "" && e.guid != "" {
+			c.latest[errorEventKey{e.pool, e.guid, kind}] = e
+		}
+	}
+	c.lastEID = max(c.lastEID, last)
+}
+
+// lastError returns the last error report of kind, checksum, read or write,
+// of the device of pool, by its GUID when known and by its name otherwise.
+func (c *eventsCollector) lastError(pool, device, guid, kind string) (zpoolEvent, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if guid != "" {
+		e, ok := c.latest[errorEventKey{pool, guid, kind}]
+		return e, ok
+	}
+	var last zpoolEvent
+	found := false
+	for key, e := range c.latest {
+		if key.pool == pool && key.kind == kind && vdevMatches(e.path, device) && (!found || e.eid > last.eid) {
+			last, found = e, true
+		}
+	}
+	return last, found
+}
+
+// errorExemplar returns m, a counter of the errors of kind of the device,
+// with the last error report of that kind of the device as its exemplar:
+// its ID in the eid label, and the time it was reported. OpenMetrics carries
+// the exemplar, the other formats leave it out.
+func (c *eventsCollector) errorExemplar(m prometheus.Metric, pool, device, guid, kind string) prometheus.Metric {
+	e, ok := c.lastError(pool, device, guid, kind)
+	if !ok {
+		return m
+	}
+	ex := prometheus.Exemplar{Value: 1, Labels: prometheus.Labels{"eid": strconv.FormatUint(e.eid, 10)}}
+	if e.timeReported {
+		ex.Timestamp = e.time
+	}
+	return prometheus.MustNewMetricWithExemplars(m, ex)
+}
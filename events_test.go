@@ -0,0 +1,201 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestParseEvents(t *testing.T) {
+	b, err := mockFS.ReadFile("mock/zpool-events.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := parseEvents(string(b))
+	if err != nil {
+		t.Fatalf("Error in parseEvents (%s)", err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("Incorrect number of events %d, should be 4", len(events))
+	}
+	if e := events[0]; e.ereport || e.class != "sysevent.fs.zfs.history_event" || e.pool != "tank" || e.eid != 28 {
+		t.Errorf("Incorrect history event %+v", e)
+	}
+	// The pool of the embedded detector nvlist is its GUID, which must not
+	// replace the name of the pool.
+	e := events[2]
+	if !e.ereport || e.class != "checksum" || e.pool != "backup" || e.eid != 30 || e.errorKind() != "checksum" {
+		t.Errorf("Incorrect checksum event %+v", e)
+	}
+	if e.guid != "15724425633244614632" || e.path != "/dev/disk/by-id/ata-ST4000VN008_ZGY1-part1" {
+		t.Errorf("Incorrect vdev of the checksum event %q %q", e.guid, e.path)
+	}
+	if want := time.Unix(0x65ed252e, 0x1069079); !e.timeReported || !e.time.Equal(want) {
+		t.Errorf("Incorrect time of the checksum event %s, should be %s", e.time, want)
+	}
+	if kind := events[1].errorKind(); kind != "" {
+		t.Errorf("A delay should not count as a %s error", kind)
+	}
+	if kind := (zpoolEvent{ereport: true, class: "io", zioType: 2}).errorKind(); kind != "write" {
+		t.Errorf("Incorrect kind of a write error %q", kind)
+	}
+
+	for _, output := range []string{
+		"        eid = 0x1\n",
+		"Mar 10 2024 03:12:46.017223801\tereport.fs.zfs.io\n        eid = 1x\n",
+		"Mar 10 2024 03:12:46.017223801\tereport.fs.zfs.io\n        time = 0x65ed252e\n",
+	} {
+		if _, err := parseEvents(output); err == nil {
+			t.Errorf("parseEvents(%q) should produce error", output)
+		}
+	}
+}
+
+func TestVdevMatches(t *testing.T) {
+	for _, c := range []struct {
+		path, device string
+		want         bool
+	}{
+		{"/dev/disk/by-id/ata-ST4000VN008_ZGY1-part1", "ata-ST4000VN008_ZGY1", true},
+		{"/dev/sda1", "sda", true},
+		{"/dev/nvme0n1p1", "nvme0n1", true},
+		{"/dev/sdb3", "sdb3", true},
+		{"/dev/sdb3", "/dev/sdb3", true},
+		{"/dev/disk/by-id/ata-ST4000VN008_ZGY1-part1", "ata-ST4000VN008_ZGY2", false},
+		{"/dev/sda11", "sda", false},
+	} {
+		if got := vdevMatches(c.path, c.device); got != c.want {
+			t.Errorf("vdevMatches(%q, %q) is %v, should be %v", c.path, c.device, got, c.want)
+		}
+	}
+}
+
+// eventsRunner answers zpool events with output.
+type eventsRunner struct {
+	mockRunner
+	output *string
+}
+
+func (r eventsRunner) run(name string, args ...string) (string, error) {
+	if name == "zpool" && len(args) > 0 && args[0] == "events" {
+		return *r.output, nil
+	}
+	return r.mockRunner.run(name, args...)
+}
+
+func TestEventsPoll(t *testing.T) {
+	event := func(eid, class string) string {
+		return "Mar 10 2024 03:12:46.017223801\tereport.fs.zfs." + class + "\n" +
+			"        pool = \"tank\"\n        vdev_guid = 0x2a\n        eid = " + eid + "\n\n"
+	}
+	output := event("1", "checksum") + event("2", "delay")
+	r := eventsRunner{output: &output}
+	c := newEventsCollector(time.Minute)
+	counts := func() map[string]float64 {
+		t.Helper()
+		c.poll(r)
+		if c.err != nil {
+			t.Fatalf("Error in poll (%s)", c.err)
+		}
+		ch := make(chan prometheus.Metric, 10)
+		c.collect(r, []zpool{{name: "tank"}}, ch)
+		close(ch)
+		got := map[string]float64{}
+		for m := range ch {
+			got[metricLabel(m, "class")] = metricValue(m)
+		}
+		return got
+	}
+	if got := counts(); got["checksum"] != 1 || got["delay"] != 1 {
+		t.Errorf("Incorrect ereport counts %v", got)
+	}
+	// Events already read, even once they are cleared with zpool events -c,
+	// are not counted again.
+	output = event("1", "checksum") + event("2", "delay") + event("3", "checksum")
+	if got := counts(); got["checksum"] != 2 || got["delay"] != 1 {
+		t.Errorf("Incorrect ereport counts after a new event %v", got)
+	}
+	output = event("4", "checksum")
+	if got := counts(); got["checksum"] != 3 {
+		t.Errorf("Incorrect ereport counts after zpool events -c %v", got)
+	}
+	if e, ok := c.lastError("tank", "sda", "42", "checksum"); !ok || e.eid != 4 {
+		t.Errorf("Incorrect last checksum error %+v", e)
+	}
+	// The IDs start over once the module is loaded again.
+	output = event("1", "io")
+	if got := counts(); got["io"] != 1 {
+		t.Errorf("Incorrect ereport counts after the IDs started over %v", got)
+	}
+
+	output = "        eid = 0x5\n"
+	c.poll(r)
+	if c.err == nil {
+		t.Errorf("Unparseable events should fail the collector")
+	}
+}
+
+// fixedCollector collects its metrics.
+type fixedCollector []prometheus.Metric
+
+func (c fixedCollector) Describe(ch chan<- *prometheus.Desc) { prometheus.DescribeByCollect(c, ch) }
+
+func (c fixedCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c {
+		ch <- m
+	}
+}
+
+func TestErrorExemplars(t *testing.T) {
+	e := newMockExporter(t)
+	backup := zpool{name: "backup", devices: []statusDevice{
+		{device: "ata-ST4000VN008_ZGY1", errors: deviceErrors{checksum: 1}, errorsKnown: true},
+		{device: "ata-ST4000VN008_ZGY2", errors: deviceErrors{checksum: 1}, errorsKnown: true},
+	}}
+	tracker := errorTracker{events: e.deviceErrors.events}
+	tracker.observe([]zpool{backup}, time.Now())
+	ch := make(chan prometheus.Metric, 20)
+	tracker.collect(ch)
+	close(ch)
+	var served fixedCollector
+	for m := range ch {
+		var d dto.Metric
+		m.Write(&d)
+		ex := d.GetCounter().GetExemplar()
+		switch device := metricLabel(m, "device"); {
+		case m.Desc() != deviceChecksumErrorsDesc:
+			if ex != nil {
+				t.Errorf("%s of %s should have no exemplar", descName(m.Desc()), device)
+			}
+		case device == "ata-ST4000VN008_ZGY2":
+			if ex != nil {
+				t.Errorf("The checksum errors of %s should have no exemplar, got %v", device, ex)
+			}
+		case ex == nil:
+			t.Errorf("The checksum errors of %s should have the exemplar of the last checksum ereport", device)
+		case len(ex.GetLabel()) != 1 || ex.GetLabel()[0].GetValue() != "30" || !ex.GetTimestamp().AsTime().Equal(time.Unix(0x65ed252e, 0x1069079)):
+			t.Errorf("Incorrect exemplar %v", ex)
+		default:
+			served = append(served, m)
+		}
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(served)
+
+	// The exemplar is only served with OpenMetrics.
+	for _, accept := range []string{"text/plain", "application/openmetrics-text; version=1.0.0"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		r.Header.Set("Accept", accept)
+		metricsHandler(reg, reg, nil).ServeHTTP(w, r)
+		want := strings.HasPrefix(accept, "application/openmetrics-text")
+		if got := strings.Contains(w.Body.String(), `# {eid="30"} 1.0 1.710040366017`); got != want {
+			t.Errorf("The %s output should have the exemplar: %v, got\n%s", accept, want, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// metricFilter selects the metrics to export by name, from --metric-include
+// and --metric-exclude. The names are those exported, the version 2 names
+// with -metrics.version=2. A nil filter exports everything; exclude takes
+// precedence over include.
+type metricFilter struct {
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+	version int
+}
+
+// newMetricFilter compiles the include and exclude expressions, anchored to
+// match the whole name like those of --dataset-include. It returns nil when
+// both are empty.
+func newMetricFilter(include, exclude string, version int) (f *metricFilter, err error) {
+	if include == "" && exclude == "" {
+		return nil, nil
+	}
+	f = &metricFilter{version: version}
+	if include != "" {
+		if f.include, err = regexp.Compile("^(?:" + include + ")$"); err != nil {
+			return nil, fmt.Errorf("-metric-include: %s", err)
+		}
+	}
+	if exclude != "" {
+		if f.exclude, err = regexp.Compile("^(?:" + exclude + ")$"); err != nil {
+			return nil, fmt.Errorf("-metric-exclude: %s", err)
+		}
+	}
+	return f, nil
+}
+
+// allows reports whether the metric exported as name is exported.
+func (f *metricFilter) allows(name string) bool {
+	if f == nil {
+		return true
+	}
+	if f.exclude != nil && f.exclude.MatchString(name) {
+		return false
+	}
+	return f.include == nil || f.include.MatchString(name)
+}
+
+// exportedName returns the name the collectors' metric name is exported as.
+func (f *metricFilter) exportedName(name string) string {
+	if rename, ok := metricRenameIndex[name]; ok && f.version == 2 {
+		return rename.v2
+	}
+	return name
+}
+
+// allowsAny reports whether any of descs is exported.
+func (f *metricFilter) allowsAny(descs ...*prometheus.Desc) bool {
+	if f == nil {
+		return true
+	}
+	for _, d := range descs {
+		if f.allows(f.exportedName(descName(d))) {
+			return true
+		}
+	}
+	return false
+}
+
+// split sorts the exported names of descs into those the filter exports and
+// those it does not.
+func (f *metricFilter) split(descs []*prometheus.Desc) (included, excluded []string) {
+	seen := map[string]bool{}
+	for _, d := range descs {
+		name := f.exportedName(descName(d))
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if f.allows(name) {
+			included = append(included, name)
+		} else {
+			excluded = append(excluded, name)
+		}
+	}
+	sort.Strings(included)
+	sort.Strings(excluded)
+	return included, excluded
+}
+
+// describeAll returns the descriptors describe sends.
+func describeAll(describe func(ch chan<- *prometheus.Desc)) []*prometheus.Desc {
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		describe(ch)
+		close(ch)
+	}()
+	var descs []*prometheus.Desc
+	for d := range ch {
+		descs = append(descs, d)
+	}
+	return descs
+}
+
+// descName extracts the fully-qualified metric name from a descriptor.
+func descName(d *prometheus.Desc) string {
+	s := d.String()
+	s = s[strings.Index(s, "fqName: \"")+len("fqName: \""):]
+	return s[:strings.Index(s, "\"")]
+}
+
+// filteringGatherer drops the families the filter does not export from
+// what the wrapped Gatherer returns, which covers the metrics that are not
+// the exporter's own, such as those of the Go runtime. It wraps the
+// renamingGatherer with -metrics.version=2, so it sees the exported names.
+type filteringGatherer struct {
+	prometheus.Gatherer
+	filter *metricFilter
+}
+
+func (g filteringGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	kept := families[:0]
+	for _, family := range families {
+		if g.filter.allows(family.GetName()) {
+			kept = append(kept, family)
+		}
+	}
+	return kept, err
+}
+
+// logFilter logs which of the metrics of the exporter e.filter exports.
+func (e *Exporter) logFilter() {
+	if e.filter == nil {
+		return
+	}
+	included, excluded := e.filter.split(describeAll(e.Describe))
+	if len(excluded) == 0 {
+		excluded = []string{"none"}
+	}
+	log.Printf("Exporting %d metrics, excluded by -metric-include and -metric-exclude: %s", len(included), strings.Join(excluded, ", "))
+	debugf("Metrics exported: %s", strings.Join(included, ", "))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetricFilter(t *testing.T) {
+	f, err := newMetricFilter("zpool_.*|zfs_pool_.*", "zpool_iostat_.*", 1)
+	if err != nil {
+		t.Fatalf("Error in newMetricFilter (%s)", err)
+	}
+	for name, want := range map[string]bool{
+		"zpool_up":                         true,
+		"zfs_pool_datasets":                true,
+		"zpool_iostat_read_ops_per_second": false,
+		"zfs_arc_size_bytes":               false,
+		"xzpool_up":                        false,
+	} {
+		if got := f.allows(name); got != want {
+			t.Errorf("Incorrect allows(%s) (%t), should be %t", name, got, want)
+		}
+	}
+	if f, err := newMetricFilter("", "", 1); f != nil || err != nil || !f.allows("zpool_up") {
+		t.Errorf("No expressions should give a nil filter allowing everything, got %v, %v", f, err)
+	}
+	if _, err := newMetricFilter("(", "", 1); err == nil || !strings.Contains(err.Error(), "-metric-include") {
+		t.Errorf("Invalid include expression should produce error, got %v", err)
+	}
+	if _, err := newMetricFilter("", "[", 1); err == nil || !strings.Contains(err.Error(), "-metric-exclude") {
+		t.Errorf("Invalid exclude expression should produce error, got %v", err)
+	}
+
+	// The filter sees the names as they are exported.
+	v1, _ := newMetricFilter("", "zfs_pool_datasets", 1)
+	v2, _ := newMetricFilter("", "zfs_pool_datasets", 2)
+	desc := prometheus.NewDesc("zfs_pool_dataset_count", "h", nil, nil)
+	if !v1.allowsAny(desc) || v2.allowsAny(desc) {
+		t.Errorf("zfs_pool_dataset_count should only be excluded as zfs_pool_datasets with -metrics.version=2")
+	}
+	included, excluded := v2.split([]*prometheus.Desc{desc, zpoolUpDesc, zpoolUpDesc})
+	if strings.Join(included, ",") != "zfs_pool_up" || strings.Join(excluded, ",") != "zfs_pool_datasets" {
+		t.Errorf("Incorrect split %v %v", included, excluded)
+	}
+}
+
+func TestFilteredCollectorsDoNotRun(t *testing.T) {
+	f, _ := newMetricFilter("", "zpool_iostat_.*|zfs_version_.*|zpool_capacity_percentage", 1)
+	r := &callRecorder{commandRunner: fixtureRunner{}}
+	e := NewExporter(&[]zpool{{name: "tank"}})
+	e.runner = r
+	e.available = true
+	e.filter = f
+	if e.addCollector("iostat", &iostatCollector{interval: 1}) {
+		t.Errorf("A collector whose metrics are all excluded should not be added")
+	}
+	if !e.addCollector("pool-counts", poolCountCollector{}) {
+		t.Errorf("A collector with included metrics should be added")
+	}
+	reg := prometheus.NewRegistry()
+	if err := e.Register(reg); err != nil {
+		t.Fatalf("Error in Register (%s)", err)
+	}
+	families, err := filteringGatherer{reg, f}.Gather()
+	if err != nil {
+		t.Fatalf("Error in Gather (%s)", err)
+	}
+	got := map[string]bool{}
+	for _, family := range families {
+		got[family.GetName()] = true
+	}
+	if !got["zpool_up"] || !got["zpool_capacity_ratio"] || got["zpool_capacity_percentage"] {
+		t.Errorf("Incorrect families %v", got)
+	}
+	for _, call := range r.calls {
+		if strings.HasPrefix(call, "zpool iostat") || call == "zfs version" {
+			t.Errorf("Excluded metrics should not run %s", call)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+// envPrefix starts the environment variables that set flags, such as
+// PROMETHEUS_ZFS_POOL for --pool.
+const envPrefix = "PROMETHEUS_ZFS_"
+
+// noEnvFlags cannot be set from the environment: aliases, which would race
+// with the flag they stand for, one-off actions such as --check-config, and --mock, which should
+// never be enabled by accident.
+var noEnvFlags = map[string]bool{
+	"check-config":      true,
+	"collect-datasets":  true,
+	"collect-snapshots": true,
+	"help":              true,
+	"mock":              true,
+	"version":           true,
+}
+
+// envName returns the environment variable for the flag name.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(name))
+}
+
+// parseFlags parses the command line into fs, then sets the flags that were
+// not given from the environment through lookup. A flag on the command line
+// takes precedence over its environment variable, which takes precedence
+// over the default.
+func parseFlags(fs *flag.FlagSet, args []string, lookup func(string) (string, bool)) error {
+	if err := fs.Parse(normalizeArgs(fs, args)); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return fmt.Errorf("%s%s, see --help for the flags", err, suggestFlag(fs, err))
+	}
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || f.Changed || noEnvFlags[f.Name] {
+			return
+		}
+		if value, ok := lookup(envName(f.Name)); ok {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid %s %q: %s", envName(f.Name), value, setErr)
+			}
+		}
+	})
+	return err
+}
+
+// normalizeArgs turns the single-dash long flags of earlier releases, such
+// as -pool tank or -collector.dataset, into their double-dash form. Single
+// dash arguments that are not the name of a flag, such as -p tank, are left
+// to be parsed as shorthands.
+func normalizeArgs(fs *flag.FlagSet, args []string) []string {
+	normalized := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			return append(normalized, args[i:]...)
+		}
+		if len(arg) > 2 && arg[0] == '-' && arg[1] != '-' {
+			name, _, _ := strings.Cut(arg[1:], "=")
+			if len(name) > 1 && fs.Lookup(name) != nil {
+				arg = "-" + arg
+			}
+		}
+		normalized = append(normalized, arg)
+	}
+	return normalized
+}
+
+// suggestFlag returns a hint naming the flag closest to the unknown one in
+// err, if any is close enough to be a typo.
+func suggestFlag(fs *flag.FlagSet, err error) string {
+	msg := err.Error()
+	if !strings.HasPrefix(msg, "unknown flag: --") {
+		return ""
+	}
+	unknown := strings.TrimPrefix(msg, "unknown flag: --")
+	best, bestDistance := "", 3
+	fs.VisitAll(func(f *flag.Flag) {
+		if d := editDistance(unknown, f.Name); d < bestDistance {
+			best, bestDistance = f.Name, d
+		}
+	})
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean --%s?)", best)
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFlags(t *testing.T) {
+	env := map[string]string{
+		"PROMETHEUS_ZFS_POOL":                    "backup",
+		"PROMETHEUS_ZFS_COLLECTOR_DATASET":       "true",
+		"PROMETHEUS_ZFS_HEALTHY_STATUS_INTERVAL": "5m",
+		"PROMETHEUS_ZFS_MOCK":                    "true",
+		"PROMETHEUS_ZFS_LABEL":                   "cluster=eu1",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	// The command line takes precedence over the environment, which
+	// takes precedence over the defaults
+	fs := newFlagSet()
+	if err := parseFlags(fs, []string{"--pool=tank", "-collector.arc", "--endpoint", "zfs"}, lookup); err != nil {
+		t.Fatalf("Error in parseFlags (%s)", err)
+	}
+	if len(zfsPool) != 1 || zfsPool[0] != "tank" || !arcCheck || metricsHandle != "zfs" {
+		t.Errorf("Command line flags should be set, got pools %q, arc %v, endpoint %q", zfsPool, arcCheck, metricsHandle)
+	}
+	if !datasetsCheck || healthyInterval != 5*time.Minute || len(staticLabels) != 1 {
+		t.Errorf("Environment should set the flags not given, got datasets %v, interval %s, labels %v", datasetsCheck, healthyInterval, staticLabels)
+	}
+	if mockCheck {
+		t.Errorf("-mock should not be set from the environment")
+	}
+	if listenPort != "8080" || iostatInterval != 1 {
+		t.Errorf("Flags given nowhere should keep their defaults, got port %q, interval %d", listenPort, iostatInterval)
+	}
+
+	// A new FlagSet starts over from the defaults
+	fs = newFlagSet()
+	if err := parseFlags(fs, []string{"-p", "scratch", "-keep-running=false"}, func(string) (string, bool) { return "", false }); err != nil {
+		t.Fatalf("Error in parseFlags (%s)", err)
+	}
+	if len(zfsPool) != 1 || zfsPool[0] != "scratch" || arcCheck || datasetsCheck || len(staticLabels) != 0 {
+		t.Errorf("Flags should be reset, got pools %q, arc %v, datasets %v, labels %v", zfsPool, arcCheck, datasetsCheck, staticLabels)
+	}
+
+	for _, args := range [][]string{{"--pool-x", "tank"}, {"--port"}, {"-collector.kmem.top-caches", "many"}} {
+		if err := parseFlags(newFlagSet(), args, lookup); err == nil {
+			t.Errorf("%q should produce error in parseFlags", args)
+		}
+	}
+	if err := parseFlags(newFlagSet(), []string{"--colector.arc"}, lookup); err == nil || !strings.Contains(err.Error(), "did you mean --collector.arc?") {
+		t.Errorf("Misspelled flag should suggest --collector.arc, got %v", err)
+	}
+	env["PROMETHEUS_ZFS_DATASET_MAX_DEPTH"] = "deep"
+	if err := parseFlags(newFlagSet(), nil, lookup); err == nil || !strings.Contains(err.Error(), "PROMETHEUS_ZFS_DATASET_MAX_DEPTH") {
+		t.Errorf("Invalid environment variable should produce error naming it, got %v", err)
+	}
+}
+
+func TestRepeatedPools(t *testing.T) {
+	noEnv := func(string) (string, bool) { return "", false }
+	if err := parseFlags(newFlagSet(), nil, noEnv); err != nil {
+		t.Fatalf("Error in parseFlags (%s)", err)
+	}
+	if pools := parsePools(zfsPool...); len(pools) != 1 || pools[0].name != "tank" {
+		t.Errorf("Incorrect default pools %+v, should be tank", pools)
+	}
+	if err := parseFlags(newFlagSet(), []string{"--pool", "tank", "-p", "backup,scratch", "-pool=tank"}, noEnv); err != nil {
+		t.Fatalf("Error in parseFlags (%s)", err)
+	}
+	pools := parsePools(zfsPool...)
+	if len(pools) != 3 || pools[0].name != "tank" || pools[1].name != "backup" || pools[2].name != "scratch" {
+		t.Errorf("Incorrect pools %+v, should be tank, backup and scratch", pools)
+	}
+}
+
+func TestNormalizeArgs(t *testing.T) {
+	args := normalizeArgs(newFlagSet(), []string{"-pool", "tank", "-p", "backup", "-port=9090", "-x", "--debug", "--", "-version"})
+	want := []string{"--pool", "tank", "-p", "backup", "--port=9090", "-x", "--debug", "--", "-version"}
+	if strings.Join(args, " ") != strings.Join(want, " ") {
+		t.Errorf("Incorrect normalized args %q, should be %q", args, want)
+	}
+}
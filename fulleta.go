@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	zpoolUsableGrowthDesc = prometheus.NewDesc("zpool_usable_growth_bytes_per_second",
+		"Estimated growth of the space used in the root dataset of the zpool, averaged over the collections with weights halving every --full-eta.window; absent until the collections span half of it", []string{"name"}, nil)
+	zpoolFullETADesc = prometheus.NewDesc("zpool_full_eta_seconds",
+		"Estimated time until the root dataset of the zpool runs out of space at zpool_usable_growth_bytes_per_second; absent when that is absent or the used space is not growing", []string{"name"}, nil)
+)
+
+// growthTrend is the used space of a pool seen by the collections.
+type growthTrend struct {
+	used, available uint64 // of the root dataset, as last seen
+	first, last     time.Time
+	// growth and weight are the sums of the growth of the used space and
+	// of the time between the collections, decayed by their age, whose
+	// ratio is the growth rate.
+	growth, weight float64
+}
+
+// etaTracker estimates when the pools fill up from the growth of their used
+// space across collections. Unlike predict_linear, it weighs the recent
+// collections more, so that the estimate follows a change of workload
+// within hours, and it does not need a range query. The space is that of
+// the root dataset, as in zpool_usable_capacity_ratio, so pools whose root
+// dataset space is unknown have no estimate. Like errorTracker, it is not
+// safe for concurrent use.
+type etaTracker struct {
+	// window is the time after which a collection counts half as much.
+	window time.Duration
+	pools  map[string]*growthTrend
+}
+
+// observe records the used space of the collected pools at now. Pools that
+// failed keep their trend, and pools no longer monitored are forgotten.
+func (t *etaTracker) observe(pools []zpool, now time.Time) {
+	if t.pools == nil {
+		t.pools = map[string]*growthTrend{}
+	}
+	monitored := map[string]bool{}
+	for _, pool := range pools {
+		monitored[pool.name] = true
+		if pool.err != nil || !pool.rootSpace {
+			continue
+		}
+		g, ok := t.pools[pool.name]
+		if !ok {
+			t.pools[pool.name] = &growthTrend{used: pool.rootUsed, available: pool.rootAvailable, first: now, last: now}
+			continue
+		}
+		elapsed := now.Sub(g.last).Seconds()
+		if elapsed <= 0 {
+			continue
+		}
+		decay := math.Exp2(-elapsed / t.window.Seconds())
+		g.growth = g.growth*decay + float64(pool.rootUsed) - float64(g.used)
+		g.weight = g.weight*decay + elapsed
+		g.used, g.available, g.last = pool.rootUsed, pool.rootAvailable, now
+	}
+	for name := range t.pools {
+		if !monitored[name] {
+			delete(t.pools, name)
+		}
+	}
+}
+
+// rate returns the growth rate of g in bytes per second, and false until
+// the collections span half of window.
+func (t *etaTracker) rate(g *growthTrend) (float64, bool) {
+	if g.weight == 0 || g.last.Sub(g.first) < t.window/2 {
+		return 0, false
+	}
+	return g.growth / g.weight, true
+}
+
+func (t *etaTracker) describe(ch chan<- *prometheus.Desc) {
+	ch <- zpoolUsableGrowthDesc
+	ch <- zpoolFullETADesc
+}
+
+func (t *etaTracker) collect(ch chan<- prometheus.Metric) {
+	for name, g := range t.pools {
+		rate, ok := t.rate(g)
+		emitIfKnown(ch, zpoolUsableGrowthDesc, rate, ok, name)
+		emitIfKnown(ch, zpoolFullETADesc, float64(g.available)/rate, ok && rate > 0, name)
+	}
+}
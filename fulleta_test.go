@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectETA returns the growth rate and the time until full t exports for
+// each pool, NaN where absent.
+func collectETA(t *etaTracker) (rates, etas map[string]float64) {
+	rates, etas = map[string]float64{}, map[string]float64{}
+	ch := make(chan prometheus.Metric, 100)
+	t.collect(ch)
+	close(ch)
+	for m := range ch {
+		switch m.Desc() {
+		case zpoolUsableGrowthDesc:
+			rates[metricLabel(m, "name")] = metricValue(m)
+		case zpoolFullETADesc:
+			etas[metricLabel(m, "name")] = metricValue(m)
+		}
+	}
+	return rates, etas
+}
+
+func TestETATracker(t *testing.T) {
+	const gib = 1 << 30
+	tracker := &etaTracker{window: 6 * time.Hour}
+	start := time.Unix(1700000000, 0)
+	pool := func(name string, used uint64) zpool {
+		return zpool{name: name, rootUsed: used, rootAvailable: 1000*gib - used, rootSpace: true}
+	}
+	// tank grows by 1 GiB an hour, backup shrinks, and the root dataset
+	// space of status is unknown.
+	for hour := 0; hour <= 2; hour++ {
+		tracker.observe([]zpool{pool("tank", uint64(100+hour)*gib), pool("backup", uint64(500-hour)*gib), {name: "status"}},
+			start.Add(time.Duration(hour)*time.Hour))
+	}
+	if rates, etas := collectETA(tracker); len(rates) != 0 || len(etas) != 0 {
+		t.Errorf("There should be no estimate before half of the window, got %v, %v", rates, etas)
+	}
+	for hour := 3; hour <= 6; hour++ {
+		tracker.observe([]zpool{pool("tank", uint64(100+hour)*gib), pool("backup", uint64(500-hour)*gib), {name: "status"}},
+			start.Add(time.Duration(hour)*time.Hour))
+	}
+	rates, etas := collectETA(tracker)
+	if want := float64(gib) / 3600; math.Abs(rates["tank"]-want) > 1 {
+		t.Errorf("Incorrect growth rate of tank (%v), should be %v", rates["tank"], want)
+	}
+	if want := float64(894 * 3600); math.Abs(etas["tank"]-want) > 1 {
+		t.Errorf("Incorrect time until tank is full (%v), should be %v", etas["tank"], want)
+	}
+	if _, ok := etas["backup"]; ok || rates["backup"] >= 0 {
+		t.Errorf("A shrinking pool should have a negative rate and no time until full, got %v, %v", rates, etas)
+	}
+	if _, ok := rates["status"]; ok {
+		t.Errorf("A pool without root dataset space should have no estimate")
+	}
+
+	// A failed collection keeps the trend, and the recent growth weighs
+	// more than the old one.
+	failed := pool("tank", 0)
+	failed.err = errors.New("zpool status failed")
+	tracker.observe([]zpool{failed, pool("backup", 494*gib)}, start.Add(7*time.Hour))
+	tracker.observe([]zpool{pool("tank", 116*gib), pool("backup", 494*gib)}, start.Add(8*time.Hour))
+	rates, _ = collectETA(tracker)
+	// 6 hours at 1 GiB and 2 at 5 GiB an hour average 1.75 GiB an hour.
+	if average, fast := 1.75*gib/3600, 5.0*gib/3600; rates["tank"] <= average || rates["tank"] >= fast {
+		t.Errorf("Incorrect growth rate of tank after faster hours (%v), should be between %v and %v", rates["tank"], average, fast)
+	}
+
+	// Pools no longer monitored are forgotten.
+	tracker.observe([]zpool{pool("tank", 117*gib)}, start.Add(9*time.Hour))
+	if rates, _ := collectETA(tracker); len(rates) != 1 {
+		t.Errorf("Only tank should be left, got %v", rates)
+	}
+}
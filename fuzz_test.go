@@ -0,0 +1,149 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// addSeeds adds the fixtures matching pattern, relative to the repository,
+// to the seed corpus of f.
+func addSeeds(f *testing.F, pattern string) {
+	f.Helper()
+	files, err := filepath.Glob(pattern)
+	if err != nil || len(files) == 0 {
+		f.Fatalf("No fixtures match %s (%v)", pattern, err)
+	}
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(b))
+	}
+}
+
+// checkCount fails t unless v is a valid count, rate or byte count: finite and
+// not negative, except for the -1 of values that are not shown when absent is
+// set.
+func checkCount(t *testing.T, name string, v float64, absent bool) {
+	t.Helper()
+	if math.IsNaN(v) || math.IsInf(v, 0) || v < 0 && !(absent && v == -1) {
+		t.Errorf("Invalid %s %v", name, v)
+	}
+}
+
+func FuzzZpoolStatus(f *testing.F) {
+	addSeeds(f, "testdata/zpool-status-*.txt")
+	addSeeds(f, "mock/zpool-status-*.txt")
+	// Regressions: NaN and infinite sizes and percentages were accepted.
+	f.Add("  pool: tank\n state: ONLINE\nremove: Evacuation of /dev/sdb in progress since Thu Oct 10 09:12:30 2024\n" +
+		"\tNaNG copied out of InfG at 140M/s, 63.72% done, 0h0m to go\nconfig:\n\n" +
+		"\tNAME STATE READ WRITE CKSUM\n\ttank ONLINE 0 0 0\n\t  sda ONLINE 0 0 0 (NaN% initialized, started at Tue Jun  2 10:00:00 2020)\n")
+	f.Fuzz(func(t *testing.T, output string) {
+		z := zpool{name: "tank"}
+		opts := poolOptions{dedup: true, activities: true, slowIOs: true}
+		if err := z.setStatus(output, opts); err != nil {
+			return
+		}
+		if z.online < 0 || z.faulted < 0 || z.indirect < 0 {
+			t.Errorf("Negative provider counts online %d, faulted %d, indirect %d", z.online, z.faulted, z.indirect)
+		}
+		for _, v := range []float64{z.scan.scanned, z.scan.issued, z.scan.total, z.scan.rate} {
+			checkCount(t, "scan progress", v, true)
+		}
+		checkCount(t, "removal copied", z.removal.copied, true)
+		checkCount(t, "removal total", z.removal.total, true)
+		for name, v := range z.activities.percentDone {
+			if !(v >= 0 && v <= 100) {
+				t.Errorf("Invalid %s progress %v%%", name, v)
+			}
+		}
+		for _, d := range z.slowIOs {
+			checkCount(t, "slow I/Os of "+d.device, d.slow, false)
+		}
+		parseStatusX(strings.NewReader(output), []string{"tank", "backup"}, opts)
+	})
+}
+
+func FuzzZpoolList(f *testing.F) {
+	f.Add("tank\t11988103774208\t6118856933376\t5869246840832\t51\t12\tONLINE\toff\t-\t-\n")
+	f.Add("tank\t11988103774208\t6118856933376\t5869246840832\t51%\t-\tDEGRADED\ton\t/mnt\tnone\n")
+	f.Add("cannot open 'tank': no such pool\n")
+	// Regressions: out of range numbers were accepted.
+	f.Add("tank\t1\t1\t1\t-5\t12\tONLINE\toff\t-\t-\n")
+	f.Add("tank\t1\t1\t1\t120\t12\tONLINE\toff\t-\t-\n")
+	f.Add("tank\t1\t1\t1\t51\t-3%\tONLINE\toff\t-\t-\n")
+	f.Fuzz(func(t *testing.T, output string) {
+		pools := []zpool{{name: "tank"}}
+		parseZpoolList(output, pools)
+		if z := pools[0]; z.err == nil {
+			if z.capacity < 0 || z.capacity > 100 {
+				t.Errorf("Capacity %d%% outside 0-100", z.capacity)
+			}
+			if z.fragmentation < -1 {
+				t.Errorf("Invalid fragmentation %d", z.fragmentation)
+			}
+		}
+	})
+}
+
+func FuzzVdevList(f *testing.F) {
+	addSeeds(f, "mock/zpool-list-v.txt")
+	f.Add("tank\t10\t20\t-\t-\t-\t-\t-\t-\tONLINE\t-\n\tmirror-0\t10\t20\t-\t-\t-\t-3\t-\t-\tONLINE\n")
+	f.Fuzz(func(t *testing.T, output string) {
+		vdevs, err := parseVdevList(output)
+		if err != nil {
+			return
+		}
+		for pool, list := range vdevs {
+			for _, v := range list {
+				if r := v.capacityRatio(); !(r >= 0) {
+					t.Errorf("Invalid capacity ratio %v of %s in %s", r, v.name, pool)
+				}
+				if v.fragmentation < -1 {
+					t.Errorf("Invalid fragmentation %d of %s in %s", v.fragmentation, v.name, pool)
+				}
+			}
+		}
+	})
+}
+
+func FuzzIostat(f *testing.F) {
+	addSeeds(f, "mock/zpool-iostat-v.txt")
+	f.Add(strings.Join([]string{"tank", "3929468416", "6737952768", "112", "48", "4251625", "1265451"}, "\t") + "\n")
+	f.Add("tank\t1\t1\t-1\tNaN\tInf\t1\n")
+	f.Fuzz(func(t *testing.T, output string) {
+		if rates, err := parseIostat(output); err == nil {
+			for pool, values := range rates {
+				for _, v := range values {
+					checkCount(t, "rate of "+pool, v, false)
+				}
+			}
+		}
+		rates, devices, err := parseIostatVerbose(output, []string{"tank", "backup"})
+		if err != nil {
+			return
+		}
+		for pool, values := range rates {
+			for _, v := range values {
+				checkCount(t, "rate of "+pool, v, false)
+			}
+		}
+		for pool, list := range devices {
+			if _, ok := rates[pool]; !ok {
+				t.Errorf("Devices of %s without pool rates", pool)
+			}
+			for _, d := range list {
+				if len(d.values) != len(iostatDeviceMetrics) {
+					t.Errorf("Device %s has %d rates", d.device, len(d.values))
+				}
+				for _, v := range d.values {
+					checkCount(t, "rate of "+d.device, v, false)
+				}
+			}
+		}
+	})
+}
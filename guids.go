@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// getGUIDs sets the guid of the devices of the pool from zpool status -g,
+// which prints the config section with the GUIDs of the vdevs in place of
+// their names. Device names change when controllers are renumbered or
+// by-id links are renamed, while the GUIDs stay, so the guid label keeps
+// the series of a device together across a rename. config is the config
+// section of the zpool status just parsed, with the names.
+//
+// A failure only leaves the guid labels empty: it is logged and noted in
+// the warnings of the pool, which is collected anyway.
+func (z *zpool) getGUIDs(r commandRunner, config []*statusVdev) {
+	fields := logFields{"POOL": z.name}
+	output, err := guidsCommand(z.name).run(r)
+	var guids map[string]string
+	if err != nil {
+		// Releases without -g print their usage, of which the first line
+		// says what is wrong.
+		if msg := strings.TrimSpace(output); msg != "" {
+			err = fmt.Errorf("%s: %s", err, strings.SplitN(msg, "\n", 2)[0])
+		}
+	} else {
+		guids, err = correlateGUIDs(config, parseStatusConfig(output))
+	}
+	if err != nil {
+		z.warnings = append(z.warnings, "zpool status -g: "+err.Error())
+		logProblem("guids "+z.name, fields, "Error collecting the device GUIDs of %s with zpool status -g: %s", z.name, err)
+		return
+	}
+	logResolved("guids "+z.name, fields, "Collecting the device GUIDs of %s again", z.name)
+	for i := range z.devices {
+		z.devices[i].guid = guids[z.devices[i].device]
+	}
+	for i := range z.slowIOs {
+		z.slowIOs[i].guid = guids[z.slowIOs[i].device]
+	}
+}
+
+// guidsCommand is the zpool status -g of pool.
+func guidsCommand(pool string) command {
+	return command{"zpool", []string{"status", "-g", pool}}
+}
+
+// correlateGUIDs maps the names of the vdevs in named, the config section of
+// zpool status as returned by parseStatusConfig, to their GUIDs in guids,
+// that of zpool status -g. Both print the same tree in the same order, so
+// the entries are matched by their position in it. The pool and the class
+// headings at the top level keep their names in both. The trees differ when
+// the pool changed between the two commands, such as by a device being
+// attached, and nothing is mapped then.
+func correlateGUIDs(named, guids []*statusVdev) (map[string]string, error) {
+	result := map[string]string{}
+	if len(named) != len(guids) {
+		return nil, fmt.Errorf("%d top-level entries where zpool status has %d", len(guids), len(named))
+	}
+	for i, v := range named {
+		if guids[i].name != v.name {
+			return nil, fmt.Errorf("%s where zpool status has %s", guids[i].name, v.name)
+		}
+		if err := correlateVdevs(v.children, guids[i].children, result); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// correlateVdevs adds the GUIDs of the vdevs in named and below to result.
+func correlateVdevs(named, guids []*statusVdev, result map[string]string) error {
+	if len(named) != len(guids) {
+		return fmt.Errorf("%d vdevs where zpool status has %d", len(guids), len(named))
+	}
+	for i, v := range named {
+		guid := guids[i].name
+		if _, err := strconv.ParseUint(guid, 10, 64); err != nil {
+			return fmt.Errorf("%s where zpool status has %s is not a GUID", guid, v.name)
+		}
+		result[v.name] = guid
+		if err := correlateVdevs(v.children, guids[i].children, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
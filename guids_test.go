@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCorrelateGUIDs(t *testing.T) {
+	for _, fixture := range []string{"mock/zpool-status-tank.txt", "testdata/zpool-status-spare.txt", "testdata/zpool-status-replacing.txt"} {
+		b, err := os.ReadFile(fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		named := parseStatusConfig(string(b))
+		pool := named[0].name
+		guids, err := correlateGUIDs(named, parseStatusConfig(mockGUIDs(string(b), pool)))
+		if err != nil {
+			t.Fatalf("Error in correlateGUIDs of %s (%s)", fixture, err)
+		}
+		devices := leafDevices(named)
+		for _, d := range devices {
+			if guids[d.device] == "" {
+				t.Errorf("%s: device %s should have a GUID, got %v", fixture, d.device, guids)
+			}
+		}
+		if _, ok := guids[pool]; ok {
+			t.Errorf("%s: the pool should keep its name", fixture)
+		}
+
+		// A device attached between the two commands
+		lines := strings.Split(mockGUIDs(string(b), pool), "\n")
+		for i, line := range lines {
+			if strings.HasPrefix(strings.TrimSpace(line), pool+" ") {
+				lines = append(lines[:i+2], append([]string{"\t    1234567    ONLINE  0 0 0"}, lines[i+2:]...)...)
+				break
+			}
+		}
+		if _, err := correlateGUIDs(named, parseStatusConfig(strings.Join(lines, "\n"))); err == nil {
+			t.Errorf("%s: trees that differ should not be correlated", fixture)
+		}
+	}
+	if _, err := correlateGUIDs(parseStatusConfig(mockStatus(t, "backup")), parseStatusConfig(mockStatus(t, "backup"))); err == nil {
+		t.Errorf("Names should not be taken for GUIDs")
+	}
+}
+
+// mockStatus returns the zpool status of pool in mock mode.
+func mockStatus(t *testing.T, pool string) string {
+	t.Helper()
+	output, err := mockZpoolStatus([]string{pool})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return output
+}
+
+func TestDeviceGUIDLabels(t *testing.T) {
+	e := newMockExporter(t)
+	e.pool.guids = true
+	guids := map[string]string{}
+	for _, m := range e.snapshot(nil) {
+		switch descName(m.Desc()) {
+		case "zpool_device_resilvering", "zpool_device_slow_ios_total", "zpool_device_checksum_errors_observed_total":
+			key := descName(m.Desc()) + " " + metricLabel(m, "device")
+			if guids[key] = metricLabel(m, "guid"); guids[key] == "" {
+				t.Errorf("%s should have a guid label", key)
+			}
+		}
+	}
+	if len(guids) == 0 {
+		t.Fatal("Should export per-device metrics")
+	}
+	for key, guid := range guids {
+		device := key[strings.LastIndexByte(key, ' ')+1:]
+		if want := guids["zpool_device_resilvering "+device]; guid != want {
+			t.Errorf("%s should have the guid %s of the device, got %s", key, want, guid)
+		}
+	}
+
+	// Without zpool status -g the pools are collected with empty labels.
+	e.runner = noGUIDsRunner{e.runner}
+	up := 0
+	for _, m := range e.snapshot(nil) {
+		switch descName(m.Desc()) {
+		case "zpool_up":
+			if metricValue(m) != 1 {
+				t.Errorf("A failing zpool status -g should not fail %s", metricLabel(m, "name"))
+			}
+			up++
+		case "zpool_device_resilvering":
+			if guid := metricLabel(m, "guid"); guid != "" {
+				t.Errorf("A failing zpool status -g should leave the guid empty, got %s", guid)
+			}
+		}
+	}
+	if up != 2 {
+		t.Errorf("Both pools should be exported without zpool status -g, got %d", up)
+	}
+}
+
+// noGUIDsRunner fails zpool status -g as releases without it do, and runs
+// everything else with commandRunner.
+type noGUIDsRunner struct {
+	commandRunner
+}
+
+func (r noGUIDsRunner) run(name string, args ...string) (string, error) {
+	if name == "zpool" && len(args) > 1 && args[0] == "status" && args[1] == "-g" {
+		return "invalid option 'g'\nusage:\n", errors.New("exit status 2")
+	}
+	return r.commandRunner.run(name, args...)
+}
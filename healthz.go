@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// poolsHealthPath serves the health of the monitored pools, unlike /healthz
+// which only tells that the exporter is alive.
+const poolsHealthPath = "/healthz/pools"
+
+// poolProblem is a monitored pool that makes poolsHealthPath unhealthy:
+// one that is not ONLINE, or whose collection failed.
+type poolProblem struct {
+	Pool   string `json:"pool"`
+	Health string `json:"health,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// poolsHealth is the body of poolsHealthPath.
+type poolsHealth struct {
+	Status string        `json:"status"` // "ok", "unhealthy" or "unknown"
+	Pools  []poolProblem `json:"pools"`
+}
+
+// poolProblems returns the problems of pools after a collection.
+func poolProblems(pools []zpool) []poolProblem {
+	problems := []poolProblem{}
+	for _, pool := range pools {
+		switch {
+		case pool.err != nil:
+			problems = append(problems, poolProblem{Pool: pool.name, Error: pool.err.Error()})
+		case pool.health != "ONLINE":
+			problems = append(problems, poolProblem{Pool: pool.name, Health: pool.health})
+		}
+	}
+	return problems
+}
+
+// failedProblems returns the problems of pools when collecting all of them
+// failed with err, such as when zpool failed.
+func failedProblems(pools []zpool, err error) []poolProblem {
+	problems := make([]poolProblem, len(pools))
+	for i, pool := range pools {
+		problems[i] = poolProblem{Pool: pool.name, Error: err.Error()}
+	}
+	return problems
+}
+
+// ServePoolsHealth answers 200 when every monitored pool was ONLINE in the
+// last collection, and unhealthy, 503 by default, when a pool was not, its
+// collection failed or no collection finished yet, listing the pools at
+// fault. It reflects the last scrape, like /ready.
+func (e *Exporter) ServePoolsHealth(unhealthy int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body := poolsHealth{Status: "unknown", Pools: []poolProblem{}}
+		code := unhealthy
+		if problems, ok := e.problems.Load().([]poolProblem); ok {
+			body.Status, body.Pools = "unhealthy", problems
+			if len(problems) == 0 {
+				body.Status, code = "ok", http.StatusOK
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(body)
+	}
+}
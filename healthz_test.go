@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServePoolsHealth(t *testing.T) {
+	e := NewExporter(&[]zpool{{name: "tank"}})
+	serve := func(code int) (int, poolsHealth) {
+		w := httptest.NewRecorder()
+		e.ServePoolsHealth(code)(w, httptest.NewRequest("GET", poolsHealthPath, nil))
+		var body poolsHealth
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Error decoding %s (%s)", w.Body, err)
+		}
+		return w.Code, body
+	}
+
+	if code, body := serve(http.StatusServiceUnavailable); code != http.StatusServiceUnavailable || body.Status != "unknown" {
+		t.Errorf("Pools before a collection should be unknown, got %d %v", code, body)
+	}
+	e = newMockExporter(t)
+	e.snapshot(nil)
+	code, body := serve(http.StatusInternalServerError)
+	if code != http.StatusInternalServerError || body.Status != "unhealthy" {
+		t.Errorf("Degraded pool should be unhealthy with the configured status, got %d %v", code, body)
+	}
+	if len(body.Pools) != 1 || body.Pools[0] != (poolProblem{Pool: "backup", Health: "DEGRADED"}) {
+		t.Errorf("Incorrect problems (%v), should only list backup", body.Pools)
+	}
+
+	e.problems.Store(poolProblems([]zpool{{name: "tank", health: "ONLINE"}}))
+	if code, body := serve(http.StatusServiceUnavailable); code != http.StatusOK || body.Status != "ok" || len(body.Pools) != 0 {
+		t.Errorf("Online pools should be healthy, got %d %v", code, body)
+	}
+
+	err := errors.New("zpool list failed")
+	e.problems.Store(failedProblems([]zpool{{name: "tank"}, {name: "backup"}}, err))
+	if code, body := serve(http.StatusServiceUnavailable); code != http.StatusServiceUnavailable || len(body.Pools) != 2 || body.Pools[1].Error != err.Error() {
+		t.Errorf("Failing collection should list every pool, got %d %v", code, body)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var poolAdminCommandsDesc = prometheus.NewDesc("zfs_pool_admin_commands_total",
+	"Number of zpool and zfs commands zpool history recorded for the pool since the exporter started, by command such as \"zpool scrub\" or \"zfs snapshot\", and other for the rest", []string{"name", "command"}, nil)
+
+// historyCommands are the commands counted on their own; the others count as
+// other.
+var historyCommands = []string{
+	"zpool create", "zpool destroy", "zpool import", "zpool export", "zpool upgrade",
+	"zpool add", "zpool remove", "zpool attach", "zpool detach", "zpool replace", "zpool split",
+	"zpool online", "zpool offline", "zpool clear", "zpool reguid",
+	"zpool scrub", "zpool resilver", "zpool trim", "zpool initialize", "zpool set",
+	"zfs create", "zfs destroy", "zfs snapshot", "zfs rename", "zfs clone", "zfs promote",
+	"zfs rollback", "zfs receive", "zfs set", "zfs inherit",
+	"other",
+}
+
+// historyEntry is one command logged by zpool history.
+type historyEntry struct {
+	time    string // such as 2024-03-01.10:00:00, which sorts like the times
+	command string // one of historyCommands
+}
+
+// parseHistoryEntry parses a line of zpool history -l such as
+//
+//	2024-03-02.02:00:01 zfs snapshot -r tank@daily [user 0 (root) on nas:linux]
+//
+// ok is false for the "History for" heading, blank lines and anything else
+// that is not an entry.
+func parseHistoryEntry(line string) (e historyEntry, ok bool) {
+	// The long format appends who ran the command where; user and host
+	// names may contain spaces, so it is cut off at its opening bracket.
+	if i := strings.LastIndex(line, " [user "); i >= 0 && strings.HasSuffix(line, "]") {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 3 || len(fields[0]) != len("2006-01-02.15:04:05") || fields[0][10] != '.' {
+		return e, false
+	}
+	if fields[1] != "zpool" && fields[1] != "zfs" {
+		return e, false
+	}
+	verb := fields[2]
+	if verb == "recv" {
+		verb = "receive"
+	}
+	e.time, e.command = fields[0], "other"
+	if command := fields[1] + " " + verb; stringInSlice(command, historyCommands) {
+		e.command = command
+	}
+	return e, true
+}
+
+// historyPosition is the last entry of the history of a pool that was seen:
+// its time and how many entries had that time, since several commands can
+// run within a second.
+type historyPosition struct {
+	time string
+	same int
+}
+
+// historyCollector counts the administrative commands of the pools from
+// zpool history, which logs every zpool and zfs command that changed a pool.
+// zpool history always prints the whole history, which can be long, so each
+// scrape streams it and only counts the entries after the last one the
+// previous scrape saw. The first scrape only finds that position, so that
+// the counters start at 0 rather than with the commands of years.
+type historyCollector struct {
+	positions map[string]historyPosition
+	counts    map[string]map[string]float64
+}
+
+func newHistoryCollector() *historyCollector {
+	return &historyCollector{positions: map[string]historyPosition{}, counts: map[string]map[string]float64{}}
+}
+
+func (c *historyCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- poolAdminCommandsDesc
+}
+
+// historyCommand prints the history of pool in the long format.
+func historyCommand(pool string) command {
+	return command{"zpool", []string{"history", "-l", pool}}
+}
+
+func (c *historyCollector) plan(pools []zpool) []plannedCommand {
+	var plan []plannedCommand
+	for _, pool := range pools {
+		plan = append(plan, plannedCommand{historyCommand(pool.name), ""})
+	}
+	return plan
+}
+
+// collect counts the new entries of each pool. Failures for one pool do not
+// prevent the others from being exported.
+func (c *historyCollector) collect(r commandRunner, pools []zpool, ch chan<- prometheus.Metric) error {
+	var errs []string
+	for _, pool := range pools {
+		if err := c.tail(r, pool.name); err != nil {
+			errs = append(errs, fmt.Sprintf("zpool history %s: %s", pool.name, err))
+			continue
+		}
+		for _, command := range historyCommands {
+			ch <- prometheus.MustNewConstMetric(poolAdminCommandsDesc, prometheus.CounterValue, c.counts[pool.name][command], pool.name, command)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// tail reads the history of pool and counts the entries after its position,
+// which it then moves to the last entry. A failed read counts nothing.
+func (c *historyCollector) tail(r commandRunner, pool string) error {
+	output, err := historyCommand(pool).start(r)
+	if err != nil {
+		return err
+	}
+	counts, pos, err := c.read(output, pool)
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if c.counts[pool] == nil {
+		c.counts[pool] = map[string]float64{}
+	}
+	if _, seeded := c.positions[pool]; seeded {
+		for command, n := range counts {
+			c.counts[pool][command] += n
+		}
+	}
+	c.positions[pool] = pos
+	return nil
+}
+
+// read counts the entries of output after the position of pool and returns
+// the position of its last entry.
+func (c *historyCollector) read(output io.Reader, pool string) (map[string]float64, historyPosition, error) {
+	seen := c.positions[pool]
+	counts := map[string]float64{}
+	var pos historyPosition
+	atSeen := 0
+	scanner := newOutputScanner(output)
+	for scanner.Scan() {
+		e, ok := parseHistoryEntry(scanner.Text())
+		if !ok {
+			continue
+		}
+		isNew := e.time > seen.time
+		if e.time == seen.time {
+			atSeen++
+			isNew = atSeen > seen.same
+		}
+		if isNew {
+			counts[e.command]++
+		}
+		if e.time != pos.time {
+			pos = historyPosition{time: e.time}
+		}
+		pos.same++
+	}
+	return counts, pos, scanError(scanner)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseHistoryEntry(t *testing.T) {
+	for _, test := range []struct {
+		line string
+		ok   bool
+		want historyEntry
+	}{
+		{"2024-03-02.02:00:01 zfs snapshot -r tank@daily [user 0 (root) on nas:linux]", true, historyEntry{"2024-03-02.02:00:01", "zfs snapshot"}},
+		{"2024-03-02.02:00:01 zpool scrub tank [user 1000 (Jane Doe) on backup host:linux]", true, historyEntry{"2024-03-02.02:00:01", "zpool scrub"}},
+		{"2024-03-02.02:00:01 zfs recv -F tank/home [user 0 (root) on nas:linux]", true, historyEntry{"2024-03-02.02:00:01", "zfs receive"}},
+		{"2024-03-02.02:00:01 zpool set autotrim=on tank", true, historyEntry{"2024-03-02.02:00:01", "zpool set"}},
+		{"2024-03-02.02:00:01 zpool sync tank [user 0 (root) on nas:linux]", true, historyEntry{"2024-03-02.02:00:01", "other"}},
+		{"2024-03-02.02:00:01 zfs set comment=[user notes] tank [user 0 (root) on nas:linux]", true, historyEntry{"2024-03-02.02:00:01", "zfs set"}},
+		{"History for 'tank':", false, historyEntry{}},
+		{"", false, historyEntry{}},
+		{"2024-03-02.02:00:01 [internal snapshot txg:1234] dataset = 56", false, historyEntry{}},
+		{"yesterday zpool scrub tank", false, historyEntry{}},
+	} {
+		got, ok := parseHistoryEntry(test.line)
+		if ok != test.ok || got != test.want {
+			t.Errorf("Incorrect entry for %q (%+v, %t), should be %+v, %t", test.line, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+// historyRunner answers zpool history with the history of each pool in
+// histories, failing for those without one.
+type historyRunner map[string]string
+
+func (r historyRunner) run(name string, args ...string) (string, error) {
+	pool := args[len(args)-1]
+	history, ok := r[pool]
+	if !ok {
+		return "", io.ErrUnexpectedEOF
+	}
+	return "History for '" + pool + "':\n" + history, nil
+}
+
+func (r historyRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	output, err := r.run(name, args...)
+	return io.NopCloser(strings.NewReader(output)), err
+}
+
+// historyCounts collects c and returns the counters of pool that are not 0.
+func historyCounts(t *testing.T, c *historyCollector, r commandRunner, pool string) (map[string]float64, error) {
+	t.Helper()
+	ch := make(chan prometheus.Metric, 1000)
+	err := c.collect(r, []zpool{{name: pool}}, ch)
+	close(ch)
+	counts := map[string]float64{}
+	for m := range ch {
+		if v := metricValue(m); v != 0 {
+			counts[metricLabel(m, "command")] = v
+		}
+	}
+	return counts, err
+}
+
+func TestHistoryCollector(t *testing.T) {
+	c := newHistoryCollector()
+	r := historyRunner{"tank": "" +
+		"2023-06-12.09:14:02 zpool create tank mirror sda sdb [user 0 (root) on nas:linux]\n" +
+		"2024-03-02.02:00:01 zfs snapshot -r tank@daily [user 0 (root) on nas:linux]\n"}
+
+	counts, err := historyCounts(t, c, r, "tank")
+	if err != nil {
+		t.Fatalf("Error in collect (%s)", err)
+	}
+	if len(counts) != 0 {
+		t.Errorf("The first scrape should not count the existing history, got %v", counts)
+	}
+
+	// Two more snapshots in the same second as the last one seen, and a
+	// scrub.
+	r["tank"] += "" +
+		"2024-03-02.02:00:01 zfs snapshot -r tank@daily2 [user 0 (root) on nas:linux]\n" +
+		"2024-03-02.02:00:01 zfs snapshot -r tank@daily3 [user 0 (root) on nas:linux]\n" +
+		"2024-03-03.00:24:01 zpool scrub tank [user 0 (root) on nas:linux]\n"
+	counts, err = historyCounts(t, c, r, "tank")
+	if err != nil {
+		t.Fatalf("Error in collect (%s)", err)
+	}
+	if want := map[string]float64{"zfs snapshot": 2, "zpool scrub": 1}; !equalCounts(counts, want) {
+		t.Errorf("Incorrect counts of the new entries (%v), should be %v", counts, want)
+	}
+
+	counts, _ = historyCounts(t, c, r, "tank")
+	if want := map[string]float64{"zfs snapshot": 2, "zpool scrub": 1}; !equalCounts(counts, want) {
+		t.Errorf("Entries should only be counted once (%v), should be %v", counts, want)
+	}
+
+	// A failed read exports nothing for the pool and keeps its position.
+	delete(r, "tank")
+	if counts, err = historyCounts(t, c, r, "tank"); err == nil || len(counts) != 0 {
+		t.Errorf("A failed read should fail the collection and count nothing, got %v, %v", counts, err)
+	}
+	r["tank"] = "2024-03-03.00:24:01 zpool scrub tank [user 0 (root) on nas:linux]\n" +
+		"2024-03-04.10:00:00 zfs destroy tank/old [user 0 (root) on nas:linux]\n"
+	counts, _ = historyCounts(t, c, r, "tank")
+	if want := map[string]float64{"zfs snapshot": 2, "zpool scrub": 1, "zfs destroy": 1}; !equalCounts(counts, want) {
+		t.Errorf("Incorrect counts after a failed read (%v), should be %v", counts, want)
+	}
+}
+
+func equalCounts(a, b map[string]float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	zpoolImportableDesc = prometheus.NewDesc("zpool_importable",
+		"Pool that zpool import could import, by its state as shown by the import scan; the pool is not imported", []string{"name", "id", "state"}, nil)
+	importScanTimeDesc = prometheus.NewDesc("zfs_exporter_import_scan_timestamp_seconds",
+		"When the last zpool import scan of the import collector finished", nil, nil)
+)
+
+// noImportablePools is what zpool import prints, exiting with an error, when
+// it finds no pools to import.
+const noImportablePools = "no pools available to import"
+
+// importablePool is a pool zpool import found on the devices.
+type importablePool struct {
+	name, id, state string
+}
+
+// importCollector exports the pools that could be imported but are not, as
+// on a standby host holding the replicas of another. zpool import reads the
+// labels of every device, which can take long, so the pools are scanned in
+// the background by run every interval instead of on every scrape, and the
+// scrape exports the last scan. With timestamps the metrics of the scan
+// carry the time it finished rather than that of the scrape.
+type importCollector struct {
+	interval   time.Duration
+	timestamps bool
+
+	mutex   sync.Mutex
+	pools   []importablePool
+	scanned time.Time // zero until the first scan finished
+	err     error     // of the last scan
+}
+
+func (c *importCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- zpoolImportableDesc
+	ch <- importScanTimeDesc
+}
+
+func (c *importCollector) collect(r commandRunner, pools []zpool, ch chan<- prometheus.Metric) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.scanned.IsZero() {
+		return c.err
+	}
+	for _, pool := range c.pools {
+		ch <- c.stamp(prometheus.MustNewConstMetric(zpoolImportableDesc, prometheus.GaugeValue, 1, pool.name, pool.id, pool.state))
+	}
+	ch <- c.stamp(prometheus.MustNewConstMetric(importScanTimeDesc, prometheus.GaugeValue, float64(c.scanned.Unix())))
+	return c.err
+}
+
+// stamp gives m the time of the last scan with timestamps. c.mutex is held.
+func (c *importCollector) stamp(m prometheus.Metric) prometheus.Metric {
+	if !c.timestamps {
+		return m
+	}
+	return prometheus.NewMetricWithTimestamp(c.scanned, m)
+}
+
+// run scans for importable pools with r right away and then every interval,
+// until ctx is done.
+func (c *importCollector) run(ctx context.Context, r commandRunner) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		c.scan(r)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// importCommand lists the importable pools.
+var importCommand = command{"zpool", []string{"import"}}
+
+func (c *importCollector) plan([]zpool) []plannedCommand {
+	return []plannedCommand{{importCommand, fmt.Sprintf("every %s in the background", c.interval)}}
+}
+
+// scan runs zpool import once. A failed scan keeps the pools of the previous
+// one, and fails the collector until a scan succeeds again.
+func (c *importCollector) scan(r commandRunner) {
+	output, err := importCommand.run(r)
+	var pools []importablePool
+	switch {
+	case err != nil && strings.Contains(output, noImportablePools):
+		err = nil
+	case err != nil:
+		err = fmt.Errorf("zpool import: %s", strings.TrimSpace(output))
+	default:
+		err = recovered("import", func() error {
+			pools = parseImport(output)
+			return nil
+		})
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.err = err
+	if err == nil {
+		c.pools = pools
+		c.scanned = time.Now()
+	}
+}
+
+// parseImport parses the pools listed by zpool import without arguments,
+// each starting with a "pool:" line followed by its "id:" and "state:".
+func parseImport(output string) []importablePool {
+	var pools []importablePool
+	for lines := newLineScanner(output); lines.scan(); {
+		key, value, ok := strings.Cut(strings.TrimSpace(lines.line), ": ")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch {
+		case key == "pool":
+			pools = append(pools, importablePool{name: value})
+		case len(pools) == 0:
+		case key == "id":
+			pools[len(pools)-1].id = value
+		case key == "state":
+			pools[len(pools)-1].state = value
+		}
+	}
+	return pools
+}
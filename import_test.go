@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// importRunner answers zpool import with output and err.
+type importRunner struct {
+	output string
+	err    error
+}
+
+func (r *importRunner) run(name string, args ...string) (string, error) {
+	return r.output, r.err
+}
+
+func (r *importRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	output, err := r.run(name, args...)
+	return io.NopCloser(strings.NewReader(output)), err
+}
+
+func TestParseImport(t *testing.T) {
+	b, err := mockFS.ReadFile("mock/zpool-import.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := string(b) + "\n" + "   pool: archive\n     id: 1029384756\n  state: ONLINE\n action: The pool can be imported using its name or numeric identifier.\n config:\n\n\tarchive     ONLINE\n\t  sdx       ONLINE\n"
+	pools := parseImport(output)
+	want := []importablePool{{"offsite", "8273645019283746501", "DEGRADED"}, {"archive", "1029384756", "ONLINE"}}
+	if len(pools) != len(want) {
+		t.Fatalf("Incorrect pools %+v, should be %+v", pools, want)
+	}
+	for i := range want {
+		if pools[i] != want[i] {
+			t.Errorf("Incorrect pool %+v, should be %+v", pools[i], want[i])
+		}
+	}
+}
+
+func gatherImports(t *testing.T, c *importCollector) (map[string]float64, error) {
+	t.Helper()
+	ch := make(chan prometheus.Metric, 10)
+	err := c.collect(nil, nil, ch)
+	close(ch)
+	got := map[string]float64{}
+	for m := range ch {
+		if m.Desc() == zpoolImportableDesc {
+			got[metricLabel(m, "name")+" "+metricLabel(m, "state")] = metricValue(m)
+		}
+	}
+	return got, err
+}
+
+func TestImportCollector(t *testing.T) {
+	c := &importCollector{}
+	r := &importRunner{output: "   pool: offsite\n     id: 1\n  state: ONLINE\n"}
+	if got, err := gatherImports(t, c); len(got) != 0 || err != nil {
+		t.Errorf("Nothing should be exported before the first scan, got %v, %v", got, err)
+	}
+	c.scan(r)
+	if got, err := gatherImports(t, c); len(got) != 1 || got["offsite ONLINE"] != 1 || err != nil {
+		t.Errorf("Incorrect importable pools %v (%v)", got, err)
+	}
+
+	// A failed scan keeps the pools of the last one.
+	r.output, r.err = "cannot discover pools: permission denied\n", errors.New("exit status 1")
+	c.scan(r)
+	got, err := gatherImports(t, c)
+	if len(got) != 1 || err == nil || !isPermissionError(err) {
+		t.Errorf("Failed scan should keep the pools and fail the collector, got %v, %v", got, err)
+	}
+
+	r.output = noImportablePools + "\n"
+	c.scan(r)
+	if got, err := gatherImports(t, c); len(got) != 0 || err != nil {
+		t.Errorf("No importable pools should export none, got %v, %v", got, err)
+	}
+}
+
+func TestImportTimestamps(t *testing.T) {
+	scrape := func(timestamps bool) string {
+		c := &importCollector{timestamps: timestamps}
+		c.scan(&importRunner{output: "   pool: offsite\n     id: 1\n  state: ONLINE\n"})
+		c.scanned = time.Unix(1700000000, 0)
+		e := NewExporter(&[]zpool{{name: "tank"}})
+		e.runner = fixtureRunner{}
+		e.available = true
+		e.addCollector("import", c)
+		reg := prometheus.NewRegistry()
+		if err := e.Register(reg); err != nil {
+			t.Fatalf("Error in Register (%s)", err)
+		}
+		w := httptest.NewRecorder()
+		metricsHandler(reg, reg, nil).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		return w.Body.String()
+	}
+
+	body := scrape(true)
+	for _, want := range []string{
+		`zpool_importable{id="1",name="offsite",state="ONLINE"} 1 1700000000000` + "\n",
+		"zfs_exporter_import_scan_timestamp_seconds 1.7e+09 1700000000000\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Metrics with timestamps should contain %q, got:\n%s", want, body)
+		}
+	}
+	if !strings.Contains(body, `zpool_up{name="tank"} 1`+"\n") {
+		t.Errorf("Metrics of the other collectors should have no timestamp, got:\n%s", body)
+	}
+	if body := scrape(false); !strings.Contains(body, `zpool_importable{id="1",name="offsite",state="ONLINE"} 1`+"\n") {
+		t.Errorf("Metrics without timestamps should have none, got:\n%s", body)
+	}
+}
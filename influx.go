@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// influxPoint is one line of InfluxDB line protocol: the fields of all
+// metrics of a measurement that share the same labels.
+type influxPoint struct {
+	measurement string
+	tags        string // escaped and sorted, with a leading comma
+	fields      []string
+}
+
+// influxMeasurement splits a metric name into a measurement and a field:
+// zpool_capacity_percentage is the capacity_percentage field of zpool, and
+// zfs_dataset_used_bytes the used_bytes field of zfs_dataset. Metrics that
+// are not about ZFS, such as the Go runtime ones, are skipped.
+func influxMeasurement(name string) (measurement, field string, ok bool) {
+	parts := strings.SplitN(name, "_", 3)
+	switch {
+	case parts[0] == "zpool" && len(parts) > 1:
+		return "zpool", strings.TrimPrefix(name, "zpool_"), true
+	case parts[0] == "zfs" && len(parts) == 3:
+		return "zfs_" + parts[1], parts[2], true
+	}
+	return "", "", false
+}
+
+// influxEscaper escapes measurements, tag keys, tag values and field keys,
+// which is stricter than needed for measurements but never wrong.
+var influxEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// writeLineProtocol renders the gauges and counters of families as InfluxDB
+// line protocol with nanosecond timestamps. Labels become tags, and metrics
+// with the same measurement and labels become fields of one line.
+func writeLineProtocol(w io.Writer, families []*dto.MetricFamily, now time.Time) error {
+	points := map[string]*influxPoint{}
+	for _, family := range families {
+		measurement, field, ok := influxMeasurement(family.GetName())
+		if !ok {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			var v float64
+			switch family.GetType() {
+			case dto.MetricType_GAUGE:
+				v = m.GetGauge().GetValue()
+			case dto.MetricType_COUNTER:
+				v = m.GetCounter().GetValue()
+			case dto.MetricType_UNTYPED:
+				v = m.GetUntyped().GetValue()
+			default:
+				continue
+			}
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				continue // not representable in line protocol
+			}
+			labels := append([]*dto.LabelPair(nil), m.GetLabel()...)
+			sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+			var tags strings.Builder
+			for _, l := range labels {
+				if l.GetValue() == "" {
+					continue // empty tag values are not allowed
+				}
+				fmt.Fprintf(&tags, ",%s=%s", influxEscaper.Replace(l.GetName()), influxEscaper.Replace(l.GetValue()))
+			}
+			key := measurement + tags.String()
+			p, ok := points[key]
+			if !ok {
+				p = &influxPoint{measurement: influxEscaper.Replace(measurement), tags: tags.String()}
+				points[key] = p
+			}
+			p.fields = append(p.fields, influxEscaper.Replace(field)+"="+strconv.FormatFloat(v, 'f', -1, 64))
+		}
+	}
+
+	keys := make([]string, 0, len(points))
+	for key := range points {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	ts := now.UnixNano()
+	for _, key := range keys {
+		p := points[key]
+		sort.Strings(p.fields)
+		if _, err := fmt.Fprintf(w, "%s%s %s %d\n", p.measurement, p.tags, strings.Join(p.fields, ","), ts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// influxHandler serves the metrics gathered from g as InfluxDB line
+// protocol, for Telegraf's http input. Every request collects like a scrape
+// of the Prometheus endpoint.
+func influxHandler(g prometheus.Gatherer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		families, err := g.Gather()
+		if err != nil {
+			if len(families) == 0 {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			// Gather still returns what it could collect.
+			log.Printf("Error gathering metrics for %s: %s", r.URL.Path, err)
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := writeLineProtocol(w, families, time.Now()); err != nil {
+			log.Printf("Error writing %s: %s", r.URL.Path, err)
+		}
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestWriteLineProtocol(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	capacity := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "zpool_capacity_percentage", Help: "h"}, []string{"name"})
+	online := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "zpool_online_providers_count", Help: "h"}, []string{"name"})
+	frag := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "zpool_vdev_fragmentation_percentage", Help: "h"}, []string{"name", "vdev"})
+	used := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "zfs_dataset_used_bytes", Help: "h"}, []string{"name"})
+	rate := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "zpool_scan_rate_bytes_per_second", Help: "h"}, []string{"name"})
+	hits := prometheus.NewCounter(prometheus.CounterOpts{Name: "zfs_arc_hits_total", Help: "h"})
+	other := prometheus.NewGauge(prometheus.GaugeOpts{Name: "go_goroutines", Help: "h"})
+	reg.MustRegister(capacity, online, frag, used, rate, hits, other)
+	capacity.WithLabelValues("tank").Set(51)
+	online.WithLabelValues("tank").Set(6)
+	frag.WithLabelValues("tank", "mirror-0").Set(12)
+	used.WithLabelValues("tank/my home").Set(1024)
+	rate.WithLabelValues("tank").Set(math.NaN())
+	hits.Add(5339656)
+	other.Set(8)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := writeLineProtocol(&b, families, time.Unix(1700000000, 1)); err != nil {
+		t.Fatalf("Error in writeLineProtocol (%s)", err)
+	}
+	want := `zfs_arc hits_total=5339656 1700000000000000001
+zfs_dataset,name=tank/my\ home used_bytes=1024 1700000000000000001
+zpool,name=tank capacity_percentage=51,online_providers_count=6 1700000000000000001
+zpool,name=tank,vdev=mirror-0 vdev_fragmentation_percentage=12 1700000000000000001
+`
+	if b.String() != want {
+		t.Errorf("Incorrect line protocol:\n%s\nshould be:\n%s", b.String(), want)
+	}
+}
+
+func TestInfluxHandler(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	capacity := prometheus.NewGauge(prometheus.GaugeOpts{Name: "zpool_capacity_percentage", Help: "h"})
+	reg.MustRegister(capacity)
+
+	w := httptest.NewRecorder()
+	influxHandler(reg).ServeHTTP(w, httptest.NewRequest("GET", "/influx", nil))
+	if w.Code != 200 || !strings.HasPrefix(w.Body.String(), "zpool capacity_percentage=0 ") {
+		t.Errorf("Incorrect response %d %q", w.Code, w.Body.String())
+	}
+}
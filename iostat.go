@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// iostatMetrics are the rate columns of zpool iostat -Hp, after name, alloc
+// and free.
+var iostatMetrics = []*prometheus.Desc{
+	prometheus.NewDesc("zpool_iostat_read_ops_per_second", "Read operations per second on the zpool", []string{"name"}, nil),
+	prometheus.NewDesc("zpool_iostat_write_ops_per_second", "Write operations per second on the zpool", []string{"name"}, nil),
+	prometheus.NewDesc("zpool_iostat_read_bytes_per_second", "Bytes read per second from the zpool", []string{"name"}, nil),
+	prometheus.NewDesc("zpool_iostat_write_bytes_per_second", "Bytes written per second to the zpool", []string{"name"}, nil),
+}
+
+// iostatDeviceMetrics are iostatMetrics for the leaf devices of a pool.
+var iostatDeviceMetrics = []*prometheus.Desc{
+	prometheus.NewDesc("zpool_iostat_device_read_ops_per_second", "Read operations per second on the device", []string{"name", "vdev", "device"}, nil),
+	prometheus.NewDesc("zpool_iostat_device_write_ops_per_second", "Write operations per second on the device", []string{"name", "vdev", "device"}, nil),
+	prometheus.NewDesc("zpool_iostat_device_read_bytes_per_second", "Bytes read per second from the device", []string{"name", "vdev", "device"}, nil),
+	prometheus.NewDesc("zpool_iostat_device_write_bytes_per_second", "Bytes written per second to the device", []string{"name", "vdev", "device"}, nil),
+}
+
+// parseIostatRow parses the rate columns of one zpool iostat -Hp row.
+func parseIostatRow(fields []string) ([]float64, error) {
+	if len(fields) != 3+len(iostatMetrics) {
+		return nil, fmt.Errorf("expected %d zpool iostat columns, got %d", 3+len(iostatMetrics), len(fields))
+	}
+	values := make([]float64, len(iostatMetrics))
+	for i := range values {
+		v, err := strconv.ParseFloat(fields[3+i], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", fields[0], err)
+		}
+		if v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("%s: invalid rate %s", fields[0], fields[3+i])
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// parseIostat parses zpool iostat -Hp output and returns the rates of the
+// last report for each pool. Without -y the first report covers the time
+// since the pool was imported, so the last one is the current rate.
+func parseIostat(output string) (map[string][]float64, error) {
+	rates := map[string][]float64{}
+	for lines := newLineScanner(output); lines.scan(); {
+		if lines.line == "" {
+			continue
+		}
+		fields := strings.Split(lines.line, "\t")
+		values, err := parseIostatRow(fields)
+		if err != nil {
+			return nil, fmt.Errorf("pool %s", err)
+		}
+		rates[fields[0]] = values
+	}
+	return rates, nil
+}
+
+// deviceRates are the I/O rates of one leaf device and the top-level vdev it
+// belongs to, which is the device itself for a single-disk vdev.
+type deviceRates struct {
+	vdev, device string
+	values       []float64
+}
+
+// parseIostatVerbose parses zpool iostat -v -Hp output of the pools in names,
+// returning the rates of the last report of each pool and of its leaf
+// devices. -H does not indent the vdev rows, so the hierarchy is rebuilt like
+// zpool prints it: a top-level vdev reports its alloc and free, the rows
+// below it up to the next one show "-" and are its devices, or interior
+// vdevs such as replacing-0, and a top-level vdev without such rows, such as
+// a single disk or a cache device, is a device itself.
+func parseIostatVerbose(output string, names []string) (map[string][]float64, map[string][]deviceRates, error) {
+	rates := map[string][]float64{}
+	devices := map[string][]deviceRates{}
+	var pool string
+	var top *deviceRates // the top-level vdev the rows below belong to
+	var leaves bool
+	flush := func() {
+		if top != nil && !leaves && !hasAnyPrefix(top.vdev, vdevGroupPrefixes) {
+			devices[pool] = append(devices[pool], deviceRates{vdev: top.vdev, device: top.vdev, values: top.values})
+		}
+		top = nil
+	}
+	for lines := newLineScanner(output); lines.scan(); {
+		line := strings.TrimLeft(lines.line, "\t")
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		name := fields[0]
+		if stringInSlice(name, vdevClasses) {
+			flush() // the vdevs below still belong to pool
+			continue
+		}
+		values, err := parseIostatRow(fields)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case stringInSlice(name, names):
+			// A later report replaces the devices of the earlier one.
+			flush()
+			pool = name
+			rates[pool] = values
+			devices[pool] = nil
+		case pool == "":
+			return nil, nil, fmt.Errorf("unexpected zpool iostat -v row %q", lines.line)
+		case fields[1] != "-":
+			flush()
+			top, leaves = &deviceRates{vdev: name, values: values}, false
+		case top != nil && !hasAnyPrefix(name, vdevGroupPrefixes):
+			leaves = true
+			devices[pool] = append(devices[pool], deviceRates{vdev: top.vdev, device: name, values: values})
+		}
+	}
+	flush()
+	return rates, devices, nil
+}
+
+// iostatCollector exports the I/O rates of every pool, measured by
+// zpool iostat over one interval. Every scrape takes at least that long.
+//
+// With perDevice it runs zpool iostat -v and also exports the rates of every
+// leaf device, which multiplies the series by the number of disks.
+type iostatCollector struct {
+	interval  int // seconds
+	perDevice bool
+}
+
+func (c *iostatCollector) describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range iostatMetrics {
+		ch <- desc
+	}
+	if c.perDevice {
+		for _, desc := range iostatDeviceMetrics {
+			ch <- desc
+		}
+	}
+}
+
+// statsCommand is the zpool iostat of pools.
+func (c *iostatCollector) statsCommand(pools []zpool) command {
+	args := []string{"iostat", "-Hp"}
+	if c.perDevice {
+		args = []string{"iostat", "-v", "-Hp"}
+	}
+	args = append(args, poolNames(pools)...)
+	return command{"zpool", append(args, strconv.Itoa(c.interval), "2")}
+}
+
+func (c *iostatCollector) plan(pools []zpool) []plannedCommand {
+	return []plannedCommand{{c.statsCommand(pools), ""}}
+}
+
+func (c *iostatCollector) collect(r commandRunner, pools []zpool, ch chan<- prometheus.Metric) error {
+	output, err := c.statsCommand(pools).run(r)
+	if err != nil {
+		return fmt.Errorf("zpool iostat: %s", strings.TrimSpace(output))
+	}
+	var rates map[string][]float64
+	var devices map[string][]deviceRates
+	if c.perDevice {
+		rates, devices, err = parseIostatVerbose(output, poolNames(pools))
+	} else {
+		rates, err = parseIostat(output)
+	}
+	if err != nil {
+		return err
+	}
+	for _, pool := range pools {
+		values, ok := rates[pool.name]
+		if !ok {
+			continue
+		}
+		for i, desc := range iostatMetrics {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, values[i], pool.name)
+		}
+		for _, d := range devices[pool.name] {
+			for i, desc := range iostatDeviceMetrics {
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, d.values[i], pool.name, d.vdev, d.device)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestIostatCollector(t *testing.T) {
+	r := staticRunner{
+		"zpool iostat -Hp tank 1 2": "tank\t3929468416\t6737952768\t112\t48\t4251625\t1265451\n" +
+			"tank\t3929468416\t6737952768\t2\t30\t16384\t983040\n",
+	}
+	ch := make(chan prometheus.Metric, len(iostatMetrics))
+	if err := (&iostatCollector{interval: 1}).collect(r, []zpool{{name: "tank"}}, ch); err != nil {
+		t.Fatalf("Error in collect (%s)", err)
+	}
+	close(ch)
+	got := map[string]float64{}
+	for m := range ch {
+		got[descName(m.Desc())] = metricValue(m)
+	}
+	for name, want := range map[string]float64{
+		"zpool_iostat_read_ops_per_second":    2,
+		"zpool_iostat_write_ops_per_second":   30,
+		"zpool_iostat_read_bytes_per_second":  16384,
+		"zpool_iostat_write_bytes_per_second": 983040,
+	} {
+		if got[name] != want {
+			t.Errorf("Incorrect %s (%v), should be %v", name, got[name], want)
+		}
+	}
+
+	if _, err := parseIostat("tank\t1\t2\n"); err == nil {
+		t.Errorf("Short line should produce error in parseIostat")
+	}
+}
+
+func TestParseIostatVerbose(t *testing.T) {
+	output := "tank\t100\t200\t9\t9\t9\t9\n" +
+		"\tmirror-0\t100\t200\t9\t9\t9\t9\n" +
+		"\tsda\t-\t-\t9\t9\t9\t9\n" +
+		"tank\t100\t200\t30\t12\t3000\t1200\n" +
+		"\tmirror-0\t80\t150\t20\t10\t2000\t1000\n" +
+		"\tsda\t-\t-\t12\t5\t1200\t500\n" +
+		"\treplacing-1\t-\t-\t8\t5\t800\t500\n" +
+		"\tsdb\t-\t-\t8\t0\t800\t0\n" +
+		"\tsdc\t-\t-\t0\t5\t0\t500\n" +
+		"\tsdd\t20\t50\t5\t1\t500\t100\n" +
+		"logs\t-\t-\t-\t-\t-\t-\n" +
+		"\tnvme0n1\t1\t9\t0\t1\t0\t100\n" +
+		"cache\t-\t-\t-\t-\t-\t-\n" +
+		"\tnvme1n1\t5\t5\t5\t0\t500\t0\n"
+	rates, devices, err := parseIostatVerbose(output, []string{"tank"})
+	if err != nil {
+		t.Fatalf("Error in parseIostatVerbose (%s)", err)
+	}
+	if got := rates["tank"]; len(got) != 4 || got[0] != 30 || got[3] != 1200 {
+		t.Errorf("Incorrect pool rates (%v), should be the last report", got)
+	}
+	want := []deviceRates{
+		{vdev: "mirror-0", device: "sda", values: []float64{12, 5, 1200, 500}},
+		{vdev: "mirror-0", device: "sdb", values: []float64{8, 0, 800, 0}},
+		{vdev: "mirror-0", device: "sdc", values: []float64{0, 5, 0, 500}},
+		{vdev: "sdd", device: "sdd", values: []float64{5, 1, 500, 100}},
+		{vdev: "nvme0n1", device: "nvme0n1", values: []float64{0, 1, 0, 100}},
+		{vdev: "nvme1n1", device: "nvme1n1", values: []float64{5, 0, 500, 0}},
+	}
+	got := devices["tank"]
+	if len(got) != len(want) {
+		t.Fatalf("Incorrect devices (%v), should be %v", got, want)
+	}
+	for i := range want {
+		if got[i].vdev != want[i].vdev || got[i].device != want[i].device || got[i].values[0] != want[i].values[0] || got[i].values[3] != want[i].values[3] {
+			t.Errorf("Incorrect device %d (%v), should be %v", i, got[i], want[i])
+		}
+	}
+
+	if _, _, err := parseIostatVerbose("\tsda\t-\t-\t1\t1\t1\t1\n", []string{"tank"}); err == nil {
+		t.Errorf("Device before any pool should produce error in parseIostatVerbose")
+	}
+	if _, _, err := parseIostatVerbose("tank\t1\t2\n", []string{"tank"}); err == nil {
+		t.Errorf("Short line should produce error in parseIostatVerbose")
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// kmemSlabPath lists the SPL kmem caches on Linux.
+var kmemSlabPath = "/proc/spl/kmem/slab"
+
+var (
+	kmemSlabSizeDesc = prometheus.NewDesc("zfs_kmem_slab_size_bytes",
+		"Memory allocated to the SPL kmem cache, only for the largest caches", []string{"cache"}, nil)
+	kmemSlabAllocDesc = prometheus.NewDesc("zfs_kmem_slab_alloc_bytes",
+		"Memory in use by objects of the SPL kmem cache, only for the largest caches", []string{"cache"}, nil)
+	kmemSlabTotalDesc = prometheus.NewDesc("zfs_kmem_slab_total_size_bytes",
+		"Memory allocated to all SPL kmem caches", nil, nil)
+	kmemSlabCachesDesc = prometheus.NewDesc("zfs_kmem_slab_caches",
+		"Number of SPL kmem caches", nil, nil)
+)
+
+// kmemARCMetrics are the arcstats statistics about the caches kept outside
+// the ARC data buffers. dbuf_size comes first for withoutDbufSize.
+var kmemARCMetrics = []kstatMetric{
+	{stat: "dbuf_size", desc: dbufCacheSizeDesc},
+	newKstatMetric("dnode_size", "zfs_dnode_cache_size_bytes", "Size of the dnode cache", false),
+	newKstatMetric("abd_chunk_waste_size", "zfs_abd_chunk_waste_size_bytes", "Memory lost to the ABD chunk allocator, absent on releases that do not report it", false),
+}
+
+// slabCache is one row of the SPL slab list.
+type slabCache struct {
+	name  string
+	size  float64 // bytes allocated to the cache
+	alloc float64 // bytes in use by its objects
+}
+
+// parseKmemSlab parses /proc/spl/kmem/slab: a line of column groups, a
+// header starting with "name  flags  size  alloc" and one line per cache.
+func parseKmemSlab(r io.Reader) ([]slabCache, error) {
+	var caches []slabCache
+	scanner := bufio.NewScanner(r)
+	header := false
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if !header {
+			header = len(fields) > 3 && fields[0] == "name" && fields[1] == "flags" && fields[2] == "size" && fields[3] == "alloc"
+			continue
+		}
+		if len(fields) < 4 {
+			continue
+		}
+		size, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("cache %s: %s", fields[0], err)
+		}
+		alloc, err := strconv.ParseFloat(fields[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("cache %s: %s", fields[0], err)
+		}
+		caches = append(caches, slabCache{name: fields[0], size: size, alloc: alloc})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !header {
+		return nil, fmt.Errorf("no name flags size alloc header in slab list")
+	}
+	return caches, nil
+}
+
+// kmemCollector exports the memory ZFS uses outside the ARC: the dbuf and
+// dnode caches from arcstats and the SPL kmem caches. The slab list holds
+// hundreds of caches, so only the top largest get a series of their own;
+// the total covers all of them.
+type kmemCollector struct {
+	top  int
+	arcs *kstatCollector
+}
+
+func newKmemCollector(top int) *kmemCollector {
+	return &kmemCollector{top: top, arcs: &kstatCollector{kstat: "arcstats", metrics: kmemARCMetrics}}
+}
+
+// withoutDbufSize leaves zfs_dbuf_cache_size_bytes to the dbuf collector,
+// which reads it from dbufstats.
+func (c *kmemCollector) withoutDbufSize() *kmemCollector {
+	c.arcs = &kstatCollector{kstat: "arcstats", metrics: kmemARCMetrics[1:]}
+	return c
+}
+
+func (c *kmemCollector) describe(ch chan<- *prometheus.Desc) {
+	c.arcs.describe(ch)
+	ch <- kmemSlabSizeDesc
+	ch <- kmemSlabAllocDesc
+	ch <- kmemSlabTotalDesc
+	ch <- kmemSlabCachesDesc
+}
+
+func (c *kmemCollector) collect(r commandRunner, pools []zpool, ch chan<- prometheus.Metric) error {
+	f, err := os.Open(kmemSlabPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	caches, err := parseKmemSlab(f)
+	if err != nil {
+		return fmt.Errorf("%s: %s", kmemSlabPath, err)
+	}
+	if err := c.arcs.collect(r, pools, ch); err != nil {
+		return err
+	}
+
+	var total float64
+	for _, cache := range caches {
+		total += cache.size
+	}
+	ch <- prometheus.MustNewConstMetric(kmemSlabTotalDesc, prometheus.GaugeValue, total)
+	ch <- prometheus.MustNewConstMetric(kmemSlabCachesDesc, prometheus.GaugeValue, float64(len(caches)))
+	sort.Slice(caches, func(i, j int) bool {
+		if caches[i].size != caches[j].size {
+			return caches[i].size > caches[j].size
+		}
+		return caches[i].name < caches[j].name
+	})
+	for i, cache := range caches {
+		if i >= c.top {
+			break
+		}
+		ch <- prometheus.MustNewConstMetric(kmemSlabSizeDesc, prometheus.GaugeValue, cache.size, cache.name)
+		ch <- prometheus.MustNewConstMetric(kmemSlabAllocDesc, prometheus.GaugeValue, cache.alloc, cache.name)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const kmemSlab = `--------------------- cache -------------------------------------------------------  ----- slab ------  ---- object -----  --- emergency ---
+name                                    flags      size     alloc slabsize  objsize  total alloc   max  total alloc   max  dlock alloc   max
+spl_vn_cache                          0x00020         0         0     4096       88      0     0     0      0     0     0      0     0     0
+zio_buf_comb_16384                    0x00042  67108864  50331648  1048576    16384     64    60    64   3008  3072  3072      0     0     0
+zio_data_buf_131072                   0x00042 201326592 132120576  2097152   131072     96    63    96   1488  1008  1488      0     0     0
+ddt_cache                             0x00040   1595104   1594896   199388    24984      8     8     8     64    64    64      0     0     0
+`
+
+func TestParseKmemSlab(t *testing.T) {
+	caches, err := parseKmemSlab(strings.NewReader(kmemSlab))
+	if err != nil {
+		t.Fatalf("Error in parseKmemSlab (%s)", err)
+	}
+	if len(caches) != 4 || caches[2] != (slabCache{"zio_data_buf_131072", 201326592, 132120576}) {
+		t.Errorf("Incorrect caches %v", caches)
+	}
+	if _, err := parseKmemSlab(strings.NewReader("hello\n")); err == nil {
+		t.Errorf("Missing header should produce error in parseKmemSlab")
+	}
+}
+
+func TestKmemCollector(t *testing.T) {
+	dir := t.TempDir()
+	stats := arcstats + "dbuf_size                       4    1048576\ndnode_size                      4    2097152\n"
+	if err := os.WriteFile(filepath.Join(dir, "arcstats"), []byte(stats), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "slab"), []byte(kmemSlab), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(oldKstat, oldSlab string) { kstatDir, kmemSlabPath = oldKstat, oldSlab }(kstatDir, kmemSlabPath)
+	kstatDir, kmemSlabPath = dir, filepath.Join(dir, "slab")
+
+	ch := make(chan prometheus.Metric, 20)
+	if err := newKmemCollector(2).collect(staticRunner{}, nil, ch); err != nil {
+		t.Fatalf("Error in collect (%s)", err)
+	}
+	close(ch)
+	got := map[string]float64{}
+	for m := range ch {
+		key := descName(m.Desc())
+		if cache := metricLabel(m, "cache"); cache != "" {
+			key += " " + cache
+		}
+		got[key] = metricValue(m)
+	}
+	for key, want := range map[string]float64{
+		"zfs_dbuf_cache_size_bytes":                    1048576,
+		"zfs_dnode_cache_size_bytes":                   2097152,
+		"zfs_kmem_slab_total_size_bytes":               201326592 + 67108864 + 1595104,
+		"zfs_kmem_slab_caches":                         4,
+		"zfs_kmem_slab_size_bytes zio_data_buf_131072": 201326592,
+		"zfs_kmem_slab_alloc_bytes zio_buf_comb_16384": 50331648,
+	} {
+		if v, ok := got[key]; !ok || v != want {
+			t.Errorf("Incorrect %s (%v), should be %v", key, v, want)
+		}
+	}
+	if _, ok := got["zfs_kmem_slab_size_bytes ddt_cache"]; ok {
+		t.Errorf("Only the 2 largest caches should be exported, got %v", got)
+	}
+
+	kmemSlabPath = filepath.Join(dir, "missing")
+	if err := newKmemCollector(2).collect(staticRunner{}, nil, make(chan prometheus.Metric, 20)); !os.IsNotExist(err) {
+		t.Errorf("Missing slab list should produce not exist error, got %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// kstatDir is where the ZFS kstats are found on Linux.
+var kstatDir = "/proc/spl/kstat/zfs"
+
+// parseKstatValues parses a named kstat such as arcstats: a header line, a
+// "name type data" line and one line per statistic. Values are returned as
+// text, since some, such as the dataset_name of objset kstats, are strings.
+func parseKstatValues(r io.Reader) (map[string]string, error) {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	header := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if !header {
+			header = len(fields) == 3 && fields[0] == "name" && fields[1] == "type" && fields[2] == "data"
+			continue
+		}
+		if len(fields) < 3 {
+			continue
+		}
+		// String data, such as a dataset name, may contain spaces.
+		data := strings.TrimSpace(line)
+		for i := 0; i < 2; i++ {
+			data = strings.TrimSpace(strings.TrimPrefix(data, fields[i]))
+		}
+		values[fields[0]] = data
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !header {
+		return nil, fmt.Errorf("no name type data header in kstat")
+	}
+	return values, nil
+}
+
+// parseKstat parses a named kstat into its numeric statistics. Statistics
+// that are not numbers are skipped, since the set changes between OpenZFS
+// releases.
+func parseKstat(r io.Reader) (map[string]float64, error) {
+	values, err := parseKstatValues(r)
+	if err != nil {
+		return nil, err
+	}
+	stats := map[string]float64{}
+	for name, value := range values {
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			stats[name] = v
+		}
+	}
+	return stats, nil
+}
+
+// readKstat reads the named kstat from kstatDir.
+func readKstat(name string) (map[string]float64, error) {
+	f, err := os.Open(filepath.Join(kstatDir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stats, err := parseKstat(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", f.Name(), err)
+	}
+	return stats, nil
+}
+
+// kstatMetric maps one kstat statistic to a metric.
+type kstatMetric struct {
+	stat    string
+	desc    *prometheus.Desc
+	counter bool
+}
+
+func newKstatMetric(stat, name, help string, counter bool) kstatMetric {
+	return kstatMetric{stat: stat, desc: prometheus.NewDesc(name, help, nil, nil), counter: counter}
+}
+
+// arcMetrics are the arcstats statistics exported by the arc collector.
+var arcMetrics = []kstatMetric{
+	newKstatMetric("size", "zfs_arc_size_bytes", "Current size of the ARC", false),
+	newKstatMetric("c", "zfs_arc_target_size_bytes", "Target size of the ARC", false),
+	newKstatMetric("c_min", "zfs_arc_min_size_bytes", "Minimum size of the ARC", false),
+	newKstatMetric("c_max", "zfs_arc_max_size_bytes", "Maximum size of the ARC", false),
+	newKstatMetric("mru_size", "zfs_arc_mru_size_bytes", "Size of the most recently used part of the ARC", false),
+	newKstatMetric("mfu_size", "zfs_arc_mfu_size_bytes", "Size of the most frequently used part of the ARC", false),
+	newKstatMetric("arc_meta_used", "zfs_arc_metadata_size_bytes", "Size of the metadata in the ARC, absent on releases that do not report it", false),
+	newKstatMetric("hits", "zfs_arc_hits_total", "Number of ARC hits", true),
+	newKstatMetric("misses", "zfs_arc_misses_total", "Number of ARC misses", true),
+	newKstatMetric("memory_throttle_count", "zfs_arc_memory_throttle_total", "Number of times the ARC throttled writes for lack of memory", true),
+	newKstatMetric("l2_size", "zfs_arc_l2_size_bytes", "Size of the data in the L2ARC", false),
+	newKstatMetric("l2_hits", "zfs_arc_l2_hits_total", "Number of L2ARC hits", true),
+	newKstatMetric("l2_misses", "zfs_arc_l2_misses_total", "Number of L2ARC misses", true),
+}
+
+// kstatCollector exports selected statistics of one kstat file. It does not
+// depend on the monitored pools, since the kstat covers the whole system.
+type kstatCollector struct {
+	kstat   string
+	metrics []kstatMetric
+}
+
+func newARCCollector() *kstatCollector {
+	return &kstatCollector{kstat: "arcstats", metrics: arcMetrics}
+}
+
+func (c *kstatCollector) describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics {
+		ch <- m.desc
+	}
+}
+
+func (c *kstatCollector) collect(_ commandRunner, _ []zpool, ch chan<- prometheus.Metric) error {
+	stats, err := readKstat(c.kstat)
+	if err != nil {
+		return err
+	}
+	for _, m := range c.metrics {
+		v, ok := stats[m.stat]
+		if !ok {
+			continue
+		}
+		valueType := prometheus.GaugeValue
+		if m.counter {
+			valueType = prometheus.CounterValue
+		}
+		ch <- prometheus.MustNewConstMetric(m.desc, valueType, v)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const arcstats = `13 1 0x01 123 33456 3576668862 1711978956744537
+name                            type data
+hits                            4    5339656
+misses                          4    38858
+c                               4    4148789248
+c_min                           4    131072000
+c_max                           4    4194304000
+size                            4    3821565048
+mru_size                        4    2124670976
+mfu_size                        4    1442077696
+memory_throttle_count           4    0
+l2_size                         4    0
+l2_hits                         4    0
+l2_misses                       4    0
+`
+
+func TestParseKstat(t *testing.T) {
+	stats, err := parseKstat(strings.NewReader(arcstats))
+	if err != nil {
+		t.Fatalf("Error in parseKstat (%s)", err)
+	}
+	if stats["size"] != 3821565048 || stats["hits"] != 5339656 {
+		t.Errorf("Incorrect stats %v", stats)
+	}
+	if _, err := parseKstat(strings.NewReader("hello\n")); err == nil {
+		t.Errorf("Missing header should produce error in parseKstat")
+	}
+}
+
+func TestARCCollector(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "arcstats"), []byte(arcstats), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(old string) { kstatDir = old }(kstatDir)
+	kstatDir = dir
+
+	ch := make(chan prometheus.Metric, len(arcMetrics))
+	if err := newARCCollector().collect(staticRunner{}, nil, ch); err != nil {
+		t.Fatalf("Error in collect (%s)", err)
+	}
+	close(ch)
+	got := map[string]float64{}
+	for m := range ch {
+		got[descName(m.Desc())] = metricValue(m)
+	}
+	if got["zfs_arc_size_bytes"] != 3821565048 || got["zfs_arc_misses_total"] != 38858 {
+		t.Errorf("Incorrect ARC metrics %v", got)
+	}
+	if _, ok := got["zfs_arc_metadata_size_bytes"]; ok {
+		t.Errorf("Missing arc_meta_used should not be exported")
+	}
+
+	kstatDir = filepath.Join(dir, "missing")
+	if err := newARCCollector().collect(staticRunner{}, nil, ch); !os.IsNotExist(err) {
+		t.Errorf("Missing kstat should produce not exist error, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// labelNameRE matches legal Prometheus label names.
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedLabels are the labels the exporter sets itself.
+var reservedLabels = []string{
+	"name", "vdev", "dataset", "user", "group", "project", "origin", "state",
+	"collector", "mountpoint", "canmount", "activity", "device", "enclosure", "slot", "cache",
+	"altroot", "cachefile", "comment", "bootfs", "version", "guid", "createtxg", "from", "to", "reason",
+	"userland", "kernel", "capability", "command", "kind", "entry", "value", "class", "aggregation", "id", "tag",
+	"sync", "logbias",
+}
+
+// labelFlag collects the key=value pairs of a repeatable -label flag, each
+// occurrence of which may also hold a comma separated list.
+type labelFlag []string
+
+func (f *labelFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *labelFlag) Set(value string) error {
+	*f = append(*f, strings.Split(value, ",")...)
+	return nil
+}
+
+// Type names the value in the --help output.
+func (f *labelFlag) Type() string {
+	return "key=value"
+}
+
+// parseStaticLabels validates key=value pairs given with -label.
+func parseStaticLabels(pairs []string) (prometheus.Labels, error) {
+	labels := prometheus.Labels{}
+	for _, pair := range pairs {
+		if pair == "" {
+			continue
+		}
+		i := strings.IndexByte(pair, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid -label %q, should be key=value", pair)
+		}
+		key, value := pair[:i], pair[i+1:]
+		switch {
+		case !labelNameRE.MatchString(key) || strings.HasPrefix(key, "__"):
+			return nil, fmt.Errorf("invalid -label %q, %q is not a legal label name", pair, key)
+		case stringInSlice(key, reservedLabels):
+			return nil, fmt.Errorf("invalid -label %q, %q is used by the exporter", pair, key)
+		}
+		if _, ok := labels[key]; ok {
+			return nil, fmt.Errorf("invalid -label %q, %q is given more than once", pair, key)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// addHostLabel adds a host label to labels, set to hostname or, when that is
+// empty, the name of this machine.
+func addHostLabel(labels prometheus.Labels, hostname string) error {
+	if _, ok := labels["host"]; ok {
+		return fmt.Errorf("-label host is given as well as -add-hostname-label or -hostname")
+	}
+	if hostname == "" {
+		var err error
+		if hostname, err = os.Hostname(); err != nil {
+			return fmt.Errorf("could not get hostname for the host label, set -hostname: %s", err)
+		}
+	}
+	labels["host"] = hostname
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseStaticLabels(t *testing.T) {
+	var f labelFlag
+	f.Set("cluster=storage-eu1,role=backup")
+	f.Set("empty=")
+	labels, err := parseStaticLabels(f)
+	if err != nil {
+		t.Fatalf("Error in parseStaticLabels (%s)", err)
+	}
+	if len(labels) != 3 || labels["cluster"] != "storage-eu1" || labels["role"] != "backup" || labels["empty"] != "" {
+		t.Errorf("Incorrect labels %v", labels)
+	}
+
+	for _, pair := range []string{"cluster", "1st=x", "__meta=x", "has-dash=x", "name=tank", "vdev=x", "role=a,role=b"} {
+		if _, err := parseStaticLabels(strings.Split(pair, ",")); err == nil {
+			t.Errorf("parseStaticLabels(%q) should produce error", pair)
+		}
+	}
+}
+
+func TestAddHostLabel(t *testing.T) {
+	labels := prometheus.Labels{"cluster": "storage-eu1"}
+	if err := addHostLabel(labels, "nas1"); err != nil || labels["host"] != "nas1" {
+		t.Errorf("Incorrect host label %v (%v)", labels, err)
+	}
+	if err := addHostLabel(labels, "nas2"); err == nil {
+		t.Errorf("Host label given twice should produce error")
+	}
+
+	hostname, _ := os.Hostname()
+	labels = prometheus.Labels{}
+	if err := addHostLabel(labels, ""); err != nil || labels["host"] != hostname {
+		t.Errorf("Host label should default to %q, got %v (%v)", hostname, labels, err)
+	}
+}
+
+func TestStaticLabels(t *testing.T) {
+	list, _ := fixtureRunner{}.run("zpool", "list", "-Hp", "-o", strings.Join(zpoolListProperties, ","), "tank")
+	status, _ := fixtureRunner{}.run("zpool", "status", "tank")
+	r := staticRunner{
+		"zpool list -Hp -o " + strings.Join(zpoolListProperties, ",") + " tank": list,
+		"zpool status tank": status,
+		"zfs get -Hp -o name,property,value filesystem_count,snapshot_count tank": "tank\tfilesystem_count\t4\ntank\tsnapshot_count\t10\n",
+	}
+	e := NewExporter(&[]zpool{{name: "tank"}})
+	e.runner = r
+	e.available = true
+	e.addCollector("pool-counts", poolCountCollector{})
+	reg := prometheus.NewRegistry()
+	if err := e.Register(prometheus.WrapRegistererWith(prometheus.Labels{"cluster": "storage-eu1"}, reg)); err != nil {
+		t.Fatalf("Error in Register (%s)", err)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Error in Gather (%s)", err)
+	}
+	names := map[string]bool{}
+	for _, family := range families {
+		names[family.GetName()] = true
+		for _, m := range family.GetMetric() {
+			found := false
+			for _, l := range m.GetLabel() {
+				found = found || (l.GetName() == "cluster" && l.GetValue() == "storage-eu1")
+			}
+			if !found {
+				t.Errorf("%s should have the cluster label: %v", family.GetName(), m.GetLabel())
+			}
+		}
+	}
+	for _, name := range []string{"zpool_capacity_percentage", "zfs_pool_dataset_count", "zfs_exporter_zfs_available", "zfs_exporter_collector_enabled"} {
+		if !names[name] {
+			t.Errorf("%s should be exported, got %v", name, names)
+		}
+	}
+}
+
+// TestReservedLabels checks that every label of the metrics of the exporter
+// is reserved, so that -label and -pool-labels.file cannot set it too.
+func TestReservedLabels(t *testing.T) {
+	e := newMockExporter(t)
+	e.addCollector("history", newHistoryCollector())
+	descs := make(chan *prometheus.Desc, 1000)
+	e.Describe(descs)
+	collectorPanics.Describe(descs)
+	close(descs)
+	variableLabels := regexp.MustCompile(`variableLabels: \{([^}]*)\}`)
+	for desc := range descs {
+		m := variableLabels.FindStringSubmatch(desc.String())
+		if m == nil || m[1] == "" {
+			continue
+		}
+		for _, label := range strings.Split(m[1], ",") {
+			if !stringInSlice(label, reservedLabels) {
+				t.Errorf("Label %s of %s should be reserved", label, descName(desc))
+			}
+		}
+	}
+}
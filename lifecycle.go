@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// The lifecycle API of --web.enable-lifecycle, shaped like the one of
+// Prometheus: POST reloadPath sets the exporter up again as SIGHUP does,
+// POST quitPath shuts it down as SIGTERM does.
+const (
+	reloadPath = "/-/reload"
+	quitPath   = "/-/quit"
+)
+
+// reload sets the exporter up again at the next collection, as at startup:
+// the pools are those of --pool again, dropping the changes made through the
+// admin API, the zpool features are probed and the details only read once,
+// such as the creation times, are fetched again. This picks up a zpool
+// upgraded since the exporter started. The file of --pool-labels.file is
+// read again right away.
+func (e *Exporter) reload() {
+	e.mutex.Lock()
+	e.wantPools = append([]string(nil), e.configPools...)
+	e.poolsChanged = true
+	e.reloading = true
+	e.mutex.Unlock()
+	if e.poolLabels != nil {
+		if err := e.poolLabels.load(e.configPools); err != nil {
+			log.Printf("Warning: keeping the previous pool labels: %s", err)
+		}
+	}
+}
+
+// reloadRequested reports whether reload was called since the last time it
+// was asked, for the collection.
+func (e *Exporter) reloadRequested() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	requested := e.reloading
+	e.reloading = false
+	return requested
+}
+
+// lifecyclePost wraps the handlers of the lifecycle API, which only accept
+// POST since they change the state of the exporter.
+func lifecyclePost(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed, use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// ServeReload handles reloadPath, wrapped in lifecyclePost.
+func (e *Exporter) ServeReload(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Setting the pools up again from the next scrape, requested through %s", reloadPath)
+	e.reload()
+	fmt.Fprintln(w, "setting the pools up again from the next scrape")
+}
+
+// serveQuit handles quitPath, wrapped in lifecyclePost, by sending on quit,
+// on which the main loop shuts the exporter down gracefully, letting the
+// response be sent.
+func serveQuit(quit chan<- struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case quit <- struct{}{}:
+		default: // already shutting down
+		}
+		fmt.Fprintln(w, "shutting down")
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeReload(t *testing.T) {
+	e := newMockExporter(t)
+	reload := lifecyclePost(e.ServeReload)
+	w := httptest.NewRecorder()
+	reload(w, httptest.NewRequest(http.MethodGet, reloadPath, nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "POST" {
+		t.Errorf("GET %s should not be allowed, got %d", reloadPath, w.Code)
+	}
+
+	e.ServeAdminPools(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, adminPoolsPath+"backup", nil))
+	e.snapshot(nil)
+	if got := poolNames(*e.zpools); len(got) != 1 {
+		t.Fatalf("Pool should be removed through the admin API, got %v", got)
+	}
+	e.pool.slowIOs = false // as if zpool was upgraded since the last probe
+
+	w = httptest.NewRecorder()
+	reload(w, httptest.NewRequest(http.MethodPost, reloadPath, nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Incorrect status of POST %s (%d), should be 200", reloadPath, w.Code)
+	}
+	e.snapshot(nil)
+	if got := poolNames(*e.zpools); len(got) != 2 || got[1] != "backup" {
+		t.Errorf("Reload should restore the pools of the command line, got %v", got)
+	}
+	if !e.pool.slowIOs || !e.available {
+		t.Errorf("Reload should set the exporter up again, probing zpool status -s")
+	}
+	if (*e.zpools)[1].creation == 0 {
+		t.Errorf("Restored pool should have its creation time")
+	}
+}
+
+func TestServeQuit(t *testing.T) {
+	quit := make(chan struct{}, 1)
+	h := lifecyclePost(serveQuit(quit))
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, quitPath, nil))
+	if w.Code != http.StatusMethodNotAllowed || len(quit) != 0 {
+		t.Errorf("GET %s should not be allowed, got %d", quitPath, w.Code)
+	}
+	for i := 0; i < 2; i++ {
+		w = httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodPost, quitPath, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("Incorrect status of POST %s (%d), should be 200", quitPath, w.Code)
+		}
+	}
+	if len(quit) != 1 {
+		t.Errorf("POST %s should request the shutdown once", quitPath)
+	}
+}
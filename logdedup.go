@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// logDedup keeps problems that persist across scrapes, such as a pool that
+// fails to collect, from logging the same message on every scrape. Each
+// problem has a key, such as "pool tank": its message is logged when it is
+// first seen or changes, and then again at most every window with the number
+// of repeats left out, which the suppressed counter also counts. A problem
+// that goes away is logged right away as well, by logResolved.
+type logDedup struct {
+	window     time.Duration // 0 logs every repeat
+	now        func() time.Time
+	suppressed prometheus.Counter
+
+	mutex    sync.Mutex
+	problems map[string]*loggedProblem
+}
+
+// loggedProblem is the message last logged for one key.
+type loggedProblem struct {
+	msg     string
+	at      time.Time
+	repeats int // left out since at
+}
+
+func newLogDedup(window time.Duration) *logDedup {
+	return &logDedup{
+		window: window,
+		now:    time.Now,
+		suppressed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "zfs_exporter_suppressed_log_messages_total",
+			Help: "Number of repeated log messages about a persisting problem that were left out, see --log.repeat-interval",
+		}),
+		problems: map[string]*loggedProblem{},
+	}
+}
+
+// logRepeats is the logDedup of logProblem and logResolved, replaced by run
+// with one of --log.repeat-interval.
+var logRepeats = newLogDedup(time.Hour)
+
+func (d *logDedup) register(reg prometheus.Registerer) error {
+	return reg.Register(d.suppressed)
+}
+
+// problem returns the message to log for the problem of key, or "" when it
+// is a repeat to leave out.
+func (d *logDedup) problem(key, msg string) string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	now := d.now()
+	p := d.problems[key]
+	if p == nil || p.msg != msg {
+		d.problems[key] = &loggedProblem{msg: msg, at: now}
+		return msg
+	}
+	if elapsed := now.Sub(p.at); elapsed < d.window {
+		p.repeats++
+		d.suppressed.Inc()
+		return ""
+	} else if p.repeats > 0 {
+		msg = fmt.Sprintf("%s (repeated %d more times in the last %s)", msg, p.repeats, elapsed.Round(time.Second))
+	}
+	p.at, p.repeats = now, 0
+	return msg
+}
+
+// resolved forgets the problem of key, reporting whether there was one.
+func (d *logDedup) resolved(key string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	_, ok := d.problems[key]
+	delete(d.problems, key)
+	return ok
+}
+
+// logProblem logs like logf a problem that may persist across scrapes,
+// identified by key, leaving out the repeats of the same message within
+// --log.repeat-interval.
+func logProblem(key string, fields logFields, format string, args ...interface{}) {
+	if msg := logRepeats.problem(key, fmt.Sprintf(format, args...)); msg != "" {
+		logEntry(fields, msg)
+	}
+}
+
+// logResolved logs like logf that the problem of key went away, such as
+// "Pool tank is collected again", if logProblem logged one.
+func logResolved(key string, fields logFields, format string, args ...interface{}) {
+	if logRepeats.resolved(key) {
+		logEntry(fields, fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLogDedup(t *testing.T) {
+	d := newLogDedup(time.Hour)
+	now := time.Unix(1700000000, 0)
+	d.now = func() time.Time { return now }
+	scrape := func(key, msg string) string {
+		now = now.Add(15 * time.Second)
+		return d.problem(key, msg)
+	}
+
+	if got := scrape("pool tank", "exit status 1"); got != "exit status 1" {
+		t.Errorf("First occurrence should be logged, got %q", got)
+	}
+	for i := 0; i < 3; i++ {
+		if got := scrape("pool tank", "exit status 1"); got != "" {
+			t.Errorf("Repeat should be left out, got %q", got)
+		}
+	}
+	if got := scrape("pool backup", "exit status 1"); got == "" {
+		t.Errorf("Problem of another key should be logged")
+	}
+	if got := testutil.ToFloat64(d.suppressed); got != 3 {
+		t.Errorf("Incorrect suppressed count (%v), should be 3", got)
+	}
+
+	now = now.Add(time.Hour)
+	if got := scrape("pool tank", "exit status 1"); !strings.Contains(got, "repeated 3 more times") {
+		t.Errorf("Repeat after the window should be logged with the count, got %q", got)
+	}
+	if got := scrape("pool tank", "exit status 2"); got != "exit status 2" {
+		t.Errorf("Changed message should be logged right away, got %q", got)
+	}
+
+	if !d.resolved("pool tank") || d.resolved("pool tank") {
+		t.Errorf("A logged problem should be resolved once")
+	}
+	if got := scrape("pool tank", "exit status 2"); got != "exit status 2" {
+		t.Errorf("Problem coming back should be logged, got %q", got)
+	}
+
+	d = newLogDedup(0)
+	d.problem("pool tank", "exit status 1")
+	if got := d.problem("pool tank", "exit status 1"); got != "exit status 1" {
+		t.Errorf("Every repeat should be logged without a window, got %q", got)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// logOutputs are the values of --log.output.
+var logOutputs = []string{"stderr", "syslog", "journal"}
+
+// Log priorities, as in syslog(3).
+const (
+	priorityErr     = 3
+	priorityWarning = 4
+	priorityInfo    = 6
+	priorityDebug   = 7
+)
+
+// logFields are structured fields of a log entry, such as POOL=tank, which
+// the journal stores alongside the message so that it can be filtered on.
+type logFields map[string]string
+
+// logSink sends log entries to syslog or the journal instead of stderr.
+type logSink interface {
+	send(priority int, msg string, fields logFields) error
+	Close() error
+}
+
+// sink is where log entries go, nil for stderr through the log package.
+var sink logSink
+
+// logPriority derives the priority of a log message from the way the
+// exporter words them: "Warning: ...", "Error ..." or "Debug: ...".
+func logPriority(msg string) int {
+	switch {
+	case strings.HasPrefix(msg, "Error"), strings.HasPrefix(msg, "Could not"):
+		return priorityErr
+	case strings.HasPrefix(msg, "Warning"):
+		return priorityWarning
+	case strings.HasPrefix(msg, "Debug"):
+		return priorityDebug
+	}
+	return priorityInfo
+}
+
+// logf logs like log.Printf, with fields for the journal. Other outputs only
+// get the message, which names the pool or collector as well.
+func logf(fields logFields, format string, args ...interface{}) {
+	logEntry(fields, fmt.Sprintf(format, args...))
+}
+
+// logEntry logs msg with fields for logf and the functions like it, which
+// have the caller to report two calls up.
+func logEntry(fields logFields, msg string) {
+	if sink == nil || sink.send(logPriority(msg), msg, fields) != nil {
+		log.Output(3, msg)
+	}
+}
+
+// sinkWriter passes the output of the log package on to the sink, one entry
+// per call as log.Logger writes them.
+type sinkWriter struct{}
+
+func (sinkWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	if err := sink.send(logPriority(msg), msg, nil); err != nil {
+		// Rather lose the structure than the message.
+		fmt.Fprintln(os.Stderr, msg)
+	}
+	return len(p), nil
+}
+
+// setupLogging sends the log to output, one of logOutputs, tagged with tag.
+// facility is the syslog facility, such as daemon or local0. The returned
+// function restores logging to stderr, after logging err through the sink
+// when it is not nil, since the reason the exporter stopped would otherwise
+// only show up on stderr.
+func setupLogging(output, facility, tag string) (func(err error), error) {
+	var err error
+	switch output {
+	case "stderr":
+		return func(error) {}, nil
+	case "syslog":
+		sink, err = newSyslogSink(facility, tag)
+	case "journal":
+		sink, err = newJournalSink(journalSocket, tag)
+	default:
+		return nil, fmt.Errorf("--log.output should be one of %s", strings.Join(logOutputs, ", "))
+	}
+	if err != nil {
+		sink = nil
+		return nil, fmt.Errorf("could not log to %s: %s", output, err)
+	}
+	flags := log.Flags()
+	log.SetFlags(0) // syslog and the journal add the time themselves
+	log.SetOutput(sinkWriter{})
+	return func(err error) {
+		if err != nil {
+			log.Print("Error: ", err)
+		}
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+		sink.Close()
+		sink = nil
+	}, nil
+}
+
+// journalSocket is where journald receives entries in its native protocol.
+const journalSocket = "/run/systemd/journal/socket"
+
+// encodeJournal encodes a journal entry in the native protocol of
+// systemd.journal-fields(7): KEY=value lines, or for values spanning several
+// lines the key, a newline, the little-endian 64-bit length and the value.
+func encodeJournal(priority int, msg, tag string, fields logFields) []byte {
+	var b bytes.Buffer
+	field := func(key, value string) {
+		if !strings.Contains(value, "\n") {
+			fmt.Fprintf(&b, "%s=%s\n", key, value)
+			return
+		}
+		b.WriteString(key + "\n")
+		binary.Write(&b, binary.LittleEndian, uint64(len(value)))
+		b.WriteString(value + "\n")
+	}
+	field("MESSAGE", msg)
+	field("PRIORITY", fmt.Sprint(priority))
+	field("SYSLOG_IDENTIFIER", tag)
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		field(key, fields[key])
+	}
+	return b.Bytes()
+}
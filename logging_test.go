@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogPriority(t *testing.T) {
+	for msg, want := range map[string]int{
+		"Error collecting pool tank: exit status 1":        priorityErr,
+		"Could not get pool creation times: exit status 1": priorityErr,
+		"Warning: pool tank is listed more than once":      priorityWarning,
+		"Debug: zpool status -s is supported":              priorityDebug,
+		"Monitoring pools tank":                            priorityInfo,
+	} {
+		if got := logPriority(msg); got != want {
+			t.Errorf("Incorrect priority of %q (%d), should be %d", msg, got, want)
+		}
+	}
+}
+
+func TestEncodeJournal(t *testing.T) {
+	got := encodeJournal(priorityErr, "Error collecting pool tank", "zfs", logFields{"POOL": "tank", "COLLECTOR": "pool"})
+	want := "MESSAGE=Error collecting pool tank\nPRIORITY=3\nSYSLOG_IDENTIFIER=zfs\nCOLLECTOR=pool\nPOOL=tank\n"
+	if string(got) != want {
+		t.Errorf("Incorrect journal entry %q, should be %q", got, want)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("MESSAGE\n")
+	binary.Write(&b, binary.LittleEndian, uint64(len("two\nlines")))
+	b.WriteString("two\nlines\nPRIORITY=6\nSYSLOG_IDENTIFIER=zfs\n")
+	if got := encodeJournal(priorityInfo, "two\nlines", "zfs", nil); !bytes.Equal(got, b.Bytes()) {
+		t.Errorf("Incorrect journal entry %q for a message over two lines, should be %q", got, b.Bytes())
+	}
+}
+
+func TestSetupLoggingErrors(t *testing.T) {
+	for _, test := range []struct{ output, facility string }{
+		{"file", "daemon"},
+		{"syslog", "local9"},
+	} {
+		if _, err := setupLogging(test.output, test.facility, "zfs"); err == nil {
+			t.Errorf("--log.output %s with facility %s should produce error in setupLogging", test.output, test.facility)
+		}
+		if sink != nil {
+			t.Errorf("Failing setupLogging should leave logging to stderr")
+		}
+	}
+	if _, err := newJournalSink(t.TempDir()+"/missing", "zfs"); err == nil {
+		t.Errorf("Missing journal socket should produce error in newJournalSink")
+	}
+}
+
+// fakeSink records the entries sent to it.
+type fakeSink struct {
+	entries []string
+}
+
+func (s *fakeSink) send(priority int, msg string, fields logFields) error {
+	s.entries = append(s.entries, string(encodeJournal(priority, msg, "test", fields)))
+	return nil
+}
+
+func (s *fakeSink) Close() error { return nil }
+
+func TestLogSink(t *testing.T) {
+	fake := &fakeSink{}
+	sink = fake
+	log.SetOutput(sinkWriter{})
+	log.SetFlags(0)
+	defer func() {
+		sink = nil
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+	logf(logFields{"POOL": "tank"}, "Error collecting pool %s: %s", "tank", "exit status 1")
+	log.Print("Monitoring pools tank")
+	if len(fake.entries) != 2 {
+		t.Fatalf("Incorrect number of entries (%d), should be 2", len(fake.entries))
+	}
+	if !strings.Contains(fake.entries[0], "PRIORITY=3\n") || !strings.Contains(fake.entries[0], "POOL=tank\n") {
+		t.Errorf("Incorrect pool entry %q", fake.entries[0])
+	}
+	if !strings.Contains(fake.entries[1], "MESSAGE=Monitoring pools tank\nPRIORITY=6\n") {
+		t.Errorf("Incorrect entry from the log package %q", fake.entries[1])
+	}
+}
+
+func TestJournalSink(t *testing.T) {
+	path := t.TempDir() + "/journal"
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("Cannot listen on a unix socket (%s)", err)
+	}
+	defer conn.Close()
+	s, err := newJournalSink(path, "zfs")
+	if err != nil {
+		t.Skipf("No journal sink here (%s)", err)
+	}
+	defer s.Close()
+	if err := s.send(priorityWarning, "Warning: disabling the arc collector", logFields{"COLLECTOR": "arc"}); err != nil {
+		t.Fatalf("Error in send (%s)", err)
+	}
+	buf := make([]byte, 256)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil || !strings.HasSuffix(string(buf[:n]), "SYSLOG_IDENTIFIER=zfs\nCOLLECTOR=arc\n") {
+		t.Errorf("Incorrect journal entry %q (%v)", buf[:n], err)
+	}
+	if err := s.send(priorityInfo, "x", logFields{"pool": "tank"}); err == nil {
+		t.Errorf("Lowercase field should produce error in send")
+	}
+}
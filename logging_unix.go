@@ -0,0 +1,87 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+	"net"
+	"runtime"
+	"strings"
+)
+
+// syslogFacilities are the facilities --log.syslog-facility accepts.
+var syslogFacilities = map[string]syslog.Priority{
+	"kern": syslog.LOG_KERN, "user": syslog.LOG_USER, "mail": syslog.LOG_MAIL,
+	"daemon": syslog.LOG_DAEMON, "auth": syslog.LOG_AUTH, "syslog": syslog.LOG_SYSLOG,
+	"lpr": syslog.LOG_LPR, "news": syslog.LOG_NEWS, "uucp": syslog.LOG_UUCP,
+	"cron": syslog.LOG_CRON, "authpriv": syslog.LOG_AUTHPRIV, "ftp": syslog.LOG_FTP,
+	"local0": syslog.LOG_LOCAL0, "local1": syslog.LOG_LOCAL1, "local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3, "local4": syslog.LOG_LOCAL4, "local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6, "local7": syslog.LOG_LOCAL7,
+}
+
+// syslogSink logs to the local syslog daemon.
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func newSyslogSink(facility, tag string) (logSink, error) {
+	f, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", facility)
+	}
+	w, err := syslog.New(f|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return syslogSink{w}, nil
+}
+
+func (s syslogSink) send(priority int, msg string, _ logFields) error {
+	switch priority {
+	case priorityErr:
+		return s.w.Err(msg)
+	case priorityWarning:
+		return s.w.Warning(msg)
+	case priorityDebug:
+		return s.w.Debug(msg)
+	}
+	return s.w.Info(msg)
+}
+
+func (s syslogSink) Close() error {
+	return s.w.Close()
+}
+
+// journalSink logs to journald, with the fields of each entry.
+type journalSink struct {
+	conn *net.UnixConn
+	tag  string
+}
+
+func newJournalSink(socket, tag string) (logSink, error) {
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("the journal is only available on Linux")
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journalSink{conn: conn, tag: tag}, nil
+}
+
+func (s *journalSink) send(priority int, msg string, fields logFields) error {
+	for key := range fields {
+		if strings.Trim(key, "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_") != "" {
+			return fmt.Errorf("invalid journal field %q", key)
+		}
+	}
+	_, err := s.conn.Write(encodeJournal(priority, msg, s.tag, fields))
+	return err
+}
+
+func (s *journalSink) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,13 @@
+package main
+
+import "errors"
+
+// Windows has neither syslog nor the journal.
+
+func newSyslogSink(facility, tag string) (logSink, error) {
+	return nil, errors.New("syslog is not supported on Windows")
+}
+
+func newJournalSink(socket, tag string) (logSink, error) {
+	return nil, errors.New("the journal is not supported on Windows")
+}
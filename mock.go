@@ -0,0 +1,513 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+// mockFS holds the fixtures -mock serves: the output of the zpool and zfs
+// commands for two pools, tank and backup, a pool offsite that is not
+// imported, and the kstat and kmem files.
+//
+//go:embed mock
+var mockFS embed.FS
+
+// mockPools are the pools monitored by default with -mock, backup tagged
+// with its role.
+const mockPools = "tank,backup:backup"
+
+// enableMock turns on every collector and option the fixtures have data for
+// and monitors mockPools. Flags given on the command line are left alone, so
+// that -mock can be combined with -pool backup or -collector.iostat=false.
+func enableMock(fs *flag.FlagSet) {
+	for name, check := range map[string]*bool{
+		"collector.dataset":           &datasetsCheck,
+		"collector.snapshot":          &snapshotCheck,
+		"collect-bookmarks":           &bookmarkCheck,
+		"collector.dataset-io":        &datasetIOCheck,
+		"collector.arc":               &arcCheck,
+		"collector.kmem":              &kmemCheck,
+		"collector.dbuf":              &dbufCheck,
+		"collector.iostat":            &iostatCheck,
+		"collector.iostat.per-device": &iostatDeviceCheck,
+		"collector.request-sizes":     &requestSizeCheck,
+		"collector.import":            &importCheck,
+		"collector.cachefile":         &cachefileCheck,
+		"collector.history":           &historyCheck,
+		"collector.events":            &eventsCheck,
+		"collect-pool-counts":         &countsCheck,
+		"collect-pool-snapshot-space": &snapSpaceCheck,
+		"collect-dedup":               &dedupCheck,
+		"collect-vdevs":               &vdevsCheck,
+		"collect-activities":          &activityCheck,
+		"collect-device-guids":        &guidsCheck,
+		"collect-full-eta":            &fullETACheck,
+	} {
+		if !fs.Changed(name) {
+			*check = true
+		}
+	}
+	if !fs.Changed("pool") {
+		zfsPool = []string{mockPools}
+	}
+	if !fs.Changed("dataset-types") {
+		dsTypes = "filesystem,volume,snapshot"
+	}
+	if !fs.Changed("expected-providers") {
+		expectedProviders = labelFlag{"tank=7", "backup=3"}
+	}
+	if !fs.Changed("collect-permanent-errors") {
+		errorEntries = 10
+	}
+	if !fs.Changed("userspace-datasets") {
+		spaceDatasets = "tank/home"
+	}
+	if !fs.Changed("collector.module-parameters") {
+		moduleParams = "zfs_arc_max,zfs_txg_timeout,zfs_vdev_raidz_impl"
+	}
+}
+
+// mockRunner answers the zpool and zfs commands the collectors run from the
+// embedded fixtures instead of running them, so that dashboards and alerts
+// can be developed on a machine without ZFS. It understands the flags the
+// exporter passes, not every flag of the real commands.
+type mockRunner struct{}
+
+func (mockRunner) run(name string, args ...string) (string, error) {
+	if len(args) == 0 {
+		return "", fmt.Errorf("mock: %s needs a subcommand", name)
+	}
+	switch name + " " + args[0] {
+	case "zpool list":
+		return mockZpoolList(args[1:])
+	case "zpool status":
+		return mockZpoolStatus(args[1:])
+	case "zpool get":
+		return mockGet("zpool-get.tsv", args[1:])
+	case "zpool iostat":
+		return mockIostat(args[1:])
+	case "zpool import":
+		b, err := mockFS.ReadFile("mock/zpool-import.txt")
+		return string(b), err
+	case "zpool events":
+		b, err := mockFS.ReadFile("mock/zpool-events.txt")
+		return string(b), err
+	case "zpool history":
+		_, operands := mockArgs(args[1:], "")
+		if len(operands) != 1 {
+			return "missing pool argument\n", fmt.Errorf("mock: history needs one pool")
+		}
+		b, err := mockFS.ReadFile("mock/zpool-history-" + operands[0] + ".txt")
+		return string(b), err
+	case "zdb -C":
+		// Only backup is missing from the cache file, as if it had
+		// been imported with -o cachefile=none.
+		if flags, operands := mockArgs(args[1:], "U"); flags["U"] != "" && len(operands) == 0 {
+			b, err := mockFS.ReadFile("mock/zdb-cachefile.txt")
+			return string(b), err
+		}
+	case "zfs get":
+		return mockGet("zfs-get.tsv", args[1:])
+	case "zfs list":
+		return mockZfsList(args[1:])
+	case "zfs version":
+		return "zfs-2.2.2-1\nzfs-kmod-2.2.2-1\n", nil
+	case "zfs userspace", "zfs groupspace", "zfs projectspace":
+		return mockSpace(args[0], args[1:])
+	}
+	// zdb -C of a pool among others: the collectors fall back to what
+	// zpool shows.
+	return fmt.Sprintf("%s %s: not available in mock mode\n", name, args[0]),
+		fmt.Errorf("mock: %s %s is not supported", name, args[0])
+}
+
+func (r mockRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	output, err := r.run(name, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", err, strings.TrimSpace(output))
+	}
+	return io.NopCloser(strings.NewReader(output)), nil
+}
+
+// mockArgs splits command arguments into flags and operands. The flags in
+// withValue take the next argument as their value, the others are set to "".
+// Combined flags such as -Hp are split into H and p.
+func mockArgs(args []string, withValue string) (map[string]string, []string) {
+	flags := map[string]string{}
+	var operands []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if len(arg) < 2 || arg[0] != '-' {
+			operands = append(operands, arg)
+			continue
+		}
+		for _, c := range arg[1:] {
+			flags[string(c)] = ""
+			if strings.ContainsRune(withValue, c) && i+1 < len(args) {
+				i++
+				flags[string(c)] = args[i]
+			}
+		}
+	}
+	return flags, operands
+}
+
+// mockTable is a tab separated fixture with a header row.
+type mockTable struct {
+	columns []string
+	rows    [][]string
+}
+
+func loadMockTable(file string) (mockTable, error) {
+	b, err := mockFS.ReadFile(path.Join("mock", file))
+	if err != nil {
+		return mockTable{}, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	t := mockTable{columns: strings.Split(lines[0], "\t")}
+	for _, line := range lines[1:] {
+		t.rows = append(t.rows, strings.Split(line, "\t"))
+	}
+	return t, nil
+}
+
+// value returns the column of row, or "-" when the fixture does not have it.
+func (t mockTable) value(row []string, column string) string {
+	for i, c := range t.columns {
+		if c == column && i < len(row) {
+			return row[i]
+		}
+	}
+	return "-"
+}
+
+// format prints the columns of rows like the -H -o output of zpool and zfs.
+func (t mockTable) format(rows [][]string, columns []string) string {
+	var b strings.Builder
+	for _, row := range rows {
+		for i, column := range columns {
+			if i > 0 {
+				b.WriteByte('\t')
+			}
+			b.WriteString(t.value(row, column))
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// mockSelectPools returns the rows of t for the named pools, or all rows when
+// no pools are named. Unknown pools fail with the message zpool prints, and
+// like zpool the rows of the known ones are still returned.
+func mockSelectPools(t mockTable, names []string) ([][]string, string, error) {
+	if len(names) == 0 {
+		return t.rows, "", nil
+	}
+	var rows [][]string
+	var msg string
+	var err error
+	for _, arg := range names {
+		for _, name := range strings.Split(arg, ",") {
+			found := false
+			for _, row := range t.rows {
+				if t.value(row, "name") == name {
+					rows = append(rows, row)
+					found = true
+				}
+			}
+			if !found {
+				msg += fmt.Sprintf("cannot open '%s': no such pool\n", name)
+				err = fmt.Errorf("mock: no such pool %s", name)
+			}
+		}
+	}
+	return rows, msg, err
+}
+
+// mockZpoolList answers zpool list. Like zpool it lists the pools that exist
+// after the errors for those that do not.
+func mockZpoolList(args []string) (string, error) {
+	flags, operands := mockArgs(args, "o")
+	t, err := loadMockTable("zpool-list.tsv")
+	if err != nil {
+		return "", err
+	}
+	rows, msg, err := mockSelectPools(t, operands)
+	if len(rows) == 0 {
+		return msg, err
+	}
+	if _, ok := flags["v"]; ok {
+		output, vErr := mockVdevList("zpool-list-v.txt", t, rows)
+		if vErr != nil {
+			return "", vErr
+		}
+		return msg + output, err
+	}
+	columns, ok := flags["o"]
+	if !ok {
+		header := "NAME\tSIZE\tALLOC\tFREE\tCAP\tHEALTH\n"
+		return msg + header + t.format(rows, []string{"name", "size", "alloc", "free", "cap", "health"}), err
+	}
+	return msg + t.format(rows, strings.Split(columns, ",")), err
+}
+
+// mockVdevList returns the sections of file, a fixture with the vdev rows of
+// each pool below the line that starts with its name, of the pools in rows.
+func mockVdevList(file string, t mockTable, rows [][]string) (string, error) {
+	b, err := mockFS.ReadFile("mock/" + file)
+	if err != nil {
+		return "", err
+	}
+	pools := map[string]bool{}
+	wanted := map[string]bool{}
+	for _, row := range t.rows {
+		pools[t.value(row, "name")] = true
+	}
+	for _, row := range rows {
+		wanted[t.value(row, "name")] = true
+	}
+	var out strings.Builder
+	keep := false
+	for _, line := range strings.SplitAfter(string(b), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && pools[fields[0]] && !strings.HasPrefix(line, "\t") {
+			keep = wanted[fields[0]]
+		}
+		if keep {
+			out.WriteString(line)
+		}
+	}
+	return out.String(), nil
+}
+
+func mockZpoolStatus(args []string) (string, error) {
+	flags, operands := mockArgs(args, "")
+	t, err := loadMockTable("zpool-list.tsv")
+	if err != nil {
+		return "", err
+	}
+	rows, msg, err := mockSelectPools(t, operands)
+	if err != nil {
+		return msg, err
+	}
+	_, healthyOnly := flags["x"]
+	_, guids := flags["g"]
+	var out strings.Builder
+	for _, row := range rows {
+		name := t.value(row, "name")
+		if healthyOnly && t.value(row, "health") == "ONLINE" {
+			fmt.Fprintf(&out, "pool '%s' is healthy\n", name)
+			continue
+		}
+		b, err := mockFS.ReadFile("mock/zpool-status-" + name + ".txt")
+		if err != nil {
+			return "", err
+		}
+		if guids {
+			b = []byte(mockGUIDs(string(b), name))
+		}
+		out.Write(b)
+		out.WriteByte('\n')
+	}
+	return out.String(), nil
+}
+
+// mockGUIDs replaces the names of the vdevs in the config section of the
+// zpool status of pool with made up GUIDs, as zpool status -g prints them.
+// The GUID of a vdev is a hash of its name, so it is the same for every
+// scrape.
+func mockGUIDs(status, pool string) string {
+	var out strings.Builder
+	var p configParser
+	for lines := newLineScanner(status); lines.scan(); {
+		line := lines.line
+		fields := strings.Fields(line)
+		p.line(line, fields)
+		if p.inConfig && !p.done && len(fields) > 0 && p.last != nil && p.last.name == fields[0] &&
+			fields[0] != pool && !stringInSlice(fields[0], vdevClasses) {
+			h := fnv.New64a()
+			h.Write([]byte(pool + "/" + fields[0]))
+			line = strings.Replace(line, fields[0], strconv.FormatUint(h.Sum64(), 10), 1)
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.String()
+}
+
+// mockGet answers zpool get and zfs get from a fixture of name, property and
+// value rows: get -H[p] -o <columns> <properties> <names>...
+func mockGet(file string, args []string) (string, error) {
+	flags, operands := mockArgs(args, "o")
+	if len(operands) == 0 {
+		return "missing property argument\n", fmt.Errorf("mock: get needs properties")
+	}
+	t, err := loadMockTable(file)
+	if err != nil {
+		return "", err
+	}
+	names := operands[1:]
+	var rows [][]string
+	for _, name := range names {
+		for _, property := range strings.Split(operands[0], ",") {
+			for _, row := range t.rows {
+				if t.value(row, "name") == name && t.value(row, "property") == property {
+					rows = append(rows, row)
+				}
+			}
+		}
+	}
+	columns := "name,property,value"
+	if o, ok := flags["o"]; ok {
+		columns = o
+	}
+	return t.format(rows, strings.Split(columns, ",")), nil
+}
+
+// mockIostat answers zpool iostat [-v] -Hp <pools>... <interval> <count>
+// with one report per pool, and zpool iostat -r -p <pools>... with the
+// request size histograms of each.
+func mockIostat(args []string) (string, error) {
+	flags, operands := mockArgs(args, "")
+	var names []string
+	for _, operand := range operands {
+		if _, err := strconv.Atoi(operand); err != nil {
+			names = append(names, operand)
+		}
+	}
+	t, err := loadMockTable("zpool-iostat.tsv")
+	if err != nil {
+		return "", err
+	}
+	rows, msg, err := mockSelectPools(t, names)
+	if err != nil {
+		return msg, err
+	}
+	if _, ok := flags["r"]; ok {
+		return mockVdevList("zpool-iostat-r.txt", t, rows)
+	}
+	if _, ok := flags["v"]; ok {
+		return mockVdevList("zpool-iostat-v.txt", t, rows)
+	}
+	return t.format(rows, t.columns), nil
+}
+
+// mockZfsList answers zfs list -H[p] -o <columns> -t <types> [-r | -d <depth>]
+// <datasets>..., listing the datasets below each of them.
+func mockZfsList(args []string) (string, error) {
+	flags, operands := mockArgs(args, "otd")
+	t, err := loadMockTable("zfs-list.tsv")
+	if err != nil {
+		return "", err
+	}
+	types := "filesystem,volume"
+	if v, ok := flags["t"]; ok {
+		types = v
+	}
+	depth := -1
+	if v, ok := flags["d"]; ok {
+		if depth, err = strconv.Atoi(v); err != nil {
+			return "invalid depth\n", err
+		}
+	}
+	var rows [][]string
+	for _, row := range t.rows {
+		if !stringInSlice(t.value(row, "type"), strings.Split(types, ",")) {
+			continue
+		}
+		name := t.value(row, "name")
+		for _, operand := range operands {
+			if mockBelow(name, operand, depth) {
+				rows = append(rows, row)
+				break
+			}
+		}
+	}
+	columns := "name"
+	if o, ok := flags["o"]; ok {
+		columns = o
+	}
+	return t.format(rows, strings.Split(columns, ",")), nil
+}
+
+// mockBelow reports whether name is dataset or below it, at most depth levels
+// down when depth is not negative. Snapshots and bookmarks are one level below
+// their dataset.
+func mockBelow(name, dataset string, depth int) bool {
+	if name != dataset && !strings.HasPrefix(name, dataset+"/") &&
+		!strings.HasPrefix(name, dataset+"@") && !strings.HasPrefix(name, dataset+"#") {
+		return false
+	}
+	rel := strings.TrimPrefix(name, dataset)
+	levels := strings.Count(rel, "/")
+	if strings.ContainsAny(rel, "@#") {
+		levels++
+	}
+	return depth < 0 || levels <= depth
+}
+
+// mockSpace answers zfs userspace, groupspace and projectspace -H[p] -o
+// <columns> <dataset>.
+func mockSpace(command string, args []string) (string, error) {
+	flags, operands := mockArgs(args, "o")
+	if len(operands) != 1 {
+		return "missing dataset argument\n", fmt.Errorf("mock: %s needs one dataset", command)
+	}
+	t, err := loadMockTable("zfs-space.tsv")
+	if err != nil {
+		return "", err
+	}
+	var rows [][]string
+	for _, row := range t.rows {
+		if t.value(row, "kind") == command && t.value(row, "dataset") == operands[0] {
+			rows = append(rows, row)
+		}
+	}
+	columns := "name,used,quota"
+	if o, ok := flags["o"]; ok {
+		columns = o
+	}
+	return t.format(rows, strings.Split(columns, ",")), nil
+}
+
+// extractMockFiles writes the embedded kstat, kmem and module parameter
+// fixtures to a new temporary directory and points kstatDir, kmemSlabPath and
+// moduleParamsDir at them. The
+// caller removes the returned directory.
+func extractMockFiles() (string, error) {
+	dir, err := os.MkdirTemp("", "prometheus-zfs-mock")
+	if err != nil {
+		return "", err
+	}
+	err = fs.WalkDir(mockFS, "mock", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := mockFS.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(target, b, 0644)
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	kstatDir = filepath.Join(dir, "mock", "kstat")
+	kmemSlabPath = filepath.Join(dir, "mock", "kmem", "slab")
+	moduleParamsDir = filepath.Join(dir, "mock", "parameters")
+	return dir, nil
+}
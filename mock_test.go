@@ -0,0 +1,156 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// mockUnavailable are the described metrics -mock cannot show: properties
+// zfs reports as "-" for the dataset type, transitions, which need the state
+// of a pool to change between scrapes, as does the growth of its used space,
+// and the progress of a vdev removal.
+var mockUnavailable = map[string]bool{
+	"zpool_state_transitions_total":             true,
+	"zpool_removing_bytes":                      true,
+	"zpool_removal_in_progress":                 true,
+	"zpool_removal_copied_bytes":                true,
+	"zpool_removal_total_bytes":                 true,
+	"zpool_usable_growth_bytes_per_second":      true,
+	"zpool_full_eta_seconds":                    true,
+	"zfs_volume_quota_bytes":                    true,
+	"zfs_volume_mounted":                        true,
+	"zfs_snapshot_available_bytes":              true,
+	"zfs_snapshot_quota_bytes":                  true,
+	"zfs_snapshot_used_by_dataset_bytes":        true,
+	"zfs_snapshot_used_by_snapshots_bytes":      true,
+	"zfs_snapshot_used_by_children_bytes":       true,
+	"zfs_snapshot_used_by_refreservation_bytes": true,
+	"zfs_snapshot_reservation_bytes":            true,
+	"zfs_snapshot_refreservation_bytes":         true,
+	"zfs_snapshot_mounted":                      true,
+	"zfs_snapshot_sync_disabled":                true,
+	"zfs_volume_filesystem_limit":               true,
+	"zfs_volume_filesystem_count":               true,
+	"zfs_snapshot_filesystem_limit":             true,
+	"zfs_snapshot_filesystem_count":             true,
+	"zfs_snapshot_snapshot_limit":               true,
+	"zfs_snapshot_snapshot_limit_count":         true,
+	"zfs_dataset_volblocksize_bytes":            true,
+	"zfs_volume_recordsize_bytes":               true,
+	"zfs_snapshot_recordsize_bytes":             true,
+	"zfs_snapshot_volblocksize_bytes":           true,
+}
+
+// newMockExporter returns an exporter of the mock pools with every collector
+// enabled, like -mock sets it up.
+func newMockExporter(t *testing.T) *Exporter {
+	t.Helper()
+	return newMockExporterWith(t, mockRunner{})
+}
+
+// newMockExporterWith returns the exporter of newMockExporter running r,
+// which answers like the mock runner.
+func newMockExporterWith(t *testing.T, r commandRunner) *Exporter {
+	t.Helper()
+	oldDir, oldSlab, oldParams := kstatDir, kmemSlabPath, moduleParamsDir
+	dir, err := extractMockFiles()
+	if err != nil {
+		t.Fatalf("Error in extractMockFiles (%s)", err)
+	}
+	t.Cleanup(func() {
+		kstatDir, kmemSlabPath, moduleParamsDir = oldDir, oldSlab, oldParams
+		os.RemoveAll(dir)
+	})
+
+	pools := parsePools(mockPools)
+	e := NewExporter(&pools)
+	e.runner = r
+	e.pool = poolOptions{dedup: true, vdevs: true, activities: true, errorEntries: 10,
+		expectedProviders: map[string]int64{"tank": 7, "backup": 3}, tags: map[string]string{"backup": "backup"}}
+	e.fatal = make(chan error, 1)
+	e.fullETA = &etaTracker{window: time.Hour}
+	if err := e.setup(); err != nil {
+		t.Fatalf("Error in setup (%s)", err)
+	}
+	filter, _ := newDatasetFilter("", "")
+	e.addCollector("datasets", newDatasetCollector(datasetOptions{
+		filter:   filter,
+		maxDepth: -1,
+		types:    []string{"filesystem", "volume", "snapshot"},
+	}))
+	e.addCollector("snapshots", newSnapshotCollector(filter, true, 0))
+	userspace := newSpaceCollector([]string{"tank/home"})
+	if !userspace.enableProjects(e.caps.projectspace) {
+		t.Errorf("Mock zfs should support projectspace")
+	}
+	e.addCollector("userspace", userspace)
+	e.addCollector("pool-counts", poolCountCollector{})
+	e.addCollector("dataset-io", &objsetCollector{filter: filter})
+	e.addCollector("arc", newARCCollector())
+	e.addCollector("kmem", newKmemCollector(10).withoutDbufSize())
+	e.addCollector("dbuf", newDbufCollector())
+	params, err := newParamsCollector([]string{"zfs_arc_max", "zfs_txg_timeout", "zfs_vdev_raidz_impl"})
+	if err != nil {
+		t.Fatalf("Error in newParamsCollector (%s)", err)
+	}
+	e.addCollector("module-parameters", params)
+	e.addCollector("iostat", &iostatCollector{interval: 1, perDevice: true})
+	if !e.caps.requestSizes {
+		t.Errorf("Mock zpool iostat should support -r")
+	}
+	e.addCollector("request-sizes", requestSizeCollector{caps: &e.caps})
+	imports := &importCollector{interval: time.Minute}
+	imports.scan(e.runner)
+	e.addCollector("import", imports)
+	e.addCollector("cachefile", cachefileCollector{})
+	events := newEventsCollector(time.Minute)
+	events.poll(e.runner)
+	e.addCollector("events", events)
+	e.deviceErrors.events = events
+	return e
+}
+
+// TestMockMode checks that every metric the collectors describe has data in
+// the mock fixtures, so that -mock shows dashboards something for each.
+func TestMockMode(t *testing.T) {
+	e := newMockExporter(t)
+	reg := prometheus.NewRegistry()
+	if err := e.Register(reg); err != nil {
+		t.Fatalf("Error in Register (%s)", err)
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Error in Gather (%s)", err)
+	}
+	gathered := map[string]bool{}
+	for _, family := range families {
+		gathered[family.GetName()] = len(family.GetMetric()) > 0
+	}
+	descs := make(chan *prometheus.Desc, 1000)
+	e.Describe(descs)
+	close(descs)
+	for desc := range descs {
+		if name := descName(desc); !gathered[name] && !mockUnavailable[name] {
+			t.Errorf("Mock mode should export %s", name)
+		}
+	}
+}
+
+func TestMockRunner(t *testing.T) {
+	if err := checkExistance(mockRunner{}, "tank,missing"); err == nil {
+		t.Errorf("Unknown pool should produce error in checkExistance")
+	}
+	output, err := mockRunner{}.run("zfs", "list", "-H", "-o", "name", "-t", "filesystem,snapshot", "-d", "1", "tank")
+	if err != nil {
+		t.Fatalf("Error in zfs list (%s)", err)
+	}
+	if want := "tank\ntank/home\ntank/vm\n"; output != want {
+		t.Errorf("Incorrect zfs list output %q, should be %q", output, want)
+	}
+	if _, err := (mockRunner{}).run("zdb", "-C", "tank"); err == nil {
+		t.Errorf("zdb should produce error in mock mode")
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// metricRename maps a metric name exported with -metrics.version=1, the
+// names the collectors use, onto the name following the Prometheus naming
+// conventions exported with -metrics.version=2.
+type metricRename struct {
+	v1, v2 string
+	// scale multiplies the value when not 0, such as 0.01 for a percentage
+	// that becomes a ratio.
+	scale float64
+	// label and value are added to the metric, so that several version 1
+	// metrics become one version 2 metric told apart by the label.
+	label, value string
+	// help replaces the version 1 help when set, which merged metrics need.
+	help string
+}
+
+// metricRenames are the metrics whose names differ between the versions; the
+// others, such as zfs_arc_size_bytes, already follow the conventions and are
+// exported as they are.
+var metricRenames = []metricRename{
+	{v1: "zpool_activity_in_progress", v2: "zfs_pool_activity_in_progress"},
+	{v1: "zpool_activity_percent_done", v2: "zfs_pool_activity_progress_ratio", scale: 0.01,
+		help: "Progress of the initialize, remove or trim in progress on the zpool from 0 to 1, averaged over its vdevs"},
+	{v1: "zpool_allocated_bytes", v2: "zfs_pool_allocated_bytes"},
+	{v1: "zpool_cache_devices_unavailable_count", v2: "zfs_pool_cache_devices_unavailable"},
+	{v1: "zpool_capacity_percentage", v2: "zfs_pool_capacity_ratio", scale: 0.01,
+		help: "Current zpool capacity level from 0 to 1"},
+	{v1: "zpool_capacity_ratio", v2: "zfs_pool_allocated_ratio"},
+	{v1: "zpool_config_info", v2: "zfs_pool_config_info"},
+	{v1: "zpool_configured_providers_count", v2: "zfs_pool_configured_providers"},
+	{v1: "zpool_creation_timestamp_seconds", v2: "zfs_pool_creation_timestamp_seconds"},
+	{v1: "zpool_ddt_entries", v2: "zfs_pool_dedup_table_entries"},
+	{v1: "zpool_ddt_size_bytes_in_core", v2: "zfs_pool_dedup_table_in_core_bytes"},
+	{v1: "zpool_ddt_size_bytes_on_disk", v2: "zfs_pool_dedup_table_on_disk_bytes"},
+	{v1: "zpool_device_checksum_errors_observed_total", v2: "zfs_pool_device_checksum_errors_observed_total"},
+	{v1: "zpool_device_error_counter_resets_total", v2: "zfs_pool_device_error_resets_total"},
+	{v1: "zpool_device_initialize_in_progress", v2: "zfs_pool_device_initialize_in_progress"},
+	{v1: "zpool_device_initialize_percent_done", v2: "zfs_pool_device_initialize_progress_ratio", scale: 0.01,
+		help: "Progress of the last zpool initialize of the device from 0 to 1, absent for devices never initialized"},
+	{v1: "zpool_device_last_initialize_timestamp_seconds", v2: "zfs_pool_device_last_initialize_timestamp_seconds"},
+	{v1: "zpool_device_read_errors_observed_total", v2: "zfs_pool_device_read_errors_observed_total"},
+	{v1: "zpool_device_resilvering", v2: "zfs_pool_device_resilvering"},
+	{v1: "zpool_device_slow_ios_total", v2: "zfs_pool_device_slow_ios_total"},
+	{v1: "zpool_device_write_errors_observed_total", v2: "zfs_pool_device_write_errors_observed_total"},
+	{v1: "zpool_expected_providers_count", v2: "zfs_pool_expected_providers"},
+	{v1: "zpool_faulted_providers_count", v2: "zfs_pool_providers", label: "state", value: "faulted",
+		help: "Number of zpool providers (disks) by state, faulted counting FAULTED and UNAVAIL ones"},
+	{v1: "zpool_online_providers_count", v2: "zfs_pool_providers", label: "state", value: "online",
+		help: "Number of zpool providers (disks) by state, faulted counting FAULTED and UNAVAIL ones"},
+	{v1: "zpool_free_bytes", v2: "zfs_pool_free_bytes"},
+	{v1: "zpool_full_eta_seconds", v2: "zfs_pool_full_eta_seconds"},
+	{v1: "zpool_importable", v2: "zfs_pool_importable"},
+	{v1: "zpool_in_cachefile", v2: "zfs_pool_in_cachefile"},
+	{v1: "zpool_indirect_vdev_count", v2: "zfs_pool_indirect_vdevs"},
+	{v1: "zpool_iostat_device_read_bytes_per_second", v2: "zfs_pool_iostat_device_read_bytes_per_second"},
+	{v1: "zpool_iostat_device_read_ops_per_second", v2: "zfs_pool_iostat_device_read_ops_per_second"},
+	{v1: "zpool_iostat_device_write_bytes_per_second", v2: "zfs_pool_iostat_device_write_bytes_per_second"},
+	{v1: "zpool_iostat_device_write_ops_per_second", v2: "zfs_pool_iostat_device_write_ops_per_second"},
+	{v1: "zpool_iostat_read_bytes_per_second", v2: "zfs_pool_iostat_read_bytes_per_second"},
+	{v1: "zpool_iostat_read_ops_per_second", v2: "zfs_pool_iostat_read_ops_per_second"},
+	{v1: "zpool_iostat_write_bytes_per_second", v2: "zfs_pool_iostat_write_bytes_per_second"},
+	{v1: "zpool_iostat_write_ops_per_second", v2: "zfs_pool_iostat_write_ops_per_second"},
+	{v1: "zpool_last_scan_attempt_timestamp_seconds", v2: "zfs_pool_last_scan_attempt_timestamp_seconds"},
+	{v1: "zpool_last_scan_cancelled", v2: "zfs_pool_last_scan_cancelled"},
+	{v1: "zpool_last_scrub_duration_seconds", v2: "zfs_pool_last_scrub_duration_seconds"},
+	{v1: "zpool_last_scrub_errors", v2: "zfs_pool_last_scrub_errors"},
+	{v1: "zpool_last_scrub_repaired_bytes", v2: "zfs_pool_last_scrub_repaired_bytes"},
+	{v1: "zpool_last_scrub_timestamp_seconds", v2: "zfs_pool_last_scrub_timestamp_seconds"},
+	{v1: "zpool_log_devices_unavailable_count", v2: "zfs_pool_log_devices_unavailable"},
+	{v1: "zpool_never_scrubbed", v2: "zfs_pool_never_scrubbed"},
+	{v1: "zpool_permanent_error_info", v2: "zfs_pool_permanent_error_info"},
+	{v1: "zpool_permanent_errors", v2: "zfs_pool_permanent_errors"},
+	{v1: "zpool_properties_info", v2: "zfs_pool_properties_info"},
+	{v1: "zpool_read_request_size_bytes", v2: "zfs_pool_read_request_size_bytes"},
+	{v1: "zpool_readonly", v2: "zfs_pool_readonly"},
+	{v1: "zpool_removal_copied_bytes", v2: "zfs_pool_removal_copied_bytes"},
+	{v1: "zpool_removal_in_progress", v2: "zfs_pool_removal_in_progress"},
+	{v1: "zpool_removal_total_bytes", v2: "zfs_pool_removal_total_bytes"},
+	{v1: "zpool_removing_bytes", v2: "zfs_pool_removing_bytes"},
+	{v1: "zpool_scan_issued_bytes", v2: "zfs_pool_scan_issued_bytes"},
+	{v1: "zpool_scan_rate_bytes_per_second", v2: "zfs_pool_scan_rate_bytes_per_second"},
+	{v1: "zpool_scan_scanned_bytes", v2: "zfs_pool_scan_scanned_bytes"},
+	{v1: "zpool_scan_total_bytes", v2: "zfs_pool_scan_total_bytes"},
+	{v1: "zpool_scrub_paused", v2: "zfs_pool_scrub_paused"},
+	{v1: "zpool_seconds_since_last_scrub", v2: "zfs_pool_last_scrub_age_seconds"},
+	{v1: "zpool_size_bytes", v2: "zfs_pool_size_bytes"},
+	{v1: "zpool_state_transitions_total", v2: "zfs_pool_state_transitions_total"},
+	{v1: "zpool_status_has_warning", v2: "zfs_pool_status_warning"},
+	{v1: "zpool_status_reason_info", v2: "zfs_pool_status_reason_info"},
+	{v1: "zpool_tag_info", v2: "zfs_pool_tag_info"},
+	{v1: "zpool_unhealthy_seconds_total", v2: "zfs_pool_unhealthy_seconds_total"},
+	{v1: "zpool_up", v2: "zfs_pool_up"},
+	{v1: "zpool_usable_available_bytes", v2: "zfs_pool_usable_available_bytes"},
+	{v1: "zpool_usable_capacity_ratio", v2: "zfs_pool_usable_capacity_ratio"},
+	{v1: "zpool_usable_growth_bytes_per_second", v2: "zfs_pool_usable_growth_bytes_per_second"},
+	{v1: "zpool_usable_used_bytes", v2: "zfs_pool_usable_used_bytes"},
+	{v1: "zpool_vdev_ashift", v2: "zfs_pool_vdev_ashift"},
+	{v1: "zpool_vdev_capacity_max_ratio", v2: "zfs_pool_vdev_capacity_max_ratio"},
+	{v1: "zpool_vdev_capacity_ratio", v2: "zfs_pool_vdev_capacity_ratio"},
+	{v1: "zpool_vdev_fragmentation_percentage", v2: "zfs_pool_vdev_fragmentation_ratio", scale: 0.01,
+		help: "Fragmentation of the free space of the top-level vdev from 0 to 1"},
+	{v1: "zpool_write_request_size_bytes", v2: "zfs_pool_write_request_size_bytes"},
+	{v1: "zfs_pool_dataset_count", v2: "zfs_pool_datasets"},
+	{v1: "zfs_pool_snapshot_count", v2: "zfs_pool_snapshots"},
+	{v1: "zfs_dataset_bookmark_count", v2: "zfs_dataset_bookmarks"},
+	{v1: "zfs_dataset_filesystem_count", v2: "zfs_dataset_descendant_filesystems"},
+	{v1: "zfs_dataset_snapshot_count", v2: "zfs_dataset_snapshots"},
+	{v1: "zfs_dataset_snapshot_limit_count", v2: "zfs_dataset_descendant_snapshots"},
+	{v1: "zfs_snapshot_clone_count", v2: "zfs_snapshot_clones"},
+	{v1: "zfs_volume_snapshot_limit_count", v2: "zfs_volume_descendant_snapshots"},
+}
+
+// metricRenameIndex maps the version 1 names in metricRenames to their entry.
+var metricRenameIndex = func() map[string]*metricRename {
+	index := make(map[string]*metricRename, len(metricRenames))
+	for i := range metricRenames {
+		index[metricRenames[i].v1] = &metricRenames[i]
+	}
+	return index
+}()
+
+// renamingGatherer gathers the version 2 names: it renames the families the
+// wrapped Gatherer returns according to metricRenames.
+type renamingGatherer struct {
+	prometheus.Gatherer
+}
+
+func (g renamingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	return renameFamilies(families), err
+}
+
+// renameFamilies renames, rescales and merges families in place according to
+// metricRenames and returns them sorted by name, as Gather does.
+func renameFamilies(families []*dto.MetricFamily) []*dto.MetricFamily {
+	byName := map[string]*dto.MetricFamily{}
+	var result []*dto.MetricFamily
+	for _, family := range families {
+		rename, ok := metricRenameIndex[family.GetName()]
+		if !ok {
+			result = append(result, family)
+			continue
+		}
+		for _, m := range family.Metric {
+			rename.apply(m)
+		}
+		if merged, ok := byName[rename.v2]; ok {
+			merged.Metric = append(merged.Metric, family.Metric...)
+			continue
+		}
+		family.Name = proto.String(rename.v2)
+		if rename.help != "" {
+			family.Help = proto.String(rename.help)
+		}
+		byName[rename.v2] = family
+		result = append(result, family)
+	}
+	for _, family := range byName {
+		sort.Slice(family.Metric, func(i, j int) bool {
+			return labelsKey(family.Metric[i]) < labelsKey(family.Metric[j])
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].GetName() < result[j].GetName() })
+	return result
+}
+
+// apply rescales m and adds the label of the rename to it.
+func (rename *metricRename) apply(m *dto.Metric) {
+	if rename.scale != 0 {
+		switch {
+		case m.Gauge != nil:
+			m.Gauge.Value = proto.Float64(m.Gauge.GetValue() * rename.scale)
+		case m.Counter != nil:
+			m.Counter.Value = proto.Float64(m.Counter.GetValue() * rename.scale)
+		case m.Untyped != nil:
+			m.Untyped.Value = proto.Float64(m.Untyped.GetValue() * rename.scale)
+		}
+	}
+	if rename.label != "" {
+		m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(rename.label), Value: proto.String(rename.value)})
+		sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+	}
+}
+
+// labelsKey orders metrics of one family by their label values.
+func labelsKey(m *dto.Metric) string {
+	values := make([]string, len(m.Label))
+	for i, l := range m.Label {
+		values[i] = l.GetValue()
+	}
+	return strings.Join(values, "\xff")
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil/promlint"
+)
+
+func TestRenameFamilies(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	for name, value := range map[string]float64{
+		"zpool_capacity_percentage":     51,
+		"zpool_online_providers_count":  6,
+		"zpool_faulted_providers_count": 1,
+		"zfs_arc_size_bytes":            1024,
+	} {
+		gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: "h"}, []string{"name"})
+		gauge.WithLabelValues("tank").Set(value)
+		reg.MustRegister(gauge)
+	}
+	families, err := renamingGatherer{reg}.Gather()
+	if err != nil {
+		t.Fatalf("Error in Gather (%s)", err)
+	}
+
+	got := map[string]float64{}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			key := family.GetName()
+			for _, l := range m.GetLabel() {
+				key += " " + l.GetName() + "=" + l.GetValue()
+			}
+			got[key] = m.GetGauge().GetValue()
+		}
+	}
+	for key, want := range map[string]float64{
+		"zfs_pool_capacity_ratio name=tank":          0.51,
+		"zfs_pool_providers name=tank state=online":  6,
+		"zfs_pool_providers name=tank state=faulted": 1,
+		"zfs_arc_size_bytes name=tank":               1024,
+	} {
+		if v, ok := got[key]; !ok || v != want {
+			t.Errorf("Incorrect %s (%v), should be %v", key, v, want)
+		}
+	}
+	if len(families) != 3 || len(got) != 4 {
+		t.Errorf("Incorrect renamed families %v", got)
+	}
+	for i := 1; i < len(families); i++ {
+		if families[i-1].GetName() >= families[i].GetName() {
+			t.Errorf("Families should be sorted, got %s before %s", families[i-1].GetName(), families[i].GetName())
+		}
+	}
+}
+
+// TestMetricsVersion2 checks that the version 2 names of everything the mock
+// exporter produces pass the promtool check metrics lints, and that every
+// entry of metricRenames is still described by a collector.
+func TestMetricsVersion2(t *testing.T) {
+	e := newMockExporter(t)
+	reg := prometheus.NewRegistry()
+	if err := e.Register(reg); err != nil {
+		t.Fatalf("Error in Register (%s)", err)
+	}
+	families, err := renamingGatherer{reg}.Gather()
+	if err != nil {
+		t.Fatalf("Error in Gather (%s)", err)
+	}
+	problems, err := promlint.NewWithMetricFamilies(families).Lint()
+	if err != nil {
+		t.Fatalf("Error in Lint (%s)", err)
+	}
+	for _, p := range problems {
+		t.Errorf("%s: %s", p.Metric, p.Text)
+	}
+
+	described := map[string]bool{}
+	descs := make(chan *prometheus.Desc, 1000)
+	e.Describe(descs)
+	close(descs)
+	for desc := range descs {
+		described[descName(desc)] = true
+	}
+	for _, rename := range metricRenames {
+		if !described[rename.v1] {
+			t.Errorf("Renamed metric %s is not exported", rename.v1)
+		}
+	}
+}
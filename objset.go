@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// objsetMetrics are the objset kstat statistics exported per dataset.
+var objsetMetrics = []kstatMetric{
+	newObjsetMetric("nread", "zfs_dataset_read_bytes_total", "Number of bytes read from the dataset"),
+	newObjsetMetric("nwritten", "zfs_dataset_write_bytes_total", "Number of bytes written to the dataset"),
+	newObjsetMetric("reads", "zfs_dataset_read_ops_total", "Number of read operations on the dataset"),
+	newObjsetMetric("writes", "zfs_dataset_write_ops_total", "Number of write operations on the dataset"),
+}
+
+func newObjsetMetric(stat, name, help string) kstatMetric {
+	return kstatMetric{stat: stat, desc: prometheus.NewDesc(name, help, []string{"name"}, nil), counter: true}
+}
+
+// objsetCollector exports per-dataset I/O counters from the objset-0x*
+// kstats Linux keeps under the directory of each pool for every dataset in
+// use. Datasets that have not been mounted or opened since the pool was
+// imported have no kstat.
+type objsetCollector struct {
+	filter datasetFilter
+}
+
+func (c *objsetCollector) describe(ch chan<- *prometheus.Desc) {
+	for _, m := range objsetMetrics {
+		ch <- m.desc
+	}
+}
+
+func (c *objsetCollector) collect(_ commandRunner, pools []zpool, ch chan<- prometheus.Metric) error {
+	for _, pool := range pools {
+		dir := filepath.Join(kstatDir, pool.name)
+		if _, err := os.Stat(dir); err != nil {
+			return err
+		}
+		paths, err := filepath.Glob(filepath.Join(dir, "objset-0x*"))
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			f, err := os.Open(path)
+			if err != nil {
+				continue // the dataset was released since the glob
+			}
+			values, err := parseKstatValues(f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %s", path, err)
+			}
+			name := values["dataset_name"]
+			if name == "" || !c.filter.matches(name) {
+				continue
+			}
+			for _, m := range objsetMetrics {
+				v, err := strconv.ParseFloat(values[m.stat], 64)
+				if err != nil {
+					continue
+				}
+				ch <- prometheus.MustNewConstMetric(m.desc, prometheus.CounterValue, v, name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const objset = `52 1 0x01 7 2160 5210722755 2004628467924
+name                            type data
+dataset_name                    7    tank/my home
+writes                          4    4096
+nwritten                        4    16777216
+reads                           4    1024
+nread                           4    4194304
+nunlinks                        4    12
+nunlinked                       4    12
+`
+
+func TestObjsetCollector(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "tank"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for file, dataset := range map[string]string{"objset-0x36": "tank/my home", "objset-0x37": "tank/scratch"} {
+		content := strings.Replace(objset, "tank/my home", dataset, 1)
+		if err := os.WriteFile(filepath.Join(dir, "tank", file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(old string) { kstatDir = old }(kstatDir)
+	kstatDir = dir
+
+	filter, _ := newDatasetFilter("", "tank/scratch")
+	c := &objsetCollector{filter: filter}
+	ch := make(chan prometheus.Metric, 10)
+	if err := c.collect(staticRunner{}, []zpool{{name: "tank"}}, ch); err != nil {
+		t.Fatalf("Error in collect (%s)", err)
+	}
+	close(ch)
+	got := map[string]float64{}
+	for m := range ch {
+		if name := metricLabel(m, "name"); name != "tank/my home" {
+			t.Errorf("Excluded dataset %s should not be exported", name)
+		}
+		got[descName(m.Desc())] = metricValue(m)
+	}
+	if len(got) != 4 || got["zfs_dataset_read_bytes_total"] != 4194304 || got["zfs_dataset_write_ops_total"] != 4096 {
+		t.Errorf("Incorrect dataset I/O metrics %v", got)
+	}
+
+	// Without kstats for the pool, the collector disables itself
+	err := c.collect(staticRunner{}, []zpool{{name: "other"}}, make(chan prometheus.Metric, 10))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Missing pool kstats should produce ErrNotExist, got %v", err)
+	}
+}
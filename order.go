@@ -0,0 +1,56 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// sortMetrics sorts metrics by name, then by the names and values of their
+// labels, so that a collection delivers them in the same order every time
+// rather than in that of the maps the collectors keep their state in.
+func sortMetrics(metrics []prometheus.Metric) {
+	sort.Stable(metricsByKey{metrics, metricKeys(metrics)})
+}
+
+// metricsByKey sorts metrics along with their keys.
+type metricsByKey struct {
+	metrics []prometheus.Metric
+	keys    []string
+}
+
+func (s metricsByKey) Len() int           { return len(s.metrics) }
+func (s metricsByKey) Less(i, j int) bool { return s.keys[i] < s.keys[j] }
+func (s metricsByKey) Swap(i, j int) {
+	s.metrics[i], s.metrics[j] = s.metrics[j], s.metrics[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+// metricKeys returns the metricKey of each of metrics.
+func metricKeys(metrics []prometheus.Metric) []string {
+	keys := make([]string, len(metrics))
+	for i, m := range metrics {
+		keys[i] = metricKey(m)
+	}
+	return keys
+}
+
+// metricKey returns the name and labels of m joined by NUL bytes, which sort
+// before any character of a name or value.
+func metricKey(m prometheus.Metric) string {
+	var b strings.Builder
+	b.WriteString(descName(m.Desc()))
+	var d dto.Metric
+	if err := m.Write(&d); err != nil {
+		return b.String()
+	}
+	for _, pair := range d.GetLabel() {
+		b.WriteByte(0)
+		b.WriteString(pair.GetName())
+		b.WriteByte(0)
+		b.WriteString(pair.GetValue())
+	}
+	return b.String()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/proto"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite testdata/mock-metrics.prom with the output of the mock exporter")
+
+// goldenVolatile are the metrics of the mock exporter whose values depend on
+// the time of the run, which the golden output shows as 0.
+var goldenVolatile = map[string]bool{
+	"zfs_exporter_collector_duration_seconds":    true,
+	"zfs_exporter_import_scan_timestamp_seconds": true,
+	"zpool_seconds_since_last_scrub":             true,
+}
+
+func TestSortMetrics(t *testing.T) {
+	desc := prometheus.NewDesc("zpool_up", "", []string{"name"}, nil)
+	other := prometheus.NewDesc("zpool_health", "", []string{"name", "state"}, nil)
+	metrics := []prometheus.Metric{
+		prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, "tank"),
+		prometheus.MustNewConstMetric(other, prometheus.GaugeValue, 1, "tank", "ONLINE"),
+		prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, "backup"),
+		prometheus.MustNewConstMetric(other, prometheus.GaugeValue, 0, "backup", "ONLINE"),
+	}
+	sortMetrics(metrics)
+	got := metricKeys(metrics)
+	want := []string{
+		"zpool_health\x00name\x00backup\x00state\x00ONLINE",
+		"zpool_health\x00name\x00tank\x00state\x00ONLINE",
+		"zpool_up\x00name\x00backup",
+		"zpool_up\x00name\x00tank",
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Incorrect order %q, should be %q", got, want)
+			break
+		}
+	}
+}
+
+// TestSnapshotOrder checks that every collection delivers the metrics of
+// the mock exporter in the same, sorted order.
+func TestSnapshotOrder(t *testing.T) {
+	e := newMockExporter(t)
+	for i := 0; i < 3; i++ {
+		if !sort.StringsAreSorted(metricKeys(e.snapshot(nil))) {
+			t.Fatalf("The metrics of collection %d are not sorted", i)
+		}
+	}
+}
+
+// TestGoldenMetrics compares the output of the mock exporter with
+// testdata/mock-metrics.prom, so that a change to the metrics shows up as a
+// change of that file. Run go test -run TestGoldenMetrics -update to rewrite
+// it.
+func TestGoldenMetrics(t *testing.T) {
+	local := time.Local
+	time.Local = time.UTC
+	t.Cleanup(func() { time.Local = local })
+
+	e := newMockExporter(t)
+	reg := prometheus.NewRegistry()
+	if err := e.Register(reg); err != nil {
+		t.Fatalf("Error in Register (%s)", err)
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Error in Gather (%s)", err)
+	}
+	var got bytes.Buffer
+	for _, family := range families {
+		if goldenVolatile[family.GetName()] {
+			for _, m := range family.GetMetric() {
+				if m.GetGauge() != nil {
+					m.Gauge.Value = proto.Float64(0)
+				}
+			}
+		}
+		if _, err := expfmt.MetricFamilyToText(&got, family); err != nil {
+			t.Fatalf("Error in MetricFamilyToText (%s)", err)
+		}
+	}
+
+	path := filepath.Join("testdata", "mock-metrics.prom")
+	if *updateGolden {
+		if err := os.WriteFile(path, got.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Error reading %s (%s), run go test -run TestGoldenMetrics -update to create it", path, err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		gotLines, wantLines := bytes.Split(got.Bytes(), []byte("\n")), bytes.Split(want, []byte("\n"))
+		for i := 0; i < len(gotLines) || i < len(wantLines); i++ {
+			var g, w []byte
+			if i < len(gotLines) {
+				g = gotLines[i]
+			}
+			if i < len(wantLines) {
+				w = wantLines[i]
+			}
+			if !bytes.Equal(g, w) {
+				t.Fatalf("The metrics differ from %s at line %d:\n got: %s\nwant: %s\nRun go test -run TestGoldenMetrics -update if the change is intended", path, i+1, g, w)
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// outputLimits bound what the exporter reads of the output of a command, so
+// that a command printing without end cannot exhaust its memory.
+type outputLimits struct {
+	maxLine  int   // longest line, in bytes
+	maxBytes int64 // whole output, in bytes; 0 for no limit
+}
+
+// outputLimit holds the limits of --command.max-line-bytes and
+// --command.max-output-bytes.
+var outputLimit = outputLimits{maxLine: 1 << 20, maxBytes: 256 << 20}
+
+// limitedReader fails once more than max bytes were read, unlike
+// io.LimitReader, which would truncate the output silently.
+type limitedReader struct {
+	r   io.Reader
+	n   int64
+	max int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n >= l.max {
+		// Output of exactly max bytes is fine, as long as it ends there.
+		var probe [1]byte
+		if n, err := l.r.Read(probe[:]); n == 0 {
+			return 0, err
+		}
+		return 0, fmt.Errorf("output longer than %d bytes, see --command.max-output-bytes", l.max)
+	}
+	if rest := l.max - l.n; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	return n, err
+}
+
+// newOutputScanner returns a scanner over the lines of the command output r
+// within outputLimit. Report its errors with scanError.
+func newOutputScanner(r io.Reader) *bufio.Scanner {
+	if outputLimit.maxBytes > 0 {
+		r = &limitedReader{r: r, max: outputLimit.maxBytes}
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, outputLimit.maxLine)
+	return scanner
+}
+
+// scanError returns the error that stopped scanner, naming the flag to raise
+// when a line was too long.
+func scanError(scanner *bufio.Scanner) error {
+	err := scanner.Err()
+	if errors.Is(err, bufio.ErrTooLong) {
+		return fmt.Errorf("line longer than %d bytes, see --command.max-line-bytes", outputLimit.maxLine)
+	}
+	return err
+}
+
+// scanOutput calls fn for every line of the command output r, without
+// holding more than a line in memory.
+func scanOutput(r io.Reader, fn func(line string)) error {
+	scanner := newOutputScanner(r)
+	for scanner.Scan() {
+		fn(scanner.Text())
+	}
+	return scanError(scanner)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestScanOutput(t *testing.T) {
+	defer func(limit outputLimits) { outputLimit = limit }(outputLimit)
+	outputLimit = outputLimits{maxLine: 16, maxBytes: 32}
+
+	var lines []string
+	if err := scanOutput(strings.NewReader("tank\nbackup\n"), func(line string) { lines = append(lines, line) }); err != nil {
+		t.Fatalf("Error in scanOutput (%s)", err)
+	}
+	if strings.Join(lines, ",") != "tank,backup" {
+		t.Errorf("Incorrect lines %q", lines)
+	}
+
+	err := scanOutput(strings.NewReader("tank\n"+strings.Repeat("x", 20)+"\n"), func(string) {})
+	if err == nil || !strings.Contains(err.Error(), "--command.max-line-bytes") {
+		t.Errorf("Line longer than the limit should fail naming the flag, got %v", err)
+	}
+	err = scanOutput(strings.NewReader(strings.Repeat("tank\n", 10)), func(string) {})
+	if err == nil || !strings.Contains(err.Error(), "--command.max-output-bytes") {
+		t.Errorf("Output longer than the limit should fail naming the flag, got %v", err)
+	}
+	// Exactly at the limit
+	if err := scanOutput(strings.NewReader(strings.Repeat("x", 15)+"\n"+strings.Repeat("y", 15)+"\n"), func(string) {}); err != nil {
+		t.Errorf("Output of the limit should be read, got %s", err)
+	}
+
+	outputLimit.maxBytes = 0
+	if err := scanOutput(strings.NewReader(strings.Repeat("tank\n", 10)), func(string) {}); err != nil {
+		t.Errorf("Output should not be limited with 0, got %s", err)
+	}
+}
+
+// exitRunner serves output like staticRunner from start, and fails Close with
+// err like a command exiting with an error.
+type exitRunner struct {
+	staticRunner
+	err error
+}
+
+type exitOutput struct {
+	io.Reader
+	err error
+}
+
+func (o exitOutput) Close() error { return o.err }
+
+func (r exitRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	output, _ := r.run(name, args...)
+	return exitOutput{strings.NewReader(output), r.err}, nil
+}
+
+func TestGetStatusStreamed(t *testing.T) {
+	defer func(limit outputLimits) { outputLimit = limit }(outputLimit)
+	output := largeStatusOutput(2)
+	r := exitRunner{staticRunner: staticRunner{"zpool status -s tank": output}}
+	opts := poolOptions{slowIOs: true}
+
+	z := zpool{name: "tank"}
+	if err := z.getStatus(r, opts); err != nil {
+		t.Fatalf("Error in getStatus (%s)", err)
+	}
+	if z.status != "ONLINE" || z.online != 12 || len(z.slowIOs) != 12 || z.scan.function != "scrub" {
+		t.Errorf("Incorrect status parsed from the stream: %+v", z)
+	}
+
+	status, _ := readStatus(strings.NewReader(output), "tank", opts)
+	if rest := status.rest.String(); strings.Contains(rest, "raidz2-0") || !strings.Contains(rest, "errors: No known data errors") {
+		t.Errorf("Only the sections outside the config should be kept, got %q", rest)
+	}
+
+	// Test an exit status without output, as for a pool zpool cannot open
+	r.err = errors.New("exit status 1: cannot open 'tank': no such pool")
+	r.staticRunner = staticRunner{}
+	err := z.getStatus(r, opts)
+	if err == nil || !strings.Contains(err.Error(), "no such pool") {
+		t.Errorf("getStatus should report the error of zpool status, got %v", err)
+	}
+
+	// Test output beyond the limit
+	outputLimit.maxBytes = int64(len(output) / 2)
+	r = exitRunner{staticRunner: staticRunner{"zpool status -s tank": output}}
+	if err := z.getStatus(r, opts); err == nil || !strings.Contains(err.Error(), "--command.max-output-bytes") {
+		t.Errorf("Output beyond the limit should fail getStatus, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	collectionOverrunDesc = prometheus.NewDesc("zfs_exporter_collection_overrun",
+		"Whether the last collection took longer than the time between the last two scrapes (1) or not (0)", nil, nil)
+	collectionOverrunsDesc = prometheus.NewDesc("zfs_exporter_collection_overruns_total",
+		"Number of collections that took longer than the time between the scrape that started them and the one before", nil, nil)
+)
+
+// overrunTracker compares how long collections take with how often they are
+// requested. A scrape interval shorter than the collection, such as during a
+// scrub, makes scrapes wait for the collection before them and Prometheus
+// see gaps. Scrapes selecting different collectors with collect[] are timed
+// apart, since they usually come from different jobs.
+type overrunTracker struct {
+	mutex sync.Mutex
+	// requested is when the last scrape of each selection arrived, and
+	// intervals the time between its last two.
+	requested map[string]time.Time
+	intervals map[string]time.Duration
+	overrun   bool
+	overruns  float64
+	warned    time.Time // when the overrun was last logged, zero without one
+}
+
+// request records that a scrape of the selection key arrived at now.
+func (o *overrunTracker) request(key string, now time.Time) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.requested == nil {
+		o.requested, o.intervals = map[string]time.Time{}, map[string]time.Duration{}
+	}
+	if last, ok := o.requested[key]; ok {
+		o.intervals[key] = now.Sub(last)
+	}
+	o.requested[key] = now
+}
+
+// observe records that the collection of the selection key that started at
+// started took until now, and exports whether it overran. The warning is
+// logged at most every --log.repeat-interval while collections keep
+// overrunning.
+func (o *overrunTracker) observe(key string, started, now time.Time, ch chan<- prometheus.Metric) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	took := now.Sub(started)
+	interval, ok := o.intervals[key]
+	o.overrun = ok && took > interval
+	switch {
+	case o.overrun:
+		o.overruns++
+		if o.warned.IsZero() || now.Sub(o.warned) >= logRepeats.window {
+			log.Printf("Warning: the collection took %s, longer than the %s between the last two scrapes; scrapes wait for the collection before them, raise the scrape interval", took.Round(time.Millisecond), interval.Round(time.Millisecond))
+			o.warned = now
+		}
+	case ok && !o.warned.IsZero():
+		log.Printf("The collection took %s, shorter again than the %s between scrapes", took.Round(time.Millisecond), interval.Round(time.Millisecond))
+		o.warned = time.Time{}
+	}
+	ch <- prometheus.MustNewConstMetric(collectionOverrunDesc, prometheus.GaugeValue, boolToFloat(o.overrun))
+	ch <- prometheus.MustNewConstMetric(collectionOverrunsDesc, prometheus.CounterValue, o.overruns)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestOverrunTracker(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer func(old *logDedup) { logRepeats = old }(logRepeats)
+	logRepeats = newLogDedup(time.Minute)
+
+	var o overrunTracker
+	start := time.Unix(1700000000, 0)
+	observe := func(requested time.Duration, took time.Duration) (overrun, overruns float64) {
+		t.Helper()
+		o.request("", start.Add(requested))
+		ch := make(chan prometheus.Metric, 2)
+		o.observe("", start.Add(requested), start.Add(requested+took), ch)
+		close(ch)
+		for m := range ch {
+			switch m.Desc() {
+			case collectionOverrunDesc:
+				overrun = metricValue(m)
+			case collectionOverrunsDesc:
+				overruns = metricValue(m)
+			}
+		}
+		return overrun, overruns
+	}
+
+	if overrun, overruns := observe(0, 8*time.Second); overrun != 0 || overruns != 0 {
+		t.Errorf("The first scrape has no interval to overrun, got %v, %v", overrun, overruns)
+	}
+	if overrun, overruns := observe(5*time.Second, 8*time.Second); overrun != 1 || overruns != 1 {
+		t.Errorf("An 8s collection 5s after the last scrape should overrun, got %v, %v", overrun, overruns)
+	}
+	if !strings.Contains(buf.String(), "the collection took 8s, longer than the 5s between the last two scrapes") {
+		t.Errorf("An overrun should be logged with both durations, got %q", buf.String())
+	}
+	buf.Reset()
+	if overrun, overruns := observe(10*time.Second, 8*time.Second); overrun != 1 || overruns != 2 {
+		t.Errorf("Another overrun should count, got %v, %v", overrun, overruns)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("A repeated overrun within --log.repeat-interval should not be logged, got %q", buf.String())
+	}
+	if overrun, overruns := observe(25*time.Second, 2*time.Second); overrun != 0 || overruns != 2 {
+		t.Errorf("A 2s collection 15s after the last scrape should not overrun, got %v, %v", overrun, overruns)
+	}
+	if !strings.Contains(buf.String(), "shorter again") {
+		t.Errorf("The end of the overruns should be logged, got %q", buf.String())
+	}
+
+	// Scrapes of other collectors are timed apart.
+	o.request("arc", start.Add(26*time.Second))
+	ch := make(chan prometheus.Metric, 2)
+	o.observe("arc", start.Add(26*time.Second), start.Add(30*time.Second), ch)
+	if m := <-ch; metricValue(m) != 0 {
+		t.Errorf("The first scrape selecting arc should not overrun")
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newCollectorPanics() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "zfs_exporter_collector_panics_total",
+		Help: "Number of panics in a collector, or in the collection of one pool by the pool collector, that the exporter recovered from",
+	}, []string{"collector"})
+}
+
+// collectorPanics counts the panics recovered by recovered, such as an index
+// out of range in a parser fed truncated output. run replaces it with one it
+// registers.
+var collectorPanics = newCollectorPanics()
+
+// recovered runs f for the named collector, turning a panic in it into an
+// error instead of letting it take the exporter down when ZFS misbehaves
+// and the metrics are needed most. The panic is counted in collectorPanics
+// and logged with its stack, so that it can be reported.
+func recovered(collector string, f func() error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			collectorPanics.WithLabelValues(collector).Inc()
+			logf(logFields{"COLLECTOR": collector}, "Error: recovered from a panic in the %s collector: %v\n%s", collector, v, debug.Stack())
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+	return f()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// panickingRunner panics, as a parser bug would, for the command lines
+// panics returns true for, and runs the others with commandRunner.
+type panickingRunner struct {
+	commandRunner
+	panics func(line string) bool
+}
+
+func (r panickingRunner) check(name string, args []string) {
+	if r.panics(strings.Join(append([]string{name}, args...), " ")) {
+		var fields []string
+		_ = fields[3] // index out of range
+	}
+}
+
+func (r panickingRunner) run(name string, args ...string) (string, error) {
+	r.check(name, args)
+	return r.commandRunner.run(name, args...)
+}
+
+func (r panickingRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	r.check(name, args)
+	return r.commandRunner.start(name, args...)
+}
+
+func TestRecovered(t *testing.T) {
+	before := testutil.ToFloat64(collectorPanics.WithLabelValues("test"))
+	err := recovered("test", func() error {
+		var m map[string]int
+		m["x"] = 1
+		return nil
+	})
+	if err == nil || !strings.HasPrefix(err.Error(), "panic: ") {
+		t.Errorf("A panic should become an error, got %v", err)
+	}
+	if got := testutil.ToFloat64(collectorPanics.WithLabelValues("test")) - before; got != 1 {
+		t.Errorf("The panic should be counted once, got %v", got)
+	}
+	if err := recovered("test", func() error { return nil }); err != nil {
+		t.Errorf("Error in recovered (%s)", err)
+	}
+}
+
+func TestExporterSurvivesPanics(t *testing.T) {
+	e := newMockExporter(t)
+	e.runner = panickingRunner{e.runner, func(line string) bool {
+		return strings.HasPrefix(line, "zpool status ") && strings.HasSuffix(line, " backup") ||
+			strings.HasPrefix(line, "zfs list ")
+	}}
+	poolPanics := testutil.ToFloat64(collectorPanics.WithLabelValues("pool"))
+	datasetPanics := testutil.ToFloat64(collectorPanics.WithLabelValues("datasets"))
+
+	up := map[string]float64{}
+	success := map[string]float64{}
+	for _, m := range e.snapshot(nil) {
+		switch descName(m.Desc()) {
+		case "zpool_up":
+			up[metricLabel(m, "name")] = metricValue(m)
+		case "zfs_exporter_collector_success":
+			success[metricLabel(m, "collector")] = metricValue(m)
+		}
+	}
+	select {
+	case err := <-e.fatal:
+		t.Fatalf("A panic in one pool should not stop the exporter (%s)", err)
+	default:
+	}
+	if up["tank"] != 1 {
+		t.Errorf("The pool that did not panic should still be exported, got %v", up)
+	}
+	if success["datasets"] != 0 || success["arc"] != 1 || success["pool"] != 1 {
+		t.Errorf("Only the collector that panicked should fail, got %v", success)
+	}
+	if got := testutil.ToFloat64(collectorPanics.WithLabelValues("pool")) - poolPanics; got != 1 {
+		t.Errorf("The panic collecting backup should be counted once, got %v", got)
+	}
+	if got := testutil.ToFloat64(collectorPanics.WithLabelValues("datasets")) - datasetPanics; got != 1 {
+		t.Errorf("The panic of the datasets collector should be counted once, got %v", got)
+	}
+	if err := (*e.zpools)[1].err; err == nil || !strings.Contains(err.Error(), "panic") {
+		t.Errorf("backup should fail with the panic, got %v", err)
+	}
+}
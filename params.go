@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// moduleParamsDir holds the tunables of the zfs kernel module on Linux, one
+// file per parameter.
+var moduleParamsDir = "/sys/module/zfs/parameters"
+
+var (
+	moduleParamDesc = prometheus.NewDesc("zfs_module_parameter",
+		"Value of the numeric zfs module parameter", []string{"name"}, nil)
+	moduleParamInfoDesc = prometheus.NewDesc("zfs_module_parameter_info",
+		"Value of the non-numeric zfs module parameter, always 1", []string{"name", "value"}, nil)
+)
+
+// validModuleParam reports whether name can be a module parameter, so that
+// the allowlist cannot name files outside moduleParamsDir.
+func validModuleParam(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// paramsCollector exports the module parameters in names. The module has
+// hundreds of them, most of which never change, so only the allowlisted ones
+// are exported. Parameters the loaded release does not have are left out.
+type paramsCollector struct {
+	names []string
+}
+
+func newParamsCollector(names []string) (*paramsCollector, error) {
+	for _, name := range names {
+		if !validModuleParam(name) {
+			return nil, fmt.Errorf("invalid module parameter %q", name)
+		}
+	}
+	return &paramsCollector{names: names}, nil
+}
+
+func (c *paramsCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- moduleParamDesc
+	ch <- moduleParamInfoDesc
+}
+
+func (c *paramsCollector) collect(r commandRunner, pools []zpool, ch chan<- prometheus.Metric) error {
+	if _, err := os.Stat(moduleParamsDir); err != nil {
+		return err // the module is not loaded
+	}
+	for _, name := range c.names {
+		b, err := os.ReadFile(filepath.Join(moduleParamsDir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		value := strings.TrimSpace(string(b))
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			ch <- prometheus.MustNewConstMetric(moduleParamDesc, prometheus.GaugeValue, v, name)
+		} else {
+			ch <- prometheus.MustNewConstMetric(moduleParamInfoDesc, prometheus.GaugeValue, 1, name, value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParamsCollector(t *testing.T) {
+	dir := t.TempDir()
+	for name, value := range map[string]string{
+		"zfs_arc_max":         "17179869184\n",
+		"zfs_txg_timeout":     "5\n",
+		"zfs_vdev_raidz_impl": "cycle [fastest] original scalar\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(old string) { moduleParamsDir = old }(moduleParamsDir)
+	moduleParamsDir = dir
+
+	c, err := newParamsCollector([]string{"zfs_arc_max", "zfs_vdev_raidz_impl", "zfs_missing"})
+	if err != nil {
+		t.Fatalf("Error in newParamsCollector (%s)", err)
+	}
+	ch := make(chan prometheus.Metric, 10)
+	if err := c.collect(staticRunner{}, nil, ch); err != nil {
+		t.Fatalf("Error in collect (%s)", err)
+	}
+	close(ch)
+	got := map[string]float64{}
+	for m := range ch {
+		key := descName(m.Desc()) + " " + metricLabel(m, "name")
+		if value := metricLabel(m, "value"); value != "" {
+			key += " " + value
+		}
+		got[key] = metricValue(m)
+	}
+	want := map[string]float64{
+		"zfs_module_parameter zfs_arc_max":                                              17179869184,
+		"zfs_module_parameter_info zfs_vdev_raidz_impl cycle [fastest] original scalar": 1,
+	}
+	if len(got) != len(want) {
+		t.Errorf("Incorrect metrics (%v), should be %v", got, want)
+	}
+	for key, v := range want {
+		if got[key] != v {
+			t.Errorf("Incorrect %s (%v), should be %v", key, got[key], v)
+		}
+	}
+
+	for _, name := range []string{"", "../../kernel/x", "zfs arc", "ZFS_ARC_MAX"} {
+		if _, err := newParamsCollector([]string{name}); err == nil {
+			t.Errorf("Parameter %q should produce error in newParamsCollector", name)
+		}
+	}
+	moduleParamsDir = filepath.Join(dir, "missing")
+	if err := c.collect(staticRunner{}, nil, make(chan prometheus.Metric, 10)); err == nil {
+		t.Errorf("Missing parameters directory should produce error in collect")
+	}
+}
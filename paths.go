@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The paths of the host the exporter and the commands it runs read, which
+// the --path flags point elsewhere for an exporter in a container with the
+// paths of the host bind-mounted.
+const (
+	defaultProcSPL = "/proc/spl"
+	defaultDevZFS  = "/dev/zfs"
+)
+
+// commandEnv is added to the environment of the commands the exporter runs,
+// ZFS_DEV for --path.dev-zfs.
+var commandEnv []string
+
+// setProcSPL points kstatDir and kmemSlabPath into base rather than
+// /proc/spl.
+func setProcSPL(base string) {
+	kstatDir = filepath.Join(base, "kstat", "zfs")
+	kmemSlabPath = filepath.Join(base, "kmem", "slab")
+}
+
+// hostPath is a path of the host the exporter needs, with the flag setting
+// it and how to provide it in a container.
+type hostPath struct {
+	flag, path, kind string // kind is directory, device or file
+	mount            string
+}
+
+// hostPaths returns the paths of the --path flags given on the command line,
+// which change is true for.
+func hostPaths(changed func(flag string) bool) []hostPath {
+	var paths []hostPath
+	if changed("path.proc-spl") {
+		paths = append(paths, hostPath{"path.proc-spl", procSPL, "directory",
+			"bind-mount the /proc of the host, such as with -v /proc:/host/proc:ro and --path.proc-spl /host/proc/spl"})
+	}
+	if changed("path.dev-zfs") {
+		paths = append(paths, hostPath{"path.dev-zfs", devZFS, "device",
+			"pass the device of the host, such as with --device /dev/zfs:" + devZFS})
+	}
+	if changed("path.zpool-cache") {
+		paths = append(paths, hostPath{"path.zpool-cache", zpoolCache, "file",
+			"bind-mount the /etc/zfs of the host, such as with -v /etc/zfs:/host/etc/zfs:ro and --path.zpool-cache /host/etc/zfs/zpool.cache"})
+	}
+	return paths
+}
+
+// checkHostPaths checks that every path exists and is of its kind, and
+// returns an error naming each one that is not along with the mount that
+// would provide it.
+func checkHostPaths(paths []hostPath) error {
+	var problems []string
+	for _, p := range paths {
+		info, err := os.Stat(p.path)
+		problem := ""
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			problem = "does not exist"
+		case err != nil:
+			problem = fmt.Sprintf("cannot be accessed (%s)", errors.Unwrap(err))
+		case p.kind == "directory" && !info.IsDir():
+			problem = "is not a directory"
+		case p.kind == "device" && info.Mode()&os.ModeCharDevice == 0:
+			problem = "is not a character device"
+		case p.kind == "file" && !info.Mode().IsRegular():
+			problem = "is not a file"
+		}
+		if problem != "" {
+			problems = append(problems, fmt.Sprintf("-%s %s %s: %s", p.flag, p.path, problem, p.mount))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckHostPaths(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "zpool.cache")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	good := []hostPath{{"path.proc-spl", dir, "directory", ""}, {"path.zpool-cache", file, "file", ""}}
+	if info, err := os.Stat("/dev/null"); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		good = append(good, hostPath{"path.dev-zfs", "/dev/null", "device", ""})
+	}
+	if err := checkHostPaths(good); err != nil {
+		t.Errorf("Error in checkHostPaths (%s)", err)
+	}
+
+	err := checkHostPaths([]hostPath{
+		{"path.proc-spl", filepath.Join(dir, "spl"), "directory", "bind-mount /proc"},
+		{"path.dev-zfs", file, "device", "pass the device"},
+		{"path.zpool-cache", dir, "file", "bind-mount /etc/zfs"},
+	})
+	if err == nil {
+		t.Fatal("Missing paths should produce error in checkHostPaths")
+	}
+	// Every problem is reported at once, with the mount that fixes it.
+	for _, want := range []string{
+		"-path.proc-spl " + filepath.Join(dir, "spl") + " does not exist: bind-mount /proc",
+		"-path.dev-zfs " + file + " is not a character device: pass the device",
+		"-path.zpool-cache " + dir + " is not a file: bind-mount /etc/zfs",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("The error should contain %q, got %q", want, err)
+		}
+	}
+}
+
+func TestHostPaths(t *testing.T) {
+	defer func(old string) { procSPL = old }(procSPL)
+	procSPL = "/host/proc/spl"
+	paths := hostPaths(func(flag string) bool { return flag == "path.proc-spl" })
+	if len(paths) != 1 || paths[0].path != "/host/proc/spl" || paths[0].kind != "directory" {
+		t.Errorf("Only the given flag should be checked, got %+v", paths)
+	}
+
+	defer func(dir, slab string) { kstatDir, kmemSlabPath = dir, slab }(kstatDir, kmemSlabPath)
+	setProcSPL(procSPL)
+	if kstatDir != "/host/proc/spl/kstat/zfs" || kmemSlabPath != "/host/proc/spl/kmem/slab" {
+		t.Errorf("Incorrect paths below --path.proc-spl: %s, %s", kstatDir, kmemSlabPath)
+	}
+}
+
+func TestCommandEnv(t *testing.T) {
+	defer func(old []string) { commandEnv = old }(commandEnv)
+	commandEnv = []string{"ZFS_DEV=/host/dev/zfs"}
+	output, err := execRunner{}.run("sh", "-c", "echo $ZFS_DEV $HOME")
+	if err != nil {
+		t.Skipf("sh cannot be run (%s)", err)
+	}
+	if want := "/host/dev/zfs " + os.Getenv("HOME") + "\n"; output != want {
+		t.Errorf("The commands should get ZFS_DEV along with the environment of the exporter, got %q, should be %q", output, want)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// permanentErrors is the errors: section of zpool status: the data errors
+// that redundancy could not repair.
+type permanentErrors struct {
+	count int64 // -1 when zpool status did not show the section
+	// entries are the files and objects zpool status -v listed, without
+	// the ones it left out.
+	entries []string
+}
+
+// maxErrorPathLength is how much of a file path is shown with
+// --permanent-errors.show-paths.
+const maxErrorPathLength = 128
+
+// parsePermanentErrors parses the errors: section of zpool status output,
+// which is one of
+//
+//	errors: No known data errors
+//	errors: 3 data errors, use '-v' for a list
+//
+// or with -v the list of the damaged files and objects, such as
+// "/tank/home/file", "tank/home@snap:/file", "tank/home:<0x1f>" and
+// "<metadata>:<0x3f>", which may end in "... and 12 more". Only the first
+// section is parsed.
+func parsePermanentErrors(output string) permanentErrors {
+	e := permanentErrors{count: -1}
+	inList := false
+	for lines := newLineScanner(output); lines.scan(); {
+		trimmed := strings.TrimSpace(lines.line)
+		if !inList {
+			if !strings.HasPrefix(trimmed, "errors:") {
+				continue
+			}
+			summary := strings.TrimSpace(strings.TrimPrefix(trimmed, "errors:"))
+			switch {
+			case summary == "No known data errors":
+				e.count = 0
+			case strings.HasPrefix(summary, "Permanent errors have been detected"):
+				e.count = 0
+				inList = true
+				continue
+			default:
+				if n, err := strconv.ParseInt(strings.Fields(summary + " x")[0], 10, 64); err == nil {
+					e.count = n
+				}
+			}
+			return e
+		}
+		switch {
+		case trimmed == "":
+			if e.count > 0 {
+				return e // the blank line after the list
+			}
+		case !strings.HasPrefix(trimmed, "/") && isStatusKey(trimmed):
+			return e
+		case elidedErrors(trimmed) > 0:
+			e.count += elidedErrors(trimmed)
+		default:
+			e.entries = append(e.entries, trimmed)
+			e.count++
+		}
+	}
+	return e
+}
+
+// elidedErrors returns N for a "... and N more" line ending a list of
+// permanent errors, and 0 for other lines.
+func elidedErrors(line string) int64 {
+	fields := strings.Fields(strings.TrimLeft(line, ". "))
+	if len(fields) != 3 || fields[0] != "and" || fields[2] != "more" {
+		return 0
+	}
+	n, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// errorEntryKind tells the entries listed by parsePermanentErrors apart:
+// "metadata" for pool metadata, "file" for a file path, in a mounted dataset
+// or after the name of a dataset or snapshot, and "object" for an object
+// number of a dataset, which zpool shows when it cannot tell the path.
+func errorEntryKind(entry string) string {
+	switch {
+	case strings.HasPrefix(entry, "<metadata>:"):
+		return "metadata"
+	case strings.HasPrefix(entry, "/"):
+		return "file"
+	}
+	if _, object, ok := strings.Cut(entry, ":"); ok && strings.HasPrefix(object, "<0x") {
+		return "object"
+	}
+	return "file"
+}
+
+// errorEntryLabel is how an entry appears in zpool_permanent_error_info.
+// File paths and dataset names can be sensitive, so every entry but pool
+// metadata is hashed unless showPaths is set, in which case it is truncated
+// to maxErrorPathLength bytes.
+func errorEntryLabel(entry string, showPaths bool) string {
+	switch {
+	case errorEntryKind(entry) == "metadata":
+		return entry
+	case showPaths:
+		return truncateLabel(entry, maxErrorPathLength)
+	}
+	sum := sha256.Sum256([]byte(entry))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// truncateLabel shortens s to at most max bytes, marking the cut with "...",
+// without splitting a UTF-8 sequence.
+func truncateLabel(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	cut := max - len("...")
+	for cut > 0 && s[cut]&0xc0 == 0x80 {
+		cut--
+	}
+	return s[:cut] + "..."
+}
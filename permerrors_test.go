@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePermanentErrors(t *testing.T) {
+	for _, test := range []struct {
+		output  string
+		count   int64
+		entries []string
+	}{
+		{"  pool: tank\n state: ONLINE\nconfig:\n", -1, nil},
+		{"errors: No known data errors\n", 0, nil},
+		{"errors: 3 data errors, use '-v' for a list\n", 3, nil},
+		{"errors: Permanent errors have been detected in the following files:\n\n" +
+			"        <metadata>:<0x3f>\n" +
+			"        /tank/home/alice/report.pdf\n" +
+			"        tank/home@daily:/alice/old report.pdf\n" +
+			"        tank/vm:<0x1f>\n" +
+			"        ... and 12 more\n" +
+			"\n" +
+			"  pool: backup\n" +
+			"errors: No known data errors\n",
+			16, []string{"<metadata>:<0x3f>", "/tank/home/alice/report.pdf", "tank/home@daily:/alice/old report.pdf", "tank/vm:<0x1f>"}},
+	} {
+		e := parsePermanentErrors(test.output)
+		if e.count != test.count || strings.Join(e.entries, "|") != strings.Join(test.entries, "|") {
+			t.Errorf("Incorrect permanent errors %d %q in %q, should be %d %q", e.count, e.entries, test.output, test.count, test.entries)
+		}
+	}
+}
+
+func TestErrorEntryLabel(t *testing.T) {
+	for entry, kind := range map[string]string{
+		"<metadata>:<0x3f>":             "metadata",
+		"/tank/home/alice/report.pdf":   "file",
+		"tank/home@daily:/alice/report": "file",
+		"tank/vm:<0x1f>":                "object",
+		"<0x13>:<0x2>":                  "object",
+	} {
+		if got := errorEntryKind(entry); got != kind {
+			t.Errorf("Incorrect kind of %s (%s), should be %s", entry, got, kind)
+		}
+	}
+
+	if got := errorEntryLabel("<metadata>:<0x3f>", false); got != "<metadata>:<0x3f>" {
+		t.Errorf("Pool metadata should not be hashed, got %s", got)
+	}
+	hashed := errorEntryLabel("/tank/home/alice/report.pdf", false)
+	if !strings.HasPrefix(hashed, "sha256:") || len(hashed) != len("sha256:")+16 || strings.Contains(hashed, "alice") {
+		t.Errorf("Incorrect hashed path %s", hashed)
+	}
+	if got := errorEntryLabel("/tank/home/alice/report.pdf", true); got != "/tank/home/alice/report.pdf" {
+		t.Errorf("Path should be shown with showPaths, got %s", got)
+	}
+	long := "/tank/" + strings.Repeat("é", 100)
+	got := errorEntryLabel(long, true)
+	if len(got) > maxErrorPathLength || !strings.HasSuffix(got, "...") || !strings.HasPrefix(long, strings.TrimSuffix(got, "...")) {
+		t.Errorf("Long path should be truncated to %d bytes, got %q", maxErrorPathLength, got)
+	}
+}
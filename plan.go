@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+)
+
+// plannedCommand is a command listed by --print-commands.
+type plannedCommand struct {
+	command
+	when string // "" for every scrape
+}
+
+// planner is implemented by the collectors that run commands. plan returns
+// the commands collect runs for pools, built by the same functions.
+type planner interface {
+	plan(pools []zpool) []plannedCommand
+}
+
+// collectorPlan is the plan of one collector, or of the startup.
+type collectorPlan struct {
+	name     string
+	commands []plannedCommand
+}
+
+// plan returns the commands the exporter runs on pools: at startup, and on
+// every scrape by collector. The probes at startup decide which options of
+// zpool status are used; the plan assumes every option is supported.
+func (e *Exporter) plan(pools []zpool) []collectorPlan {
+	var startup []plannedCommand
+	for _, pool := range pools {
+		startup = append(startup, plannedCommand{existenceCommand(pool.name), "at startup"})
+	}
+	for _, c := range probeCommands(pools[0].name) {
+		startup = append(startup, plannedCommand{c, "at startup, probing what zpool and zfs support"})
+	}
+	pool := &poolCollector{zpools: &pools, opts: &e.pool}
+	if e.pools == nil {
+		for _, c := range pool.plan(pools) {
+			if c.when = "at startup; " + c.when; c.when == "at startup; " {
+				c.when = "at startup"
+			}
+			startup = append(startup, c)
+		}
+	}
+	startup = append(startup, plannedCommand{creationCommand(pools), "at startup"})
+	if e.pool.vdevs {
+		for _, p := range pools {
+			startup = append(startup, plannedCommand{zdbConfigCommand(p), "at startup"})
+		}
+		startup = append(startup, plannedCommand{ashiftCommand(pools), "at startup, for the pools zdb -C fails for"})
+	}
+	plans := []collectorPlan{{"startup", startup}}
+	if e.pools != nil {
+		plans = append(plans, collectorPlan{"pool", pool.plan(pools)})
+	}
+	if e.filter.allowsAny(zfsVersionInfoDesc, zfsVersionMismatchDesc) {
+		plans = append(plans, collectorPlan{"version", []plannedCommand{{versionCommand, ""}}})
+	}
+	for _, c := range e.collectors {
+		if p, ok := c.collector.(planner); ok {
+			plans = append(plans, collectorPlan{c.name, p.plan(pools)})
+		}
+	}
+	return plans
+}
+
+// plan returns the commands of collect, with the zpool status options
+// probeCapabilities may turn off.
+func (c *poolCollector) plan(pools []zpool) []plannedCommand {
+	opts := *c.opts
+	opts.slowIOs, opts.parsable = true, true
+	plan := []plannedCommand{
+		{listCommand(pools), ""},
+		{propertiesCommand(pools), ""},
+		{rootSpaceCommand(pools), ""},
+	}
+	if opts.vdevs {
+		plan = append(plan, plannedCommand{vdevListCommand(pools), ""})
+	}
+	const probed = "-s and -p where the probes found them supported"
+	status := probed
+	if opts.healthyInterval > 0 {
+		plan = append(plan, plannedCommand{command{"zpool", opts.statusXArgs(poolNames(pools)...)}, probed})
+		status = fmt.Sprintf("for the pools zpool status -x reports unhealthy, and every %s for the others; %s", opts.healthyInterval, probed)
+	}
+	for _, pool := range pools {
+		plan = append(plan, plannedCommand{command{"zpool", opts.statusArgs(pool.name)}, status})
+		if opts.guids {
+			plan = append(plan, plannedCommand{guidsCommand(pool.name), ""})
+		}
+	}
+	return plan
+}
+
+// liner is a runner that runs the commands under a command line of its own,
+// such as that of a --command.nice wrapper or of ssh.
+type liner interface {
+	line(name string, args []string) []string
+}
+
+// runnerLine returns the command line r runs c with.
+func runnerLine(r commandRunner, c command) string {
+	for {
+		switch runner := r.(type) {
+		case liner:
+			words := runner.line(c.name, c.args)
+			return commandLine(words[0], words[1:])
+		case instrumentedRunner:
+			r = runner.commandRunner
+		case debugRunner:
+			r = runner.commandRunner
+		default:
+			return commandLine(c.name, c.args)
+		}
+	}
+}
+
+// printPlan prints the commands of e.plan as its runner runs them. The only
+// command it runs is the zpool list telling the cache files of the pools for
+// -collector.cachefile.
+func printPlan(w io.Writer, e *Exporter) {
+	pools := append([]zpool(nil), *e.zpools...)
+	for _, c := range e.collectors {
+		if _, ok := c.collector.(cachefileCollector); ok {
+			if err := listPools(e.runner, pools); err != nil {
+				log.Printf("Warning: could not list the pools to find their cache files, assuming %s: %s", defaultCachefile, err)
+			}
+		}
+	}
+	for _, plan := range e.plan(pools) {
+		if len(plan.commands) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "# %s\n", plan.name)
+		for _, c := range plan.commands {
+			line := runnerLine(e.runner, c.command)
+			if c.when != "" {
+				line += "  # " + c.when
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+}
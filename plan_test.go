@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// callRecorder records the command lines it runs through another runner.
+type callRecorder struct {
+	commandRunner
+	calls []string
+}
+
+func (r *callRecorder) run(name string, args ...string) (string, error) {
+	r.calls = append(r.calls, commandLine(name, args))
+	return r.commandRunner.run(name, args...)
+}
+
+func (r *callRecorder) start(name string, args ...string) (io.ReadCloser, error) {
+	r.calls = append(r.calls, commandLine(name, args))
+	return r.commandRunner.start(name, args...)
+}
+
+// TestPlanCoversCollection checks that the plan lists every command the
+// setup and a scrape of the mock exporter run.
+func TestPlanCoversCollection(t *testing.T) {
+	// A debugRunner, since only the mock runner under one runs without ZFS
+	debug := &debugState{}
+	r := debugRunner{mockRunner{}, debug}
+	e := newMockExporterWith(t, r)
+	e.pool.guids = true
+	e.pool.healthyInterval = 0
+	e.addCollector("pool-snapshot-space", snapshotSpaceCollector{})
+	reg := prometheus.NewRegistry()
+	if err := e.Register(reg); err != nil {
+		t.Fatalf("Error in Register (%s)", err)
+	}
+	if _, err := reg.Gather(); err != nil {
+		t.Fatalf("Error in Gather (%s)", err)
+	}
+
+	planned := map[string]bool{}
+	for _, plan := range e.plan(*e.zpools) {
+		for _, c := range plan.commands {
+			planned[runnerLine(r, c.command)] = true
+		}
+	}
+	if len(debug.commands) == 0 {
+		t.Fatalf("No commands recorded")
+	}
+	for _, c := range debug.commands {
+		if !planned[c.Command] {
+			t.Errorf("%s was run but is not in the plan", c.Command)
+		}
+	}
+}
+
+func TestPrintPlan(t *testing.T) {
+	pools := []zpool{{name: "tank"}, {name: "backup"}}
+	e := NewExporter(&pools)
+	r := &callRecorder{commandRunner: failingRunner{}}
+	e.runner = r
+	e.pool = poolOptions{healthyInterval: 10 * time.Minute}
+	e.addCollector("iostat", &iostatCollector{interval: 5})
+	e.addCollector("arc", newARCCollector())
+	var out bytes.Buffer
+	printPlan(&out, e)
+	if len(r.calls) != 0 {
+		t.Errorf("printPlan should not run commands without -collector.cachefile, ran %q", r.calls)
+	}
+	for _, want := range []string{
+		"# startup\nzpool list tank  # at startup\n",
+		"\n# pool\nzpool list -Hp -o ",
+		"zpool status -x -s -p tank backup  # -s and -p where",
+		"zpool status -s -p backup  # for the pools zpool status -x reports unhealthy, and every 10m0s for the others;",
+		"\n# version\nzfs version\n",
+		"\n# iostat\nzpool iostat -Hp tank backup 5 2\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Plan should contain %q, got:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "# arc") {
+		t.Errorf("Plan should leave out collectors without commands, got:\n%s", out.String())
+	}
+
+	e.addCollector("cachefile", cachefileCollector{})
+	out.Reset()
+	printPlan(&out, e)
+	if len(r.calls) != 1 || !strings.HasPrefix(r.calls[0], "zpool list -Hp") {
+		t.Errorf("printPlan should only run zpool list for -collector.cachefile, ran %q", r.calls)
+	}
+	if !strings.Contains(out.String(), "# cachefile\nzdb -C -U /etc/zfs/zpool.cache\n") {
+		t.Errorf("Plan should read the default cache file, got:\n%s", out.String())
+	}
+}
+
+func TestRunnerLine(t *testing.T) {
+	c := command{"zpool", []string{"status", "my pool"}}
+	if got := runnerLine(staticRunner{}, c); got != `zpool status "my pool"` {
+		t.Errorf("Incorrect line %q for a runner without one", got)
+	}
+	wrapped := instrumentedRunner{execRunner{wrapper: []string{"/usr/bin/nice", "-n", "10"}}, newCommandMetrics()}
+	if got := runnerLine(wrapped, command{"no-such-command", []string{"-x"}}); got != "/usr/bin/nice -n 10 no-such-command -x" {
+		t.Errorf("Incorrect line %q for a command not in PATH", got)
+	}
+	if got := runnerLine(wrapped, command{"sh", []string{"-c", "true"}}); !strings.HasPrefix(got, "/usr/bin/nice -n 10 /") || !strings.HasSuffix(got, "/sh -c true") {
+		t.Errorf("Incorrect line %q for a wrapped command", got)
+	}
+	ssh := &sshRunner{ssh: "/usr/bin/ssh", target: sshTarget{host: "nas", connectTimeout: 5 * time.Second, controlPersist: time.Minute}, controlDir: "/tmp/pzfs"}
+	if got := runnerLine(debugRunner{ssh, &debugState{}}, c); !strings.HasPrefix(got, "/usr/bin/ssh -T ") || !strings.HasSuffix(got, ` nas -- "zpool status 'my pool'"`) {
+		t.Errorf("Incorrect line %q over ssh", got)
+	}
+}
+
+// TestPrintCommands checks that --print-commands exits without ZFS and
+// without listening.
+func TestPrintCommands(t *testing.T) {
+	busy, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	t.Setenv("PATH", t.TempDir())
+	if err := run([]string{"--print-commands", "--collector.dataset", "--port", strconv.Itoa(busy.Addr().(*net.TCPAddr).Port)}); err != nil {
+		t.Errorf("Error in run with --print-commands (%s)", err)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+// disableProcCollectors turns off the enabled collectors that read the
+// kstats and module parameters ZFS on Linux has in /proc and /sys when goos
+// has neither, as for OpenZFS on Windows, rather than letting each of them
+// fail on the first scrape. It returns the flags of the ones it turned off.
+func disableProcCollectors(goos string) []string {
+	if goos != "windows" {
+		return nil
+	}
+	return disableLocalCollectors()
+}
+
+// disableLocalCollectors turns off the enabled collectors that read files
+// of this machine, the ones of disableProcCollectors, and returns their
+// flags.
+func disableLocalCollectors() []string {
+	var disabled []string
+	for _, c := range []struct {
+		flag  string
+		check *bool
+	}{
+		{"collector.dataset-io", &datasetIOCheck},
+		{"collector.arc", &arcCheck},
+		{"collector.kmem", &kmemCheck},
+		{"collector.dbuf", &dbufCheck},
+	} {
+		if *c.check {
+			*c.check = false
+			disabled = append(disabled, "-"+c.flag)
+		}
+	}
+	if moduleParams != "" {
+		moduleParams = ""
+		disabled = append(disabled, "-collector.module-parameters")
+	}
+	return disabled
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestDisableProcCollectors(t *testing.T) {
+	oldIO, oldARC, oldKmem, oldParams := datasetIOCheck, arcCheck, kmemCheck, moduleParams
+	t.Cleanup(func() {
+		datasetIOCheck, arcCheck, kmemCheck, moduleParams = oldIO, oldARC, oldKmem, oldParams
+	})
+	datasetIOCheck, arcCheck, kmemCheck, moduleParams = false, true, true, "zfs_arc_max"
+	if disabled := disableProcCollectors("linux"); disabled != nil || !arcCheck {
+		t.Errorf("Linux should keep the kstat collectors, disabled %v", disabled)
+	}
+	disabled := disableProcCollectors("windows")
+	want := []string{"-collector.arc", "-collector.kmem", "-collector.module-parameters"}
+	if !reflect.DeepEqual(disabled, want) || arcCheck || kmemCheck || moduleParams != "" {
+		t.Errorf("Windows should disable the kstat collectors, disabled %v, should be %v", disabled, want)
+	}
+}
+
+// crlfRunner ends the lines of the output of commandRunner in \r\n, as
+// OpenZFS on Windows does.
+type crlfRunner struct {
+	commandRunner
+}
+
+func (r crlfRunner) run(name string, args ...string) (string, error) {
+	out, err := r.commandRunner.run(name, args...)
+	return strings.ReplaceAll(out, "\n", "\r\n"), err
+}
+
+func (r crlfRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	out, err := r.run(name, args...)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(strings.NewReader(out)), nil
+}
+
+// TestParsersCRLF checks that every parser gives the same metrics for the
+// mock fixtures with \r\n line endings.
+func TestParsersCRLF(t *testing.T) {
+	// setup looks zpool up in PATH unless it runs the mock runner itself.
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "zpool"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	gather := func(e *Exporter) map[string]string {
+		got := map[string]string{}
+		for _, m := range e.snapshot(nil) {
+			name := descName(m.Desc())
+			var d dto.Metric
+			if err := m.Write(&d); err != nil {
+				t.Fatal(err)
+			}
+			var labels []string
+			for _, l := range d.Label {
+				labels = append(labels, l.GetName()+"="+l.GetValue())
+			}
+			key := name + "{" + strings.Join(labels, ",") + "}"
+			if strings.Contains(name, "seconds") {
+				got[key] = "" // durations and ages change between collections
+			} else {
+				d.Label = nil
+				if c := d.Counter; c != nil {
+					c.CreatedTimestamp = nil
+				}
+				got[key] = d.String()
+			}
+		}
+		return got
+	}
+	want := gather(newMockExporter(t))
+	got := gather(newMockExporterWith(t, crlfRunner{mockRunner{}}))
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("Incorrect %s with CRLF line endings: %q, should be %q", key, got[key], value)
+		}
+	}
+	for key := range got {
+		if _, ok := want[key]; !ok {
+			t.Errorf("Unexpected %s with CRLF line endings", key)
+		}
+	}
+}
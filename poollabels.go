@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// poolLabelRule adds labels to the metrics of the pools it matches, the pool
+// named pool or those whose whole name matches regex.
+type poolLabelRule struct {
+	Pool   string            `yaml:"pool"`
+	Regex  string            `yaml:"regex"`
+	Labels map[string]string `yaml:"labels"`
+
+	re *regexp.Regexp
+}
+
+func (r poolLabelRule) matches(pool string) bool {
+	if r.re != nil {
+		return r.re.MatchString(pool)
+	}
+	return r.Pool == pool
+}
+
+// poolLabelFile is the file of --pool-labels.file, such as
+//
+//	pools:
+//	  - pool: tank
+//	    labels: {role: vm, tier: fast}
+//	  - regex: backup-.*
+//	    labels: {role: backup}
+type poolLabelFile struct {
+	Pools []poolLabelRule `yaml:"pools"`
+}
+
+// parsePoolLabels parses and validates the rules of a pool label file. The
+// labels must differ from those of the exporter and from static, the labels
+// of -label, since a metric cannot have a label twice.
+func parsePoolLabels(b []byte, static prometheus.Labels) ([]poolLabelRule, error) {
+	var file poolLabelFile
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	for i := range file.Pools {
+		rule := &file.Pools[i]
+		switch {
+		case (rule.Pool == "") == (rule.Regex == ""):
+			return nil, fmt.Errorf("rule %d should have either pool or regex", i+1)
+		case len(rule.Labels) == 0:
+			return nil, fmt.Errorf("rule %d has no labels", i+1)
+		}
+		if rule.Regex != "" {
+			re, err := regexp.Compile("^(?:" + rule.Regex + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: invalid regex: %s", i+1, err)
+			}
+			rule.re = re
+		}
+		for name := range rule.Labels {
+			switch {
+			case !labelNameRE.MatchString(name) || strings.HasPrefix(name, "__"):
+				return nil, fmt.Errorf("rule %d: %q is not a legal label name", i+1, name)
+			case stringInSlice(name, reservedLabels):
+				return nil, fmt.Errorf("rule %d: label %q is used by the exporter", i+1, name)
+			}
+			if _, ok := static[name]; ok {
+				return nil, fmt.Errorf("rule %d: label %q is also set on every metric, with -label, -hostname or -ssh.host", i+1, name)
+			}
+		}
+	}
+	return file.Pools, nil
+}
+
+// poolLabels are the rules of --pool-labels.file, read again on reload. A
+// file that fails to read or parse on reload leaves the rules as they were.
+type poolLabels struct {
+	path   string
+	static prometheus.Labels
+	// pools returns the monitored pools, the only ones labeled; nil before
+	// the exporter is set up.
+	pools func() []string
+
+	mutex sync.Mutex
+	rules []poolLabelRule
+}
+
+// load reads the rules from l.path and logs how many of pools they apply
+// to.
+func (l *poolLabels) load(pools []string) error {
+	b, err := os.ReadFile(l.path)
+	if err != nil {
+		return err
+	}
+	rules, err := parsePoolLabels(b, l.static)
+	if err != nil {
+		return fmt.Errorf("%s: %s", l.path, err)
+	}
+	l.mutex.Lock()
+	l.rules = rules
+	l.mutex.Unlock()
+	applied, labeled := map[int]bool{}, 0
+	for _, pool := range pools {
+		if i, _ := l.match(pool); i >= 0 {
+			applied[i] = true
+			labeled++
+		}
+	}
+	log.Printf("Loaded %d pool label rules from %s, %d of them apply to %d of the %d monitored pools", len(rules), l.path, len(applied), labeled, len(pools))
+	return nil
+}
+
+// match returns the index of the first rule matching pool and the rule, -1
+// if none does.
+func (l *poolLabels) match(pool string) (int, poolLabelRule) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for i, rule := range l.rules {
+		if rule.matches(pool) {
+			return i, rule
+		}
+	}
+	return -1, poolLabelRule{}
+}
+
+// labels returns the label pairs of the first rule matching pool, sorted by
+// name, and nil if none does.
+func (l *poolLabels) labels(pool string) []*dto.LabelPair {
+	_, rule := l.match(pool)
+	var pairs []*dto.LabelPair
+	for name, value := range rule.Labels {
+		pairs = append(pairs, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].GetName() < pairs[j].GetName() })
+	return pairs
+}
+
+// poolLabelNames are the labels that name the pool of a metric or something
+// within it, such as a dataset, tried in order.
+var poolLabelNames = []string{"name", "dataset"}
+
+// poolOf returns the pool of a label value such as tank, tank/home or
+// tank/home@daily.
+func poolOf(value string) string {
+	if i := strings.IndexAny(value, "/@#"); i >= 0 {
+		return value[:i]
+	}
+	return value
+}
+
+// monitoredPools returns the names of the pools to monitor.
+func (e *Exporter) monitoredPools() []string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]string(nil), e.wantPools...)
+}
+
+// poolLabelGatherer adds the labels of the pool label rules to the metrics
+// of the monitored pools, and of their datasets, in what the wrapped
+// Gatherer returns. Only label values naming a monitored pool count, so that
+// a kmem cache or module parameter never gets the labels of a pool.
+type poolLabelGatherer struct {
+	prometheus.Gatherer
+	labels *poolLabels
+}
+
+func (g poolLabelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	if g.labels.pools == nil {
+		return families, err
+	}
+	monitored := map[string][]*dto.LabelPair{}
+	for _, pool := range g.labels.pools() {
+		monitored[pool] = g.labels.labels(pool)
+	}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			pairs := poolPairs(m, monitored)
+			if len(pairs) == 0 {
+				continue
+			}
+			m.Label = append(m.Label, pairs...)
+			sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+		}
+	}
+	return families, err
+}
+
+// poolPairs returns the labels of the pool of m in monitored, nil when m
+// does not belong to a monitored pool.
+func poolPairs(m *dto.Metric, monitored map[string][]*dto.LabelPair) []*dto.LabelPair {
+	for _, name := range poolLabelNames {
+		for _, l := range m.GetLabel() {
+			if l.GetName() == name {
+				return monitored[poolOf(l.GetValue())]
+			}
+		}
+	}
+	return nil
+}
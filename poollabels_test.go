@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const poolLabelsYAML = `pools:
+  - pool: tank
+    labels: {role: vm, tier: fast}
+  - regex: backup-.*
+    labels: {role: backup}
+  - regex: .*
+    labels: {role: other}
+`
+
+func TestParsePoolLabels(t *testing.T) {
+	rules, err := parsePoolLabels([]byte(poolLabelsYAML), prometheus.Labels{"cluster": "eu1"})
+	if err != nil {
+		t.Fatalf("Error in parsePoolLabels (%s)", err)
+	}
+	if len(rules) != 3 || !rules[0].matches("tank") || rules[0].matches("tank2") || !rules[1].matches("backup-1") || rules[1].matches("old-backup-1") {
+		t.Errorf("Incorrect rules %+v", rules)
+	}
+	if rules, err := parsePoolLabels(nil, nil); err != nil || len(rules) != 0 {
+		t.Errorf("An empty file should have no rules, got %v (%v)", rules, err)
+	}
+
+	for _, file := range []string{
+		"pools:\n  - labels: {role: vm}\n",
+		"pools:\n  - pool: tank\n    regex: tank\n    labels: {role: vm}\n",
+		"pools:\n  - pool: tank\n",
+		"pools:\n  - regex: '('\n    labels: {role: vm}\n",
+		"pools:\n  - pool: tank\n    labels: {name: vm}\n",
+		"pools:\n  - pool: tank\n    labels: {collector: vm}\n",
+		"pools:\n  - pool: tank\n    labels: {cluster: eu2}\n",
+		"pools:\n  - pool: tank\n    labels: {has-dash: vm}\n",
+		"pools:\n  - pool: tank\n    labels: {__role: vm}\n",
+		"pools:\n  - pool: tank\n    lables: {role: vm}\n",
+		"pools: tank\n",
+	} {
+		if _, err := parsePoolLabels([]byte(file), prometheus.Labels{"cluster": "eu1"}); err == nil {
+			t.Errorf("parsePoolLabels(%q) should produce error", file)
+		}
+	}
+}
+
+func TestPoolLabelGatherer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pools.yml")
+	if err := os.WriteFile(path, []byte(poolLabelsYAML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l := &poolLabels{path: path, pools: func() []string { return []string{"tank", "backup-1", "scratch"} }}
+	if err := l.load(l.pools()); err != nil {
+		t.Fatalf("Error in load (%s)", err)
+	}
+
+	reg := prometheus.NewRegistry()
+	up := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "zpool_up"}, []string{"name"})
+	used := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "zfs_dataset_used_bytes"}, []string{"name"})
+	quota := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "zfs_userspace_quota_bytes"}, []string{"dataset", "user"})
+	param := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "zfs_module_parameter"}, []string{"name"})
+	reg.MustRegister(up, used, quota, param)
+	for _, pool := range []string{"tank", "backup-1", "scratch", "unmonitored"} {
+		up.WithLabelValues(pool).Set(1)
+	}
+	used.WithLabelValues("tank/home").Set(1)
+	used.WithLabelValues("backup-1/tank@daily").Set(1)
+	quota.WithLabelValues("tank/home", "alice").Set(1)
+	param.WithLabelValues("zfs_arc_max").Set(1)
+
+	gather := func() map[string]string {
+		t.Helper()
+		families, err := poolLabelGatherer{reg, l}.Gather()
+		if err != nil {
+			t.Fatalf("Error in Gather (%s)", err)
+		}
+		got := map[string]string{}
+		for _, family := range families {
+			for _, m := range family.GetMetric() {
+				var key, labels []string
+				for _, pair := range m.GetLabel() {
+					if pair.GetName() == "role" || pair.GetName() == "tier" {
+						labels = append(labels, pair.GetName()+"="+pair.GetValue())
+					} else {
+						key = append(key, pair.GetValue())
+					}
+				}
+				got[family.GetName()+" "+strings.Join(key, ",")] = strings.Join(labels, ",")
+			}
+		}
+		return got
+	}
+	want := map[string]string{
+		"zpool_up tank":                              "role=vm,tier=fast",
+		"zpool_up backup-1":                          "role=backup",
+		"zpool_up scratch":                           "role=other",
+		"zpool_up unmonitored":                       "",
+		"zfs_dataset_used_bytes tank/home":           "role=vm,tier=fast",
+		"zfs_dataset_used_bytes backup-1/tank@daily": "role=backup",
+		"zfs_userspace_quota_bytes tank/home,alice":  "role=vm,tier=fast",
+		"zfs_module_parameter zfs_arc_max":           "",
+	}
+	got := gather()
+	for key, labels := range want {
+		if got[key] != labels {
+			t.Errorf("Incorrect pool labels of %s (%q), should be %q", key, got[key], labels)
+		}
+	}
+
+	// A file that no longer parses keeps the rules, a valid one replaces
+	// them.
+	os.WriteFile(path, []byte("pools:\n  - pool: tank\n    labels: {name: x}\n"), 0o644)
+	if err := l.load(l.pools()); err == nil {
+		t.Errorf("Loading an invalid file should produce error")
+	}
+	if got := gather(); got["zpool_up tank"] != "role=vm,tier=fast" {
+		t.Errorf("An invalid file should keep the labels, got %q", got["zpool_up tank"])
+	}
+	os.WriteFile(path, []byte("pools:\n  - pool: scratch\n    labels: {role: scratch}\n"), 0o644)
+	if err := l.load(l.pools()); err != nil {
+		t.Fatalf("Error in load (%s)", err)
+	}
+	if got := gather(); got["zpool_up tank"] != "" || got["zpool_up scratch"] != "role=scratch" {
+		t.Errorf("Incorrect labels after a reload %v", got)
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	zpoolUpDesc = prometheus.NewDesc("zpool_up",
+		"Whether the last collection of the zpool succeeded (1) or not (0); the other zpool metrics are absent while it fails", []string{"name"}, nil)
+	poolCollectErrorsDesc = prometheus.NewDesc("zfs_exporter_pool_collect_errors_total",
+		"Number of collections of the zpool that failed", []string{"name"}, nil)
+	parseErrorsDesc = prometheus.NewDesc("zfs_exporter_parse_errors_total",
+		"Number of zpool outputs of a pool in a format the exporter does not recognize, by the command that printed it", []string{"command"}, nil)
+	formatUnrecognizedDesc = prometheus.NewDesc("zfs_exporter_output_format_unrecognized",
+		"Whether the last collection found zpool output in a format the exporter does not recognize (1) or not (0), as after an upgrade of ZFS", nil, nil)
+	zpoolCapacityDesc = prometheus.NewDesc("zpool_capacity_percentage",
+		"Current zpool capacity level", []string{"name"}, nil)
+	zpoolCapacityRatioDesc = prometheus.NewDesc("zpool_capacity_ratio",
+		"Allocated fraction of the zpool size from zpool list, from 0 to 1; the size includes raidz parity and the slop space, so writes can fail below 1", []string{"name"}, nil)
+	zpoolUsableCapacityDesc = prometheus.NewDesc("zpool_usable_capacity_ratio",
+		"Used fraction of the space the root dataset of the zpool can use, used/(used+available), from 0 to 1; writes fail as it reaches 1", []string{"name"}, nil)
+	zpoolSizeDesc = prometheus.NewDesc("zpool_size_bytes",
+		"Size of the zpool from zpool list, including raidz parity and the slop space", []string{"name"}, nil)
+	zpoolAllocatedDesc = prometheus.NewDesc("zpool_allocated_bytes",
+		"Allocated bytes of the zpool from zpool list", []string{"name"}, nil)
+	zpoolFreeDesc = prometheus.NewDesc("zpool_free_bytes",
+		"Unallocated bytes of the zpool from zpool list, including the slop space, so more than the datasets can write", []string{"name"}, nil)
+	zpoolUsableUsedDesc = prometheus.NewDesc("zpool_usable_used_bytes",
+		"Bytes used by the root dataset of the zpool and everything in it, from zfs get used", []string{"name"}, nil)
+	zpoolUsableAvailableDesc = prometheus.NewDesc("zpool_usable_available_bytes",
+		"Bytes the root dataset of the zpool can still write, from zfs get available; writes fail as it reaches 0", []string{"name"}, nil)
+	zpoolTagInfoDesc = prometheus.NewDesc("zpool_tag_info",
+		"The tag --pool gives the zpool, such as boot or root, always 1; absent for untagged pools", []string{"name", "tag"}, nil)
+	zpoolOnlineDesc = prometheus.NewDesc("zpool_online_providers_count",
+		"Number of ONLINE zpool providers (disks)", []string{"name"}, nil)
+	zpoolFaultedDesc = prometheus.NewDesc("zpool_faulted_providers_count",
+		"Number of FAULTED/UNAVAIL zpool providers (disks)", []string{"name"}, nil)
+	zpoolLogsUnavailableDesc = prometheus.NewDesc("zpool_log_devices_unavailable_count",
+		"Number of FAULTED/UNAVAIL log devices (SLOGs) of the zpool, also counted in zpool_faulted_providers_count", []string{"name"}, nil)
+	zpoolCacheUnavailableDesc = prometheus.NewDesc("zpool_cache_devices_unavailable_count",
+		"Number of FAULTED/UNAVAIL cache devices (L2ARC) of the zpool, also counted in zpool_faulted_providers_count", []string{"name"}, nil)
+	zpoolConfiguredDesc = prometheus.NewDesc("zpool_configured_providers_count",
+		"Number of zpool providers (disks) in the config section of zpool status, whatever their state", []string{"name"}, nil)
+	zpoolExpectedDesc = prometheus.NewDesc("zpool_expected_providers_count",
+		"Number of providers the zpool should have, from --expected-providers; absent for pools without one", []string{"name"}, nil)
+	zpoolStatusWarningDesc = prometheus.NewDesc("zpool_status_has_warning",
+		"Whether zpool status shows a status: advisory for the zpool (1) or not (0)", []string{"name"}, nil)
+	zpoolStatusReasonDesc = prometheus.NewDesc("zpool_status_reason_info",
+		"The status: advisory zpool status shows for the zpool as a short code, always 1; absent when there is none", []string{"name", "reason"}, nil)
+	zpoolCreationDesc = prometheus.NewDesc("zpool_creation_timestamp_seconds",
+		"Time the zpool was created, as a unix timestamp", []string{"name"}, nil)
+	zpoolReadonlyDesc = prometheus.NewDesc("zpool_readonly",
+		"Whether the zpool is imported read-only (1) or not (0)", []string{"name"}, nil)
+	zpoolConfigInfoDesc = prometheus.NewDesc("zpool_config_info",
+		"Import settings of the zpool that persist until it is exported, always 1; empty labels are unset", []string{"name", "altroot", "cachefile"}, nil)
+	zpoolPropertiesInfoDesc = prometheus.NewDesc("zpool_properties_info",
+		"Descriptive properties of the zpool, always 1; empty labels are unset", []string{"name", "comment", "bootfs", "version", "guid"}, nil)
+	zpoolLastScrubDesc = prometheus.NewDesc("zpool_last_scrub_timestamp_seconds",
+		"Time the last scrub of the zpool finished, absent (0 with --strict-zero) if none is known", []string{"name"}, nil)
+	zpoolSinceScrubDesc = prometheus.NewDesc("zpool_seconds_since_last_scrub",
+		"Seconds since the last scrub of the zpool finished, absent if none is known", []string{"name"}, nil)
+	zpoolScrubDurationDesc = prometheus.NewDesc("zpool_last_scrub_duration_seconds",
+		"How long the last finished scrub of the zpool took, absent if not known", []string{"name"}, nil)
+	zpoolScrubRepairedDesc = prometheus.NewDesc("zpool_last_scrub_repaired_bytes",
+		"Bytes the last finished scrub of the zpool repaired, absent if not known", []string{"name"}, nil)
+	zpoolScrubErrorsDesc = prometheus.NewDesc("zpool_last_scrub_errors",
+		"Number of errors the last finished scrub of the zpool found, absent if not known", []string{"name"}, nil)
+	zpoolLastScanDesc = prometheus.NewDesc("zpool_last_scan_attempt_timestamp_seconds",
+		"Time the last scrub or resilver of the zpool finished or was canceled, absent (0 with --strict-zero) if none is known", []string{"name"}, nil)
+	zpoolScanCanceledDesc = prometheus.NewDesc("zpool_last_scan_cancelled",
+		"Whether the last scrub or resilver of the zpool to end was canceled (1) or finished (0), absent (0 with --strict-zero) if none is known", []string{"name"}, nil)
+	zpoolNeverScrubbedDesc = prometheus.NewDesc("zpool_never_scrubbed",
+		"Whether no scrub or resilver was ever requested on the zpool (1) or not (0)", []string{"name"}, nil)
+	zpoolScanRateDesc = prometheus.NewDesc("zpool_scan_rate_bytes_per_second",
+		"Scan rate of the scrub or resilver in progress, absent when no rate is shown yet, and when none is running unless --strict-zero makes it 0", []string{"name"}, nil)
+	zpoolScrubPausedDesc = prometheus.NewDesc("zpool_scrub_paused",
+		"Whether a scrub of the zpool is paused (1) or not (0)", []string{"name"}, nil)
+	zpoolScanScannedDesc = prometheus.NewDesc("zpool_scan_scanned_bytes",
+		"Bytes scanned by the active scrub or resilver", []string{"name"}, nil)
+	zpoolScanIssuedDesc = prometheus.NewDesc("zpool_scan_issued_bytes",
+		"Bytes issued by the active scrub or resilver, absent on releases that do not report it", []string{"name"}, nil)
+	zpoolScanTotalDesc = prometheus.NewDesc("zpool_scan_total_bytes",
+		"Total bytes to be scanned by the active scrub or resilver", []string{"name"}, nil)
+	zpoolDDTEntriesDesc = prometheus.NewDesc("zpool_ddt_entries",
+		"Number of entries in the dedup table of the zpool", []string{"name"}, nil)
+	zpoolDDTOnDiskDesc = prometheus.NewDesc("zpool_ddt_size_bytes_on_disk",
+		"Size of the dedup table of the zpool on disk", []string{"name"}, nil)
+	zpoolDDTInCoreDesc = prometheus.NewDesc("zpool_ddt_size_bytes_in_core",
+		"Size of the dedup table of the zpool in memory", []string{"name"}, nil)
+	zpoolActivityDesc = prometheus.NewDesc("zpool_activity_in_progress",
+		"Whether the activity (discard, initialize, remove, resilver, scrub or trim) is in progress on the zpool (1) or not (0)", []string{"name", "activity"}, nil)
+	zpoolActivityDoneDesc = prometheus.NewDesc("zpool_activity_percent_done",
+		"Progress of the initialize, remove or trim in progress on the zpool, averaged over its vdevs", []string{"name", "activity"}, nil)
+	zpoolSlowIOsDesc = prometheus.NewDesc("zpool_device_slow_ios_total",
+		"Number of I/Os of the device that took longer than zio_slow_io_ms, absent where zpool status -s is not supported", []string{"name", "device", "enclosure", "slot", "guid"}, nil)
+	zpoolDeviceResilveringDesc = prometheus.NewDesc("zpool_device_resilvering",
+		"Whether zpool status notes that the device is being resilvered or waits for a resilver (1) or not (0)", []string{"name", "device", "enclosure", "slot", "guid"}, nil)
+	zpoolDeviceInitializingDesc = prometheus.NewDesc("zpool_device_initialize_in_progress",
+		"Whether zpool initialize is writing to the device (1) or not (0), absent for devices never initialized", []string{"name", "device", "enclosure", "slot", "guid"}, nil)
+	zpoolDeviceInitializeDoneDesc = prometheus.NewDesc("zpool_device_initialize_percent_done",
+		"Progress of the last zpool initialize of the device, absent for devices never initialized", []string{"name", "device", "enclosure", "slot", "guid"}, nil)
+	zpoolDeviceLastInitializeDesc = prometheus.NewDesc("zpool_device_last_initialize_timestamp_seconds",
+		"When the last zpool initialize of the device completed, absent (0 with --strict-zero) unless it completed", []string{"name", "device", "enclosure", "slot", "guid"}, nil)
+	zpoolPermanentErrorsDesc = prometheus.NewDesc("zpool_permanent_errors",
+		"Number of files and objects of the zpool with data errors redundancy could not repair, as listed by zpool status", []string{"name"}, nil)
+	zpoolPermanentErrorDesc = prometheus.NewDesc("zpool_permanent_error_info",
+		"File or object of the zpool with a permanent data error, always 1; file paths are hashed unless --permanent-errors.show-paths is set", []string{"name", "kind", "entry"}, nil)
+	zpoolVdevFragDesc = prometheus.NewDesc("zpool_vdev_fragmentation_percentage",
+		"Fragmentation of the free space of the top-level vdev", []string{"name", "vdev"}, nil)
+	zpoolVdevCapacityDesc = prometheus.NewDesc("zpool_vdev_capacity_ratio",
+		"Allocated fraction of the top-level vdev", []string{"name", "vdev"}, nil)
+	zpoolVdevCapacityMaxDesc = prometheus.NewDesc("zpool_vdev_capacity_max_ratio",
+		"Allocated fraction of the fullest top-level vdev of the zpool, not counting log, cache, special and dedup vdevs; above zpool_capacity_ratio when the vdevs are filled unevenly", []string{"name"}, nil)
+	zpoolIndirectDesc = prometheus.NewDesc("zpool_indirect_vdev_count",
+		"Number of indirect vdevs left in the zpool by top-level vdevs removed with zpool remove", []string{"name"}, nil)
+	zpoolRemovingDesc = prometheus.NewDesc("zpool_removing_bytes",
+		"Bytes left to copy off the top-level vdev being removed, absent (0 with --strict-zero) when no removal is in progress", []string{"name"}, nil)
+	zpoolRemovalInProgressDesc = prometheus.NewDesc("zpool_removal_in_progress",
+		"Whether the removal of a top-level vdev from the zpool is in progress (1) or not (0), absent (0 with --strict-zero) when no vdev was removed since the pool was imported", []string{"name"}, nil)
+	zpoolRemovalCopiedDesc = prometheus.NewDesc("zpool_removal_copied_bytes",
+		"Bytes copied off the top-level vdev being removed, or by the last removal that completed", []string{"name"}, nil)
+	zpoolRemovalTotalDesc = prometheus.NewDesc("zpool_removal_total_bytes",
+		"Bytes to copy off the top-level vdev being removed, or copied by the last removal that completed", []string{"name"}, nil)
+	zpoolVdevAshiftDesc = prometheus.NewDesc("zpool_vdev_ashift",
+		"ashift (log2 of the sector size) of the top-level vdev, vdev is empty when only the pool property is known", []string{"name", "vdev"}, nil)
+)
+
+// poolCollector exports the metrics of every pool from zpool list and zpool
+// status. It is the default collector; the details it collects are selected
+// by the options of the exporter.
+type poolCollector struct {
+	zpools *[]zpool
+	opts   *poolOptions
+
+	// failures counts the failed collections of each pool.
+	failures map[string]float64
+
+	// parseErrors counts the unrecognized outputs of each command, and
+	// lastFormat holds the problem last logged for each, so that an output
+	// format that changed is logged once rather than for every pool.
+	parseErrors  map[string]float64
+	lastFormat   map[string]string
+	unrecognized bool
+}
+
+// recordErrors counts every failure and logs the errors of the pools with
+// logProblem, so that a pool failing the same way is not logged on every
+// scrape. When every pool failed the exporter reports the error itself, so
+// quiet skips the logging.
+func (c *poolCollector) recordErrors(pools []zpool, quiet bool) {
+	if c.failures == nil {
+		c.failures = map[string]float64{}
+		// Exported from the start, so that increases show up in rate().
+		c.parseErrors = map[string]float64{"zpool list": 0, "zpool status": 0}
+		c.lastFormat = map[string]string{}
+	}
+	c.unrecognized = false
+	for _, pool := range pools {
+		var ferr *formatError
+		if errors.As(pool.err, &ferr) {
+			c.recordFormatError(ferr)
+		}
+		fields := logFields{"POOL": pool.name}
+		if pool.err == nil {
+			logResolved("pool "+pool.name, fields, "Pool %s is collected again", pool.name)
+			continue
+		}
+		c.failures[pool.name]++
+		if !quiet {
+			logProblem("pool "+pool.name, fields, "Error collecting pool %s: %s", pool.name, pool.err)
+		}
+	}
+}
+
+// recordFormatError counts an unrecognized output and logs it with its
+// first lines when the problem is new for the command, even when the
+// exporter reports the error itself, which leaves the lines out.
+func (c *poolCollector) recordFormatError(err *formatError) {
+	c.unrecognized = true
+	c.parseErrors[err.command]++
+	if c.lastFormat[err.command] == err.problem {
+		return
+	}
+	c.lastFormat[err.command] = err.problem
+	log.Printf("Warning: %s; the ZFS release may print a format this exporter does not support, first lines:\n%s",
+		err, strings.Join(err.lines, "\n"))
+}
+
+func (c *poolCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- zpoolCapacityDesc
+	ch <- zpoolCapacityRatioDesc
+	ch <- zpoolUsableCapacityDesc
+	ch <- zpoolOnlineDesc
+	ch <- zpoolFaultedDesc
+	ch <- zpoolLogsUnavailableDesc
+	ch <- zpoolCacheUnavailableDesc
+	ch <- zpoolConfiguredDesc
+	if len(c.opts.expectedProviders) > 0 {
+		ch <- zpoolExpectedDesc
+	}
+	ch <- zpoolSizeDesc
+	ch <- zpoolAllocatedDesc
+	ch <- zpoolFreeDesc
+	ch <- zpoolUsableUsedDesc
+	ch <- zpoolUsableAvailableDesc
+	if len(c.opts.tags) > 0 {
+		ch <- zpoolTagInfoDesc
+	}
+	ch <- zpoolStatusWarningDesc
+	ch <- zpoolStatusReasonDesc
+	ch <- zpoolUpDesc
+	ch <- poolCollectErrorsDesc
+	describeSummary(ch)
+	ch <- parseErrorsDesc
+	ch <- formatUnrecognizedDesc
+	ch <- zpoolCreationDesc
+	ch <- zpoolReadonlyDesc
+	ch <- zpoolConfigInfoDesc
+	ch <- zpoolPropertiesInfoDesc
+	ch <- zpoolLastScrubDesc
+	ch <- zpoolSinceScrubDesc
+	ch <- zpoolScrubDurationDesc
+	ch <- zpoolScrubRepairedDesc
+	ch <- zpoolScrubErrorsDesc
+	ch <- zpoolLastScanDesc
+	ch <- zpoolScanCanceledDesc
+	ch <- zpoolNeverScrubbedDesc
+	ch <- zpoolScanRateDesc
+	ch <- zpoolScrubPausedDesc
+	ch <- zpoolScanScannedDesc
+	ch <- zpoolScanIssuedDesc
+	ch <- zpoolScanTotalDesc
+	if c.opts.dedup {
+		ch <- zpoolDDTEntriesDesc
+		ch <- zpoolDDTOnDiskDesc
+		ch <- zpoolDDTInCoreDesc
+	}
+	ch <- zpoolSlowIOsDesc
+	ch <- zpoolDeviceResilveringDesc
+	ch <- zpoolPermanentErrorsDesc
+	if c.opts.errorEntries > 0 {
+		ch <- zpoolPermanentErrorDesc
+	}
+	ch <- zpoolRemovalInProgressDesc
+	ch <- zpoolRemovalCopiedDesc
+	ch <- zpoolRemovalTotalDesc
+	if c.opts.activities {
+		ch <- zpoolActivityDesc
+		ch <- zpoolActivityDoneDesc
+		ch <- zpoolDeviceInitializingDesc
+		ch <- zpoolDeviceInitializeDoneDesc
+		ch <- zpoolDeviceLastInitializeDesc
+	}
+	if c.opts.vdevs {
+		ch <- zpoolVdevFragDesc
+		ch <- zpoolVdevCapacityDesc
+		ch <- zpoolVdevCapacityMaxDesc
+		ch <- zpoolVdevAshiftDesc
+		ch <- zpoolIndirectDesc
+		ch <- zpoolRemovingDesc
+	}
+}
+
+func (c *poolCollector) collect(r commandRunner, pools []zpool, ch chan<- prometheus.Metric) error {
+	err := collectPools(r, pools, *c.opts)
+	c.recordErrors(pools, err != nil)
+	for command, n := range c.parseErrors {
+		ch <- prometheus.MustNewConstMetric(parseErrorsDesc, prometheus.CounterValue, n, command)
+	}
+	ch <- prometheus.MustNewConstMetric(formatUnrecognizedDesc, prometheus.GaugeValue, boolToFloat(c.unrecognized))
+	if err != nil {
+		return err
+	}
+	for _, pool := range pools {
+		emitAlways(ch, zpoolUpDesc, boolToFloat(pool.err == nil), pool.name)
+		ch <- prometheus.MustNewConstMetric(poolCollectErrorsDesc, prometheus.CounterValue, c.failures[pool.name], pool.name)
+		if tag, ok := c.opts.tags[pool.name]; ok {
+			ch <- prometheus.MustNewConstMetric(zpoolTagInfoDesc, prometheus.GaugeValue, 1, pool.name, tag)
+		}
+		if pool.err != nil {
+			continue
+		}
+		emitAlways(ch, zpoolCapacityDesc, float64(pool.capacity), pool.name)
+		emitIfKnown(ch, zpoolCapacityRatioDesc, float64(pool.alloc)/float64(pool.size), pool.size > 0, pool.name)
+		total := pool.rootUsed + pool.rootAvailable
+		emitIfKnown(ch, zpoolUsableCapacityDesc, float64(pool.rootUsed)/float64(total), pool.rootSpace && total > 0, pool.name)
+		emitAlways(ch, zpoolSizeDesc, float64(pool.size), pool.name)
+		emitAlways(ch, zpoolAllocatedDesc, float64(pool.alloc), pool.name)
+		emitAlways(ch, zpoolFreeDesc, float64(pool.free), pool.name)
+		emitIfKnown(ch, zpoolUsableUsedDesc, float64(pool.rootUsed), pool.rootSpace, pool.name)
+		emitIfKnown(ch, zpoolUsableAvailableDesc, float64(pool.rootAvailable), pool.rootSpace, pool.name)
+		emitAlways(ch, zpoolOnlineDesc, float64(pool.online), pool.name)
+		emitAlways(ch, zpoolFaultedDesc, float64(pool.faulted), pool.name)
+		emitAlways(ch, zpoolLogsUnavailableDesc, float64(pool.logsFaulted), pool.name)
+		emitAlways(ch, zpoolCacheUnavailableDesc, float64(pool.cacheFaulted), pool.name)
+		emitAlways(ch, zpoolConfiguredDesc, float64(pool.configured), pool.name)
+		if len(c.opts.expectedProviders) > 0 {
+			n, ok := c.opts.expectedProviders[pool.name]
+			emitIfKnown(ch, zpoolExpectedDesc, float64(n), ok, pool.name)
+		}
+		emitAlways(ch, zpoolStatusWarningDesc, boolToFloat(pool.statusReason != ""), pool.name)
+		if pool.statusReason != "" {
+			ch <- prometheus.MustNewConstMetric(zpoolStatusReasonDesc, prometheus.GaugeValue, 1, pool.name, pool.statusReason)
+		}
+
+		emitIfKnown(ch, zpoolCreationDesc, float64(pool.creation), pool.creation > 0, pool.name)
+		emitAlways(ch, zpoolReadonlyDesc, boolToFloat(pool.readonly), pool.name)
+		ch <- prometheus.MustNewConstMetric(zpoolConfigInfoDesc, prometheus.GaugeValue, 1, pool.name, pool.altroot, pool.cachefile)
+		props := pool.properties
+		ch <- prometheus.MustNewConstMetric(zpoolPropertiesInfoDesc, prometheus.GaugeValue, 1, pool.name, props.comment, props.bootfs, props.version, props.guid)
+		scrubbed := !pool.lastScrub.IsZero()
+		emitIfPresent(ch, zpoolLastScrubDesc, float64(pool.lastScrub.Unix()), scrubbed, pool.name)
+		// 0 would read as just scrubbed, so this one stays absent.
+		emitIfKnown(ch, zpoolSinceScrubDesc, time.Since(pool.lastScrub).Seconds(), scrubbed, pool.name)
+		for desc, v := range map[*prometheus.Desc]float64{
+			zpoolScrubDurationDesc: pool.scrubResult.duration,
+			zpoolScrubRepairedDesc: pool.scrubResult.repaired,
+			zpoolScrubErrorsDesc:   pool.scrubResult.errors,
+		} {
+			if !scrubbed || v >= 0 {
+				emitIfPresent(ch, desc, v, scrubbed, pool.name)
+			}
+		}
+		ended := !pool.lastScan.IsZero()
+		emitIfPresent(ch, zpoolLastScanDesc, float64(pool.lastScan.Unix()), ended, pool.name)
+		emitIfPresent(ch, zpoolScanCanceledDesc, boolToFloat(pool.scanCanceled), ended, pool.name)
+		emitAlways(ch, zpoolNeverScrubbedDesc, boolToFloat(pool.neverScrubbed()), pool.name)
+		scanning := pool.scan.state == "in progress" || pool.scan.state == "paused"
+		paused := pool.scan.function == "scrub" && pool.scan.state == "paused"
+		emitAlways(ch, zpoolScrubPausedDesc, boolToFloat(paused), pool.name)
+		for desc, v := range map[*prometheus.Desc]float64{
+			zpoolScanRateDesc:    pool.scan.rate,
+			zpoolScanScannedDesc: pool.scan.scanned,
+			zpoolScanIssuedDesc:  pool.scan.issued,
+			zpoolScanTotalDesc:   pool.scan.total,
+		} {
+			if !scanning || v >= 0 {
+				emitIfPresent(ch, desc, v, scanning && v >= 0, pool.name)
+			}
+		}
+		if c.opts.dedup {
+			var ddt ddtStats
+			if pool.ddt != nil {
+				ddt = *pool.ddt
+			}
+			emitIfPresent(ch, zpoolDDTEntriesDesc, float64(ddt.entries), pool.ddt != nil, pool.name)
+			emitIfPresent(ch, zpoolDDTOnDiskDesc, float64(ddt.onDisk), pool.ddt != nil, pool.name)
+			emitIfPresent(ch, zpoolDDTInCoreDesc, float64(ddt.inCore), pool.ddt != nil, pool.name)
+		}
+		for _, d := range pool.slowIOs {
+			ch <- prometheus.MustNewConstMetric(zpoolSlowIOsDesc, prometheus.CounterValue, d.slow, pool.name, d.device, d.enclosure, d.slot, d.guid)
+		}
+		for _, d := range pool.devices {
+			emitAlways(ch, zpoolDeviceResilveringDesc, boolToFloat(d.resilvering), pool.name, d.device, d.enclosure, d.slot, d.guid)
+		}
+		emitIfKnown(ch, zpoolPermanentErrorsDesc, float64(pool.dataErrors.count), pool.dataErrors.count >= 0, pool.name)
+		seen := map[string]bool{}
+		for _, entry := range pool.dataErrors.entries {
+			if len(seen) == c.opts.errorEntries {
+				break
+			}
+			label := errorEntryLabel(entry, c.opts.showPaths)
+			if seen[label] {
+				continue // truncated to the same label
+			}
+			seen[label] = true
+			ch <- prometheus.MustNewConstMetric(zpoolPermanentErrorDesc, prometheus.GaugeValue, 1, pool.name, errorEntryKind(entry), label)
+		}
+		if c.opts.activities {
+			for _, activity := range poolActivities {
+				emitAlways(ch, zpoolActivityDesc, boolToFloat(pool.activities.inProgress[activity]), pool.name, activity)
+				v, ok := pool.activities.percentDone[activity]
+				emitIfPresent(ch, zpoolActivityDoneDesc, v, ok, pool.name, activity)
+			}
+			for _, d := range pool.devices {
+				if d.initialize == nil {
+					continue
+				}
+				emitAlways(ch, zpoolDeviceInitializingDesc, boolToFloat(d.initialize.state == "started"), pool.name, d.device, d.enclosure, d.slot, d.guid)
+				emitAlways(ch, zpoolDeviceInitializeDoneDesc, d.initialize.percentDone, pool.name, d.device, d.enclosure, d.slot, d.guid)
+				completed := d.initialize.state == "completed" && !d.initialize.at.IsZero()
+				emitIfPresent(ch, zpoolDeviceLastInitializeDesc, float64(d.initialize.at.Unix()), completed, pool.name, d.device, d.enclosure, d.slot, d.guid)
+			}
+		}
+		for _, vdev := range pool.vdevs {
+			emitIfKnown(ch, zpoolVdevFragDesc, float64(vdev.fragmentation), vdev.fragmentation >= 0, pool.name, vdev.name)
+			emitAlways(ch, zpoolVdevCapacityDesc, vdev.capacityRatio(), pool.name, vdev.name)
+		}
+		if c.opts.vdevs {
+			max, ok := maxCapacityRatio(pool.vdevs)
+			emitIfKnown(ch, zpoolVdevCapacityMaxDesc, max, ok, pool.name)
+			emitAlways(ch, zpoolIndirectDesc, float64(pool.indirect), pool.name)
+			if v := pool.removal.remaining(); !pool.removal.inProgress || v >= 0 {
+				emitIfPresent(ch, zpoolRemovingDesc, v, pool.removal.inProgress, pool.name)
+			}
+		}
+		emitIfPresent(ch, zpoolRemovalInProgressDesc, boolToFloat(pool.removal.inProgress), pool.removal.present, pool.name)
+		if !pool.removal.present || pool.removal.total >= 0 {
+			emitIfPresent(ch, zpoolRemovalCopiedDesc, pool.removal.copied, pool.removal.present, pool.name)
+			emitIfPresent(ch, zpoolRemovalTotalDesc, pool.removal.total, pool.removal.present, pool.name)
+		}
+		for _, a := range pool.ashifts {
+			emitAlways(ch, zpoolVdevAshiftDesc, float64(a.ashift), pool.name, a.vdev)
+		}
+	}
+	summaryMetrics(ch, pools)
+
+	return nil
+}
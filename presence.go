@@ -0,0 +1,42 @@
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// The gauges of the collectors are exported with one of three helpers, which
+// define what a missing value turns into:
+//
+//   - emitAlways for values that always exist, 0 when there is nothing to
+//     count, such as zpool_faulted_providers_count or zpool_never_scrubbed.
+//   - emitIfPresent for values of something that may not exist, such as the
+//     last scrub of a pool that was never scrubbed or the quota of a dataset
+//     without one. They are absent then, or 0 with --strict-zero.
+//   - emitIfKnown for values that exist but are unknown, because zpool or zfs
+//     does not report them here, or that are not configured, such as
+//     zpool_expected_providers_count. They are absent then even with
+//     --strict-zero, since 0 would be a wrong value.
+//
+// Series of things that do not exist at all, such as a device or a dataset,
+// and info metrics carrying their value in a label are not affected.
+
+// emitAlways exports the gauge desc with value v.
+func emitAlways(ch chan<- prometheus.Metric, desc *prometheus.Desc, v float64, labels ...string) {
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, labels...)
+}
+
+// emitIfPresent exports the gauge desc with value v when present, and with 0
+// otherwise when strictZero is set.
+func emitIfPresent(ch chan<- prometheus.Metric, desc *prometheus.Desc, v float64, present bool, labels ...string) {
+	switch {
+	case present:
+		emitAlways(ch, desc, v, labels...)
+	case strictZero:
+		emitAlways(ch, desc, 0, labels...)
+	}
+}
+
+// emitIfKnown exports the gauge desc with value v when known.
+func emitIfKnown(ch chan<- prometheus.Metric, desc *prometheus.Desc, v float64, known bool, labels ...string) {
+	if known {
+		emitAlways(ch, desc, v, labels...)
+	}
+}
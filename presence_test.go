@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// idleRunner answers like fixtureRunner for pools that were never scrubbed
+// and have nothing running.
+type idleRunner struct {
+	fixtureRunner
+}
+
+func (r idleRunner) run(name string, args ...string) (string, error) {
+	output, err := r.fixtureRunner.run(name, args...)
+	if len(args) > 0 && args[0] == "status" {
+		output = strings.Replace(output, "scrub repaired 0 in 1h1m with 0 errors on Thu Jan 1 13:37:00 1970", "none requested", 1)
+	}
+	return output, err
+}
+
+func (r idleRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	output, err := r.run(name, args...)
+	return io.NopCloser(strings.NewReader(output)), err
+}
+
+// collectNames returns the names of the metrics a collection of a pool
+// never scrubbed exports.
+func collectNames(t *testing.T, strict bool) map[string]bool {
+	t.Helper()
+	defer func(old bool) { strictZero = old }(strictZero)
+	strictZero = strict
+	pools := []zpool{{name: "tank"}}
+	opts := poolOptions{dedup: true, activities: true, expectedProviders: map[string]int64{"backup": 2}}
+	c := &poolCollector{zpools: &pools, opts: &opts}
+	ch := make(chan prometheus.Metric, 1000)
+	if err := c.collect(idleRunner{}, pools, ch); err != nil {
+		t.Fatalf("Error in collect (%s)", err)
+	}
+	close(ch)
+	names := map[string]bool{}
+	for m := range ch {
+		names[descName(m.Desc())] = true
+	}
+	return names
+}
+
+// TestMissingValues locks in which metrics are exported with 0, left out,
+// or exported as 0 only with --strict-zero when what they measure does not
+// exist or is unknown.
+func TestMissingValues(t *testing.T) {
+	const (
+		always   = iota // exported either way
+		ifStrict        // absent, 0 with --strict-zero
+		never           // absent either way
+	)
+	names := map[bool]map[string]bool{false: collectNames(t, false), true: collectNames(t, true)}
+	for _, test := range []struct {
+		metric   string
+		behavior int
+	}{
+		{"zpool_up", always},
+		{"zpool_capacity_percentage", always},
+		{"zpool_size_bytes", always},
+		{"zpool_free_bytes", always},
+		{"zpool_faulted_providers_count", always},
+		{"zpool_log_devices_unavailable_count", always},
+		{"zpool_cache_devices_unavailable_count", always},
+		{"zpool_status_has_warning", always},
+		{"zpool_readonly", always},
+		{"zpool_never_scrubbed", always},
+		{"zpool_scrub_paused", always},
+		{"zpool_permanent_errors", always},
+		{"zpool_activity_in_progress", always},
+		{"zfs_capacity_max_ratio", always},
+		{"zpool_last_scrub_timestamp_seconds", ifStrict},
+		{"zpool_last_scrub_duration_seconds", ifStrict},
+		{"zpool_last_scrub_repaired_bytes", ifStrict},
+		{"zpool_last_scrub_errors", ifStrict},
+		{"zpool_last_scan_attempt_timestamp_seconds", ifStrict},
+		{"zpool_last_scan_cancelled", ifStrict},
+		{"zpool_scan_rate_bytes_per_second", ifStrict},
+		{"zpool_scan_scanned_bytes", ifStrict},
+		{"zpool_scan_issued_bytes", ifStrict},
+		{"zpool_scan_total_bytes", ifStrict},
+		{"zpool_ddt_entries", ifStrict},
+		{"zpool_ddt_size_bytes_on_disk", ifStrict},
+		{"zpool_ddt_size_bytes_in_core", ifStrict},
+		{"zpool_activity_percent_done", ifStrict},
+		{"zpool_removal_in_progress", ifStrict},
+		{"zpool_removal_copied_bytes", ifStrict},
+		{"zpool_removal_total_bytes", ifStrict},
+		{"zpool_seconds_since_last_scrub", never},
+		{"zpool_expected_providers_count", never},
+		{"zpool_creation_timestamp_seconds", never},
+		{"zpool_status_reason_info", never},
+		{"zpool_tag_info", never},
+	} {
+		for _, strict := range []bool{false, true} {
+			want := test.behavior == always || (test.behavior == ifStrict && strict)
+			if got := names[strict][test.metric]; got != want {
+				t.Errorf("Incorrect presence of %s with strict %t (%t), should be %t", test.metric, strict, got, want)
+			}
+		}
+	}
+}
+
+func TestEmitIfPresent(t *testing.T) {
+	defer func(old bool) { strictZero = old }(strictZero)
+	desc := prometheus.NewDesc("zfs_dataset_quota_bytes", "h", []string{"name"}, nil)
+	for _, test := range []struct {
+		strict, present bool
+		want            []float64
+	}{
+		{false, true, []float64{5}},
+		{false, false, nil},
+		{true, true, []float64{5}},
+		{true, false, []float64{0}},
+	} {
+		strictZero = test.strict
+		ch := make(chan prometheus.Metric, 1)
+		emitIfPresent(ch, desc, 5, test.present, "tank")
+		emitIfKnown(ch, desc, 5, false, "tank")
+		close(ch)
+		var got []float64
+		for m := range ch {
+			got = append(got, metricValue(m))
+		}
+		if len(got) != len(test.want) || (len(got) == 1 && got[0] != test.want[0]) {
+			t.Errorf("Incorrect values with strict %t and present %t (%v), should be %v", test.strict, test.present, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// commandPriority is the CPU and I/O priority of -command.nice and
+// -command.ionice-class to run zpool, zfs and the other commands with, so
+// that scrapes add as little as possible to the latency of a busy pool, such
+// as one resilvering.
+type commandPriority struct {
+	nice        int    // 0 leaves the niceness unchanged
+	ioniceClass string // "" leaves the I/O priority unchanged
+	ioniceLevel int    // within the class, ignored for idle
+}
+
+// ioniceClasses maps the -command.ionice-class names onto the numbers of
+// ionice -c, which are all older util-linux releases and busybox accept.
+var ioniceClasses = map[string]string{"realtime": "1", "best-effort": "2", "idle": "3"}
+
+func (p commandPriority) validate() error {
+	if p.nice < -20 || p.nice > 19 {
+		return errors.New("-command.nice should be from -20 to 19")
+	}
+	if _, ok := ioniceClasses[p.ioniceClass]; p.ioniceClass != "" && !ok {
+		return fmt.Errorf("-command.ionice-class should be idle, best-effort or realtime, not %q", p.ioniceClass)
+	}
+	if p.ioniceLevel < 0 || p.ioniceLevel > 7 {
+		return errors.New("-command.ionice-level should be from 0 to 7")
+	}
+	return nil
+}
+
+// wrapper returns the command line to run every command under for p, such
+// as nice -n 10 ionice -c 3, with the paths lookPath finds, or nil when p
+// leaves the priorities unchanged. ionice only exists on Linux: elsewhere,
+// or when it is not installed, the I/O priority is left out with a warning,
+// and so is the niceness without nice.
+func (p commandPriority) wrapper(lookPath func(string) (string, error)) []string {
+	var words []string
+	if p.nice != 0 {
+		if path, err := lookPath("nice"); err != nil {
+			log.Print("Warning: could not find nice in PATH, running the commands without -command.nice")
+		} else {
+			words = append(words, path, "-n", strconv.Itoa(p.nice))
+		}
+	}
+	if p.ioniceClass != "" {
+		if path, err := lookPath("ionice"); err != nil {
+			log.Print("Warning: could not find ionice in PATH, which only Linux has, running the commands without -command.ionice-class")
+		} else {
+			words = append(words, path, "-c", ioniceClasses[p.ioniceClass])
+			if p.ioniceClass != "idle" {
+				words = append(words, "-n", strconv.Itoa(p.ioniceLevel))
+			}
+		}
+	}
+	return words
+}
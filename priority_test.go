@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCommandPriorityValidate(t *testing.T) {
+	for _, p := range []commandPriority{
+		{nice: -21},
+		{nice: 20},
+		{ioniceClass: "low"},
+		{ioniceClass: "best-effort", ioniceLevel: 8},
+	} {
+		if err := p.validate(); err == nil {
+			t.Errorf("Priority %+v should be invalid", p)
+		}
+	}
+	if err := (commandPriority{nice: 19, ioniceClass: "idle", ioniceLevel: 4}).validate(); err != nil {
+		t.Errorf("Error in validate (%s)", err)
+	}
+}
+
+func TestCommandPriorityWrapper(t *testing.T) {
+	found := func(name string) (string, error) { return "/usr/bin/" + name, nil }
+	for _, test := range []struct {
+		priority commandPriority
+		want     []string
+	}{
+		{commandPriority{ioniceLevel: 4}, nil},
+		{commandPriority{nice: 10}, []string{"/usr/bin/nice", "-n", "10"}},
+		{commandPriority{ioniceClass: "idle", ioniceLevel: 4}, []string{"/usr/bin/ionice", "-c", "3"}},
+		{commandPriority{nice: -5, ioniceClass: "best-effort", ioniceLevel: 7},
+			[]string{"/usr/bin/nice", "-n", "-5", "/usr/bin/ionice", "-c", "2", "-n", "7"}},
+	} {
+		if got := test.priority.wrapper(found); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Incorrect wrapper %q for %+v, should be %q", got, test.priority, test.want)
+		}
+	}
+
+	// As on FreeBSD, which has nice but no ionice.
+	withoutIonice := func(name string) (string, error) {
+		if name == "ionice" {
+			return "", errors.New("not found")
+		}
+		return "/usr/bin/" + name, nil
+	}
+	p := commandPriority{nice: 10, ioniceClass: "idle"}
+	if got, want := p.wrapper(withoutIonice), []string{"/usr/bin/nice", "-n", "10"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect wrapper %q without ionice, should be %q", got, want)
+	}
+}
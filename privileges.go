@@ -0,0 +1,73 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// dropIDs are the user and group to switch to after startup, -1 for the ones
+// to keep.
+type dropIDs struct {
+	uid, gid int
+}
+
+// resolveDropIDs looks up the -drop-user and -drop-group flags, names or
+// numeric IDs. Without -drop-group the primary group of the user is used.
+func resolveDropIDs(userName, groupName string) (dropIDs, error) {
+	ids := dropIDs{uid: -1, gid: -1}
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			u, err = user.LookupId(userName)
+		}
+		if err == nil {
+			ids.uid, _ = strconv.Atoi(u.Uid)
+			ids.gid, _ = strconv.Atoi(u.Gid)
+		} else if ids.uid, err = strconv.Atoi(userName); err != nil || ids.uid < 0 {
+			return ids, fmt.Errorf("invalid -drop-user %q, no such user", userName)
+		}
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			g, err = user.LookupGroupId(groupName)
+		}
+		if err == nil {
+			ids.gid, _ = strconv.Atoi(g.Gid)
+		} else if ids.gid, err = strconv.Atoi(groupName); err != nil || ids.gid < 0 {
+			return ids, fmt.Errorf("invalid -drop-group %q, no such group", groupName)
+		}
+	}
+	return ids, nil
+}
+
+// drop switches the process to the IDs. It fails unless the process ends up
+// running as them.
+func (ids dropIDs) drop() error {
+	if ids.gid >= 0 {
+		if err := syscall.Setgroups([]int{ids.gid}); err != nil {
+			return fmt.Errorf("could not drop supplementary groups: %s", err)
+		}
+		if err := syscall.Setgid(ids.gid); err != nil {
+			return fmt.Errorf("could not change group to %d: %s", ids.gid, err)
+		}
+	}
+	if ids.uid >= 0 {
+		if err := syscall.Setuid(ids.uid); err != nil {
+			return fmt.Errorf("could not change user to %d: %s", ids.uid, err)
+		}
+	}
+	if ids.uid >= 0 && os.Getuid() != ids.uid || ids.gid >= 0 && os.Getgid() != ids.gid {
+		return fmt.Errorf("running as uid %d gid %d after dropping privileges", os.Getuid(), os.Getgid())
+	}
+	if ids.uid > 0 && syscall.Setuid(0) == nil {
+		return fmt.Errorf("could regain root after dropping privileges")
+	}
+	return nil
+}
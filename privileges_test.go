@@ -0,0 +1,41 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os/user"
+	"strconv"
+	"testing"
+)
+
+func TestResolveDropIDs(t *testing.T) {
+	u, err := user.Current()
+	if err != nil {
+		t.Skip("no current user: ", err)
+	}
+	uid, _ := strconv.Atoi(u.Uid)
+	gid, _ := strconv.Atoi(u.Gid)
+
+	for _, test := range []struct {
+		user, group string
+		want        dropIDs
+	}{
+		{"", "", dropIDs{-1, -1}},
+		{u.Username, "", dropIDs{uid, gid}},
+		{u.Uid, "", dropIDs{uid, gid}},
+		{u.Username, "4242", dropIDs{uid, 4242}},
+		{"4242", "", dropIDs{4242, -1}},
+		{"", "4242", dropIDs{-1, 4242}},
+	} {
+		ids, err := resolveDropIDs(test.user, test.group)
+		if err != nil || ids != test.want {
+			t.Errorf("resolveDropIDs(%q, %q) = %+v, %v; should be %+v", test.user, test.group, ids, err, test.want)
+		}
+	}
+	for _, test := range [][2]string{{"no-such-user-here", ""}, {"", "no-such-group-here"}, {"-1", ""}} {
+		if _, err := resolveDropIDs(test[0], test[1]); err == nil {
+			t.Errorf("resolveDropIDs(%q, %q) should produce error", test[0], test[1])
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import "errors"
+
+// dropIDs is unused on Windows, which has no setuid.
+type dropIDs struct {
+	uid, gid int
+}
+
+func resolveDropIDs(userName, groupName string) (dropIDs, error) {
+	if userName != "" || groupName != "" {
+		return dropIDs{}, errors.New("-drop-user and -drop-group are not supported on Windows")
+	}
+	return dropIDs{uid: -1, gid: -1}, nil
+}
+
+func (ids dropIDs) drop() error {
+	return nil
+}
@@ -1,154 +1,1801 @@
 package main
 
 import (
-	"flag"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	flag "github.com/spf13/pflag"
 )
 
 const (
 	toolVersion = "0.1.1"
 )
 
+var (
+	zfsAvailableDesc = prometheus.NewDesc("zfs_exporter_zfs_available",
+		"Whether the zpool command was found and the monitored pools were set up (1) or not (0)", nil, nil)
+)
+
 // Exporter collects zpool stats from the given zpool and exports them using
 // the prometheus metrics package.
 type Exporter struct {
-	mutex  sync.RWMutex
+	// mutex protects inflight and the pools requested through the admin
+	// API. The state below it is only used by the goroutine running the
+	// collection inflight stands for, or before the exporter is registered.
+	mutex    sync.Mutex
+	inflight *scrape
+	// wantPools are the names of the pools to monitor, changed by
+	// ServeAdminPools; poolsChanged is set until syncPools applied them.
+	wantPools    []string
+	poolsChanged bool
+	// configPools are the pools given on the command line, which reload
+	// restores; reloading is set until the collection set the exporter up
+	// again.
+	configPools []string
+	reloading   bool
+	// nameCollisions is how many --pool entries are not monitored because
+	// they collide with another, see dropPoolNameCollisions.
+	nameCollisions int
+
 	zpools *[]zpool
+	runner commandRunner
+	pool   poolOptions
+
+	// available is false until setup succeeded; scrapes retry it. With
+	// keepRunning, a failing collection makes it false again instead of
+	// stopping the exporter.
+	available   bool
+	keepRunning bool
+	// unprobed is set when no pool existed at setup to probe the zpool
+	// status options with, until one is collected.
+	unprobed bool
+	// caps are the capabilities found by probe.
+	caps capabilities
+	// versions exports the versions of the ZFS userland and kernel module.
+	versions versionChecker
+
+	// ready is 1 while the last collection of every pool succeeded. It is
+	// read outside of collections, so it is accessed atomically. Pools that
+	// fail do not stop the others from being exported.
+	ready int32
+	// problems are the poolProblems of the last collection for
+	// /healthz/pools, nil before the first one.
+	problems atomic.Value
+
+	// collected is closed once setup collected the pools for the first time.
+	collected     chan struct{}
+	collectedOnce sync.Once
+
+	// fatal receives collection errors the exporter cannot recover from. When
+	// it is nil they are only logged.
+	fatal chan error
+
+	// pools exports the pool metrics, unless disabled with -collector.pool.
+	pools *poolCollector
+	// health counts the health changes of the pools seen by pools and the
+	// time they spent unhealthy.
+	health healthTracker
+	// deviceErrors keeps the error counters of the devices of the pools
+	// seen by pools across zpool clear, sharing the error reports of the
+	// events collector with it for their exemplars.
+	deviceErrors errorTracker
+	// fullETA estimates when the pools seen by pools fill up, nil unless
+	// --collect-full-eta is set.
+	fullETA *etaTracker
+
+	// collectors are the optional collectors whose metrics were requested.
+	collectors []*optionalCollector
+	// overruns compares the duration of the collections with the time
+	// between the scrapes.
+	overruns overrunTracker
+	// requests are the scrapes being served, which cancel the collection
+	// once all of them disconnected.
+	requests *scrapeRequests
+
+	// poolLabels adds the labels of --pool-labels.file to the metrics of
+	// the pools, nil without it.
+	poolLabels *poolLabels
+
+	// filter selects the metrics to export by name, nil for all of them.
+	// Collectors it exports no metric of are not added.
+	filter *metricFilter
+
+	// scrubs saves the last scrub of the pools to --scrub.state-file, nil
+	// without it.
+	scrubs *scrubState
+
+	// debug keeps what the collections parsed for debugPoolsPath, nil
+	// unless --web.enable-debug is set.
+	debug *debugState
 }
 
 // NewExporter returns an initialized Exporter.
 func NewExporter(pools *[]zpool) *Exporter {
 	// Init and return our exporter.
-	return &Exporter{
-		zpools: pools,
+	e := &Exporter{
+		zpools:      pools,
+		runner:      execRunner{},
+		wantPools:   poolNames(*pools),
+		configPools: poolNames(*pools),
+		collected:   make(chan struct{}),
+		requests:    newScrapeRequests(),
+	}
+	e.pools = &poolCollector{zpools: pools, opts: &e.pool}
+	return e
+}
+
+// Register registers the exporter with reg. It must be called after all
+// collectors were added, since the registry checks that collected metrics
+// were described.
+func (e *Exporter) Register(reg prometheus.Registerer) error {
+	if err := reg.Register(e); err != nil {
+		if _, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return errors.New("an exporter is already registered")
+		}
+		return err
+	}
+	return nil
+}
+
+// setup checks that the zpool command and the monitored pools exist, then
+// collects the pools once, including the details that are only fetched at
+// startup. With ignoreMissing, pools that do not exist are only logged.
+func (e *Exporter) setup() error {
+	if !isOffline(e.runner) && !isRemote(e.runner) {
+		if err := findZpool(); err != nil {
+			return err
+		}
+	}
+	pools := *e.zpools
+	names := poolNames(pools)
+	var present []string
+	for _, name := range names {
+		err := checkExistance(e.runner, name)
+		switch {
+		case errors.Is(err, errNoSuchPool) && e.pool.ignoreMissing:
+			logf(logFields{"POOL": name}, "Warning: pool %s does not exist, exporting zpool_up 0 for it until it is imported", name)
+		case err != nil:
+			return err
+		default:
+			present = append(present, name)
+		}
+	}
+	names = present
+	e.unprobed = len(names) == 0
+	if !e.unprobed {
+		e.probe(names[0])
+	}
+	for i := range pools {
+		pools[i].detailed = false
+	}
+	if err := collectPools(e.runner, pools, e.pool); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&e.ready, boolToInt32(len(collectedPools(pools)) == len(pools)))
+	e.problems.Store(poolProblems(pools))
+	e.fetchDetails()
+	e.available = true
+	e.collectedOnce.Do(func() { close(e.collected) })
+	return nil
+}
+
+// probe detects the capabilities of the installed zpool and zfs by running
+// them on pool, and sets the pool options from them.
+func (e *Exporter) probe(pool string) {
+	e.caps = probeCapabilities(e.runner, pool)
+	log.Printf("Detected %s", e.caps)
+	if e.pool.slowIOs = e.caps.slowIOs; !e.pool.slowIOs {
+		log.Print("zpool status -s is not supported, not exporting slow I/O counts")
+	}
+	if e.pool.parsable = e.caps.parsable; !e.pool.parsable {
+		debugf("zpool status does not support -p, expanding size suffixes")
+	}
+	if e.pool.activities && !e.caps.trim {
+		log.Print("Warning: zpool status -i -t is not supported, not exporting activities; --collect-activities requires OpenZFS 0.8 or later")
+		e.pool.activities = false
+	}
+	e.unprobed = false
+}
+
+// fetchDetails fetches the details that are only read once, the creation
+// time and the vdev ashifts, of the pools collected for the first time since
+// setup: every pool at startup, and later pools that were imported after
+// the exporter started or were added through the admin API.
+func (e *Exporter) fetchDetails() {
+	fresh := func(z *zpool) bool { return z.err == nil && !z.detailed }
+	onPools(*e.zpools, fresh, func(pools []zpool) error {
+		if e.unprobed {
+			e.probe(pools[0].name)
+		}
+		if err := getCreationTimes(e.runner, pools); err != nil {
+			log.Print("Could not get pool creation times: ", err)
+		}
+		if e.pool.vdevs {
+			if err := getAshifts(e.runner, pools); err != nil {
+				log.Print("Could not get vdev ashifts: ", err)
+			}
+		}
+		for i := range pools {
+			pools[i].detailed = true
+		}
+		return nil
+	})
+}
+
+// parsePools returns a zpool for every pool named in the lists, each of
+// which may be a comma separated list. Pools listed more than once are only
+// monitored once, since their metrics would otherwise collide. The tags of
+// the pools are left out, see parsePoolTags.
+func parsePools(lists ...string) []zpool {
+	var pools []zpool
+	var names []string
+	for _, entry := range strings.Split(strings.Join(lists, ","), ",") {
+		name, _ := splitPoolTag(strings.TrimSpace(entry))
+		if name == "" {
+			continue
+		}
+		if stringInSlice(name, names) {
+			logf(logFields{"POOL": name}, "Warning: pool %s is listed more than once", name)
+			continue
+		}
+		names = append(names, name)
+		pools = append(pools, zpool{name: name})
+	}
+	return pools
+}
+
+// splitPoolTag splits a --pool entry such as rpool:root into the pool and
+// its tag. The tag follows the last colon, since pool names may contain
+// colons too: an untagged pool named a:b is given as a:b:.
+func splitPoolTag(entry string) (pool, tag string) {
+	if i := strings.LastIndexByte(entry, ':'); i >= 0 {
+		return entry[:i], entry[i+1:]
+	}
+	return entry, ""
+}
+
+var poolTagRE = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// parsePoolTags returns the tags the --pool entries in the lists give the
+// pools, such as boot for bpool:boot.
+func parsePoolTags(lists ...string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, entry := range strings.Split(strings.Join(lists, ","), ",") {
+		entry = strings.TrimSpace(entry)
+		pool, tag := splitPoolTag(entry)
+		switch {
+		case tag == "":
+			continue
+		case pool == "":
+			return nil, fmt.Errorf("invalid --pool %q, should be pool:tag", entry)
+		case !poolTagRE.MatchString(tag):
+			return nil, fmt.Errorf("invalid --pool %q, the tag should only have letters, digits, _ and -", entry)
+		}
+		if old, ok := tags[pool]; ok && old != tag {
+			return nil, fmt.Errorf("invalid --pool %q, pool %s is already tagged %s", entry, pool, old)
+		}
+		tags[pool] = tag
+	}
+	return tags, nil
+}
+
+// validatePort checks that port is a TCP port number, so that typos are
+// reported before trying to listen.
+func validatePort(port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port %q, should be a number from 1 to 65535", port)
+	}
+	return nil
+}
+
+// validateListenAddress checks that addr is an optional host and a port.
+// IPv6 literals have to be in brackets, as in [fd00::5]:9134, and both
+// fd00::5:9134 and brackets around anything else are rejected.
+func validateListenAddress(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid listen address %q, should be [host]:port", addr)
+	}
+	if bracketed := strings.HasPrefix(addr, "["); bracketed || strings.Contains(host, ":") {
+		ip, _, _ := strings.Cut(host, "%") // zone, as in [fe80::1%eth0]
+		if net.ParseIP(ip) == nil || !strings.Contains(ip, ":") {
+			return fmt.Errorf("invalid listen address %q, should be [host]:port with an IPv6 address in brackets", addr)
+		}
+	}
+	return validatePort(port)
+}
+
+// listenAll listens on every one of addrs. When one of them fails the others
+// are closed again, and the error names the address.
+func listenAll(addrs []string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, addr := range addrs {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("could not listen on %s: %s", addr, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// normalizeEndpoint turns an -endpoint flag into the path to serve metrics
+// on. Leading, trailing and repeated slashes are ignored, so "metrics",
+// "/metrics" and "zfs//metrics/" serve on /metrics and /zfs/metrics.
+func normalizeEndpoint(endpoint string) (string, error) {
+	if strings.ContainsAny(endpoint, "?# ") {
+		return "", fmt.Errorf("invalid endpoint %q, should be a path without query or spaces", endpoint)
+	}
+	p := path.Clean("/" + endpoint)
+	if p == "/" {
+		return "", fmt.Errorf("invalid endpoint %q, should name a path such as metrics", endpoint)
+	}
+	return p, nil
+}
+
+// validate collects every pool and runs every optional collector once, so
+// that missing privileges show up at startup rather than on the first
+// scrape. Collectors lacking privileges are disabled, as they would be then.
+func (e *Exporter) validate() error {
+	if !e.available {
+		return nil // retried on every scrape anyway
+	}
+	if err := collectPools(e.runner, *e.zpools, e.pool); err != nil {
+		return err
+	}
+	ch := make(chan prometheus.Metric)
+	go func() {
+		for _, c := range e.collectors {
+			c.run(e.runner, collectedPools(*e.zpools), ch)
+		}
+		close(ch)
+	}()
+	for range ch {
+	}
+	return nil
+}
+
+// findZpool reports why the zpool command cannot be run, if it cannot.
+func findZpool() error {
+	_, err := exec.LookPath("zpool")
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		name := "zpool"
+		if runtime.GOOS == "windows" {
+			name += ".exe"
+		}
+		for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+			path := filepath.Join(dir, name)
+			if info, statErr := os.Stat(path); statErr == nil && !info.IsDir() {
+				return fmt.Errorf("zpool command cannot be run: %s is not executable", path)
+			}
+		}
+		return fmt.Errorf("zpool command not found in PATH (%s), is ZFS installed?", os.Getenv("PATH"))
 	}
+	return fmt.Errorf("zpool command cannot be run: %s", err)
+}
+
+// fail reports an error that stops the exporter, or logs it when nobody
+// is listening for those.
+func (e *Exporter) fail(err error) {
+	if e.fatal == nil {
+		log.Print(err)
+		return
+	}
+	select {
+	case e.fatal <- err:
+	default: // already stopping
+	}
+}
+
+// ServeReady answers 200 once every pool was collected successfully, and 503
+// before that or while collecting fails.
+func (e *Exporter) ServeReady(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&e.ready) == 0 {
+		http.Error(w, "not ready: no successful collection of every pool", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ready")
+}
+
+// metricsHandler serves the metrics gathered from g, in the OpenMetrics
+// format to clients that ask for it, including _created series for counters,
+// and gzip-compressed to those that accept it.
+// Like promhttp.Handler it instruments itself on reg. A scrape with
+// collectParam parameters is served the metrics gathered by selected for
+// the collectors they name instead, or 400 when selected fails because one
+// is unknown. selected may be nil, which ignores them.
+func metricsHandler(reg prometheus.Registerer, g prometheus.Gatherer, selected func(names []string) (prometheus.Gatherer, error)) http.Handler {
+	opts := promhttp.HandlerOpts{
+		EnableOpenMetrics:                   true,
+		EnableOpenMetricsTextCreatedSamples: true,
+		OfferedCompressions:                 []promhttp.Compression{promhttp.Identity, promhttp.Gzip},
+	}
+	all := promhttp.HandlerFor(g, opts)
+	return promhttp.InstrumentMetricHandler(reg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		names := r.URL.Query()[collectParam]
+		if len(names) == 0 || selected == nil {
+			all.ServeHTTP(w, r)
+			return
+		}
+		g, err := selected(names)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		promhttp.HandlerFor(g, opts).ServeHTTP(w, r)
+	}))
+}
+
+// serveHealthy answers 200 as long as the process serves HTTP.
+func serveHealthy(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// addCollector enables an optional collector under the given name, which
+// labels its zfs_exporter_collector_enabled metric. It reports false and
+// leaves the collector out, so that it runs no commands, when e.filter
+// exports none of its metrics.
+func (e *Exporter) addCollector(name string, c collector) bool {
+	if !e.filter.allowsAny(describeAll(c.describe)...) {
+		log.Printf("Not running the %s collector, -metric-include and -metric-exclude exclude all of its metrics", name)
+		return false
+	}
+	e.collectors = append(e.collectors, &optionalCollector{name: name, collector: c})
+	return true
 }
 
 // Describe describes all the metrics ever exported by the zpool exporter. It
 // implements prometheus.Collector.
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
-	for _, pool := range *e.zpools {
-		ch <- prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "zpool_capacity_percentage",
-			Help: "Current zpool capacity level",
-			ConstLabels: prometheus.Labels{
-				"name": pool.name,
-			},
-		}).Desc()
-		ch <- prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "zpool_online_providers_count",
-			Help: "Number of ONLINE zpool providers (disks)",
-			ConstLabels: prometheus.Labels{
-				"name": pool.name,
-			},
-		}).Desc()
-		ch <- prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "zpool_faulted_providers_count",
-			Help: "Number of FAULTED/UNAVAIL zpool providers (disks)",
-			ConstLabels: prometheus.Labels{
-				"name": pool.name,
-			},
-		}).Desc()
+	ch <- zfsAvailableDesc
+	ch <- poolNameCollisionsDesc
+	ch <- capabilityDesc
+	ch <- zfsVersionInfoDesc
+	ch <- zfsVersionMismatchDesc
+	if e.pools != nil {
+		e.pools.describe(ch)
+		ch <- zpoolTransitionsDesc
+		ch <- zpoolUnhealthyDesc
+		ch <- deviceReadErrorsDesc
+		ch <- deviceWriteErrorsDesc
+		ch <- deviceChecksumErrorsDesc
+		ch <- deviceErrorResetsDesc
+		if e.fullETA != nil {
+			e.fullETA.describe(ch)
+		}
+	}
+	for _, c := range e.collectors {
+		c.describe(ch)
+	}
+	if len(e.collectors) > 0 {
+		ch <- collectorEnabledDesc
 	}
+	ch <- collectorDurationDesc
+	ch <- collectorSuccessDesc
+	ch <- collectionOverrunDesc
+	ch <- collectionOverrunsDesc
+}
+
+// scrape is one collection of the selected metrics. Collect calls that
+// arrive while it runs wait for it and export the same metrics when they
+// select the same collectors, rather than queueing up for collections of
+// their own.
+type scrape struct {
+	done      chan struct{} // closed once metrics is complete
+	started   time.Time
+	selection string // the key of the collectorSelection
+	metrics   []prometheus.Metric
 }
 
 // Collect fetches the stats from configured ZFS pool and delivers them
 // as Prometheus metrics. It implements prometheus.Collector.
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	e.mutex.Lock() // To protect metrics from concurrent collects.
+	e.collectSelection(ch, nil)
+}
+
+// collectSelection delivers the metrics of the selected collectors. A
+// collection of other collectors that is running is waited for first, since
+// only one collection runs at a time.
+func (e *Exporter) collectSelection(ch chan<- prometheus.Metric, selection collectorSelection) {
+	key := selection.key()
+	e.overruns.request(key, time.Now())
+	for {
+		e.mutex.Lock()
+		s, running := e.inflight, e.inflight != nil
+		if !running {
+			s = &scrape{done: make(chan struct{}), started: time.Now(), selection: key}
+			e.inflight = s
+		}
+		e.mutex.Unlock()
+
+		if running {
+			<-s.done
+			if s.selection != key {
+				continue
+			}
+		} else {
+			ctx, done := e.requests.collection()
+			s.metrics = e.snapshotContext(ctx, selection)
+			done()
+			e.mutex.Lock()
+			e.inflight = nil
+			e.mutex.Unlock()
+			close(s.done)
+		}
+		for _, m := range s.metrics {
+			ch <- m
+		}
+		return
+	}
+}
+
+// collectingSince returns when the collection running now started, or the
+// zero time when none is running.
+func (e *Exporter) collectingSince() time.Time {
+	e.mutex.Lock()
 	defer e.mutex.Unlock()
+	if e.inflight == nil {
+		return time.Time{}
+	}
+	return e.inflight.started
+}
 
-	for _, pool := range *e.zpools {
-		pool.getStatus()
+// snapshot runs one collection of the selected collectors and returns the
+// metrics it produced, in the order of sortMetrics, which are not changed
+// afterwards.
+func (e *Exporter) snapshot(selection collectorSelection) []prometheus.Metric {
+	return e.snapshotContext(context.Background(), selection)
+}
 
-		poolUsage := prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "zpool_capacity_percentage",
-			Help: "Current zpool capacity level",
-			ConstLabels: prometheus.Labels{
-				"name": pool.name,
-			},
-		})
-		poolUsage.Set(float64(pool.capacity))
-		ch <- poolUsage
-
-		providersOnline := prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "zpool_online_providers_count",
-			Help: "Number of ONLINE zpool providers (disks)",
-			ConstLabels: prometheus.Labels{
-				"name": pool.name,
-			},
-		})
-		providersOnline.Set(float64(pool.online))
-		ch <- providersOnline
-
-		providersFaulted := prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "zpool_faulted_providers_count",
-			Help: "Number of FAULTED/UNAVAIL zpool providers (disks)",
-			ConstLabels: prometheus.Labels{
-				"name": pool.name,
-			},
-		})
-		providersFaulted.Set(float64(pool.faulted))
-		ch <- providersFaulted
+// snapshotContext is snapshot with the commands of the collection killed
+// once ctx is done.
+func (e *Exporter) snapshotContext(ctx context.Context, selection collectorSelection) []prometheus.Metric {
+	ch := make(chan prometheus.Metric)
+	done := make(chan []prometheus.Metric)
+	go func() {
+		var metrics []prometheus.Metric
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		sortMetrics(metrics)
+		done <- metrics
+	}()
+	e.collect(ctx, ch, selection)
+	close(ch)
+	return <-done
+}
+
+// collect fetches the metrics of the selected collectors, running their
+// commands with ctx. Only one collection runs at a time, so it does not need
+// to lock the state of the exporter.
+func (e *Exporter) collect(ctx context.Context, ch chan<- prometheus.Metric, selection collectorSelection) {
+	started := time.Now()
+	runner := runnerWithContext(e.runner, ctx)
+	debugf("Scrape %s: collecting %s", scrapeIDs(ctx), selection.key())
+	defer e.recordDebug(started)
+	if e.reloadRequested() {
+		e.available = false
 	}
+	e.syncPools()
+	if !e.available {
+		if err := e.setup(); err != nil {
+			atomic.StoreInt32(&e.ready, 0)
+			e.problems.Store(failedProblems(*e.zpools, err))
+			ch <- prometheus.MustNewConstMetric(zfsAvailableDesc, prometheus.GaugeValue, 0)
+			if e.pools != nil && unreachable(err) {
+				unreachableMetrics(ch, *e.zpools)
+			}
+			return
+		}
+	}
+	pools := *e.zpools
+	if e.pools != nil && !selection.has("pool") {
+		pools = collectedPools(pools)
+	} else if e.pools != nil {
+		start := time.Now()
+		err := recovered("pool", func() error { return e.pools.collect(runner, pools, ch) })
+		if errors.Is(err, context.Canceled) || ctx.Err() != nil {
+			// Every client waiting for the collection disconnected, which
+			// killed the commands of the pools: that says nothing about
+			// them, so the state of the exporter is left as it is.
+			debugf("Scrape %s: collection canceled, the pools are not updated", scrapeIDs(ctx))
+			return
+		}
+		collectorStats(ch, "pool", start, err)
+		if err != nil {
+			atomic.StoreInt32(&e.ready, 0)
+			e.problems.Store(failedProblems(pools, err))
+			e.health.interrupt()
+			for _, c := range e.collectors {
+				if selection.has(c.name) {
+					c.skip(ch)
+				}
+			}
+			if !e.keepRunning && !unreachable(err) {
+				e.fail(err)
+				return
+			}
+			// Set everything up again once zpool works, which also
+			// re-reads the details only fetched at startup.
+			log.Printf("Warning: %s; exporting zfs_exporter_zfs_available 0 until this is resolved", err)
+			e.available = false
+			ch <- prometheus.MustNewConstMetric(zfsAvailableDesc, prometheus.GaugeValue, 0)
+			if unreachable(err) {
+				unreachableMetrics(ch, pools)
+			}
+			return
+		}
+		e.problems.Store(poolProblems(pools))
+		e.saveScrubs()
+		e.health.observe(pools, time.Now())
+		e.health.collect(ch)
+		e.deviceErrors.observe(pools, time.Now())
+		e.deviceErrors.collect(ch)
+		if e.fullETA != nil {
+			e.fullETA.observe(pools, time.Now())
+			e.fullETA.collect(ch)
+		}
+		e.fetchDetails()
+		pools = collectedPools(pools)
+	}
+	if selection.has("pool") {
+		atomic.StoreInt32(&e.ready, boolToInt32(len(pools) == len(*e.zpools)))
+	}
+	ch <- prometheus.MustNewConstMetric(zfsAvailableDesc, prometheus.GaugeValue, 1)
+	ch <- prometheus.MustNewConstMetric(poolNameCollisionsDesc, prometheus.GaugeValue, float64(e.nameCollisions))
+	e.caps.collect(ch)
+	if e.filter.allowsAny(zfsVersionInfoDesc, zfsVersionMismatchDesc) {
+		e.versions.collect(runner, ch)
+	}
+	for _, c := range e.collectors {
+		if selection.has(c.name) {
+			c.run(runner, pools, ch)
+		}
+	}
+	e.overruns.observe(selection.key(), started, time.Now(), ch)
+}
+
+// saveScrubs saves the last scrub of the pools to --scrub.state-file, when
+// it is set.
+func (e *Exporter) saveScrubs() {
+	if e.scrubs == nil {
+		return
+	}
+	if err := e.scrubs.save(*e.zpools); err != nil {
+		logProblem("scrub state", nil, "Error saving the scrub state file: %s", err)
+	} else {
+		logResolved("scrub state", nil, "Saving the scrub state file again")
+	}
+}
+
+// recordDebug stores the pools as a collection that started at started left
+// them for debugPoolsPath, when it is enabled.
+func (e *Exporter) recordDebug(started time.Time) {
+	if e.debug != nil {
+		e.debug.store(*e.zpools, e.pool.showPaths, started)
+	}
+}
 
+// collectedPools returns the pools whose last collection succeeded. The
+// optional collectors only see those, so that a pool zpool cannot collect
+// does not make them fail for every pool.
+func collectedPools(pools []zpool) []zpool {
+	collected := make([]zpool, 0, len(pools))
+	for _, pool := range pools {
+		if pool.err == nil {
+			collected = append(collected, pool)
+		}
+	}
+	return collected
 }
 
 var (
-	zfsPool       string
-	listenPort    string
-	metricsHandle string
-	versionCheck  bool
+	zfsPool           []string
+	listenPort        string
+	listenAddress     []string
+	metricsHandle     string
+	influxHandle      string
+	routePrefix       string
+	externalURL       string
+	unhealthyCode     int
+	versionCheck      bool
+	poolCheck         bool
+	datasetsCheck     bool
+	rootsOnly         bool
+	snapshotCheck     bool
+	arcCheck          bool
+	datasetIOCheck    bool
+	kmemCheck         bool
+	dbufCheck         bool
+	kmemTop           int
+	iostatCheck       bool
+	iostatInterval    int
+	iostatDeviceCheck bool
+	requestSizeCheck  bool
+	importCheck       bool
+	importInterval    time.Duration
+	importTimestamps  bool
+	cachefileCheck    bool
+	historyCheck      bool
+	eventsCheck       bool
+	eventsInterval    time.Duration
+	noDefaults        bool
+	bookmarkCheck     bool
+	spaceDatasets     string
+	moduleParams      string
+	countsCheck       bool
+	snapSpaceCheck    bool
+	dedupCheck        bool
+	vdevsCheck        bool
+	activityCheck     bool
+	enclosureCheck    bool
+	guidsCheck        bool
+	errorEntries      int
+	showErrorPaths    bool
+	fullETACheck      bool
+	fullETAWindow     time.Duration
+	scrubStatePath    string
+	healthyInterval   time.Duration
+	keepRunning       bool
+	debugCheck        bool
+	staticLabels      labelFlag
+	poolLabelsPath    string
+	expectedProviders labelFlag
+	hostnameCheck     bool
+	hostname          string
+	dropUser          string
+	dropGroup         string
+	rwURL             string
+	rwInterval        time.Duration
+	rwBuffer          int
+	rwUser            string
+	rwPasswordFile    string
+	rwTokenFile       string
+	mockCheck         bool
+	statusDir         string
+	procSPL           string
+	devZFS            string
+	zpoolCache        string
+	metricsVersion    int
+	metricInclude     string
+	metricExclude     string
+	strictZero        bool
+	checkConfig       bool
+	printCommands     bool
+	adminAPI          bool
+	debugAPI          bool
+	lifecycleAPI      bool
+	ignoreMissing     bool
+	logOutput         string
+	logFacility       string
+	logTag            string
+	logRepeat         time.Duration
+	dsInclude         string
+	dsExclude         string
+	dsMaxDepth        int
+	maxDatasets       int
+	maxLineBytes      int
+	priority          commandPriority
+	commandTimeout    time.Duration
+	maxProcesses      int
+	remoteTarget      sshTarget
+	serverTLS         webTLS
+	maxOutputBytes    int64
+	maxResponseBytes  int64
+	dsTypes           string
 )
 
-func init() {
+// newFlagSet defines the command line flags on a new FlagSet. The variables
+// they set are reset to their defaults, so that run can be called again.
+func newFlagSet() *flag.FlagSet {
 	const (
-		defaultPool   = "tank"
-		selectedPool  = "what ZFS pool to monitor. Multiple pools can be monitored by providing a comma seperated list of pool names"
-		versionUsage  = "display current tool version"
-		defaultPort   = "8080"
-		portUsage     = "Port to listen on"
-		defaultHandle = "metrics"
-		handleUsage   = "HTTP endpoint to export data on"
+		defaultPool    = "tank"
+		selectedPool   = "ZFS pool to monitor, may be repeated or given as a comma separated list of pool names; pool:tag, such as bpool:boot, also exports zpool_tag_info with the tag"
+		versionUsage   = "display current tool version"
+		defaultPort    = "8080"
+		portUsage      = "Port to listen on, short for --web.listen-address :<port>"
+		addressUsage   = "[host]:port to listen on, may be repeated to listen on several addresses with the same endpoints"
+		defaultHandle  = "metrics"
+		handleUsage    = "HTTP endpoint to export data on"
+		prefixUsage    = "path prefix to serve every endpoint below, such as /hosts/nas01/zfs behind a reverse proxy, defaults to the path of --web.external-url"
+		externalUsage  = "URL the exporter is reachable at through a reverse proxy, used for the links on the landing page"
+		unhealthyUsage = "HTTP status " + poolsHealthPath + " answers with when a pool is not ONLINE or its collection fails"
+		maxRespUsage   = "most bytes of metrics in the text format to serve before compression, 0 for no limit; larger responses leave out snapshots, then datasets, then pools"
+		influxUsage    = "if set, also serve the metrics in InfluxDB line protocol on this HTTP endpoint"
+		poolUsage      = "export pool metrics from zpool list and zpool status"
+		datasetsUsage  = "export per-dataset metrics from zfs list"
+		rootsUsage     = "only export used, available, referenced, compressratio and logicalused of the root dataset of each pool, even without --collector.dataset"
+		dsIOUsage      = "export per-dataset I/O counters from the objset kstats in " + "/proc/spl/kstat/zfs/<pool>"
+		arcUsage       = "export ARC statistics from " + "/proc/spl/kstat/zfs/arcstats"
+		kmemUsage      = "export the dbuf and dnode cache sizes from arcstats and the SPL kmem caches from " + "/proc/spl/kmem/slab"
+		paramsUsage    = "comma separated list of zfs module parameters in /sys/module/zfs/parameters to export, such as zfs_arc_max,zfs_txg_timeout"
+		dbufUsage      = "export dbuf cache statistics from " + "/proc/spl/kstat/zfs/dbufstats"
+		kmemTopUsage   = "how many of the largest SPL kmem caches to export per-cache sizes for"
+		iostatUsage    = "export pool I/O rates from zpool iostat, which makes every scrape take --collector.iostat.interval"
+		intervalUsage  = "seconds zpool iostat measures the I/O rates over"
+		perDeviceUsage = "also export the I/O rates of every vdev and device from zpool iostat -v, one series per disk"
+		importUsage    = "export the pools zpool import could import, scanning every --collector.import.interval in the background"
+		importIntUsage = "how often to scan the devices for importable pools with --collector.import"
+		importTSUsage  = "give the metrics of --collector.import the time of the scan instead of letting Prometheus use the time of the scrape"
+		reqSizeUsage   = "export the request size histograms of zpool iostat -r, disabled when zpool does not support -r"
+		cacheUsage     = "export whether each pool is in its cache file, and so imported at boot, using zdb -C -U"
+		historyUsage   = "count the zpool and zfs commands run on each pool from zpool history, starting when the exporter starts"
+		eventsUsage    = "count the error reports of each pool in zpool events, read every --collector.events.interval in the background, and attach the last one of each device to its error counters as an exemplar"
+		eventsIntUsage = "how often to read zpool events with --collector.events"
+		noDefUsage     = "disable the collectors that are enabled by default (--collector.pool), unless they are enabled explicitly"
+		includeUsage   = "only export datasets whose full name matches this regular expression"
+		excludeUsage   = "do not export datasets whose full name matches this regular expression, takes precedence over --dataset-include"
+		depthUsage     = "how many levels below each pool root dataset to export, 0 for only the root dataset and negative for unlimited"
+		maxDataUsage   = "most datasets the dataset and snapshot collectors export each, the first by name, 0 for no limit"
+		maxLineUsage   = "longest line of zpool and zfs output to read, in bytes; longer lines fail the collection"
+		maxOutUsage    = "most bytes of the output of a zpool or zfs command to read, 0 for no limit; larger outputs fail the collection"
+		timeoutUsage   = "kill a zpool, zfs or other command with its process group after it ran this long, 0 to wait for it however long it takes"
+		maxProcUsage   = "most commands to have running at a time, including killed ones stuck in the kernel; the pools are exported with zpool_up 0 beyond that, 0 for no limit"
+		niceUsage      = "niceness from -20 to 19 to run zpool, zfs and the other commands with using nice, 0 to leave it unchanged"
+		ioClassUsage   = "I/O scheduling class to run the commands with using ionice, idle, best-effort or realtime, on Linux only; empty to leave it unchanged"
+		ioLevelUsage   = "I/O priority from 0 (highest) to 7 within --command.ionice-class best-effort or realtime"
+		typesUsage     = "comma separated list of dataset types to export: filesystem, volume and/or snapshot"
+		snapshotUsage  = "export per-dataset snapshot counts and holds from a listing of all snapshots"
+		bookmarkUsage  = "also export per-dataset bookmark counts from a listing of all bookmarks, requires --collector.snapshot"
+		spaceUsage     = "comma separated list of datasets to export per-user, per-group and per-project space usage and quotas for"
+		countsUsage    = "export the number of datasets and snapshots per pool"
+		snapSpaceUsage = "export the space used by snapshots per pool, summed up over its datasets"
+		dedupUsage     = "export dedup table sizes from zpool status -D"
+		vdevsUsage     = "export fragmentation, capacity and ashift per top-level vdev, and the indirect vdevs and removal progress"
+		activityUsage  = "export which long-running activities are in progress from zpool status -i -t, requires OpenZFS 0.8 or later"
+		errEntUsage    = "also export up to this many entries per pool of the permanent error list of zpool status -v, hashed unless --permanent-errors.show-paths is set"
+		scrubStUsage   = "file to keep the last finished scrub of every pool in, so that its metrics survive restarts, such as /var/lib/prometheus-zfs/scrubs.json"
+		errPathsUsage  = "show the file paths of --collect-permanent-errors, truncated to 128 bytes, instead of hashes; paths can be sensitive"
+		fullETAUsage   = "export an estimate of when each pool fills up, zpool_full_eta_seconds, from the growth of its used space across collections"
+		etaWinUsage    = "how long ago a collection counts half as much in the growth rate of --collect-full-eta; the estimate appears after half of it"
+		encUsage       = "add the enclosure and slot of each disk from sysfs to the per-device metrics, Linux only"
+		guidsUsage     = "add the vdev GUID of each disk from zpool status -g to the per-device metrics, which stays when device names change"
+		debugUsage     = "log diagnostic details, such as the zpool features detected at startup"
+		logOutUsage    = "where to log: stderr, syslog or journal, the systemd journal with POOL and COLLECTOR fields (Linux only)"
+		facilityUsage  = "syslog facility to log to with --log.output syslog, such as daemon or local0"
+		logTagUsage    = "tag of the log entries sent to syslog or the journal"
+		logRepeatUsage = "log a problem that persists across scrapes, such as a failing pool, again at most this often, 0 to log it on every scrape"
+		keepUsage      = "keep serving with zfs_exporter_zfs_available 0 instead of exiting when zpool or the pools are missing at startup"
+		labelUsage     = "label to add to every metric, may be repeated or given as a comma separated list"
+		poolLblUsage   = "YAML file of rules adding labels to the metrics of the pools they match by name or regular expression, read again on reload"
+		addHostUsage   = "add a host label with the hostname of this machine to every metric"
+		hostnameUsage  = "hostname to use for the host label instead of the one of this machine, implies --add-hostname-label"
+		dropUserUsage  = "user name or ID to switch to after starting to listen"
+		dropGrpUsage   = "group name or ID to switch to after starting to listen, defaults to the primary group of --drop-user"
+		rwURLUsage     = "push metrics to this Prometheus remote-write URL every --remote-write-interval, in addition to serving them"
+		rwIntUsage     = "how often to push metrics with --remote-write-url"
+		rwBufUsage     = "maximum number of samples to keep while the remote-write endpoint is unreachable, the oldest are dropped beyond that"
+		rwUserUsage    = "user name for basic auth to the remote-write endpoint, requires --remote-write-password-file"
+		rwPassUsage    = "file holding the password for --remote-write-username"
+		rwTokenUsage   = "file holding a bearer token for the remote-write endpoint"
+		healthyUsage   = "if set, check all pools with one zpool status -x per scrape and only refresh the full status of healthy pools this often"
+		namesUsage     = "1 for the metric names of earlier releases, 2 for names following the Prometheus naming conventions"
+		mIncludeUsage  = "only export metrics whose name matches this regular expression; collectors none of whose metrics match do not run"
+		mExcludeUsage  = "do not export metrics whose name matches this regular expression, takes precedence over --metric-include"
+		strictUsage    = "export the metrics of things that do not exist, such as the last scrub of a pool never scrubbed or an unset quota, as 0 instead of leaving them out"
+		missingUsage   = "export zpool_up 0 for monitored pools that do not exist, instead of exiting, until they are imported"
+		adminUsage     = "serve POST and DELETE " + adminPoolsPath + "<pool> to add and remove monitored pools at runtime"
+		debugAPIUsage  = "serve the pools as the last collection parsed them, with the commands it ran, as JSON on " + debugPoolsPath
+		lifecycleUsage = "enable POST " + reloadPath + " to set the pools up again, as on SIGHUP, and POST " + quitPath + " to shut down"
+		checkUsage     = "check the flags, zpool and the pools, then exit with 0 if the exporter would start or 1 with the problem found, without listening"
+		printUsage     = "print the commands the exporter would run with the other flags, at startup and on every scrape, then exit without running them"
+		mockUsage      = "serve made-up metrics of the pools tank and backup from embedded fixtures with every collector enabled, for developing dashboards without ZFS"
+		expectedUsage  = "number of providers (disks) a pool should have, as pool=count, exported as zpool_expected_providers_count to compare with zpool_configured_providers_count; may be repeated or given as a comma separated list"
+		sshHostUsage   = "run zpool, zfs and the other commands on this host, or user@host, over ssh instead of on this machine, adding a target label with it to every metric"
+		sshUserUsage   = "user to log in to --ssh.host as, instead of the one of the ssh config"
+		sshPortUsage   = "port of --ssh.host, 0 for the one of the ssh config"
+		sshKeyUsage    = "private key to log in to --ssh.host with, instead of the ones of the ssh config or agent"
+		sshTimeUsage   = "how long to wait for --ssh.host to answer, when connecting and once connected, before exporting zpool_up 0"
+		sshKeepUsage   = "how long to keep the connection to --ssh.host open after a scrape for the next one to reuse"
+		tlsCertUsage   = "serve HTTPS with this PEM certificate, chain included, instead of HTTP; requires --web.tls-key-file"
+		tlsKeyUsage    = "PEM private key of --web.tls-cert-file"
+		tlsCAUsage     = "require clients to present a certificate signed by one of the PEM certificates in this file, rejecting the others during the TLS handshake"
+		tlsNameUsage   = "only accept client certificates with this common name or subject alternative name, may be repeated; requires --web.tls-client-ca-file"
+		statusDirUsage = "read zpool list from list.txt and zpool status from <pool>-status.txt in this directory instead of running zpool, to see the metrics of another machine's output"
+		procSPLUsage   = "directory with the SPL kstats and kmem caches the ARC, kmem, dbuf and dataset I/O collectors read; in a container, bind-mount the /proc of the host, such as at /host/proc, and set /host/proc/spl"
+		devZFSUsage    = "ZFS control device, checked at startup and passed to the commands as ZFS_DEV; in a container, pass the device of the host with --device /dev/zfs"
+		cachePathUsage = "cache file of the pools whose cachefile property is unset, read by --collector.cachefile; in a container, bind-mount the /etc/zfs of the host, such as at /host/etc/zfs, and set /host/etc/zfs/zpool.cache"
 	)
-	flag.StringVar(&zfsPool, "pool", defaultPool, selectedPool)
-	flag.StringVar(&zfsPool, "p", defaultPool, selectedPool+" (shorthand)")
-	flag.StringVar(&listenPort, "port", defaultPort, portUsage)
-	flag.StringVar(&metricsHandle, "endpoint", defaultHandle, handleUsage)
-	flag.BoolVar(&versionCheck, "version", false, versionUsage)
-	flag.Parse()
+	fs := flag.NewFlagSet("prometheus-zfs", flag.ContinueOnError)
+	staticLabels = nil
+	expectedProviders = nil
+	fs.StringArrayVarP(&zfsPool, "pool", "p", []string{defaultPool}, selectedPool)
+	fs.StringVar(&listenPort, "port", defaultPort, portUsage)
+	fs.StringArrayVar(&listenAddress, "web.listen-address", []string{":" + defaultPort}, addressUsage)
+	fs.StringVar(&metricsHandle, "endpoint", defaultHandle, handleUsage)
+	fs.StringVar(&influxHandle, "influx-endpoint", "", influxUsage)
+	fs.StringVar(&routePrefix, "web.route-prefix", "", prefixUsage)
+	fs.StringVar(&externalURL, "web.external-url", "", externalUsage)
+	fs.StringVar(&serverTLS.certFile, "web.tls-cert-file", "", tlsCertUsage)
+	fs.StringVar(&serverTLS.keyFile, "web.tls-key-file", "", tlsKeyUsage)
+	fs.StringVar(&serverTLS.clientCAFile, "web.tls-client-ca-file", "", tlsCAUsage)
+	fs.StringArrayVar(&serverTLS.allowedNames, "web.tls-client-name", nil, tlsNameUsage)
+	fs.Int64Var(&maxResponseBytes, "web.max-response-bytes", 0, maxRespUsage)
+	fs.IntVar(&unhealthyCode, "web.pools-health.unhealthy-code", http.StatusServiceUnavailable, unhealthyUsage)
+	fs.BoolVar(&versionCheck, "version", false, versionUsage)
+	fs.BoolVar(&poolCheck, "collector.pool", true, poolUsage)
+	fs.BoolVar(&datasetsCheck, "collector.dataset", false, datasetsUsage)
+	fs.BoolVar(&datasetsCheck, "collect-datasets", false, "alias of --collector.dataset")
+	fs.BoolVar(&rootsOnly, "collector.dataset.roots-only", false, rootsUsage)
+	fs.BoolVar(&snapshotCheck, "collector.snapshot", false, snapshotUsage)
+	fs.BoolVar(&snapshotCheck, "collect-snapshots", false, "alias of --collector.snapshot")
+	fs.BoolVar(&datasetIOCheck, "collector.dataset-io", false, dsIOUsage)
+	fs.BoolVar(&arcCheck, "collector.arc", false, arcUsage)
+	fs.BoolVar(&kmemCheck, "collector.kmem", false, kmemUsage)
+	fs.BoolVar(&dbufCheck, "collector.dbuf", false, dbufUsage)
+	fs.IntVar(&kmemTop, "collector.kmem.top-caches", 10, kmemTopUsage)
+	fs.StringVar(&moduleParams, "collector.module-parameters", "", paramsUsage)
+	fs.BoolVar(&iostatCheck, "collector.iostat", false, iostatUsage)
+	fs.IntVar(&iostatInterval, "collector.iostat.interval", 1, intervalUsage)
+	fs.BoolVar(&iostatDeviceCheck, "collector.iostat.per-device", false, perDeviceUsage)
+	fs.BoolVar(&importCheck, "collector.import", false, importUsage)
+	fs.DurationVar(&importInterval, "collector.import.interval", 10*time.Minute, importIntUsage)
+	fs.BoolVar(&importTimestamps, "collector.import.timestamps", false, importTSUsage)
+	fs.BoolVar(&requestSizeCheck, "collector.request-sizes", false, reqSizeUsage)
+	fs.BoolVar(&cachefileCheck, "collector.cachefile", false, cacheUsage)
+	fs.BoolVar(&historyCheck, "collector.history", false, historyUsage)
+	fs.BoolVar(&eventsCheck, "collector.events", false, eventsUsage)
+	fs.DurationVar(&eventsInterval, "collector.events.interval", 30*time.Second, eventsIntUsage)
+	fs.BoolVar(&noDefaults, "collector.disable-defaults", false, noDefUsage)
+	fs.StringVar(&dsInclude, "dataset-include", "", includeUsage)
+	fs.StringVar(&dsExclude, "dataset-exclude", "", excludeUsage)
+	fs.IntVar(&dsMaxDepth, "dataset-max-depth", -1, depthUsage)
+	fs.IntVar(&maxDatasets, "collector.dataset.max-datasets", 10000, maxDataUsage)
+	fs.IntVar(&maxLineBytes, "command.max-line-bytes", 1<<20, maxLineUsage)
+	fs.Int64Var(&maxOutputBytes, "command.max-output-bytes", 256<<20, maxOutUsage)
+	fs.DurationVar(&commandTimeout, "command.timeout", 5*time.Minute, timeoutUsage)
+	fs.IntVar(&maxProcesses, "command.max-processes", 16, maxProcUsage)
+	fs.IntVar(&priority.nice, "command.nice", 0, niceUsage)
+	fs.StringVar(&priority.ioniceClass, "command.ionice-class", "", ioClassUsage)
+	fs.IntVar(&priority.ioniceLevel, "command.ionice-level", 4, ioLevelUsage)
+	fs.StringVar(&dsTypes, "dataset-types", strings.Join(datasetTypes, ","), typesUsage)
+	fs.BoolVar(&bookmarkCheck, "collect-bookmarks", false, bookmarkUsage)
+	fs.StringVar(&spaceDatasets, "userspace-datasets", "", spaceUsage)
+	fs.BoolVar(&countsCheck, "collect-pool-counts", false, countsUsage)
+	fs.BoolVar(&snapSpaceCheck, "collect-pool-snapshot-space", false, snapSpaceUsage)
+	fs.BoolVar(&dedupCheck, "collect-dedup", false, dedupUsage)
+	fs.BoolVar(&vdevsCheck, "collect-vdevs", false, vdevsUsage)
+	fs.BoolVar(&activityCheck, "collect-activities", false, activityUsage)
+	fs.BoolVar(&enclosureCheck, "collect-enclosures", false, encUsage)
+	fs.BoolVar(&guidsCheck, "collect-device-guids", false, guidsUsage)
+	fs.IntVar(&errorEntries, "collect-permanent-errors", 0, errEntUsage)
+	fs.BoolVar(&showErrorPaths, "permanent-errors.show-paths", false, errPathsUsage)
+	fs.BoolVar(&fullETACheck, "collect-full-eta", false, fullETAUsage)
+	fs.DurationVar(&fullETAWindow, "full-eta.window", 6*time.Hour, etaWinUsage)
+	fs.StringVar(&scrubStatePath, "scrub.state-file", "", scrubStUsage)
+	fs.DurationVar(&healthyInterval, "healthy-status-interval", 0, healthyUsage)
+	fs.BoolVar(&keepRunning, "keep-running", false, keepUsage)
+	fs.BoolVar(&ignoreMissing, "ignore-missing-pools", false, missingUsage)
+	fs.Var(&expectedProviders, "expected-providers", expectedUsage)
+	fs.BoolVar(&debugCheck, "debug", false, debugUsage)
+	fs.StringVar(&logOutput, "log.output", "stderr", logOutUsage)
+	fs.StringVar(&logFacility, "log.syslog-facility", "daemon", facilityUsage)
+	fs.StringVar(&logTag, "log.syslog-tag", "prometheus-zfs", logTagUsage)
+	fs.DurationVar(&logRepeat, "log.repeat-interval", time.Hour, logRepeatUsage)
+	fs.Var(&staticLabels, "label", labelUsage)
+	fs.StringVar(&poolLabelsPath, "pool-labels.file", "", poolLblUsage)
+	fs.BoolVar(&hostnameCheck, "add-hostname-label", false, addHostUsage)
+	fs.StringVar(&hostname, "hostname", "", hostnameUsage)
+	fs.StringVar(&dropUser, "drop-user", "", dropUserUsage)
+	fs.StringVar(&dropGroup, "drop-group", "", dropGrpUsage)
+	fs.StringVar(&rwURL, "remote-write-url", "", rwURLUsage)
+	fs.DurationVar(&rwInterval, "remote-write-interval", 30*time.Second, rwIntUsage)
+	fs.IntVar(&rwBuffer, "remote-write-buffer", 100000, rwBufUsage)
+	fs.StringVar(&rwUser, "remote-write-username", "", rwUserUsage)
+	fs.StringVar(&rwPasswordFile, "remote-write-password-file", "", rwPassUsage)
+	fs.StringVar(&rwTokenFile, "remote-write-bearer-token-file", "", rwTokenUsage)
+	fs.BoolVar(&mockCheck, "mock", false, mockUsage)
+	fs.StringVar(&statusDir, "status-dir", "", statusDirUsage)
+	fs.StringVar(&procSPL, "path.proc-spl", defaultProcSPL, procSPLUsage)
+	fs.StringVar(&devZFS, "path.dev-zfs", defaultDevZFS, devZFSUsage)
+	fs.StringVar(&zpoolCache, "path.zpool-cache", defaultCachefile, cachePathUsage)
+	fs.StringVar(&remoteTarget.host, "ssh.host", "", sshHostUsage)
+	fs.StringVar(&remoteTarget.user, "ssh.user", "", sshUserUsage)
+	fs.IntVar(&remoteTarget.port, "ssh.port", 0, sshPortUsage)
+	fs.StringVar(&remoteTarget.identityFile, "ssh.identity-file", "", sshKeyUsage)
+	fs.DurationVar(&remoteTarget.connectTimeout, "ssh.connect-timeout", 5*time.Second, sshTimeUsage)
+	fs.DurationVar(&remoteTarget.controlPersist, "ssh.control-persist", 5*time.Minute, sshKeepUsage)
+	fs.BoolVar(&checkConfig, "check-config", false, checkUsage)
+	fs.BoolVar(&printCommands, "print-commands", false, printUsage)
+	fs.BoolVar(&adminAPI, "web.enable-admin-api", false, adminUsage)
+	fs.BoolVar(&debugAPI, "web.enable-debug", false, debugAPIUsage)
+	fs.BoolVar(&lifecycleAPI, "web.enable-lifecycle", false, lifecycleUsage)
+	fs.IntVar(&metricsVersion, "metrics.version", 1, namesUsage)
+	fs.StringVar(&metricInclude, "metric-include", "", mIncludeUsage)
+	fs.StringVar(&metricExclude, "metric-exclude", "", mExcludeUsage)
+	fs.BoolVar(&strictZero, "strict-zero", false, strictUsage)
+	return fs
+}
+
+// Exit codes, so that scripts can tell why the exporter stopped.
+const (
+	exitCheckFailed = 1 // --check-config found a problem
+	exitConfig      = 2 // invalid command line
+	exitUnavailable = 3 // zpool or the monitored pools missing at startup
+	exitBind        = 4 // could not listen on --port
+	exitRuntime     = 5 // collecting or serving failed after startup
+)
+
+// exitError is an error that ends the exporter with a specific exit code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+// exitCode returns the exit code for an error returned by run.
+func exitCode(err error) int {
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return exitRuntime
 }
 
 func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "prometheus-zfs:", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// printCheck reports what a --check-config run found the exporter would do.
+func printCheck(w io.Writer, pools []string, e *Exporter, url string) {
+	collectors := []string{"pool"}
+	if e.pools == nil {
+		collectors = nil
+	}
+	for _, c := range e.collectors {
+		collectors = append(collectors, c.name)
+	}
+	if len(collectors) == 0 {
+		collectors = []string{"none"}
+	}
+	fmt.Fprintln(w, "Configuration OK")
+	fmt.Fprintf(w, "  pools:      %s\n", strings.Join(pools, ", "))
+	fmt.Fprintf(w, "  collectors: %s\n", strings.Join(collectors, ", "))
+	fmt.Fprintf(w, "  endpoint:   %s\n", url)
+}
+
+// run parses the command line and serves metrics until the HTTP server
+// fails, a scrape fails fatally or the process is told to stop. All failures
+// are returned rather than exiting, so that deferred cleanup runs. With
+// --check-config it goes through the same startup, without listening, and
+// returns once the exporter would start serving.
+func run(args []string) (err error) {
+	fs := newFlagSet()
+	if err := parseFlags(fs, args, os.LookupEnv); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return &exitError{exitConfig, err}
+	}
+	if checkConfig {
+		defer func() {
+			if err != nil {
+				err = &exitError{exitCheckFailed, fmt.Errorf("configuration check failed: %s", err)}
+			}
+		}()
+	}
 	if versionCheck {
 		fmt.Printf("prometheus-zfs v%s (https://github.com/eripa/prometheus-zfs)\n", toolVersion)
-		os.Exit(0)
+		return nil
 	}
-	err := checkExistance(zfsPool)
+	closeLog, err := setupLogging(logOutput, logFacility, logTag)
 	if err != nil {
-		log.Fatal(err)
+		return &exitError{exitConfig, err}
 	}
-
-	pools := []zpool{}
-	for _, pool := range strings.Split(zfsPool, ",") {
-		z := zpool{name: pool}
-		z.getStatus()
-		pools = append(pools, z)
+	defer func() { closeLog(err) }()
+	addrs := listenAddress
+	if fs.Changed("port") {
+		if fs.Changed("web.listen-address") {
+			return &exitError{exitConfig, errors.New("--port and --web.listen-address cannot be combined")}
+		}
+		addrs = []string{":" + listenPort}
+	}
+	for i, addr := range addrs {
+		if err := validateListenAddress(addr); err != nil {
+			return &exitError{exitConfig, err}
+		}
+		if stringInSlice(addr, addrs[:i]) {
+			return &exitError{exitConfig, fmt.Errorf("listen address %s is given more than once", addr)}
+		}
+	}
+	if err := serverTLS.validate(); err != nil {
+		return &exitError{exitConfig, err}
+	}
+	if serverTLS.enabled() {
+		if err := serverTLS.load(); err != nil {
+			return &exitError{exitConfig, err}
+		}
+	}
+	if metricsVersion != 1 && metricsVersion != 2 {
+		return &exitError{exitConfig, errors.New("-metrics.version should be 1 or 2")}
+	}
+	metrics, err := newMetricFilter(metricInclude, metricExclude, metricsVersion)
+	if err != nil {
+		return &exitError{exitConfig, err}
+	}
+	if printCommands && checkConfig {
+		return &exitError{exitConfig, errors.New("-print-commands cannot be combined with -check-config")}
+	}
+	if mockCheck && statusDir != "" {
+		return &exitError{exitConfig, errors.New("-mock cannot be combined with -status-dir")}
+	}
+	if remoteTarget.host != "" {
+		if mockCheck || statusDir != "" {
+			return &exitError{exitConfig, errors.New("-ssh.host cannot be combined with -mock or -status-dir")}
+		}
+		if err := remoteTarget.validate(); err != nil {
+			return &exitError{exitConfig, err}
+		}
+	}
+	paths := hostPaths(fs.Changed)
+	if len(paths) > 0 && (mockCheck || statusDir != "" || remoteTarget.host != "") {
+		return &exitError{exitConfig, fmt.Errorf("-%s is a path of this machine and cannot be combined with -mock, -status-dir or -ssh.host", paths[0].flag)}
+	}
+	if mockCheck {
+		if rwURL != "" {
+			return &exitError{exitConfig, errors.New("-mock cannot be combined with -remote-write-url")}
+		}
+		enableMock(fs)
+	}
+	endpoint, err := normalizeEndpoint(metricsHandle)
+	if err != nil {
+		return &exitError{exitConfig, fmt.Errorf("-endpoint: %s", err)}
+	}
+	var influxEndpoint string
+	if influxHandle != "" {
+		if influxEndpoint, err = normalizeEndpoint(influxHandle); err != nil {
+			return &exitError{exitConfig, fmt.Errorf("-influx-endpoint: %s", err)}
+		}
+		if influxEndpoint == endpoint {
+			return &exitError{exitConfig, errors.New("-influx-endpoint should differ from -endpoint")}
+		}
+	}
+	external := ""
+	if externalURL != "" {
+		u, err := parseExternalURL(externalURL)
+		if err != nil {
+			return &exitError{exitConfig, fmt.Errorf("-web.external-url: %s", err)}
+		}
+		external = u.Path
+		if !fs.Changed("web.route-prefix") {
+			routePrefix = external
+		}
+	}
+	prefix, err := normalizeRoutePrefix(routePrefix)
+	if err != nil {
+		return &exitError{exitConfig, fmt.Errorf("-web.route-prefix: %s", err)}
+	}
+	if externalURL == "" {
+		external = prefix
+	}
+	if unhealthyCode < 400 || unhealthyCode > 599 {
+		return &exitError{exitConfig, errors.New("-web.pools-health.unhealthy-code should be an HTTP error status between 400 and 599")}
+	}
+	filter, err := newDatasetFilter(dsInclude, dsExclude)
+	if err != nil {
+		return &exitError{exitConfig, err}
+	}
+	types, err := parseDatasetTypes(dsTypes)
+	if err != nil {
+		return &exitError{exitConfig, err}
+	}
+	if bookmarkCheck && !snapshotCheck {
+		return &exitError{exitConfig, errors.New("-collect-bookmarks requires -collector.snapshot")}
+	}
+	if iostatDeviceCheck && !iostatCheck {
+		return &exitError{exitConfig, errors.New("-collector.iostat.per-device requires -collector.iostat")}
+	}
+	if logRepeat < 0 {
+		return &exitError{exitConfig, errors.New("-log.repeat-interval should not be negative")}
+	}
+	if errorEntries < 0 {
+		return &exitError{exitConfig, errors.New("-collect-permanent-errors should not be negative")}
+	}
+	if importTimestamps && !importCheck {
+		return &exitError{exitConfig, errors.New("-collector.import.timestamps requires -collector.import")}
+	}
+	if fullETAWindow <= 0 {
+		return &exitError{exitConfig, errors.New("-full-eta.window should be positive")}
+	}
+	if importInterval <= 0 {
+		return &exitError{exitConfig, errors.New("-collector.import.interval should be positive")}
+	}
+	if eventsInterval <= 0 {
+		return &exitError{exitConfig, errors.New("-collector.events.interval should be positive")}
+	}
+	if iostatInterval < 1 {
+		return &exitError{exitConfig, errors.New("-collector.iostat.interval should be at least 1 second")}
+	}
+	if maxDatasets < 0 {
+		return &exitError{exitConfig, errors.New("-collector.dataset.max-datasets should not be negative")}
+	}
+	if kmemTop < 0 {
+		return &exitError{exitConfig, errors.New("-collector.kmem.top-caches should not be negative")}
+	}
+	if maxLineBytes < 1 {
+		return &exitError{exitConfig, errors.New("-command.max-line-bytes should be at least 1")}
+	}
+	if maxOutputBytes < 0 {
+		return &exitError{exitConfig, errors.New("-command.max-output-bytes should not be negative")}
+	}
+	if maxResponseBytes < 0 {
+		return &exitError{exitConfig, errors.New("-web.max-response-bytes should not be negative")}
+	}
+	outputLimit = outputLimits{maxLine: maxLineBytes, maxBytes: maxOutputBytes}
+	if commandTimeout < 0 {
+		return &exitError{exitConfig, errors.New("-command.timeout should not be negative")}
+	}
+	if maxProcesses < 0 {
+		return &exitError{exitConfig, errors.New("-command.max-processes should not be negative")}
+	}
+	if err := priority.validate(); err != nil {
+		return &exitError{exitConfig, err}
+	}
+	if !mockCheck {
+		if disabled := disableProcCollectors(runtime.GOOS); len(disabled) > 0 {
+			log.Printf("Warning: %s read /proc or /sys, which Windows does not have, not enabling them", strings.Join(disabled, ", "))
+		}
+	}
+	if remoteTarget.host != "" {
+		disabled := disableLocalCollectors()
+		if enclosureCheck {
+			enclosureCheck = false
+			disabled = append(disabled, "-collect-enclosures")
+		}
+		if len(disabled) > 0 {
+			log.Printf("Warning: %s read the files of this machine rather than of -ssh.host, not enabling them", strings.Join(disabled, ", "))
+		}
+	}
+	var params *paramsCollector
+	if moduleParams != "" {
+		if params, err = newParamsCollector(strings.Split(moduleParams, ",")); err != nil {
+			return &exitError{exitConfig, fmt.Errorf("-collector.module-parameters: %s", err)}
+		}
+	}
+	if noDefaults && !fs.Changed("collector.pool") {
+		poolCheck = false
+	}
+	ids, err := resolveDropIDs(dropUser, dropGroup)
+	if err != nil {
+		return &exitError{exitConfig, err}
+	}
+	expected, err := parseExpectedProviders(expectedProviders)
+	if err != nil {
+		return &exitError{exitConfig, err}
+	}
+	tags, err := parsePoolTags(zfsPool...)
+	if err != nil {
+		return &exitError{exitConfig, err}
+	}
+	labels, err := parseStaticLabels(staticLabels)
+	if err != nil {
+		return &exitError{exitConfig, err}
+	}
+	if hostnameCheck || hostname != "" {
+		if err := addHostLabel(labels, hostname); err != nil {
+			return &exitError{exitConfig, err}
+		}
+	}
+	if _, ok := labels["target"]; !ok && remoteTarget.host != "" {
+		labels["target"] = remoteTarget.name()
+	}
+	var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
+	var poolLabelMap *poolLabels
+	if poolLabelsPath != "" {
+		poolLabelMap = &poolLabels{path: poolLabelsPath, static: labels}
+		gatherer = poolLabelGatherer{gatherer, poolLabelMap}
+	}
+	if metricsVersion == 2 {
+		gatherer = renamingGatherer{gatherer}
+	}
+	if metrics != nil {
+		gatherer = filteringGatherer{gatherer, metrics}
+	}
+	var writer *remoteWriter
+	if rwURL != "" {
+		writer, err = newRemoteWriter(rwURL, gatherer, rwInterval, rwBuffer)
+		if err == nil {
+			err = writer.setAuth(rwUser, rwPasswordFile, rwTokenFile)
+		}
+		if err != nil {
+			return &exitError{exitConfig, err}
+		}
 	}
 
+	logRepeats = newLogDedup(logRepeat)
+	collectorPanics = newCollectorPanics()
+	children = &childProcesses{max: maxProcesses, timeout: commandTimeout}
+	var runner commandRunner = execRunner{}
+	if mockCheck {
+		dir, err := extractMockFiles()
+		if err != nil {
+			return &exitError{exitRuntime, fmt.Errorf("could not extract mock data: %s", err)}
+		}
+		defer os.RemoveAll(dir)
+		runner = mockRunner{}
+		log.Print("Warning: -mock is set, serving made-up metrics instead of the state of this machine")
+	}
+	if statusDir != "" {
+		if _, err := os.Stat(filepath.Join(statusDir, statusListFile)); err != nil {
+			return &exitError{exitConfig, fmt.Errorf("-status-dir: %s", err)}
+		}
+		runner = dirRunner{dir: statusDir}
+		log.Printf("Warning: -status-dir is set, serving metrics parsed from the files in %s instead of the state of this machine", statusDir)
+	}
+	var remote *sshRunner
+	if remoteTarget.host != "" {
+		if remote, err = newSSHRunner(remoteTarget); err != nil {
+			return &exitError{exitConfig, err}
+		}
+		defer remote.close()
+		runner = remote
+		log.Printf("Running the commands on %s over ssh", remoteTarget.host)
+	}
+	if remote != nil {
+		if remote.wrapper = priority.wrapper(remoteLookPath); len(remote.wrapper) > 0 {
+			log.Printf("Running the commands under %s on %s", strings.Join(remote.wrapper, " "), remoteTarget.host)
+		}
+	} else if !isOffline(runner) {
+		if wrapper := priority.wrapper(exec.LookPath); len(wrapper) > 0 {
+			log.Printf("Running the commands under %s", strings.Join(wrapper, " "))
+			runner = execRunner{wrapper: wrapper}
+		}
+	}
+	if len(paths) > 0 {
+		if err := checkHostPaths(paths); err != nil {
+			return &exitError{exitConfig, err}
+		}
+		setProcSPL(procSPL)
+		defaultCachefile = zpoolCache
+		if devZFS != defaultDevZFS {
+			commandEnv = []string{"ZFS_DEV=" + devZFS}
+		}
+	}
+	pools := parsePools(zfsPool...)
+	if len(pools) == 0 {
+		return &exitError{exitConfig, errors.New("--pool should name at least one pool")}
+	}
+	for _, name := range poolNames(pools) {
+		if !poolNameRE.MatchString(name) {
+			return &exitError{exitConfig, fmt.Errorf("invalid --pool %q, pool names start with a letter and only have letters, digits, spaces and _.:-", name)}
+		}
+	}
+	pools, collisions := dropPoolNameCollisions(pools)
+	names := poolNames(pools)
+	log.Printf("Monitoring pools %s", strings.Join(names, ", "))
+	for pool := range expected {
+		if !stringInSlice(pool, names) {
+			logf(logFields{"POOL": pool}, "Warning: -expected-providers names pool %s, which is not monitored", pool)
+		}
+	}
 	exporter := NewExporter(&pools)
-	prometheus.MustRegister(exporter)
+	exporter.nameCollisions = collisions
+	exporter.filter = metrics
+	if poolLabelMap != nil {
+		if err := poolLabelMap.load(names); err != nil {
+			return &exitError{exitConfig, fmt.Errorf("-pool-labels.file: %s", err)}
+		}
+		poolLabelMap.pools = exporter.monitoredPools
+		exporter.poolLabels = poolLabelMap
+	}
+	commands := newCommandMetrics()
+	if debugAPI {
+		exporter.debug = &debugState{}
+		runner = debugRunner{runner, exporter.debug}
+	}
+	exporter.runner = instrumentedRunner{runner, commands}
+	exporter.pool = poolOptions{
+		dedup:             dedupCheck,
+		vdevs:             vdevsCheck,
+		activities:        activityCheck,
+		enclosures:        enclosureCheck,
+		guids:             guidsCheck,
+		errorEntries:      errorEntries,
+		showPaths:         showErrorPaths,
+		healthyInterval:   healthyInterval,
+		expectedProviders: expected,
+		tags:              tags,
+	}
+	exporter.fatal = make(chan error, 1)
+	// A target that cannot be reached exports zpool_up 0 rather than
+	// stopping the exporter.
+	exporter.keepRunning = keepRunning || remote != nil
+	exporter.pool.ignoreMissing = ignoreMissing
+	if fullETACheck {
+		exporter.fullETA = &etaTracker{window: fullETAWindow}
+	}
+	if !poolCheck {
+		exporter.pools = nil
+	} else if scrubStatePath != "" {
+		exporter.scrubs = loadScrubState(scrubStatePath, pools)
+	}
+	// The commands of the setup are checked before it runs them, and those
+	// of the collectors once they are added.
+	if err := exporter.checkReadOnly(); err != nil {
+		return &exitError{exitConfig, err}
+	}
+	// The plan of --print-commands runs nothing, not even the setup.
+	if !printCommands {
+		started := time.Now()
+		err = exporter.setup()
+		exporter.recordDebug(started)
+		if err != nil {
+			if !exporter.keepRunning || checkConfig {
+				return &exitError{exitUnavailable, err}
+			}
+			log.Printf("Warning: %s; exporting zfs_exporter_zfs_available 0 until this is resolved", err)
+		}
+	}
+
+	var datasets *datasetCollector
+	if rootsOnly {
+		if datasetsCheck {
+			log.Print("Warning: --collector.dataset.roots-only is set, only exporting the root dataset of each pool")
+		}
+		exporter.addCollector("datasets", newDatasetCollector(datasetOptions{rootsOnly: true}))
+	} else if datasetsCheck {
+		for _, t := range types {
+			if t == "snapshot" {
+				log.Print("Warning: exporting snapshots creates series for every snapshot and can produce a very large number of metrics")
+			}
+		}
+		datasets = newDatasetCollector(datasetOptions{
+			filter:      filter,
+			maxDepth:    dsMaxDepth,
+			types:       types,
+			maxDatasets: maxDatasets,
+		})
+		exporter.addCollector("datasets", datasets)
+	}
+	if snapSpaceCheck {
+		space := snapshotSpaceCollector{}
+		if datasets != nil && datasets.listsEverything() {
+			space.datasets = datasets
+		}
+		exporter.addCollector("pool-snapshot-space", space)
+	}
+	if snapshotCheck {
+		exporter.addCollector("snapshots", newSnapshotCollector(filter, bookmarkCheck, maxDatasets))
+	}
+	if spaceDatasets != "" {
+		userspace := newSpaceCollector(strings.Split(spaceDatasets, ","))
+		projects := exporter.caps.projectspace
+		if printCommands {
+			projects = true
+		} else if !exporter.caps.probed {
+			projects = probeProjectspace(exporter.runner, userspace.datasets[0])
+		}
+		if !userspace.enableProjects(projects) {
+			log.Print("zfs projectspace is not supported, not exporting project quotas")
+		}
+		exporter.addCollector("userspace", userspace)
+	}
+	if countsCheck {
+		exporter.addCollector("pool-counts", poolCountCollector{})
+	}
+	if datasetIOCheck {
+		exporter.addCollector("dataset-io", &objsetCollector{filter: filter})
+	}
+	if arcCheck {
+		exporter.addCollector("arc", newARCCollector())
+	}
+	if kmemCheck {
+		kmem := newKmemCollector(kmemTop)
+		if dbufCheck {
+			kmem.withoutDbufSize()
+		}
+		exporter.addCollector("kmem", kmem)
+	}
+	if dbufCheck {
+		exporter.addCollector("dbuf", newDbufCollector())
+	}
+	if params != nil {
+		exporter.addCollector("module-parameters", params)
+	}
+	if iostatCheck {
+		exporter.addCollector("iostat", &iostatCollector{interval: iostatInterval, perDevice: iostatDeviceCheck})
+	}
+	if requestSizeCheck {
+		exporter.addCollector("request-sizes", requestSizeCollector{caps: &exporter.caps})
+	}
+	var imports *importCollector
+	if importCheck {
+		imports = &importCollector{interval: importInterval, timestamps: importTimestamps}
+		if !exporter.addCollector("import", imports) {
+			imports = nil
+		}
+	}
+	if cachefileCheck {
+		exporter.addCollector("cachefile", cachefileCollector{})
+	}
+	if historyCheck {
+		exporter.addCollector("history", newHistoryCollector())
+	}
+	var events *eventsCollector
+	if eventsCheck {
+		events = newEventsCollector(eventsInterval)
+		if exporter.addCollector("events", events) {
+			exporter.deviceErrors.events = events
+		} else {
+			events = nil
+		}
+	}
+	exporter.logFilter()
+	if err := exporter.checkReadOnly(); err != nil {
+		return &exitError{exitConfig, err}
+	}
+
+	if printCommands {
+		printPlan(os.Stdout, exporter)
+		return nil
+	}
 
-	fmt.Printf("Starting zpool metrics exporter on :%s/%s\n", listenPort, metricsHandle)
-	http.Handle("/"+metricsHandle, promhttp.Handler())
-	http.ListenAndServe(":"+listenPort, nil)
+	// The check does not listen, so that it can run next to the exporter
+	// it checks the configuration for.
+	var listeners []net.Listener
+	if !checkConfig {
+		if listeners, err = listenAll(addrs); err != nil {
+			return &exitError{exitBind, err}
+		}
+		defer func() {
+			for _, l := range listeners {
+				l.Close()
+			}
+		}()
+		if serverTLS.enabled() {
+			listeners = serverTLS.listen(listeners)
+		}
+	}
+	if ids != (dropIDs{-1, -1}) {
+		err := ids.drop()
+		if err == nil {
+			err = exporter.validate()
+		}
+		if err != nil {
+			return &exitError{exitRuntime, fmt.Errorf("after dropping privileges: %s", err)}
+		}
+		log.Printf("Dropped privileges to uid %d gid %d", os.Getuid(), os.Getgid())
+	}
+	reg := prometheus.WrapRegistererWith(labels, prometheus.DefaultRegisterer)
+	if err := exporter.Register(reg); err != nil {
+		return &exitError{exitRuntime, fmt.Errorf("could not register exporter: %s", err)}
+	}
+	if err := commands.register(reg); err != nil {
+		return &exitError{exitRuntime, fmt.Errorf("could not register command metrics: %s", err)}
+	}
+	if err := exporter.requests.register(reg); err != nil {
+		return &exitError{exitRuntime, fmt.Errorf("could not register scrape metrics: %s", err)}
+	}
+	if err := logRepeats.register(reg); err != nil {
+		return &exitError{exitRuntime, fmt.Errorf("could not register log metrics: %s", err)}
+	}
+	for _, name := range exporter.collectorNames() {
+		collectorPanics.WithLabelValues(name)
+	}
+	if err := reg.Register(collectorPanics); err != nil {
+		return &exitError{exitRuntime, fmt.Errorf("could not register panic metrics: %s", err)}
+	}
+	if err := reg.Register(children.gauge()); err != nil {
+		return &exitError{exitRuntime, fmt.Errorf("could not register child process metrics: %s", err)}
+	}
+	if err := serverTLS.register(reg); err != nil {
+		return &exitError{exitRuntime, fmt.Errorf("could not register TLS metrics: %s", err)}
+	}
+	if writer != nil {
+		if err := writer.register(reg); err != nil {
+			return &exitError{exitRuntime, fmt.Errorf("could not register remote write metrics: %s", err)}
+		}
+	}
+	if checkConfig {
+		urls := make([]string, len(addrs))
+		for i, addr := range addrs {
+			urls[i] = addr + prefix + endpoint
+		}
+		printCheck(os.Stdout, names, exporter, strings.Join(urls, ", "))
+		return nil
+	}
+	if writer != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go writer.run(ctx)
+		log.Printf("Pushing metrics to %s every %s", writer.url.Redacted(), rwInterval)
+	}
+	if imports != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go imports.run(ctx, exporter.runner)
+		log.Printf("Scanning for importable pools every %s", importInterval)
+	}
+	if events != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go events.run(ctx, exporter.runner)
+		log.Printf("Reading zpool events every %s", eventsInterval)
+	}
+	mux := http.NewServeMux()
+	links := []landingLink{{endpoint, "Metrics"}}
+	// A selecting scrape gathers the selected collectors from a registry of
+	// its own, with the labels and names of the default one.
+	selected := func(names []string) (prometheus.Gatherer, error) {
+		selection, err := exporter.selectCollectors(names)
+		if err != nil {
+			return nil, err
+		}
+		r := prometheus.NewRegistry()
+		if err := prometheus.WrapRegistererWith(labels, r).Register(selectedExporter{exporter, selection}); err != nil {
+			return nil, err
+		}
+		var g prometheus.Gatherer = r
+		if poolLabelMap != nil {
+			g = poolLabelGatherer{g, poolLabelMap}
+		}
+		if metricsVersion == 2 {
+			g = renamingGatherer{g}
+		}
+		if metrics != nil {
+			g = filteringGatherer{g, metrics}
+		}
+		if maxResponseBytes > 0 {
+			g = truncatingGatherer{g, maxResponseBytes, labels}
+		}
+		return g, nil
+	}
+	// Only the scrapes are truncated; remote write and the InfluxDB
+	// endpoint send everything.
+	scraped := gatherer
+	if maxResponseBytes > 0 {
+		scraped = truncatingGatherer{gatherer, maxResponseBytes, labels}
+	}
+	mux.Handle(endpoint, exporter.requests.track(metricsHandler(prometheus.DefaultRegisterer, scraped, selected)))
+	if influxEndpoint != "" {
+		mux.Handle(influxEndpoint, influxHandler(gatherer))
+		links = append(links, landingLink{influxEndpoint, "Metrics in InfluxDB line protocol"})
+	}
+	mux.HandleFunc("/healthz", serveHealthy)
+	mux.HandleFunc("/ready", exporter.ServeReady)
+	links = append(links, landingLink{"/healthz", "Health"}, landingLink{"/ready", "Readiness"})
+	if exporter.pools != nil {
+		mux.HandleFunc(poolsHealthPath, exporter.ServePoolsHealth(unhealthyCode))
+		links = append(links, landingLink{poolsHealthPath, "Pool health"})
+	}
+	if adminAPI {
+		mux.HandleFunc(adminPoolsPath, exporter.ServeAdminPools)
+		log.Printf("Serving the admin API on %s", prefix+adminPoolsPath)
+	}
+	if exporter.debug != nil {
+		mux.HandleFunc(debugPoolsPath, exporter.debug.ServeDebugPools)
+		log.Printf("Serving the pools as last parsed on %s", prefix+debugPoolsPath)
+	}
+	quit := make(chan struct{}, 1)
+	if lifecycleAPI {
+		mux.HandleFunc(reloadPath, lifecyclePost(exporter.ServeReload))
+		mux.HandleFunc(quitPath, lifecyclePost(serveQuit(quit)))
+		log.Printf("Serving the lifecycle API on %s and %s", prefix+reloadPath, prefix+quitPath)
+	}
+	mux.HandleFunc("/", landingPage(external, links))
+	server := &http.Server{Handler: withRoutePrefix(prefix, external, mux)}
+	defer server.Close()
+
+	// One server on every listener, so that they share the handlers and
+	// shut down together.
+	served := make(chan error, len(listeners))
+	scheme := "http"
+	if serverTLS.enabled() {
+		scheme = "https"
+	}
+	urls := make([]string, len(listeners))
+	for i, l := range listeners {
+		l := l
+		go func() {
+			served <- fmt.Errorf("could not serve on %s: %s", l.Addr(), server.Serve(l))
+		}()
+		urls[i] = fmt.Sprintf("%s://%s%s%s", scheme, l.Addr(), prefix, endpoint)
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	shutdown := func() error {
+		if err := sdNotify("STOPPING=1"); err != nil {
+			log.Print("Warning: ", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return server.Shutdown(ctx)
+	}
 
+	fmt.Printf("Starting zpool metrics exporter on %s\n", strings.Join(urls, ", "))
+
+	// Under systemd, READY=1 is sent once the pools were collected, which
+	// with --keep-running may take until ZFS is available.
+	ready := exporter.collected
+	var watchdog <-chan time.Time
+	interval, err := watchdogInterval()
+	if err != nil {
+		log.Print("Warning: ", err)
+	}
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		watchdog = ticker.C
+	}
+	// With client certificates required the watchdog has none to present,
+	// and only checks the collections.
+	healthz := scheme + "://" + loopbackAddr(listeners[0].Addr().String()) + prefix + "/healthz"
+	if serverTLS.clientAuth() {
+		healthz = ""
+	}
+	var unhealthy bool
+	for {
+		select {
+		case err := <-served:
+			return &exitError{exitRuntime, err}
+		case err := <-exporter.fatal:
+			return &exitError{exitRuntime, err}
+		case sig := <-stop:
+			log.Printf("Received %s, shutting down", sig)
+			return shutdown()
+		case <-quit:
+			log.Printf("Shutting down, requested through %s", quitPath)
+			return shutdown()
+		case <-hangup:
+			log.Print("Received hangup, setting the pools up again from the next scrape")
+			exporter.reload()
+		case <-ready:
+			ready = nil
+			if err := sdNotify("READY=1"); err != nil {
+				log.Print("Warning: ", err)
+			}
+		case <-watchdog:
+			// Pings stop while a collection has been running for longer
+			// than WatchdogSec or /healthz does not answer, so that
+			// systemd restarts the exporter once it is stuck.
+			if err := checkAlive(exporter, healthz, 2*interval, interval/2); err != nil {
+				if !unhealthy {
+					log.Printf("Not pinging the systemd watchdog: %s", err)
+				}
+				unhealthy = true
+				continue
+			}
+			unhealthy = false
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				log.Print("Warning: ", err)
+			}
+		}
+	}
 }
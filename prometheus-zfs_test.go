@@ -0,0 +1,798 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/textparse"
+)
+
+func TestFindZpool(t *testing.T) {
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+
+	dir := t.TempDir()
+	os.Setenv("PATH", dir)
+	if err := findZpool(); err == nil || !strings.Contains(err.Error(), "not found in PATH ("+dir+")") {
+		t.Errorf("Missing zpool should produce not found error, got %v", err)
+	}
+
+	// Test a zpool that is not executable
+	if err := os.WriteFile(dir+"/zpool", []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := findZpool(); err == nil || !strings.Contains(err.Error(), "cannot be run") {
+		t.Errorf("Non-executable zpool should produce error, got %v", err)
+	}
+}
+
+func TestExporterUnavailable(t *testing.T) {
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	os.Setenv("PATH", t.TempDir())
+
+	e := NewExporter(&[]zpool{{name: "tank"}})
+	ch := make(chan prometheus.Metric)
+	go func() {
+		e.Collect(ch)
+		close(ch)
+	}()
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	if len(metrics) != 1 || descName(metrics[0].Desc()) != "zfs_exporter_zfs_available" || metricValue(metrics[0]) != 0 {
+		t.Errorf("Exporter without zpool should only export zfs_exporter_zfs_available 0, got %v", metrics)
+	}
+}
+
+func TestValidatePort(t *testing.T) {
+	for _, port := range []string{"1", "8080", "65535"} {
+		if err := validatePort(port); err != nil {
+			t.Errorf("Error in validatePort(%q) (%s)", port, err)
+		}
+	}
+	for _, port := range []string{"", "0", "65536", "-1", "80a", "http"} {
+		if err := validatePort(port); err == nil {
+			t.Errorf("validatePort(%q) should produce error", port)
+		}
+	}
+}
+
+func TestValidateListenAddress(t *testing.T) {
+	for _, addr := range []string{":8080", "127.0.0.1:9134", "[fd00::5]:9134", "[::]:9134", "[fe80::1%eth0]:9134", "localhost:9134"} {
+		if err := validateListenAddress(addr); err != nil {
+			t.Errorf("Error in validateListenAddress(%q) (%s)", addr, err)
+		}
+	}
+	for _, addr := range []string{"localhost", "fd00::5:9134", "[fd00::5]", "[127.0.0.1]:9134", "[fd00::zz]:9134", "[fd00::5]:http"} {
+		if err := validateListenAddress(addr); err == nil {
+			t.Errorf("validateListenAddress(%q) should produce error", addr)
+		}
+	}
+}
+
+func TestListenAll(t *testing.T) {
+	listeners, err := listenAll([]string{"127.0.0.1:0", "127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("Error in listenAll (%s)", err)
+	}
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	if len(listeners) != 2 || listeners[0].Addr().String() == listeners[1].Addr().String() {
+		t.Errorf("Incorrect listeners %v, should be two on different ports", listeners)
+	}
+
+	busy := listeners[0].Addr().String()
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	freeAddr := free.Addr().String()
+	free.Close()
+	_, err = listenAll([]string{freeAddr, busy})
+	if err == nil || !strings.Contains(err.Error(), busy) {
+		t.Errorf("Busy address should produce error naming %s in listenAll, got %v", busy, err)
+	}
+	// The listener on the free address was closed again.
+	l, err := net.Listen("tcp", freeAddr)
+	if err != nil {
+		t.Errorf("Listener on %s should be closed after listenAll failed (%s)", freeAddr, err)
+	} else {
+		l.Close()
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	os.Setenv("PATH", t.TempDir())
+
+	busy, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	busyPort := strconv.Itoa(busy.Addr().(*net.TCPAddr).Port)
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	free.Close()
+
+	for _, test := range []struct {
+		args []string
+		code int
+	}{
+		{[]string{"-port", "http"}, exitConfig},
+		{[]string{"-port", "8080", "-dataset-types", "filesystem,pool"}, exitConfig},
+		{[]string{"-dataset-types", "filesystem", "-collect-bookmarks"}, exitConfig},
+		{[]string{"-collector.iostat.per-device"}, exitConfig},
+		{[]string{"-collector.import.timestamps"}, exitConfig},
+		{[]string{"-metric-include", "("}, exitConfig},
+		{[]string{"-collector.module-parameters", "zfs_arc_max,../x"}, exitConfig},
+		{[]string{"-web.external-url", "nas01/zfs"}, exitConfig},
+		{[]string{"-web.pools-health.unhealthy-code", "200"}, exitConfig},
+		{[]string{"-collector.dataset.max-datasets", "-1"}, exitConfig},
+		{[]string{"-command.max-line-bytes", "0"}, exitConfig},
+		{[]string{"-command.max-output-bytes", "-1"}, exitConfig},
+		{[]string{"-web.max-response-bytes", "-1"}, exitConfig},
+		{[]string{"-command.nice", "20"}, exitConfig},
+		{[]string{"-command.ionice-class", "low"}, exitConfig},
+		{[]string{"-status-dir", "/nonexistent"}, exitConfig},
+		{[]string{"-path.dev-zfs", "/nonexistent/zfs"}, exitConfig},
+		{[]string{"-mock", "-path.proc-spl", "/host/proc/spl"}, exitConfig},
+		{[]string{"-collect-bookmarks=false", "-keep-running=false"}, exitUnavailable},
+		{[]string{"-port", busyPort, "-keep-running"}, exitBind},
+		{[]string{"-collector.kmem", "-collector.kmem.top-caches", "-1"}, exitConfig},
+		{[]string{"-collector.import", "-collector.import.interval", "0s"}, exitConfig},
+		{[]string{"-collector.events", "-collector.events.interval", "0s"}, exitConfig},
+		{[]string{"-collect-permanent-errors", "-1"}, exitConfig},
+		{[]string{"-log.repeat-interval", "-1s"}, exitConfig},
+		{[]string{"-collector.iostat", "-collector.iostat.interval", "0"}, exitConfig},
+		{[]string{"-mock", "-remote-write-url", "http://mimir/api/v1/push"}, exitConfig},
+		{[]string{"-metrics.version", "3"}, exitConfig},
+		{[]string{"--port", "9090", "--web.listen-address", ":9091"}, exitConfig},
+		{[]string{"--web.listen-address", "localhost"}, exitConfig},
+		{[]string{"--web.listen-address", ":9091", "--web.listen-address", ":9091"}, exitConfig},
+		{[]string{"--web.listen-address", free.Addr().String(), "--web.listen-address", "127.0.0.1:" + busyPort, "--keep-running"}, exitBind},
+		{[]string{"--no-such-flag"}, exitConfig},
+		{[]string{"--check-config"}, exitCheckFailed},
+		{[]string{"--check-config", "--keep-running"}, exitCheckFailed},
+		{[]string{"--check-config", "--port", "http"}, exitCheckFailed},
+		{[]string{"--check-config", "--print-commands"}, exitCheckFailed},
+	} {
+		err := run(test.args)
+		if err == nil || exitCode(err) != test.code {
+			t.Errorf("run(%q) should exit with %d, got %v (%d)", test.args, test.code, err, exitCode(err))
+		}
+	}
+	if exitCode(errors.New("other")) != exitRuntime {
+		t.Errorf("Errors without exit code should exit with %d", exitRuntime)
+	}
+}
+
+// TestCheckConfig checks that a passing --check-config returns without
+// listening, even on a port that is in use.
+func TestCheckConfig(t *testing.T) {
+	oldDir, oldSlab := kstatDir, kmemSlabPath
+	defer func() { kstatDir, kmemSlabPath = oldDir, oldSlab }()
+	busy, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	busyPort := strconv.Itoa(busy.Addr().(*net.TCPAddr).Port)
+
+	if err := run([]string{"--mock", "--check-config", "--port", busyPort}); err != nil {
+		t.Errorf("Error in run with --check-config (%s)", err)
+	}
+}
+
+func TestParsePools(t *testing.T) {
+	pools := parsePools("tank, backup,tank,,")
+	if len(pools) != 2 || pools[0].name != "tank" || pools[1].name != "backup" {
+		t.Errorf("Incorrect pools %+v, should be tank and backup", pools)
+	}
+	if pools := parsePools(","); len(pools) != 0 {
+		t.Errorf("Empty list should produce no pools, got %+v", pools)
+	}
+	if pools := parsePools("bpool:boot", "rpool:root,a:b:"); len(pools) != 3 || pools[0].name != "bpool" || pools[1].name != "rpool" || pools[2].name != "a:b" {
+		t.Errorf("Incorrect pools %+v, should be bpool, rpool and a:b without their tags", pools)
+	}
+}
+
+func TestParsePoolTags(t *testing.T) {
+	tags, err := parsePoolTags("bpool:boot", "rpool:root, tank,a:b:,bpool:boot")
+	if err != nil {
+		t.Fatalf("Error in parsePoolTags (%s)", err)
+	}
+	if len(tags) != 2 || tags["bpool"] != "boot" || tags["rpool"] != "root" {
+		t.Errorf("Incorrect tags %v, should be boot and root", tags)
+	}
+	for _, list := range []string{":boot", "bpool:boot,bpool:root", "bpool:bo ot", "bpool:a=b"} {
+		if _, err := parsePoolTags(list); err == nil {
+			t.Errorf("parsePoolTags(%q) should produce error", list)
+		}
+	}
+}
+
+// TestPoolTagMetrics checks the series the alerts on a tagged pool use: the
+// tag, and the space in exact bytes rather than a ratio moving in large
+// steps on a small pool.
+func TestPoolTagMetrics(t *testing.T) {
+	e := newMockExporter(t)
+	tags, space := map[string]string{}, map[string]float64{}
+	for _, m := range e.snapshot(nil) {
+		switch name := descName(m.Desc()); name {
+		case "zpool_tag_info":
+			tags[metricLabel(m, "name")] = metricLabel(m, "tag")
+		case "zpool_free_bytes", "zpool_usable_available_bytes":
+			space[name+" "+metricLabel(m, "name")] = metricValue(m)
+		}
+	}
+	if len(tags) != 1 || tags["backup"] != "backup" {
+		t.Errorf("Only backup should be tagged, got %v", tags)
+	}
+	for key, want := range map[string]float64{
+		"zpool_free_bytes backup":             398572965069,
+		"zpool_usable_available_bytes backup": 227633266688,
+	} {
+		if space[key] != want {
+			t.Errorf("Incorrect %s (%v), should be %v", key, space[key], want)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	// Test two exporters in one process, on their own registries
+	for _, pools := range [][]zpool{{{name: "tank"}}, {{name: "backup"}}} {
+		e := NewExporter(&pools)
+		if err := e.Register(prometheus.NewRegistry()); err != nil {
+			t.Errorf("Error in Register (%s)", err)
+		}
+	}
+
+	// Test registering the same pools twice on one registry
+	reg := prometheus.NewRegistry()
+	if err := NewExporter(&[]zpool{{name: "tank"}}).Register(reg); err != nil {
+		t.Fatalf("Error in Register (%s)", err)
+	}
+	if err := NewExporter(&[]zpool{{name: "tank"}}).Register(reg); err == nil {
+		t.Errorf("Registering the same pools twice should produce error")
+	}
+}
+
+func TestNormalizeEndpoint(t *testing.T) {
+	for endpoint, want := range map[string]string{
+		"metrics":       "/metrics",
+		"/metrics":      "/metrics",
+		"//metrics/":    "/metrics",
+		"zfs/metrics":   "/zfs/metrics",
+		"/zfs//metrics": "/zfs/metrics",
+	} {
+		if got, err := normalizeEndpoint(endpoint); err != nil || got != want {
+			t.Errorf("normalizeEndpoint(%q) = %q, %v; should be %q", endpoint, got, err, want)
+		}
+	}
+	for _, endpoint := range []string{"", "/", "//", "metrics?format=text", "metrics#x", "my metrics"} {
+		if _, err := normalizeEndpoint(endpoint); err == nil {
+			t.Errorf("normalizeEndpoint(%q) should produce error", endpoint)
+		}
+	}
+}
+
+func TestServeReady(t *testing.T) {
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	os.Setenv("PATH", t.TempDir())
+
+	list, _ := fixtureRunner{}.run("zpool", "list", "-Hp", "-o", strings.Join(zpoolListProperties, ","), "tank")
+	status, _ := fixtureRunner{}.run("zpool", "status", "tank")
+	r := staticRunner{
+		"zpool list -Hp -o " + strings.Join(zpoolListProperties, ",") + " tank": list,
+		"zpool status tank": status,
+	}
+	e := NewExporter(&[]zpool{{name: "tank"}})
+	e.runner = r
+	ready := func() int {
+		w := httptest.NewRecorder()
+		e.ServeReady(w, httptest.NewRequest("GET", "/ready", nil))
+		return w.Code
+	}
+	collect := func() {
+		ch := make(chan prometheus.Metric)
+		go func() {
+			e.Collect(ch)
+			close(ch)
+		}()
+		for range ch {
+		}
+	}
+
+	// Test before and after the first successful collection
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Errorf("Exporter without collection should not be ready, got %d", code)
+	}
+	e.available = true
+	collect()
+	if code := ready(); code != http.StatusOK {
+		t.Errorf("Exporter should be ready after collecting, got %d", code)
+	}
+
+	// Test ZFS becoming unavailable in -keep-running mode
+	e.keepRunning = true
+	e.runner = staticRunner{}
+	collect()
+	if code := ready(); code != http.StatusServiceUnavailable || e.available {
+		t.Errorf("Exporter failing to collect should not be ready, got %d", code)
+	}
+	e.runner = r
+	collect()
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Errorf("Exporter without zpool should not be ready, got %d", code)
+	}
+}
+
+// failingRunner fails the zpool status of one pool, as for a pool zpool
+// cannot open, and runs everything else from the fixtures.
+type failingRunner struct {
+	fixtureRunner
+	pool string
+}
+
+func (f failingRunner) run(name string, args ...string) (string, error) {
+	if len(args) > 0 && args[0] == "status" && args[len(args)-1] == f.pool {
+		return "cannot open '" + f.pool + "': I/O error\n", errors.New("exit status 1")
+	}
+	return f.fixtureRunner.run(name, args...)
+}
+
+func (f failingRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	output, err := f.run(name, args...)
+	return io.NopCloser(strings.NewReader(output)), err
+}
+
+func TestPoolFailureIsolation(t *testing.T) {
+	e := NewExporter(&[]zpool{{name: "tank"}, {name: "broken"}, {name: "backup"}})
+	e.runner = failingRunner{pool: "broken"}
+	e.available = true
+	e.fatal = make(chan error, 1)
+	var metrics []prometheus.Metric
+	for i := 0; i < 2; i++ {
+		ch := make(chan prometheus.Metric)
+		go func() {
+			e.Collect(ch)
+			close(ch)
+		}()
+		metrics = nil
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+	}
+
+	got := map[string]float64{}
+	for _, m := range metrics {
+		got[descName(m.Desc())+" "+metricLabel(m, "name")] = metricValue(m)
+	}
+	for key, want := range map[string]float64{
+		"zfs_exporter_zfs_available ":                   1,
+		"zpool_up tank":                                 1,
+		"zpool_up backup":                               1,
+		"zpool_up broken":                               0,
+		"zfs_exporter_pool_collect_errors_total broken": 2,
+		"zfs_exporter_pool_collect_errors_total tank":   0,
+		"zpool_capacity_percentage tank":                51,
+		"zpool_capacity_percentage backup":              51,
+		"zpool_online_providers_count backup":           2,
+	} {
+		if v, ok := got[key]; !ok || v != want {
+			t.Errorf("Incorrect %s (%v), should be %v", key, v, want)
+		}
+	}
+	for key := range got {
+		if strings.HasSuffix(key, " broken") && !strings.HasPrefix(key, "zpool_up ") && !strings.HasPrefix(key, "zfs_exporter_pool_collect_errors_total ") {
+			t.Errorf("Failing pool should only export zpool_up and its error count, got %s", key)
+		}
+	}
+	select {
+	case err := <-e.fatal:
+		t.Errorf("One failing pool should not stop the exporter, got %s", err)
+	default:
+	}
+	w := httptest.NewRecorder()
+	e.ServeReady(w, httptest.NewRequest("GET", "/ready", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Exporter with a failing pool should not be ready, got %d", w.Code)
+	}
+}
+
+// TestSimilarPoolNames checks that pools whose names differ only in
+// punctuation export separate series rather than one of them twice.
+func TestSimilarPoolNames(t *testing.T) {
+	names := []string{"data-1", "data.1", "data_1", "data:1"}
+	var pools []zpool
+	for _, name := range names {
+		pools = append(pools, zpool{name: name})
+	}
+	e := NewExporter(&pools)
+	e.runner = fixtureRunner{}
+	e.available = true
+	e.fatal = make(chan error, 1)
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(e)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Error in Gather (%s)", err)
+	}
+	got := map[string]bool{}
+	for _, family := range families {
+		if family.GetName() != "zpool_up" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "name" {
+					got[label.GetValue()] = true
+				}
+			}
+		}
+	}
+	for _, name := range names {
+		if !got[name] {
+			t.Errorf("Pool %s should have its own zpool_up, got %v", name, got)
+		}
+	}
+	if len(got) != len(names) {
+		t.Errorf("Incorrect number of pools in zpool_up (%d), should be %d", len(got), len(names))
+	}
+}
+
+// slowRunner delays every zpool status, like a pool that is resilvering,
+// and records how many collections ran zpool status at the same time.
+type slowRunner struct {
+	fixtureRunner
+	delay   time.Duration
+	running *int32
+	most    *int32
+}
+
+func (s slowRunner) run(name string, args ...string) (string, error) {
+	if len(args) > 0 && args[0] == "status" {
+		n := atomic.AddInt32(s.running, 1)
+		defer atomic.AddInt32(s.running, -1)
+		for {
+			most := atomic.LoadInt32(s.most)
+			if n <= most || atomic.CompareAndSwapInt32(s.most, most, n) {
+				break
+			}
+		}
+		time.Sleep(s.delay)
+	}
+	return s.fixtureRunner.run(name, args...)
+}
+
+func (s slowRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	output, err := s.run(name, args...)
+	return io.NopCloser(strings.NewReader(output)), err
+}
+
+// TestConcurrentGather is meant to be run with -race as well.
+func TestConcurrentGather(t *testing.T) {
+	const scrapes = 5
+	const delay = 200 * time.Millisecond
+	var running, most int32
+	e := NewExporter(&[]zpool{{name: "tank"}})
+	e.runner = slowRunner{delay: delay, running: &running, most: &most}
+	e.available = true
+	e.addCollector("pool-counts", poolCountCollector{})
+	reg := prometheus.NewRegistry()
+	if err := e.Register(reg); err != nil {
+		t.Fatalf("Error in Register (%s)", err)
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	errs := make(chan error, scrapes)
+	for i := 0; i < scrapes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			families, err := reg.Gather()
+			if err == nil && len(families) == 0 {
+				err = errors.New("no metrics")
+			}
+			for _, f := range families {
+				if f.GetName() == "zpool_up" && f.GetMetric()[0].GetGauge().GetValue() != 1 {
+					err = errors.New("tank is not up")
+				}
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Error in concurrent Gather (%s)", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed >= scrapes*delay/2 {
+		t.Errorf("Concurrent scrapes should share collections, took %s for %d scrapes of %s", elapsed, scrapes, delay)
+	}
+	if most != 1 {
+		t.Errorf("Collections should not overlap, %d ran at once", most)
+	}
+}
+
+func TestOpenMetrics(t *testing.T) {
+	list, _ := fixtureRunner{}.run("zpool", "list", "-Hp", "-o", strings.Join(zpoolListProperties, ","), "tank")
+	status, _ := fixtureRunner{}.run("zpool", "status", "tank")
+	r := staticRunner{
+		"zpool list -Hp -o " + strings.Join(zpoolListProperties, ",") + " tank": list,
+		"zpool status tank": status,
+		"zfs get -Hp -o name,property,value filesystem_count,snapshot_count tank":                "tank\tfilesystem_count\t4\ntank\tsnapshot_count\t10\n",
+		"zfs list -Hp -o " + strings.Join(datasetColumns, ",") + " -t filesystem,volume -r tank": zfsListOutput,
+	}
+	e := NewExporter(&[]zpool{{name: "tank"}})
+	e.runner = r
+	e.available = true
+	e.addCollector("pool-counts", poolCountCollector{})
+	e.addCollector("dataset", newDatasetCollector(datasetOptions{maxDepth: -1}))
+	reg := prometheus.NewRegistry()
+	if err := e.Register(reg); err != nil {
+		t.Fatalf("Error in Register (%s)", err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	metricsHandler(reg, reg, nil).ServeHTTP(w, req)
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/openmetrics-text") {
+		t.Fatalf("Incorrect Content-Type %q, should be OpenMetrics", ct)
+	}
+
+	types := map[string]string{}
+	series := 0
+	p := textparse.NewOpenMetricsParser(w.Body.Bytes(), nil)
+	for {
+		entry, err := p.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Invalid OpenMetrics output (%s):\n%s", err, w.Body.String())
+		}
+		switch entry {
+		case textparse.EntryType:
+			name, typ := p.Type()
+			types[string(name)] = string(typ)
+		case textparse.EntrySeries:
+			series++
+		}
+	}
+	if series == 0 {
+		t.Errorf("OpenMetrics output should contain series:\n%s", w.Body.String())
+	}
+	for name, typ := range types {
+		if strings.HasSuffix(name, "_total") {
+			t.Errorf("%s %s should not have _total in its family name", typ, name)
+		}
+	}
+	if types["zfs_exporter_datasets_malformed"] != "counter" {
+		t.Errorf("zfs_exporter_datasets_malformed should be a counter, got %q", types["zfs_exporter_datasets_malformed"])
+	}
+	if !strings.Contains(w.Body.String(), "\nzfs_exporter_datasets_malformed_created ") {
+		t.Errorf("Counters should have a _created series:\n%s", w.Body.String())
+	}
+}
+
+// benchRunner serves the fixture pools and a zfs list of datasets.
+type benchRunner struct {
+	fixtureRunner
+	datasets string
+}
+
+func (r benchRunner) run(name string, args ...string) (string, error) {
+	if name == "zfs" && len(args) > 0 && args[0] == "list" {
+		return r.datasets, nil
+	}
+	return r.fixtureRunner.run(name, args...)
+}
+
+func (r benchRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	output, err := r.run(name, args...)
+	return io.NopCloser(strings.NewReader(output)), err
+}
+
+// BenchmarkCollect runs a whole scrape of 12 pools with slow I/O counts and
+// 600 datasets, through the registry like the HTTP handler does.
+func BenchmarkCollect(b *testing.B) {
+	var pools []zpool
+	r := benchRunner{}
+	for i := 0; i < 12; i++ {
+		name := fmt.Sprintf("pool%d", i)
+		pools = append(pools, zpool{name: name})
+		r.pools = append(r.pools, name)
+	}
+	var datasets strings.Builder
+	for i := 0; i < 600; i++ {
+		datasets.WriteString(zfsListRow(fmt.Sprintf("pool%d/data%d", i%12, i), "filesystem", map[string]string{
+			"used": "2199023255552", "available": "5685034868736", "referenced": "2199023255552", "quota": "0",
+			"usedbydataset": "2190433320960", "usedbysnapshots": "8589934592", "usedbychildren": "0", "usedbyrefreservation": "0",
+			"logicalused": "3298534883328", "logicalreferenced": "3285649981440", "written": "4294967296",
+			"mounted": "yes", "mountpoint": "/data", "canmount": "on",
+		}))
+	}
+	r.datasets = datasets.String()
+	e := NewExporter(&pools)
+	e.runner = r
+	e.available = true
+	e.pool.slowIOs = true
+	e.addCollector("dataset", newDatasetCollector(datasetOptions{maxDepth: -1}))
+	reg := prometheus.NewRegistry()
+	if err := e.Register(reg); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := reg.Gather(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// importingRunner is a fixtureRunner on which the pools in missing do not
+// exist yet, until they are deleted from it.
+type importingRunner struct {
+	fixtureRunner
+	missing map[string]bool
+}
+
+func (r importingRunner) run(name string, args ...string) (string, error) {
+	var errs string
+	var kept []string
+	for _, arg := range args {
+		if r.missing[arg] {
+			errs += "cannot open '" + arg + "': no such pool\n"
+			continue
+		}
+		kept = append(kept, arg)
+	}
+	var output string
+	if name == "zfs" && len(kept) > 0 && kept[0] == "get" {
+		for _, pool := range kept[5:] {
+			output += pool + "\t1600000000\n"
+		}
+	} else {
+		output, _ = r.fixtureRunner.run(name, kept...)
+	}
+	if errs != "" {
+		return errs + output, errors.New("exit status 1")
+	}
+	return output, nil
+}
+
+func (r importingRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	output, err := r.run(name, args...)
+	return io.NopCloser(strings.NewReader(output)), err
+}
+
+// TestMetricsCompression checks that the metrics are gzip-compressed for the
+// clients that accept it, and only for them.
+func TestMetricsCompression(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	g := prometheus.NewGauge(prometheus.GaugeOpts{Name: "zpool_up"})
+	g.Set(1)
+	reg.MustRegister(g)
+	for _, test := range []struct {
+		accept, encoding string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip;q=1.0, identity;q=0.5, *;q=0", "gzip"},
+		{"br", ""},
+		{"zstd", ""},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if test.accept != "" {
+			req.Header.Set("Accept-Encoding", test.accept)
+		}
+		metricsHandler(prometheus.NewRegistry(), reg, nil).ServeHTTP(w, req)
+		if encoding := w.Header().Get("Content-Encoding"); encoding != test.encoding {
+			t.Errorf("Incorrect Content-Encoding for Accept-Encoding %q (%q), should be %q", test.accept, encoding, test.encoding)
+			continue
+		}
+		body := io.Reader(w.Body)
+		if test.encoding == "gzip" {
+			zr, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatalf("Invalid gzip response (%s)", err)
+			}
+			body = zr
+		}
+		if b, _ := io.ReadAll(body); !strings.Contains(string(b), "zpool_up 1") {
+			t.Errorf("Incorrect metrics for Accept-Encoding %q:\n%s", test.accept, b)
+		}
+	}
+}
+
+func TestIgnoreMissingPools(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(dir+"/zpool", []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+	up := func(e *Exporter) map[string]float64 {
+		values := map[string]float64{}
+		for _, m := range e.snapshot(nil) {
+			if m.Desc() == zpoolUpDesc {
+				values[metricLabel(m, "name")] = metricValue(m)
+			}
+		}
+		return values
+	}
+
+	missing := map[string]bool{"data": true}
+	e := NewExporter(&[]zpool{{name: "tank"}, {name: "data"}})
+	e.runner = importingRunner{missing: missing}
+	if err := e.setup(); !errors.Is(err, errNoSuchPool) {
+		t.Errorf("Missing pool should produce no such pool error in setup, got %v", err)
+	}
+
+	e.pool.ignoreMissing = true
+	e.fatal = make(chan error, 1)
+	if err := e.setup(); err != nil {
+		t.Fatalf("Error in setup with a missing pool (%s)", err)
+	}
+	if got := up(e); got["tank"] != 1 || got["data"] != 0 {
+		t.Errorf("Incorrect zpool_up %v before data is imported", got)
+	}
+	delete(missing, "data")
+	if got := up(e); got["tank"] != 1 || got["data"] != 1 {
+		t.Errorf("Incorrect zpool_up %v after data is imported", got)
+	}
+	if creation := (*e.zpools)[1].creation; creation != 1600000000 {
+		t.Errorf("Incorrect creation time of the imported pool (%d)", creation)
+	}
+
+	// No pool exists at startup.
+	missing["data"] = true
+	e = NewExporter(&[]zpool{{name: "data"}})
+	e.runner = importingRunner{missing: missing}
+	e.pool.ignoreMissing = true
+	e.fatal = make(chan error, 1)
+	if err := e.setup(); err != nil {
+		t.Fatalf("Error in setup without any pool (%s)", err)
+	}
+	if got := up(e); got["data"] != 0 || !e.unprobed {
+		t.Errorf("Incorrect zpool_up %v before data is imported", got)
+	}
+	delete(missing, "data")
+	if got := up(e); got["data"] != 1 || e.unprobed {
+		t.Errorf("Incorrect zpool_up %v after data is imported", got)
+	}
+	select {
+	case err := <-e.fatal:
+		t.Errorf("Missing pools should not stop the exporter, got %s", err)
+	default:
+	}
+}
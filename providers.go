@@ -0,0 +1,328 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// statusVdev is one entry of the config section of zpool status: the pool,
+// a class heading such as logs, an interior vdev or a device.
+type statusVdev struct {
+	name        string
+	state       string // "" for class headings
+	indent      int
+	resilvering bool // annotated "(resilvering)", "(awaiting resilver)" or the like
+	errors      deviceErrors
+	errorsKnown bool          // the READ, WRITE and CKSUM columns were parsed
+	initialize  *vdevProgress // nil unless zpool status -i noted an initialize
+	children    []*statusVdev
+}
+
+// deviceErrors are the READ, WRITE and CKSUM columns of zpool status: the
+// read, write and checksum errors since the pool was imported or last
+// cleared with zpool clear.
+type deviceErrors struct {
+	read, write, checksum float64
+}
+
+// parseDeviceErrors parses the READ, WRITE and CKSUM columns at the start of
+// fields. zpool abbreviates large counts like sizes, such as 1.2K.
+func parseDeviceErrors(fields []string) (deviceErrors, bool) {
+	if len(fields) < 3 {
+		return deviceErrors{}, false
+	}
+	var counts [3]float64
+	for i := range counts {
+		v, err := parseHumanSize(fields[i])
+		if err != nil {
+			return deviceErrors{}, false
+		}
+		counts[i] = v
+	}
+	return deviceErrors{read: counts[0], write: counts[1], checksum: counts[2]}, true
+}
+
+// vdevStates are the states zpool status prints in the STATE column. Hot
+// spares are AVAIL or INUSE in the spares section.
+var vdevStates = []string{"ONLINE", "DEGRADED", "FAULTED", "OFFLINE", "UNAVAIL", "REMOVED", "AVAIL", "INUSE", "SPLIT"}
+
+// slotPrefixes name the interior vdevs that stand in for a single device
+// while it is replaced or covered by a hot spare. Their children are the old
+// and new devices, so they count as one provider.
+var slotPrefixes = []string{"replacing-", "spare-"}
+
+// parseStatusConfig parses the config section of zpool status output into
+// the entries at its top level, the pool and the class headings, with the
+// vdevs below them as children following the indentation.
+//
+// Device names longer than the NAME column push the other columns to the
+// right, or when the output was wrapped, onto the next line; such a line
+// starting with a state belongs to the entry before it. Annotations after the
+// counters, such as "was /dev/sdb1", are ignored, including when they were
+// wrapped onto a line of their own, except that a parenthesized note about a
+// resilver marks the entry as resilvering, and one about an initialize, which
+// zpool status -i prints, is kept in initialize.
+func parseStatusConfig(output string) []*statusVdev {
+	var p configParser
+	for lines := newLineScanner(output); lines.scan(); {
+		p.line(lines.line, strings.Fields(lines.line))
+	}
+	return p.roots
+}
+
+// configParser is parseStatusConfig fed one line at a time, as zpool status
+// prints them, along with its fields as split by strings.Fields.
+type configParser struct {
+	roots, stack   []*statusVdev
+	last           *statusVdev
+	inConfig, done bool
+}
+
+func (p *configParser) line(line string, fields []string) {
+	if p.done {
+		return
+	}
+	if !p.inConfig {
+		p.inConfig = len(fields) > 1 && fields[0] == "NAME" && fields[1] == "STATE"
+		return
+	}
+	if len(fields) == 0 {
+		p.done = true // end of the config section
+		return
+	}
+	if stringInSlice(fields[0], vdevStates) {
+		if p.last != nil && p.last.state == "" && len(p.last.children) == 0 {
+			p.last.state = fields[0]
+			p.last.errors, p.last.errorsKnown = parseDeviceErrors(fields[1:])
+			p.last.initialize = initializeNote(line)
+		}
+		return
+	}
+	if fields[0] == "was" || strings.HasPrefix(fields[0], "(") {
+		if p.last != nil && resilverNote(fields) {
+			p.last.resilvering = true
+		}
+		if p.last != nil && p.last.initialize == nil {
+			p.last.initialize = initializeNote(line)
+		}
+		return
+	}
+	v := &statusVdev{
+		name:        fields[0],
+		indent:      len(line) - len(strings.TrimLeft(line, " \t")),
+		resilvering: resilverNote(fields[1:]),
+		initialize:  initializeNote(strings.Join(fields[1:], " ")),
+	}
+	if len(fields) > 1 && stringInSlice(fields[1], vdevStates) {
+		v.state = fields[1]
+		v.errors, v.errorsKnown = parseDeviceErrors(fields[2:])
+	}
+	for len(p.stack) > 0 && p.stack[len(p.stack)-1].indent >= v.indent {
+		p.stack = p.stack[:len(p.stack)-1]
+	}
+	if len(p.stack) == 0 {
+		p.roots = append(p.roots, v)
+	} else {
+		parent := p.stack[len(p.stack)-1]
+		parent.children = append(parent.children, v)
+	}
+	p.stack = append(p.stack, v)
+	p.last = v
+}
+
+// resilverNote reports whether fields, the columns after a name, include a
+// parenthesized note mentioning a resilver. Its wording differs between
+// releases.
+func resilverNote(fields []string) bool {
+	rest := strings.Join(fields, " ")
+	if i := strings.Index(rest, "("); i >= 0 {
+		return strings.Contains(rest[i:], "resilver")
+	}
+	return false
+}
+
+// statusDevice is a leaf device of the config section of zpool status.
+type statusDevice struct {
+	device        string
+	guid          string // only with poolOptions.guids
+	enclosureSlot        // only with poolOptions.enclosures
+	resilvering   bool
+	errors        deviceErrors
+	errorsKnown   bool
+	initialize    *vdevProgress
+}
+
+// leafDevices returns the devices below the entries returned by
+// parseStatusConfig, once each: hot spares only show up where they are in
+// use, not in the spares section, and the indirect vdevs of removed devices
+// are left out.
+func leafDevices(roots []*statusVdev) []statusDevice {
+	var devices []statusDevice
+	var walk func(vdevs []*statusVdev)
+	walk = func(vdevs []*statusVdev) {
+		for _, v := range vdevs {
+			switch {
+			case hasAnyPrefix(v.name, removedVdevPrefixes):
+			case len(v.children) > 0:
+				walk(v.children)
+			default:
+				devices = append(devices, statusDevice{device: v.name, resilvering: v.resilvering, errors: v.errors, errorsKnown: v.errorsKnown, initialize: v.initialize})
+			}
+		}
+	}
+	for _, root := range roots {
+		if root.name != "spares" {
+			walk(root.children)
+		}
+	}
+	return devices
+}
+
+// providerHealth is how a provider counts towards the online and faulted
+// provider metrics.
+type providerHealth int
+
+const (
+	providerOther   providerHealth = iota // OFFLINE, REMOVED, spares and the like
+	providerOnline                        // ONLINE
+	providerFaulted                       // FAULTED or UNAVAIL
+)
+
+func healthOfState(state string) providerHealth {
+	switch state {
+	case "ONLINE":
+		return providerOnline
+	case "FAULTED", "UNAVAIL":
+		return providerFaulted
+	}
+	return providerOther
+}
+
+// slotHealth is the health of a replacing-N or spare-N vdev as one provider:
+// online when any device in it is online, since it then serves the data,
+// faulted when all of them are faulted.
+func slotHealth(v *statusVdev) providerHealth {
+	health := providerFaulted
+	for _, c := range v.children {
+		h := healthOfState(c.state)
+		if len(c.children) > 0 {
+			h = slotHealth(c)
+		}
+		switch {
+		case h == providerOnline:
+			return providerOnline
+		case h != providerFaulted:
+			health = providerOther
+		}
+	}
+	return health
+}
+
+// countProviders counts the online and faulted providers below the entries
+// returned by parseStatusConfig. The pool and class headings are never
+// providers themselves, interior vdevs such as mirror-0 and the indirect
+// vdevs of removed devices are not counted, and a device under replacement or
+// covered by a spare counts once.
+func countProviders(roots []*statusVdev) (online, faulted int64) {
+	var count func(vdevs []*statusVdev)
+	count = func(vdevs []*statusVdev) {
+		for _, v := range vdevs {
+			health := healthOfState(v.state)
+			switch {
+			case hasAnyPrefix(v.name, removedVdevPrefixes):
+				continue
+			case len(v.children) > 0 && hasAnyPrefix(v.name, slotPrefixes):
+				health = slotHealth(v)
+			case len(v.children) > 0:
+				count(v.children)
+				continue
+			}
+			switch health {
+			case providerOnline:
+				online++
+			case providerFaulted:
+				faulted++
+			}
+		}
+	}
+	for _, root := range roots {
+		count(root.children)
+	}
+	return online, faulted
+}
+
+// countClassFaulted counts the faulted providers in the section of the
+// class, such as logs or cache, as countProviders does. A pool imported
+// without its log or cache devices shows them UNAVAIL there, while its state
+// may still be ONLINE.
+func countClassFaulted(roots []*statusVdev, class string) int64 {
+	var faulted int64
+	for i, root := range roots {
+		// The first entry is the pool, which may have the name of a class.
+		if i > 0 && root.name == class {
+			_, n := countProviders([]*statusVdev{root})
+			faulted += n
+		}
+	}
+	return faulted
+}
+
+// countConfigured counts every provider in the config section, whatever
+// its state, as countProviders would if it counted them all: a device under
+// replacement or covered by a spare counts once, and so does a hot spare,
+// which the spares section also lists while it is in use.
+func countConfigured(roots []*statusVdev) int64 {
+	var n int64
+	var count func(vdevs []*statusVdev)
+	count = func(vdevs []*statusVdev) {
+		for _, v := range vdevs {
+			switch {
+			case hasAnyPrefix(v.name, removedVdevPrefixes):
+			case len(v.children) > 0 && hasAnyPrefix(v.name, slotPrefixes):
+				n++
+			case len(v.children) > 0:
+				count(v.children)
+			default:
+				n++
+			}
+		}
+	}
+	for _, root := range roots {
+		if root.name != "spares" {
+			count(root.children)
+			continue
+		}
+		for _, spare := range root.children {
+			if spare.state != "INUSE" {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// parseExpectedProviders parses the pool=count pairs of
+// -expected-providers, the number of providers each pool should have.
+func parseExpectedProviders(pairs []string) (map[string]int64, error) {
+	expected := map[string]int64{}
+	for _, pair := range pairs {
+		if pair == "" {
+			continue
+		}
+		i := strings.LastIndexByte(pair, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid -expected-providers %q, should be pool=count", pair)
+		}
+		pool := pair[:i]
+		n, err := strconv.ParseInt(pair[i+1:], 10, 64)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid -expected-providers %q, the count should be a positive integer", pair)
+		}
+		if _, ok := expected[pool]; ok {
+			return nil, fmt.Errorf("invalid -expected-providers %q, %q is given more than once", pair, pool)
+		}
+		expected[pool] = n
+	}
+	return expected, nil
+}
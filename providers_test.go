@@ -0,0 +1,208 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCountProviders(t *testing.T) {
+	for _, test := range []struct {
+		fixture                     string
+		online, faulted, configured int64
+		logs, cache                 int64 // faulted in the logs and cache sections
+	}{
+		// A replacement counts once: online while the new device is, and
+		// faulted when neither the old nor the new device is usable.
+		{"zpool-status-replacing.txt", 3, 1, 4, 0, 0},
+		// The faulted device is covered by the spare, which counts once, and
+		// the spares section does not count, except that the spare not in
+		// use is configured.
+		{"zpool-status-spare.txt", 7, 0, 8, 0, 0},
+		// Names overflowing the column, wrapped names and "was" annotations.
+		{"zpool-status-long-names.txt", 3, 2, 5, 0, 0},
+		// A pool imported without one of its log and cache devices, and
+		// with the log mirror missing both.
+		{"zpool-status-missing-log.txt", 3, 3, 6, 2, 1},
+	} {
+		z := zpool{name: "tank"}
+		if err := z.getProviders(readFixture(t, test.fixture)); err != nil {
+			t.Errorf("Error in getProviders of %s (%s)", test.fixture, err)
+		}
+		if z.online != test.online || z.faulted != test.faulted {
+			t.Errorf("Incorrect providers in %s, %d online and %d faulted, should be %d and %d",
+				test.fixture, z.online, z.faulted, test.online, test.faulted)
+		}
+		if z.logsFaulted != test.logs || z.cacheFaulted != test.cache {
+			t.Errorf("Incorrect faulted log and cache devices in %s, %d and %d, should be %d and %d",
+				test.fixture, z.logsFaulted, z.cacheFaulted, test.logs, test.cache)
+		}
+		if z.configured != test.configured {
+			t.Errorf("Incorrect configured providers in %s (%d), should be %d", test.fixture, z.configured, test.configured)
+		}
+	}
+}
+
+// TestCountClassFaulted checks that a pool named like a class is not taken
+// for its section.
+func TestCountClassFaulted(t *testing.T) {
+	output := "config:\n\n" +
+		"\tNAME        STATE     READ WRITE CKSUM\n" +
+		"\tlogs        DEGRADED     0     0     0\n" +
+		"\t  mirror-0  DEGRADED     0     0     0\n" +
+		"\t    sda     ONLINE       0     0     0\n" +
+		"\t    sdb     UNAVAIL      0     0     0\n" +
+		"\tcache\n" +
+		"\t  sdc       UNAVAIL      0     0     0\n"
+	roots := parseStatusConfig(output)
+	if logs, cache := countClassFaulted(roots, "logs"), countClassFaulted(roots, "cache"); logs != 0 || cache != 1 {
+		t.Errorf("Incorrect faulted log and cache devices of pool logs, %d and %d, should be 0 and 1", logs, cache)
+	}
+}
+
+func TestParseStatusConfig(t *testing.T) {
+	roots := parseStatusConfig(readFixture(t, "zpool-status-long-names.txt"))
+	if len(roots) != 1 || roots[0].name != "backup" || len(roots[0].children) != 1 {
+		t.Fatalf("Incorrect config %+v, should be the pool with one raidz", roots)
+	}
+	devices := roots[0].children[0].children
+	if len(devices) != 5 {
+		t.Fatalf("Incorrect number of devices (%d), should be 5", len(devices))
+	}
+	if devices[2].name != "ata-ST8000VN004-2M2101_WSD3IJKL-part1" || devices[2].state != "ONLINE" {
+		t.Errorf("Incorrect wrapped device %s %s", devices[2].name, devices[2].state)
+	}
+	for _, d := range devices[3:] {
+		if d.state != "UNAVAIL" || len(d.children) != 0 {
+			t.Errorf("Incorrect missing device %s %s with %d children", d.name, d.state, len(d.children))
+		}
+	}
+
+	roots = parseStatusConfig(readFixture(t, "zpool-status-spare.txt"))
+	var names []string
+	for _, root := range roots {
+		names = append(names, root.name)
+	}
+	if len(names) != 4 || names[1] != "logs" || names[2] != "cache" || names[3] != "spares" {
+		t.Errorf("Incorrect top-level entries %v", names)
+	}
+	if spare := roots[0].children[0].children[1]; spare.name != "spare-1" || len(spare.children) != 2 {
+		t.Errorf("Incorrect spare %s with %d children, should be spare-1 with 2", spare.name, len(spare.children))
+	}
+	if got := parseStatusConfig("  pool: tank\n state: ONLINE\n"); got != nil {
+		t.Errorf("Output without config should have no entries, got %v", got)
+	}
+}
+
+func TestLeafDevices(t *testing.T) {
+	for _, test := range []struct {
+		fixture     string
+		devices     int
+		resilvering []string
+	}{
+		{"zpool-status-replacing.txt", 6, []string{"ata-WDC_WD40EFRX-68N32N0_WD-WCC7K7FG8HIJ"}},
+		// The spare in use counts once, the available one not at all.
+		{"zpool-status-spare.txt", 8, nil},
+		{"zpool-status-long-names.txt", 5, nil},
+	} {
+		devices := leafDevices(parseStatusConfig(readFixture(t, test.fixture)))
+		var resilvering []string
+		for _, d := range devices {
+			if d.resilvering {
+				resilvering = append(resilvering, d.device)
+			}
+		}
+		if len(devices) != test.devices || strings.Join(resilvering, ",") != strings.Join(test.resilvering, ",") {
+			t.Errorf("Incorrect devices in %s (%d, resilvering %v), should be %d, resilvering %v",
+				test.fixture, len(devices), resilvering, test.devices, test.resilvering)
+		}
+	}
+
+	// Older releases say "awaiting resilver", which may be wrapped.
+	output := "config:\n\n" +
+		"\tNAME        STATE     READ WRITE CKSUM\n" +
+		"\ttank        ONLINE       0     0     0\n" +
+		"\t  mirror-0  ONLINE       0     0     0\n" +
+		"\t    sda     ONLINE       0     0     0  (awaiting resilver)\n" +
+		"\t    sdb     ONLINE       0     0     0\n" +
+		"\t      (awaiting resilver)\n" +
+		"\t    sdc     ONLINE       0     0     0  (trimming)\n"
+	devices := leafDevices(parseStatusConfig(output))
+	if len(devices) != 3 || !devices[0].resilvering || !devices[1].resilvering || devices[2].resilvering {
+		t.Errorf("Incorrect devices %+v, sda and sdb should be resilvering", devices)
+	}
+}
+
+func TestParseDeviceErrors(t *testing.T) {
+	output := "config:\n\n" +
+		"\tNAME        STATE     READ WRITE CKSUM\n" +
+		"\ttank        ONLINE       0     0     0\n" +
+		"\t  mirror-0  ONLINE       0     0     0\n" +
+		"\t    sda     ONLINE       3     0  1.5K\n" +
+		"\t    ata-WDC_WD40EFRX-68N32N0_WD-WCC7K7FG8HIJ\n" +
+		"\t            ONLINE       0     2     1\n" +
+		"\t    sdc     UNAVAIL\n"
+	devices := leafDevices(parseStatusConfig(output))
+	want := []statusDevice{
+		{device: "sda", errors: deviceErrors{read: 3, checksum: 1536}, errorsKnown: true},
+		{device: "ata-WDC_WD40EFRX-68N32N0_WD-WCC7K7FG8HIJ", errors: deviceErrors{write: 2, checksum: 1}, errorsKnown: true},
+		{device: "sdc"},
+	}
+	if len(devices) != len(want) {
+		t.Fatalf("Incorrect devices %+v, should be %+v", devices, want)
+	}
+	for i, d := range devices {
+		if d != want[i] {
+			t.Errorf("Incorrect device %+v, should be %+v", d, want[i])
+		}
+	}
+}
+
+func TestParseExpectedProviders(t *testing.T) {
+	expected, err := parseExpectedProviders([]string{"tank=6", "", "backup=2"})
+	if err != nil || len(expected) != 2 || expected["tank"] != 6 || expected["backup"] != 2 {
+		t.Errorf("Incorrect expected providers %v (%v)", expected, err)
+	}
+	for _, pairs := range [][]string{{"tank"}, {"=6"}, {"tank=six"}, {"tank=0"}, {"tank=6", "tank=7"}} {
+		if _, err := parseExpectedProviders(pairs); err == nil {
+			t.Errorf("parseExpectedProviders(%q) should fail", pairs)
+		}
+	}
+}
+
+func TestExpectedProvidersMetrics(t *testing.T) {
+	e := newMockExporter(t)
+	gather := func() (configured, expected map[string]float64) {
+		configured, expected = map[string]float64{}, map[string]float64{}
+		for _, m := range e.snapshot(nil) {
+			switch descName(m.Desc()) {
+			case "zpool_configured_providers_count":
+				configured[metricLabel(m, "name")] = metricValue(m)
+			case "zpool_expected_providers_count":
+				expected[metricLabel(m, "name")] = metricValue(m)
+			}
+		}
+		return configured, expected
+	}
+	e.pool.expectedProviders = map[string]int64{"backup": 3}
+	configured, expected := gather()
+	if configured["tank"] != 7 || configured["backup"] != 2 {
+		t.Errorf("Incorrect configured providers %v", configured)
+	}
+	if len(expected) != 1 || expected["backup"] != 3 {
+		t.Errorf("Only backup should have expected providers, got %v", expected)
+	}
+	e.pool.expectedProviders = nil
+	if _, expected := gather(); len(expected) != 0 {
+		t.Errorf("Without -expected-providers the expected providers should be absent, got %v", expected)
+	}
+}
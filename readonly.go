@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// readOnlySubcommands are the subcommands of zpool and zfs the exporter may
+// run, none of which changes a pool. zpool import is among them for the scan
+// of the import collector, which imports nothing as long as it is given no
+// arguments.
+var readOnlySubcommands = map[string][]string{
+	"zpool": {"status", "list", "get", "iostat", "events", "history", "import"},
+	"zfs":   {"list", "get", "userspace", "groupspace", "projectspace", "version"},
+}
+
+// readOnlyFlags are the options the exporter gives each command, by its
+// commandLabel. An option that is not among them, such as the -c of zpool
+// events, which clears the event log, or the -c of zpool status, which runs
+// scripts, is refused, and so is an operand that would be taken for one.
+var readOnlyFlags = map[string][]string{
+	"zpool status":     {"-D", "-g", "-i", "-j", "-p", "-s", "-t", "-v", "-x"},
+	"zpool list":       {"-H", "-Hp", "-o", "-v"},
+	"zpool get":        {"-H", "-Hp", "-o"},
+	"zpool iostat":     {"-Hp", "-p", "-r", "-v"},
+	"zpool events":     {"-H", "-v"},
+	"zpool history":    {"-l"},
+	"zfs list":         {"-H", "-Hp", "-d", "-o", "-r", "-t"},
+	"zfs get":          {"-Hp", "-o"},
+	"zfs userspace":    {"-Hp", "-o"},
+	"zfs groupspace":   {"-Hp", "-o"},
+	"zfs projectspace": {"-H", "-Hp", "-o"},
+	"zdb":              {"-C", "-U"},
+}
+
+// checkReadOnly returns an error unless c is zpool or zfs with one of
+// readOnlySubcommands, or zdb -C, which only reads the configuration of a
+// pool, given no other options than those of readOnlyFlags. It guards
+// against a command that changes a pool, such as zpool destroy or zfs set,
+// ever being built from the configuration, the admin API or the pools found
+// at runtime.
+func checkReadOnly(c command) error {
+	line := commandLine(c.name, c.args)
+	var operands []string
+	switch c.name {
+	case "zpool", "zfs":
+		subcommands := readOnlySubcommands[c.name]
+		if len(c.args) == 0 || !stringInSlice(c.args[0], subcommands) {
+			return fmt.Errorf("refusing to run %s, the exporter only runs the read-only subcommands %s of %s", line, strings.Join(subcommands, ", "), c.name)
+		}
+		operands = c.args[1:]
+	case "zdb":
+		if len(c.args) == 0 || c.args[0] != "-C" {
+			return fmt.Errorf("refusing to run %s, the exporter only runs zdb -C", line)
+		}
+		operands = c.args
+	default:
+		return fmt.Errorf("refusing to run %s, the exporter only runs zpool, zfs and zdb", line)
+	}
+	label := commandLabel(c.name, c.args)
+	switch {
+	case label == "zpool import" && len(operands) > 0:
+		return fmt.Errorf("refusing to run %s, the exporter only scans for importable pools with zpool import without arguments", line)
+	case label == "zpool events" && stringInSlice("-c", operands):
+		return fmt.Errorf("refusing to run %s, which clears the event log", line)
+	}
+	for _, arg := range operands {
+		if strings.HasPrefix(arg, "-") && !stringInSlice(arg, readOnlyFlags[label]) {
+			return fmt.Errorf("refusing to run %s, the exporter never gives %s the option %s", line, label, arg)
+		}
+	}
+	return nil
+}
+
+// checkReadOnly returns the error of checkReadOnly for the first command of
+// the plan of e that is not read-only, so that the exporter refuses to start
+// rather than run it.
+func (e *Exporter) checkReadOnly() error {
+	if len(*e.zpools) == 0 {
+		return nil
+	}
+	for _, plan := range e.plan(*e.zpools) {
+		for _, c := range plan.commands {
+			if err := checkReadOnly(c.command); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCheckReadOnly(t *testing.T) {
+	for _, c := range []command{
+		{"zpool", []string{"status", "-p", "tank"}},
+		{"zpool", []string{"import"}},
+		{"zpool", []string{"events", "-H", "-v"}},
+		{"zfs", []string{"groupspace", "-Hp", "tank/home"}},
+		{"zfs", []string{"version"}},
+		{"zdb", []string{"-C", "-U", "/etc/zfs/zpool.cache"}},
+		// Operands are never subcommands.
+		{"zpool", []string{"status", "destroy"}},
+		{"zfs", []string{"get", "-Hp", "-o", "name,value", "creation", "clear", "set"}},
+	} {
+		if err := checkReadOnly(c); err != nil {
+			t.Errorf("checkReadOnly(%v) should succeed, got %s", c, err)
+		}
+	}
+	for _, c := range []command{
+		{"zpool", []string{"destroy", "tank"}},
+		{"zpool", []string{"clear", "tank"}},
+		{"zpool", []string{"set", "cachefile=none", "tank"}},
+		{"zpool", []string{"import", "tank"}},
+		{"zpool", []string{"import", "-a"}},
+		{"zpool", []string{"events", "-c"}},
+		{"zpool", []string{"events", "-H", "-v", "-c"}},
+		{"zpool", []string{"status", "-c", "smart", "tank"}},
+		// Pools and datasets named like options.
+		{"zpool", []string{"list", "-Hp", "-o", "name,size", "-f"}},
+		{"zpool", []string{"status", "-p", "-c"}},
+		{"zfs", []string{"userspace", "-Hp", "-o", "name,used,quota", "-x"}},
+		{"zfs", []string{"holds", "tank@daily"}},
+		{"zpool", nil},
+		{"zpool", []string{"-o", "status"}},
+		{"zfs", []string{"destroy", "-r", "tank"}},
+		{"zfs", []string{"set", "sync=disabled", "tank"}},
+		{"zfs", nil},
+		{"zdb", []string{"-e", "tank"}},
+		{"zdb", []string{"-C", "-e", "tank"}},
+		{"rm", []string{"-rf", "/"}},
+		{"/sbin/zpool", []string{"status"}},
+	} {
+		if err := checkReadOnly(c); err == nil || !strings.HasPrefix(err.Error(), "refusing to run ") {
+			t.Errorf("checkReadOnly(%v) should refuse it, got %v", c, err)
+		}
+	}
+}
+
+// plannedCollector plans commands and collects nothing.
+type plannedCollector []command
+
+func (plannedCollector) describe(ch chan<- *prometheus.Desc) { ch <- zpoolUpDesc }
+
+func (plannedCollector) collect(r commandRunner, pools []zpool, ch chan<- prometheus.Metric) error {
+	return nil
+}
+
+func (c plannedCollector) plan([]zpool) []plannedCommand {
+	var plan []plannedCommand
+	for _, command := range c {
+		plan = append(plan, plannedCommand{command, ""})
+	}
+	return plan
+}
+
+func TestExporterReadOnly(t *testing.T) {
+	e := newMockExporter(t)
+	if err := e.checkReadOnly(); err != nil {
+		t.Fatalf("Every command of the mock exporter should be read-only, got %s", err)
+	}
+
+	// Pools and datasets named like subcommands, from -pool,
+	// -userspace-datasets or the dataset filters, stay operands.
+	pools := []zpool{{name: "destroy"}, {name: "clear"}, {name: "set"}}
+	e.zpools = &pools
+	e.addCollector("userspace", newSpaceCollector([]string{"destroy", "set/clear"}))
+	e.addCollector("history", newHistoryCollector())
+	if err := e.checkReadOnly(); err != nil {
+		t.Errorf("Pools and datasets should not make the commands change a pool, got %s", err)
+	}
+
+	for _, c := range []command{
+		{"zpool", []string{"destroy", "tank"}},
+		{"zpool", []string{"clear", "tank"}},
+		{"zfs", []string{"set", "readonly=on", "tank"}},
+	} {
+		e := newMockExporter(t)
+		e.addCollector("planned", plannedCollector{c})
+		err := e.checkReadOnly()
+		if err == nil || !strings.Contains(err.Error(), commandLine(c.name, c.args)) {
+			t.Errorf("The exporter should refuse to run %v, got %v", c, err)
+		}
+	}
+}
+
+// newGuardedExporter returns the mock exporter running its commands through
+// an instrumentedRunner, as run does, with a debugRunner under it recording
+// the commands that got past it.
+func newGuardedExporter(t *testing.T) (*Exporter, *debugState) {
+	t.Helper()
+	debug := &debugState{}
+	return newMockExporterWith(t, instrumentedRunner{debugRunner{mockRunner{}, debug}, newCommandMetrics()}), debug
+}
+
+// ranCommands returns the command lines debug recorded since the last call.
+func ranCommands(debug *debugState) []string {
+	var lines []string
+	for _, c := range debug.commands {
+		lines = append(lines, c.Command)
+	}
+	debug.commands = nil
+	return lines
+}
+
+// TestReadOnlyFlags checks that pools and datasets given on the command line
+// that would be taken for options make the exporter exit with 2.
+func TestReadOnlyFlags(t *testing.T) {
+	oldDir, oldSlab, oldParams := kstatDir, kmemSlabPath, moduleParamsDir
+	defer func() { kstatDir, kmemSlabPath, moduleParamsDir = oldDir, oldSlab, oldParams }()
+	for _, test := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--mock", "--pool", "-c"}, `invalid --pool "-c"`},
+		{[]string{"--mock", "--pool", "tank,-f destroy"}, `invalid --pool "-f destroy"`},
+		{[]string{"--mock", "--pool", "tank,-f:clear"}, `invalid --pool "-f"`},
+		{[]string{"--mock", "--userspace-datasets", "-c"}, "refusing to run zfs userspace -Hp -o name,used,quota -c"},
+		{[]string{"--mock", "--userspace-datasets", "tank/home,-r", "--print-commands"}, "refusing to run zfs userspace -Hp -o name,used,quota -r"},
+	} {
+		err := run(test.args)
+		if exitCode(err) != exitConfig || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("run(%q) should exit with %d: %s, got %v", test.args, exitConfig, test.want, err)
+		}
+	}
+}
+
+// TestReadOnlyAdminAPI checks that the pools added through the admin API
+// only ever run read-only commands.
+func TestReadOnlyAdminAPI(t *testing.T) {
+	e, debug := newGuardedExporter(t)
+	ranCommands(debug)
+	for name, want := range map[string]int{
+		"-c":      http.StatusBadRequest,
+		"-f":      http.StatusBadRequest,
+		"destroy": http.StatusNotFound,
+		"clear":   http.StatusNotFound,
+		"set":     http.StatusNotFound,
+		"-c%20x":  http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		e.ServeAdminPools(w, httptest.NewRequest(http.MethodPost, adminPoolsPath+name, nil))
+		if w.Code != want {
+			t.Errorf("Incorrect status of POST %s (%d), should be %d", name, w.Code, want)
+		}
+	}
+	// Pools named like subcommands stay operands.
+	lines := ranCommands(debug)
+	sort.Strings(lines)
+	if want := []string{"zpool list clear", "zpool list destroy", "zpool list set"}; !slices.Equal(lines, want) {
+		t.Errorf("Incorrect commands run for the admin API %q, should be %q", lines, want)
+	}
+}
+
+// TestReadOnlySyncPools checks that the commands of a pool named like an
+// option, which the admin API refuses, are refused as they run once the
+// pool is among those collected.
+func TestReadOnlySyncPools(t *testing.T) {
+	e, debug := newGuardedExporter(t)
+	e.mutex.Lock()
+	e.wantPools = append(e.wantPools, "-c", "destroy")
+	e.poolsChanged = true
+	e.mutex.Unlock()
+	success := map[string]float64{}
+	for _, m := range e.snapshot(nil) {
+		if m.Desc() == collectorSuccessDesc {
+			success[metricLabel(m, "collector")] = metricValue(m)
+		}
+	}
+	if got := poolNames(*e.zpools); !slices.Equal(got, []string{"tank", "backup", "-c", "destroy"}) {
+		t.Fatalf("Incorrect pools after syncPools %q", got)
+	}
+	if success["pool"] != 0 {
+		t.Errorf("The pool collector should fail to run commands for pool -c")
+	}
+	for _, line := range ranCommands(debug) {
+		if stringInSlice("-c", strings.Fields(line)) {
+			t.Errorf("%s should have been refused", line)
+		}
+	}
+}
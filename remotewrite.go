@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Defaults for pushing to a remote-write endpoint.
+const (
+	remoteWriteBatch      = 5000 // samples per request
+	remoteWriteMinBackoff = time.Second
+	remoteWriteMaxBackoff = 5 * time.Minute
+)
+
+// remoteWriter pushes the gathered metrics to a Prometheus remote-write
+// endpoint every interval, for hosts that can reach the monitoring system
+// but cannot be scraped. Samples that could not be sent yet are kept, up to
+// maxPending, and retried with exponential backoff.
+type remoteWriter struct {
+	url         *url.URL
+	client      *http.Client
+	gatherer    prometheus.Gatherer
+	interval    time.Duration
+	maxPending  int
+	username    string
+	password    string
+	bearerToken string
+
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	// pending is only used by the goroutine running run.
+	pending []prompb.TimeSeries
+
+	sent         prometheus.Counter
+	failed       prometheus.Counter
+	pendingGauge prometheus.Gauge
+}
+
+func newRemoteWriter(rawURL string, g prometheus.Gatherer, interval time.Duration, maxPending int) (*remoteWriter, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("-remote-write-url %q should be an http or https URL", rawURL)
+	}
+	if interval <= 0 {
+		return nil, errors.New("-remote-write-interval should be positive")
+	}
+	if maxPending < 1 {
+		return nil, errors.New("-remote-write-buffer should be at least 1")
+	}
+	return &remoteWriter{
+		url:        u,
+		client:     &http.Client{Timeout: interval},
+		gatherer:   g,
+		interval:   interval,
+		maxPending: maxPending,
+		minBackoff: remoteWriteMinBackoff,
+		maxBackoff: remoteWriteMaxBackoff,
+		sent: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "zfs_exporter_remote_write_samples_sent_total",
+			Help: "Number of samples accepted by the remote-write endpoint",
+		}),
+		failed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "zfs_exporter_remote_write_samples_failed_total",
+			Help: "Number of samples dropped because the remote-write endpoint rejected them or the buffer was full",
+		}),
+		pendingGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "zfs_exporter_remote_write_pending_samples",
+			Help: "Number of samples waiting to be sent to the remote-write endpoint",
+		}),
+	}, nil
+}
+
+// setAuth configures basic auth or a bearer token, read from files so that
+// secrets do not show up in the process list.
+func (w *remoteWriter) setAuth(username, passwordFile, tokenFile string) error {
+	if username != "" && tokenFile != "" {
+		return errors.New("-remote-write-username and -remote-write-bearer-token-file cannot be combined")
+	}
+	if (username == "") != (passwordFile == "") {
+		return errors.New("-remote-write-username and -remote-write-password-file should be given together")
+	}
+	var err error
+	if username != "" {
+		w.username = username
+		w.password, err = readSecret(passwordFile)
+	}
+	if tokenFile != "" {
+		w.bearerToken, err = readSecret(tokenFile)
+	}
+	return err
+}
+
+// readSecret returns the contents of path without trailing whitespace.
+func readSecret(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n\t "), nil
+}
+
+// register registers the self-metrics of the writer.
+func (w *remoteWriter) register(reg prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{w.sent, w.failed, w.pendingGauge} {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run gathers and pushes every interval until ctx is done. While the
+// endpoint keeps failing, retries back off up to maxBackoff and new samples
+// are only buffered.
+func (w *remoteWriter) run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	var backoff time.Duration
+	var retry <-chan time.Time
+	push := func() {
+		if err := w.flush(ctx); err != nil {
+			backoff = nextBackoff(backoff, w.minBackoff, w.maxBackoff)
+			log.Printf("Warning: remote write to %s failed, retrying in %s: %s", w.url.Redacted(), backoff, err)
+			retry = time.After(backoff)
+			return
+		}
+		backoff, retry = 0, nil
+	}
+
+	w.enqueue(time.Now())
+	push()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			w.enqueue(now)
+			if retry == nil {
+				push()
+			}
+		case <-retry:
+			push()
+		}
+	}
+}
+
+// nextBackoff doubles the previous backoff, starting at lo and capped at hi.
+func nextBackoff(prev, lo, hi time.Duration) time.Duration {
+	if prev < lo {
+		return lo
+	}
+	if prev*2 > hi {
+		return hi
+	}
+	return prev * 2
+}
+
+// enqueue gathers the current metrics and adds them to the pending samples,
+// dropping the oldest samples beyond maxPending.
+func (w *remoteWriter) enqueue(now time.Time) {
+	families, err := w.gatherer.Gather()
+	if err != nil {
+		// Gather still returns what it could collect.
+		log.Printf("Error gathering metrics for remote write: %s", err)
+	}
+	w.pending = append(w.pending, timeSeries(families, now.UnixNano()/int64(time.Millisecond))...)
+	if drop := len(w.pending) - w.maxPending; drop > 0 {
+		log.Printf("Warning: remote write buffer is full, dropping the %d oldest samples", drop)
+		w.failed.Add(float64(drop))
+		w.pending = append([]prompb.TimeSeries(nil), w.pending[drop:]...)
+	}
+	w.pendingGauge.Set(float64(len(w.pending)))
+}
+
+// recoverableError is a failed push that is worth retrying, such as a
+// network error or a 5xx or 429 response.
+type recoverableError struct {
+	error
+}
+
+// flush sends the pending samples in batches. Batches the endpoint rejects
+// permanently are dropped; it stops at the first recoverable error, leaving
+// the rest pending.
+func (w *remoteWriter) flush(ctx context.Context) error {
+	defer func() { w.pendingGauge.Set(float64(len(w.pending))) }()
+	for len(w.pending) > 0 {
+		n := len(w.pending)
+		if n > remoteWriteBatch {
+			n = remoteWriteBatch
+		}
+		err := w.send(ctx, w.pending[:n])
+		var recoverable recoverableError
+		if errors.As(err, &recoverable) {
+			return err
+		}
+		if err != nil {
+			log.Printf("Error in remote write to %s, dropping %d samples: %s", w.url.Redacted(), n, err)
+			w.failed.Add(float64(n))
+		} else {
+			w.sent.Add(float64(n))
+		}
+		w.pending = w.pending[n:]
+	}
+	w.pending = nil
+	return nil
+}
+
+// send pushes one remote-write request.
+func (w *remoteWriter) send(ctx context.Context, series []prompb.TimeSeries) error {
+	body, err := (&prompb.WriteRequest{Timeseries: series}).Marshal()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", w.url.String(), bytes.NewReader(snappy.Encode(nil, body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "prometheus-zfs/"+toolVersion)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if w.username != "" {
+		req.SetBasicAuth(w.username, w.password)
+	}
+	if w.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+w.bearerToken)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return recoverableError{err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+		return recoverableError{err}
+	}
+	return err
+}
+
+// timeSeries converts gathered metric families to one remote-write series
+// per sample, with timestamp ts in milliseconds unless the metric has one of
+// its own. Summaries and histograms are split into the series the text format
+// would show.
+func timeSeries(families []*dto.MetricFamily, ts int64) []prompb.TimeSeries {
+	var series []prompb.TimeSeries
+	for _, family := range families {
+		name := family.GetName()
+		for _, m := range family.GetMetric() {
+			ts := ts
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			add := func(name string, v float64, extra ...string) {
+				labels := []prompb.Label{{Name: "__name__", Value: name}}
+				for _, l := range m.GetLabel() {
+					labels = append(labels, prompb.Label{Name: l.GetName(), Value: l.GetValue()})
+				}
+				for i := 0; i+1 < len(extra); i += 2 {
+					labels = append(labels, prompb.Label{Name: extra[i], Value: extra[i+1]})
+				}
+				sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+				series = append(series, prompb.TimeSeries{
+					Labels:  labels,
+					Samples: []prompb.Sample{{Value: v, Timestamp: ts}},
+				})
+			}
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add(name, q.GetValue(), "quantile", formatFloat(q.GetQuantile()))
+				}
+				add(name+"_sum", s.GetSampleSum())
+				add(name+"_count", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				inf := false
+				for _, b := range h.GetBucket() {
+					add(name+"_bucket", float64(b.GetCumulativeCount()), "le", formatFloat(b.GetUpperBound()))
+					inf = inf || math.IsInf(b.GetUpperBound(), 1)
+				}
+				if !inf {
+					add(name+"_bucket", float64(h.GetSampleCount()), "le", "+Inf")
+				}
+				add(name+"_sum", h.GetSampleSum())
+				add(name+"_count", float64(h.GetSampleCount()))
+			}
+		}
+	}
+	return series
+}
+
+// formatFloat formats a quantile or bucket bound like the text format does.
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
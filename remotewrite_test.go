@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// seriesName returns the __name__ label of s.
+func seriesName(s prompb.TimeSeries) string {
+	for _, l := range s.Labels {
+		if l.Name == "__name__" {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+func TestTimeSeries(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "zpool_capacity_percentage", Help: "h"}, []string{"name"})
+	gauge.WithLabelValues("tank").Set(51)
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "scrape_seconds", Help: "h", Buckets: []float64{0.5, 1}})
+	histogram.Observe(0.7)
+	reg.MustRegister(gauge, histogram)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	series := timeSeries(families, 1000)
+	got := map[string]float64{}
+	for _, s := range series {
+		key := seriesName(s)
+		for _, l := range s.Labels {
+			if l.Name != "__name__" {
+				key += " " + l.Name + "=" + l.Value
+			}
+		}
+		if len(s.Samples) != 1 || s.Samples[0].Timestamp != 1000 {
+			t.Errorf("%s should have one sample at 1000, got %v", key, s.Samples)
+		}
+		got[key] = s.Samples[0].Value
+	}
+	for key, want := range map[string]float64{
+		"zpool_capacity_percentage name=tank": 51,
+		"scrape_seconds_bucket le=0.5":        0,
+		"scrape_seconds_bucket le=1":          1,
+		"scrape_seconds_bucket le=+Inf":       1,
+		"scrape_seconds_sum":                  0.7,
+		"scrape_seconds_count":                1,
+	} {
+		if v, ok := got[key]; !ok || v != want {
+			t.Errorf("Incorrect %s (%v), should be %v", key, v, want)
+		}
+	}
+	if len(got) != 6 {
+		t.Errorf("Incorrect series %v", got)
+	}
+}
+
+func TestTimeSeriesOwnTimestamp(t *testing.T) {
+	name, v, own := "zpool_importable", 1.0, int64(500)
+	families := []*dto.MetricFamily{{
+		Name: &name,
+		Type: dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{
+			{Gauge: &dto.Gauge{Value: &v}, TimestampMs: &own},
+			{Gauge: &dto.Gauge{Value: &v}},
+		},
+	}}
+	series := timeSeries(families, 1000)
+	if len(series) != 2 || series[0].Samples[0].Timestamp != 500 || series[1].Samples[0].Timestamp != 1000 {
+		t.Errorf("A metric with a timestamp should keep it and the others get 1000, got %v", series)
+	}
+}
+
+func TestRemoteWriter(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	var received []prompb.TimeSeries
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Authorization") != "Bearer s3cret" {
+			t.Errorf("Incorrect headers %v", r.Header)
+		}
+		compressed, _ := io.ReadAll(r.Body)
+		body, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Errorf("Error decoding request (%s)", err)
+			return
+		}
+		var req prompb.WriteRequest
+		if err := req.Unmarshal(body); err != nil {
+			t.Errorf("Error unmarshaling request (%s)", err)
+			return
+		}
+		if status == http.StatusOK {
+			received = append(received, req.Timeseries...)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	token := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(token, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "zfs_exporter_zfs_available", Help: "h"})
+	reg.MustRegister(gauge)
+	w, err := newRemoteWriter(server.URL+"/api/v1/push", reg, time.Minute, 2)
+	if err != nil {
+		t.Fatalf("Error in newRemoteWriter (%s)", err)
+	}
+	if err := w.setAuth("", "", token); err != nil {
+		t.Fatalf("Error in setAuth (%s)", err)
+	}
+	counterValue := func(c prometheus.Counter) float64 {
+		var m dto.Metric
+		c.Write(&m)
+		return m.GetCounter().GetValue()
+	}
+
+	// Unavailable endpoints keep the samples, up to the buffer size
+	for i := 0; i < 3; i++ {
+		w.enqueue(time.Unix(int64(i), 0))
+		if err := w.flush(context.Background()); err == nil {
+			t.Errorf("503 should produce error in flush")
+		}
+	}
+	if len(w.pending) != 2 || w.pending[0].Samples[0].Timestamp != 1000 || counterValue(w.failed) != 1 {
+		t.Errorf("Full buffer should drop the oldest sample, pending %v, failed %v", w.pending, counterValue(w.failed))
+	}
+
+	status = http.StatusOK
+	if err := w.flush(context.Background()); err != nil {
+		t.Errorf("Error in flush (%s)", err)
+	}
+	if len(received) != 2 || len(w.pending) != 0 || counterValue(w.sent) != 2 {
+		t.Errorf("Pending samples should be sent, received %d, sent %v", len(received), counterValue(w.sent))
+	}
+
+	// Rejected samples are dropped rather than retried
+	status = http.StatusBadRequest
+	w.enqueue(time.Unix(3, 0))
+	if err := w.flush(context.Background()); err != nil {
+		t.Errorf("400 should not be retried, got %s", err)
+	}
+	if len(w.pending) != 0 || counterValue(w.failed) != 2 {
+		t.Errorf("Rejected samples should be dropped, pending %d, failed %v", len(w.pending), counterValue(w.failed))
+	}
+}
+
+func TestNewRemoteWriter(t *testing.T) {
+	for _, rawURL := range []string{"mimir:9009/api/v1/push", "ftp://mimir/push", "https://"} {
+		if _, err := newRemoteWriter(rawURL, prometheus.NewRegistry(), time.Minute, 1); err == nil {
+			t.Errorf("%q should produce error in newRemoteWriter", rawURL)
+		}
+	}
+	w, err := newRemoteWriter("https://mimir/api/v1/push", prometheus.NewRegistry(), time.Minute, 1)
+	if err != nil {
+		t.Fatalf("Error in newRemoteWriter (%s)", err)
+	}
+	if err := w.setAuth("exporter", "", ""); err == nil {
+		t.Errorf("Username without password file should produce error in setAuth")
+	}
+	if err := w.setAuth("exporter", "pw", "token"); err == nil {
+		t.Errorf("Basic auth and bearer token should produce error in setAuth")
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	var backoff time.Duration
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		backoff = nextBackoff(backoff, time.Second, 5*time.Second)
+		if backoff != want {
+			t.Errorf("Incorrect backoff %s, should be %s", backoff, want)
+		}
+	}
+}
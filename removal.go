@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+)
+
+// removedVdevPrefixes name what is left of top-level vdevs removed with
+// zpool remove: zpool status shows indirect-N, which maps the blocks that
+// were on the vdev, and zdb shows the emptied slot as a hole.
+var removedVdevPrefixes = []string{"indirect-", "hole"}
+
+// countIndirect counts the indirect vdevs in the entries returned by
+// parseStatusConfig.
+func countIndirect(roots []*statusVdev) int64 {
+	var n int64
+	for _, root := range roots {
+		for _, v := range root.children {
+			if strings.HasPrefix(v.name, "indirect-") {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// removalStatus is the remove: section of zpool status, about the last
+// top-level vdev removed from the pool.
+type removalStatus struct {
+	present    bool // false when no vdev was ever removed
+	inProgress bool
+	// copied and total are the bytes copied off the vdev and the bytes to
+	// copy, -1 when zpool status does not show them, as for a canceled
+	// removal.
+	copied, total float64
+}
+
+// remaining returns the bytes left to copy off the vdev being removed, or -1
+// when no removal is in progress.
+func (s removalStatus) remaining() float64 {
+	if !s.inProgress || s.copied < 0 || s.total < s.copied {
+		return -1
+	}
+	return s.total - s.copied
+}
+
+// parseRemoval parses the remove: section of zpool status, such as
+//
+//	remove: Evacuation of /dev/sdb in progress since Thu Oct 10 09:12:30 2024
+//		1.37G copied out of 2.15G at 140M/s, 63.72% done, 0h0m to go
+//
+// or, once the removal completed,
+//
+//	remove: Removal of vdev 1 copied 2.15G in 0h15m, completed on Thu Oct 10 09:27:41 2024
+//
+// The section stays after a removal completed or was canceled, until the
+// pool is exported.
+func parseRemoval(output string) removalStatus {
+	section := statusSection(output, "remove:")
+	if len(section) == 0 {
+		return removalStatus{}
+	}
+	s := removalStatus{present: true, copied: -1, total: -1}
+	if strings.Contains(section[0], " in progress since ") {
+		s.inProgress = true
+		if len(section) < 2 {
+			return s
+		}
+		// "1.37G copied out of 2.15G at 140M/s"
+		progress, _, _ := strings.Cut(section[1], ", ")
+		copied, rest, ok := strings.Cut(progress, " copied out of ")
+		if !ok {
+			return s
+		}
+		total, _, _ := strings.Cut(rest, " ")
+		c, err := parseHumanSize(copied)
+		if err != nil {
+			return s
+		}
+		t, err := parseHumanSize(total)
+		if err != nil {
+			return s
+		}
+		s.copied, s.total = c, t
+		return s
+	}
+	// "Removal of vdev 1 copied 2.15G in 0h15m, completed on ..."
+	if _, rest, ok := strings.Cut(section[0], " copied "); ok && strings.Contains(rest, "completed on") {
+		size, _, _ := strings.Cut(rest, " ")
+		if v, err := parseHumanSize(size); err == nil {
+			s.copied, s.total = v, v
+		}
+	}
+	return s
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRemovedVdevs(t *testing.T) {
+	for _, test := range []struct {
+		fixture  string
+		online   int64
+		indirect int64
+		removing float64
+	}{
+		{"zpool-status-removed.txt", 4, 2, -1},
+		{"zpool-status-removing.txt", 4, 1, (1.82 - 1.37) * (1 << 40)},
+	} {
+		z := zpool{name: "tank"}
+		if err := z.getProviders(readFixture(t, test.fixture)); err != nil {
+			t.Errorf("Error in getProviders of %s (%s)", test.fixture, err)
+		}
+		if z.online != test.online || z.faulted != 0 {
+			t.Errorf("Incorrect providers in %s, %d online and %d faulted, should be %d and 0",
+				test.fixture, z.online, z.faulted, test.online)
+		}
+		if z.indirect != test.indirect {
+			t.Errorf("Incorrect indirect vdevs in %s (%d), should be %d", test.fixture, z.indirect, test.indirect)
+		}
+		if diff := z.removal.remaining() - test.removing; diff < -1 || diff > 1 {
+			t.Errorf("Incorrect bytes removing in %s (%v), should be %v", test.fixture, z.removal.remaining(), test.removing)
+		}
+	}
+}
+
+func TestParseRemoval(t *testing.T) {
+	for _, test := range []struct {
+		output    string
+		want      removalStatus
+		remaining float64
+	}{
+		{"  pool: tank\n state: ONLINE\nconfig:\n", removalStatus{}, -1},
+		{"remove: Removal of /dev/sdb canceled on Mon Oct 14 09:20:00 2024\n",
+			removalStatus{present: true, copied: -1, total: -1}, -1},
+		{"remove: Evacuation of /dev/sdb in progress since Mon Oct 14 09:12:30 2024\n",
+			removalStatus{present: true, inProgress: true, copied: -1, total: -1}, -1},
+		{"remove: Evacuation of /dev/sdb in progress since Mon Oct 14 09:12:30 2024\n\tsomething else\n",
+			removalStatus{present: true, inProgress: true, copied: -1, total: -1}, -1},
+		{"remove: Evacuation of /dev/sdb in progress since Mon Oct 14 09:12:30 2024\n" +
+			"\t1048576 copied out of 3145728 at 140M/s, 33.33% done, 0h0m to go\n",
+			removalStatus{present: true, inProgress: true, copied: 1048576, total: 3145728}, 2097152},
+		{"remove: Removal of vdev 1 copied 3145728 in 0h15m, completed on Mon Oct 14 09:27:41 2024\n" +
+			"\t15.9M memory used for removed device mappings\n",
+			removalStatus{present: true, copied: 3145728, total: 3145728}, -1},
+	} {
+		got := parseRemoval(test.output)
+		if got != test.want || got.remaining() != test.remaining {
+			t.Errorf("Incorrect removal %+v (%v remaining) for %q, should be %+v (%v)", got, got.remaining(), test.output, test.want, test.remaining)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	readRequestSizeDesc = prometheus.NewDesc("zpool_read_request_size_bytes",
+		"Sizes of the read requests issued to the devices of the zpool since it was imported, by I/O class and whether they were issued individually or aggregated", []string{"name", "class", "aggregation"}, nil)
+	writeRequestSizeDesc = prometheus.NewDesc("zpool_write_request_size_bytes",
+		"Sizes of the write requests issued to the devices of the zpool since it was imported, by I/O class and whether they were issued individually or aggregated", []string{"name", "class", "aggregation"}, nil)
+)
+
+// requestSizeColumns maps the zpool iostat -r columns onto the histogram and
+// class they are exported as. trim and initialize neither read nor write
+// data, and are left out.
+var requestSizeColumns = map[string]struct {
+	desc  *prometheus.Desc
+	class string
+}{
+	"sync_read":   {readRequestSizeDesc, "sync"},
+	"async_read":  {readRequestSizeDesc, "async"},
+	"scrub":       {readRequestSizeDesc, "scrub"},
+	"rebuild":     {readRequestSizeDesc, "rebuild"},
+	"sync_write":  {writeRequestSizeDesc, "sync"},
+	"async_write": {writeRequestSizeDesc, "async"},
+}
+
+// requestSizeHistogram is one ind or agg column of zpool iostat -r: the
+// number of requests in each power-of-two size range, by the lower bound of
+// the range.
+type requestSizeHistogram struct {
+	pool, column, aggregation string
+	counts                    map[uint64]uint64
+}
+
+// buckets returns the cumulative bucket counts of h for a Prometheus
+// histogram, with its count and an estimate of its sum. zpool counts the
+// requests of at least size and less than twice that in the row of size, so
+// the upper bound of its bucket is twice size less a byte. The sizes are
+// only known to the row, so each request counts as the smallest of its row
+// in the sum.
+func (h requestSizeHistogram) buckets() (map[float64]uint64, uint64, float64) {
+	sizes := make([]uint64, 0, len(h.counts))
+	for size := range h.counts {
+		sizes = append(sizes, size)
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	buckets := make(map[float64]uint64, len(sizes))
+	var count uint64
+	var sum float64
+	for _, size := range sizes {
+		count += h.counts[size]
+		sum += float64(size) * float64(h.counts[size])
+		buckets[float64(2*size-1)] = count
+	}
+	return buckets, count, sum
+}
+
+// parseRequestSizes parses zpool iostat -r -p output, which holds for every
+// pool a header naming the pool and the columns, such as
+//
+//	tank        sync_read    sync_write    async_read    async_write      scrub         trim
+//	req_size    ind    agg    ind    agg    ind    agg    ind    agg    ind    agg    ind    agg
+//	----------  -----  -----  -----  -----  -----  -----  -----  -----  -----  -----  -----  -----
+//	512           0      0      0      0      0      0      0      0      0      0      0      0
+//	1024         12      0    340      0      0      0   4500     81      0      0      0      0
+//
+// followed by a row for every power of two, up to 16M. Without -p sizes and
+// counts are abbreviated, such as 1K and 4.50K.
+func parseRequestSizes(output string) ([]requestSizeHistogram, error) {
+	var (
+		histograms []requestSizeHistogram
+		header     []string // the pool and its columns
+		current    []int    // indexes into histograms of the columns of the rows
+	)
+	for lines := newLineScanner(output); lines.scan(); {
+		fields := strings.Fields(lines.line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "---") {
+			continue
+		}
+		if fields[0] == "req_size" {
+			if len(header) < 2 {
+				return nil, fmt.Errorf("zpool iostat -r: no pool header above %q", lines.line)
+			}
+			current = nil
+			for _, column := range header[1:] {
+				for _, aggregation := range []string{"individual", "aggregated"} {
+					current = append(current, len(histograms))
+					histograms = append(histograms, requestSizeHistogram{pool: header[0], column: column, aggregation: aggregation, counts: map[uint64]uint64{}})
+				}
+			}
+			continue
+		}
+		size, err := parseHumanSize(fields[0])
+		if current == nil || err != nil || size < 1 {
+			header, current = fields, nil
+			continue
+		}
+		if len(fields) != 1+len(current) {
+			return nil, fmt.Errorf("zpool iostat -r: expected %d columns, got %q", 1+len(current), lines.line)
+		}
+		for i, field := range fields[1:] {
+			v, err := parseHumanSize(field)
+			if err != nil {
+				return nil, fmt.Errorf("zpool iostat -r: invalid count %q", field)
+			}
+			histograms[current[i]].counts[uint64(size)] = uint64(v)
+		}
+	}
+	return histograms, nil
+}
+
+// requestSizeCollector exports the request size histograms of zpool iostat
+// -r, which OpenZFS 0.7 added. caps are the capabilities of the exporter: on
+// releases without -r it is disabled once the pools were probed.
+type requestSizeCollector struct {
+	caps *capabilities
+}
+
+func (requestSizeCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- readRequestSizeDesc
+	ch <- writeRequestSizeDesc
+}
+
+// requestSizeCommand is the zpool iostat -r of pools.
+func requestSizeCommand(pools []zpool) command {
+	return command{"zpool", append([]string{"iostat", "-r", "-p"}, poolNames(pools)...)}
+}
+
+func (requestSizeCollector) plan(pools []zpool) []plannedCommand {
+	return []plannedCommand{{requestSizeCommand(pools), ""}}
+}
+
+func (c requestSizeCollector) collect(r commandRunner, pools []zpool, ch chan<- prometheus.Metric) error {
+	if c.caps.probed && !c.caps.requestSizes {
+		return fmt.Errorf("zpool iostat does not support -r: %w", os.ErrNotExist)
+	}
+	if len(pools) == 0 {
+		return nil
+	}
+	output, err := requestSizeCommand(pools).run(r)
+	if err != nil {
+		return fmt.Errorf("zpool iostat -r: %s", strings.TrimSpace(output))
+	}
+	histograms, err := parseRequestSizes(output)
+	if err != nil {
+		return err
+	}
+	for _, h := range histograms {
+		column, ok := requestSizeColumns[h.column]
+		if !ok {
+			continue
+		}
+		buckets, count, sum := h.buckets()
+		ch <- prometheus.MustNewConstHistogram(column.desc, count, sum, buckets, h.pool, column.class, h.aggregation)
+	}
+	return nil
+}
+
+// probeRequestSizes reports whether zpool iostat supports -r.
+func probeRequestSizes(r commandRunner, pool string) bool {
+	output, err := requestSizeCommand([]zpool{{name: pool}}).run(r)
+	return err == nil && strings.Contains(output, "req_size")
+}
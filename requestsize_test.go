@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestParseRequestSizes(t *testing.T) {
+	b, err := mockFS.ReadFile("mock/zpool-iostat-r.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	histograms, err := parseRequestSizes(string(b))
+	if err != nil {
+		t.Fatalf("Error in parseRequestSizes (%s)", err)
+	}
+	// Seven columns of two histograms for each of the two pools.
+	if len(histograms) != 28 {
+		t.Fatalf("Incorrect number of histograms %d", len(histograms))
+	}
+	h := histograms[0]
+	if h.pool != "tank" || h.column != "sync_read" || h.aggregation != "individual" || len(h.counts) != 16 {
+		t.Errorf("Incorrect first histogram %+v", h)
+	}
+	if h.counts[512] != 3954 || h.counts[4096] != 19327 || h.counts[16777216] != 0 {
+		t.Errorf("Incorrect counts %v", h.counts)
+	}
+	if h := histograms[1]; h.aggregation != "aggregated" || h.counts[512] != 781 {
+		t.Errorf("Incorrect aggregated histogram %+v", h)
+	}
+	if h := histograms[14]; h.pool != "backup" || h.column != "sync_read" {
+		t.Errorf("Incorrect histogram of the second pool %+v", h)
+	}
+}
+
+func TestParseRequestSizesAbbreviated(t *testing.T) {
+	output := "tank        sync_read    sync_write\n" +
+		"req_size    ind    agg    ind    agg\n" +
+		"----------  -----  -----  -----  -----\n" +
+		"512            0      0      3      0\n" +
+		"1K          4.50K     0      0      0\n" +
+		"--------------------------------------\n"
+	histograms, err := parseRequestSizes(output)
+	if err != nil {
+		t.Fatalf("Error in parseRequestSizes (%s)", err)
+	}
+	if len(histograms) != 4 || histograms[0].counts[1024] != 4608 || histograms[2].counts[512] != 3 {
+		t.Errorf("Incorrect histograms %+v", histograms)
+	}
+	if _, err := parseRequestSizes(output + "tank x\nreq_size ind agg\n512 1 2 3\n"); err == nil {
+		t.Errorf("A row with missing columns should be an error")
+	}
+	if _, err := parseRequestSizes("req_size ind agg\n512 0 0\n"); err == nil {
+		t.Errorf("A histogram without a pool header should be an error")
+	}
+}
+
+func TestRequestSizeBuckets(t *testing.T) {
+	h := requestSizeHistogram{counts: map[uint64]uint64{512: 2, 1024: 0, 4096: 3, 2048: 1}}
+	buckets, count, sum := h.buckets()
+	if count != 6 || sum != 2*512+2048+3*4096 {
+		t.Errorf("Incorrect count %d or sum %g", count, sum)
+	}
+	want := map[float64]uint64{1023: 2, 2047: 2, 4095: 3, 8191: 6}
+	if len(buckets) != len(want) {
+		t.Errorf("Incorrect buckets %v, should be %v", buckets, want)
+	}
+	for le, n := range want {
+		if buckets[le] != n {
+			t.Errorf("Incorrect buckets %v, should be %v", buckets, want)
+			break
+		}
+	}
+}
+
+func TestRequestSizeCollector(t *testing.T) {
+	caps := &capabilities{probed: true, requestSizes: true}
+	ch := make(chan prometheus.Metric, 100)
+	err := requestSizeCollector{caps: caps}.collect(mockRunner{}, []zpool{{name: "tank"}}, ch)
+	close(ch)
+	if err != nil {
+		t.Fatalf("Error in collect (%s)", err)
+	}
+	got := map[string]*dto.Histogram{}
+	for m := range ch {
+		var d dto.Metric
+		if err := m.Write(&d); err != nil {
+			t.Fatal(err)
+		}
+		key := descName(m.Desc()) + " " + metricLabel(m, "class") + " " + metricLabel(m, "aggregation")
+		if metricLabel(m, "name") != "tank" {
+			t.Errorf("Unexpected pool in %s", key)
+		}
+		got[key] = d.Histogram
+	}
+	// trim is neither a read nor a write.
+	if len(got) != 12 {
+		t.Errorf("Incorrect number of histograms %d", len(got))
+	}
+	h := got["zpool_read_request_size_bytes sync individual"]
+	if h == nil || len(h.Bucket) != 16 || h.GetSampleCount() != 128507 {
+		t.Fatalf("Incorrect sync read histogram %v", h)
+	}
+	if b := h.Bucket[0]; b.GetUpperBound() != 1023 || b.GetCumulativeCount() != 3954 {
+		t.Errorf("Incorrect first bucket %v", b)
+	}
+	if got["zpool_write_request_size_bytes async aggregated"] == nil {
+		t.Errorf("Missing aggregated async write histogram")
+	}
+
+	unsupported := requestSizeCollector{caps: &capabilities{probed: true}}
+	if err := unsupported.collect(mockRunner{}, []zpool{{name: "tank"}}, make(chan prometheus.Metric, 100)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Without zpool iostat -r the collector should be disabled, got %v", err)
+	}
+}
+
+func TestProbeRequestSizes(t *testing.T) {
+	if !probeRequestSizes(mockRunner{}, "tank") {
+		t.Errorf("Mock zpool iostat should support -r")
+	}
+	r := unsupportedRunner{staticRunner{}}
+	if probeRequestSizes(r, "tank") {
+		t.Errorf("zpool iostat -r should be unsupported when it fails")
+	}
+}
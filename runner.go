@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// commandRunner executes the external zpool/zfs commands the exporter relies
+// on. It is an interface so that tests and benchmarks can substitute canned
+// output for a real ZFS installation.
+type commandRunner interface {
+	// run returns the combined output of the command once it has exited.
+	run(name string, args ...string) (string, error)
+	// start returns the standard output of the command as it is produced,
+	// for output too large to buffer. Closing it waits for the command.
+	start(name string, args ...string) (io.ReadCloser, error)
+}
+
+// command is a command line a collection runs, built by a function that
+// both the collection and its plan for --print-commands use, so that the
+// plan lists what the collection runs.
+type command struct {
+	name string
+	args []string
+}
+
+func (c command) run(r commandRunner) (string, error) {
+	return r.run(c.name, c.args...)
+}
+
+func (c command) start(r commandRunner) (io.ReadCloser, error) {
+	return r.start(c.name, c.args...)
+}
+
+// execRunner runs commands found in PATH. With a wrapper, such as nice -n
+// 10 from -command.nice, it runs the wrapper with the full path of the
+// command and its arguments instead.
+type execRunner struct {
+	wrapper []string
+	// ctx kills the commands once it is done, nil for none; see
+	// withContext.
+	ctx context.Context
+}
+
+func (r execRunner) withContext(ctx context.Context) commandRunner {
+	r.ctx = ctx
+	return r
+}
+
+// command returns the command to run name with args.
+func (r execRunner) command(name string, args []string) (*exec.Cmd, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, commandNotFoundError{name}
+	}
+	var cmd *exec.Cmd
+	if len(r.wrapper) == 0 {
+		cmd = exec.Command(path, args...)
+	} else {
+		words := append([]string{}, r.wrapper[1:]...)
+		words = append(append(words, path), args...)
+		cmd = exec.Command(r.wrapper[0], words...)
+	}
+	if len(commandEnv) > 0 {
+		cmd.Env = append(os.Environ(), commandEnv...)
+	}
+	return cmd, nil
+}
+
+// line returns the command line running name with args, with the name as
+// given when it is not in PATH.
+func (r execRunner) line(name string, args []string) []string {
+	if cmd, err := r.command(name, args); err == nil {
+		return cmd.Args
+	}
+	return append(append(append([]string{}, r.wrapper...), name), args...)
+}
+
+func (r execRunner) run(name string, args ...string) (string, error) {
+	cmd, err := r.command(name, args)
+	if err != nil {
+		return "", err
+	}
+	return runCommand(contextOrBackground(r.ctx), name, cmd)
+}
+
+func (r execRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	cmd, err := r.command(name, args)
+	if err != nil {
+		return nil, err
+	}
+	return startCommand(contextOrBackground(r.ctx), name, cmd)
+}
+
+// commandNotFoundError is the error of a command that is not in PATH. It is
+// an os.ErrNotExist, so that an optional collector needing the command, such
+// as zdb, disables itself.
+type commandNotFoundError struct {
+	name string
+}
+
+func (e commandNotFoundError) Error() string {
+	return fmt.Sprintf("could not find %s in PATH", e.name)
+}
+
+func (commandNotFoundError) Is(target error) bool {
+	return target == os.ErrNotExist
+}
+
+// commandBuckets span the 10ms a zpool list takes on a small pool to the 10s
+// a zfs list of many datasets can take.
+var commandBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// commandMetrics count the commands run through an instrumentedRunner and
+// how long they took, labelled by command, such as "zpool status".
+type commandMetrics struct {
+	executions *prometheus.CounterVec
+	failures   *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+}
+
+func newCommandMetrics() *commandMetrics {
+	return &commandMetrics{
+		executions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "zfs_exporter_command_executions_total",
+			Help: "Number of zpool, zfs and zdb commands run",
+		}, []string{"command"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "zfs_exporter_command_failures_total",
+			Help: "Number of zpool, zfs and zdb commands that could not be started or exited with an error",
+		}, []string{"command"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "zfs_exporter_command_duration_seconds",
+			Help:    "Time zpool, zfs and zdb commands took until they exited",
+			Buckets: commandBuckets,
+		}, []string{"command"}),
+	}
+}
+
+// register registers the command metrics.
+func (m *commandMetrics) register(reg prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{m.executions, m.failures, m.duration} {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// observe records one command started at start.
+func (m *commandMetrics) observe(command string, start time.Time, err error) {
+	m.executions.WithLabelValues(command).Inc()
+	if err != nil {
+		m.failures.WithLabelValues(command).Inc()
+	}
+	m.duration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+}
+
+// commandLabel names a command by the program and its subcommand, such as
+// "zpool status", leaving out the other arguments, which would make a series
+// per pool or dataset.
+func commandLabel(name string, args []string) string {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		return name + " " + args[0]
+	}
+	return name
+}
+
+// instrumentedRunner records the commands it runs in metrics. It is the
+// runner every command of the exporter goes through, so it also refuses to
+// run those checkReadOnly does not allow, whether they come from the plan
+// checked at startup, the probes, the admin API or the pools found later.
+type instrumentedRunner struct {
+	commandRunner
+	metrics *commandMetrics
+}
+
+func (r instrumentedRunner) run(name string, args ...string) (string, error) {
+	if err := checkReadOnly(command{name, args}); err != nil {
+		return err.Error() + "\n", err
+	}
+	start := time.Now()
+	out, err := r.commandRunner.run(name, args...)
+	r.metrics.observe(commandLabel(name, args), start, err)
+	return out, err
+}
+
+func (r instrumentedRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	if err := checkReadOnly(command{name, args}); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	out, err := r.commandRunner.start(name, args...)
+	if err != nil {
+		r.metrics.observe(commandLabel(name, args), start, err)
+		return nil, err
+	}
+	return &instrumentedOutput{ReadCloser: out, done: func(err error) {
+		r.metrics.observe(commandLabel(name, args), start, err)
+	}}, nil
+}
+
+func (r instrumentedRunner) withContext(ctx context.Context) commandRunner {
+	return instrumentedRunner{runnerWithContext(r.commandRunner, ctx), r.metrics}
+}
+
+// instrumentedOutput records a started command once Close waited for it.
+type instrumentedOutput struct {
+	io.ReadCloser
+	done func(err error)
+}
+
+func (o *instrumentedOutput) Close() error {
+	err := o.ReadCloser.Close()
+	o.done(err)
+	return err
+}
+
+// contextRunner is a commandRunner that can kill the commands it runs once
+// a context is done. The runners reading fixtures or files start no
+// commands, so they do not implement it.
+type contextRunner interface {
+	commandRunner
+	// withContext returns the runner with its commands tied to ctx.
+	withContext(ctx context.Context) commandRunner
+}
+
+// runnerWithContext returns r with its commands tied to ctx, or r itself
+// when it starts no commands.
+func runnerWithContext(r commandRunner, ctx context.Context) commandRunner {
+	if c, ok := r.(contextRunner); ok {
+		return c.withContext(ctx)
+	}
+	return r
+}
+
+// contextOrBackground returns ctx, or the background context when it is
+// nil.
+func contextOrBackground(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
+
+// isOffline reports whether r answers from the embedded fixtures of --mock
+// or the files of --status-dir rather than running zpool.
+func isOffline(r commandRunner) bool {
+	for {
+		switch runner := r.(type) {
+		case mockRunner, dirRunner:
+			return true
+		case instrumentedRunner:
+			r = runner.commandRunner
+		case debugRunner:
+			r = runner.commandRunner
+		default:
+			return false
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCommandLabel(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"zpool", []string{"status", "-p", "tank"}, "zpool status"},
+		{"zfs", []string{"list", "-Hp", "-r", "tank"}, "zfs list"},
+		{"zpool", []string{"-V"}, "zpool"},
+		{"zdb", nil, "zdb"},
+	} {
+		if got := commandLabel(test.name, test.args); got != test.want {
+			t.Errorf("Incorrect label of %s %q (%q), should be %q", test.name, test.args, got, test.want)
+		}
+	}
+}
+
+// brokenRunner fails zpool status and the close of any started command.
+type brokenRunner struct{ staticRunner }
+
+func (r brokenRunner) run(name string, args ...string) (string, error) {
+	if commandLabel(name, args) == "zpool status" {
+		return "", errors.New("exit status 1")
+	}
+	return r.staticRunner.run(name, args...)
+}
+
+func (r brokenRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	return failingClose{}, nil
+}
+
+type failingClose struct{ io.Reader }
+
+func (failingClose) Close() error { return errors.New("exit status 2") }
+
+func TestInstrumentedRunner(t *testing.T) {
+	m := newCommandMetrics()
+	r := instrumentedRunner{brokenRunner{staticRunner{}}, m}
+	r.run("zpool", "list", "-Hp", "tank")
+	r.run("zpool", "list", "-Hp", "backup")
+	r.run("zpool", "status", "-p", "tank")
+	out, _ := r.start("zfs", "list", "-Hp", "-r", "tank")
+	if got := testutil.CollectAndCount(m.duration); got != 2 {
+		t.Errorf("Started command should only be recorded once closed, got %d commands", got)
+	}
+	out.Close()
+
+	for command, want := range map[string][2]float64{
+		"zpool list":   {2, 0},
+		"zpool status": {1, 1},
+		"zfs list":     {1, 1},
+	} {
+		executions := testutil.ToFloat64(m.executions.WithLabelValues(command))
+		failures := testutil.ToFloat64(m.failures.WithLabelValues(command))
+		if executions != want[0] || failures != want[1] {
+			t.Errorf("Incorrect counts of %s (%v executions, %v failures), should be %v", command, executions, failures, want)
+		}
+	}
+	if !isOffline(instrumentedRunner{mockRunner{}, m}) || !isOffline(instrumentedRunner{debugRunner{dirRunner{}, &debugState{}}, m}) || isOffline(r) {
+		t.Errorf("isOffline should see through instrumentedRunner and debugRunner")
+	}
+}
+
+// TestInstrumentedRunnerReadOnly checks that the commands checkReadOnly does
+// not allow never reach the runner, however they were built.
+func TestInstrumentedRunnerReadOnly(t *testing.T) {
+	recorder := &recordingRunner{}
+	m := newCommandMetrics()
+	r := instrumentedRunner{recorder, m}
+	r.run("zpool", "list", "-Hp", "tank")
+	for _, c := range []command{
+		{"zpool", []string{"destroy", "tank"}},
+		{"zpool", []string{"list", "-c"}},
+		{"zfs", []string{"set", "readonly=on", "tank"}},
+	} {
+		if _, err := c.run(r); err == nil || !strings.HasPrefix(err.Error(), "refusing to run ") {
+			t.Errorf("%v should be refused, got %v", c, err)
+		}
+		if _, err := c.start(r); err == nil || !strings.HasPrefix(err.Error(), "refusing to run ") {
+			t.Errorf("Started %v should be refused, got %v", c, err)
+		}
+	}
+	if len(recorder.calls) != 1 || testutil.CollectAndCount(m.executions) != 1 {
+		t.Errorf("Only zpool list should have run, got %q", recorder.calls)
+	}
+}
+
+func TestExecRunnerWrapper(t *testing.T) {
+	env, err := exec.LookPath("env")
+	if err != nil {
+		t.Skip("env is not installed")
+	}
+	r := execRunner{wrapper: []string{env, "WRAPPED=yes"}}
+	out, err := r.run("sh", "-c", "echo $WRAPPED $0", "zpool")
+	if err != nil || out != "yes zpool\n" {
+		t.Errorf("Command should run under the wrapper, got %q (%v)", out, err)
+	}
+	if _, err := r.run("nonexistent-zpool"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("A missing command should fail with ErrNotExist under the wrapper, got %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scanStatus is the state of the most recent scrub or resilver, parsed from
+// the scan: section of zpool status.
+type scanStatus struct {
+	function string // "scrub" or "resilver", empty when no scan was ever requested
+	state    string // "finished", "in progress", "paused" or "canceled"
+	end      time.Time
+	rate     float64 // scan rate in bytes per second, negative when not shown
+
+	// Progress of an active scan in bytes, negative when not shown.
+	scanned float64
+	issued  float64
+	total   float64
+
+	result scrubResult // of a finished scan
+}
+
+// scrubResult is what a finished scan repaired and found, from a line such
+// as "scrub repaired 0B in 05:31:07 with 0 errors on Sun Mar  3 05:55:08
+// 2024". Each field is negative when zpool status did not show it.
+type scrubResult struct {
+	duration float64 // seconds
+	repaired float64 // bytes
+	errors   float64
+}
+
+// scanTimeLayout is the layout of the timestamps printed by zpool status,
+// after collapsing runs of spaces.
+const scanTimeLayout = "Mon Jan 2 15:04:05 2006"
+
+// scanSection returns the lines of the scan: section of zpool status output,
+// with the "scan:" prefix removed from the first one.
+func scanSection(output string) []string {
+	return statusSection(output, "scan:")
+}
+
+// statusSection returns the lines of the section of zpool status output
+// starting with key, such as "remove:", with key removed from the first one.
+func statusSection(output, key string) []string {
+	var section []string
+	for lines := newLineScanner(output); lines.scan(); {
+		line := lines.line
+		trimmed := strings.TrimSpace(line)
+		if len(section) == 0 {
+			if strings.HasPrefix(trimmed, key) {
+				section = append(section, strings.TrimSpace(strings.TrimPrefix(trimmed, key)))
+			}
+			continue
+		}
+		if trimmed == "" || isStatusKey(trimmed) {
+			break
+		}
+		section = append(section, trimmed)
+	}
+	return section
+}
+
+// isStatusKey reports whether line starts a new section of zpool status
+// output, such as "config:" or "errors: No known data errors".
+func isStatusKey(line string) bool {
+	key, _, _ := strings.Cut(line, " ")
+	return strings.HasSuffix(key, ":") && !strings.ContainsAny(key, "0123456789")
+}
+
+// parseScanTime parses a zpool status timestamp such as
+// "Thu Jan  1 13:37:00 1970" in local time.
+func parseScanTime(s string) (time.Time, bool) {
+	t, err := time.ParseInLocation(scanTimeLayout, strings.Join(strings.Fields(s), " "), time.Local)
+	return t, err == nil
+}
+
+// parseScanRate finds the scan rate in lines such as
+// "1.09T scanned at 580M/s, 276G issued at 144M/s, 1.55T total",
+// "2.63T / 14.9T scanned at 1.17G/s, 463G / 14.9T issued at 205M/s" or the older
+// "1.09T scanned out of 1.55T at 580M/s, 0h20m to go".
+func parseScanRate(section []string) float64 {
+	for _, line := range section {
+		i := strings.Index(line, "scanned")
+		if i < 0 {
+			continue
+		}
+		rest := line[i:]
+		j := strings.Index(rest, " at ")
+		if j < 0 {
+			continue
+		}
+		fields := strings.Fields(rest[j+len(" at "):])
+		if len(fields) == 0 {
+			continue
+		}
+		rate := strings.TrimSuffix(fields[0], ",")
+		if !strings.HasSuffix(rate, "/s") {
+			continue
+		}
+		if v, err := parseHumanSize(strings.TrimSuffix(rate, "/s")); err == nil {
+			return v
+		}
+	}
+	return -1
+}
+
+// parseScanProgress finds the progress counters of an active scan in lines
+// such as "1.09T scanned at 580M/s, 276G issued at 144M/s, 1.55T total",
+// the "2.63T / 14.9T scanned at 1.17G/s, 463G / 14.9T issued at 205M/s" of
+// OpenZFS 2.2, which gives the total after each counter, and the older
+// "1.09T scanned out of 1.55T at 580M/s, 0h20m to go", which has no issued
+// counter.
+func (s *scanStatus) parseScanProgress(section []string) {
+	for _, line := range section {
+		for _, clause := range strings.Split(line, ", ") {
+			fields := strings.Fields(clause)
+			if len(fields) < 2 {
+				continue
+			}
+			v, err := parseHumanSize(fields[0])
+			if err != nil {
+				continue
+			}
+			if len(fields) >= 4 && fields[1] == "/" {
+				if total, err := parseHumanSize(fields[2]); err == nil {
+					s.total = total
+				}
+				fields = fields[2:]
+			}
+			switch fields[1] {
+			case "scanned":
+				s.scanned = v
+				if len(fields) >= 5 && fields[2] == "out" && fields[3] == "of" {
+					if total, err := parseHumanSize(fields[4]); err == nil {
+						s.total = total
+					}
+				}
+			case "issued":
+				s.issued = v
+			case "total":
+				s.total = v
+			}
+		}
+	}
+}
+
+// parseScan parses the scan: section of zpool status output.
+func parseScan(output string) (s scanStatus) {
+	s.rate, s.scanned, s.issued, s.total = -1, -1, -1, -1
+	section := scanSection(output)
+	if len(section) == 0 || section[0] == "none requested" {
+		return s
+	}
+	first := section[0]
+	switch {
+	case strings.HasPrefix(first, "scrub"):
+		s.function = "scrub"
+	case strings.HasPrefix(first, "resilver"):
+		s.function = "resilver"
+	default:
+		return s
+	}
+	switch {
+	case strings.Contains(first, " in progress since "):
+		s.state = "in progress"
+		s.rate = parseScanRate(section[1:])
+		s.parseScanProgress(section[1:])
+	case strings.Contains(first, " paused since "):
+		s.state = "paused"
+		s.parseScanProgress(section[1:])
+	case strings.Contains(first, " canceled on "):
+		s.state = "canceled"
+		s.end, _ = parseScanTime(first[strings.Index(first, " canceled on ")+len(" canceled on "):])
+	default:
+		s.state = "finished"
+		if i := strings.LastIndex(first, " on "); i >= 0 {
+			s.end, _ = parseScanTime(first[i+len(" on "):])
+			s.result = parseScrubResult(first[:i])
+		}
+	}
+	return s
+}
+
+// parseScrubResult parses the summary of a finished scan, such as "scrub
+// repaired 1.5M in 1 days 02:03:04 with 2 errors", or "scrub repaired 0 in
+// 0h12m with 0 errors" before OpenZFS 0.8.
+func parseScrubResult(summary string) scrubResult {
+	r := scrubResult{duration: -1, repaired: -1, errors: -1}
+	fields := strings.Fields(summary)
+	for i := 0; i+1 < len(fields); i++ {
+		switch fields[i] {
+		case "repaired", "resilvered":
+			if v, err := parseHumanSize(fields[i+1]); err == nil {
+				r.repaired = v
+			}
+		case "in":
+			end := i + 1
+			for end < len(fields) && fields[end] != "with" {
+				end++
+			}
+			if d, ok := parseScanDuration(fields[i+1 : end]); ok {
+				r.duration = d.Seconds()
+			}
+		case "with":
+			if i+2 < len(fields) && fields[i+2] == "errors" {
+				if v, err := strconv.ParseUint(fields[i+1], 10, 64); err == nil {
+					r.errors = float64(v)
+				}
+			}
+		}
+	}
+	return r
+}
+
+// parseScanDuration parses how long a scan took: "05:31:07", "1 days
+// 02:03:04", or "0h12m" before OpenZFS 0.8.
+func parseScanDuration(fields []string) (time.Duration, bool) {
+	var days int64
+	switch {
+	case len(fields) == 3 && fields[1] == "days":
+		n, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil || n < 0 {
+			return 0, false
+		}
+		days, fields = n, fields[2:]
+	case len(fields) != 1:
+		return 0, false
+	}
+	if d, err := time.ParseDuration(fields[0]); err == nil && days == 0 {
+		return d, d >= 0
+	}
+	parts := strings.Split(fields[0], ":")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	d := time.Duration(days) * 24 * time.Hour
+	for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second} {
+		n, err := strconv.ParseUint(parts[i], 10, 32)
+		if err != nil {
+			return 0, false
+		}
+		d += time.Duration(n) * unit
+	}
+	return d, true
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const (
+	gibibyte = 1024 * 1024 * 1024
+	tebibyte = 1024 * gibibyte
+)
+
+const scanFinishedOutput = `  pool: tank
+ state: ONLINE
+  scan: scrub repaired 0B in 00:18:33 with 0 errors on Sun Jun 13 00:42:34 2021
+config:
+
+        NAME        STATE     READ WRITE CKSUM
+        tank        ONLINE       0     0     0
+          sda       ONLINE       0     0     0
+
+errors: No known data errors`
+
+const scanInProgressOutput = `  pool: tank
+ state: ONLINE
+  scan: scrub in progress since Sun Jul 25 16:07:49 2021
+	1.09T scanned at 580M/s, 276G issued at 144M/s, 1.55T total
+	0B repaired, 17.40% done, 02:28:35 to go
+config:
+
+        NAME        STATE     READ WRITE CKSUM
+        tank        ONLINE       0     0     0
+          sda       ONLINE       0     0     0
+
+errors: No known data errors`
+
+const scanPausedOutput = `  pool: tank
+ state: ONLINE
+  scan: scrub paused since Mon Jul 26 08:00:00 2021
+	scrub started on Sun Jul 25 16:07:49 2021
+	1.09T scanned, 276G issued, 1.55T total
+	0B repaired, 17.40% done
+config:
+
+        NAME        STATE     READ WRITE CKSUM
+        tank        ONLINE       0     0     0
+          sda       ONLINE       0     0     0
+
+errors: No known data errors`
+
+// scanLegacyOutput is the scan section as printed before OpenZFS 0.8.
+const scanLegacyOutput = `  pool: tank
+ state: ONLINE
+  scan: scrub in progress since Sun Jul 25 16:07:49 2021
+    1.09T scanned out of 1.55T at 1.5G/s, 0h5m to go
+    0 repaired, 70.32% done
+config:
+
+        NAME        STATE     READ WRITE CKSUM
+        tank        ONLINE       0     0     0
+          sda       ONLINE       0     0     0
+
+errors: No known data errors`
+
+// scanSlashOutput is the scan section as printed since OpenZFS 2.2, with the
+// total after each counter.
+const scanSlashOutput = `  pool: tank
+ state: ONLINE
+  scan: scrub in progress since Sun Jul 25 16:07:49 2021
+	2.63T / 14.9T scanned at 1.17G/s, 463G / 14.9T issued at 205M/s
+	0B repaired, 3.03% done, 20:13:55 to go
+config:
+
+        NAME        STATE     READ WRITE CKSUM
+        tank        ONLINE       0     0     0
+          sda       ONLINE       0     0     0
+
+errors: No known data errors`
+
+// scanSlashPausedOutput is a paused scrub as printed since OpenZFS 2.2.
+const scanSlashPausedOutput = `  pool: tank
+ state: ONLINE
+  scan: scrub paused since Mon Jul 26 08:00:00 2021
+	scrub started on Sun Jul 25 16:07:49 2021
+	2.63T / 14.9T scanned, 463G / 14.9T issued
+	0B repaired, 3.03% done
+config:
+
+errors: No known data errors`
+
+// scanStartedOutput is shown right after a scrub is started, before zpool
+// has a rate to report.
+const scanStartedOutput = `  pool: tank
+ state: ONLINE
+  scan: scrub in progress since Sun Jul 25 16:07:49 2021
+	0B scanned, 0B issued, 1.55T total
+	0B repaired, 0.00% done, no estimated completion time
+config:
+
+errors: No known data errors`
+
+const scanNoneOutput = `  pool: tank
+ state: ONLINE
+  scan: none requested
+config:
+
+        NAME        STATE     READ WRITE CKSUM
+        tank        ONLINE       0     0     0
+          sda       ONLINE       0     0     0
+
+errors: No known data errors`
+
+const scanResilveredOutput = `  pool: tank
+ state: ONLINE
+  scan: resilvered 1.21T in 04:11:23 with 0 errors on Mon Jul 26 03:01:02 2021
+config:
+
+        NAME        STATE     READ WRITE CKSUM
+        tank        ONLINE       0     0     0
+          sda       ONLINE       0     0     0
+
+errors: No known data errors`
+
+// scanCanceledOutput is shown after zpool scrub -s stopped a scrub.
+const scanCanceledOutput = `  pool: tank
+ state: ONLINE
+  scan: scrub canceled on Mon Jul 26 09:15:00 2021
+config:
+
+        NAME        STATE     READ WRITE CKSUM
+        tank        ONLINE       0     0     0
+          sda       ONLINE       0     0     0
+
+errors: No known data errors`
+
+const scanResilverCanceledOutput = `  pool: tank
+ state: ONLINE
+  scan: resilver canceled on Tue Jul 27 11:30:00 2021
+config:
+
+errors: No known data errors`
+
+func TestParseScan(t *testing.T) {
+	s := parseScan(scanFinishedOutput)
+	if s.function != "scrub" || s.state != "finished" {
+		t.Errorf("Incorrect scan %+v, should be a finished scrub", s)
+	}
+	if want := time.Date(2021, 6, 13, 0, 42, 34, 0, time.Local); !s.end.Equal(want) {
+		t.Errorf("Incorrect scan end %s, should be %s", s.end, want)
+	}
+
+	// The old single digit day format from the getProviders fixtures
+	s = parseScan("  scan: scrub repaired 0 in 1h1m with 0 errors on Thu Jan 1 13:37:00 1970\n")
+	if want := time.Date(1970, 1, 1, 13, 37, 0, 0, time.Local); !s.end.Equal(want) {
+		t.Errorf("Incorrect scan end %s, should be %s", s.end, want)
+	}
+
+	s = parseScan(scanInProgressOutput)
+	if s.function != "scrub" || s.state != "in progress" || !s.end.IsZero() {
+		t.Errorf("Incorrect scan %+v, should be a scrub in progress", s)
+	}
+
+	if s.rate != 580*1024*1024 {
+		t.Errorf("Incorrect scan rate %v, should be 580M", s.rate)
+	}
+
+	if s.scanned != 1.09*tebibyte || s.issued != 276*gibibyte || s.total != 1.55*tebibyte {
+		t.Errorf("Incorrect scan progress %+v", s)
+	}
+
+	s = parseScan(scanLegacyOutput)
+	if s.rate != 1.5*1024*1024*1024 {
+		t.Errorf("Incorrect legacy scan rate %v, should be 1.5G", s.rate)
+	}
+	if s.scanned != 1.09*tebibyte || s.issued >= 0 || s.total != 1.55*tebibyte {
+		t.Errorf("Incorrect legacy scan progress %+v", s)
+	}
+
+	s = parseScan(scanSlashOutput)
+	if s.rate != 1.17*gibibyte {
+		t.Errorf("Incorrect OpenZFS 2.2 scan rate %v, should be 1.17G", s.rate)
+	}
+	if s.scanned != 2.63*tebibyte || s.issued != 463*gibibyte || s.total != 14.9*tebibyte {
+		t.Errorf("Incorrect OpenZFS 2.2 scan progress %+v", s)
+	}
+	s = parseScan(scanSlashPausedOutput)
+	if s.state != "paused" || s.scanned != 2.63*tebibyte || s.issued != 463*gibibyte || s.total != 14.9*tebibyte {
+		t.Errorf("Incorrect paused OpenZFS 2.2 scan progress %+v", s)
+	}
+
+	s = parseScan(scanStartedOutput)
+	if s.state != "in progress" || s.rate >= 0 {
+		t.Errorf("Incorrect scan %+v, should be in progress without a rate", s)
+	}
+
+	s = parseScan(scanPausedOutput)
+	if s.function != "scrub" || s.state != "paused" || s.rate >= 0 {
+		t.Errorf("Incorrect scan %+v, should be a paused scrub without a rate", s)
+	}
+	if s.scanned != 1.09*tebibyte || s.issued != 276*gibibyte || s.total != 1.55*tebibyte {
+		t.Errorf("Incorrect paused scan progress %+v", s)
+	}
+
+	s = parseScan(scanCanceledOutput)
+	if want := time.Date(2021, 7, 26, 9, 15, 0, 0, time.Local); s.function != "scrub" || s.state != "canceled" || !s.end.Equal(want) {
+		t.Errorf("Incorrect scan %+v, should be a scrub canceled on %s", s, want)
+	}
+	if s.result != (scrubResult{}) {
+		t.Errorf("Canceled scan should have no result, got %+v", s.result)
+	}
+	s = parseScan(scanResilverCanceledOutput)
+	if s.function != "resilver" || s.state != "canceled" || s.end.IsZero() {
+		t.Errorf("Incorrect scan %+v, should be a canceled resilver", s)
+	}
+
+	s = parseScan(scanFinishedOutput)
+	if s.scanned >= 0 || s.issued >= 0 || s.total >= 0 {
+		t.Errorf("Finished scan should have no progress %+v", s)
+	}
+
+	s = parseScan(scanNoneOutput)
+	if s.function != "" || s.rate >= 0 {
+		t.Errorf("Incorrect scan %+v, should be none", s)
+	}
+}
+
+func TestLastScrub(t *testing.T) {
+	z := zpool{name: "tank"}
+	z.setScan(scanNoneOutput)
+	if !z.neverScrubbed() || !z.lastScrub.IsZero() {
+		t.Errorf("Pool with no scan requested should be never scrubbed")
+	}
+
+	z.setScan(scanFinishedOutput)
+	if z.neverScrubbed() || z.lastScrub.IsZero() {
+		t.Errorf("Pool with a finished scrub should have a last scrub")
+	}
+	last := z.lastScrub
+
+	// A resilver or a scrub in progress replaces the scan line but the
+	// previous scrub is remembered.
+	z.setScan(scanResilveredOutput)
+	z.setScan(scanInProgressOutput)
+	if !z.lastScrub.Equal(last) {
+		t.Errorf("Last scrub should be kept (%s), got %s", last, z.lastScrub)
+	}
+	if want := time.Date(2021, 7, 26, 3, 1, 2, 0, time.Local); !z.lastScan.Equal(want) || z.scanCanceled {
+		t.Errorf("Last scan should be the finished resilver (%s, %t), should be %s", z.lastScan, z.scanCanceled, want)
+	}
+
+	// A canceled scrub is an attempt, but not the last scrub.
+	z.setScan(scanCanceledOutput)
+	if !z.lastScrub.Equal(last) {
+		t.Errorf("A canceled scrub should keep the last scrub (%s), got %s", last, z.lastScrub)
+	}
+	if want := time.Date(2021, 7, 26, 9, 15, 0, 0, time.Local); !z.lastScan.Equal(want) || !z.scanCanceled {
+		t.Errorf("Last scan should be the canceled scrub (%s, %t), should be %s", z.lastScan, z.scanCanceled, want)
+	}
+	z.setScan(scanResilverCanceledOutput)
+	z.setScan(scanInProgressOutput)
+	if want := time.Date(2021, 7, 27, 11, 30, 0, 0, time.Local); !z.lastScan.Equal(want) || !z.scanCanceled {
+		t.Errorf("Last scan should be the canceled resilver while a scrub runs (%s, %t), should be %s", z.lastScan, z.scanCanceled, want)
+	}
+}
+
+func TestParseScrubResult(t *testing.T) {
+	for summary, want := range map[string]scrubResult{
+		"scrub repaired 0B in 00:18:33 with 0 errors":           {1113, 0, 0},
+		"scrub repaired 1.50M in 1 days 02:03:04 with 2 errors": {93784, 1.5 * 1024 * 1024, 2},
+		"scrub repaired 0 in 0h12m with 0 errors":               {720, 0, 0},
+		"scrub repaired 4096 in 00:00:01 with 1 errors":         {1, 4096, 1},
+		"scrub repaired 0B with 0 errors":                       {-1, 0, 0},
+		"scrub canceled":                                        {-1, -1, -1},
+	} {
+		if got := parseScrubResult(summary); got != want {
+			t.Errorf("Incorrect result of %q (%+v), should be %+v", summary, got, want)
+		}
+	}
+	if s := parseScan(scanFinishedOutput); s.result != (scrubResult{1113, 0, 0}) {
+		t.Errorf("Finished scan should have a result, got %+v", s.result)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scrapeBuckets span a scrape of a few pools served from the status cache
+// to one waiting for a zfs list of many datasets.
+var scrapeBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// scrapeRequests are the scrapes the metrics handler is serving. Each gets
+// an ID, which its debug logs carry and which is attached as an exemplar to
+// zfs_exporter_scrape_duration_seconds in OpenMetrics output. A collection
+// started while scrapes are served is canceled, killing the commands it
+// runs, once the client of every one of them disconnected, so that a
+// scraper timing out does not leave zpool commands running for no one. A
+// collection started while no scrape is served, as for remote write, is
+// never canceled.
+type scrapeRequests struct {
+	duration prometheus.Histogram
+	canceled prometheus.Counter
+
+	mu        sync.Mutex
+	next      uint64
+	connected map[string]bool // the IDs of the scrapes whose client is connected
+	cancel    context.CancelFunc
+}
+
+func newScrapeRequests() *scrapeRequests {
+	return &scrapeRequests{
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "zfs_exporter_scrape_duration_seconds",
+			Help:    "Time the metrics endpoint took to serve a scrape, with the ID of the scrape as an exemplar",
+			Buckets: scrapeBuckets,
+		}),
+		canceled: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "zfs_exporter_scrapes_canceled_total",
+			Help: "Number of collections canceled because the client of every scrape waiting for them disconnected, with the ID of the last one as an exemplar",
+		}),
+		connected: map[string]bool{},
+	}
+}
+
+// register registers the scrape metrics.
+func (t *scrapeRequests) register(reg prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{t.duration, t.canceled} {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// track serves each scrape with h as one of t.
+func (t *scrapeRequests) track(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := t.begin()
+		debugf("Scrape %s: %s %s from %s", id, r.Method, r.URL.RequestURI(), r.RemoteAddr)
+		ctx := r.Context()
+		stop := context.AfterFunc(ctx, func() { t.disconnected(id) })
+		h.ServeHTTP(w, r.WithContext(withScrapeIDs(ctx, []string{id})))
+		if stop() {
+			t.end(id)
+		}
+		elapsed := time.Since(start)
+		t.duration.(prometheus.ExemplarObserver).ObserveWithExemplar(elapsed.Seconds(), prometheus.Labels{"scrape_id": id})
+		debugf("Scrape %s: served in %s", id, elapsed)
+	})
+}
+
+// begin adds a scrape and returns its ID.
+func (t *scrapeRequests) begin() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.next++
+	id := strconv.FormatUint(t.next, 10)
+	t.connected[id] = true
+	return id
+}
+
+// end removes the scrape id, which was served.
+func (t *scrapeRequests) end(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.connected, id)
+}
+
+// disconnected removes the scrape id, whose client went away before it was
+// served, and cancels the collection once no client is left waiting.
+func (t *scrapeRequests) disconnected(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.connected, id)
+	if len(t.connected) > 0 || t.cancel == nil {
+		return
+	}
+	debugf("Scrape %s: the client disconnected, canceling the collection", id)
+	t.cancel()
+	t.cancel = nil
+	t.canceled.(prometheus.ExemplarAdder).AddWithExemplar(1, prometheus.Labels{"scrape_id": id})
+}
+
+// collection returns the context of a new collection, canceled once every
+// scrape connected now or later disconnected, and the function to call when
+// it is done. Only one collection runs at a time.
+func (t *scrapeRequests) collection() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.connected) == 0 {
+		return ctx, cancel
+	}
+	ids := make([]string, 0, len(t.connected))
+	for id := range t.connected {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	t.cancel = cancel
+	return withScrapeIDs(ctx, ids), func() {
+		t.mu.Lock()
+		t.cancel = nil
+		t.mu.Unlock()
+		cancel()
+	}
+}
+
+type scrapeIDsKey struct{}
+
+// withScrapeIDs returns ctx carrying the IDs of the scrapes it serves.
+func withScrapeIDs(ctx context.Context, ids []string) context.Context {
+	return context.WithValue(ctx, scrapeIDsKey{}, ids)
+}
+
+// scrapeIDs returns the IDs of the scrapes ctx serves, such as "3,4", or
+// "none" for a collection not started for a scrape.
+func scrapeIDs(ctx context.Context) string {
+	ids, _ := ctx.Value(scrapeIDsKey{}).([]string)
+	if len(ids) == 0 {
+		return "none"
+	}
+	return strings.Join(ids, ",")
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// sleepingRunner answers like the mock runner, and runs sleep with ctx. With
+// list set, the zpool list of the pool collector runs sleep first like a
+// hung zpool, signaling list.started and sending its error to list.done.
+type sleepingRunner struct {
+	mockRunner
+	ctx  context.Context
+	list *sleepCollector
+}
+
+func (r sleepingRunner) run(name string, args ...string) (string, error) {
+	switch {
+	case name == "sleep":
+		return execRunner{ctx: r.ctx}.run(name, args...)
+	case r.list != nil && name == "zpool" && len(args) > 1 && args[0] == "list" && args[1] == "-Hp":
+		if err := r.list.collect(r, nil, nil); err != nil {
+			return "", err
+		}
+	}
+	return r.mockRunner.run(name, args...)
+}
+
+func (r sleepingRunner) withContext(ctx context.Context) commandRunner {
+	r.ctx = ctx
+	return r
+}
+
+// sleepCollector runs sleep, signaling started first and sending the error
+// of sleep to done.
+type sleepCollector struct {
+	started, done chan error
+}
+
+func (sleepCollector) describe(ch chan<- *prometheus.Desc) {}
+
+func (c sleepCollector) collect(r commandRunner, pools []zpool, ch chan<- prometheus.Metric) error {
+	c.started <- nil
+	_, err := r.run("sleep", "30")
+	c.done <- err
+	return err
+}
+
+// TestScrapeDisconnect checks that the commands of a collection are killed
+// once its only client disconnected, those of an optional collector as
+// well as the zpool list of the pool collector, and that a disconnect leaves
+// the exporter as it was, rather than making it fail.
+func TestScrapeDisconnect(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep is not installed")
+	}
+	for _, hang := range []string{"collector", "zpool list"} {
+		t.Run(hang, func(t *testing.T) {
+			withChildLimits(t, 4, time.Minute)
+			e := newMockExporter(t)
+			e.snapshot(nil)
+			if atomic.LoadInt32(&e.ready) != 1 || !e.available {
+				t.Fatalf("The mock exporter should be ready after its first collection")
+			}
+			problems := e.problems.Load()
+			c := sleepCollector{started: make(chan error, 1), done: make(chan error, 1)}
+			if hang == "collector" {
+				e.runner = sleepingRunner{}
+				e.addCollector("sleep", c)
+			} else {
+				e.runner = sleepingRunner{list: &c}
+			}
+			reg := prometheus.NewRegistry()
+			reg.MustRegister(e)
+			if err := e.requests.register(reg); err != nil {
+				t.Fatal(err)
+			}
+			server := httptest.NewServer(e.requests.track(metricsHandler(reg, reg, nil)))
+			defer server.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+			client := make(chan error, 1)
+			go func() {
+				_, err := http.DefaultClient.Do(req)
+				client <- err
+			}()
+			select {
+			case <-c.started:
+			case <-time.After(10 * time.Second):
+				t.Fatal("The collection did not start")
+			}
+			cancel()
+			<-client
+			select {
+			case err := <-c.done:
+				var canceled *commandCanceledError
+				if !errors.As(err, &canceled) || !errors.Is(err, context.Canceled) {
+					t.Errorf("sleep should be canceled, got %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("sleep was not killed when the client disconnected")
+			}
+			// The collection returns once sleep was reaped, and the handler
+			// once the collection returned.
+			for deadline := time.Now().Add(5 * time.Second); children.count() != 0 || e.collectingSince() != (time.Time{}); time.Sleep(10 * time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatalf("%d commands are left after the disconnect", children.count())
+				}
+			}
+
+			var canceled dto.Metric
+			e.requests.canceled.Write(&canceled)
+			if got := canceled.GetCounter(); got.GetValue() != 1 || got.GetExemplar() == nil {
+				t.Errorf("The canceled collection should be counted with an exemplar, got %v", got)
+			}
+			select {
+			case err := <-e.fatal:
+				t.Errorf("A disconnect should not stop the exporter, got %s", err)
+			default:
+			}
+			if atomic.LoadInt32(&e.ready) != 1 || !e.available || !reflect.DeepEqual(e.problems.Load(), problems) {
+				t.Errorf("A disconnect should leave the exporter ready and available with its problems, got ready %d, available %v and %v", atomic.LoadInt32(&e.ready), e.available, e.problems.Load())
+			}
+		})
+	}
+}
+
+func TestScrapeCollection(t *testing.T) {
+	requests := newScrapeRequests()
+	ctx, done := requests.collection()
+	if got := scrapeIDs(ctx); got != "none" {
+		t.Errorf("A collection without scrapes should have no IDs, got %q", got)
+	}
+	done()
+
+	// A collection is canceled once every scrape waiting for it
+	// disconnected, only those connected when it started or later.
+	first, second := requests.begin(), requests.begin()
+	ctx, done = requests.collection()
+	defer done()
+	if got := scrapeIDs(ctx); got != first+","+second {
+		t.Errorf("Incorrect scrape IDs %q", got)
+	}
+	third := requests.begin()
+	requests.disconnected(first)
+	requests.end(second)
+	if ctx.Err() != nil {
+		t.Fatalf("The collection should run while %s is connected", third)
+	}
+	requests.disconnected(third)
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		t.Errorf("The collection should be canceled once every client disconnected")
+	}
+}
+
+func TestScrapeDurationExemplar(t *testing.T) {
+	requests := newScrapeRequests()
+	reg := prometheus.NewRegistry()
+	if err := requests.register(reg); err != nil {
+		t.Fatal(err)
+	}
+	handler := requests.track(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(scrapeIDs(r.Context())))
+	}))
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if got := w.Body.String(); got != []string{"1", "2"}[i] {
+			t.Errorf("Incorrect scrape ID %q", got)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	r.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	metricsHandler(reg, reg, nil).ServeHTTP(w, r)
+	if body := w.Body.String(); !strings.Contains(body, `zfs_exporter_scrape_duration_seconds_count 2`) || !strings.Contains(body, `# {scrape_id="2"}`) {
+		t.Errorf("The scrape duration should have the ID of the last scrape as an exemplar, got\n%s", body)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// scrubStateVersion is the version of the --scrub.state-file format.
+const scrubStateVersion = 1
+
+// scrubStateFile is the JSON --scrub.state-file holds.
+type scrubStateFile struct {
+	Version int                   `json:"version"`
+	Pools   map[string]savedScrub `json:"pools"`
+}
+
+// savedScrub is the last finished scrub of a pool, with -1 for what zpool
+// status did not show, as in scrubResult.
+type savedScrub struct {
+	End      time.Time `json:"end"`
+	Duration float64   `json:"duration_seconds"`
+	Repaired float64   `json:"repaired_bytes"`
+	Errors   float64   `json:"errors"`
+}
+
+// scrubState keeps the last finished scrub of every monitored pool in a
+// file, so that its metrics survive a restart of the exporter even when
+// zpool status no longer shows it, such as after a resilver or an export.
+// It is only used by the collection.
+type scrubState struct {
+	path  string
+	saved map[string]savedScrub // what the file holds
+}
+
+// loadScrubState reads the scrubs saved at path into the pools that have not
+// seen a newer one. A missing file is a first start; a file that cannot be
+// read or parsed is logged and replaced by the next save.
+func loadScrubState(path string, pools []zpool) *scrubState {
+	s := &scrubState{path: path, saved: map[string]savedScrub{}}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s
+	}
+	var f scrubStateFile
+	if err == nil {
+		if err = json.Unmarshal(b, &f); err == nil && f.Version != scrubStateVersion {
+			err = fmt.Errorf("unknown version %d", f.Version)
+		}
+	}
+	if err != nil {
+		logf(nil, "Warning: ignoring the scrub state file %s: %s", path, err)
+		return s
+	}
+	for i := range pools {
+		z := &pools[i]
+		saved, ok := f.Pools[z.name]
+		if !ok || saved.End.IsZero() {
+			continue
+		}
+		s.saved[z.name] = saved
+		if saved.End.After(z.lastScrub) {
+			z.lastScrub = saved.End
+			z.scrubResult = scrubResult{duration: saved.Duration, repaired: saved.Repaired, errors: saved.Errors}
+		}
+	}
+	return s
+}
+
+// save writes the last scrub of pools to the file when one changed. It is
+// written to a temporary file that replaces it, so that a crash never
+// leaves it half written. Pools without a known scrub and pools no longer
+// monitored are left out.
+func (s *scrubState) save(pools []zpool) error {
+	scrubs := map[string]savedScrub{}
+	for _, z := range pools {
+		if !z.lastScrub.IsZero() {
+			r := z.scrubResult
+			scrubs[z.name] = savedScrub{End: z.lastScrub, Duration: r.duration, Repaired: r.repaired, Errors: r.errors}
+		}
+	}
+	if len(scrubs) == len(s.saved) {
+		changed := false
+		for name, scrub := range scrubs {
+			if saved, ok := s.saved[name]; !ok || !saved.End.Equal(scrub.End) || saved.Duration != scrub.Duration ||
+				saved.Repaired != scrub.Repaired || saved.Errors != scrub.Errors {
+				changed = true
+			}
+		}
+		if !changed {
+			return nil
+		}
+	}
+	b, err := json.MarshalIndent(scrubStateFile{Version: scrubStateVersion, Pools: scrubs}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(append(b, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	s.saved = scrubs
+	return nil
+}
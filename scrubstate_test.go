@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScrubState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scrubs.json")
+	pools := []zpool{{name: "tank"}, {name: "backup"}}
+	s := loadScrubState(path, pools)
+	if !pools[0].lastScrub.IsZero() {
+		t.Errorf("Missing state file should load no scrubs, got %s", pools[0].lastScrub)
+	}
+	pools[0].setScan(scanFinishedOutput)
+	if err := s.save(pools); err != nil {
+		t.Fatalf("Error in save (%s)", err)
+	}
+
+	// After a restart the scrub is loaded, and kept while zpool status
+	// shows a resilver instead.
+	restarted := []zpool{{name: "tank"}, {name: "backup"}}
+	s = loadScrubState(path, restarted)
+	restarted[0].setScan(scanResilveredOutput)
+	if !restarted[0].lastScrub.Equal(pools[0].lastScrub) || restarted[0].scrubResult != pools[0].scrubResult {
+		t.Errorf("Incorrect loaded scrub %s %+v, should be %s %+v",
+			restarted[0].lastScrub, restarted[0].scrubResult, pools[0].lastScrub, pools[0].scrubResult)
+	}
+	if !restarted[1].lastScrub.IsZero() {
+		t.Errorf("Pool without a saved scrub should have none, got %s", restarted[1].lastScrub)
+	}
+
+	// A newer scrub replaces the loaded one, and is saved.
+	restarted[0].lastScrub = restarted[0].lastScrub.Add(-time.Hour)
+	restarted[0].setScan(scanFinishedOutput)
+	if restarted[0].lastScrub.Equal(pools[0].lastScrub.Add(-time.Hour)) {
+		t.Errorf("Newer scrub should replace the loaded one")
+	}
+	restarted[0].scrubResult.errors = 3
+	if err := s.save(restarted); err != nil {
+		t.Fatalf("Error in save (%s)", err)
+	}
+	again := []zpool{{name: "tank"}}
+	loadScrubState(path, again)
+	if again[0].scrubResult.errors != 3 {
+		t.Errorf("Changed scrub should be saved, got %+v", again[0].scrubResult)
+	}
+}
+
+func TestScrubStateCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scrubs.json")
+	for _, content := range []string{"{not json", `{"version": 99, "pools": {"tank": {"end": "2024-03-03T05:55:08Z"}}}`} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		pools := []zpool{{name: "tank"}}
+		s := loadScrubState(path, pools)
+		if !pools[0].lastScrub.IsZero() {
+			t.Errorf("Corrupt state file %q should be ignored, got %s", content, pools[0].lastScrub)
+		}
+		pools[0].setScan(scanFinishedOutput)
+		if err := s.save(pools); err != nil {
+			t.Errorf("Corrupt state file should be replaced, got %s", err)
+		}
+	}
+	matches, _ := filepath.Glob(path + ".tmp*")
+	if len(matches) != 0 {
+		t.Errorf("Saving should not leave temporary files, found %v", matches)
+	}
+}
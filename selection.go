@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectParam is the query parameter of the metrics endpoint that selects
+// the collectors a scrape runs, as in node_exporter:
+// /metrics?collect[]=pool&collect[]=arc. Without it every collector runs.
+const collectParam = "collect[]"
+
+// collectorSelection names the collectors a collection runs, by the names of
+// zfs_exporter_collector_success. nil selects all of them.
+type collectorSelection map[string]bool
+
+func (s collectorSelection) has(name string) bool {
+	return s == nil || s[name]
+}
+
+// key identifies the selection, so that scrapes selecting the same
+// collectors can share a collection.
+func (s collectorSelection) key() string {
+	if s == nil {
+		return ""
+	}
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// collectorNames returns the names collectParam accepts: pool, unless the
+// pool collector is disabled, and the optional collectors that were enabled.
+func (e *Exporter) collectorNames() []string {
+	var names []string
+	if e.pools != nil {
+		names = append(names, "pool")
+	}
+	for _, c := range e.collectors {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// selectCollectors returns the selection of the collectors named by
+// collectParam parameters, or an error listing the valid names when one is
+// unknown.
+func (e *Exporter) selectCollectors(names []string) (collectorSelection, error) {
+	valid := e.collectorNames()
+	selection := collectorSelection{}
+	for _, name := range names {
+		if !stringInSlice(name, valid) {
+			return nil, fmt.Errorf("unknown collector %q in %s, valid collectors are %s", name, collectParam, strings.Join(valid, ", "))
+		}
+		selection[name] = true
+	}
+	return selection, nil
+}
+
+// selectedExporter collects only the selected collectors of the exporter,
+// for a scrape with collectParam parameters. The unselected ones run no
+// commands; the optional collectors see the pools as the last collection of
+// the pool collector left them.
+type selectedExporter struct {
+	*Exporter
+	selection collectorSelection
+}
+
+func (s selectedExporter) Collect(ch chan<- prometheus.Metric) {
+	s.Exporter.collectSelection(ch, s.selection)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSelectCollectors(t *testing.T) {
+	e := NewExporter(&[]zpool{{name: "tank"}})
+	e.addCollector("arc", newARCCollector())
+	e.addCollector("datasets", newDatasetCollector(datasetOptions{maxDepth: -1}))
+	selection, err := e.selectCollectors([]string{"pool", "arc", "pool"})
+	if err != nil || !selection.has("pool") || !selection.has("arc") || selection.has("datasets") {
+		t.Errorf("Incorrect selection %v (%v)", selection, err)
+	}
+	if got := selection.key(); got != "arc,pool" {
+		t.Errorf("Incorrect selection key %q", got)
+	}
+	if !collectorSelection(nil).has("datasets") || collectorSelection(nil).key() != "" {
+		t.Errorf("The nil selection should select every collector")
+	}
+	_, err = e.selectCollectors([]string{"pool", "snapshots"})
+	if err == nil || !strings.Contains(err.Error(), "pool, arc, datasets") {
+		t.Errorf("An unknown collector should fail listing the valid ones, got %v", err)
+	}
+}
+
+func TestCollectSelection(t *testing.T) {
+	e := newMockExporter(t)
+	e.debug = &debugState{}
+	e.runner = debugRunner{e.runner, e.debug}
+	e.snapshot(nil)
+	gather := func(selection collectorSelection) (map[string]bool, []debugCommand) {
+		e.debug.commands = nil
+		got := map[string]bool{}
+		for _, m := range e.snapshot(selection) {
+			got[descName(m.Desc())] = true
+		}
+		return got, e.debug.commands
+	}
+
+	got, commands := gather(collectorSelection{"arc": true})
+	if len(commands) != 0 {
+		t.Errorf("Only selecting arc should run no commands, ran %+v", commands)
+	}
+	if !got["zfs_arc_size_bytes"] || got["zpool_up"] || got["zfs_dataset_used_bytes"] {
+		t.Errorf("Only selecting arc should only export the ARC metrics, got %v", got)
+	}
+
+	got, commands = gather(collectorSelection{"pool": true})
+	for _, c := range commands {
+		if !strings.HasPrefix(c.Command, "zpool ") {
+			t.Errorf("Only selecting pool should only run zpool, ran %s", c.Command)
+		}
+	}
+	if !got["zpool_up"] || got["zfs_arc_size_bytes"] || got["zfs_dataset_used_bytes"] {
+		t.Errorf("Only selecting pool should only export the pool metrics, got %v", got)
+	}
+}
+
+func TestMetricsHandlerSelection(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	all := prometheus.NewGauge(prometheus.GaugeOpts{Name: "all", Help: "h"})
+	reg.MustRegister(all)
+	selectedReg := prometheus.NewRegistry()
+	selectedReg.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "selected", Help: "h"}))
+	var names []string
+	selected := func(n []string) (prometheus.Gatherer, error) {
+		names = n
+		if n[0] == "unknown" {
+			return nil, errors.New("unknown collector \"unknown\" in collect[], valid collectors are pool, arc")
+		}
+		return selectedReg, nil
+	}
+	h := metricsHandler(prometheus.NewRegistry(), reg, selected)
+	serve := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	if w := serve("/metrics"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "\nall 0") || names != nil {
+		t.Errorf("A scrape without collect[] should serve all metrics, got %d %s", w.Code, w.Body)
+	}
+	w := serve("/metrics?collect[]=pool&collect[]=arc")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "\nselected 0") || strings.Contains(w.Body.String(), "\nall 0") {
+		t.Errorf("A scrape with collect[] should serve the selected metrics, got %d %s", w.Code, w.Body)
+	}
+	if strings.Join(names, ",") != "pool,arc" {
+		t.Errorf("Incorrect selected collectors %v", names)
+	}
+	if w := serve("/metrics?collect[]=unknown"); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "valid collectors are pool, arc") {
+		t.Errorf("An unknown collector should answer 400 with the valid ones, got %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+)
+
+// deviceSlowIOs is the SLOW column of one leaf device in zpool status -s:
+// the number of I/Os that took longer than zio_slow_io_ms.
+type deviceSlowIOs struct {
+	device        string
+	guid          string // only with poolOptions.guids
+	slow          float64
+	enclosureSlot // only with poolOptions.enclosures
+}
+
+// vdevGroupPrefixes name the interior vdevs of the zpool status config
+// section, whose counters cover their children.
+var vdevGroupPrefixes = []string{"mirror-", "raidz", "draid", "replacing-", "spare-"}
+
+// probeSlowIOs reports whether zpool status supports -s, which OpenZFS added
+// in 0.8. It is run once when the pools are set up.
+func probeSlowIOs(r commandRunner, pool string) bool {
+	output, err := slowIOsProbe(pool).run(r)
+	if err != nil {
+		return false
+	}
+	for lines := newLineScanner(output); lines.scan(); {
+		line := lines.line
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == "NAME" {
+			return stringInSlice("SLOW", fields)
+		}
+	}
+	return false
+}
+
+// parseSlowIOs returns the SLOW column of the leaf devices in the config
+// section of zpool status -s output. Sizes such as "1.2K" are expanded.
+func parseSlowIOs(output, pool string) []deviceSlowIOs {
+	p := slowIOParser{pool: pool, column: -1}
+	for lines := newLineScanner(output); lines.scan(); {
+		p.line(strings.Fields(lines.line))
+	}
+	return p.devices
+}
+
+// slowIOParser is parseSlowIOs fed the fields of one line at a time, as
+// zpool status prints them.
+type slowIOParser struct {
+	pool    string
+	column  int // of SLOW, -1 until the NAME header
+	done    bool
+	devices []deviceSlowIOs
+}
+
+func (p *slowIOParser) line(fields []string) {
+	if p.done {
+		return
+	}
+	if p.column < 0 {
+		if len(fields) > 0 && fields[0] == "NAME" {
+			for i, f := range fields {
+				if f == "SLOW" {
+					p.column = i
+				}
+			}
+		}
+		return
+	}
+	if len(fields) == 0 {
+		p.done = true // end of the config section
+		return
+	}
+	name := fields[0]
+	if len(fields) <= p.column || name == p.pool || hasAnyPrefix(name, vdevGroupPrefixes) {
+		return // class headings, spares and interior vdevs
+	}
+	slow, err := parseHumanSize(fields[p.column])
+	if err != nil {
+		return
+	}
+	p.devices = append(p.devices, deviceSlowIOs{device: name, slow: slow})
+}
@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+const slowIOsOutput = `  pool: tank
+ state: ONLINE
+  scan: scrub repaired 0B in 00:18:33 with 0 errors on Sun Jun 13 00:42:34 2021
+config:
+
+        NAME        STATE     READ WRITE CKSUM  SLOW
+        tank        ONLINE       0     0     0     -
+          mirror-0  ONLINE       0     0     0     -
+            sda     ONLINE       0     0     0     0
+            sdb     ONLINE       0     0     0  1.2K
+        logs
+          nvme0n1   ONLINE       0     0     0    17
+        spares
+          sdc       AVAIL
+
+errors: No known data errors`
+
+func TestParseSlowIOs(t *testing.T) {
+	devices := parseSlowIOs(slowIOsOutput, "tank")
+	want := []deviceSlowIOs{{device: "sda"}, {device: "sdb", slow: 1.2 * 1024}, {device: "nvme0n1", slow: 17}}
+	if len(devices) != len(want) {
+		t.Fatalf("Incorrect devices %v, should be %v", devices, want)
+	}
+	for i := range want {
+		if devices[i] != want[i] {
+			t.Errorf("Incorrect device %v, should be %v", devices[i], want[i])
+		}
+	}
+	if devices := parseSlowIOs(scanFinishedOutput, "tank"); len(devices) != 0 {
+		t.Errorf("Output without SLOW column should have no devices, got %v", devices)
+	}
+}
+
+func TestProbeSlowIOs(t *testing.T) {
+	if !probeSlowIOs(staticRunner{"zpool status -s tank": slowIOsOutput}, "tank") {
+		t.Errorf("SLOW column should be detected")
+	}
+	if probeSlowIOs(staticRunner{"zpool status -s tank": "invalid option 's'\nusage:\n"}, "tank") {
+		t.Errorf("Usage error should not be detected as support")
+	}
+	if probeSlowIOs(fixtureRunner{}, "tank") {
+		t.Errorf("Output without SLOW column should not be detected as support")
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// snapshotColumns are the columns requested from zfs list -t snapshot.
+var snapshotColumns = []string{"name", "userrefs"}
+
+var (
+	snapshotCountDesc = prometheus.NewDesc("zfs_dataset_snapshot_count",
+		"Number of snapshots of the dataset", []string{"name"}, nil)
+	snapshotHoldsDesc = prometheus.NewDesc("zfs_dataset_snapshot_holds",
+		"Number of user holds on all snapshots of the dataset", []string{"name"}, nil)
+	bookmarkCountDesc = prometheus.NewDesc("zfs_dataset_bookmark_count",
+		"Number of bookmarks of the dataset", []string{"name"}, nil)
+)
+
+// snapshotStats aggregates the snapshots of one dataset.
+type snapshotStats struct {
+	snapshots int
+	holds     uint64
+}
+
+// parseSnapshots reads zfs list -Hp -t snapshot -o <snapshotColumns> output
+// line by line and aggregates it per dataset. Snapshots of datasets rejected
+// by filter are not counted.
+func parseSnapshots(r io.Reader, filter datasetFilter) (stats map[string]*snapshotStats, skipped int, err error) {
+	stats = map[string]*snapshotStats{}
+	scanner := newOutputScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		at := strings.IndexByte(fields[0], '@')
+		if len(fields) != len(snapshotColumns) || at < 0 {
+			skipped++
+			continue
+		}
+		name := fields[0][:at]
+		if !filter.matches(name) {
+			continue
+		}
+		holds, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			skipped++
+			continue
+		}
+		s, ok := stats[name]
+		if !ok {
+			s = &snapshotStats{}
+			stats[name] = s
+		}
+		s.snapshots++
+		s.holds += holds
+	}
+	return stats, skipped, scanError(scanner)
+}
+
+// parseBookmarks reads zfs list -H -t bookmark -o name output and counts the
+// bookmarks per dataset accepted by filter.
+func parseBookmarks(r io.Reader, filter datasetFilter) (counts map[string]int, skipped int, err error) {
+	counts = map[string]int{}
+	scanner := newOutputScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		hash := strings.IndexByte(line, '#')
+		if hash < 0 {
+			skipped++
+			continue
+		}
+		if name := line[:hash]; filter.matches(name) {
+			counts[name]++
+		}
+	}
+	return counts, skipped, scanError(scanner)
+}
+
+// bookmarkFeatureCommand is the zpool get of bookmarkPools.
+func bookmarkFeatureCommand(pools []zpool) command {
+	return command{"zpool", append([]string{"get", "-H", "-o", "name,value", "feature@bookmarks"}, poolNames(pools)...)}
+}
+
+// bookmarkPools returns the pools on which the bookmarks feature is enabled
+// or active. Listing bookmarks on the others is skipped.
+func bookmarkPools(r commandRunner, pools []zpool) ([]zpool, error) {
+	output, err := bookmarkFeatureCommand(pools).run(r)
+	if err != nil {
+		return nil, fmt.Errorf("zpool get feature@bookmarks: %s", err)
+	}
+	enabled := map[string]bool{}
+	for lines := newLineScanner(output); lines.scan(); {
+		fields := strings.Split(lines.line, "\t")
+		if len(fields) == 2 && (fields[1] == "enabled" || fields[1] == "active") {
+			enabled[fields[0]] = true
+		}
+	}
+	var result []zpool
+	for _, pool := range pools {
+		if enabled[pool.name] {
+			result = append(result, pool)
+		}
+	}
+	return result, nil
+}
+
+// snapshotCollector exports per-dataset snapshot aggregates from a single
+// listing of all snapshots in the monitored pools, and optionally bookmark
+// counts from a single listing of all bookmarks. Enumerating snapshots can
+// be expensive, so it is only enabled on request.
+//
+// At most maxDatasets datasets, the first by name, get a series, 0 meaning no
+// limit.
+type snapshotCollector struct {
+	filter    datasetFilter
+	bookmarks bool
+	limit     datasetLimit
+}
+
+func newSnapshotCollector(filter datasetFilter, bookmarks bool, maxDatasets int) *snapshotCollector {
+	return &snapshotCollector{filter: filter, bookmarks: bookmarks, limit: datasetLimit{collector: "snapshots", max: maxDatasets}}
+}
+
+func (c *snapshotCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- snapshotCountDesc
+	ch <- snapshotHoldsDesc
+	if c.bookmarks {
+		ch <- bookmarkCountDesc
+	}
+	ch <- datasetsTruncatedDesc
+}
+
+// snapshotListCommand lists the snapshots of pools.
+func snapshotListCommand(pools []zpool) command {
+	return command{"zfs", append([]string{"list", "-Hp", "-t", "snapshot", "-o", strings.Join(snapshotColumns, ","), "-r"}, poolNames(pools)...)}
+}
+
+// bookmarkListCommand lists the bookmarks of pools.
+func bookmarkListCommand(pools []zpool) command {
+	return command{"zfs", append([]string{"list", "-H", "-t", "bookmark", "-o", "name", "-r"}, poolNames(pools)...)}
+}
+
+func (c *snapshotCollector) plan(pools []zpool) []plannedCommand {
+	plan := []plannedCommand{{snapshotListCommand(pools), ""}}
+	if c.bookmarks {
+		plan = append(plan,
+			plannedCommand{bookmarkFeatureCommand(pools), ""},
+			plannedCommand{bookmarkListCommand(pools), "for the pools with the bookmarks feature"})
+	}
+	return plan
+}
+
+func (c *snapshotCollector) collect(r commandRunner, pools []zpool, ch chan<- prometheus.Metric) error {
+	output, err := snapshotListCommand(pools).start(r)
+	if err != nil {
+		return err
+	}
+	stats, skipped, err := parseSnapshots(output, c.filter)
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("listing snapshots: %s", err)
+	}
+	if skipped > 0 {
+		log.Printf("Skipped %d malformed rows in zfs snapshot list output", skipped)
+	}
+	var counts map[string]int
+	if c.bookmarks {
+		if counts, err = c.listBookmarks(r, pools); err != nil {
+			return err
+		}
+		// Datasets with snapshots but no bookmarks export 0.
+		for name := range stats {
+			if _, ok := counts[name]; !ok {
+				counts[name] = 0
+			}
+		}
+	}
+
+	names := make([]string, 0, len(stats)+len(counts))
+	for name := range stats {
+		names = append(names, name)
+	}
+	for name := range counts {
+		if _, ok := stats[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	c.limit.report(len(names), ch)
+	for i, name := range names {
+		if !c.limit.allow(i) {
+			break
+		}
+		// A dataset with bookmarks but no snapshots has no snapshot stats.
+		s, ok := stats[name]
+		if !ok {
+			s = &snapshotStats{}
+		}
+		emitIfPresent(ch, snapshotCountDesc, float64(s.snapshots), ok, name)
+		emitIfPresent(ch, snapshotHoldsDesc, float64(s.holds), ok, name)
+		if count, ok := counts[name]; ok {
+			emitAlways(ch, bookmarkCountDesc, float64(count), name)
+		}
+	}
+	return nil
+}
+
+func (c *snapshotCollector) listBookmarks(r commandRunner, pools []zpool) (map[string]int, error) {
+	pools, err := bookmarkPools(r, pools)
+	if err != nil || len(pools) == 0 {
+		return map[string]int{}, err
+	}
+	output, err := bookmarkListCommand(pools).start(r)
+	if err != nil {
+		return nil, err
+	}
+	counts, skipped, err := parseBookmarks(output, c.filter)
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listing bookmarks: %s", err)
+	}
+	if skipped > 0 {
+		log.Printf("Skipped %d malformed rows in zfs bookmark list output", skipped)
+	}
+	return counts, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const zfsSnapshotListOutput = "tank/home@daily-1\t0\n" +
+	"tank/home@daily-2\t2\n" +
+	"tank/home@send-stuck\t1\n" +
+	"tank/vmail@daily-1\t0\n" +
+	"tank/docker/abc@base\t0\n" +
+	"tank/nosnapshot\t0\n" +
+	"tank/vmail@broken\tmany\n"
+
+func TestParseSnapshots(t *testing.T) {
+	filter, _ := newDatasetFilter("", "tank/docker/.*")
+	stats, skipped, err := parseSnapshots(strings.NewReader(zfsSnapshotListOutput), filter)
+	if err != nil {
+		t.Fatalf("Error in parseSnapshots (%s)", err)
+	}
+	if skipped != 2 {
+		t.Errorf("Incorrect amount of skipped rows (%d), should be 2.", skipped)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("Incorrect amount of datasets (%d), should be 2.", len(stats))
+	}
+	if s := stats["tank/home"]; s.snapshots != 3 || s.holds != 3 {
+		t.Errorf("Incorrect stats for tank/home: %+v", s)
+	}
+	if s := stats["tank/vmail"]; s.snapshots != 1 || s.holds != 0 {
+		t.Errorf("Incorrect stats for tank/vmail: %+v", s)
+	}
+}
+
+func TestSnapshotCollectorBookmarks(t *testing.T) {
+	r := staticRunner{
+		"zfs list -Hp -t snapshot -o name,userrefs -r tank old": zfsSnapshotListOutput,
+		"zpool get -H -o name,value feature@bookmarks tank old": "tank\tactive\nold\tdisabled\n",
+		"zfs list -H -t bookmark -o name -r tank":               "tank/vmail#daily-0\ntank/vmail#daily-1\ntank/backup#base\n",
+	}
+	c := newSnapshotCollector(datasetFilter{}, true, 0)
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		if err := c.collect(r, []zpool{{name: "tank"}, {name: "old"}}, ch); err != nil {
+			t.Errorf("Error in collect (%s)", err)
+		}
+		close(ch)
+	}()
+	bookmarks := map[string]float64{}
+	for m := range ch {
+		if m.Desc() == bookmarkCountDesc {
+			bookmarks[metricLabel(m, "name")] = metricValue(m)
+		}
+	}
+	for name, want := range map[string]float64{
+		"tank/home":       0, // snapshots but no bookmarks
+		"tank/vmail":      2,
+		"tank/backup":     1,
+		"tank/docker/abc": 0,
+	} {
+		if got, ok := bookmarks[name]; !ok || got != want {
+			t.Errorf("Incorrect bookmark count for %s (%v), should be %v", name, got, want)
+		}
+	}
+}
+
+func TestSnapshotCollectorLimit(t *testing.T) {
+	r := staticRunner{
+		"zfs list -Hp -t snapshot -o name,userrefs -r tank": zfsSnapshotListOutput,
+		"zpool get -H -o name,value feature@bookmarks tank": "tank\tactive\n",
+		"zfs list -H -t bookmark -o name -r tank":           "tank/vmail#daily-0\ntank/backup#base\n",
+	}
+	c := newSnapshotCollector(datasetFilter{}, true, 2)
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		if err := c.collect(r, []zpool{{name: "tank"}}, ch); err != nil {
+			t.Errorf("Error in collect (%s)", err)
+		}
+		close(ch)
+	}()
+	datasets := map[string]bool{}
+	truncated := -1.0
+	for m := range ch {
+		if m.Desc() == datasetsTruncatedDesc {
+			truncated = metricValue(m)
+		} else {
+			datasets[metricLabel(m, "name")] = true
+		}
+	}
+	if truncated != 1 {
+		t.Errorf("Incorrect truncated (%v), should be 1", truncated)
+	}
+	if len(datasets) != 2 || !datasets["tank/backup"] || !datasets["tank/docker/abc"] {
+		t.Errorf("Incorrect datasets (%v), should be the first two by name", datasets)
+	}
+}
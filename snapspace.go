@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var poolSnapshotUsedDesc = prometheus.NewDesc("zfs_pool_snapshot_used_bytes",
+	"Space used by the snapshots of the pool, the usedbysnapshots of its filesystems and volumes summed up", []string{"name"}, nil)
+
+// snapshotSpace sums up the usedbysnapshots of filesystems and volumes per
+// pool.
+type snapshotSpace map[string]float64
+
+// add adds the usedbysnapshots of the dataset name, ignoring values zfs does
+// not report.
+func (s snapshotSpace) add(name, value string) {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return // "-"
+	}
+	pool := name
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		pool = name[:i]
+	}
+	s[pool] += v
+}
+
+// snapshotSpaceCollector exports the space used by snapshots per pool
+// without any per-dataset series. When the dataset collector lists every
+// filesystem and volume of the pools, the sums are taken from the listing it
+// made in the same scrape; otherwise the collector lists usedbysnapshots of
+// every dataset itself.
+type snapshotSpaceCollector struct {
+	datasets *datasetCollector // nil without a dataset collector that lists everything
+}
+
+func (snapshotSpaceCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- poolSnapshotUsedDesc
+}
+
+// snapshotSpaceCommand is the zfs list of listSnapshotSpace.
+func snapshotSpaceCommand(pools []zpool) command {
+	return command{"zfs", append([]string{"list", "-Hp", "-o", "name,usedbysnapshots", "-t", "filesystem,volume", "-r"}, poolNames(pools)...)}
+}
+
+// plan is empty when the sums come from the listing of the dataset
+// collector.
+func (c snapshotSpaceCollector) plan(pools []zpool) []plannedCommand {
+	if c.datasets != nil {
+		return nil
+	}
+	return []plannedCommand{{snapshotSpaceCommand(pools), ""}}
+}
+
+func (c snapshotSpaceCollector) collect(r commandRunner, pools []zpool, ch chan<- prometheus.Metric) error {
+	var space snapshotSpace
+	if c.datasets != nil {
+		space = c.datasets.takeSnapshotSpace()
+	}
+	if space == nil {
+		var err error
+		if space, err = listSnapshotSpace(r, pools); err != nil {
+			return err
+		}
+	}
+	for _, pool := range pools {
+		v, ok := space[pool.name]
+		emitIfKnown(ch, poolSnapshotUsedDesc, v, ok, pool.name)
+	}
+	return nil
+}
+
+// listSnapshotSpace sums up the usedbysnapshots of the datasets of pools
+// from a zfs list of just that property.
+func listSnapshotSpace(r commandRunner, pools []zpool) (snapshotSpace, error) {
+	output, err := snapshotSpaceCommand(pools).start(r)
+	if err != nil {
+		return nil, err
+	}
+	space := snapshotSpace{}
+	scanner := newOutputScanner(output)
+	for scanner.Scan() {
+		if fields := strings.Split(scanner.Text(), "\t"); len(fields) == 2 {
+			space.add(fields[0], fields[1])
+		}
+	}
+	err = scanError(scanner)
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("zfs list usedbysnapshots: %s", err)
+	}
+	return space, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolSnapshotSpace returns zfs_pool_snapshot_used_bytes by pool.
+func poolSnapshotSpace(metrics []prometheus.Metric) map[string]float64 {
+	got := map[string]float64{}
+	for _, m := range metrics {
+		if m.Desc() == poolSnapshotUsedDesc {
+			got[metricLabel(m, "name")] = metricValue(m)
+		}
+	}
+	return got
+}
+
+// mockSnapshotSpace is the usedbysnapshots of the mock datasets by pool:
+// tank/home and tank/vm/db in tank, backup/tank in backup.
+var mockSnapshotSpace = map[string]float64{"tank": 1099511627776 + 2199023255552, "backup": 274877710336}
+
+// listingRunner records the zfs list command lines run through it.
+type listingRunner struct {
+	commandRunner
+	lists *[]string
+}
+
+func (r listingRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	if name == "zfs" && len(args) > 0 && args[0] == "list" {
+		*r.lists = append(*r.lists, strings.Join(args, " "))
+	}
+	return r.commandRunner.start(name, args...)
+}
+
+func TestSnapshotSpace(t *testing.T) {
+	pools := parsePools(mockPools)
+	ch := make(chan prometheus.Metric, 10)
+	if err := (snapshotSpaceCollector{}).collect(mockRunner{}, pools, ch); err != nil {
+		t.Fatalf("Error in collect (%s)", err)
+	}
+	close(ch)
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	if got := poolSnapshotSpace(metrics); !reflect.DeepEqual(got, mockSnapshotSpace) {
+		t.Errorf("Incorrect snapshot space %v, should be %v", got, mockSnapshotSpace)
+	}
+}
+
+func TestSnapshotSpaceFromDatasets(t *testing.T) {
+	e := newMockExporter(t)
+	datasets := e.collectors[0].collector.(*datasetCollector)
+	if !datasets.listsEverything() {
+		t.Fatal("The mock dataset collector should list every dataset")
+	}
+	e.addCollector("pool-snapshot-space", snapshotSpaceCollector{datasets: datasets})
+	var lists []string
+	e.runner = listingRunner{e.runner, &lists}
+	for i := 0; i < 2; i++ {
+		lists = nil
+		if got := poolSnapshotSpace(e.snapshot(nil)); !reflect.DeepEqual(got, mockSnapshotSpace) {
+			t.Errorf("Incorrect snapshot space %v, should be %v", got, mockSnapshotSpace)
+		}
+		for _, list := range lists {
+			if strings.Contains(list, "name,usedbysnapshots") {
+				t.Errorf("The listing of the dataset collector should be used, got %s", list)
+			}
+		}
+	}
+
+	// Without the dataset collector in the scrape the collector lists
+	// the datasets itself.
+	lists = nil
+	if got := poolSnapshotSpace(e.snapshot(collectorSelection{"pool-snapshot-space": true})); !reflect.DeepEqual(got, mockSnapshotSpace) {
+		t.Errorf("Incorrect snapshot space on its own %v, should be %v", got, mockSnapshotSpace)
+	}
+	if len(lists) != 1 || !strings.Contains(lists[0], "name,usedbysnapshots") {
+		t.Errorf("The collector should list usedbysnapshots on its own, got %v", lists)
+	}
+
+	filtered := newDatasetCollector(datasetOptions{maxDepth: 1})
+	if filtered.listsEverything() {
+		t.Errorf("A dataset collector with a depth limit does not list every dataset")
+	}
+}
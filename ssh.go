@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sshTarget is the remote host of -ssh.host to run zpool, zfs and the other
+// commands on, for machines the exporter cannot be installed on. The
+// commands run through the ssh client of this machine, so that local users
+// carry no SSH code in the exporter and ~/.ssh/config, known_hosts and the
+// agent work as they do for ssh itself.
+type sshTarget struct {
+	host           string // host name or address, or user@host
+	user           string // "" for the user of host or the ssh config
+	port           int    // 0 for the port of the ssh config
+	identityFile   string // "" for the keys of the ssh config or agent
+	connectTimeout time.Duration
+	// controlPersist is how long the shared connection stays open after
+	// the last command; every command runs over it rather than
+	// connecting again.
+	controlPersist time.Duration
+}
+
+func (t sshTarget) validate() error {
+	switch {
+	case strings.HasPrefix(t.host, "-") || strings.ContainsAny(t.host, " \t"):
+		return fmt.Errorf("invalid -ssh.host %q", t.host)
+	case t.port < 0 || t.port > 65535:
+		return errors.New("-ssh.port should be from 1 to 65535, or 0 for the port of the ssh config")
+	case t.connectTimeout < time.Second:
+		return errors.New("-ssh.connect-timeout should be at least 1s")
+	case t.controlPersist < time.Second:
+		return errors.New("-ssh.control-persist should be at least 1s")
+	}
+	return nil
+}
+
+// name is the host name of the target, without the user, for the target
+// label.
+func (t sshTarget) name() string {
+	return t.host[strings.LastIndexByte(t.host, '@')+1:]
+}
+
+// options returns the options of ssh for the target, sharing the
+// connection through the socket in controlDir. BatchMode makes ssh fail
+// rather than prompt for a password or an unknown host key, and the server
+// alive checks make a command over a connection that broke fail within
+// about three connect timeouts instead of hanging.
+func (t sshTarget) options(controlDir string) []string {
+	seconds := func(d time.Duration) string {
+		return strconv.Itoa(int(d.Round(time.Second) / time.Second))
+	}
+	args := []string{
+		"-T",
+		"-o", "BatchMode=yes",
+		"-o", "ConnectTimeout=" + seconds(t.connectTimeout),
+		"-o", "ServerAliveInterval=" + seconds(t.connectTimeout),
+		"-o", "ServerAliveCountMax=3",
+		"-o", "ControlMaster=auto",
+		"-o", "ControlPath=" + filepath.Join(controlDir, "%C"),
+		"-o", "ControlPersist=" + seconds(t.controlPersist),
+	}
+	if t.user != "" {
+		args = append(args, "-l", t.user)
+	}
+	if t.port != 0 {
+		args = append(args, "-p", strconv.Itoa(t.port))
+	}
+	if t.identityFile != "" {
+		args = append(args, "-i", t.identityFile, "-o", "IdentitiesOnly=yes")
+	}
+	return args
+}
+
+// remoteLookPath stands in for exec.LookPath for the commands run on the
+// target, such as nice, which the shell of the target finds in its PATH.
+func remoteLookPath(name string) (string, error) {
+	return name, nil
+}
+
+// safeShellWord matches the words the remote shell reads as they are.
+var safeShellWord = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellQuote quotes word for the POSIX shell ssh hands the remote command
+// line to, which would otherwise split and expand dataset names with spaces
+// or the like.
+func shellQuote(word string) string {
+	if safeShellWord.MatchString(word) {
+		return word
+	}
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// sshExitStatus is the exit status of ssh when it could not connect or the
+// connection broke, rather than relaying that of the remote command.
+const sshExitStatus = 255
+
+// sshError is a command that failed because the target could not be
+// reached, rather than by an error of the command. The pools of the target
+// are exported with zpool_up 0 while it lasts.
+type sshError struct {
+	host string
+	msg  string // what ssh printed, such as "Connection refused"
+}
+
+func (e *sshError) Error() string {
+	if e.msg == "" {
+		return fmt.Sprintf("could not reach %s over ssh", e.host)
+	}
+	return fmt.Sprintf("could not reach %s over ssh: %s", e.host, e.msg)
+}
+
+// unreachable reports whether err means that the pools cannot be reached
+// at the moment, rather than that a collection failed: an sshError, or a
+// tooManyChildrenError for commands stuck in the kernel. The pools are then
+// exported with zpool_up 0 instead of stopping the exporter.
+func unreachable(err error) bool {
+	var e *sshError
+	var t *tooManyChildrenError
+	return errors.As(err, &e) || errors.As(err, &t)
+}
+
+// sshRunner runs the commands on the target with the ssh client. With a
+// wrapper, such as nice -n 10 from -command.nice, it runs the commands
+// under it on the target.
+type sshRunner struct {
+	ssh        string // path of the ssh client
+	target     sshTarget
+	controlDir string
+	wrapper    []string
+	ctx        context.Context // see execRunner
+}
+
+func (r *sshRunner) withContext(ctx context.Context) commandRunner {
+	runner := *r
+	runner.ctx = ctx
+	return &runner
+}
+
+// newSSHRunner returns the runner for target, with the socket of the
+// shared connection in a new private directory that close removes.
+func newSSHRunner(target sshTarget) (*sshRunner, error) {
+	path, err := exec.LookPath("ssh")
+	if err != nil {
+		return nil, fmt.Errorf("-ssh.host needs the ssh client: %s", err)
+	}
+	// Short, since the socket path with the %C hash must fit in the 104
+	// bytes of a unix socket address.
+	dir, err := os.MkdirTemp("", "pzfs")
+	if err != nil {
+		return nil, err
+	}
+	return &sshRunner{ssh: path, target: target, controlDir: dir}, nil
+}
+
+// command returns the ssh command running name with args on the target.
+func (r *sshRunner) command(name string, args []string) *exec.Cmd {
+	words := append(append(append([]string{}, r.wrapper...), name), args...)
+	for i, w := range words {
+		words[i] = shellQuote(w)
+	}
+	line := append(r.target.options(r.controlDir), r.target.host, "--", strings.Join(words, " "))
+	return exec.Command(r.ssh, line...)
+}
+
+func (r *sshRunner) line(name string, args []string) []string {
+	return r.command(name, args).Args
+}
+
+// check turns the error of cmd into an sshError carrying the last line ssh
+// printed when it exited with sshExitStatus.
+func (r *sshRunner) check(cmd *exec.Cmd, err error, output string) error {
+	if err == nil || cmd.ProcessState == nil || cmd.ProcessState.ExitCode() != sshExitStatus {
+		return err
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return &sshError{host: r.target.name(), msg: strings.TrimSpace(lines[len(lines)-1])}
+}
+
+func (r *sshRunner) run(name string, args ...string) (string, error) {
+	cmd := r.command(name, args)
+	out, err := runCommand(contextOrBackground(r.ctx), name, cmd)
+	return out, r.check(cmd, err, out)
+}
+
+func (r *sshRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	output, err := startCommand(contextOrBackground(r.ctx), name, r.command(name, args))
+	if err != nil {
+		return nil, err
+	}
+	return &sshOutput{commandOutput: output, runner: r}, nil
+}
+
+// sshOutput is the commandOutput of a command started over ssh.
+type sshOutput struct {
+	*commandOutput
+	runner *sshRunner
+}
+
+func (o *sshOutput) Close() error {
+	err := o.commandOutput.Close()
+	return o.runner.check(o.child.cmd, err, o.stderr.String())
+}
+
+// close ends the shared connection and removes its directory.
+func (r *sshRunner) close() {
+	args := append(r.target.options(r.controlDir), "-O", "exit", r.target.host)
+	exec.Command(r.ssh, args...).Run()
+	os.RemoveAll(r.controlDir)
+}
+
+// isRemote reports whether r runs the commands on an -ssh.host.
+func isRemote(r commandRunner) bool {
+	for {
+		switch runner := r.(type) {
+		case *sshRunner:
+			return true
+		case instrumentedRunner:
+			r = runner.commandRunner
+		case debugRunner:
+			r = runner.commandRunner
+		default:
+			return false
+		}
+	}
+}
+
+// unreachableMetrics exports zpool_up 0 for every pool while the target
+// cannot be reached, so that alerts on zpool_up cover the connection too.
+func unreachableMetrics(ch chan<- prometheus.Metric, pools []zpool) {
+	for _, pool := range pools {
+		ch <- prometheus.MustNewConstMetric(zpoolUpDesc, prometheus.GaugeValue, 0, pool.name)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSSH puts an ssh in PATH that runs the remote command line with sh, as
+// sshd would, or fails as ssh does when it cannot connect while down is
+// set in the environment.
+func fakeSSH(t *testing.T) sshTarget {
+	t.Helper()
+	dir := t.TempDir()
+	script := `#!/bin/sh
+if [ -n "$FAKE_SSH_DOWN" ]; then
+	echo "ssh: connect to host nas port 22: Connection refused" >&2
+	exit 255
+fi
+for last; do :; done
+exec /bin/sh -c "$last"
+`
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return sshTarget{host: "root@nas", connectTimeout: 5 * time.Second, controlPersist: time.Minute}
+}
+
+func TestSSHTargetOptions(t *testing.T) {
+	target := sshTarget{host: "nas", user: "monitor", port: 2222, identityFile: "/etc/key",
+		connectTimeout: 3 * time.Second, controlPersist: 5 * time.Minute}
+	got := strings.Join(target.options("/tmp/pzfs1"), " ")
+	for _, want := range []string{"-o BatchMode=yes", "-o ConnectTimeout=3", "-o ControlMaster=auto",
+		"-o ControlPath=/tmp/pzfs1/%C", "-o ControlPersist=300", "-l monitor", "-p 2222", "-i /etc/key"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("The ssh options %q should include %q", got, want)
+		}
+	}
+	if got := (sshTarget{host: "monitor@nas"}).name(); got != "nas" {
+		t.Errorf("Incorrect target name %q", got)
+	}
+	for _, bad := range []sshTarget{
+		{host: "-oProxyCommand=x", connectTimeout: time.Second, controlPersist: time.Second},
+		{host: "nas", port: 70000, connectTimeout: time.Second, controlPersist: time.Second},
+		{host: "nas", controlPersist: time.Second},
+	} {
+		if err := bad.validate(); err == nil {
+			t.Errorf("%+v should not be valid", bad)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	for word, want := range map[string]string{
+		"zpool":             "zpool",
+		"name,used,avail":   "name,used,avail",
+		"tank/my documents": "'tank/my documents'",
+		"it's":              `'it'\''s'`,
+		"$HOME":             "'$HOME'",
+	} {
+		if got := shellQuote(word); got != want {
+			t.Errorf("shellQuote(%q) = %s, should be %s", word, got, want)
+		}
+	}
+}
+
+func TestSSHRunner(t *testing.T) {
+	r, err := newSSHRunner(fakeSSH(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.close()
+	r.wrapper = []string{"env", "X=1"}
+	out, err := r.run("echo", "tank/my documents", "it's", "$X")
+	if err != nil || out != "tank/my documents it's $X\n" {
+		t.Errorf("The arguments should reach the target as they are, got %q (%v)", out, err)
+	}
+	if _, err := r.run("false"); err == nil || unreachable(err) {
+		t.Errorf("A failing command should fail without being unreachable, got %v", err)
+	}
+
+	t.Setenv("FAKE_SSH_DOWN", "1")
+	_, err = r.run("zpool", "list")
+	if !unreachable(err) || err.Error() != "could not reach nas over ssh: ssh: connect to host nas port 22: Connection refused" {
+		t.Errorf("A connection failure should be unreachable, got %v", err)
+	}
+	output, err := r.start("zfs", "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, output)
+	if err := output.Close(); !unreachable(err) {
+		t.Errorf("A connection failure of a started command should be unreachable, got %v", err)
+	}
+}
+
+func TestUnreachableTarget(t *testing.T) {
+	e := newMockExporter(t)
+	r, err := newSSHRunner(fakeSSH(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.close()
+	e.runner = r
+	e.keepRunning = true
+	t.Setenv("FAKE_SSH_DOWN", "1")
+
+	// The first scrape finds the target gone, the next ones set the
+	// exporter up again while it is.
+	for i := 0; i < 2; i++ {
+		up := map[string]float64{}
+		available := -1.0
+		for _, m := range e.snapshot(nil) {
+			switch descName(m.Desc()) {
+			case "zpool_up":
+				up[metricLabel(m, "name")] = metricValue(m)
+			case "zfs_exporter_zfs_available":
+				available = metricValue(m)
+			}
+		}
+		if want := map[string]float64{"tank": 0, "backup": 0}; !reflect.DeepEqual(up, want) || available != 0 {
+			t.Errorf("Scrape %d of an unreachable target should export zpool_up 0 for every pool, got %v and available %v", i, up, available)
+		}
+	}
+	select {
+	case err := <-e.fatal:
+		t.Errorf("An unreachable target should not stop the exporter (%s)", err)
+	default:
+	}
+}
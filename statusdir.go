@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// statusListFile is the zpool list output in a --status-dir directory.
+const statusListFile = "list.txt"
+
+// dirRunner answers zpool list and zpool status from files in dir instead of
+// running them, so that dumps from another machine can be turned into the
+// metrics the exporter would serve there: list.txt holds the output of
+// zpool list -Hp -o <zpoolListProperties> and <pool>-status.txt that of
+// zpool status <pool>. The files are read on every call, so the metrics
+// follow when they are replaced. Other commands fail, as they do with -mock.
+type dirRunner struct {
+	dir string
+}
+
+func (r dirRunner) run(name string, args ...string) (string, error) {
+	if name != "zpool" || len(args) == 0 {
+		return r.unsupported(name, args)
+	}
+	flags, operands := mockArgs(args[1:], "o")
+	switch args[0] {
+	case "list":
+		if _, ok := flags["v"]; ok {
+			return r.unsupported(name, args)
+		}
+		return r.list(operands)
+	case "status":
+		// The files hold what one zpool status showed, so options such as
+		// -x that change what it shows cannot be answered.
+		for _, flag := range []string{"x", "p", "g"} {
+			if _, ok := flags[flag]; ok {
+				return r.unsupported(name, args)
+			}
+		}
+		return r.status(operands)
+	}
+	return r.unsupported(name, args)
+}
+
+func (r dirRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	output, err := r.run(name, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", err, strings.TrimSpace(output))
+	}
+	return io.NopCloser(strings.NewReader(output)), nil
+}
+
+func (r dirRunner) unsupported(name string, args []string) (string, error) {
+	command := strings.TrimSpace(name + " " + strings.Join(args, " "))
+	return fmt.Sprintf("%s: not available with --status-dir\n", command),
+		fmt.Errorf("--status-dir: %s is not supported", command)
+}
+
+// list returns the rows of list.txt of pools, or all of them when pools is
+// empty, with zpool's error for the pools it has no row for.
+func (r dirRunner) list(pools []string) (string, error) {
+	b, err := os.ReadFile(filepath.Join(r.dir, statusListFile))
+	if err != nil {
+		return err.Error() + "\n", err
+	}
+	if len(pools) == 0 {
+		return string(b), nil
+	}
+	rows := map[string]string{}
+	for _, line := range strings.SplitAfter(string(b), "\n") {
+		if name, _, _ := strings.Cut(line, "\t"); name != "" {
+			rows[name] += line
+		}
+	}
+	var out strings.Builder
+	var missing error
+	for _, pool := range pools {
+		row, ok := rows[pool]
+		if !ok {
+			fmt.Fprintf(&out, "cannot open '%s': no such pool\n", pool)
+			missing = fmt.Errorf("pool %s is not in %s", pool, statusListFile)
+			continue
+		}
+		out.WriteString(row)
+	}
+	return out.String(), missing
+}
+
+// status returns the status files of pools, with zpool's error for the pools
+// there is no file for.
+func (r dirRunner) status(pools []string) (string, error) {
+	var out strings.Builder
+	var missing error
+	for _, pool := range pools {
+		b, err := os.ReadFile(filepath.Join(r.dir, pool+"-status.txt"))
+		if err != nil {
+			fmt.Fprintf(&out, "cannot open '%s': no such pool\n", pool)
+			missing = err
+			continue
+		}
+		out.Write(b)
+	}
+	return out.String(), missing
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDirRunner(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, file string) {
+		t.Helper()
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeList := func(health string) {
+		t.Helper()
+		row := "tank\t47962866745344\t26379576705024\t21583290040320\t55\t12\t" + health + "\toff\t-\t-\n"
+		if err := os.WriteFile(filepath.Join(dir, statusListFile), []byte(row), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeList("ONLINE")
+	write("tank-status.txt", "mock/zpool-status-tank.txt")
+
+	pools := parsePools("tank")
+	e := NewExporter(&pools)
+	e.runner = dirRunner{dir: dir}
+	warning := func() float64 {
+		t.Helper()
+		for _, m := range e.snapshot(nil) {
+			if descName(m.Desc()) == "zpool_status_has_warning" && metricLabel(m, "name") == "tank" {
+				return metricValue(m)
+			}
+		}
+		t.Fatalf("No zpool_status_has_warning for tank")
+		return 0
+	}
+	if got := warning(); got != 0 {
+		t.Errorf("Incorrect status warning (%v), should be 0", got)
+	}
+
+	// Replaced files show up on the next scrape.
+	writeList("DEGRADED")
+	write("tank-status.txt", "testdata/zpool-status-spare.txt")
+	if got := warning(); got != 1 {
+		t.Errorf("Status warning should follow the replaced status file, got %v", got)
+	}
+
+	r := dirRunner{dir: dir}
+	if output, err := r.run("zpool", "list", "scratch"); err == nil || !strings.Contains(output, "no such pool") {
+		t.Errorf("Pool missing from list.txt should be reported as no such pool, got %q (%v)", output, err)
+	}
+	if _, err := r.run("zpool", "status", "-x", "tank"); err == nil {
+		t.Errorf("zpool status -x should not be supported")
+	}
+	if _, err := r.run("zfs", "list", "-Hp"); err == nil {
+		t.Errorf("zfs should not be supported")
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	poolsTotalDesc = prometheus.NewDesc("zfs_pools",
+		"Number of zpools the exporter monitors", nil, nil)
+	poolsUnhealthyDesc = prometheus.NewDesc("zfs_pools_unhealthy",
+		"Number of monitored zpools that are not ONLINE, or that could not be collected", nil, nil)
+	providersFaultedDesc = prometheus.NewDesc("zfs_providers_faulted",
+		"Number of faulted zpool providers (disks), summed over the pools", nil, nil)
+	capacityMaxDesc = prometheus.NewDesc("zfs_capacity_max_ratio",
+		"zpool_capacity_ratio of the fullest zpool, from 0 to 1, absent (0 with --strict-zero) when no pool with a size was collected", nil, nil)
+)
+
+// describeSummary describes the metrics of summaryMetrics.
+func describeSummary(ch chan<- *prometheus.Desc) {
+	ch <- poolsTotalDesc
+	ch <- poolsUnhealthyDesc
+	ch <- providersFaultedDesc
+	ch <- capacityMaxDesc
+}
+
+// summaryMetrics exports the per host totals of the pools as collected,
+// without a name label, so that a global view federated from many hosts can
+// drop the series of every pool and still see how the hosts are doing.
+func summaryMetrics(ch chan<- prometheus.Metric, pools []zpool) {
+	var unhealthy, faulted int64
+	capacity := -1.0
+	for _, pool := range pools {
+		if pool.err != nil {
+			unhealthy++
+			continue
+		}
+		if pool.health != "ONLINE" {
+			unhealthy++
+		}
+		faulted += pool.faulted
+		if pool.size > 0 {
+			if ratio := float64(pool.alloc) / float64(pool.size); ratio > capacity {
+				capacity = ratio
+			}
+		}
+	}
+	emitAlways(ch, poolsTotalDesc, float64(len(pools)))
+	emitAlways(ch, poolsUnhealthyDesc, float64(unhealthy))
+	emitAlways(ch, providersFaultedDesc, float64(faulted))
+	emitIfPresent(ch, capacityMaxDesc, capacity, capacity >= 0)
+}
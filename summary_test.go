@@ -0,0 +1,54 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// summary returns the summary metrics of a scrape of e by name.
+func summary(t *testing.T, e *Exporter) map[string]float64 {
+	t.Helper()
+	got := map[string]float64{}
+	for _, m := range e.snapshot(nil) {
+		switch name := descName(m.Desc()); name {
+		case "zfs_pools", "zfs_pools_unhealthy", "zfs_providers_faulted", "zfs_capacity_max_ratio":
+			if metricLabel(m, "name") != "" {
+				t.Errorf("%s should not have a name label", name)
+			}
+			got[name] = metricValue(m)
+		}
+	}
+	return got
+}
+
+func TestSummaryMetrics(t *testing.T) {
+	got := summary(t, newMockExporter(t))
+	want := map[string]float64{"zfs_pools": 2, "zfs_pools_unhealthy": 1, "zfs_providers_faulted": 1}
+	capacity := got["zfs_capacity_max_ratio"]
+	delete(got, "zfs_capacity_max_ratio")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect summary %v, should be %v", got, want)
+	}
+	if math.Abs(capacity-3587156685619.0/3985729650688) > 1e-9 {
+		t.Errorf("zfs_capacity_max_ratio should be that of backup, got %v", capacity)
+	}
+
+	// A pool that fails is unhealthy, and neither its providers nor its
+	// capacity count.
+	e := newMockExporter(t)
+	e.runner = panickingRunner{e.runner, func(line string) bool {
+		return strings.HasPrefix(line, "zpool status ") && strings.HasSuffix(line, " backup")
+	}}
+	got = summary(t, e)
+	capacity = got["zfs_capacity_max_ratio"]
+	delete(got, "zfs_capacity_max_ratio")
+	want = map[string]float64{"zfs_pools": 2, "zfs_pools_unhealthy": 1, "zfs_providers_faulted": 0}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Incorrect summary with a failed pool %v, should be %v", got, want)
+	}
+	if math.Abs(capacity-26379576705024.0/47962866745344) > 1e-9 {
+		t.Errorf("zfs_capacity_max_ratio should be that of tank, got %v", capacity)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends state, such as "READY=1", to the service manager over
+// $NOTIFY_SOCKET, as sd_notify(3) does. It does nothing when the socket is
+// not set, which is the case unless systemd started the exporter as a
+// Type=notify service. Abstract socket names starting with @ are handled by
+// the net package.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("could not notify systemd: %s", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("could not notify systemd: %s", err)
+	}
+	return nil
+}
+
+// watchdogInterval returns how often to ping the systemd watchdog: half of
+// $WATCHDOG_USEC, as sd_watchdog_enabled(3) recommends. It returns 0 when
+// the watchdog is not enabled, or is enabled for another process according
+// to $WATCHDOG_PID.
+func watchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid WATCHDOG_USEC %q", usec)
+	}
+	return time.Duration(n) * time.Microsecond / 2, nil
+}
+
+// checkAlive reports why the exporter should not ping the watchdog: a
+// collection running for longer than limit, such as one waiting on a wedged
+// zpool command, or an HTTP server that does not answer the /healthz URL
+// healthz within timeout. Pinging from the main loop alone would keep the
+// watchdog happy when only the signal handling still works. An empty healthz
+// only checks the collection.
+func checkAlive(e *Exporter, healthz string, limit, timeout time.Duration) error {
+	if since := e.collectingSince(); !since.IsZero() && time.Since(since) > limit {
+		return fmt.Errorf("collection running for %s", time.Since(since).Round(time.Second))
+	}
+	if healthz == "" {
+		return nil
+	}
+	// The certificate of the exporter is for its public name, not the
+	// loopback address, and it is the exporter itself answering anyway.
+	transport := &http.Transport{Proxy: nil, TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	client := http.Client{Timeout: timeout, Transport: transport}
+	resp, err := client.Get(healthz)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("/healthz answered " + resp.Status)
+	}
+	return nil
+}
+
+// loopbackAddr returns the address to connect to the listener on addr from
+// this host: the loopback address for a listener on all addresses, such as
+// [::]:8080, and addr itself otherwise.
+func loopbackAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		if ip.To4() != nil {
+			return net.JoinHostPort("127.0.0.1", port)
+		}
+		return net.JoinHostPort("::1", port)
+	}
+	return addr
+}
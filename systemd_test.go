@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSdNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := sdNotify("READY=1"); err != nil {
+		t.Errorf("sdNotify without NOTIFY_SOCKET should do nothing, got %s", err)
+	}
+
+	path := t.TempDir() + "/notify"
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("Cannot listen on a unix socket (%s)", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+	if err := sdNotify("READY=1"); err != nil {
+		t.Fatalf("Error in sdNotify (%s)", err)
+	}
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "READY=1" {
+		t.Errorf("Incorrect notification %q (%v), should be READY=1", buf[:n], err)
+	}
+
+	t.Setenv("NOTIFY_SOCKET", t.TempDir()+"/missing")
+	if err := sdNotify("READY=1"); err == nil {
+		t.Errorf("Missing socket should produce error in sdNotify")
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	for _, test := range []struct {
+		usec, pid string
+		want      time.Duration
+		err       bool
+	}{
+		{"", "", 0, false},
+		{"30000000", "", 15 * time.Second, false},
+		{"30000000", pid, 15 * time.Second, false},
+		{"30000000", "1", 0, false},
+		{"soon", "", 0, true},
+		{"-1", "", 0, true},
+	} {
+		t.Setenv("WATCHDOG_USEC", test.usec)
+		t.Setenv("WATCHDOG_PID", test.pid)
+		got, err := watchdogInterval()
+		if got != test.want || (err != nil) != test.err {
+			t.Errorf("Incorrect watchdog interval for %q and pid %q (%s, %v), should be %s", test.usec, test.pid, got, err, test.want)
+		}
+	}
+}
+
+func TestCheckAlive(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(serveHealthy))
+	defer healthy.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	e := NewExporter(&[]zpool{{name: "tank"}})
+	if err := checkAlive(e, healthy.URL+"/healthz", time.Minute, time.Second); err != nil {
+		t.Errorf("Error in checkAlive (%s)", err)
+	}
+	if err := checkAlive(e, broken.URL+"/healthz", time.Minute, time.Second); err == nil {
+		t.Errorf("Failing /healthz should produce error in checkAlive")
+	}
+	e.inflight = &scrape{done: make(chan struct{}), started: time.Now().Add(-2 * time.Minute)}
+	if err := checkAlive(e, healthy.URL+"/healthz", time.Minute, time.Second); err == nil {
+		t.Errorf("Stuck collection should produce error in checkAlive")
+	}
+	if err := checkAlive(e, "", time.Minute, time.Second); err == nil {
+		t.Errorf("Stuck collection should produce error in checkAlive without /healthz")
+	}
+	e.inflight = nil
+
+	secure := httptest.NewTLSServer(http.HandlerFunc(serveHealthy))
+	defer secure.Close()
+	if err := checkAlive(e, secure.URL+"/healthz", time.Minute, time.Second); err != nil {
+		t.Errorf("Error in checkAlive over HTTPS (%s)", err)
+	}
+	if err := checkAlive(e, "", time.Minute, time.Second); err != nil {
+		t.Errorf("Error in checkAlive without /healthz (%s)", err)
+	}
+}
+
+func TestLoopbackAddr(t *testing.T) {
+	for addr, want := range map[string]string{
+		"[::]:8080":      "[::1]:8080",
+		"0.0.0.0:8080":   "127.0.0.1:8080",
+		"10.0.0.1:9134":  "10.0.0.1:9134",
+		"localhost:8080": "localhost:8080",
+	} {
+		if got := loopbackAddr(addr); got != want {
+			t.Errorf("Incorrect loopback address of %s (%s), should be %s", addr, got, want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// webTLS is the TLS of the HTTP server from the --web.tls-* flags. With
+// a client CA every client has to present a certificate signed by it, and
+// with allowed names also one for one of those names, or the connection is
+// rejected during the handshake, before any request is read.
+type webTLS struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	// allowedNames are the common names and subject alternative names of
+	// the client certificates to accept; empty accepts any the CA signed.
+	allowedNames []string
+
+	cert      tls.Certificate
+	clientCAs *x509.CertPool
+	failures  *prometheus.CounterVec
+}
+
+// Reasons of zfs_exporter_tls_client_verification_failures_total.
+const (
+	tlsNoCertificate      = "no_certificate"
+	tlsInvalidCertificate = "invalid_certificate"
+	tlsUntrusted          = "untrusted"
+	tlsNameNotAllowed     = "name_not_allowed"
+)
+
+func (w *webTLS) enabled() bool {
+	return w.certFile != ""
+}
+
+// clientAuth reports whether clients have to present a certificate.
+func (w *webTLS) clientAuth() bool {
+	return w.clientCAFile != ""
+}
+
+func (w *webTLS) validate() error {
+	switch {
+	case (w.certFile == "") != (w.keyFile == ""):
+		return errors.New("-web.tls-cert-file and -web.tls-key-file should be given together")
+	case w.clientCAFile != "" && w.certFile == "":
+		return errors.New("-web.tls-client-ca-file needs -web.tls-cert-file and -web.tls-key-file")
+	case len(w.allowedNames) > 0 && w.clientCAFile == "":
+		return errors.New("-web.tls-client-name needs -web.tls-client-ca-file")
+	}
+	return nil
+}
+
+// load reads the certificate, its key and the client CA bundle. They are
+// read once at startup, before dropping privileges, so that the key can be
+// readable by root alone.
+func (w *webTLS) load() error {
+	cert, err := tls.LoadX509KeyPair(w.certFile, w.keyFile)
+	if err != nil {
+		return fmt.Errorf("-web.tls-cert-file: %s", err)
+	}
+	w.cert = cert
+	if w.clientCAFile == "" {
+		return nil
+	}
+	pem, err := os.ReadFile(w.clientCAFile)
+	if err != nil {
+		return fmt.Errorf("-web.tls-client-ca-file: %s", err)
+	}
+	w.clientCAs = x509.NewCertPool()
+	if !w.clientCAs.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("-web.tls-client-ca-file: no PEM certificates in %s", w.clientCAFile)
+	}
+	w.failures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "zfs_exporter_tls_client_verification_failures_total",
+		Help: "Number of TLS handshakes rejected for the client certificate, by reason: no_certificate, invalid_certificate, untrusted (not signed by --web.tls-client-ca-file or expired) or name_not_allowed",
+	}, []string{"reason"})
+	for _, reason := range []string{tlsNoCertificate, tlsInvalidCertificate, tlsUntrusted, tlsNameNotAllowed} {
+		w.failures.WithLabelValues(reason)
+	}
+	return nil
+}
+
+// register registers the verification failures, which only exist with a
+// client CA.
+func (w *webTLS) register(reg prometheus.Registerer) error {
+	if w.failures == nil {
+		return nil
+	}
+	return reg.Register(w.failures)
+}
+
+// config returns the server configuration. The client certificate is
+// requested rather than required, so that verifyClient sees every
+// handshake, including those without one, and counts why it failed.
+func (w *webTLS) config() *tls.Config {
+	c := &tls.Config{
+		Certificates: []tls.Certificate{w.cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if w.clientAuth() {
+		c.ClientAuth = tls.RequestClientCert
+		c.VerifyPeerCertificate = w.verifyClient
+	}
+	return c
+}
+
+// listen wraps the listeners in TLS.
+func (w *webTLS) listen(listeners []net.Listener) []net.Listener {
+	c := w.config()
+	wrapped := make([]net.Listener, len(listeners))
+	for i, l := range listeners {
+		wrapped[i] = tls.NewListener(l, c)
+	}
+	return wrapped
+}
+
+// verifyClient verifies the certificate chain the client presented against
+// the client CAs and its names against allowedNames.
+func (w *webTLS) verifyClient(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	reason, err := w.checkClient(rawCerts)
+	if err != nil {
+		w.failures.WithLabelValues(reason).Inc()
+	}
+	return err
+}
+
+func (w *webTLS) checkClient(rawCerts [][]byte) (reason string, err error) {
+	if len(rawCerts) == 0 {
+		return tlsNoCertificate, errors.New("no client certificate")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		if certs[i], err = x509.ParseCertificate(raw); err != nil {
+			return tlsInvalidCertificate, fmt.Errorf("invalid client certificate: %s", err)
+		}
+	}
+	opts := x509.VerifyOptions{
+		Roots:         w.clientCAs,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(opts); err != nil {
+		return tlsUntrusted, fmt.Errorf("client certificate: %s", err)
+	}
+	if len(w.allowedNames) > 0 && !w.allowed(certs[0]) {
+		return tlsNameNotAllowed, fmt.Errorf("client certificate for %q is not for an allowed name", certs[0].Subject.CommonName)
+	}
+	return "", nil
+}
+
+// allowed reports whether the common name or a DNS, email, IP or URI
+// subject alternative name of cert is one of allowedNames.
+func (w *webTLS) allowed(cert *x509.Certificate) bool {
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	for _, name := range names {
+		if name != "" && stringInSlice(name, w.allowedNames) {
+			return true
+		}
+	}
+	return false
+}
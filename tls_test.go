@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testCert is a certificate with its key, issued by testIssue.
+type testCert struct {
+	cert *x509.Certificate
+	der  []byte
+	key  *ecdsa.PrivateKey
+}
+
+// testIssue issues a certificate for cn from parent, or a self-signed CA
+// when parent is nil.
+func testIssue(t *testing.T, parent *testCert, cn string, usage x509.ExtKeyUsage, dnsNames ...string) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		template.ExtKeyUsage = nil
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, der: der, key: key}
+}
+
+// writePEM writes the certificate and its key to files in dir.
+func (c *testCert) writePEM(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestWebTLSValidate(t *testing.T) {
+	for _, test := range []struct {
+		tls webTLS
+		ok  bool
+	}{
+		{webTLS{}, true},
+		{webTLS{certFile: "cert.pem", keyFile: "key.pem"}, true},
+		{webTLS{certFile: "cert.pem", keyFile: "key.pem", clientCAFile: "ca.pem", allowedNames: []string{"prometheus"}}, true},
+		{webTLS{certFile: "cert.pem"}, false},
+		{webTLS{keyFile: "key.pem"}, false},
+		{webTLS{clientCAFile: "ca.pem"}, false},
+		{webTLS{certFile: "cert.pem", keyFile: "key.pem", allowedNames: []string{"prometheus"}}, false},
+	} {
+		if err := test.tls.validate(); (err == nil) != test.ok {
+			t.Errorf("Incorrect validation of %+v (%v), should be ok: %t", test.tls, err, test.ok)
+		}
+	}
+}
+
+func TestWebTLSLoad(t *testing.T) {
+	dir := t.TempDir()
+	ca := testIssue(t, nil, "ca", 0)
+	caFile, _ := ca.writePEM(t, dir, "ca")
+	certFile, keyFile := testIssue(t, ca, "localhost", x509.ExtKeyUsageServerAuth, "localhost").writePEM(t, dir, "server")
+
+	w := webTLS{certFile: certFile, keyFile: keyFile, clientCAFile: caFile}
+	if err := w.load(); err != nil {
+		t.Fatalf("Error in load (%s)", err)
+	}
+	if w.failures == nil {
+		t.Errorf("A client CA should create the verification failure counter")
+	}
+	if err := (&webTLS{certFile: certFile, keyFile: keyFile}).load(); err != nil {
+		t.Errorf("Error in load without client CA (%s)", err)
+	}
+	if err := (&webTLS{certFile: certFile, keyFile: caFile}).load(); err == nil {
+		t.Errorf("Mismatched key should produce error in load")
+	}
+	if err := (&webTLS{certFile: certFile, keyFile: keyFile, clientCAFile: keyFile}).load(); err == nil || !strings.Contains(err.Error(), "no PEM certificates") {
+		t.Errorf("Client CA file without certificates should produce error in load, got %v", err)
+	}
+}
+
+func TestClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca := testIssue(t, nil, "ca", 0)
+	caFile, _ := ca.writePEM(t, dir, "ca")
+	certFile, keyFile := testIssue(t, ca, "localhost", x509.ExtKeyUsageServerAuth, "localhost").writePEM(t, dir, "server")
+	w := webTLS{certFile: certFile, keyFile: keyFile, clientCAFile: caFile, allowedNames: []string{"prometheus.example.com"}}
+	if err := w.load(); err != nil {
+		t.Fatalf("Error in load (%s)", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listeners := w.listen([]net.Listener{l})
+	server := &http.Server{Handler: http.HandlerFunc(serveHealthy), ErrorLog: log.New(io.Discard, "", 0)}
+	go server.Serve(listeners[0])
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(certs ...tls.Certificate) error {
+		client := http.Client{Timeout: 5 * time.Second, Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: certs},
+		}}
+		resp, err := client.Get("https://" + l.Addr().String() + "/healthz")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	other := testIssue(t, nil, "other ca", 0)
+	for _, test := range []struct {
+		name   string
+		certs  []tls.Certificate
+		reason string // "" when accepted
+	}{
+		{"allowed SAN", []tls.Certificate{testIssue(t, ca, "scraper", x509.ExtKeyUsageClientAuth, "prometheus.example.com").tlsCertificate()}, ""},
+		{"allowed CN", []tls.Certificate{testIssue(t, ca, "prometheus.example.com", x509.ExtKeyUsageClientAuth).tlsCertificate()}, ""},
+		{"no certificate", nil, tlsNoCertificate},
+		{"other CA", []tls.Certificate{testIssue(t, other, "prometheus.example.com", x509.ExtKeyUsageClientAuth).tlsCertificate()}, tlsUntrusted},
+		{"server certificate", []tls.Certificate{testIssue(t, ca, "prometheus.example.com", x509.ExtKeyUsageServerAuth).tlsCertificate()}, tlsUntrusted},
+		{"other name", []tls.Certificate{testIssue(t, ca, "grafana.example.com", x509.ExtKeyUsageClientAuth).tlsCertificate()}, tlsNameNotAllowed},
+	} {
+		var before float64
+		if test.reason != "" {
+			before = testutil.ToFloat64(w.failures.WithLabelValues(test.reason))
+		}
+		err := get(test.certs...)
+		switch {
+		case test.reason == "" && err != nil:
+			t.Errorf("Client with %s should be accepted, got %s", test.name, err)
+		case test.reason != "" && err == nil:
+			t.Errorf("Client with %s should be rejected", test.name)
+		case test.reason != "":
+			if got := testutil.ToFloat64(w.failures.WithLabelValues(test.reason)) - before; got != 1 {
+				t.Errorf("Client with %s should count 1 %s failure, got %v", test.name, test.reason, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var zpoolTransitionsDesc = prometheus.NewDesc("zpool_state_transitions_total",
+	"Number of times the health of the zpool changed from one state to another since the exporter started", []string{"name", "from", "to"}, nil)
+
+var zpoolUnhealthyDesc = prometheus.NewDesc("zpool_unhealthy_seconds_total",
+	"Seconds the zpool spent in the state other than ONLINE since the exporter started, observed between collections", []string{"name", "state"}, nil)
+
+// stateTransition is a change of the health of a pool, such as from ONLINE
+// to DEGRADED.
+type stateTransition struct {
+	pool, from, to string
+}
+
+// transitionCount counts one stateTransition since it was first seen.
+type transitionCount struct {
+	count   float64
+	created time.Time
+}
+
+// unhealthyTime accumulates the seconds one pool spent in one state other
+// than ONLINE.
+type unhealthyTime struct {
+	seconds float64
+	created time.Time
+}
+
+// poolState is a pool in one health state.
+type poolState struct {
+	pool, state string
+}
+
+// trackedUnhealthyStates, every health of poolHealthStates but ONLINE, always
+// have a zpool_unhealthy_seconds_total series for every pool seen, starting
+// at 0, so that increase() also covers the first time a pool is in them.
+var trackedUnhealthyStates = poolHealthStates[1:]
+
+// healthTracker remembers the health of every pool across scrapes, counts
+// its changes and accumulates the time spent in each state other than
+// ONLINE, so that a pool flapping between states in between scrapes of a
+// human still shows up, and the time a pool was degraded can be read from
+// sparse scrapes. Pools are tracked by name, which keeps the counts when the
+// pools are set up again. The zero value is ready to use; it is not safe for
+// concurrent use, which is fine since only one collection of the Exporter
+// runs at a time.
+type healthTracker struct {
+	last        map[string]string
+	transitions map[stateTransition]*transitionCount
+	// since holds when each pool was last observed, the start of the
+	// interval the next observation accounts for.
+	since     map[string]time.Time
+	unhealthy map[poolState]*unhealthyTime
+}
+
+// observe records the current health of pools at now. The first health seen
+// of a pool is not a transition, and pools that failed to collect are
+// skipped.
+//
+// The time since the previous observation of a pool is added to the state it
+// was in; when the state changed, the change happened at an unknown point of
+// the interval, and each state gets half of it. Time is only accounted
+// between two successful observations: a pool that failed to collect, or
+// that is no longer monitored, starts over at its next observation.
+func (t *healthTracker) observe(pools []zpool, now time.Time) {
+	if t.last == nil {
+		t.last = map[string]string{}
+		t.transitions = map[stateTransition]*transitionCount{}
+		t.unhealthy = map[poolState]*unhealthyTime{}
+	}
+	if t.since == nil {
+		t.since = map[string]time.Time{}
+	}
+	observed := map[string]bool{}
+	for _, pool := range pools {
+		if pool.err != nil {
+			delete(t.since, pool.name)
+			continue
+		}
+		observed[pool.name] = true
+		for _, state := range trackedUnhealthyStates {
+			t.addUnhealthy(pool.name, state, 0, now)
+		}
+		prev, seen := t.last[pool.name]
+		t.last[pool.name] = pool.health
+		if at, ok := t.since[pool.name]; ok && seen {
+			elapsed := now.Sub(at).Seconds()
+			if prev == pool.health {
+				t.addUnhealthy(pool.name, prev, elapsed, now)
+			} else {
+				t.addUnhealthy(pool.name, prev, elapsed/2, now)
+				t.addUnhealthy(pool.name, pool.health, elapsed/2, now)
+			}
+		}
+		t.since[pool.name] = now
+		if !seen || prev == pool.health {
+			continue
+		}
+		key := stateTransition{pool.name, prev, pool.health}
+		c, ok := t.transitions[key]
+		if !ok {
+			c = &transitionCount{created: now}
+			t.transitions[key] = c
+		}
+		c.count++
+	}
+	for name := range t.since {
+		if !observed[name] {
+			delete(t.since, name)
+		}
+	}
+}
+
+// addUnhealthy adds seconds to the time pool spent in state, unless the
+// state is ONLINE.
+func (t *healthTracker) addUnhealthy(pool, state string, seconds float64, now time.Time) {
+	if state == "ONLINE" {
+		return
+	}
+	key := poolState{pool, state}
+	u, ok := t.unhealthy[key]
+	if !ok {
+		u = &unhealthyTime{created: now}
+		t.unhealthy[key] = u
+	}
+	if seconds > 0 {
+		u.seconds += seconds
+	}
+}
+
+// interrupt forgets when the pools were last observed, after a collection
+// failed, so that the time zpool could not tell the health of the pools is
+// not added to any state.
+func (t *healthTracker) interrupt() {
+	t.since = nil
+}
+
+func (t *healthTracker) collect(ch chan<- prometheus.Metric) {
+	for key, c := range t.transitions {
+		ch <- prometheus.MustNewConstMetricWithCreatedTimestamp(zpoolTransitionsDesc, prometheus.CounterValue, c.count, c.created, key.pool, key.from, key.to)
+	}
+	for key, u := range t.unhealthy {
+		ch <- prometheus.MustNewConstMetricWithCreatedTimestamp(zpoolUnhealthyDesc, prometheus.CounterValue, u.seconds, u.created, key.pool, key.state)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestHealthTracker(t *testing.T) {
+	var h healthTracker
+	now := time.Unix(1700000000, 0)
+	for _, states := range [][]string{
+		{"ONLINE", "ONLINE"},
+		{"DEGRADED", "ONLINE"},
+		{"ONLINE", "ONLINE"},
+		{"DEGRADED", "FAULTED"},
+		{"ONLINE", "FAULTED"},
+	} {
+		h.observe([]zpool{{name: "tank", health: states[0]}, {name: "backup", health: states[1]}}, now)
+	}
+
+	ch := make(chan prometheus.Metric, 20)
+	h.collect(ch)
+	close(ch)
+	got := map[string]float64{}
+	for m := range ch {
+		if m.Desc() == zpoolTransitionsDesc {
+			got[metricLabel(m, "name")+" "+metricLabel(m, "from")+">"+metricLabel(m, "to")] = metricValue(m)
+		}
+	}
+	want := map[string]float64{
+		"tank ONLINE>DEGRADED":  2,
+		"tank DEGRADED>ONLINE":  2,
+		"backup ONLINE>FAULTED": 1,
+	}
+	if len(got) != len(want) {
+		t.Errorf("Incorrect transitions %v, should be %v", got, want)
+	}
+	for key, v := range want {
+		if got[key] != v {
+			t.Errorf("Incorrect %s transitions (%v), should be %v", key, got[key], v)
+		}
+	}
+}
+
+// TestSuspendedTransitions checks that a pool suspended from zpool list, as
+// it parses, and resumed counts both transitions.
+func TestSuspendedTransitions(t *testing.T) {
+	var h healthTracker
+	now := time.Unix(1700000000, 0)
+	for i, health := range []string{"ONLINE", "SUSPENDED", "ONLINE"} {
+		pools := []zpool{{name: "tank"}}
+		row := "tank\t11988103774208\t6118856933376\t5869246840832\t51\t12\t" + health + "\toff\t-\t-\n"
+		if err := parseZpoolList(row, pools); err != nil {
+			t.Fatalf("Error in parseZpoolList of a %s pool (%s)", health, err)
+		}
+		h.observe(pools, now.Add(time.Duration(i)*time.Minute))
+	}
+	ch := make(chan prometheus.Metric, 50)
+	h.collect(ch)
+	close(ch)
+	got := map[string]float64{}
+	for m := range ch {
+		if m.Desc() == zpoolTransitionsDesc {
+			got[metricLabel(m, "from")+">"+metricLabel(m, "to")] = metricValue(m)
+		}
+	}
+	if len(got) != 2 || got["ONLINE>SUSPENDED"] != 1 || got["SUSPENDED>ONLINE"] != 1 {
+		t.Errorf("Incorrect transitions %v, should be ONLINE>SUSPENDED and SUSPENDED>ONLINE", got)
+	}
+}
+
+func TestUnhealthyTime(t *testing.T) {
+	var h healthTracker
+	start := time.Unix(1700000000, 0)
+	for _, step := range []struct {
+		minutes int
+		pools   []zpool
+		failed  bool
+	}{
+		{0, []zpool{{name: "tank", health: "ONLINE"}, {name: "backup", health: "DEGRADED"}}, false},
+		// tank changed somewhere in the interval, half of it counts
+		{10, []zpool{{name: "tank", health: "DEGRADED"}, {name: "backup", health: "DEGRADED"}}, false},
+		// a missed scrape changes nothing
+		{30, []zpool{{name: "tank", health: "DEGRADED"}, {name: "backup", health: "DEGRADED"}}, false},
+		// failed collections do not count, for one pool or all
+		{40, []zpool{{name: "tank", health: "DEGRADED"}, {name: "backup", err: errors.New("gone")}}, false},
+		{50, nil, true},
+		{60, []zpool{{name: "tank", health: "FAULTED"}, {name: "backup", health: "DEGRADED"}}, false},
+		{70, []zpool{{name: "tank", health: "FAULTED"}, {name: "backup", health: "DEGRADED"}}, false},
+		// backup is no longer monitored, and starts over when it is again
+		{80, []zpool{{name: "tank", health: "ONLINE"}}, false},
+		{90, []zpool{{name: "tank", health: "ONLINE"}, {name: "backup", health: "DEGRADED"}}, false},
+	} {
+		if step.failed {
+			h.interrupt()
+			continue
+		}
+		h.observe(step.pools, start.Add(time.Duration(step.minutes)*time.Minute))
+	}
+
+	ch := make(chan prometheus.Metric, 50)
+	h.collect(ch)
+	close(ch)
+	got := map[string]float64{}
+	for m := range ch {
+		if m.Desc() == zpoolUnhealthyDesc {
+			got[metricLabel(m, "name")+" "+metricLabel(m, "state")] = metricValue(m) / 60
+		}
+	}
+	want := map[string]float64{
+		"tank DEGRADED":   5 + 20 + 10,
+		"tank FAULTED":    10 + 5,
+		"backup DEGRADED": 10 + 20 + 10,
+	}
+	for _, state := range trackedUnhealthyStates {
+		for _, pool := range []string{"tank", "backup"} {
+			if _, ok := want[pool+" "+state]; !ok {
+				want[pool+" "+state] = 0
+			}
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("Incorrect unhealthy minutes %v, should be %v", got, want)
+	}
+	for key, v := range want {
+		if got[key] != v {
+			t.Errorf("Incorrect %s minutes (%v), should be %v", key, got[key], v)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/proto"
+)
+
+const responseTruncatedName = "zfs_exporter_response_truncated"
+
+// truncationOrder lists, by the prefixes of their names in either version of
+// the names, the families a truncatingGatherer drops first: snapshots, then
+// datasets, then the devices of the pools, and the pools last. The other
+// families, such as those of the exporter itself, are never dropped.
+var truncationOrder = [][]string{
+	{"zfs_snapshot_"},
+	{"zfs_dataset_", "zfs_volume_", "zfs_filesystem_"},
+	{"zpool_device_", "zpool_vdev_", "zpool_iostat_device_", "zfs_pool_device_", "zfs_pool_vdev_", "zfs_kmem_", "zfs_module_"},
+	{"zpool_", "zfs_pool_", "zfs_pools"},
+}
+
+// truncationRank returns the index in truncationOrder of the family name,
+// and false for a family that is never dropped.
+func truncationRank(name string) (int, bool) {
+	for rank, prefixes := range truncationOrder {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(name, prefix) {
+				return rank, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+// textSize returns the size of family in the text format.
+func textSize(family *dto.MetricFamily) int64 {
+	var w countingWriter
+	expfmt.MetricFamilyToText(&w, family)
+	return int64(w)
+}
+
+// truncatingGatherer drops whole families, in truncationOrder and the
+// largest first within a rank, from what the wrapped Gatherer returns until
+// the rest fits into maxBytes in the text format, rather than letting a
+// scrape fail on a payload cut in the middle. It adds
+// zfs_exporter_response_truncated with labels, 1 when it dropped any. It
+// wraps the filteringGatherer, so it sees the exported families.
+type truncatingGatherer struct {
+	prometheus.Gatherer
+	maxBytes int64
+	labels   prometheus.Labels
+}
+
+func (g truncatingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	sizes := make(map[*dto.MetricFamily]int64, len(families))
+	var total int64
+	var droppable []*dto.MetricFamily
+	for _, family := range families {
+		sizes[family] = textSize(family)
+		total += sizes[family]
+		if _, ok := truncationRank(family.GetName()); ok {
+			droppable = append(droppable, family)
+		}
+	}
+	sort.SliceStable(droppable, func(i, j int) bool {
+		a, _ := truncationRank(droppable[i].GetName())
+		b, _ := truncationRank(droppable[j].GetName())
+		if a != b {
+			return a < b
+		}
+		return sizes[droppable[i]] > sizes[droppable[j]]
+	})
+	truncated := g.truncated(0)
+	total += textSize(truncated)
+	dropped := map[*dto.MetricFamily]bool{}
+	for _, family := range droppable {
+		if total <= g.maxBytes {
+			break
+		}
+		dropped[family] = true
+		total -= sizes[family]
+	}
+	kept := families[:0]
+	for _, family := range families {
+		if !dropped[family] {
+			kept = append(kept, family)
+		}
+	}
+	if len(dropped) > 0 {
+		truncated = g.truncated(1)
+	}
+	i := sort.Search(len(kept), func(i int) bool { return kept[i].GetName() >= responseTruncatedName })
+	kept = append(kept, nil)
+	copy(kept[i+1:], kept[i:])
+	kept[i] = truncated
+	return kept, err
+}
+
+// truncated returns the zfs_exporter_response_truncated family with value.
+func (g truncatingGatherer) truncated(value float64) *dto.MetricFamily {
+	m := &dto.Metric{Gauge: &dto.Gauge{Value: proto.Float64(value)}}
+	for name, value := range g.labels {
+		m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+	}
+	sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+	return &dto.MetricFamily{
+		Name:   proto.String(responseTruncatedName),
+		Help:   proto.String("1 when families were left out of this response, snapshots and datasets before pools, to stay within --web.max-response-bytes"),
+		Type:   dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{m},
+	}
+}
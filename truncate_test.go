@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestTruncatingGatherer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	gauges := map[string]*prometheus.GaugeVec{}
+	for _, name := range []string{"zfs_exporter_up", "zpool_up", "zpool_device_read_errors", "zfs_dataset_used_bytes", "zfs_dataset_available_bytes", "zfs_snapshot_used_bytes"} {
+		gauges[name] = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: "Help"}, []string{"name"})
+		reg.MustRegister(gauges[name])
+	}
+	series := func(name string, n int) {
+		for i := 0; i < n; i++ {
+			gauges[name].WithLabelValues("tank/" + strings.Repeat("x", i)).Set(1)
+		}
+	}
+	series("zfs_exporter_up", 1)
+	series("zpool_up", 2)
+	series("zpool_device_read_errors", 4)
+	series("zfs_dataset_used_bytes", 10)
+	series("zfs_dataset_available_bytes", 8)
+	series("zfs_snapshot_used_bytes", 20)
+
+	// gather returns the families of g and the value of
+	// zfs_exporter_response_truncated.
+	gather := func(maxBytes int64) (names []string, truncated float64) {
+		t.Helper()
+		families, err := truncatingGatherer{reg, maxBytes, prometheus.Labels{"cluster": "eu1"}}.Gather()
+		if err != nil {
+			t.Fatalf("Error in Gather (%s)", err)
+		}
+		var size int64
+		for i, family := range families {
+			size += textSize(family)
+			if i > 0 && families[i-1].GetName() >= family.GetName() {
+				t.Errorf("Families should stay sorted, got %s before %s", families[i-1].GetName(), family.GetName())
+			}
+			if family.GetName() != responseTruncatedName {
+				names = append(names, family.GetName())
+				continue
+			}
+			m := family.GetMetric()[0]
+			if len(m.GetLabel()) != 1 || m.GetLabel()[0].GetValue() != "eu1" {
+				t.Errorf("Incorrect labels of %s (%v)", responseTruncatedName, m.GetLabel())
+			}
+			truncated = m.GetGauge().GetValue()
+		}
+		if size > maxBytes && len(names) > 1 {
+			t.Errorf("Response of %d bytes should fit into %d", size, maxBytes)
+		}
+		return names, truncated
+	}
+
+	all, truncated := gather(1 << 20)
+	if len(all) != 6 || truncated != 0 {
+		t.Errorf("Nothing should be truncated below the limit, got %v, %v", all, truncated)
+	}
+	families, _ := reg.Gather()
+	sizes := map[string]int64{}
+	var total int64
+	for _, family := range families {
+		sizes[family.GetName()] = textSize(family)
+		total += sizes[family.GetName()]
+	}
+
+	for _, test := range []struct {
+		dropped []string
+		want    []string
+	}{
+		{[]string{"zfs_snapshot_used_bytes"},
+			[]string{"zfs_dataset_available_bytes", "zfs_dataset_used_bytes", "zfs_exporter_up", "zpool_device_read_errors", "zpool_up"}},
+		// The larger of the datasets families goes first.
+		{[]string{"zfs_snapshot_used_bytes", "zfs_dataset_used_bytes"},
+			[]string{"zfs_dataset_available_bytes", "zfs_exporter_up", "zpool_device_read_errors", "zpool_up"}},
+		{[]string{"zfs_snapshot_used_bytes", "zfs_dataset_used_bytes", "zfs_dataset_available_bytes", "zpool_device_read_errors"},
+			[]string{"zfs_exporter_up", "zpool_up"}},
+		{[]string{"zfs_snapshot_used_bytes", "zfs_dataset_used_bytes", "zfs_dataset_available_bytes", "zpool_device_read_errors", "zpool_up"},
+			[]string{"zfs_exporter_up"}},
+	} {
+		maxBytes := total + textSize(truncatingGatherer{labels: prometheus.Labels{"cluster": "eu1"}}.truncated(1))
+		for _, name := range test.dropped {
+			maxBytes -= sizes[name]
+		}
+		names, truncated := gather(maxBytes)
+		if strings.Join(names, ",") != strings.Join(test.want, ",") || truncated != 1 {
+			t.Errorf("Incorrect families at %d bytes (%v, %v), should be %v truncated", maxBytes, names, truncated, test.want)
+		}
+	}
+
+	// The metrics of the exporter itself are never dropped.
+	if names, truncated := gather(1); len(names) != 1 || names[0] != "zfs_exporter_up" || truncated != 1 {
+		t.Errorf("Only the metrics of the exporter should be left, got %v, %v", names, truncated)
+	}
+}
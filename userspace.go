@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// spaceKind describes one of the zfs *space subcommands reporting space
+// accounting per user or group.
+type spaceKind struct {
+	command   string // zfs subcommand
+	label     string // label holding the user or group name
+	usedDesc  *prometheus.Desc
+	quotaDesc *prometheus.Desc
+}
+
+func newSpaceKind(command, label string) spaceKind {
+	return spaceKind{
+		command: command,
+		label:   label,
+		usedDesc: prometheus.NewDesc("zfs_dataset_"+label+"_used_bytes",
+			"Space used in the dataset by the "+label, []string{"dataset", label}, nil),
+		quotaDesc: prometheus.NewDesc("zfs_dataset_"+label+"_quota_bytes",
+			"Quota of the "+label+" in the dataset, absent (0 with --strict-zero) when no quota is set", []string{"dataset", label}, nil),
+	}
+}
+
+// list is the zfs command listing the space of dataset.
+func (k spaceKind) list(dataset string) command {
+	return command{"zfs", []string{k.command, "-Hp", "-o", "name,used,quota", dataset}}
+}
+
+var spaceKinds = []spaceKind{
+	newSpaceKind("userspace", "user"),
+	newSpaceKind("groupspace", "group"),
+}
+
+// projectSpaceKind reports project quotas, which older OpenZFS releases do
+// not support.
+var projectSpaceKind = newSpaceKind("projectspace", "project")
+
+// spaceUsage is one row of zfs userspace -Hp -o name,used,quota.
+type spaceUsage struct {
+	name  string
+	used  uint64
+	quota uint64 // 0 when no quota is set
+}
+
+// parseSpace parses zfs userspace/groupspace/projectspace -Hp -o
+// name,used,quota output.
+func parseSpace(output string) ([]spaceUsage, error) {
+	var usage []spaceUsage
+	for lines := newLineScanner(strings.TrimSpace(output)); lines.scan(); {
+		if lines.line == "" {
+			continue
+		}
+		fields := strings.Split(lines.line, "\t")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("expected 3 columns, got %d", len(fields))
+		}
+		u := spaceUsage{name: fields[0]}
+		var err error
+		if u.used, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+			return nil, err
+		}
+		if fields[2] != "none" && fields[2] != "-" {
+			if u.quota, err = strconv.ParseUint(fields[2], 10, 64); err != nil {
+				return nil, err
+			}
+		}
+		usage = append(usage, u)
+	}
+	return usage, nil
+}
+
+// spaceCollector exports per-user, per-group and per-project space usage and
+// quotas for an explicit list of datasets. Every user with files in a dataset
+// becomes a series, so datasets have to be opted in one by one.
+type spaceCollector struct {
+	datasets []string
+	kinds    []spaceKind
+}
+
+func newSpaceCollector(datasets []string) *spaceCollector {
+	return &spaceCollector{datasets: datasets, kinds: append([]spaceKind(nil), spaceKinds...)}
+}
+
+// enableProjects enables project quotas when zfs supports the projectspace
+// subcommand, as probed at startup, so that older releases do not log an
+// error on every scrape.
+func (c *spaceCollector) enableProjects(supported bool) bool {
+	if len(c.datasets) == 0 || !supported {
+		return false
+	}
+	c.kinds = append(c.kinds, projectSpaceKind)
+	return true
+}
+
+func (c *spaceCollector) describe(ch chan<- *prometheus.Desc) {
+	for _, kind := range c.kinds {
+		ch <- kind.usedDesc
+		ch <- kind.quotaDesc
+	}
+}
+
+func (c *spaceCollector) plan([]zpool) []plannedCommand {
+	var plan []plannedCommand
+	for _, dataset := range c.datasets {
+		for _, kind := range c.kinds {
+			plan = append(plan, plannedCommand{kind.list(dataset), ""})
+		}
+	}
+	return plan
+}
+
+// collect runs a zfs userspace, groupspace and projectspace per dataset.
+// Failures for one dataset do not prevent the others from being exported.
+func (c *spaceCollector) collect(r commandRunner, _ []zpool, ch chan<- prometheus.Metric) error {
+	var errs []string
+	for _, dataset := range c.datasets {
+		for _, kind := range c.kinds {
+			output, err := kind.list(dataset).run(r)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("zfs %s %s: %s", kind.command, dataset, strings.TrimSpace(output)))
+				continue
+			}
+			usage, err := parseSpace(output)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("zfs %s %s: %s", kind.command, dataset, err))
+				continue
+			}
+			for _, u := range usage {
+				emitAlways(ch, kind.usedDesc, float64(u.used), dataset, u.name)
+				emitIfPresent(ch, kind.quotaDesc, float64(u.quota), u.quota > 0, dataset, u.name)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseSpace(t *testing.T) {
+	usage, err := parseSpace("alice\t5368709120\t10737418240\nbob\t1024\tnone\n")
+	if err != nil {
+		t.Fatalf("Error in parseSpace (%s)", err)
+	}
+	if len(usage) != 2 {
+		t.Fatalf("Incorrect amount of rows (%d), should be 2.", len(usage))
+	}
+	if usage[0].name != "alice" || usage[0].used != 5368709120 || usage[0].quota != 10737418240 {
+		t.Errorf("Incorrect usage for alice: %+v", usage[0])
+	}
+	if usage[1].quota != 0 {
+		t.Errorf("Incorrect quota for bob (%d), should be 0 for none", usage[1].quota)
+	}
+
+	if _, err := parseSpace("alice\t5G\tnone\n"); err == nil {
+		t.Errorf("Non-integer should produce error in parseSpace")
+	}
+}
+
+func TestSpaceCollector(t *testing.T) {
+	r := staticRunner{
+		"zfs userspace -Hp -o name,used,quota tank/home":  "alice\t5368709120\t10737418240\nbob\t1024\tnone\n",
+		"zfs groupspace -Hp -o name,used,quota tank/home": "staff\t5368710144\tnone\n",
+	}
+	c := newSpaceCollector([]string{"tank/home"})
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		if err := c.collect(r, nil, ch); err != nil {
+			t.Errorf("Error in collect (%s)", err)
+		}
+		close(ch)
+	}()
+	names := map[string]int{}
+	for m := range ch {
+		names[descName(m.Desc())]++
+	}
+	for name, want := range map[string]int{
+		"zfs_dataset_user_used_bytes":   2,
+		"zfs_dataset_user_quota_bytes":  1, // bob has no quota
+		"zfs_dataset_group_used_bytes":  1,
+		"zfs_dataset_group_quota_bytes": 0,
+	} {
+		if names[name] != want {
+			t.Errorf("Incorrect amount of %s series (%d), should be %d.", name, names[name], want)
+		}
+	}
+}
+
+// unsupportedRunner fails every command the way zfs does for an unknown
+// subcommand.
+type unsupportedRunner struct{ staticRunner }
+
+func (u unsupportedRunner) run(name string, args ...string) (string, error) {
+	if args[0] == "projectspace" {
+		return "unrecognized command 'projectspace'\nusage: zfs command args ...\n", errors.New("exit status 2")
+	}
+	return u.staticRunner.run(name, args...)
+}
+
+func TestSpaceCollectorProjects(t *testing.T) {
+	c := newSpaceCollector([]string{"tank/home"})
+	if c.enableProjects(probeProjectspace(unsupportedRunner{}, "tank/home")) {
+		t.Errorf("projectspace should be disabled when zfs does not know it")
+	}
+	if len(c.kinds) != 2 {
+		t.Errorf("Incorrect amount of kinds (%d), should be 2.", len(c.kinds))
+	}
+
+	r := staticRunner{
+		"zfs projectspace -H -o name tank/home":             "100\n",
+		"zfs projectspace -Hp -o name,used,quota tank/home": "100\t1048576\t2097152\n",
+	}
+	c = newSpaceCollector([]string{"tank/home"})
+	if !c.enableProjects(probeProjectspace(r, "tank/home")) {
+		t.Fatalf("projectspace should be enabled when zfs supports it")
+	}
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.collect(r, nil, ch)
+		close(ch)
+	}()
+	projects := 0
+	for m := range ch {
+		if m.Desc() == projectSpaceKind.quotaDesc && metricLabel(m, "project") == "100" {
+			projects++
+		}
+	}
+	if projects != 1 {
+		t.Errorf("Incorrect amount of project quota series (%d), should be 1.", projects)
+	}
+}
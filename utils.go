@@ -1,6 +1,12 @@
 package main
 
-import "strings"
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+)
 
 func substringInSlice(str string, list []string) bool {
 	for _, v := range list {
@@ -10,3 +16,105 @@ func substringInSlice(str string, list []string) bool {
 	}
 	return false
 }
+
+// hasAnyPrefix reports whether str starts with one of prefixes.
+func hasAnyPrefix(str string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(str, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// stringInSlice reports whether str is an element of list.
+func stringInSlice(str string, list []string) bool {
+	for _, v := range list {
+		if v == str {
+			return true
+		}
+	}
+	return false
+}
+
+// appendUnique appends str to list unless it is already present.
+func appendUnique(list []string, str string) []string {
+	if stringInSlice(str, list) {
+		return list
+	}
+	return append(list, str)
+}
+
+// boolToFloat returns 1 for true and 0 for false, for 0/1 gauges.
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func boolToInt32(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// lineScanner iterates over the lines of command output held in memory,
+// without the trailing newlines, including the \r of the \r\n line endings
+// of OpenZFS on Windows. Unlike strings.Split it does not allocate a slice
+// holding every line, which adds up for the outputs parsed on every scrape.
+type lineScanner struct {
+	rest string
+	line string
+}
+
+func newLineScanner(s string) *lineScanner {
+	return &lineScanner{rest: s}
+}
+
+// scan advances to the next line, and returns false after the last one.
+func (l *lineScanner) scan() bool {
+	if l.rest == "" {
+		return false
+	}
+	if i := strings.IndexByte(l.rest, '\n'); i >= 0 {
+		l.line, l.rest = l.rest[:i], l.rest[i+1:]
+	} else {
+		l.line, l.rest = l.rest, ""
+	}
+	l.line = strings.TrimSuffix(l.line, "\r")
+	return true
+}
+
+// sizeSuffixes are the binary unit suffixes used by zfs and zpool in human
+// readable output.
+const sizeSuffixes = "BKMGTPEZ"
+
+// parseHumanSize parses sizes such as "1.09T", "580M", "0B" or "0" as printed
+// by zpool status into bytes.
+func parseHumanSize(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+	multiplier := 1.0
+	if i := strings.IndexByte(sizeSuffixes, s[len(s)-1]); i >= 0 {
+		s = s[:len(s)-1]
+		for ; i > 0; i-- {
+			multiplier *= 1024
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || !(v >= 0) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return v * multiplier, nil
+}
+
+// debugf logs like log.Printf when -debug is set.
+func debugf(format string, args ...interface{}) {
+	if debugCheck {
+		log.Printf("Debug: "+format, args...)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// vdevStats holds the space accounting of one top-level vdev.
+type vdevStats struct {
+	name          string
+	class         string // heading above the vdev such as logs or special, empty for the normal class
+	size          uint64
+	alloc         uint64
+	fragmentation int64 // -1 when zpool does not report it
+}
+
+// capacityRatio returns the allocated fraction of the vdev, computed from the
+// exact byte counts rather than zpool's rounded percentage.
+func (v vdevStats) capacityRatio() float64 {
+	if v.size == 0 {
+		return 0
+	}
+	return float64(v.alloc) / float64(v.size)
+}
+
+// vdevClasses are the headings zpool list -v prints above the log, cache,
+// spare, special and dedup vdevs of a pool.
+var vdevClasses = []string{"logs", "cache", "spare", "spares", "special", "dedup"}
+
+// maxCapacityRatio returns the capacity ratio of the fullest vdev of the
+// normal class, the one ZFS allocates most data from and that slows the pool
+// down first when the vdevs differ in size, and false when there is none.
+func maxCapacityRatio(vdevs []vdevStats) (float64, bool) {
+	max, ok := 0.0, false
+	for _, v := range vdevs {
+		if v.class != "" || v.size == 0 {
+			continue
+		}
+		if ratio := v.capacityRatio(); !ok || ratio > max {
+			max, ok = ratio, true
+		}
+	}
+	return max, ok
+}
+
+// parseVdevList parses zpool list -v -Hp output into the top-level vdevs of
+// every pool. Pool rows start at the beginning of a line, vdev rows with a
+// tab, followed by the columns size, alloc, free, ckpoint, expandsz, frag,
+// cap, dedup and health; -o does not apply to them. zpool only reports space
+// for top-level vdevs, so leaf devices and the logs/cache/spare headings,
+// which show "-" as their allocation, are skipped, as are the indirect vdevs
+// of removed vdevs, which have no space of their own.
+func parseVdevList(output string) (map[string][]vdevStats, error) {
+	vdevs := map[string][]vdevStats{}
+	pool, class := "", ""
+	for lines := newLineScanner(output); lines.scan(); {
+		line := lines.line
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "\t") {
+			name := strings.Split(line, "\t")[0]
+			if pool != "" && stringInSlice(name, vdevClasses) {
+				class = name // the vdevs below still belong to pool
+				continue
+			}
+			pool, class = name, ""
+			vdevs[pool] = nil
+			continue
+		}
+		fields := strings.Split(line[1:], "\t")
+		if pool == "" || len(fields) < 7 {
+			return nil, fmt.Errorf("unexpected zpool list -v row %q", line)
+		}
+		if fields[1] == "-" || fields[2] == "-" || strings.HasPrefix(fields[0], "indirect-") {
+			continue
+		}
+		v := vdevStats{name: fields[0], class: class}
+		var err error
+		if v.size, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+			return nil, fmt.Errorf("vdev %s: %s", v.name, err)
+		}
+		if v.alloc, err = strconv.ParseUint(fields[2], 10, 64); err != nil {
+			return nil, fmt.Errorf("vdev %s: %s", v.name, err)
+		}
+		if v.fragmentation, err = parseFragmentation(fields[6]); err != nil {
+			return nil, fmt.Errorf("vdev %s: %s", v.name, err)
+		}
+		vdevs[pool] = append(vdevs[pool], v)
+	}
+	return vdevs, nil
+}
+
+// vdevListCommand is the zpool list -v of listVdevs.
+func vdevListCommand(pools []zpool) command {
+	return command{"zpool", append([]string{"list", "-v", "-Hp"}, poolNames(pools)...)}
+}
+
+// listVdevs collects the top-level vdevs of all pools with one zpool list -v.
+func listVdevs(r commandRunner, pools []zpool) error {
+	output, err := vdevListCommand(pools).run(r)
+	if err != nil {
+		return fmt.Errorf("zpool list -v: %s", strings.TrimSpace(output))
+	}
+	vdevs, err := parseVdevList(output)
+	if err != nil {
+		return err
+	}
+	for i := range pools {
+		pools[i].vdevs = vdevs[pools[i].name]
+	}
+	return nil
+}
+
+// vdevAshift is the ashift of one top-level vdev. vdev is empty when only the
+// ashift pool property is known.
+type vdevAshift struct {
+	vdev   string
+	ashift int64
+}
+
+// parseZdbConfig parses the ashift of every top-level vdev from the vdev_tree
+// in zdb -C output. Top-level vdevs are named the way zpool status shows them:
+// mirror-0 and raidz2-1 for groups, the device name for single disks.
+func parseZdbConfig(output string) ([]vdevAshift, error) {
+	var (
+		result     []vdevAshift
+		vdev       map[string]string
+		topIndent  = -1
+		propIndent int
+	)
+	flush := func() error {
+		if vdev == nil || vdev["type"] == "hole" || vdev["type"] == "indirect" || vdev["ashift"] == "" {
+			return nil
+		}
+		ashift, err := strconv.ParseInt(vdev["ashift"], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid ashift %q", vdev["ashift"])
+		}
+		result = append(result, vdevAshift{vdev: zdbVdevName(vdev), ashift: ashift})
+		return nil
+	}
+	for lines := newLineScanner(output); lines.scan(); {
+		line := lines.line
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		if strings.HasPrefix(trimmed, "children[") {
+			if topIndent < 0 {
+				topIndent = indent
+			}
+			if indent == topIndent {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+				vdev, propIndent = map[string]string{}, -1
+			}
+			continue
+		}
+		if vdev == nil {
+			continue
+		}
+		if indent <= topIndent {
+			break // past the vdev tree
+		}
+		if propIndent < 0 {
+			propIndent = indent
+		}
+		if indent != propIndent {
+			continue // properties of leaf devices
+		}
+		if i := strings.Index(trimmed, ": "); i > 0 {
+			vdev[trimmed[:i]] = strings.Trim(trimmed[i+2:], "'")
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	if topIndent < 0 {
+		return nil, fmt.Errorf("no vdev_tree in zdb -C output")
+	}
+	return result, nil
+}
+
+// zdbVdevName derives the name zpool status shows for a top-level vdev from
+// its zdb -C properties.
+func zdbVdevName(vdev map[string]string) string {
+	switch vdev["type"] {
+	case "disk", "file":
+		name := vdev["path"]
+		if i := strings.LastIndexByte(name, '/'); i >= 0 {
+			name = name[i+1:]
+		}
+		if vdev["whole_disk"] == "1" {
+			name = stripPartition(name)
+		}
+		return name
+	case "raidz":
+		return "raidz" + vdev["nparity"] + "-" + vdev["id"]
+	default:
+		return vdev["type"] + "-" + vdev["id"]
+	}
+}
+
+// stripPartition removes the partition zfs created on a whole disk from the
+// device name, as zpool does: sda1, nvme0n1p1, ata-X-part1 and c0t0d0s0
+// become sda, nvme0n1, ata-X and c0t0d0.
+func stripPartition(name string) string {
+	if i := strings.LastIndex(name, "-part"); i > 0 {
+		return name[:i]
+	}
+	end := strings.TrimRight(name, "0123456789")
+	if end == name || end == "" {
+		return name
+	}
+	last := end[len(end)-1]
+	if (last == 'p' || last == 's') && len(end) >= 2 && isDigit(end[len(end)-2]) {
+		return end[:len(end)-1] // nvme0n1p1, mmcblk0p1, c0t0d0s0
+	}
+	if isDigit(last) {
+		return name
+	}
+	return end
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// zdbConfigCommand is the zdb -C of getAshifts for pool.
+func zdbConfigCommand(pool zpool) command {
+	return command{"zdb", []string{"-C", pool.name}}
+}
+
+// ashiftCommand is the zpool get of getAshifts for the pools zdb -C failed
+// for.
+func ashiftCommand(pools []zpool) command {
+	return command{"zpool", append([]string{"get", "-Hp", "-o", "name,value", "ashift"}, poolNames(pools)...)}
+}
+
+// getAshifts fetches the ashift of every top-level vdev with zdb -C, falling
+// back to the ashift pool property when zdb is not available. ashift is fixed
+// when a vdev is added, so this is only done once at startup.
+func getAshifts(r commandRunner, pools []zpool) error {
+	var fallback []int
+	var fallbackPools []zpool
+	for i := range pools {
+		output, err := zdbConfigCommand(pools[i]).run(r)
+		if err == nil {
+			pools[i].ashifts, err = parseZdbConfig(output)
+		}
+		if err != nil {
+			fallback = append(fallback, i)
+			fallbackPools = append(fallbackPools, pools[i])
+		}
+	}
+	if len(fallback) == 0 {
+		return nil
+	}
+	output, err := ashiftCommand(fallbackPools).run(r)
+	if err != nil {
+		return fmt.Errorf("zpool get ashift: %s", strings.TrimSpace(output))
+	}
+	values := map[string]int64{}
+	for lines := newLineScanner(output); lines.scan(); {
+		line := lines.line
+		fields := strings.Split(line, "\t")
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseInt(fields[1], 10, 64); err == nil && v > 0 {
+			values[fields[0]] = v // 0 means detected per vdev, which zpool get cannot tell
+		}
+	}
+	for _, i := range fallback {
+		if v, ok := values[pools[i].name]; ok {
+			pools[i].ashifts = []vdevAshift{{ashift: v}}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import "testing"
+
+func TestParseVdevList(t *testing.T) {
+	output := "tank\t23914377117696\t13958643712000\t9955733405696\t-\t-\t21\t58\t1.00\tONLINE\t-\n" +
+		"\tmirror-0\t3985729650688\t3746585870336\t239143780352\t-\t-\t94\t94\t-\tONLINE\n" +
+		"\tsda\t-\t-\t-\t-\t-\t-\t-\t-\tONLINE\n" +
+		"\tsdb\t-\t-\t-\t-\t-\t-\t-\t-\tONLINE\n" +
+		"\traidz2-1\t15942918602752\t10212057841664\t5730860761088\t-\t-\t3\t64\t-\tONLINE\n" +
+		"\tsdc\t3985729650688\t-\t-\t-\t-\t-\t-\t-\tONLINE\n" +
+		"\tsdd\t3985729650688\t-\t-\t-\t-\t-\t-\t-\tONLINE\n" +
+		"\tsde\t3985729650688\t-\t-\t-\t-\t-\t-\t-\tONLINE\n" +
+		"\tsdf\t3985729650688\t-\t-\t-\t-\t-\t-\t-\tONLINE\n" +
+		"\tsdg\t3985729650688\t0\t3985729650688\t-\t-\t0\t0\t-\tONLINE\n" +
+		"\tindirect-3\t0\t0\t0\t-\t-\t0\t0\t-\tONLINE\n" +
+		"logs\t-\t-\t-\t-\t-\t-\t-\t-\t-\n" +
+		"\tnvme0n1\t500107862016\t1048576\t500106813440\t-\t-\t0\t0\t-\tONLINE\n" +
+		"cache\t-\t-\t-\t-\t-\t-\t-\t-\t-\n" +
+		"\tnvme1n1\t-\t-\t-\t-\t-\t-\t-\t-\tONLINE\n" +
+		"backup\t3985729650688\t3587156685619\t398572965069\t-\t-\t-\t90\t1.00\tDEGRADED\t-\n" +
+		"\tda0\t3985729650688\t3587156685619\t398572965069\t-\t-\t-\t90\t-\tDEGRADED\n"
+
+	vdevs, err := parseVdevList(output)
+	if err != nil {
+		t.Fatalf("Error in parseVdevList (%s)", err)
+	}
+	tank := vdevs["tank"]
+	if len(tank) != 4 {
+		t.Fatalf("Incorrect vdevs for tank, should be mirror-0, raidz2-1, sdg and nvme0n1: %+v", tank)
+	}
+	if tank[0].name != "mirror-0" || tank[0].fragmentation != 94 || tank[0].capacityRatio() < 0.93 || tank[0].capacityRatio() > 0.95 {
+		t.Errorf("Incorrect mirror vdev %+v", tank[0])
+	}
+	if tank[1].name != "raidz2-1" || tank[1].fragmentation != 3 || tank[1].size != 15942918602752 {
+		t.Errorf("Incorrect raidz2 vdev %+v", tank[1])
+	}
+	if tank[2].name != "sdg" || tank[2].fragmentation != 0 || tank[2].capacityRatio() != 0 {
+		t.Errorf("Incorrect single-disk vdev %+v", tank[2])
+	}
+	backup := vdevs["backup"]
+	if len(backup) != 1 || backup[0].name != "da0" || backup[0].fragmentation != -1 {
+		t.Errorf("Incorrect vdevs for backup: %+v", backup)
+	}
+	if tank[3].name != "nvme0n1" || tank[3].class != "logs" || tank[0].class != "" {
+		t.Errorf("Log vdev should belong to tank, got %+v", tank[3])
+	}
+	if _, ok := vdevs["logs"]; ok {
+		t.Errorf("logs heading should not be parsed as a pool")
+	}
+
+	if _, err = parseVdevList("\tmirror-0\t1\t1\t0\t-\t-\t0\t100\t-\tONLINE\n"); err == nil {
+		t.Errorf("Vdev row without pool row should produce error in parseVdevList")
+	}
+	if _, err = parseVdevList("tank\t1\t1\t0\t-\t-\t0\t100\t1.00\tONLINE\t-\n\tmirror-0\tmany\t1\t0\t-\t-\t0\t100\t-\tONLINE\n"); err == nil {
+		t.Errorf("Non-integer size should produce error in parseVdevList")
+	}
+}
+
+func TestMaxCapacityRatio(t *testing.T) {
+	// A raidz2 of 12T disks filled more than the one of 18T disks next to
+	// it, and a nearly full special vdev that does not count.
+	vdevs := []vdevStats{
+		{name: "raidz2-0", size: 48e12, alloc: 42e12},
+		{name: "raidz2-1", size: 72e12, alloc: 36e12},
+		{name: "mirror-2", class: "special", size: 1e12, alloc: 0.99e12},
+		{name: "nvme0n1", class: "logs", size: 0.5e12, alloc: 0.5e12},
+	}
+	if max, ok := maxCapacityRatio(vdevs); !ok || max != 0.875 {
+		t.Errorf("Incorrect max capacity ratio %v (%v), should be 0.875 of raidz2-0", max, ok)
+	}
+	if _, ok := maxCapacityRatio(vdevs[2:]); ok {
+		t.Errorf("A pool without vdevs of the normal class should have no max capacity ratio")
+	}
+}
+
+func TestParseZdbConfig(t *testing.T) {
+	ashifts, err := parseZdbConfig(`
+MOS Configuration:
+        version: 5000
+        name: 'tank'
+        state: 0
+        txg: 4211
+        pool_guid: 7581609611446986219
+        vdev_children: 5
+        vdev_tree:
+            type: 'root'
+            id: 0
+            guid: 7581609611446986219
+            children[0]:
+                type: 'mirror'
+                id: 0
+                guid: 2380102119001881033
+                metaslab_array: 256
+                metaslab_shift: 33
+                ashift: 9
+                asize: 3985729650688
+                is_log: 0
+                create_txg: 4
+                children[0]:
+                    type: 'disk'
+                    id: 0
+                    guid: 1016167347004471035
+                    path: '/dev/sda1'
+                    whole_disk: 1
+                    ashift: 12
+                children[1]:
+                    type: 'disk'
+                    id: 1
+                    guid: 1687353846931195826
+                    path: '/dev/sdb1'
+                    whole_disk: 1
+            children[1]:
+                type: 'raidz'
+                id: 1
+                guid: 1434268154436860731
+                nparity: 2
+                ashift: 12
+                children[0]:
+                    type: 'disk'
+                    id: 0
+                    path: '/dev/sdc1'
+                    whole_disk: 1
+            children[2]:
+                type: 'hole'
+                id: 2
+                guid: 0
+                ashift: 0
+            children[3]:
+                type: 'disk'
+                id: 3
+                guid: 5044517321343878190
+                path: '/dev/disk/by-id/nvme-Samsung_SSD_970-part1'
+                whole_disk: 1
+                ashift: 13
+            children[4]:
+                type: 'indirect'
+                id: 4
+                guid: 9140810815335907786
+                ashift: 12
+                asize: 1998998994944
+        features_for_read:
+            com.delphix:hole_birth
+`)
+	if err != nil {
+		t.Fatalf("Error in parseZdbConfig (%s)", err)
+	}
+	want := []vdevAshift{{"mirror-0", 9}, {"raidz2-1", 12}, {"nvme-Samsung_SSD_970", 13}}
+	if len(ashifts) != len(want) {
+		t.Fatalf("Incorrect ashifts %+v, should be %+v", ashifts, want)
+	}
+	for i := range want {
+		if ashifts[i] != want[i] {
+			t.Errorf("Incorrect ashift %+v, should be %+v", ashifts[i], want[i])
+		}
+	}
+
+	if _, err = parseZdbConfig("zdb: can't open 'tank': Permission denied\n"); err == nil {
+		t.Errorf("Output without vdev tree should produce error in parseZdbConfig")
+	}
+}
+
+func TestStripPartition(t *testing.T) {
+	for name, want := range map[string]string{
+		"sda1":        "sda",
+		"sdp1":        "sdp",
+		"nvme0n1p1":   "nvme0n1",
+		"mmcblk0p1":   "mmcblk0",
+		"ata-X-part1": "ata-X",
+		"c0t0d0s0":    "c0t0d0",
+		"sdb":         "sdb",
+	} {
+		if got := stripPartition(name); got != want {
+			t.Errorf("stripPartition(%q) = %q, should be %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	zfsVersionInfoDesc = prometheus.NewDesc("zfs_version_info",
+		"Versions of the ZFS userland and kernel module from zfs version, always 1; kernel is empty when zfs version does not print it", []string{"userland", "kernel"}, nil)
+	zfsVersionMismatchDesc = prometheus.NewDesc("zfs_version_mismatch",
+		"Whether the ZFS userland and kernel module are different releases (1) or not (0), as after an upgrade without a reboot", nil, nil)
+)
+
+// zfsVersions are the two lines of zfs version, such as zfs-2.2.2-1 and
+// zfs-kmod-2.1.5-1, without the prefixes.
+type zfsVersions struct {
+	userland, kernel string
+}
+
+// parseZFSVersion parses the output of zfs version, which OpenZFS has since
+// 0.8. ok is false when it has no userland version.
+func parseZFSVersion(output string) (v zfsVersions, ok bool) {
+	for lines := newLineScanner(output); lines.scan(); {
+		line := strings.TrimSpace(lines.line)
+		switch {
+		case strings.HasPrefix(line, "zfs-kmod-"):
+			v.kernel = strings.TrimPrefix(line, "zfs-kmod-")
+		case strings.HasPrefix(line, "zfs-"):
+			v.userland = strings.TrimPrefix(line, "zfs-")
+		}
+	}
+	return v, v.userland != ""
+}
+
+// mismatch reports whether the userland and the kernel module are different
+// releases. Only the release numbers are compared, since the packages of a
+// distribution may add suffixes of their own. An unknown kernel version is
+// no mismatch.
+func (v zfsVersions) mismatch() bool {
+	release := func(version string) string {
+		return strings.SplitN(version, "-", 2)[0]
+	}
+	return v.kernel != "" && release(v.userland) != release(v.kernel)
+}
+
+// versionCommand prints the versions of the userland and the kernel module.
+var versionCommand = command{"zfs", []string{"version"}}
+
+// versionChecker exports the versions of ZFS on every scrape, so that a
+// package upgrade without a reboot shows up while the exporter keeps running:
+// the new userland then talks to the old kernel module, and may print what
+// the parsers do not expect. A mismatch is logged once for each pair of
+// versions.
+type versionChecker struct {
+	warned zfsVersions
+}
+
+func (c *versionChecker) collect(r commandRunner, ch chan<- prometheus.Metric) {
+	output, err := versionCommand.run(r)
+	v, ok := parseZFSVersion(output)
+	if err != nil || !ok {
+		debugf("zfs version is not supported, not exporting zfs_version_info")
+		return
+	}
+	if v.mismatch() && v != c.warned {
+		log.Printf("Warning: the ZFS userland %s does not match the kernel module %s, as after an upgrade without a reboot; commands may print output the exporter does not recognize", v.userland, v.kernel)
+		c.warned = v
+	}
+	ch <- prometheus.MustNewConstMetric(zfsVersionInfoDesc, prometheus.GaugeValue, 1, v.userland, v.kernel)
+	ch <- prometheus.MustNewConstMetric(zfsVersionMismatchDesc, prometheus.GaugeValue, boolToFloat(v.mismatch()))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseZFSVersion(t *testing.T) {
+	for _, test := range []struct {
+		output   string
+		want     zfsVersions
+		ok       bool
+		mismatch bool
+	}{
+		{"zfs-2.2.2-1\nzfs-kmod-2.2.2-1\n", zfsVersions{"2.2.2-1", "2.2.2-1"}, true, false},
+		{"zfs-2.2.2-1\nzfs-kmod-2.1.5-1\n", zfsVersions{"2.2.2-1", "2.1.5-1"}, true, true},
+		{"zfs-2.1.5-1ubuntu6~22.04.1\nzfs-kmod-2.1.5-1ubuntu6~22.04.2\n", zfsVersions{"2.1.5-1ubuntu6~22.04.1", "2.1.5-1ubuntu6~22.04.2"}, true, false},
+		{"zfs-2.1.9-FreeBSD_g92e0d9d18\nzfs-kmod-2.1.9-FreeBSD_g92e0d9d18\n", zfsVersions{"2.1.9-FreeBSD_g92e0d9d18", "2.1.9-FreeBSD_g92e0d9d18"}, true, false},
+		// The module is not loaded.
+		{"zfs-2.2.2-1\nzfs_version_kernel() failed: No such file or directory\n", zfsVersions{"2.2.2-1", ""}, true, false},
+		// Releases before 0.8
+		{"unrecognized command 'version'\nusage: zfs command args ...\n", zfsVersions{}, false, false},
+	} {
+		got, ok := parseZFSVersion(test.output)
+		if got != test.want || ok != test.ok || got.mismatch() != test.mismatch {
+			t.Errorf("Incorrect versions %+v (%v, mismatch %v) of %q, should be %+v (%v, mismatch %v)",
+				got, ok, got.mismatch(), test.output, test.want, test.ok, test.mismatch)
+		}
+	}
+}
+
+func TestVersionMismatchWarning(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	var c versionChecker
+	r := staticRunner{"zfs version": "zfs-2.2.2-1\nzfs-kmod-2.1.5-1\n"}
+	for i := 0; i < 3; i++ {
+		ch := make(chan prometheus.Metric, 2)
+		c.collect(r, ch)
+		close(ch)
+		for m := range ch {
+			switch m.Desc() {
+			case zfsVersionInfoDesc:
+				if metricLabel(m, "userland") != "2.2.2-1" || metricLabel(m, "kernel") != "2.1.5-1" {
+					t.Errorf("Incorrect zfs_version_info labels userland=%s kernel=%s", metricLabel(m, "userland"), metricLabel(m, "kernel"))
+				}
+			case zfsVersionMismatchDesc:
+				if metricValue(m) != 1 {
+					t.Errorf("zfs_version_mismatch should be 1, got %v", metricValue(m))
+				}
+			}
+		}
+	}
+	if n := strings.Count(buf.String(), "does not match the kernel module"); n != 1 {
+		t.Errorf("The mismatch should be logged once, got %d times:\n%s", n, buf.String())
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// normalizeRoutePrefix turns a --web.route-prefix flag into the path the
+// routes are served below, without a trailing slash: "" for "/" or "", and
+// "/hosts/nas01/zfs" for "hosts/nas01/zfs/".
+func normalizeRoutePrefix(prefix string) (string, error) {
+	if strings.ContainsAny(prefix, "?# ") {
+		return "", fmt.Errorf("invalid route prefix %q, should be a path without query or spaces", prefix)
+	}
+	p := path.Clean("/" + prefix)
+	if p == "/" {
+		return "", nil
+	}
+	return p, nil
+}
+
+// parseExternalURL parses --web.external-url, the URL the exporter is
+// reachable at through a reverse proxy, such as
+// https://ingress.example.com/hosts/nas01/zfs/.
+func parseExternalURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid external URL %q: %s", s, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("invalid external URL %q, should be an http or https URL without query", s)
+	}
+	u.Path = strings.TrimSuffix(path.Clean("/"+u.Path), "/")
+	u.RawPath = ""
+	return u, nil
+}
+
+// withRoutePrefix serves h below prefix, with the prefix stripped from the
+// request path. Requests outside the prefix get a 404 rather than being
+// served as well, so that a proxy configured with the wrong path shows up
+// at once. The prefix itself redirects to the landing page at prefix/,
+// through the external path when it differs from the prefix.
+func withRoutePrefix(prefix, external string, h http.Handler) http.Handler {
+	if prefix == "" {
+		return h
+	}
+	stripped := http.StripPrefix(prefix, h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, prefix)
+		switch {
+		case !ok || rest != "" && rest[0] != '/':
+			http.NotFound(w, r)
+		case rest == "":
+			http.Redirect(w, r, external+"/", http.StatusMovedPermanently)
+		default:
+			stripped.ServeHTTP(w, r)
+		}
+	})
+}
+
+// landingLink is a link on the landing page.
+type landingLink struct {
+	path, text string
+}
+
+// landingPage serves a page linking to the endpoints on / and a 404 on every
+// other path the other routes do not cover. The links are below base, the
+// path of --web.external-url or the route prefix, so that they work through
+// the reverse proxy.
+func landingPage(base string, links []landingLink) http.HandlerFunc {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head><title>ZFS exporter</title></head>\n<body>\n<h1>ZFS exporter</h1>\n<ul>\n")
+	for _, link := range links {
+		fmt.Fprintf(&b, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(base+link.path), html.EscapeString(link.text))
+	}
+	b.WriteString("</ul>\n</body>\n</html>\n")
+	page := b.String()
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, page)
+	}
+}
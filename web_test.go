@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeRoutePrefix(t *testing.T) {
+	for prefix, want := range map[string]string{
+		"":                  "",
+		"/":                 "",
+		"hosts/nas01/zfs/":  "/hosts/nas01/zfs",
+		"/hosts//nas01/zfs": "/hosts/nas01/zfs",
+	} {
+		got, err := normalizeRoutePrefix(prefix)
+		if err != nil || got != want {
+			t.Errorf("Incorrect prefix for %q (%q, %v), should be %q", prefix, got, err, want)
+		}
+	}
+	if _, err := normalizeRoutePrefix("/zfs?x=1"); err == nil {
+		t.Errorf("Query should produce error in normalizeRoutePrefix")
+	}
+}
+
+func TestParseExternalURL(t *testing.T) {
+	u, err := parseExternalURL("https://ingress.example.com/hosts/nas01/zfs/")
+	if err != nil {
+		t.Fatalf("Error in parseExternalURL (%s)", err)
+	}
+	if u.Path != "/hosts/nas01/zfs" {
+		t.Errorf("Incorrect path (%q), should be /hosts/nas01/zfs", u.Path)
+	}
+	if u, err := parseExternalURL("http://nas01:8080"); err != nil || u.Path != "" {
+		t.Errorf("Incorrect path for URL without path (%q, %v), should be empty", u.Path, err)
+	}
+	for _, s := range []string{"/hosts/nas01", "ftp://example.com/", "http://example.com/?x=1", "http://"} {
+		if _, err := parseExternalURL(s); err == nil {
+			t.Errorf("URL %q should produce error in parseExternalURL", s)
+		}
+	}
+}
+
+func TestRoutePrefix(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", serveHealthy)
+	mux.HandleFunc("/", landingPage("/ext", []landingLink{{"/metrics", "Metrics"}, {"/healthz", "Health"}}))
+	h := withRoutePrefix("/hosts/nas01/zfs", "/ext", mux)
+	for path, want := range map[string]int{
+		"/hosts/nas01/zfs/healthz": http.StatusOK,
+		"/hosts/nas01/zfs/":        http.StatusOK,
+		"/hosts/nas01/zfs":         http.StatusMovedPermanently,
+		"/hosts/nas01/zfs/nothing": http.StatusNotFound,
+		"/hosts/nas01/zfsx/":       http.StatusNotFound,
+		"/healthz":                 http.StatusNotFound,
+		"/":                        http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != want {
+			t.Errorf("Incorrect status for %s (%d), should be %d", path, rec.Code, want)
+		}
+		if path == "/hosts/nas01/zfs" && rec.Header().Get("Location") != "/ext/" {
+			t.Errorf("Incorrect redirect (%q), should be /ext/", rec.Header().Get("Location"))
+		}
+		if path == "/hosts/nas01/zfs/" && !strings.Contains(rec.Body.String(), `href="/ext/metrics"`) {
+			t.Errorf("Landing page should link to /ext/metrics:\n%s", rec.Body)
+		}
+	}
+
+	if withRoutePrefix("", "", mux) != http.Handler(mux) {
+		t.Errorf("Empty prefix should serve the routes as they are")
+	}
+}
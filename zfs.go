@@ -0,0 +1,529 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// datasetMetric exports one zfs property as a gauge named <prefix>_<name>,
+// where the prefix depends on the dataset type.
+type datasetMetric struct {
+	property string
+	name     string
+	help     string
+	omitZero bool // zfs -p prints 0 for unset properties such as quota=none
+
+	// parse converts the raw property value, returning NaN when the
+	// property does not apply. nil parses a byte count or "-".
+	parse func(string) (float64, error)
+}
+
+// parseSet returns 1 for properties that are set to anything at all, such as
+// the origin of a clone, and 0 for "-" or an empty value.
+func parseSet(s string) (float64, error) {
+	if s == "-" || s == "" {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+// parseYesNo parses boolean properties such as mounted.
+func parseYesNo(s string) (float64, error) {
+	switch s {
+	case "yes", "on":
+		return 1, nil
+	case "no", "off":
+		return 0, nil
+	case "-":
+		return math.NaN(), nil
+	}
+	return 0, fmt.Errorf("invalid boolean %q", s)
+}
+
+// parseSyncDisabled returns 1 for sync=disabled, which acknowledges
+// synchronous writes before they are on disk, and 0 for standard and always.
+func parseSyncDisabled(s string) (float64, error) {
+	switch s {
+	case "disabled":
+		return 1, nil
+	case "standard", "always":
+		return 0, nil
+	case "-":
+		return math.NaN(), nil
+	}
+	return 0, fmt.Errorf("invalid sync %q", s)
+}
+
+// parseLimit parses filesystem_limit and snapshot_limit, returning NaN when
+// no limit is set, which zfs prints as "none" even with -p.
+func parseLimit(s string) (float64, error) {
+	if s == "none" || s == "-" {
+		return math.NaN(), nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if v == math.MaxUint64 {
+		return math.NaN(), nil
+	}
+	return float64(v), nil
+}
+
+// parseRatio parses compressratio, which zfs -p prints as "1.50" and zfs
+// without -p as "1.50x".
+func parseRatio(s string) (float64, error) {
+	if s == "-" {
+		return math.NaN(), nil
+	}
+	return strconv.ParseFloat(strings.TrimSuffix(s, "x"), 64)
+}
+
+var datasetMetrics = []datasetMetric{
+	{property: "used", name: "used_bytes", help: "Space consumed by the dataset and all its descendants"},
+	{property: "available", name: "available_bytes", help: "Space available to the dataset and all its children"},
+	{property: "referenced", name: "referenced_bytes", help: "Space referenced by the dataset, possibly shared with other datasets"},
+	{property: "quota", name: "quota_bytes", help: "Quota of the dataset, absent (0 with --strict-zero) when no quota is set", omitZero: true},
+	{property: "usedbydataset", name: "used_by_dataset_bytes", help: "Space used by the dataset itself, freed if it and all its snapshots were destroyed"},
+	{property: "usedbysnapshots", name: "used_by_snapshots_bytes", help: "Space used by snapshots of the dataset, freed if all of them were destroyed"},
+	{property: "usedbychildren", name: "used_by_children_bytes", help: "Space used by children of the dataset, freed if all of them were destroyed"},
+	{property: "usedbyrefreservation", name: "used_by_refreservation_bytes", help: "Space used by the refreservation of the dataset, freed if it were removed"},
+	{property: "reservation", name: "reservation_bytes", help: "Space guaranteed to the dataset and its descendants, absent (0 with --strict-zero) when no reservation is set", omitZero: true},
+	{property: "refreservation", name: "refreservation_bytes", help: "Space guaranteed to the dataset itself, absent (0 with --strict-zero) when no refreservation is set", omitZero: true},
+	{property: "logicalused", name: "logical_used_bytes", help: "Space consumed by the dataset and its descendants before compression"},
+	{property: "logicalreferenced", name: "logical_referenced_bytes", help: "Space referenced by the dataset before compression"},
+	{property: "compressratio", name: "compression_ratio", help: "Compression ratio achieved for the space used by the dataset and its descendants, 1 for uncompressed data", parse: parseRatio},
+	{property: "recordsize", name: "recordsize_bytes", help: "Largest block size of the files of the filesystem, absent for volumes and snapshots"},
+	{property: "volblocksize", name: "volblocksize_bytes", help: "Block size of the volume, absent for filesystems and snapshots"},
+	{property: "written", name: "written_bytes", help: "Space referenced by the dataset written since its latest snapshot, resets when a snapshot is taken"},
+	{property: "mounted", name: "mounted", help: "Whether the filesystem is currently mounted (1) or not (0)", parse: parseYesNo},
+	{property: "sync", name: "sync_disabled", help: "Whether sync=disabled makes the dataset acknowledge synchronous writes before they are on disk (1) or not (0), absent for snapshots", parse: parseSyncDisabled},
+	{property: "origin", name: "is_clone", help: "Whether the dataset is a clone (1) or not (0)", parse: parseSet},
+	{property: "receive_resume_token", name: "receive_resume_token_present", help: "Whether an interrupted zfs receive left a resume token on the dataset (1) or not (0)", parse: parseSet},
+	{property: "filesystem_limit", name: "filesystem_limit", help: "Maximum number of filesystems and volumes below the dataset, absent when no limit is set", parse: parseLimit},
+	{property: "filesystem_count", name: "filesystem_count", help: "Number of filesystems and volumes below the dataset, absent unless a filesystem_limit is set on it or above it"},
+	{property: "snapshot_limit", name: "snapshot_limit", help: "Maximum number of snapshots of the dataset and its descendants, absent when no limit is set", parse: parseLimit},
+	// Not snapshot_count: zfs_dataset_snapshot_count is the count of the
+	// snapshots of the dataset itself of the snapshot collector.
+	{property: "snapshot_count", name: "snapshot_limit_count", help: "Number of snapshots of the dataset and its descendants that count against snapshot_limit, absent unless a snapshot_limit is set on it or above it"},
+}
+
+// rootDatasetProperties are the properties of datasetMetrics exported with
+// datasetOptions.rootsOnly: the space of each pool, as it can be written to.
+var rootDatasetProperties = []string{"used", "available", "referenced", "compressratio", "logicalused"}
+
+// datasetInfoProperties are exported verbatim as labels of <prefix>_info,
+// with "-" (not applicable) as an empty string. guid and createtxg tell
+// whether datasets of two pools share replicated history: a received
+// snapshot keeps the guid of the one it was sent from. sync and logbias
+// change how synchronous writes are handled, and are worth an audit.
+var datasetInfoProperties = []string{"mountpoint", "canmount", "guid", "createtxg", "sync", "logbias"}
+
+// datasetTypePrefixes maps each zfs dataset type onto its metric name prefix.
+var datasetTypePrefixes = map[string]string{
+	"filesystem": "zfs_dataset",
+	"volume":     "zfs_volume",
+	"snapshot":   "zfs_snapshot",
+}
+
+// datasetColumns are the columns requested from zfs list, in order: name and
+// type followed by every property used by datasetMetrics and
+// datasetInfoProperties.
+var datasetColumns = func() []string {
+	columns := []string{"name", "type"}
+	for _, m := range datasetMetrics {
+		columns = appendUnique(columns, m.property)
+	}
+	for _, p := range datasetInfoProperties {
+		columns = appendUnique(columns, p)
+	}
+	return columns
+}()
+
+// datasetColumnIndex maps every property in datasetColumns to its column.
+var datasetColumnIndex = func() map[string]int {
+	index := make(map[string]int, len(datasetColumns))
+	for i, column := range datasetColumns {
+		index[column] = i
+	}
+	return index
+}()
+
+type dataset struct {
+	name   string
+	kind   string    // filesystem, volume or snapshot
+	fields []string  // raw values, indexed like datasetColumns
+	values []float64 // indexed like datasetMetrics, NaN where not applicable
+}
+
+// setFields fills in the dataset from one row of
+// zfs list -Hp -o <datasetColumns>.
+func (d *dataset) setFields(fields []string) error {
+	if len(fields) != len(datasetColumns) {
+		return fmt.Errorf("expected %d zfs list columns, got %d", len(datasetColumns), len(fields))
+	}
+	d.name = fields[0]
+	d.kind = fields[1]
+	if _, ok := datasetTypePrefixes[d.kind]; !ok {
+		return fmt.Errorf("unknown dataset type %q", d.kind)
+	}
+	d.fields = fields
+	d.values = make([]float64, len(datasetMetrics))
+	for i, m := range datasetMetrics {
+		field := fields[datasetColumnIndex[m.property]]
+		if m.parse != nil {
+			v, err := m.parse(field)
+			if err != nil {
+				return err
+			}
+			d.values[i] = v
+			continue
+		}
+		if field == "-" {
+			d.values[i] = math.NaN() // not applicable to this dataset type
+			continue
+		}
+		v, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return err
+		}
+		d.values[i] = float64(v)
+	}
+	return nil
+}
+
+// property returns the raw value of the named property, with "-" (not
+// applicable) as an empty string.
+func (d *dataset) property(name string) string {
+	if v := d.fields[datasetColumnIndex[name]]; v != "-" {
+		return v
+	}
+	return ""
+}
+
+// infoLabels returns the values of datasetInfoProperties.
+func (d *dataset) infoLabels() []string {
+	labels := make([]string, len(datasetInfoProperties))
+	for i, p := range datasetInfoProperties {
+		labels[i] = d.property(p)
+	}
+	return labels
+}
+
+// value returns the parsed value of the metric for the named property, and
+// false when the property is not applicable to the dataset.
+func (d *dataset) value(property string) (float64, bool) {
+	for i, m := range datasetMetrics {
+		if m.property == property {
+			return d.values[i], !math.IsNaN(d.values[i])
+		}
+	}
+	return 0, false
+}
+
+// datasetFilter selects datasets by name. A nil include matches everything;
+// exclude takes precedence over include.
+type datasetFilter struct {
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+// newDatasetFilter compiles the include and exclude expressions, either of
+// which may be empty. Expressions are anchored to match the whole name.
+func newDatasetFilter(include, exclude string) (f datasetFilter, err error) {
+	if include != "" {
+		if f.include, err = regexp.Compile("^(?:" + include + ")$"); err != nil {
+			return f, fmt.Errorf("invalid dataset include expression: %s", err)
+		}
+	}
+	if exclude != "" {
+		if f.exclude, err = regexp.Compile("^(?:" + exclude + ")$"); err != nil {
+			return f, fmt.Errorf("invalid dataset exclude expression: %s", err)
+		}
+	}
+	return f, nil
+}
+
+func (f datasetFilter) matches(name string) bool {
+	if f.exclude != nil && f.exclude.MatchString(name) {
+		return false
+	}
+	return f.include == nil || f.include.MatchString(name)
+}
+
+// datasetStats counts the rows parseDatasets did not hand on.
+type datasetStats struct {
+	skipped  int // malformed rows
+	filtered int // rows rejected by the filter
+}
+
+// parseDatasets reads zfs list -Hp output line by line and calls fn for each
+// well-formed row accepted by filter, so the output never has to be held in
+// memory as a whole. The filter is applied to the name before any other
+// column is parsed. Malformed rows are skipped and counted rather than
+// aborting the parse.
+func parseDatasets(r io.Reader, filter datasetFilter, fn func(d *dataset)) (stats datasetStats, err error) {
+	scanner := newOutputScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		if name, _, _ := strings.Cut(line, "\t"); !filter.matches(name) {
+			stats.filtered++
+			continue
+		}
+		var d dataset
+		if err := d.setFields(strings.Split(line, "\t")); err != nil {
+			stats.skipped++
+			continue
+		}
+		fn(&d)
+	}
+	return stats, scanError(scanner)
+}
+
+// datasetDescs holds the descriptors of datasetMetrics for each dataset
+// type, indexed like datasetMetrics.
+var datasetDescs = func() map[string][]*prometheus.Desc {
+	descs := map[string][]*prometheus.Desc{}
+	for kind, prefix := range datasetTypePrefixes {
+		for _, m := range datasetMetrics {
+			descs[kind] = append(descs[kind], prometheus.NewDesc(prefix+"_"+m.name, m.help, []string{"name"}, nil))
+		}
+	}
+	return descs
+}()
+
+// datasetInfoDescs holds the <prefix>_info descriptor for each dataset type.
+var datasetInfoDescs = func() map[string]*prometheus.Desc {
+	descs := map[string]*prometheus.Desc{}
+	labels := append([]string{"name"}, datasetInfoProperties...)
+	for kind, prefix := range datasetTypePrefixes {
+		descs[kind] = prometheus.NewDesc(prefix+"_info",
+			"Informational zfs properties of the dataset as labels, always 1", labels, nil)
+	}
+	return descs
+}()
+
+var snapshotCloneCountDesc = prometheus.NewDesc("zfs_snapshot_clone_count",
+	"Number of listed datasets cloned from the snapshot", []string{"origin"}, nil)
+
+// datasetTypes are the dataset types listed unless configured otherwise.
+// Snapshots are left out as there are usually far more of them.
+var datasetTypes = []string{"filesystem", "volume"}
+
+// parseDatasetTypes validates a comma separated list of dataset types.
+func parseDatasetTypes(list string) ([]string, error) {
+	var types []string
+	for _, t := range strings.Split(list, ",") {
+		t = strings.TrimSpace(t)
+		if _, ok := datasetTypePrefixes[t]; !ok {
+			return nil, fmt.Errorf("unknown dataset type %q, should be one of filesystem, volume or snapshot", t)
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+// datasetOptions configure which datasets the collector lists.
+type datasetOptions struct {
+	filter      datasetFilter
+	maxDepth    int      // levels below each pool root dataset, negative for unlimited
+	types       []string // dataset types passed to zfs list -t, datasetTypes if empty
+	maxDatasets int      // datasets to export at most, 0 for unlimited
+	// rootsOnly only exports the rootDatasetProperties of the root
+	// filesystem of each pool, overriding maxDepth and types.
+	rootsOnly bool
+}
+
+var datasetsTruncatedDesc = prometheus.NewDesc("zfs_exporter_datasets_truncated",
+	"Whether the collector left out datasets beyond --collector.dataset.max-datasets in the last scrape", []string{"collector"}, nil)
+
+// truncationWarnInterval is how often a collector that keeps leaving out
+// datasets logs it.
+const truncationWarnInterval = time.Hour
+
+// datasetLimit caps the datasets a collector exports, so that a pool with
+// tens of thousands of them cannot flood Prometheus with series.
+type datasetLimit struct {
+	collector string
+	max       int       // 0 for unlimited
+	warned    time.Time // when the truncation was last logged
+}
+
+// allow reports whether a collector that exported n datasets so far may
+// export another one.
+func (l *datasetLimit) allow(n int) bool {
+	return l.max == 0 || n < l.max
+}
+
+// report exports whether the collector left out some of total datasets and
+// logs it, at most once per truncationWarnInterval.
+func (l *datasetLimit) report(total int, ch chan<- prometheus.Metric) {
+	truncated := !l.allow(total)
+	if truncated && time.Since(l.warned) >= truncationWarnInterval {
+		logf(logFields{"COLLECTOR": l.collector}, "Warning: %s collector found %d datasets, only exporting the first %d by name; raise --collector.dataset.max-datasets or narrow --dataset-include",
+			l.collector, total, l.max)
+		l.warned = time.Now()
+	}
+	ch <- prometheus.MustNewConstMetric(datasetsTruncatedDesc, prometheus.GaugeValue, boolToFloat(truncated), l.collector)
+}
+
+// datasetCollector exports per-dataset properties for the monitored pools
+// from a single zfs list invocation.
+type datasetCollector struct {
+	datasetOptions
+	limit     datasetLimit
+	malformed prometheus.Counter
+	filtered  prometheus.Counter
+	// snapshotSpace holds the sums of the last collection for the
+	// snapshotSpaceCollector when listsEverything, until it takes them.
+	snapshotSpace snapshotSpace
+}
+
+func newDatasetCollector(opts datasetOptions) *datasetCollector {
+	if len(opts.types) == 0 {
+		opts.types = datasetTypes
+	}
+	if opts.rootsOnly {
+		opts.types, opts.maxDepth = []string{"filesystem"}, 0
+	}
+	return &datasetCollector{
+		datasetOptions: opts,
+		limit:          datasetLimit{collector: "datasets", max: opts.maxDatasets},
+		malformed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "zfs_exporter_datasets_malformed_total",
+			Help: "Number of zfs list rows skipped because they could not be parsed",
+		}),
+		filtered: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "zfs_exporter_datasets_filtered_total",
+			Help: "Number of datasets dropped by the dataset include/exclude filters",
+		}),
+	}
+}
+
+// listsEverything reports whether the collector lists every filesystem and
+// volume of the pools, so that its listing can stand in for that of the
+// snapshotSpaceCollector.
+func (c *datasetCollector) listsEverything() bool {
+	return !c.rootsOnly && c.maxDepth < 0 && c.filter.include == nil && c.filter.exclude == nil &&
+		stringInSlice("filesystem", c.types) && stringInSlice("volume", c.types)
+}
+
+// takeSnapshotSpace returns the snapshot space summed up by the last
+// collection, once, or nil when there is none.
+func (c *datasetCollector) takeSnapshotSpace() snapshotSpace {
+	space := c.snapshotSpace
+	c.snapshotSpace = nil
+	return space
+}
+
+// exports reports whether the collector exports the metric of datasetMetrics.
+func (c *datasetCollector) exports(m datasetMetric) bool {
+	return !c.rootsOnly || stringInSlice(m.property, rootDatasetProperties)
+}
+
+func (c *datasetCollector) describe(ch chan<- *prometheus.Desc) {
+	for _, kind := range c.types {
+		for i, desc := range datasetDescs[kind] {
+			if c.exports(datasetMetrics[i]) {
+				ch <- desc
+			}
+		}
+		if !c.rootsOnly {
+			ch <- datasetInfoDescs[kind]
+		}
+	}
+	if !c.rootsOnly {
+		ch <- snapshotCloneCountDesc
+	}
+	ch <- datasetsTruncatedDesc
+	ch <- c.malformed.Desc()
+	ch <- c.filtered.Desc()
+}
+
+// listCommand lists every dataset of pools.
+func (c *datasetCollector) listCommand(pools []zpool) command {
+	args := []string{"list", "-Hp", "-o", strings.Join(datasetColumns, ","), "-t", strings.Join(c.types, ",")}
+	if c.maxDepth >= 0 {
+		args = append(args, "-d", strconv.Itoa(c.maxDepth))
+	} else {
+		args = append(args, "-r")
+	}
+	return command{"zfs", append(args, poolNames(pools)...)}
+}
+
+func (c *datasetCollector) plan(pools []zpool) []plannedCommand {
+	return []plannedCommand{{c.listCommand(pools), ""}}
+}
+
+func (c *datasetCollector) collect(r commandRunner, pools []zpool, ch chan<- prometheus.Metric) error {
+	defer func() {
+		ch <- c.malformed
+		ch <- c.filtered
+	}()
+
+	output, err := c.listCommand(pools).start(r)
+	if err != nil {
+		return err
+	}
+	clones := map[string]int{} // clone count per origin snapshot
+	total := 0
+	c.snapshotSpace = nil
+	var space snapshotSpace
+	if c.listsEverything() {
+		space = snapshotSpace{}
+	}
+	stats, err := parseDatasets(output, c.filter, func(d *dataset) {
+		if space != nil && d.kind != "snapshot" {
+			space.add(d.name, d.property("usedbysnapshots"))
+		}
+		// zfs list sorts by name, so the same datasets are left out on
+		// every scrape.
+		total++
+		if !c.limit.allow(total - 1) {
+			return
+		}
+		descs := datasetDescs[d.kind]
+		for i, m := range datasetMetrics {
+			v := d.values[i]
+			if math.IsNaN(v) || !c.exports(m) {
+				continue // not applicable to the kind of dataset
+			}
+			emitIfPresent(ch, descs[i], v, !m.omitZero || v != 0, d.name)
+		}
+		if c.rootsOnly {
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(datasetInfoDescs[d.kind], prometheus.GaugeValue, 1,
+			append([]string{d.name}, d.infoLabels()...)...)
+		if origin := d.property("origin"); origin != "" {
+			clones[origin]++
+		}
+	})
+	for origin, count := range clones {
+		ch <- prometheus.MustNewConstMetric(snapshotCloneCountDesc, prometheus.GaugeValue, float64(count), origin)
+	}
+	c.limit.report(total, ch)
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && stats.skipped == 0 {
+		c.snapshotSpace = space
+	}
+	c.filtered.Add(float64(stats.filtered))
+	if stats.skipped > 0 {
+		c.malformed.Add(float64(stats.skipped))
+		log.Printf("Skipped %d malformed rows in zfs list output", stats.skipped)
+	}
+	return err
+}
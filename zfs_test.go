@@ -0,0 +1,434 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// staticRunner returns canned output keyed by the full command line.
+type staticRunner map[string]string
+
+func (s staticRunner) run(name string, args ...string) (string, error) {
+	return s[strings.Join(append([]string{name}, args...), " ")], nil
+}
+
+func (s staticRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	output, err := s.run(name, args...)
+	return io.NopCloser(strings.NewReader(output)), err
+}
+
+// zfsListRow renders a zfs list -Hp row in datasetColumns order. Properties
+// missing from values are printed as "-", as zfs does for properties that do
+// not apply to the dataset type.
+func zfsListRow(name, kind string, values map[string]string) string {
+	fields := []string{name, kind}
+	for _, column := range datasetColumns[2:] {
+		v, ok := values[column]
+		if !ok {
+			v = "-"
+		}
+		fields = append(fields, v)
+	}
+	return strings.Join(fields, "\t") + "\n"
+}
+
+var zfsListOutput = zfsListRow("tank", "filesystem", map[string]string{
+	"used": "6118856933376", "available": "5685034868736", "referenced": "196608", "quota": "0",
+}) + zfsListRow("tank/home", "filesystem", map[string]string{
+	"used": "2199023255552", "available": "5685034868736", "referenced": "2199023255552", "quota": "3298534883328",
+	"usedbydataset": "2190433320960", "usedbysnapshots": "8589934592", "usedbychildren": "0", "usedbyrefreservation": "0",
+	"logicalused": "3298534883328", "logicalreferenced": "3285649981440", "written": "4294967296",
+	"recordsize": "131072",
+	"mounted":    "yes", "mountpoint": "/tank/home", "canmount": "on",
+	"guid": "1538210947763220176", "createtxg": "284", "sync": "standard", "logbias": "latency",
+}) + zfsListRow("tank/broken", "filesystem", map[string]string{
+	"used": "not-a-number", "available": "0", "referenced": "0", "quota": "0",
+}) + "tank/short\t1\n" + zfsListRow("tank/vmail", "filesystem", map[string]string{
+	"used": "1073741824", "available": "5685034868736", "referenced": "1073741824", "quota": "0",
+	"written":    "1073741824", // no snapshots yet
+	"recordsize": "16384",
+	"mounted":    "no", "mountpoint": "/var/vmail", "canmount": "noauto",
+	"filesystem_limit": "none", "filesystem_count": "0", "snapshot_limit": "20", "snapshot_count": "3",
+	"receive_resume_token": "1-e604ea4bf-e0-789c63a2aaca5a4c4",
+	"sync":                 "disabled", "logbias": "throughput",
+}) + zfsListRow("tank/iscsi0", "volume", map[string]string{
+	"used": "107374182400", "available": "5685034868736", "referenced": "53687091200",
+	"reservation": "0", "refreservation": "107374182400", "volblocksize": "16384",
+}) + zfsListRow("tank/home@daily", "snapshot", map[string]string{
+	"used": "1048576", "referenced": "2199023255552",
+	"guid": "11688051645833741336", "createtxg": "2801357",
+}) + zfsListRow("tank/vm1", "volume", map[string]string{
+	"used": "1048576", "referenced": "53687091200", "origin": "tank/iscsi0@golden",
+}) + zfsListRow("tank/vm2", "volume", map[string]string{
+	"used": "1048576", "referenced": "53687091200", "origin": "tank/iscsi0@golden",
+})
+
+func TestParseDatasets(t *testing.T) {
+	var datasets []dataset
+	stats, err := parseDatasets(strings.NewReader(zfsListOutput), datasetFilter{}, func(d *dataset) {
+		datasets = append(datasets, *d)
+	})
+	if err != nil {
+		t.Fatalf("Error in parseDatasets (%s)", err)
+	}
+	if stats.skipped != 2 {
+		t.Errorf("Incorrect amount of skipped rows (%d), should be 2.", stats.skipped)
+	}
+	if len(datasets) != 7 {
+		t.Fatalf("Incorrect amount of datasets (%d), should be 7.", len(datasets))
+	}
+	home := datasets[1]
+	for property, want := range map[string]float64{
+		"used":       2199023255552,
+		"available":  5685034868736,
+		"referenced": 2199023255552,
+		"quota":      3298534883328,
+
+		"usedbydataset":        2190433320960,
+		"usedbysnapshots":      8589934592,
+		"usedbychildren":       0,
+		"usedbyrefreservation": 0,
+
+		"logicalused":       3298534883328,
+		"logicalreferenced": 3285649981440,
+
+		"recordsize": 131072,
+		"mounted":    1,
+		"origin":     0,
+		"sync":       0,
+	} {
+		if v, ok := home.value(property); !ok || v != want {
+			t.Errorf("Incorrect %s for tank/home (%v), should be %v", property, v, want)
+		}
+	}
+	if strings.Join(home.infoLabels(), ",") != "/tank/home,on,1538210947763220176,284,standard,latency" {
+		t.Errorf("Incorrect info labels for tank/home: %v", home.infoLabels())
+	}
+	vmail := datasets[2]
+	if v, ok := vmail.value("mounted"); !ok || v != 0 {
+		t.Errorf("Incorrect mounted for tank/vmail (%v), should be 0", v)
+	}
+	if v, ok := vmail.value("sync"); !ok || v != 1 {
+		t.Errorf("Incorrect sync_disabled for tank/vmail (%v), should be 1", v)
+	}
+	if labels := vmail.infoLabels(); labels[4] != "disabled" || labels[5] != "throughput" {
+		t.Errorf("Incorrect sync and logbias labels for tank/vmail: %v", labels)
+	}
+	if v, ok := vmail.value("receive_resume_token"); !ok || v != 1 {
+		t.Errorf("Incorrect receive_resume_token_present for tank/vmail (%v), should be 1", v)
+	}
+	if v, ok := home.value("receive_resume_token"); !ok || v != 0 {
+		t.Errorf("Incorrect receive_resume_token_present for tank/home (%v), should be 0", v)
+	}
+	for property, want := range map[string]float64{"filesystem_count": 0, "snapshot_limit": 20, "snapshot_count": 3} {
+		if v, ok := vmail.value(property); !ok || v != want {
+			t.Errorf("Incorrect %s for tank/vmail (%v), should be %v", property, v, want)
+		}
+	}
+	if _, ok := vmail.value("filesystem_limit"); ok {
+		t.Errorf("filesystem_limit=none should not be exported")
+	}
+	if _, ok := home.value("snapshot_count"); ok {
+		t.Errorf("snapshot_count should not be exported where it is not tracked")
+	}
+	snapshot := datasets[4]
+	if snapshot.kind != "snapshot" {
+		t.Errorf("Incorrect type for tank/home@daily (%s), should be snapshot", snapshot.kind)
+	}
+	if _, ok := snapshot.value("available"); ok {
+		t.Errorf("available should not be applicable to snapshots")
+	}
+	if _, ok := snapshot.value("mounted"); ok {
+		t.Errorf("mounted should not be applicable to snapshots")
+	}
+	if _, ok := snapshot.value("sync"); ok {
+		t.Errorf("sync should not be applicable to snapshots")
+	}
+	if _, ok := home.value("volblocksize"); ok {
+		t.Errorf("volblocksize should not be applicable to filesystems")
+	}
+	if strings.Join(snapshot.infoLabels(), ",") != ",,11688051645833741336,2801357,," {
+		t.Errorf("Not applicable info labels should be empty, with the guid and createtxg of the snapshot: %v", snapshot.infoLabels())
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	for value, want := range map[string]float64{"0": 0, "100": 100} {
+		if v, err := parseLimit(value); err != nil || v != want {
+			t.Errorf("Incorrect limit %q (%v, %v), should be %v", value, v, err, want)
+		}
+	}
+	for _, value := range []string{"none", "-", "18446744073709551615"} {
+		if v, err := parseLimit(value); err != nil || !math.IsNaN(v) {
+			t.Errorf("Limit %q should not be set, got %v (%v)", value, v, err)
+		}
+	}
+	if _, err := parseLimit("lots"); err == nil {
+		t.Errorf("Invalid limit should produce error in parseLimit")
+	}
+}
+
+func TestParseSyncDisabled(t *testing.T) {
+	for value, want := range map[string]float64{"standard": 0, "always": 0, "disabled": 1} {
+		if v, err := parseSyncDisabled(value); err != nil || v != want {
+			t.Errorf("Incorrect sync_disabled of %q (%v, %v), should be %v", value, v, err, want)
+		}
+	}
+	if v, err := parseSyncDisabled("-"); err != nil || !math.IsNaN(v) {
+		t.Errorf("sync - should not apply, got %v (%v)", v, err)
+	}
+	if _, err := parseSyncDisabled("sometimes"); err == nil {
+		t.Errorf("Invalid sync should produce error in parseSyncDisabled")
+	}
+}
+
+func TestDatasetCollector(t *testing.T) {
+	r := staticRunner{
+		"zfs list -Hp -o " + strings.Join(datasetColumns, ",") + " -t filesystem,volume,snapshot -r tank": zfsListOutput,
+	}
+	c := newDatasetCollector(datasetOptions{maxDepth: -1, types: []string{"filesystem", "volume", "snapshot"}})
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		if err := c.collect(r, []zpool{{name: "tank"}}, ch); err != nil {
+			t.Errorf("Error in collect (%s)", err)
+		}
+		close(ch)
+	}()
+	names := map[string]int{}
+	for m := range ch {
+		names[descName(m.Desc())]++
+	}
+	for name, want := range map[string]int{
+		"zfs_dataset_used_bytes":  3,
+		"zfs_dataset_quota_bytes": 1, // none is omitted
+		"zfs_volume_used_bytes":   3,
+		"zfs_volume_quota_bytes":  0,
+
+		"zfs_volume_refreservation_bytes": 1, // thick provisioned
+		"zfs_volume_reservation_bytes":    0, // none is omitted
+		"zfs_snapshot_used_bytes":         1,
+		"zfs_snapshot_available_bytes":    0,
+
+		"zfs_dataset_recordsize_bytes":   2,
+		"zfs_dataset_volblocksize_bytes": 0,
+		"zfs_volume_recordsize_bytes":    0,
+		"zfs_volume_volblocksize_bytes":  1,
+	} {
+		if names[name] != want {
+			t.Errorf("Incorrect amount of %s series (%d), should be %d.", name, names[name], want)
+		}
+	}
+	if v := testutil.ToFloat64(c.malformed); v != 2 {
+		t.Errorf("Incorrect malformed count (%v), should be 2.", v)
+	}
+}
+
+func TestDatasetCollectorRootsOnly(t *testing.T) {
+	r := staticRunner{
+		"zfs list -Hp -o " + strings.Join(datasetColumns, ",") + " -t filesystem -d 0 tank backup": zfsListRow("tank", "filesystem", map[string]string{
+			"used": "1000", "available": "9000", "referenced": "100", "logicalused": "1500", "compressratio": "1.50", "mounted": "yes", "mountpoint": "/tank",
+		}) + zfsListRow("backup", "filesystem", map[string]string{"used": "2000", "available": "8000"}),
+	}
+	c := newDatasetCollector(datasetOptions{maxDepth: -1, types: []string{"volume"}, rootsOnly: true})
+	described := map[string]bool{}
+	descs := make(chan *prometheus.Desc, 100)
+	c.describe(descs)
+	close(descs)
+	for d := range descs {
+		described[descName(d)] = true
+	}
+
+	ch := make(chan prometheus.Metric, 100)
+	if err := c.collect(r, []zpool{{name: "tank"}, {name: "backup"}}, ch); err != nil {
+		t.Fatalf("Error in collect (%s)", err)
+	}
+	close(ch)
+	got := map[string]float64{}
+	for m := range ch {
+		name := descName(m.Desc())
+		if !described[name] {
+			t.Errorf("Roots only collector exported undescribed %s", name)
+		}
+		if strings.HasPrefix(name, "zfs_dataset_") {
+			got[name+" "+metricLabel(m, "name")] = metricValue(m)
+		}
+	}
+	want := map[string]float64{
+		"zfs_dataset_used_bytes tank":         1000,
+		"zfs_dataset_available_bytes tank":    9000,
+		"zfs_dataset_referenced_bytes tank":   100,
+		"zfs_dataset_logical_used_bytes tank": 1500,
+		"zfs_dataset_compression_ratio tank":  1.5,
+		"zfs_dataset_used_bytes backup":       2000,
+		"zfs_dataset_available_bytes backup":  8000,
+	}
+	if len(got) != len(want) {
+		t.Errorf("Incorrect root dataset metrics %v, should be %v", got, want)
+	}
+	for key, v := range want {
+		if got[key] != v {
+			t.Errorf("Incorrect %s (%v), should be %v", key, got[key], v)
+		}
+	}
+}
+
+func TestParseRatio(t *testing.T) {
+	for value, want := range map[string]float64{"1.50": 1.5, "2.03x": 2.03, "1.00x": 1} {
+		if v, err := parseRatio(value); err != nil || v != want {
+			t.Errorf("Incorrect ratio of %q (%v, %v), should be %v", value, v, err, want)
+		}
+	}
+	if v, err := parseRatio("-"); err != nil || !math.IsNaN(v) {
+		t.Errorf("Ratio - should not apply, got %v (%v)", v, err)
+	}
+}
+
+func TestDatasetCollectorLimit(t *testing.T) {
+	r := staticRunner{
+		"zfs list -Hp -o " + strings.Join(datasetColumns, ",") + " -t filesystem,volume,snapshot -r tank": zfsListOutput,
+	}
+	for max, want := range map[int]float64{2: 1, 100: 0} {
+		c := newDatasetCollector(datasetOptions{maxDepth: -1, types: []string{"filesystem", "volume", "snapshot"}, maxDatasets: max})
+		ch := make(chan prometheus.Metric)
+		go func() {
+			if err := c.collect(r, []zpool{{name: "tank"}}, ch); err != nil {
+				t.Errorf("Error in collect (%s)", err)
+			}
+			close(ch)
+		}()
+		datasets := map[string]bool{}
+		truncated := -1.0
+		for m := range ch {
+			if m.Desc() == datasetsTruncatedDesc {
+				truncated = metricValue(m)
+			} else if name := metricLabel(m, "name"); name != "" {
+				datasets[name] = true
+			}
+		}
+		if truncated != want {
+			t.Errorf("Incorrect truncated with limit %d (%v), should be %v", max, truncated, want)
+		}
+		if want == 1 && len(datasets) != max {
+			t.Errorf("Incorrect datasets with limit %d (%v), should be the first %d", max, datasets, max)
+		}
+	}
+}
+
+func TestDatasetFilter(t *testing.T) {
+	f, err := newDatasetFilter("tank/home(/.*)?|tank/vmail|tank/broken", "tank/home/private")
+	if err != nil {
+		t.Fatalf("Error in newDatasetFilter (%s)", err)
+	}
+	for name, want := range map[string]bool{
+		"tank":              false,
+		"tank/home":         true,
+		"tank/home/alice":   true,
+		"tank/home/private": false, // exclude wins
+		"tank/vmail":        true,
+		"tank/vmail2":       false, // anchored
+		"tank/docker/abc":   false,
+	} {
+		if got := f.matches(name); got != want {
+			t.Errorf("matches(%q) = %v, should be %v", name, got, want)
+		}
+	}
+
+	// Filtered rows are never parsed, so the malformed tank/broken row is
+	// the only one skipped here.
+	var names []string
+	stats, err := parseDatasets(strings.NewReader(zfsListOutput), f, func(d *dataset) {
+		names = append(names, d.name)
+	})
+	if err != nil {
+		t.Fatalf("Error in parseDatasets (%s)", err)
+	}
+	if stats.filtered != 6 || stats.skipped != 1 {
+		t.Errorf("Incorrect stats %+v, should be 6 filtered and 1 skipped.", stats)
+	}
+	if strings.Join(names, ",") != "tank/home,tank/vmail" {
+		t.Errorf("Incorrect datasets %v", names)
+	}
+
+	if _, err := newDatasetFilter("(", ""); err == nil {
+		t.Errorf("Invalid expression should produce error in newDatasetFilter")
+	}
+}
+
+func TestDatasetListArgs(t *testing.T) {
+	pools := []zpool{{name: "tank"}, {name: "backup"}}
+	columns := "list -Hp -o " + strings.Join(datasetColumns, ",")
+	for depth, want := range map[int]string{
+		-1: columns + " -t filesystem,volume -r tank backup",
+		0:  columns + " -t filesystem,volume -d 0 tank backup",
+		1:  columns + " -t filesystem,volume -d 1 tank backup",
+	} {
+		c := newDatasetCollector(datasetOptions{maxDepth: depth})
+		if got := strings.Join(c.listCommand(pools).args, " "); got != want {
+			t.Errorf("listCommand with depth %d = %q, should be %q", depth, got, want)
+		}
+	}
+}
+
+func TestParseDatasetTypes(t *testing.T) {
+	types, err := parseDatasetTypes("volume, snapshot")
+	if err != nil {
+		t.Fatalf("Error in parseDatasetTypes (%s)", err)
+	}
+	if strings.Join(types, ",") != "volume,snapshot" {
+		t.Errorf("Incorrect types %v", types)
+	}
+	if _, err := parseDatasetTypes("filesystem,bookmark"); err == nil {
+		t.Errorf("Unknown type should produce error in parseDatasetTypes")
+	}
+}
+
+// metricValue returns the value of a gauge or counter.
+func metricValue(m prometheus.Metric) float64 {
+	var pb dto.Metric
+	m.Write(&pb)
+	if pb.Counter != nil {
+		return pb.Counter.GetValue()
+	}
+	return pb.Gauge.GetValue()
+}
+
+// metricLabel returns the value of the named label of m.
+func metricLabel(m prometheus.Metric, name string) string {
+	var pb dto.Metric
+	m.Write(&pb)
+	for _, l := range pb.Label {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+// BenchmarkParseDatasets parses the zfs list output of 600 datasets.
+func BenchmarkParseDatasets(b *testing.B) {
+	var output strings.Builder
+	for i := 0; i < 600; i++ {
+		output.WriteString(zfsListRow(fmt.Sprintf("tank/home/user%d", i), "filesystem", map[string]string{
+			"used": "2199023255552", "available": "5685034868736", "referenced": "2199023255552", "quota": "3298534883328",
+			"usedbydataset": "2190433320960", "usedbysnapshots": "8589934592", "usedbychildren": "0", "usedbyrefreservation": "0",
+			"logicalused": "3298534883328", "logicalreferenced": "3285649981440", "written": "4294967296",
+			"mounted": "yes", "mountpoint": "/tank/home", "canmount": "on",
+		}))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		n := 0
+		if _, err := parseDatasets(strings.NewReader(output.String()), datasetFilter{}, func(d *dataset) { n++ }); err != nil || n != 600 {
+			b.Fatalf("Parsed %d datasets (%v)", n, err)
+		}
+	}
+}
@@ -1,28 +1,202 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
-	"os/exec"
+	"io"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type zpool struct {
-	name     string
-	capacity int64
-	healthy  bool
-	status   string
-	online   int64
-	faulted  int64
+	name          string
+	size          uint64
+	alloc         uint64
+	free          uint64
+	capacity      int64
+	fragmentation int64 // -1 when zpool does not report it
+	health        string
+	readonly      bool
+	altroot       string // empty when not set
+	cachefile     string // empty for the default cachefile, "none" for no cachefile
+	properties    poolProperties
+	rootUsed      uint64 // used and available of the root dataset, see getRootSpace
+	rootAvailable uint64
+	rootSpace     bool // rootUsed and rootAvailable are known
+	healthy       bool
+	status        string
+	online        int64
+	faulted       int64
+	configured    int64 // every provider, whatever its state
+	logsFaulted   int64 // the faulted ones of faulted in the logs section
+	cacheFaulted  int64 // the faulted ones of faulted in the cache section
+	devices       []statusDevice
+	indirect      int64 // indirect vdevs left by removed top-level vdevs
+	removal       removalStatus
+	statusReason  string // code of the status: advisory, "" when there is none
+	creation      int64  // unix time, 0 when unknown; fetched once by getCreationTimes
+	detailed      bool   // creation and ashifts were fetched, see Exporter.fetchDetails
+	scan          scanStatus
+	lastScrub     time.Time   // end of the last finished scrub seen, kept across scans
+	scrubResult   scrubResult // of lastScrub
+	lastScan      time.Time   // end of the last scrub or resilver seen to finish or be canceled
+	scanCanceled  bool        // whether that scan was canceled
+	ddt           *ddtStats   // nil unless zpool status -D showed a dedup table
+	dataErrors    permanentErrors
+	vdevs         []vdevStats
+	ashifts       []vdevAshift    // fetched once by getAshifts
+	activities    activityStatus  // only with poolOptions.activities
+	slowIOs       []deviceSlowIOs // only with poolOptions.slowIOs
+	statusTime    time.Time       // when the zpool status fields were last updated
+	warnings      []string        // what could not be parsed of the last zpool status
+	err           error           // why the last collection failed, nil if it succeeded
 }
 
+// poolProperties are descriptive pool properties that only zpool get shows.
+// Unset properties are empty.
+type poolProperties struct {
+	comment string
+	bootfs  string
+	version string // empty for pools with feature flags
+	guid    string
+}
+
+// poolPropertyNames are the properties getProperties fetches.
+var poolPropertyNames = []string{"comment", "bootfs", "version", "guid"}
+
+// propertiesCommand is the zpool get of getProperties.
+func propertiesCommand(pools []zpool) command {
+	args := []string{"get", "-H", "-o", "name,property,value", strings.Join(poolPropertyNames, ",")}
+	return command{"zpool", append(args, poolNames(pools)...)}
+}
+
+// getProperties refreshes the poolProperties of every pool with a single
+// zpool get.
+func getProperties(r commandRunner, pools []zpool) error {
+	output, err := propertiesCommand(pools).run(r)
+	if err != nil {
+		return fmt.Errorf("zpool get %s: %s", strings.Join(poolPropertyNames, ","), strings.TrimSpace(output))
+	}
+	parseProperties(output, pools)
+	return nil
+}
+
+// parseProperties parses zpool get -H -o name,property,value output.
+func parseProperties(output string, pools []zpool) {
+	props := map[string]*poolProperties{}
+	for i := range pools {
+		pools[i].properties = poolProperties{}
+		props[pools[i].name] = &pools[i].properties
+	}
+	for lines := newLineScanner(output); lines.scan(); {
+		line := lines.line
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 || props[fields[0]] == nil {
+			continue
+		}
+		p, value := props[fields[0]], dashToEmpty(fields[2])
+		switch fields[1] {
+		case "comment":
+			p.comment = value
+		case "bootfs":
+			p.bootfs = value
+		case "version":
+			p.version = value
+		case "guid":
+			p.guid = value
+		}
+	}
+}
+
+// ddtStats summarizes the dedup table of a pool.
+type ddtStats struct {
+	entries uint64
+	onDisk  uint64 // total size, entries times the per-entry size
+	inCore  uint64
+}
+
+// parseDedup parses the "dedup: DDT entries N, size X on disk, Y in core"
+// line printed by zpool status -D, where X and Y are per-entry sizes. It
+// returns nil when the pool has no dedup table.
+func parseDedup(output string) (*ddtStats, error) {
+	for lines := newLineScanner(output); lines.scan(); {
+		line := lines.line
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "dedup: DDT entries ") {
+			continue
+		}
+		var entries, onDisk, inCore uint64
+		_, err := fmt.Sscanf(line, "dedup: DDT entries %d, size %d on disk, %d in core", &entries, &onDisk, &inCore)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %q: %s", line, err)
+		}
+		return &ddtStats{entries: entries, onDisk: entries * onDisk, inCore: entries * inCore}, nil
+	}
+	return nil, nil // "dedup: no DDT entries", or dedup never enabled
+}
+
+// getRootSpace refreshes the used and available space of the root dataset
+// of every pool with a single zfs get. Unlike the size and alloc of zpool
+// list, these leave out the raidz parity and the slop space ZFS reserves, so
+// they tell how much can still be written.
+func getRootSpace(r commandRunner, pools []zpool) error {
+	output, err := rootSpaceCommand(pools).run(r)
+	if err != nil {
+		return fmt.Errorf("zfs get used,available: %s", strings.TrimSpace(output))
+	}
+	parseRootSpace(output, pools)
+	return nil
+}
+
+// rootSpaceCommand is the zfs get of getRootSpace.
+func rootSpaceCommand(pools []zpool) command {
+	return command{"zfs", append([]string{"get", "-Hp", "-o", "name,property,value", "used,available"}, poolNames(pools)...)}
+}
+
+// parseRootSpace parses zfs get -Hp -o name,property,value used,available
+// output. Pools missing either property are left without rootSpace.
+func parseRootSpace(output string, pools []zpool) {
+	used, available := map[string]uint64{}, map[string]uint64{}
+	for lines := newLineScanner(output); lines.scan(); {
+		fields := strings.Split(lines.line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[1] {
+		case "used":
+			used[fields[0]] = v
+		case "available":
+			available[fields[0]] = v
+		}
+	}
+	for i := range pools {
+		z := &pools[i]
+		u, okUsed := used[z.name]
+		a, okAvail := available[z.name]
+		z.rootUsed, z.rootAvailable, z.rootSpace = u, a, okUsed && okAvail
+	}
+}
+
+// zpoolListProperties are the columns requested from zpool list, in order.
+var zpoolListProperties = []string{"name", "size", "alloc", "free", "cap", "frag", "health", "readonly", "altroot", "cachefile"}
+
+// poolHealthStates are the health values zpool list and zpool status report
+// for a pool, such as SUSPENDED for a pool that stopped I/O after the
+// failure of its devices.
+var poolHealthStates = []string{"ONLINE", "DEGRADED", "FAULTED", "OFFLINE", "REMOVED", "UNAVAIL", "SUSPENDED"}
+
 func (z *zpool) checkHealth(output string) (err error) {
 	output = strings.Trim(output, "\n")
 	if output == "ONLINE" {
 		z.healthy = true
-	} else if output == "DEGRADED" || output == "FAULTED" {
+	} else if stringInSlice(output, poolHealthStates) {
 		z.healthy = false
 	} else {
 		z.healthy = false // just to make sure
@@ -33,79 +207,675 @@ func (z *zpool) checkHealth(output string) (err error) {
 
 func (z *zpool) getCapacity(output string) (err error) {
 	s := strings.Split(output, "%")[0]
-	z.capacity, err = strconv.ParseInt(s, 0, 8)
+	z.capacity, err = strconv.ParseInt(s, 10, 64)
 	if err != nil {
 		return err
 	}
+	if z.capacity < 0 || z.capacity > 100 {
+		return fmt.Errorf("capacity %d%% out of range", z.capacity)
+	}
 	return err
 }
 
-func (z *zpool) getProviders(output string) (err error) {
-	nonProviderLines := []string{
-		z.name,
-		"state:",
-		"mirror-",
-		"raid0-",
-		"raid10-",
-		"raidz-",
-		"raidz2-",
-		"raidz3-",
+// parseFragmentation parses the frag column of zpool list, -1 for the "-"
+// of pools and vdevs zpool does not report it for.
+func parseFragmentation(s string) (int64, error) {
+	if s == "-" {
+		return -1, nil
 	}
-	lines := strings.Split(output, "\n")
-	z.status = strings.Split(lines[1], " ")[2]
-
-	// Count all providers, ONLINE and FAULTED
-	var fcount int64
-	var dcount int64
-	for _, line := range lines {
-		if (strings.Contains(line, "FAULTED") || strings.Contains(line, "UNAVAIL")) && !substringInSlice(line, nonProviderLines) {
-			fcount = fcount + 1
-		} else if strings.Contains(line, "ONLINE") && !substringInSlice(line, nonProviderLines) {
-			dcount = dcount + 1
-		}
+	frag, err := strconv.ParseInt(strings.TrimSuffix(s, "%"), 10, 64)
+	if err != nil {
+		return 0, err
 	}
-	z.faulted = fcount
-	z.online = dcount
+	if frag < 0 || frag > 100 {
+		return 0, fmt.Errorf("fragmentation %d%% out of range", frag)
+	}
+	return frag, nil
+}
+
+// getProviders sets the pool state and counts the online and faulted
+// providers in the config section of zpool status output.
+func (z *zpool) getProviders(output string) error {
+	status, _ := readStatus(strings.NewReader(output), z.name, poolOptions{})
+	return z.setProviders(status)
+}
 
-	if z.status != "ONLINE" && z.status != "DEGRADED" && z.status != "FAULTED" {
+// setProviders is getProviders on output read with readStatus.
+func (z *zpool) setProviders(s *statusOutput) (err error) {
+	z.status = s.state
+	config := s.config.roots
+	z.online, z.faulted = countProviders(config)
+	z.configured = countConfigured(config)
+	z.logsFaulted = countClassFaulted(config, "logs")
+	z.cacheFaulted = countClassFaulted(config, "cache")
+	z.indirect = countIndirect(config)
+	z.devices = leafDevices(config)
+	z.removal = parseRemoval(s.rest.String())
+
+	switch {
+	case z.status == "":
+		z.faulted = 1
+		err = newFormatError("zpool status", "no state: line", s.head)
+	case len(z.devices) == 0:
+		// Every pool has a device, so the config section changed.
+		z.faulted = 1
+		lines := s.configHead
+		if len(lines) == 0 {
+			lines = s.head
+		}
+		err = newFormatError("zpool status", "no devices recognized in the config section", lines)
+	case !stringInSlice(z.status, poolHealthStates):
 		z.faulted = 1 // fake faulted if there is a parsing error or other status
 		err = errors.New("Error parsing faulted/unavailable providers")
 	}
 	return
 }
 
-func (z *zpool) getStatus() {
-	output := runZpoolCommand([]string{"status", z.name})
-	err := z.getProviders(output)
+// formatError is a zpool output the parsers do not recognize, as when a
+// release changed it. Unlike other collection errors it is counted in
+// zfs_exporter_parse_errors_total and logged with the offending lines, so
+// that it does not go unnoticed as zeros.
+type formatError struct {
+	command string // such as "zpool status"
+	problem string
+	lines   []string // the first offending lines
+}
+
+func newFormatError(command, problem string, lines []string) *formatError {
+	return &formatError{command: command, problem: problem, lines: lines}
+}
+
+func (e *formatError) Error() string {
+	return fmt.Sprintf("unrecognized %s output: %s", e.command, e.problem)
+}
+
+// excerptLines is how many lines of unrecognized output are logged.
+const excerptLines = 5
+
+// statusOutput is the zpool status output of a pool, parsed as it is read.
+// The config section lists every device, which makes up most of the output
+// of a large pool, so its lines are handed to the parsers that need them and
+// dropped. Only the other sections are kept, for the parsers of the status:,
+// scan: and remove: sections and the like.
+type statusOutput struct {
+	state      string
+	config     configParser
+	slowIOs    *slowIOParser   // with poolOptions.slowIOs
+	activities *activityParser // with poolOptions.activities
+	rest       strings.Builder // the output outside the config section
+
+	lines            int
+	head, configHead []string // the first lines, and from config:, for formatError
+}
+
+func newStatusOutput(pool string, opts poolOptions) *statusOutput {
+	s := &statusOutput{}
+	if opts.slowIOs {
+		s.slowIOs = &slowIOParser{pool: pool, column: -1}
+	}
+	if opts.activities {
+		s.activities = newActivityParser()
+	}
+	return s
+}
+
+func (s *statusOutput) line(line string) {
+	s.lines++
+	if s.lines == 2 {
+		if fields := strings.Split(line, " "); len(fields) > 2 {
+			s.state = fields[2]
+		}
+	}
+	if len(s.head) < excerptLines {
+		s.head = append(s.head, line)
+	}
+	if (len(s.configHead) > 0 && len(s.configHead) < excerptLines) || (len(s.configHead) == 0 && strings.HasPrefix(strings.TrimSpace(line), "config:")) {
+		s.configHead = append(s.configHead, line)
+	}
+	fields := strings.Fields(line)
+	if s.slowIOs != nil {
+		s.slowIOs.line(fields)
+	}
+	if s.activities != nil {
+		s.activities.line(line)
+	}
+	s.config.line(line, fields)
+	if s.config.inConfig && !s.config.done {
+		return // the NAME header or a device
+	}
+	s.rest.WriteString(line)
+	s.rest.WriteByte('\n')
+}
+
+// readStatus parses the zpool status output of pool from r as it is read.
+func readStatus(r io.Reader, pool string, opts poolOptions) (*statusOutput, error) {
+	s := newStatusOutput(pool, opts)
+	return s, scanOutput(r, s.line)
+}
+
+// dashToEmpty returns "" for the "-" zpool prints for unset properties.
+func dashToEmpty(value string) string {
+	if value == "-" {
+		return ""
+	}
+	return value
+}
+
+// setListFields fills in the fields derived from one row of
+// zpool list -Hp -o <zpoolListProperties>.
+func (z *zpool) setListFields(fields []string) (err error) {
+	if len(fields) != len(zpoolListProperties) {
+		return fmt.Errorf("expected %d zpool list columns, got %d", len(zpoolListProperties), len(fields))
+	}
+	if z.size, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+		return err
+	}
+	if z.alloc, err = strconv.ParseUint(fields[2], 10, 64); err != nil {
+		return err
+	}
+	if z.free, err = strconv.ParseUint(fields[3], 10, 64); err != nil {
+		return err
+	}
+	if err = z.getCapacity(fields[4]); err != nil {
+		return newFormatError("zpool list", fmt.Sprintf("capacity column: %s", err), []string{strings.Join(fields, "\t")})
+	}
+	if z.fragmentation, err = parseFragmentation(fields[5]); err != nil {
+		return err
+	}
+	z.health = fields[6]
+	z.readonly = fields[7] == "on"
+	z.altroot = dashToEmpty(fields[8])
+	z.cachefile = dashToEmpty(fields[9])
+	return z.checkHealth(fields[6])
+}
+
+// parseZpoolList updates every pool in pools from the output of a single
+// zpool list invocation. Rows for pools that are not monitored are ignored.
+func parseZpoolList(output string, pools []zpool) error {
+	rows := make(map[string][]string, len(pools))
+	for lines := newLineScanner(output); lines.scan(); {
+		line := lines.line
+		fields := strings.Split(line, "\t")
+		if len(fields) != len(zpoolListProperties) {
+			continue // stderr noise such as "cannot open 'x': no such pool"
+		}
+		rows[fields[0]] = fields
+	}
+	for i := range pools {
+		fields, ok := rows[pools[i].name]
+		switch {
+		case !ok && strings.Contains(output, "cannot open '"+pools[i].name+"': no such pool"):
+			pools[i].err = fmt.Errorf("pool %s: %w", pools[i].name, errNoSuchPool)
+			continue
+		case !ok:
+			pools[i].err = fmt.Errorf("pool %s missing from zpool list output", pools[i].name)
+			continue
+		}
+		if err := pools[i].setListFields(fields); err != nil {
+			pools[i].err = fmt.Errorf("pool %s: %w", pools[i].name, err)
+		}
+	}
+	return poolsError(pools)
+}
+
+// errNoSuchPool is the error of a pool zpool list says does not exist.
+var errNoSuchPool = errors.New("no such pool")
+
+// onlyMissing reports whether every pool that failed to collect does not
+// exist, as opposed to failing for another reason.
+func onlyMissing(pools []zpool) bool {
+	for _, pool := range pools {
+		if pool.err != nil && !errors.Is(pool.err, errNoSuchPool) {
+			return false
+		}
+	}
+	return true
+}
+
+// onCollected calls fn with the pools that did not fail so far, and copies
+// what fn changed back into pools.
+func onCollected(pools []zpool, fn func([]zpool) error) error {
+	return onPools(pools, func(z *zpool) bool { return z.err == nil }, fn)
+}
+
+// onPools calls fn with the pools for which in is true, and copies what fn
+// changed back into pools. fn is not called when there are none.
+func onPools(pools []zpool, in func(*zpool) bool, fn func([]zpool) error) error {
+	var subset []zpool
+	for i := range pools {
+		if in(&pools[i]) {
+			subset = append(subset, pools[i])
+		}
+	}
+	switch len(subset) {
+	case 0:
+		return nil
+	case len(pools):
+		return fn(pools)
+	}
+	err := fn(subset)
+	j := 0
+	for i := range pools {
+		if in(&pools[i]) {
+			pools[i] = subset[j]
+			j++
+		}
+	}
+	return err
+}
+
+// poolsError returns the error of the first pool when the collection of
+// every pool failed, and nil when at least one pool was collected.
+func poolsError(pools []zpool) error {
+	for _, pool := range pools {
+		if pool.err == nil {
+			return nil
+		}
+	}
+	if len(pools) == 0 {
+		return nil
+	}
+	return pools[0].err
+}
+
+// listCommand is the zpool list of listPools.
+func listCommand(pools []zpool) command {
+	return command{"zpool", append([]string{"list", "-Hp", "-o", strings.Join(zpoolListProperties, ",")}, poolNames(pools)...)}
+}
+
+// listPools collects the list-derived fields for all pools with one zpool
+// list invocation, rather than one per pool and property. zpool list exits
+// with an error when a pool is missing while it lists the others, so only an
+// error of the run that left no output, such as a canceled collection, is
+// returned as it is.
+func listPools(r commandRunner, pools []zpool) error {
+	output, err := listCommand(pools).run(r)
+	switch {
+	case unreachable(err):
+		return err
+	case errors.Is(err, context.Canceled):
+		return fmt.Errorf("zpool list: %w", err)
+	}
+	if parseErr := parseZpoolList(output, pools); parseErr != nil {
+		if err != nil {
+			return fmt.Errorf("%w (zpool list: %w)", parseErr, err)
+		}
+		return parseErr
+	}
+	return nil
+}
+
+// setScan records the scan: section of zpool status output. zpool status
+// only shows the most recent scan, so the end of the last finished scrub,
+// and that of the last scan that ended at all, are remembered while a
+// resilver or a new scrub is shown.
+func (z *zpool) setScan(output string) {
+	z.scan = parseScan(output)
+	if ended := z.scan.state == "finished" || z.scan.state == "canceled"; ended && !z.scan.end.IsZero() && !z.scan.end.Before(z.lastScan) {
+		z.lastScan, z.scanCanceled = z.scan.end, z.scan.state == "canceled"
+	}
+	// A scrub loaded from --scrub.state-file is kept until a newer one.
+	if z.scan.function == "scrub" && z.scan.state == "finished" && !z.scan.end.IsZero() && !z.scan.end.Before(z.lastScrub) {
+		z.lastScrub, z.scrubResult = z.scan.end, z.scan.result
+	}
+}
+
+// neverScrubbed reports whether zpool status says no scan was ever
+// requested and no scrub has been seen since.
+func (z *zpool) neverScrubbed() bool {
+	return z.scan.function == "" && z.lastScrub.IsZero()
+}
+
+// poolOptions select the optional pool details to collect.
+type poolOptions struct {
+	dedup      bool // zpool status -D
+	vdevs      bool // zpool list -v
+	activities bool // zpool status -i -t
+	slowIOs    bool // zpool status -s, set when probeSlowIOs succeeds
+	parsable   bool // zpool status -p, set when probeParsable succeeds
+	enclosures bool // enclosure slots of the leaf vdevs from sysfs
+	guids      bool // guid labels of the leaf vdevs from zpool status -g
+	// errorEntries is how many entries of the permanent error list of
+	// zpool status -v to export, 0 for none and not running -v.
+	errorEntries int
+	showPaths    bool // show the paths of errorEntries instead of hashes
+	// ignoreMissing treats pools that do not exist like pools that fail to
+	// collect, rather than failing, until they are imported.
+	ignoreMissing bool
+
+	// healthyInterval enables the zpool status -x fast path when positive:
+	// the full status of pools that zpool status -x reports healthy is only
+	// refreshed this often.
+	healthyInterval time.Duration
+
+	// expectedProviders is the number of providers each pool should have,
+	// from -expected-providers; pools without one have no expectation.
+	expectedProviders map[string]int64
+
+	// tags are the tags of the pools from --pool, such as boot for
+	// bpool:boot; untagged pools are left out.
+	tags map[string]string
+}
+
+// statusArgs returns the zpool status arguments for the pools.
+func (o poolOptions) statusArgs(pools ...string) []string {
+	args := []string{"status"}
+	if o.dedup {
+		args = append(args, "-D")
+	}
+	if o.activities {
+		args = append(args, "-i", "-t")
+	}
+	if o.slowIOs {
+		args = append(args, "-s")
+	}
+	if o.errorEntries > 0 {
+		args = append(args, "-v")
+	}
+	if o.parsable {
+		args = append(args, "-p")
+	}
+	return append(args, pools...)
+}
+
+// statusXArgs returns the zpool status -x arguments for the pools.
+func (o poolOptions) statusXArgs(pools ...string) []string {
+	return append([]string{"status", "-x"}, o.statusArgs(pools...)[1:]...)
+}
+
+// probeParsable reports whether zpool status supports -p, which prints
+// counters as exact numbers instead of values such as "3.4K". Without it
+// the exporter expands those suffixes with parseHumanSize. It is run once
+// when the pools are set up.
+func probeParsable(r commandRunner, pool string) bool {
+	output, err := parsableProbe(pool).run(r)
+	return err == nil && strings.Contains(output, "pool: "+pool)
+}
+
+// getStatus collects the fields that only zpool status can provide.
+func (z *zpool) getStatus(r commandRunner, opts poolOptions) error {
+	output, err := r.start("zpool", opts.statusArgs(z.name)...)
 	if err != nil {
-		log.Fatal("Error parsing zpool status")
+		return fmt.Errorf("zpool status: %w", err)
+	}
+	status, err := readStatus(output, z.name, opts)
+	closeErr := output.Close()
+	switch {
+	case err != nil:
+		return fmt.Errorf("error reading zpool status of %s: %s", z.name, err)
+	case closeErr != nil && status.state == "":
+		// Nothing but the error, such as for a pool zpool cannot open.
+		return fmt.Errorf("zpool status: %w", closeErr)
+	}
+	return z.useStatus(r, status, opts)
+}
+
+// useStatus is setStatusOutput followed by the zpool status -g of the pool
+// with opts.guids.
+func (z *zpool) useStatus(r commandRunner, s *statusOutput, opts poolOptions) error {
+	if err := z.setStatusOutput(s, opts); err != nil {
+		return err
 	}
-	output = runZpoolCommand([]string{"list", "-H", "-o", "health", z.name})
-	err = z.checkHealth(output)
+	if opts.guids {
+		z.getGUIDs(r, s.config.roots)
+	}
+	return nil
+}
+
+// setStatus parses the zpool status output of the pool.
+func (z *zpool) setStatus(output string, opts poolOptions) error {
+	status, _ := readStatus(strings.NewReader(output), z.name, opts)
+	return z.setStatusOutput(status, opts)
+}
+
+// setStatusOutput is setStatus on output read with readStatus.
+func (z *zpool) setStatusOutput(s *statusOutput, opts poolOptions) error {
+	err := z.setProviders(s)
 	if err != nil {
-		log.Fatal("Error parsing zpool list -H -o health ", z.name)
+		return fmt.Errorf("error parsing zpool status of %s: %w", z.name, err)
+	}
+	rest := s.rest.String()
+	z.warnings = nil
+	z.setScan(rest)
+	z.statusReason = parseStatusReason(rest)
+	if z.dataErrors = parsePermanentErrors(rest); z.dataErrors.count < 0 {
+		z.warnings = append(z.warnings, "no errors: section")
+	}
+	if opts.activities {
+		z.activities = s.activities.result(z.scan)
 	}
-	output = runZpoolCommand([]string{"list", "-H", "-o", "cap", z.name})
-	err = z.getCapacity(output)
+	z.slowIOs = nil
+	if opts.slowIOs {
+		z.slowIOs = s.slowIOs.devices
+	}
+	if opts.enclosures {
+		z.setEnclosures()
+	}
+	if opts.dedup {
+		if z.ddt, err = parseDedup(rest); err != nil {
+			z.warnings = append(z.warnings, "zpool status -D: "+err.Error())
+			logProblem("dedup "+z.name, logFields{"POOL": z.name}, "Error parsing zpool status -D of %s: %s", z.name, err)
+		} else {
+			logResolved("dedup "+z.name, logFields{"POOL": z.name}, "Parsing zpool status -D of %s again", z.name)
+		}
+	}
+	z.statusTime = time.Now()
+	return nil
+}
+
+// setEnclosures looks up the enclosure slot of every leaf device of the
+// pool, once per device name.
+func (z *zpool) setEnclosures() {
+	slots := map[string]enclosureSlot{}
+	lookup := func(device string) enclosureSlot {
+		slot, ok := slots[device]
+		if !ok {
+			slot = lookupEnclosure(device)
+			slots[device] = slot
+		}
+		return slot
+	}
+	for i := range z.devices {
+		z.devices[i].enclosureSlot = lookup(z.devices[i].device)
+	}
+	for i := range z.slowIOs {
+		z.slowIOs[i].enclosureSlot = lookup(z.slowIOs[i].device)
+	}
+}
+
+// parseStatusX reads zpool status -x output from r, which holds the full
+// status of each pool it reports as unhealthy, parsing those as readStatus
+// does. Pools it reports healthy have no entry. ok is false when the output
+// does not account for every pool in names or could not be read.
+func parseStatusX(r io.Reader, names []string, opts poolOptions) (sick map[string]*statusOutput, ok bool) {
+	sick = map[string]*statusOutput{}
+	healthy := map[string]bool{}
+	var current *statusOutput
+	err := scanOutput(r, func(line string) {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "pool: "):
+			name := strings.TrimPrefix(trimmed, "pool: ")
+			current = newStatusOutput(name, opts)
+			sick[name] = current
+		case current != nil:
+		case trimmed == "all pools are healthy":
+			for _, name := range names {
+				healthy[name] = true
+			}
+		case strings.HasPrefix(trimmed, "pool '") && strings.HasSuffix(trimmed, "' is healthy"):
+			healthy[strings.TrimSuffix(strings.TrimPrefix(trimmed, "pool '"), "' is healthy")] = true
+		}
+		if current != nil {
+			current.line(line)
+		}
+	})
 	if err != nil {
-		log.Fatal("Error parsing zpool capacity")
+		return nil, false
+	}
+	for _, name := range names {
+		if _, found := sick[name]; !found && !healthy[name] {
+			return nil, false
+		}
 	}
+	return sick, true
 }
 
-func checkExistance(pool string) (err error) {
-	output := runZpoolCommand([]string{"list", pool})
-	if strings.Contains(fmt.Sprintf("%s", output), "no such pool") {
-		err = errors.New("No such pool")
+// collectStatusFast refreshes the status of all pools from one zpool status
+// -x, which only prints the full status of unhealthy pools. Healthy pools
+// keep their cached status until opts.healthyInterval has passed, unless it
+// was not healthy. It returns false when the -x output could not be parsed,
+// and otherwise the error of poolsError.
+func collectStatusFast(r commandRunner, pools []zpool, opts poolOptions) (bool, error) {
+	names := poolNames(collectedPools(pools))
+	if len(names) == 0 {
+		return true, poolsError(pools)
 	}
-	return
+	output, err := r.start("zpool", opts.statusXArgs(names...)...)
+	if err != nil {
+		return false, nil
+	}
+	sick, ok := parseStatusX(output, names, opts)
+	output.Close()
+	if !ok {
+		return false, nil
+	}
+	for i := range pools {
+		z := &pools[i]
+		if z.err != nil {
+			continue
+		}
+		if status, found := sick[z.name]; found {
+			z.err = z.useStatus(r, status, opts)
+		} else if z.status != "ONLINE" || z.faulted != 0 || time.Since(z.statusTime) >= opts.healthyInterval {
+			z.err = z.getStatus(r, opts)
+		}
+	}
+	return true, poolsError(pools)
+}
+
+// collectPools refreshes all pools with refreshPools. With
+// opts.ignoreMissing, pools that do not exist fail on their own without
+// failing the collection, even when no pool exists.
+func collectPools(r commandRunner, pools []zpool, opts poolOptions) error {
+	err := refreshPools(r, pools, opts)
+	if err != nil && opts.ignoreMissing && onlyMissing(pools) {
+		return nil
+	}
+	return err
 }
 
-func runZpoolCommand(args []string) string {
-	zpoolPath, err := exec.LookPath("zpool")
+// refreshPools refreshes all pools: one zpool list and one zpool get for
+// every pool, followed by a zpool status per pool. The pools are collected
+// independently: a pool that fails keeps its error in err, and refreshPools
+// only fails when every pool did.
+func refreshPools(r commandRunner, pools []zpool, opts poolOptions) error {
+	for i := range pools {
+		pools[i].err = nil
+	}
+	if err := listPools(r, pools); unreachable(err) || errors.Is(err, context.Canceled) {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("error parsing zpool list: %w", err)
+	}
+	// Pools missing from zpool list would fail these for all of them.
+	if err := onCollected(pools, func(listed []zpool) error { return getProperties(r, listed) }); err != nil {
+		logProblem("pool properties", nil, "Error collecting pool properties: %s", err)
+	} else {
+		logResolved("pool properties", nil, "Pool properties are collected again")
+	}
+	if err := onCollected(pools, func(listed []zpool) error { return getRootSpace(r, listed) }); err != nil {
+		logProblem("root space", nil, "Error collecting the space of the pool root datasets: %s", err)
+	} else {
+		logResolved("root space", nil, "The space of the pool root datasets is collected again")
+	}
+	if opts.vdevs {
+		if err := onCollected(pools, func(listed []zpool) error { return listVdevs(r, listed) }); err != nil {
+			logProblem("vdevs", nil, "Error collecting vdev metrics: %s", err)
+		} else {
+			logResolved("vdevs", nil, "Vdev metrics are collected again")
+		}
+	}
+	if opts.healthyInterval > 0 {
+		if ok, err := collectStatusFast(r, pools, opts); ok {
+			logResolved("status -x", nil, "Parsing zpool status -x output again")
+			return err
+		}
+		logProblem("status -x", nil, "Could not parse zpool status -x output, collecting the full status of every pool")
+	}
+	for i := range pools {
+		if pools[i].err == nil {
+			pools[i].err = recovered("pool", func() error { return pools[i].getStatus(r, opts) })
+		}
+	}
+	return poolsError(pools)
+}
+
+// getCreationTimes fetches the creation time of every pool from its root
+// dataset. Creation times never change, so this is only done once when the
+// pools are set up rather than on every scrape.
+func getCreationTimes(r commandRunner, pools []zpool) error {
+	output, err := creationCommand(pools).run(r)
 	if err != nil {
-		log.Fatal("Could not find zpool in PATH")
+		return fmt.Errorf("zfs get creation: %s", strings.TrimSpace(output))
+	}
+	return parseCreationTimes(output, pools)
+}
+
+// creationCommand is the zfs get of getCreationTimes.
+func creationCommand(pools []zpool) command {
+	return command{"zfs", append([]string{"get", "-Hp", "-o", "name,value", "creation"}, poolNames(pools)...)}
+}
+
+// parseCreationTimes parses zfs get -Hp -o name,value creation output.
+func parseCreationTimes(output string, pools []zpool) error {
+	times := map[string]int64{}
+	for lines := newLineScanner(output); lines.scan(); {
+		line := lines.line
+		fields := strings.Split(line, "\t")
+		if len(fields) != 2 {
+			continue
+		}
+		t, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid creation time for %s: %s", fields[0], err)
+		}
+		times[fields[0]] = t
+	}
+	for i := range pools {
+		pools[i].creation = times[pools[i].name]
+	}
+	return nil
+}
+
+// existenceCommand is the zpool list of checkExistance.
+func existenceCommand(pool string) command {
+	return command{"zpool", []string{"list", pool}}
+}
+
+// poolNameRE matches the names zpool create accepts: a letter followed by
+// letters, digits, spaces and _.:-. Starting with a letter, a pool name is
+// never taken for an option of zpool or zfs.
+var poolNameRE = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.: -]*$`)
+
+// checkExistance returns an error unless zpool list succeeds and lists pool
+// by its name.
+func checkExistance(r commandRunner, pool string) error {
+	output, err := existenceCommand(pool).run(r)
+	switch {
+	case strings.Contains(output, "no such pool"):
+		return fmt.Errorf("%w: %s", errNoSuchPool, pool)
+	case unreachable(err):
+		return err
+	case err != nil:
+		return fmt.Errorf("zpool list %s: %s", pool, strings.TrimSpace(output))
+	}
+	// The name starts the row of the pool, followed by the spaces or the tab
+	// before the size; it may have spaces of its own.
+	for lines := newLineScanner(output); lines.scan(); {
+		if rest, ok := strings.CutPrefix(lines.line, pool); ok && (rest == "" || rest[0] == ' ' || rest[0] == '\t') {
+			return nil
+		}
 	}
-	cmd := exec.Command(zpoolPath, args...)
-	out, _ := cmd.CombinedOutput()
-	return fmt.Sprintf("%s", out)
+	return fmt.Errorf("zpool list %s did not list the pool", pool)
 }
@@ -1,7 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func TestCheckHealth(t *testing.T) {
@@ -45,6 +54,59 @@ func TestCheckHealth(t *testing.T) {
 	}
 }
 
+// suspendedStatus is the zpool status of a pool whose only disk failed, on
+// which ZFS suspended I/O.
+const suspendedStatus = `  pool: tank
+ state: SUSPENDED
+status: One or more devices are faulted in response to IO failures.
+action: Make sure the affected devices are connected, then run 'zpool clear'.
+   see: https://openzfs.github.io/openzfs-docs/msg/ZFS-8000-HC
+  scan: scrub repaired 0B in 00:18:33 with 0 errors on Sun Jun 13 00:42:34 2021
+config:
+
+	NAME        STATE     READ WRITE CKSUM
+	tank        UNAVAIL      0     0     0  insufficient replicas
+	  sda       UNAVAIL      3    12     0
+
+errors: 1 data errors, use '-v' for a list
+`
+
+func TestSuspendedPool(t *testing.T) {
+	pools := []zpool{{name: "tank"}, {name: "backup"}}
+	output := "tank\t11988103774208\t6118856933376\t5869246840832\t51\t12\tSUSPENDED\toff\t-\t-\n" +
+		"backup\t3985729650688\t3587156685619\t398572965069\t90\t-\tUNAVAIL\toff\t-\t-\n"
+	if err := parseZpoolList(output, pools); err != nil {
+		t.Fatalf("Error in parseZpoolList (%s)", err)
+	}
+	for _, z := range pools {
+		if z.err != nil || z.healthy {
+			t.Errorf("%s should be collected and unhealthy: %+v", z.name, z)
+		}
+	}
+	z := &pools[0]
+	if err := z.setStatus(suspendedStatus, poolOptions{}); err != nil {
+		t.Fatalf("Error in setStatus of a suspended pool (%s)", err)
+	}
+	if z.status != "SUSPENDED" || z.faulted != 1 {
+		t.Errorf("Incorrect status %s or faulted providers %d of the suspended pool", z.status, z.faulted)
+	}
+
+	var h healthTracker
+	now := time.Unix(1700000000, 0)
+	h.observe(pools, now)
+	h.observe(pools, now.Add(10*time.Minute))
+	ch := make(chan prometheus.Metric, 50)
+	h.collect(ch)
+	close(ch)
+	for m := range ch {
+		key := metricLabel(m, "name") + " " + metricLabel(m, "state")
+		want := map[string]float64{"tank SUSPENDED": 600, "backup UNAVAIL": 600}[key]
+		if m.Desc() == zpoolUnhealthyDesc && metricValue(m) != want {
+			t.Errorf("Incorrect unhealthy seconds of %s (%v), should be %v", key, metricValue(m), want)
+		}
+	}
+}
+
 func TestGetCapacity(t *testing.T) {
 	z := zpool{name: "tank"}
 
@@ -178,3 +240,551 @@ errors: No known data errors`)
 		t.Errorf("Incorrect amount of online (%d) providers, should be 4.", z.online)
 	}
 }
+
+func TestParseZpoolList(t *testing.T) {
+	pools := []zpool{{name: "tank"}, {name: "backup"}}
+	output := "tank\t11988103774208\t6118856933376\t5869246840832\t51\t12\tONLINE\toff\t-\t-\n" +
+		"cannot open 'other': no such pool\n" +
+		"backup\t3985729650688\t3587156685619\t398572965069\t90\t-\tDEGRADED\ton\t/mnt\tnone\n"
+
+	err := parseZpoolList(output, pools)
+	if err != nil {
+		t.Fatalf("Error in parseZpoolList (%s)", err)
+	}
+	if pools[0].size != 11988103774208 || pools[0].alloc != 6118856933376 || pools[0].free != 5869246840832 {
+		t.Errorf("Incorrect sizes for tank: %+v", pools[0])
+	}
+	if pools[0].capacity != 51 || pools[0].fragmentation != 12 || !pools[0].healthy {
+		t.Errorf("Incorrect capacity/fragmentation/health for tank: %+v", pools[0])
+	}
+	if pools[1].capacity != 90 || pools[1].fragmentation != -1 || pools[1].healthy {
+		t.Errorf("Incorrect capacity/fragmentation/health for backup: %+v", pools[1])
+	}
+	if pools[0].readonly || !pools[1].readonly {
+		t.Errorf("Only backup should be readonly: %+v", pools)
+	}
+	if pools[0].altroot != "" || pools[0].cachefile != "" || pools[1].altroot != "/mnt" || pools[1].cachefile != "none" {
+		t.Errorf("Incorrect altroot/cachefile: %+v", pools)
+	}
+
+	// Test a monitored pool missing from the output
+	err = parseZpoolList(output, []zpool{{name: "missing"}})
+	if err == nil {
+		t.Errorf("Missing pool should produce error in parseZpoolList")
+	}
+	partial := []zpool{{name: "tank"}, {name: "missing"}}
+	if err := parseZpoolList(output, partial); err != nil || partial[0].err != nil || partial[1].err == nil {
+		t.Errorf("Only the missing pool should fail, got %v, %v and %v", err, partial[0].err, partial[1].err)
+	}
+}
+
+// fixtureRunner answers zpool list and zpool status for a set of generated
+// pools, honouring the pool names passed on the command line.
+type fixtureRunner struct {
+	pools []string
+}
+
+func (f fixtureRunner) run(name string, args ...string) (string, error) {
+	switch {
+	case len(args) > 0 && args[0] == "list":
+		names := f.pools
+		switch {
+		case len(args) > 3:
+			names = args[3:]
+		case len(args) == 2: // zpool list pool of checkExistance
+			names = args[1:]
+		}
+		var b strings.Builder
+		for _, n := range names {
+			fmt.Fprintf(&b, "%s\t11988103774208\t6118856933376\t5869246840832\t51\t12\tONLINE\toff\t-\t-\n", n)
+		}
+		return b.String(), nil
+	case len(args) > 0 && args[0] == "get":
+		var b strings.Builder
+		for _, n := range args[5:] {
+			fmt.Fprintf(&b, "%s\tcomment\t-\n%s\tbootfs\t-\n%s\tversion\t-\n%s\tguid\t1234567890\n", n, n, n, n)
+		}
+		return b.String(), nil
+	case len(args) >= 2 && args[0] == "status":
+		args = args[len(args)-2:]
+		return fmt.Sprintf(`  pool: %s
+ state: ONLINE
+  scan: scrub repaired 0 in 1h1m with 0 errors on Thu Jan 1 13:37:00 1970
+config:
+
+        NAME                       STATE     READ WRITE CKSUM
+        %s                         ONLINE       0     0     0
+          raidz2-0                 ONLINE       0     0     0
+            c0t5000C5006A6E87D9d0  ONLINE       0     0     0
+            c0t5000C50024CAAFFCd0  ONLINE       0     0     0
+
+errors: No known data errors`, args[1], args[1]), nil
+	}
+	return "", fmt.Errorf("unexpected command %s %v", name, args)
+}
+
+func (f fixtureRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	output, err := f.run(name, args...)
+	return io.NopCloser(strings.NewReader(output)), err
+}
+
+// forkingRunner serves fixture output through a real child process so that
+// benchmarks account for the fork/exec cost of every invocation.
+type forkingRunner struct {
+	fixtureRunner
+}
+
+func (f forkingRunner) run(name string, args ...string) (string, error) {
+	output, err := f.fixtureRunner.run(name, args...)
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command("cat")
+	cmd.Stdin = strings.NewReader(output)
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+func (f forkingRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	output, err := f.fixtureRunner.run(name, args...)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("cat")
+	cmd.Stdin = strings.NewReader(output)
+	return startCommand(context.Background(), name, cmd)
+}
+
+func benchmarkPools(b *testing.B, n int) (forkingRunner, []zpool) {
+	if _, err := exec.LookPath("cat"); err != nil {
+		b.Skip("cat not available")
+	}
+	r := forkingRunner{}
+	pools := make([]zpool, n)
+	for i := range pools {
+		pools[i].name = fmt.Sprintf("pool%d", i)
+		r.pools = append(r.pools, pools[i].name)
+	}
+	return r, pools
+}
+
+// BenchmarkListPerPool runs one zpool list per pool, as the exporter used to.
+func BenchmarkListPerPool(b *testing.B) {
+	r, pools := benchmarkPools(b, 12)
+	for i := 0; i < b.N; i++ {
+		for p := range pools {
+			if err := listPools(r, pools[p:p+1]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkListAllPools runs a single zpool list covering every pool.
+func BenchmarkListAllPools(b *testing.B) {
+	r, pools := benchmarkPools(b, 12)
+	for i := 0; i < b.N; i++ {
+		if err := listPools(r, pools); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkParseZpoolList parses the zpool list output of 12 pools.
+func BenchmarkParseZpoolList(b *testing.B) {
+	r, pools := benchmarkPools(b, 12)
+	output, _ := r.fixtureRunner.run("zpool", "list")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := parseZpoolList(output, pools); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// largeStatusOutput is the zpool status -s output of a pool of the given
+// number of raidz2 vdevs of 6 disks each.
+func largeStatusOutput(vdevs int) string {
+	var b strings.Builder
+	b.WriteString("  pool: tank\n state: ONLINE\n  scan: scrub repaired 0B in 05:31:07 with 0 errors on Sun Mar 10 05:55:08 2024\nconfig:\n\n")
+	b.WriteString("\tNAME                        STATE     READ WRITE CKSUM  SLOW\n")
+	b.WriteString("\ttank                        ONLINE       0     0     0     -\n")
+	for v := 0; v < vdevs; v++ {
+		fmt.Fprintf(&b, "\t  raidz2-%d                  ONLINE       0     0     0     -\n", v)
+		for d := 0; d < 6; d++ {
+			fmt.Fprintf(&b, "\t    scsi-35000c500a%07d   ONLINE       0     0     0     %d\n", v*6+d, d)
+		}
+	}
+	b.WriteString("\nerrors: No known data errors\n")
+	return b.String()
+}
+
+// BenchmarkSetStatus parses the status of a pool with 60 disks, including
+// the per-device slow I/O counts.
+func BenchmarkSetStatus(b *testing.B) {
+	output := largeStatusOutput(10)
+	opts := poolOptions{slowIOs: true, activities: true, parsable: true}
+	z := zpool{name: "tank"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := z.setStatus(output, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkGetStatus reads the status of a pool with 1002 disks the way the
+// exporter used to, buffering the whole output before parsing it, and by
+// parsing it as it is read. Compare the B/op.
+func BenchmarkGetStatus(b *testing.B) {
+	output := largeStatusOutput(167)
+	opts := poolOptions{slowIOs: true, activities: true}
+	r := staticRunner{"zpool status -i -t -s tank": output}
+	z := zpool{name: "tank"}
+	b.Run("buffered", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			out, _ := io.ReadAll(strings.NewReader(output))
+			if err := z.setStatus(string(out), opts); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("streamed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := z.getStatus(r, opts); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestParseCreationTimes(t *testing.T) {
+	pools := []zpool{{name: "tank"}, {name: "backup"}}
+	err := parseCreationTimes("tank\t1420070400\nbackup\t1577836800\n", pools)
+	if err != nil {
+		t.Fatalf("Error in parseCreationTimes (%s)", err)
+	}
+	if pools[0].creation != 1420070400 || pools[1].creation != 1577836800 {
+		t.Errorf("Incorrect creation times: %+v", pools)
+	}
+
+	err = parseCreationTimes("tank\tThu Jan  1  0:00 2015\n", pools)
+	if err == nil {
+		t.Errorf("Non-integer should produce error in parseCreationTimes")
+	}
+}
+
+// listRunner answers every command with output and err, like a zpool list
+// that exits with err.
+type listRunner struct {
+	output string
+	err    error
+}
+
+func (r listRunner) run(name string, args ...string) (string, error) { return r.output, r.err }
+
+func (r listRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(r.output)), r.err
+}
+
+func TestPoolNameRE(t *testing.T) {
+	for _, name := range []string{"tank", "my pool", "a:b", "data.2024_x-1", "Tank 2"} {
+		if !poolNameRE.MatchString(name) {
+			t.Errorf("Pool name %q should be valid", name)
+		}
+	}
+	for _, name := range []string{"", "-c", " tank", "1tank", "_tank", "tank/home", "tank@daily", "tank,backup", "tank\tx"} {
+		if poolNameRE.MatchString(name) {
+			t.Errorf("Pool name %q should be invalid", name)
+		}
+	}
+}
+
+func TestCheckExistance(t *testing.T) {
+	if err := checkExistance(listRunner{output: "NAME\tSIZE\ntank\t47962866745344\n"}, "tank"); err != nil {
+		t.Errorf("Listed pool should exist, got %s", err)
+	}
+	if err := checkExistance(listRunner{output: "NAME        SIZE  ALLOC\nmy pool     9.50G   104K\n"}, "my pool"); err != nil {
+		t.Errorf("Listed pool with a space should exist, got %s", err)
+	}
+	for _, r := range []listRunner{
+		{output: "cannot open 'tan': no such pool\n", err: errors.New("exit status 1")},
+		// zpool ran but failed, or listed other pools.
+		{output: "NAME\tSIZE\ntank\t47962866745344\n", err: errors.New("exit status 1")},
+		{output: "invalid option 'c'\nusage:\n\tlist [-gHLpPv] [-o property[,...]] [-T d|u] [pool] ...\n"},
+		{output: "NAME\tSIZE\ntank\t47962866745344\n"},
+		{},
+	} {
+		if err := checkExistance(r, "tan"); err == nil {
+			t.Errorf("checkExistance should produce error for zpool list output %q", r.output)
+		}
+	}
+}
+
+func TestParseProperties(t *testing.T) {
+	pools := []zpool{{name: "tank"}, {name: "rpool", properties: poolProperties{comment: "stale"}}}
+	parseProperties("tank\tcomment\tbackup target\n"+
+		"tank\tbootfs\t-\n"+
+		"tank\tversion\t-\n"+
+		"tank\tguid\t12245729549505307405\n"+
+		"rpool\tcomment\t-\n"+
+		"rpool\tbootfs\trpool/ROOT/ubuntu\n"+
+		"rpool\tversion\t28\n", pools)
+	if want := (poolProperties{comment: "backup target", guid: "12245729549505307405"}); pools[0].properties != want {
+		t.Errorf("Incorrect properties of tank %+v, should be %+v", pools[0].properties, want)
+	}
+	if want := (poolProperties{bootfs: "rpool/ROOT/ubuntu", version: "28"}); pools[1].properties != want {
+		t.Errorf("Incorrect properties of rpool %+v, should be %+v", pools[1].properties, want)
+	}
+}
+
+func TestParseRootSpace(t *testing.T) {
+	pools := []zpool{{name: "tank"}, {name: "backup", rootSpace: true}}
+	parseRootSpace("tank\tused\t17583596175360\n"+
+		"tank\tavailable\t14388860026880\n"+
+		"backup\tused\t3573412790272\n"+
+		"backup\tavailable\t-\n", pools)
+	if !pools[0].rootSpace || pools[0].rootUsed != 17583596175360 || pools[0].rootAvailable != 14388860026880 {
+		t.Errorf("Incorrect root space of tank: %+v", pools[0])
+	}
+	if pools[1].rootSpace {
+		t.Errorf("Pool without available space should not have its root space known")
+	}
+}
+
+func TestParseHumanSize(t *testing.T) {
+	for input, want := range map[string]float64{
+		"0":     0,
+		"0B":    0,
+		"512":   512,
+		"1K":    1024,
+		"3.4K":  3.4 * 1024,
+		"1M":    1024 * 1024,
+		"1G":    1024 * 1024 * 1024,
+		"1T":    1024 * 1024 * 1024 * 1024,
+		"1.05T": 1.05 * 1024 * 1024 * 1024 * 1024,
+		"580M":  580 * 1024 * 1024,
+		"1.09T": 1.09 * 1024 * 1024 * 1024 * 1024,
+		"2.5G":  2.5 * 1024 * 1024 * 1024,
+		"1P":    1024 * 1024 * 1024 * 1024 * 1024,
+	} {
+		got, err := parseHumanSize(input)
+		if err != nil || got != want {
+			t.Errorf("parseHumanSize(%q) = %v, %v; should be %v", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"", "M", "1.2.3G", "-1K", "12Q"} {
+		if _, err := parseHumanSize(input); err == nil {
+			t.Errorf("parseHumanSize(%q) should produce error", input)
+		}
+	}
+}
+
+func TestProbeParsable(t *testing.T) {
+	if !probeParsable(fixtureRunner{}, "tank") {
+		t.Errorf("zpool status -p output should be detected as support")
+	}
+	if probeParsable(staticRunner{"zpool status -p tank": "invalid option 'p'\nusage:\n"}, "tank") {
+		t.Errorf("Usage error should not be detected as support")
+	}
+	opts := poolOptions{parsable: true, slowIOs: true}
+	if args := strings.Join(opts.statusArgs("tank"), " "); args != "status -s -p tank" {
+		t.Errorf("Incorrect zpool status arguments %q", args)
+	}
+}
+
+func TestParseDedup(t *testing.T) {
+	ddt, err := parseDedup(`  pool: tank
+ state: ONLINE
+  scan: none requested
+config:
+
+        NAME        STATE     READ WRITE CKSUM
+        tank        ONLINE       0     0     0
+          sda       ONLINE       0     0     0
+
+errors: No known data errors
+
+ dedup: DDT entries 1234567, size 345 on disk, 210 in core
+
+bucket              allocated                       referenced
+______   ______________________________   ______________________________
+refcnt   blocks   LSIZE   PSIZE   DSIZE   blocks   LSIZE   PSIZE   DSIZE
+------   ------   -----   -----   -----   ------   -----   -----   -----
+     1    1.01M    126G    121G    121G    1.01M    126G    121G    121G
+ Total    1.18M    147G    140G    141G    1.45M    181G    173G    174G
+`)
+	if err != nil {
+		t.Fatalf("Error in parseDedup (%s)", err)
+	}
+	if ddt == nil || ddt.entries != 1234567 || ddt.onDisk != 1234567*345 || ddt.inCore != 1234567*210 {
+		t.Errorf("Incorrect dedup table %+v", ddt)
+	}
+
+	// Test pools without dedup table
+	for _, output := range []string{"errors: No known data errors\n\n dedup: no DDT entries\n", "errors: No known data errors\n"} {
+		ddt, err = parseDedup(output)
+		if err != nil || ddt != nil {
+			t.Errorf("Pool without dedup table should produce no stats, got %+v (%v)", ddt, err)
+		}
+	}
+
+	if _, err = parseDedup("dedup: DDT entries many, size 1 on disk, 1 in core"); err == nil {
+		t.Errorf("Non-integer should produce error in parseDedup")
+	}
+}
+
+const degradedStatus = `  pool: backup
+ state: DEGRADED
+status: One or more devices could not be used because the label is missing or
+        invalid.  Sufficient replicas exist for the pool to continue
+        functioning in a degraded state.
+action: Replace the device using 'zpool replace'.
+  scan: scrub repaired 0B in 01:01:01 with 0 errors on Thu Jan  1 13:37:00 1970
+config:
+
+        NAME        STATE     READ WRITE CKSUM
+        backup      DEGRADED     0     0     0
+          mirror-0  DEGRADED     0     0     0
+            sda     ONLINE       0     0     0
+            sdb     UNAVAIL      0     0     0
+
+errors: No known data errors
+`
+
+func TestParseStatusX(t *testing.T) {
+	names := []string{"tank", "backup"}
+	sick, ok := parseStatusX(strings.NewReader("all pools are healthy\n"), names, poolOptions{})
+	if !ok || len(sick) != 0 {
+		t.Errorf("All pools should be healthy, got %v (%v)", sick, ok)
+	}
+
+	sick, ok = parseStatusX(strings.NewReader("pool 'tank' is healthy\n"+degradedStatus), names, poolOptions{})
+	if !ok || len(sick) != 1 || sick["backup"] == nil || sick["backup"].state != "DEGRADED" {
+		t.Errorf("Only backup should be unhealthy, got %v (%v)", sick, ok)
+	}
+	z := zpool{name: "backup"}
+	if ok && (z.setProviders(sick["backup"]) != nil || z.faulted != 1) {
+		t.Errorf("Incorrect providers parsed from the status of backup: %+v", z)
+	}
+
+	// Test unparseable output
+	if _, ok = parseStatusX(strings.NewReader("pool 'tank' is healthy\n"), names, poolOptions{}); ok {
+		t.Errorf("Output missing a pool should not be parseable")
+	}
+	if _, ok = parseStatusX(strings.NewReader("internal error: out of memory\n"), names, poolOptions{}); ok {
+		t.Errorf("Error output should not be parseable")
+	}
+}
+
+// recordingRunner serves canned output like staticRunner and records the
+// command lines it was asked to run.
+type recordingRunner struct {
+	staticRunner
+	calls []string
+}
+
+func (r *recordingRunner) run(name string, args ...string) (string, error) {
+	r.calls = append(r.calls, strings.Join(append([]string{name}, args...), " "))
+	return r.staticRunner.run(name, args...)
+}
+
+func (r *recordingRunner) start(name string, args ...string) (io.ReadCloser, error) {
+	output, err := r.run(name, args...)
+	return io.NopCloser(strings.NewReader(output)), err
+}
+
+func TestCollectStatusFast(t *testing.T) {
+	tankStatus, _ := fixtureRunner{}.run("zpool", "status", "tank")
+	r := &recordingRunner{staticRunner: staticRunner{
+		"zpool status -x tank backup": "pool 'tank' is healthy\n" + degradedStatus,
+		"zpool status tank":           tankStatus,
+		"zpool status backup":         degradedStatus,
+	}}
+	opts := poolOptions{healthyInterval: time.Hour}
+	pools := []zpool{{name: "tank"}, {name: "backup"}}
+
+	// Test first collection, which has no cached status for tank
+	if ok, err := collectStatusFast(r, pools, opts); !ok || err != nil {
+		t.Fatalf("collectStatusFast should parse the zpool status -x output (%v)", err)
+	}
+	if strings.Join(r.calls, ", ") != "zpool status -x tank backup, zpool status tank" {
+		t.Errorf("Incorrect commands run: %v", r.calls)
+	}
+	if pools[0].status != "ONLINE" || pools[1].status != "DEGRADED" || pools[1].faulted != 1 {
+		t.Errorf("Incorrect status for pools: %+v", pools)
+	}
+
+	// Test cached status of the healthy pool
+	r.calls = nil
+	collectStatusFast(r, pools, opts)
+	if len(r.calls) != 1 {
+		t.Errorf("Only zpool status -x should run while tank is cached, ran %v", r.calls)
+	}
+
+	// Test recovered pool, which must not keep its cached faults
+	r.staticRunner["zpool status -x tank backup"] = "all pools are healthy\n"
+	r.staticRunner["zpool status backup"] = strings.Replace(tankStatus, "tank", "backup", -1)
+	r.calls = nil
+	collectStatusFast(r, pools, opts)
+	if strings.Join(r.calls, ", ") != "zpool status -x tank backup, zpool status backup" || pools[1].faulted != 0 {
+		t.Errorf("Recovered pool should get its full status refreshed, ran %v: %+v", r.calls, pools[1])
+	}
+
+	// Test unparseable output
+	r.staticRunner["zpool status -x tank backup"] = ""
+	if ok, _ := collectStatusFast(r, pools, opts); ok {
+		t.Errorf("collectStatusFast should fail on unparseable output")
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	// A release that translated the config section header.
+	z := zpool{name: "tank"}
+	err := z.getProviders(`  pool: tank
+ state: ONLINE
+config:
+
+	NOM         ÉTAT     READ WRITE CKSUM
+	tank        ONLINE       0     0     0
+	  sda       ONLINE       0     0     0
+
+errors: No known data errors`)
+	var ferr *formatError
+	if !errors.As(err, &ferr) || ferr.command != "zpool status" {
+		t.Fatalf("Config section without devices should be a zpool status format error, got %v", err)
+	}
+	if len(ferr.lines) != excerptLines || ferr.lines[0] != "config:" {
+		t.Errorf("Format error should hold the lines from config:, got %q", ferr.lines)
+	}
+
+	pools := []zpool{{name: "tank"}, {name: "backup"}}
+	err = parseZpoolList("tank\t11988103774208\t6118856933376\t5869246840832\t51,0\t12\tONLINE\toff\t-\t-\n"+
+		"backup\t3985729650688\t3587156685619\t398572965069\t90\t-\tDEGRADED\ton\t/mnt\tnone\n", pools)
+	if err != nil {
+		t.Fatalf("Error in parseZpoolList (%s)", err)
+	}
+	if !errors.As(pools[0].err, &ferr) || ferr.command != "zpool list" || len(ferr.lines) != 1 {
+		t.Errorf("Unparsable capacity should be a zpool list format error, got %v", pools[0].err)
+	}
+	if pools[1].err != nil {
+		t.Errorf("Pool with a parsable capacity should be collected, got %s", pools[1].err)
+	}
+
+	c := &poolCollector{}
+	for i := 0; i < 2; i++ {
+		c.recordErrors(pools, false)
+	}
+	if !c.unrecognized || c.parseErrors["zpool list"] != 2 || c.parseErrors["zpool status"] != 0 {
+		t.Errorf("Incorrect parse errors after two collections: %v", c.parseErrors)
+	}
+	pools[0].err = nil
+	c.recordErrors(pools, false)
+	if c.unrecognized {
+		t.Errorf("Output format should be recognized again once every pool parses")
+	}
+}